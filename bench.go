@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/sjarvie/godfs/client"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchPhases are the operations a benchmark can run, in the order they run
+var benchPhases = []string{"write", "read", "mkdir", "stat", "rm"}
+
+// benchResult describes a phase of a benchmark
+type benchResult struct {
+	Phase     string
+	Ops       int             // operations completed
+	Errors    int             // operations failed
+	Bytes     int64           // file data written or read
+	Elapsed   time.Duration   // time taken by the phase
+	Latencies []time.Duration // of the completed operations, sorted
+}
+
+// percentile returns the latency which fraction p of the operations took at
+// most
+func (r benchResult) percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(r.Latencies))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(r.Latencies) {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+func (r benchResult) String() string {
+	seconds := r.Elapsed.Seconds()
+	if seconds == 0 {
+		seconds = 1e-9
+	}
+	s := fmt.Sprintf("%-6s %6d ops %4d errors %9.1f ops/s", r.Phase, r.Ops, r.Errors, float64(r.Ops)/seconds)
+	if r.Bytes > 0 {
+		s += fmt.Sprintf(" %9.2f MB/s", float64(r.Bytes)/seconds/1e6)
+	}
+	return s + fmt.Sprintf("  latency p50 %v p90 %v p99 %v max %v",
+		r.percentile(0.5), r.percentile(0.9), r.percentile(0.99), r.percentile(1))
+}
+
+// runPhase calls op for each of n items from concurrency goroutines, timing
+// each call. op returns the bytes of file data it moved.
+func runPhase(phase string, n, concurrency int, op func(i int) (int64, error)) benchResult {
+	r := benchResult{Phase: phase, Latencies: make([]time.Duration, 0, n)}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	items := make(chan int)
+
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				t := time.Now()
+				size, err := op(i)
+				latency := time.Since(t)
+
+				lock.Lock()
+				if err != nil {
+					r.Errors++
+				} else {
+					r.Ops++
+					r.Bytes += size
+					r.Latencies = append(r.Latencies, latency)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		items <- i
+	}
+	close(items)
+	wg.Wait()
+	r.Elapsed = time.Since(start)
+	sort.Slice(r.Latencies, func(i, j int) bool { return r.Latencies[i] < r.Latencies[j] })
+	return r
+}
+
+// parsePhases checks a comma separated list of benchmark phases, returning
+// them in the order they run
+func parsePhases(list string) ([]string, error) {
+	wanted := make(map[string]bool)
+	for _, p := range strings.Split(list, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		known := false
+		for _, b := range benchPhases {
+			known = known || p == b
+		}
+		if !known {
+			return nil, errors.New("Unknown benchmark phase " + p + ", expected one of " + strings.Join(benchPhases, ","))
+		}
+		wanted[p] = true
+	}
+	var phases []string
+	for _, b := range benchPhases {
+		if wanted[b] {
+			phases = append(phases, b)
+		}
+	}
+	if len(phases) == 0 {
+		return nil, errors.New("No benchmark phase given")
+	}
+	return phases, nil
+}
+
+// countingWriter counts the bytes written to it and discards them
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// bench writes and reads files files of size bytes in a new directory below
+// dir, then makes, stats and deletes as many directories, with concurrency
+// requests at a time. Only the given phases run, each needing the files or
+// directories of the phases before it. The new directory is deleted
+// afterwards.
+func bench(dir string, files int, size int64, concurrency int, phases []string) ([]benchResult, error) {
+	if files < 1 || concurrency < 1 || size < 0 {
+		return nil, errors.New("Files and concurrency must be at least 1, and size not negative")
+	}
+	dir = strings.TrimSuffix(dir, "/") + "/bench-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	err := client.Mkdir(dir, true)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Delete(dir, true, true)
+
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	file := func(i int) string { return dir + "/file" + strconv.Itoa(i) }
+	subdir := func(i int) string { return dir + "/dir" + strconv.Itoa(i) }
+
+	var results []benchResult
+	for _, phase := range phases {
+		var op func(i int) (int64, error)
+		switch phase {
+		case "write":
+			op = func(i int) (int64, error) {
+				return size, client.DistributeBlocksFromReader(bytes.NewReader(data), size, file(i))
+			}
+		case "read":
+			op = func(i int) (int64, error) {
+				var w countingWriter
+				err := client.RetrieveToWriter(bufio.NewWriter(&w), file(i))
+				if err == nil && w.n != size {
+					err = fmt.Errorf("Read %d bytes of %s, expected %d", w.n, file(i), size)
+				}
+				return w.n, err
+			}
+		case "mkdir":
+			op = func(i int) (int64, error) { return 0, client.Mkdir(subdir(i), false) }
+		case "stat":
+			op = func(i int) (int64, error) {
+				_, err := client.Stat(subdir(i))
+				return 0, err
+			}
+		case "rm":
+			op = func(i int) (int64, error) { return 0, client.Delete(subdir(i), false, true) }
+		}
+		results = append(results, runPhase(phase, files, concurrency, op))
+	}
+	return results, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/sjarvie/godfs/client"
+	"github.com/sjarvie/godfs/minicluster"
+	"testing"
+	"time"
+)
+
+func TestBench(t *testing.T) {
+
+	if _, err := parsePhases("write,bogus"); err == nil {
+		t.Errorf("Unknown phase accepted")
+	}
+	phases, err := parsePhases("rm, WRITE,read")
+	if err != nil || len(phases) != 3 || phases[0] != "write" || phases[2] != "rm" {
+		t.Errorf("Expected the phases in running order, got %v %v", phases, err)
+	}
+
+	r := benchResult{Latencies: []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}
+	if r.percentile(0.5) != 5 || r.percentile(0.9) != 9 || r.percentile(1) != 10 {
+		t.Errorf("Wrong percentiles %v %v %v", r.percentile(0.5), r.percentile(0.9), r.percentile(1))
+	}
+
+	c, err := minicluster.Start(2, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer c.Close()
+	if err := c.Connect(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	results, err := bench("/bench", 4, 10000, 2, benchPhases)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(results) != len(benchPhases) {
+		t.Fatalf("Expected a result for each phase, got %v", results)
+	}
+	for _, r := range results {
+		if r.Ops != 4 || r.Errors != 0 || len(r.Latencies) != 4 {
+			t.Errorf("Expected 4 operations, got %s", r)
+		}
+	}
+	if results[1].Bytes != 40000 {
+		t.Errorf("Expected 40000 bytes read, got %d", results[1].Bytes)
+	}
+	if list, err := client.ListDir("/bench", false); err != nil || len(list) != 0 {
+		t.Errorf("Benchmark directory left behind %v %v", list, err)
+	}
+}
@@ -0,0 +1,1135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/sjarvie/godfs/client"
+	"github.com/sjarvie/godfs/fusefs"
+	"github.com/sjarvie/godfs/namenode"
+	"github.com/sjarvie/godfs/s3gateway"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// block size used when the namenode is given by GODFS_NAMENODE and does not
+// send its own
+const defaultBlockSize = 4096
+
+// command is a subcommand of the godfs tool which talks to a running namenode
+type command struct {
+	usage string // arguments, shown in the usage message
+	short string // one line description
+	nargs int    // number of positional arguments, or -1 if checked by run
+	local bool   // runs without connecting to the namenode
+	flags func(fs *flag.FlagSet)
+	run   func(fs *flag.FlagSet) error
+}
+
+var recursive bool                   // -R / -r
+var parents bool                     // -p
+var skipTrash bool                   // -skipTrash
+var listen string                    // -listen
+var fileQuota int                    // -files
+var spaceQuota int64                 // -space
+var bandwidth int64                  // -bandwidth
+var policy string                    // -ec
+var blockSize int                    // -blocksize
+var replicas int                     // -replication
+var exclusive bool                   // -n
+var resume bool                      // -resume
+var quiet bool                       // -q of put and get
+var auditUser string                 // -user
+var auditPath string                 // -path
+var auditCmd string                  // -cmd
+var since string                     // -since
+var benchFiles int                   // -files of bench
+var benchSize int64                  // -size
+var benchThreads int                 // -concurrency
+var benchOps string                  // -ops
+var benchDir string                  // -dir
+var follow bool                      // -f
+var tailBytes int64                  // -c
+var modTime string                   // -m of settimes
+var accessTime string                // -a of settimes
+var xattrName string                 // -n
+var xattrValue string                // -v
+var xattrRemove string               // -x
+var removeSource bool                // -rm of archive
+var storagePolicy string             // -set of storagepolicy
+var unsetPolicy bool                 // -unset
+var namenodeAddress string           // -namenode of dfsadmin
+var reportJSON bool                  // -json of dfsadmin report
+var rangeOffset int64                // -offset of locations
+var rangeLength int64                // -length
+var checkpointInterval time.Duration // -interval of checkpoint
+var mirrorInterval time.Duration     // -interval of mirror
+var conflictPolicy string            // -conflict
+var mirrorState string               // -state
+var configpath string
+
+var commands = map[string]*command{
+	"put": {
+		usage: "[-config file] [-ec policy] [-blocksize bytes] [-replication n] [-n] [-resume] [-q] <local path> <remote path>",
+		short: "Insert a local file into the filesystem",
+		nargs: 2,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&policy, "ec", "", "erasure coding policy such as RS-6-3, rather than replicas")
+			fs.IntVar(&blockSize, "blocksize", 0, "size of the file's Blocks, 0 for the default")
+			fs.IntVar(&replicas, "replication", 0, "replicas kept of each Block, 0 for the namenode's replication")
+			fs.BoolVar(&exclusive, "n", false, "fail if the remote file exists, rather than replacing it")
+			fs.BoolVar(&resume, "resume", false, "upload resumably, or upload only the Blocks missing after an interrupted upload")
+			fs.BoolVar(&quiet, "q", false, "print no progress bar")
+		},
+		run: func(fs *flag.FlagSet) error {
+			showProgress()
+			if policy != "" {
+				if blockSize != 0 || replicas != 0 || exclusive || resume {
+					return errors.New("-ec cannot be combined with -blocksize, -replication, -n or -resume")
+				}
+				return client.DistributeErasureCodedFromFile(fs.Arg(0), fs.Arg(1), policy)
+			}
+			if resume {
+				if exclusive {
+					return errors.New("-resume cannot be combined with -n")
+				}
+				return client.Resume(fs.Arg(0), fs.Arg(1), blockSize, replicas)
+			}
+			if exclusive {
+				return client.DistributeWithFlags(fs.Arg(0), fs.Arg(1), blockSize, replicas, client.CREATE|client.EXCLUSIVE)
+			}
+			return client.DistributeWithLayout(fs.Arg(0), fs.Arg(1), blockSize, replicas)
+		},
+	},
+	"get": {
+		usage: "[-config file] [-q] <remote path> <local path>",
+		short: "Retrieve a file from the filesystem",
+		nargs: 2,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&quiet, "q", false, "print no progress bar")
+		},
+		run: func(fs *flag.FlagSet) error {
+			showProgress()
+			return client.RetrieveFile(fs.Arg(1), fs.Arg(0))
+		},
+	},
+	"ls": {
+		usage: "[-config file] [-R] [remote path]",
+		short: "List the contents of a directory",
+		nargs: -1,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&recursive, "R", false, "list subdirectories recursively")
+		},
+		run: func(fs *flag.FlagSet) error {
+			path := "/"
+			if fs.NArg() > 1 {
+				return errors.New("Too many arguments")
+			}
+			if fs.NArg() == 1 {
+				path = fs.Arg(0)
+			}
+			paths, err := expand(path)
+			if err != nil {
+				return err
+			}
+			for _, p := range paths {
+				list, err := client.ListDir(p, recursive)
+				if err != nil {
+					return err
+				}
+				for _, st := range list {
+					printStatus(st)
+				}
+			}
+			return nil
+		},
+	},
+	"rm": {
+		usage: "[-config file] [-r] [-skipTrash] <remote path>",
+		short: "Delete a file or directory",
+		nargs: 1,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&recursive, "r", false, "delete directories and their contents")
+			fs.BoolVar(&skipTrash, "skipTrash", false, "delete immediately rather than moving to the trash")
+		},
+		run: func(fs *flag.FlagSet) error {
+			paths, err := expand(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			// the paths a pattern matches are deleted in batches
+			for i, err := range client.DeleteAll(paths, recursive, skipTrash) {
+				if err != nil {
+					return fmt.Errorf("%s: %v", paths[i], err)
+				}
+			}
+			return nil
+		},
+	},
+	"setrep": {
+		usage: "[-config file] <replication> <remote path>",
+		short: "Change the replication factor of a file, or of the files below a directory",
+		nargs: 2,
+		run: func(fs *flag.FlagSet) error {
+			n, err := strconv.Atoi(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			message, err := client.SetReplication(fs.Arg(1), n)
+			if err != nil {
+				return err
+			}
+			fmt.Println(message)
+			return nil
+		},
+	},
+	"tail": {
+		usage: "[-config file] [-f] [-c bytes] <remote path>",
+		short: "Show the end of a file, and follow it as it grows with -f",
+		nargs: 1,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&follow, "f", false, "keep showing data appended to the file until interrupted")
+			fs.Int64Var(&tailBytes, "c", 1024, "bytes shown from the end of the file")
+		},
+		run: func(fs *flag.FlagSet) error {
+			size, err := client.Tail(fs.Arg(0), tailBytes, os.Stdout)
+			if err != nil || !follow {
+				return err
+			}
+			return client.Follow(fs.Arg(0), size, time.Second, os.Stdout, nil)
+		},
+	},
+	"getmerge": {
+		usage: "[-config file] <remote directory> <local path>",
+		short: "Retrieve the files of a directory, in order, into one local file",
+		nargs: 2,
+		run: func(fs *flag.FlagSet) error {
+			return client.GetMerge(fs.Arg(0), fs.Arg(1))
+		},
+	},
+	"backup": {
+		usage: "[-config file] [-since snapshot] <remote directory> <file:///path | s3://bucket/key | gs://bucket/key>",
+		short: "Write a backup of a directory, read from a new snapshot, to a local tar file or an object store",
+		nargs: 2,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&since, "since", "", "snapshot of an earlier backup, so only the changes since are backed up")
+		},
+		run: func(fs *flag.FlagSet) error {
+			name, err := client.Backup(fs.Arg(0), fs.Arg(1), since, func(p client.CopyProgress) {
+				fmt.Printf("Backed up %d of %d files, %d of %d bytes: %s\n", p.Files, p.TotalFiles, p.Bytes, p.TotalBytes, p.Path)
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Println("Backed up snapshot", name)
+			return nil
+		},
+	},
+	"restore": {
+		usage: "[-config file] <file:///path | s3://bucket/key | gs://bucket/key> <remote directory>",
+		short: "Rebuild a directory from a backup, restoring a full backup and then each incremental one",
+		nargs: 2,
+		run: func(fs *flag.FlagSet) error {
+			err := client.Restore(fs.Arg(0), fs.Arg(1), func(p client.CopyProgress) {
+				fmt.Printf("Restored %d of %d files, %d of %d bytes: %s\n", p.Files, p.TotalFiles, p.Bytes, p.TotalBytes, p.Path)
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Println("Done!")
+			return nil
+		},
+	},
+	"distcp": {
+		usage: "[-config file] <source> <destination>",
+		short: "Copy a directory tree in parallel, between /path, file:///path and webhdfs://host:port/path",
+		nargs: 2,
+		run: func(fs *flag.FlagSet) error {
+			err := client.Copy(fs.Arg(0), fs.Arg(1), func(p client.CopyProgress) {
+				fmt.Printf("Copied %d of %d files, %d of %d bytes: %s\n", p.Files, p.TotalFiles, p.Bytes, p.TotalBytes, p.Path)
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Println("Done!")
+			return nil
+		},
+	},
+	"archive": {
+		usage: "[-config file] [-rm] <remote directory> <archive path>",
+		short: "Pack the small files below a directory into a read-only archive",
+		nargs: 2,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&removeSource, "rm", false, "delete the directory once it is packed")
+		},
+		run: func(fs *flag.FlagSet) error {
+			err := client.CreateArchive(fs.Arg(0), fs.Arg(1))
+			if err != nil {
+				return err
+			}
+			if removeSource {
+				return client.Delete(fs.Arg(0), true, false)
+			}
+			return nil
+		},
+	},
+	"unarchive": {
+		usage: "[-config file] <archive path> <remote directory>",
+		short: "Copy the files packed into an archive out to a directory",
+		nargs: 2,
+		run: func(fs *flag.FlagSet) error {
+			return client.ExtractArchive(fs.Arg(0), fs.Arg(1))
+		},
+	},
+	"cache": {
+		usage: "[-config file] <remote path>",
+		short: "Keep the Blocks of the files below a path in datanode memory",
+		nargs: 1,
+		run: func(fs *flag.FlagSet) error {
+			return client.Cache(fs.Arg(0))
+		},
+	},
+	"uncache": {
+		usage: "[-config file] <remote path>",
+		short: "Stop keeping the Blocks of a cached path in memory",
+		nargs: 1,
+		run: func(fs *flag.FlagSet) error {
+			return client.Uncache(fs.Arg(0))
+		},
+	},
+	"lscache": {
+		usage: "[-config file]",
+		short: "List the paths whose Blocks are kept in memory",
+		run: func(fs *flag.FlagSet) error {
+			list, err := client.ListCache()
+			if err != nil {
+				return err
+			}
+			for _, st := range list {
+				fmt.Printf("%s %d bytes, %d blocks\n", st.Path, st.Size, st.NumBlocks)
+			}
+			return nil
+		},
+	},
+	"storagepolicy": {
+		usage: "[-config file] [-set policy] [-unset] <remote path>",
+		short: "Show or set the storage policy of a file or directory: HOT, WARM or COLD",
+		nargs: 1,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&storagePolicy, "set", "", "policy set on the path and inherited below it")
+			fs.BoolVar(&unsetPolicy, "unset", false, "clear the policy set on the path")
+		},
+		run: func(fs *flag.FlagSet) error {
+			if storagePolicy != "" || unsetPolicy {
+				return client.SetStoragePolicy(fs.Arg(0), storagePolicy)
+			}
+			st, err := client.Stat(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			if st.Storage == "" {
+				fmt.Println("No storage policy")
+			} else {
+				fmt.Println(st.Storage)
+			}
+			return nil
+		},
+	},
+	"locations": {
+		usage: "[-config file] [-offset bytes] [-length bytes] <remote path>",
+		short: "List the Blocks of a file and the datanodes holding their replicas, with its storage policy and replication",
+		nargs: 1,
+		flags: func(fs *flag.FlagSet) {
+			fs.Int64Var(&rangeOffset, "offset", 0, "first byte of the range of the file")
+			fs.Int64Var(&rangeLength, "length", 0, "bytes in the range, 0 for the rest of the file")
+		},
+		run: func(fs *flag.FlagSet) error {
+			st, locations, err := client.GetBlockLocations(fs.Arg(0), rangeOffset, rangeLength)
+			if err != nil {
+				return err
+			}
+			storage := st.Storage
+			if storage == "" {
+				storage = "none"
+			}
+			fmt.Printf("%s: %d bytes, %d Blocks, %d replicas wanted, %d at least, storage policy %s\n", st.Path, st.Size, st.NumBlocks, st.Replicas, st.Replication, storage)
+			for _, loc := range locations {
+				replicas := make([]string, 0, len(loc.Replicas))
+				for _, r := range loc.Replicas {
+					replica := r.DatanodeID + " (" + r.State
+					if r.Address != "" {
+						replica += " " + r.Address
+					}
+					if r.Rack != "" {
+						replica += " " + r.Rack
+					}
+					if r.Cached {
+						replica += " cached"
+					}
+					replicas = append(replicas, replica+")")
+				}
+				fmt.Printf("Block %d at %d, %d bytes: %s\n", loc.BlockNum, loc.Offset, loc.Length, strings.Join(replicas, ", "))
+			}
+			return nil
+		},
+	},
+	"checksum": {
+		usage: "[-config file] <remote path> [local path]",
+		short: "Show the checksum of a file computed by the datanodes, and verify a local copy against it",
+		nargs: -1,
+		run: func(fs *flag.FlagSet) error {
+			if fs.NArg() < 1 || fs.NArg() > 2 {
+				return errors.New("Expected a remote path and an optional local path")
+			}
+			sum, err := client.Checksum(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s %s %d bytes in %d Blocks %s\n", sum.Algorithm, sum.Sum, sum.Size, sum.NumBlocks, fs.Arg(0))
+			if fs.NArg() == 1 {
+				return nil
+			}
+			if sum.BlockSize == 0 {
+				return errors.New("The Blocks of " + fs.Arg(0) + " differ in size, so a local copy cannot be cut the same way")
+			}
+			local, err := client.LocalChecksum(fs.Arg(1), sum.BlockSize)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s %s %d bytes in %d Blocks %s\n", local.Algorithm, local.Sum, local.Size, local.NumBlocks, fs.Arg(1))
+			if local.Sum != sum.Sum {
+				return errors.New(fs.Arg(1) + " differs from " + fs.Arg(0))
+			}
+			fmt.Println("Checksums match")
+			return nil
+		},
+	},
+	"mv": {
+		usage: "[-config file] <remote path> <remote path>",
+		short: "Move a file or directory, such as out of the trash",
+		nargs: 2,
+		run: func(fs *flag.FlagSet) error {
+			return client.Rename(fs.Arg(0), fs.Arg(1))
+		},
+	},
+	"truncate": {
+		usage: "[-config file] <length> <remote path>",
+		short: "Shorten a file to a length in bytes",
+		nargs: 2,
+		run: func(fs *flag.FlagSet) error {
+			n, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+			if err != nil {
+				return err
+			}
+			return client.Truncate(fs.Arg(1), n)
+		},
+	},
+	"settimes": {
+		usage: "[-config file] [-m time] [-a time] <remote path>",
+		short: "Set the modification and access times of a file or directory",
+		nargs: 1,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&modTime, "m", "", "modification time, as 2006-01-02T15:04:05Z07:00")
+			fs.StringVar(&accessTime, "a", "", "access time, as 2006-01-02T15:04:05Z07:00")
+		},
+		run: func(fs *flag.FlagSet) error {
+			var mtime, atime time.Time
+			var err error
+			if modTime != "" {
+				mtime, err = time.Parse(time.RFC3339, modTime)
+				if err != nil {
+					return err
+				}
+			}
+			if accessTime != "" {
+				atime, err = time.Parse(time.RFC3339, accessTime)
+				if err != nil {
+					return err
+				}
+			}
+			if mtime.IsZero() && atime.IsZero() {
+				return errors.New("Expected -m or -a")
+			}
+			return client.SetTimes(fs.Arg(0), mtime, atime)
+		},
+	},
+	"setfattr": {
+		usage: "[-config file] -n name [-v value] | -x name <remote path>",
+		short: "Set or remove an extended attribute of a file or directory",
+		nargs: 1,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&xattrName, "n", "", "name of the attribute to set, such as user.schema")
+			fs.StringVar(&xattrValue, "v", "", "value of the attribute")
+			fs.StringVar(&xattrRemove, "x", "", "name of the attribute to remove")
+		},
+		run: func(fs *flag.FlagSet) error {
+			switch {
+			case xattrName != "" && xattrRemove == "":
+				return client.SetXAttr(fs.Arg(0), xattrName, []byte(xattrValue))
+			case xattrRemove != "" && xattrName == "":
+				return client.RemoveXAttr(fs.Arg(0), xattrRemove)
+			}
+			return errors.New("Expected one of -n or -x")
+		},
+	},
+	"getfattr": {
+		usage: "[-config file] [-n name] <remote path>",
+		short: "Show the extended attributes of a file or directory",
+		nargs: 1,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&xattrName, "n", "", "name of the attribute to show, rather than all of them")
+		},
+		run: func(fs *flag.FlagSet) error {
+			names := []string{xattrName}
+			if xattrName == "" {
+				var err error
+				names, err = client.ListXAttrs(fs.Arg(0))
+				if err != nil {
+					return err
+				}
+			}
+			for _, name := range names {
+				value, err := client.GetXAttr(fs.Arg(0), name)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%s=%q\n", name, value)
+			}
+			return nil
+		},
+	},
+	"ln": {
+		usage: "[-config file] [-p] <target path> <link path>",
+		short: "Create a symbolic link to a remote path",
+		nargs: 2,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&parents, "p", false, "create missing parent directories of the link")
+		},
+		run: func(fs *flag.FlagSet) error {
+			return client.CreateSymlink(fs.Arg(0), fs.Arg(1), parents)
+		},
+	},
+	"readlink": {
+		usage: "[-config file] <remote path>",
+		short: "Show the target of a symbolic link",
+		nargs: 1,
+		run: func(fs *flag.FlagSet) error {
+			target, err := client.Readlink(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			fmt.Println(target)
+			return nil
+		},
+	},
+	"watch": {
+		usage: "[-config file] [remote path]",
+		short: "Show the changes to the namespace below a path as they happen",
+		nargs: -1,
+		run: func(fs *flag.FlagSet) error {
+			path := "/"
+			if fs.NArg() > 1 {
+				return errors.New("Too many arguments")
+			}
+			if fs.NArg() == 1 {
+				path = fs.Arg(0)
+			}
+			w, err := client.Watch(path)
+			if err != nil {
+				return err
+			}
+			for e := range w.Events {
+				switch {
+				case e.Kind == client.EventRename:
+					fmt.Println(e.Kind, e.Path, "->", e.Dst)
+				case e.Kind == client.EventClose:
+					fmt.Println(e.Kind, e.Path, e.Status.Size, "bytes")
+				default:
+					fmt.Println(e.Kind, e.Path)
+				}
+			}
+			return w.Err()
+		},
+	},
+	"mkdir": {
+		usage: "[-config file] [-p] <remote path>",
+		short: "Create a directory",
+		nargs: 1,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&parents, "p", false, "create missing parent directories")
+		},
+		run: func(fs *flag.FlagSet) error {
+			return client.Mkdir(fs.Arg(0), parents)
+		},
+	},
+	"gateway": {
+		usage: "[-config file] [-listen host:port]",
+		short: "Serve the filesystem over an S3 compatible API",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&listen, "listen", ":9000", "address to serve on")
+		},
+		run: func(fs *flag.FlagSet) error {
+			fmt.Println("Serving S3 gateway on ", listen)
+			return s3gateway.ListenAndServe(listen, s3gateway.ClientBackend())
+		},
+	},
+	"checkpoint": {
+		usage: "[-interval duration] <namenode HTTP host:port>",
+		short: "Merge the namenode's edit log into its metadata file, once or every interval",
+		nargs: 1,
+		local: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.DurationVar(&checkpointInterval, "interval", 0, "checkpoint repeatedly with this time between checkpoints, such as 1h")
+		},
+		run: func(fs *flag.FlagSet) error {
+			if checkpointInterval > 0 {
+				namenode.RunCheckpointer(fs.Arg(0), checkpointInterval, nil)
+				return nil
+			}
+			n, err := namenode.Checkpoint(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			fmt.Println("Checkpointed", n, "edits")
+			return nil
+		},
+	},
+	"mirror": {
+		usage: "[-config file] [-interval duration] [-conflict policy] [-state file] [-listen host:port] <source namenode HTTP host:port> <source directory> <remote directory>",
+		short: "Copy the changes made below a directory of another cluster to this one, for disaster recovery",
+		nargs: 3,
+		flags: func(fs *flag.FlagSet) {
+			fs.DurationVar(&mirrorInterval, "interval", 5*time.Second, "time between reads of the source's edit log")
+			fs.StringVar(&conflictPolicy, "conflict", client.ConflictSource, "version kept of files changed on both clusters: source, destination or both")
+			fs.StringVar(&mirrorState, "state", "", "local file keeping the last edit applied, so a restarted mirror carries on")
+			fs.StringVar(&listen, "listen", "", "address to serve the mirror's lag metrics on at /metrics")
+		},
+		run: func(fs *flag.FlagSet) error {
+			switch conflictPolicy {
+			case client.ConflictSource, client.ConflictDestination, client.ConflictBoth:
+			default:
+				return errors.New("Conflict policy must be source, destination or both")
+			}
+			m, err := client.NewMirror(fs.Arg(0), fs.Arg(1), fs.Arg(2), mirrorState)
+			if err != nil {
+				return err
+			}
+			m.Interval, m.Conflict = mirrorInterval, conflictPolicy
+			if listen != "" {
+				mux := http.NewServeMux()
+				mux.HandleFunc("/metrics", m.ServeMetrics)
+				go http.ListenAndServe(listen, mux)
+			}
+			m.Run(nil)
+			return nil
+		},
+	},
+	"mount": {
+		usage: "[-config file] <mountpoint>",
+		short: "Mount the filesystem with FUSE until unmounted",
+		nargs: 1,
+		run: func(fs *flag.FlagSet) error {
+			return fusefs.Mount(fs.Arg(0))
+		},
+	},
+	"setquota": {
+		usage: "[-config file] [-files n] [-space bytes] <remote path>",
+		short: "Set the quotas of a directory, 0 removes a quota",
+		nargs: 1,
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&fileQuota, "files", 0, "maximum number of files")
+			fs.Int64Var(&spaceQuota, "space", 0, "maximum bytes of file data")
+		},
+		run: func(fs *flag.FlagSet) error {
+			return client.SetQuota(fs.Arg(0), fileQuota, spaceQuota)
+		},
+	},
+	"getquota": {
+		usage: "[-config file] <remote path>",
+		short: "Show the quotas and usage of a directory",
+		nargs: 1,
+		run: func(fs *flag.FlagSet) error {
+			st, err := client.GetQuota(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			printQuota(st)
+			return nil
+		},
+	},
+	"createsnapshot": {
+		usage: "[-config file] <remote directory> <name>",
+		short: "Snapshot a directory, readable at <directory>/.snapshot/<name>",
+		nargs: 2,
+		run: func(fs *flag.FlagSet) error {
+			return client.CreateSnapshot(fs.Arg(0), fs.Arg(1))
+		},
+	},
+	"deletesnapshot": {
+		usage: "[-config file] <remote directory> <name>",
+		short: "Delete a snapshot of a directory",
+		nargs: 2,
+		run: func(fs *flag.FlagSet) error {
+			return client.DeleteSnapshot(fs.Arg(0), fs.Arg(1))
+		},
+	},
+	"lssnapshot": {
+		usage: "[-config file] <remote directory>",
+		short: "List the snapshots of a directory",
+		nargs: 1,
+		run: func(fs *flag.FlagSet) error {
+			list, err := client.ListSnapshots(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			for _, st := range list {
+				printStatus(st)
+			}
+			return nil
+		},
+	},
+	"snapshotdiff": {
+		usage: "[-config file] <remote directory> <from snapshot> [to snapshot]",
+		short: "List the paths changed between two snapshots of a directory, or since a snapshot if only one is given",
+		nargs: -1,
+		run: func(fs *flag.FlagSet) error {
+			if fs.NArg() < 2 || fs.NArg() > 3 {
+				return errors.New("Expected a directory and one or two snapshots")
+			}
+			list, err := client.SnapshotDiff(fs.Arg(0), fs.Arg(1), fs.Arg(2))
+			if err != nil {
+				return err
+			}
+			for _, st := range list {
+				if st.Source != "" {
+					fmt.Println(st.Change, st.Source, "->", st.Path)
+				} else {
+					fmt.Println(st.Change, st.Path)
+				}
+			}
+			return nil
+		},
+	},
+	"createkey": {
+		usage: "[-config file] <key name>",
+		short: "Create a zone key in the client's key provider",
+		nargs: 1,
+		run: func(fs *flag.FlagSet) error {
+			return client.CreateKey(fs.Arg(0))
+		},
+	},
+	"createzone": {
+		usage: "[-config file] <remote directory> <key name>",
+		short: "Make an empty directory an encryption zone using a zone key",
+		nargs: 2,
+		run: func(fs *flag.FlagSet) error {
+			return client.CreateEncryptionZone(fs.Arg(0), fs.Arg(1))
+		},
+	},
+	"lszones": {
+		usage: "[-config file]",
+		short: "List the encryption zones and their keys",
+		run: func(fs *flag.FlagSet) error {
+			list, err := client.ListEncryptionZones()
+			if err != nil {
+				return err
+			}
+			for _, st := range list {
+				fmt.Printf("%s %s\n", st.Path, st.Zone)
+			}
+			return nil
+		},
+	},
+	"decommission": {
+		usage: "[-config file] <datanode id>",
+		short: "Drain a datanode and remove it from the cluster, repeat to show progress",
+		nargs: 1,
+		run: func(fs *flag.FlagSet) error {
+			message, err := client.Decommission(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			fmt.Println(message)
+			return nil
+		},
+	},
+	"balance": {
+		usage: "[-config file] [-bandwidth bytes]",
+		short: "Move Blocks until datanodes store similar amounts, repeat to show progress",
+		flags: func(fs *flag.FlagSet) {
+			fs.Int64Var(&bandwidth, "bandwidth", 0, "bytes per second to move, 0 for the namenode's balancebandwidth")
+		},
+		run: func(fs *flag.FlagSet) error {
+			message, err := client.Balance(bandwidth)
+			if err != nil {
+				return err
+			}
+			fmt.Println(message)
+			return nil
+		},
+	},
+	"dfsadmin": {
+		usage: "[-config file] [-namenode host:port] [-json] <report | safemode enter|leave|get | refreshNodes | setBlockSize bytes | listOpenLeases | recoverLease path | triggerBlockReport [datanode id] | shutdownDatanode id | setTransferBandwidth bytes | haState [active|standby] | tenants [refresh]>",
+		short: "Make an administrative request to the namenode",
+		nargs: -1,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&namenodeAddress, "namenode", "", "the namenode to ask, rather than the configured one, such as a standby to make active")
+			fs.BoolVar(&reportJSON, "json", false, "print the report of the datanodes and the cluster's totals as JSON")
+		},
+		run: func(fs *flag.FlagSet) error {
+			message, err := dfsadmin(fs.Args())
+			if err != nil {
+				return err
+			}
+			fmt.Print(strings.TrimSuffix(message, "\n") + "\n")
+			return nil
+		},
+	},
+	"audit": {
+		usage: "[-user name] [-path remote path] [-cmd command] [-since duration or time] <audit log>",
+		short: "Search the namenode's audit log for client requests, such as who deleted a file",
+		nargs: 1,
+		local: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&auditUser, "user", "", "only requests made as this user")
+			fs.StringVar(&auditPath, "path", "", "only requests on this path or below it, including moves to it")
+			fs.StringVar(&auditCmd, "cmd", "", "only this command, such as DELETE")
+			fs.StringVar(&since, "since", "", "only requests since a time, as RFC 3339 or a duration such as 24h")
+		},
+		run: func(fs *flag.FlagSet) error {
+			var from time.Time
+			if since != "" {
+				d, err := time.ParseDuration(since)
+				if err == nil {
+					from = time.Now().Add(-d)
+				} else if from, err = time.Parse(time.RFC3339, since); err != nil {
+					return errors.New("Invalid -since " + since)
+				}
+			}
+			under := func(p string) bool {
+				return p == auditPath || strings.HasPrefix(p, strings.TrimSuffix(auditPath, "/")+"/")
+			}
+			return namenode.ReadAudit(fs.Arg(0), func(rec namenode.AuditRecord) bool {
+				if (auditUser != "" && rec.User != auditUser) || (auditCmd != "" && rec.Command != strings.ToUpper(auditCmd)) ||
+					(auditPath != "" && !under(rec.Path) && !under(rec.Dst)) || rec.Time.Before(from) {
+					return true
+				}
+				fmt.Printf("%s %s@%s %s %s", rec.Time.Format(time.RFC3339), rec.User, rec.Host, rec.Command, rec.Path)
+				if rec.Dst != "" {
+					fmt.Printf(" -> %s", rec.Dst)
+				}
+				if rec.Arg != "" {
+					fmt.Printf(" [%s]", rec.Arg)
+				}
+				fmt.Printf(" %s\n", rec.Result)
+				return true
+			})
+		},
+	},
+	"bench": {
+		usage: "[-config file] [-files n] [-size bytes] [-concurrency n] [-ops write,read,mkdir,stat,rm] [-dir remote path]",
+		short: "Measure the throughput and latency of writes, reads and namenode requests",
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&benchFiles, "files", 100, "number of files written and read, and of directories made")
+			fs.Int64Var(&benchSize, "size", 1<<20, "bytes in each file")
+			fs.IntVar(&benchThreads, "concurrency", 4, "requests made at a time")
+			fs.StringVar(&benchOps, "ops", strings.Join(benchPhases, ","), "phases to run, in this order")
+			fs.StringVar(&benchDir, "dir", "/benchmarks", "directory below which the benchmark writes, and deletes what it wrote")
+		},
+		run: func(fs *flag.FlagSet) error {
+			phases, err := parsePhases(benchOps)
+			if err != nil {
+				return err
+			}
+			results, err := bench(benchDir, benchFiles, benchSize, benchThreads, phases)
+			if err != nil {
+				return err
+			}
+			fmt.Println()
+			for _, r := range results {
+				fmt.Println(r)
+			}
+			return nil
+		},
+	},
+	"stat": {
+		usage: "[-config file] <remote path>",
+		short: "Describe a file or directory",
+		nargs: 1,
+		run: func(fs *flag.FlagSet) error {
+			st, err := client.Stat(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			fmt.Println("Path:       ", st.Path)
+			fmt.Println("Modified:   ", statusTime(st.ModTime))
+			if st.IsDir {
+				fmt.Println("Type:        directory")
+				fmt.Println("Entries:    ", st.Children)
+				if st.Zone != "" {
+					fmt.Println("Zone key:   ", st.Zone)
+				}
+				printQuota(st)
+				return nil
+			}
+			fmt.Println("Type:        file")
+			fmt.Println("Accessed:   ", statusTime(st.AccessTime))
+			fmt.Println("Size:       ", st.Size)
+			fmt.Println("Blocks:     ", st.NumBlocks)
+			if st.Replicas > 0 {
+				fmt.Println("Replication:", st.Replication, "of", st.Replicas)
+			} else {
+				fmt.Println("Replication:", st.Replication)
+			}
+			if st.BlockSize > 0 {
+				fmt.Println("Block size: ", st.BlockSize)
+			}
+			if st.Erasure != "" {
+				fmt.Println("Erasure:    ", st.Erasure)
+			}
+			if st.Zone != "" {
+				fmt.Println("Zone key:   ", st.Zone)
+			}
+			return nil
+		},
+	},
+}
+
+// expand returns the paths matching a pattern with wildcards, or the path
+// itself if it has none
+func expand(pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		return []string{pattern}, nil
+	}
+	list, err := client.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, errors.New("No such file or directory " + pattern)
+	}
+	paths := make([]string, len(list))
+	for i, st := range list {
+		paths[i] = st.Path
+	}
+	return paths, nil
+}
+
+// printQuota prints the quotas and usage of a directory
+func printQuota(st client.FileStatus) {
+	quota := func(n int64) string {
+		if n == 0 {
+			return "none"
+		}
+		return fmt.Sprint(n)
+	}
+	fmt.Println("Files:      ", st.FileCount, "of", quota(int64(st.FileQuota)))
+	fmt.Println("Space:      ", st.SpaceUsed, "of", quota(st.SpaceQuota))
+}
+
+// printStatus prints a single line of ls output
+func printStatus(st client.FileStatus) {
+	modified := statusTime(st.ModTime).Format("2006-01-02 15:04")
+	if st.IsDir {
+		fmt.Printf("d %12d %s %s\n", 0, modified, st.Path)
+		return
+	}
+	if st.Symlink != "" {
+		fmt.Printf("l %12d %s %s -> %s\n", 0, modified, st.Path, st.Symlink)
+		return
+	}
+	fmt.Printf("- %12d %s %s\n", st.Size, modified, st.Path)
+}
+
+// showProgress draws a progress bar of each transfer on stderr, unless -q
+// was given or stderr is not a terminal
+func showProgress() {
+	fi, err := os.Stderr.Stat()
+	if quiet || err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return
+	}
+	client.SetProgress(printProgress)
+}
+
+// printProgress redraws the progress bar of a transfer in place, ending the
+// line once it is done
+func printProgress(p client.Progress) {
+	const width = 30
+	fraction := 0.0
+	if p.TotalBytes > 0 {
+		fraction = float64(p.Bytes) / float64(p.TotalBytes)
+	} else if p.TotalBlocks > 0 {
+		fraction = float64(p.Blocks) / float64(p.TotalBlocks)
+	}
+	if p.Done || fraction > 1 {
+		fraction = 1
+	}
+	filled := int(fraction * width)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+	line := fmt.Sprintf("\r%s [%s] %3.0f%% %d/%d Blocks %7.2f MB/s", p.Path, bar, fraction*100, p.Blocks, p.TotalBlocks, p.Throughput/1e6)
+	if p.Done {
+		fmt.Fprintf(os.Stderr, "%s in %s\n", line, p.Elapsed.Round(time.Millisecond))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s ETA %s   ", line, p.ETA.Round(time.Second))
+}
+
+// statusTime converts a time of a FileStatus, in milliseconds since the
+// epoch, to local time
+func statusTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// connect configures the client and connects to the namenode. The namenode
+// is taken from -namenode, the -config file, the client configuration file
+// named by GODFS_CONFIG, or the host:port in GODFS_NAMENODE, in that order.
+func connect() error {
+	if configpath == "" {
+		configpath = os.Getenv("GODFS_CONFIG")
+	}
+	if configpath != "" {
+		err := client.ParseConfigXML(configpath)
+		if err != nil {
+			return err
+		}
+		if namenodeAddress != "" {
+			return client.Connect(namenodeAddress)
+		}
+		return client.Connect(client.Address())
+	}
+
+	address := namenodeAddress
+	if address == "" {
+		address = os.Getenv("GODFS_NAMENODE")
+	}
+	if address == "" {
+		return errors.New("No namenode configured, use -config, GODFS_CONFIG or GODFS_NAMENODE")
+	}
+	client.SIZEOFBLOCK = defaultBlockSize
+	return client.Connect(address)
+}
+
+// runCommand parses the arguments of a subcommand and runs it
+func runCommand(name string, args []string) error {
+	cmd := commands[name]
+	fs := flag.NewFlagSet("godfs "+name, flag.ExitOnError)
+	fs.StringVar(&configpath, "config", "", "client configuration file")
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage : godfs", name, cmd.usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if cmd.nargs >= 0 && fs.NArg() != cmd.nargs {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if !cmd.local {
+		err := connect()
+		if err != nil {
+			return err
+		}
+	}
+	return cmd.run(fs)
+}
+
+// printUsage describes the server modes and subcommands
+func printUsage() {
+	fmt.Println("Invalid command, usage : ")
+	fmt.Println(" \t godfs namenode [location of namenode configuration file] ")
+	fmt.Println(" \t godfs datanode [location of datanode configuration file] ")
+	fmt.Println(" \t godfs client [location of client configuration file] ")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf(" \t godfs %s %s\n \t\t %s\n", name, commands[name].usage, commands[name].short)
+	}
+}
+
+// dfsadmin runs the administrative request named by the first of args,
+// returning the namenode's answer
+func dfsadmin(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("No administrative request given")
+	}
+	op, args := strings.ToLower(args[0]), args[1:]
+	want := 0
+	switch op {
+	case "safemode", "setblocksize", "shutdowndatanode", "settransferbandwidth", "recoverlease":
+		want = 1
+	case "triggerblockreport", "hastate", "tenants":
+		if len(args) == 1 {
+			want = 1
+		}
+	}
+	if len(args) != want {
+		return "", errors.New("Wrong number of arguments for " + op)
+	}
+
+	switch op {
+	case "report":
+		if !reportJSON {
+			return client.Report()
+		}
+		report, err := client.GetReport()
+		if err != nil {
+			return "", err
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		return string(data), err
+	case "safemode":
+		return client.SafeMode(args[0])
+	case "refreshnodes":
+		return client.RefreshNodes()
+	case "setblocksize":
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return "", err
+		}
+		return client.SetBlockSize(n)
+	case "listopenleases":
+		return client.ListOpenLeases()
+	case "recoverlease":
+		return client.RecoverLease(args[0])
+	case "triggerblockreport":
+		datanodeID := ""
+		if len(args) == 1 {
+			datanodeID = args[0]
+		}
+		return client.TriggerBlockReport(datanodeID)
+	case "shutdowndatanode":
+		return client.ShutdownDatanode(args[0])
+	case "settransferbandwidth":
+		n, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return "", err
+		}
+		return client.SetTransferBandwidth(n)
+	case "hastate":
+		action := ""
+		if len(args) == 1 {
+			action = args[0]
+		}
+		return client.HAState(action)
+	case "tenants":
+		action := ""
+		if len(args) == 1 {
+			action = args[0]
+		}
+		return client.Tenants(action)
+	}
+	return "", errors.New("Unknown administrative request " + op)
+}
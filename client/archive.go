@@ -0,0 +1,101 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// CreateArchive packs the files below the directory src one after another
+// into the file archive, and has the namenode index them. The archive then
+// reads as a read-only directory holding the same files, which take one
+// file's worth of namenode memory and Blocks between them. src is left as
+// it was.
+func CreateArchive(src, archive string) error {
+	st, err := Stat(src)
+	if err != nil {
+		return err
+	}
+	if !st.IsDir {
+		return errors.New("No such directory " + src)
+	}
+	list, err := ListDir(src, true)
+	if err != nil {
+		return err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+
+	// the index gives each file its offset within the archive
+	root := strings.TrimSuffix(src, "/")
+	index := make([]FileStatus, 0, len(list))
+	var files []FileStatus
+	var size int64
+	for _, st := range list {
+		if st.Symlink != "" {
+			// links are not packed, as their targets may lie outside src
+			continue
+		}
+		if st.Archive == st.Path {
+			return errors.New("Cannot pack the archive " + st.Path)
+		}
+		entry := FileStatus{Path: strings.TrimPrefix(st.Path, root), IsDir: st.IsDir, ModTime: st.ModTime}
+		if !st.IsDir {
+			entry.Offset, entry.Size = size, st.Size
+			size += st.Size
+			files = append(files, st)
+		}
+		index = append(index, entry)
+	}
+	if size == 0 {
+		return errors.New("No data to pack in " + src)
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		for _, st := range files {
+			f, err := clusterStore{}.open(st.Path)
+			if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+			n, err := io.Copy(w, f)
+			f.Close()
+			if err == nil && n != st.Size {
+				err = fmt.Errorf("%s changed while it was packed", st.Path)
+			}
+			if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+		w.Close()
+	}()
+	err = DistributeBlocksFromReader(r, size, archive)
+	r.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+
+	p := Packet{SRC: id, DST: "NN", CMD: ARCHIVE, Headers: []BlockHeader{{Filename: archive}}, Status: index}
+	err = send(p)
+	if err != nil {
+		Delete(archive, false, true)
+		return err
+	}
+	return nil
+}
+
+// ExtractArchive copies the files packed into archive out to the directory
+// dst, as files of their own
+func ExtractArchive(archive, dst string) error {
+	st, err := Stat(archive)
+	if err != nil {
+		return err
+	}
+	if st.Archive == "" {
+		return errors.New("Not an archive " + archive)
+	}
+	return Copy(archive, dst, nil)
+}
@@ -0,0 +1,454 @@
+package client
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// name of the first entry of a backup, describing the rest
+const manifestName = "manifest.json"
+
+// directory of a backup holding the data of its files
+const backupDataDir = "data"
+
+// extended attribute of a restored directory, naming the snapshot it holds
+const restoredXAttr = "user.backup.snapshot"
+
+// backupManifest describes a backup of a directory. Paths are relative to
+// the directory, starting with a slash, and empty for the directory itself.
+type backupManifest struct {
+	Dir      string       // directory backed up
+	Snapshot string       // snapshot of the directory the backup was read from
+	Base     string       // snapshot of the backup this one adds to, empty for a full backup
+	Created  int64        // time of the backup, in milliseconds since the epoch
+	Entries  []FileStatus // every file, directory and link in the snapshot, with its attributes
+	Changed  []string     // files whose data the backup holds, in the order it holds them
+	Deleted  []string     // paths deleted since the base snapshot
+	Renamed  [][2]string  // paths renamed since the base snapshot, and their new paths
+}
+
+// Backup writes a backup of the directory dir to dest, which is a local
+// file written file:///path or an object of an S3 compatible store written
+// s3://bucket/key or gs://bucket/key. The backup is a tar archive of a
+// manifest describing the namespace below dir, followed by the files' data.
+// dir is snapshotted first and read from the snapshot, so the backup is
+// consistent while dir keeps changing. With since set to the snapshot of an
+// earlier backup only the files changed since are included, along with the
+// paths deleted. It returns the name of the new snapshot, which the next
+// incremental backup is made since. progress, if not nil, is called after
+// each file.
+func Backup(dir, dest, since string, progress func(CopyProgress)) (string, error) {
+	dir = cleanDir(dir)
+	name := "backup-" + time.Now().UTC().Format("20060102T150405.000Z")
+	err := CreateSnapshot(dir, name)
+	if err != nil {
+		return "", err
+	}
+	m, err := backupEntries(dir, name)
+	if err == nil {
+		m.Base = since
+		err = m.diff(since)
+	}
+	if err == nil {
+		err = writeBackup(m, dest, progress)
+	}
+	if err != nil {
+		// a failed backup cannot be the base of the next one
+		DeleteSnapshot(dir, name)
+		return "", err
+	}
+	return name, nil
+}
+
+// snapshotPath returns the path at which the snapshot name of dir is read
+func snapshotPath(dir, name string) string {
+	return strings.TrimSuffix(dir, "/") + "/.snapshot/" + name
+}
+
+// backupEntries describes the paths of the snapshot name of dir, with the
+// attributes of the same paths outside the snapshot, which snapshots do not
+// keep. Every file is counted as changed.
+func backupEntries(dir, name string) (*backupManifest, error) {
+	snapshot := snapshotPath(dir, name)
+	list, err := ListDir(snapshot, true)
+	if err != nil {
+		return nil, err
+	}
+	live, err := ListDir(dir, true)
+	if err != nil {
+		return nil, err
+	}
+	root, err := Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string]FileStatus, len(live))
+	for _, st := range live {
+		attrs[strings.TrimPrefix(st.Path, strings.TrimSuffix(dir, "/"))] = st
+	}
+	m := &backupManifest{Dir: dir, Snapshot: name, Created: time.Now().UnixNano() / int64(time.Millisecond)}
+	root.Path = ""
+	m.Entries = append(m.Entries, root)
+	for _, st := range list {
+		rel := strings.TrimPrefix(st.Path, snapshot)
+		a, ok := attrs[rel]
+		if ok && a.Symlink != "" {
+			// snapshots keep links as empty directories
+			continue
+		}
+		if ok {
+			st.ModTime, st.AccessTime, st.Storage = a.ModTime, a.AccessTime, a.Storage
+			st.FileQuota, st.SpaceQuota = a.FileQuota, a.SpaceQuota
+		}
+		st.Path = rel
+		m.Entries = append(m.Entries, st)
+		if !st.IsDir {
+			m.Changed = append(m.Changed, rel)
+		}
+	}
+	for rel, st := range attrs {
+		if st.Symlink != "" {
+			st.Path = rel
+			m.Entries = append(m.Entries, st)
+		}
+	}
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].Path < m.Entries[j].Path })
+
+	for i, st := range m.Entries {
+		if st.Symlink != "" {
+			continue
+		}
+		names, err := ListXAttrs(dir + st.Path)
+		if err != nil {
+			continue
+		}
+		for _, n := range names {
+			value, err := GetXAttr(dir+st.Path, n)
+			if err != nil {
+				return nil, err
+			}
+			if m.Entries[i].XAttrs == nil {
+				m.Entries[i].XAttrs = make(map[string][]byte)
+			}
+			m.Entries[i].XAttrs[n] = value
+		}
+	}
+	return m, nil
+}
+
+// diff keeps only the files created or modified since the snapshot base of
+// the backed up directory, and lists the paths deleted and renamed since
+func (m *backupManifest) diff(base string) error {
+	if base == "" {
+		return nil
+	}
+	changes, err := SnapshotDiff(m.Dir, base, m.Snapshot)
+	if err != nil {
+		return err
+	}
+	isFile := make(map[string]bool, len(m.Entries))
+	for _, st := range m.Entries {
+		isFile[st.Path] = !st.IsDir && st.Symlink == ""
+	}
+
+	root := strings.TrimSuffix(m.Dir, "/")
+	m.Changed = nil
+	for _, st := range changes {
+		rel := strings.TrimPrefix(st.Path, root)
+		switch st.Change {
+		case "+", "M":
+			if isFile[rel] {
+				m.Changed = append(m.Changed, rel)
+			}
+		case "-":
+			m.Deleted = append(m.Deleted, rel)
+		case "R":
+			m.Renamed = append(m.Renamed, [2]string{strings.TrimPrefix(st.Source, root), rel})
+		}
+	}
+	return nil
+}
+
+// createBackup opens the location dest of a backup for writing
+func createBackup(dest string) (io.WriteCloser, error) {
+	switch {
+	case strings.HasPrefix(dest, "file://"):
+		return os.Create(filepath.FromSlash(strings.TrimPrefix(dest, "file://")))
+	case strings.HasPrefix(dest, "s3://"), strings.HasPrefix(dest, "gs://"):
+		s, key, err := parseObjectURL(dest)
+		if err != nil {
+			return nil, err
+		}
+		return s.create(key)
+	}
+	return nil, errors.New("Invalid backup location " + dest + ", expected file:///path, s3://bucket/key or gs://bucket/key")
+}
+
+// openBackup opens the backup at src for reading
+func openBackup(src string) (io.ReadCloser, error) {
+	switch {
+	case strings.HasPrefix(src, "file://"):
+		return os.Open(filepath.FromSlash(strings.TrimPrefix(src, "file://")))
+	case strings.HasPrefix(src, "s3://"), strings.HasPrefix(src, "gs://"):
+		s, key, err := parseObjectURL(src)
+		if err != nil {
+			return nil, err
+		}
+		return s.open(key)
+	}
+	return nil, errors.New("Invalid backup location " + src + ", expected file:///path, s3://bucket/key or gs://bucket/key")
+}
+
+// writeBackup writes the manifest m and the data of its changed files, read
+// from its snapshot, to dest
+func writeBackup(m *backupManifest, dest string, progress func(CopyProgress)) error {
+	sizes := make(map[string]int64, len(m.Entries))
+	for _, st := range m.Entries {
+		sizes[st.Path] = st.Size
+	}
+	var p CopyProgress
+	for _, rel := range m.Changed {
+		p.TotalFiles++
+		p.TotalBytes += sizes[rel]
+	}
+
+	w, err := createBackup(dest)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	manifest, err := json.Marshal(m)
+	if err == nil {
+		err = tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0644, Size: int64(len(manifest)), ModTime: time.Now()})
+	}
+	if err == nil {
+		_, err = tw.Write(manifest)
+	}
+
+	snapshot := snapshotPath(m.Dir, m.Snapshot)
+	for _, rel := range m.Changed {
+		if err != nil {
+			break
+		}
+		err = tw.WriteHeader(&tar.Header{Name: backupDataDir + rel, Mode: 0644, Size: sizes[rel], ModTime: time.Now()})
+		if err != nil {
+			break
+		}
+		bw := bufio.NewWriterSize(tw, SIZEOFBLOCK)
+		err = RetrieveToWriter(bw, snapshot+rel)
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err == nil {
+			p.Files++
+			p.Bytes += sizes[rel]
+			p.Path = rel
+			if progress != nil {
+				progress(p)
+			}
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Restore rebuilds the directory dir from the backup at src, written file:///path,
+// s3://bucket/key or gs://bucket/key. A full backup is restored into a
+// directory which is empty or does not exist. An incremental backup is
+// restored over the directory restored from the backup it adds to, so a
+// directory is rebuilt by restoring its full backup and then each
+// incremental one in turn. progress, if not nil, is called after each file.
+func Restore(src, dir string, progress func(CopyProgress)) error {
+	dir = cleanDir(dir)
+	r, err := openBackup(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return err
+	}
+	if hdr.Name != manifestName {
+		return errors.New("Not a backup, it starts with " + hdr.Name)
+	}
+	var m backupManifest
+	err = json.NewDecoder(tr).Decode(&m)
+	if err != nil {
+		return err
+	}
+
+	if m.Base == "" {
+		st, err := Stat(dir)
+		if err == nil && (!st.IsDir || st.Children > 0) {
+			return errors.New("A full backup is restored into an empty directory, " + dir + " is not")
+		}
+	} else if held, _ := GetXAttr(dir, restoredXAttr); string(held) != m.Base {
+		return errors.New("The backup adds to snapshot " + m.Base + ", but " + dir + " holds " + string(held))
+	}
+	err = Mkdir(dir, true)
+	if err != nil {
+		return err
+	}
+	err = m.restoreNames(dir)
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]FileStatus, len(m.Entries))
+	for _, st := range m.Entries {
+		entries[st.Path] = st
+		if st.IsDir && st.Path != "" {
+			err = Mkdir(dir+st.Path, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var p CopyProgress
+	p.TotalFiles = len(m.Changed)
+	for _, rel := range m.Changed {
+		p.TotalBytes += entries[rel].Size
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(hdr.Name, backupDataDir)
+		st := entries[rel]
+		if _, err := Stat(dir + rel); err == nil {
+			// a file changed since the base backup is written again
+			err = Delete(dir+rel, false, true)
+			if err != nil {
+				return err
+			}
+		}
+		err = DistributeWithLayoutFromReader(tr, hdr.Size, dir+rel, st.BlockSize, st.Replicas)
+		if err != nil {
+			return err
+		}
+		p.Files++
+		p.Bytes += hdr.Size
+		p.Path = rel
+		if progress != nil {
+			progress(p)
+		}
+	}
+
+	return m.restoreAttributes(dir)
+}
+
+// restoreNames renames and deletes the paths below dir which were renamed
+// and deleted since the base snapshot. Renamed paths are first moved aside,
+// as one may be renamed to the path of another, or out of a deleted
+// directory.
+func (m *backupManifest) restoreNames(dir string) error {
+	for i, r := range m.Renamed {
+		err := Rename(dir+r[0], dir+"/.restore-"+strconv.Itoa(i))
+		if err != nil {
+			return err
+		}
+	}
+	for _, rel := range m.Deleted {
+		if _, err := Stat(dir + rel); err == nil {
+			err = Delete(dir+rel, true, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for i, r := range m.Renamed {
+		err := Mkdir(dir+r[1][:strings.LastIndex(r[1], "/")], true)
+		if err == nil {
+			err = Rename(dir+"/.restore-"+strconv.Itoa(i), dir+r[1])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreAttributes recreates the links of the manifest below dir, and sets
+// the extended attributes, storage policies, quotas and times of its paths
+func (m *backupManifest) restoreAttributes(dir string) error {
+	policies := make(map[string]string, len(m.Entries))
+	for _, st := range m.Entries {
+		p := dir + st.Path
+		if st.Symlink != "" {
+			target := st.Symlink
+			if st.Symlink == m.Dir || strings.HasPrefix(st.Symlink, strings.TrimSuffix(m.Dir, "/")+"/") {
+				target = dir + strings.TrimPrefix(st.Symlink, strings.TrimSuffix(m.Dir, "/"))
+			}
+			if _, err := Readlink(p); err != nil {
+				err = CreateSymlink(target, p, true)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for name, value := range st.XAttrs {
+			err := SetXAttr(p, name, value)
+			if err != nil {
+				return err
+			}
+		}
+		// policies inherited from the parent are not set again
+		policies[st.Path] = st.Storage
+		parent := ""
+		if st.Path != "" {
+			parent = policies[st.Path[:strings.LastIndex(st.Path, "/")]]
+		}
+		if st.Storage != parent {
+			err := SetStoragePolicy(p, st.Storage)
+			if err != nil {
+				return err
+			}
+		}
+		if st.FileQuota > 0 || st.SpaceQuota > 0 {
+			err := SetQuota(p, st.FileQuota, st.SpaceQuota)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// changes below a directory do not touch its times once they are set
+	for i := len(m.Entries) - 1; i >= 0; i-- {
+		st := m.Entries[i]
+		if st.Symlink != "" || st.ModTime <= 0 {
+			continue
+		}
+		var atime time.Time
+		if st.AccessTime > 0 {
+			atime = time.Unix(0, st.AccessTime*int64(time.Millisecond))
+		}
+		err := SetTimes(dir+st.Path, time.Unix(0, st.ModTime*int64(time.Millisecond)), atime)
+		if err != nil {
+			return err
+		}
+	}
+	return SetXAttr(dir, restoredXAttr, []byte(m.Snapshot))
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"fmt"
+)
+
+// requests the namenode accepts in a single BATCH
+const maxBatchSize = 10000
+
+// Batch sends many namespace requests to the namenode at once, up to
+// maxBatchSize in each BATCH, and returns their answers in the same order.
+// Each request succeeds or fails by itself, an ERROR answer failing only
+// that request, and the error returned is that of a BATCH which failed.
+func Batch(ops []Packet) ([]Packet, error) {
+	answers := make([]Packet, 0, len(ops))
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		p := Packet{SRC: id, DST: "NN", CMD: BATCH, Commands: ops[:n]}
+		r, err := roundTrip(p)
+		if err != nil {
+			return answers, err
+		}
+		if r.CMD == ERROR {
+			return answers, responseError(r)
+		}
+		if r.CMD != BATCH || len(r.Commands) != n {
+			return answers, fmt.Errorf("Bad response packet %v", r)
+		}
+		answers = append(answers, r.Commands...)
+		ops = ops[n:]
+	}
+	return answers, nil
+}
+
+// batchRequest is a namespace request for path, as one of a BATCH
+func batchRequest(cmd int, path string, flags int) Packet {
+	return Packet{SRC: id, DST: "NN", CMD: cmd, Flags: flags, Headers: []BlockHeader{{Filename: path}}}
+}
+
+// batchErrors returns the error of each answer of a BATCH, or err for each
+// request if the BATCH failed before they were answered
+func batchErrors(answers []Packet, n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		switch {
+		case i >= len(answers):
+			errs[i] = err
+		case answers[i].CMD == ERROR:
+			errs[i] = responseError(answers[i])
+		}
+	}
+	return errs
+}
+
+// StatAll describes the files and directories at paths with as few requests
+// as can carry them, returning the status of each path and the error of each
+// path which could not be described
+func StatAll(paths []string) ([]FileStatus, []error) {
+	ops := make([]Packet, len(paths))
+	for i, path := range paths {
+		ops[i] = batchRequest(STAT, path, 0)
+	}
+	answers, err := Batch(ops)
+	errs := batchErrors(answers, len(paths), err)
+
+	statuses := make([]FileStatus, len(paths))
+	for i, r := range answers {
+		if errs[i] != nil {
+			continue
+		}
+		if r.CMD != STAT || len(r.Status) != 1 {
+			errs[i] = fmt.Errorf("Bad response packet %v", r)
+			continue
+		}
+		statuses[i] = r.Status[0]
+	}
+	return statuses, errs
+}
+
+// DeleteAll removes the files and directories at paths with as few requests
+// as can carry them, as Delete does each, returning the error of each path
+// which could not be removed
+func DeleteAll(paths []string, recursive, skipTrash bool) []error {
+	flags := 0
+	if recursive {
+		flags |= RECURSIVE
+	}
+	if skipTrash {
+		flags |= SKIPTRASH
+	}
+	ops := make([]Packet, len(paths))
+	for i, path := range paths {
+		ops[i] = batchRequest(DELETE, path, flags)
+	}
+	answers, err := Batch(ops)
+	for _, r := range answers {
+		if r.CMD == ACK && r.Message != "" {
+			fmt.Println(r.Message)
+		}
+	}
+	return batchErrors(answers, len(paths), err)
+}
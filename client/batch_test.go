@@ -0,0 +1,71 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+
+	// the namenode knows the files below /logs, and counts the batches asked
+	batches := make(chan int, 10)
+	go func() {
+		dec := json.NewDecoder(server)
+		enc := json.NewEncoder(server)
+		for {
+			var p Packet
+			if dec.Decode(&p) != nil {
+				close(batches)
+				return
+			}
+			r := Packet{SRC: "NN", DST: p.SRC, CMD: BATCH, RequestID: p.RequestID}
+			batches <- len(p.Commands)
+			for _, op := range p.Commands {
+				a := Packet{CMD: op.CMD}
+				name := op.Headers[0].Filename
+				if len(name) < 6 || name[:6] != "/logs/" {
+					a = Packet{CMD: ERROR, Message: "File not found " + name}
+				} else if op.CMD == STAT {
+					a.Status = []FileStatus{{Path: name}}
+				} else {
+					a.CMD = ACK
+				}
+				r.Commands = append(r.Commands, a)
+			}
+			enc.Encode(r)
+		}
+	}()
+
+	statuses, errs := StatAll([]string{"/logs/a", "/missing", "/logs/b"})
+	if statuses[0].Path != "/logs/a" || statuses[2].Path != "/logs/b" || errs[0] != nil || errs[2] != nil {
+		t.Errorf("Wrong statuses %v %v", statuses, errs)
+	}
+	if errs[1] == nil {
+		t.Errorf("Stat of a missing file did not fail")
+	}
+
+	// a batch larger than the namenode accepts is split
+	paths := make([]string, maxBatchSize+1)
+	for i := range paths {
+		paths[i] = "/logs/x"
+	}
+	for i, err := range DeleteAll(paths, false, false) {
+		if err != nil {
+			t.Fatalf("Delete %d failed %v", i, err)
+		}
+	}
+
+	client.Close()
+	var sizes []int
+	for n := range batches {
+		sizes = append(sizes, n)
+	}
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != maxBatchSize || sizes[2] != 1 {
+		t.Errorf("Batches of %v requests sent", sizes)
+	}
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"fmt"
+)
+
+// CacheStats describes the use of a datanode's block cache
+type CacheStats struct {
+	Capacity int64 // bytes the cache may hold
+	Used     int64 // bytes of the Blocks in the cache
+	Blocks   int   // Blocks in the cache
+	Pinned   int   // Blocks the namenode asked to keep, which are never evicted
+	Hits     int64 // reads answered from the cache
+	Misses   int64 // reads of Blocks missing from the cache
+}
+
+// Cache asks the namenode to keep a replica of each Block of the files at or
+// below path in the memory of its datanode, so they are read without going
+// to disc. Only administrators may cache paths.
+func Cache(path string) error {
+	_, err := request(CACHE, path, 0)
+	return err
+}
+
+// Uncache lets the datanodes evict the Blocks kept in memory for a path
+// given to Cache
+func Uncache(path string) error {
+	_, err := request(UNCACHE, path, 0)
+	return err
+}
+
+// ListCache returns the paths whose Blocks are kept in memory, with the size
+// and number of the Blocks below each
+func ListCache() ([]FileStatus, error) {
+	r, err := request(LISTCACHE, "/", 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.CMD != LISTCACHE {
+		return nil, fmt.Errorf("Bad response packet %v", r)
+	}
+	return r.Status, nil
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+var metadataCacheTTL time.Duration // time the headers and status of a path are cached, 0 disables the cache
+var metadataCacheSize = 1024       // paths kept in the metadata cache, the least recently used dropped first
+
+// metadata caches the answers to GETHEADERS and STAT
+var metadata = newMetadataCache()
+
+// cacheKey names a cached answer
+type cacheKey struct {
+	cmd    int
+	path   string
+	offset int // first header of a page of GETHEADERS
+	limit  int
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	r       Packet
+	expires time.Time
+}
+
+// metadataCache keeps the answers to GETHEADERS and STAT requests for
+// metadataCacheTTL, so repeated reads of a file do not ask the namenode
+// each time. Answers for a path are dropped when this client changes it.
+type metadataCache struct {
+	lock    sync.Mutex
+	entries map[cacheKey]*list.Element
+	order   *list.List // most recently used first
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{entries: make(map[cacheKey]*list.Element), order: list.New()}
+}
+
+// cacheable returns the key of a request whose answer may be cached
+func cacheable(p Packet) (cacheKey, bool) {
+	if (p.CMD != GETHEADERS && p.CMD != STAT) || p.Flags != 0 || len(p.Headers) != 1 {
+		return cacheKey{}, false
+	}
+	return cacheKey{p.CMD, p.Headers[0].Filename, p.Offset, p.Limit}, true
+}
+
+// get returns the cached answer to p, if it has not expired
+func (c *metadataCache) get(p Packet) (Packet, bool) {
+	key, ok := cacheable(p)
+	if !ok || metadataCacheTTL == 0 {
+		return Packet{}, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return Packet{}, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(e)
+		delete(c.entries, key)
+		return Packet{}, false
+	}
+	c.order.MoveToFront(e)
+	r := entry.r
+	r.RequestID = p.RequestID
+	return r, true
+}
+
+// observe caches the answer r to p, or drops the answers a mutating request
+// made stale
+func (c *metadataCache) observe(p Packet, r Packet) {
+	if mutates(p.CMD) {
+		c.invalidate(p)
+		return
+	}
+	key, ok := cacheable(p)
+	// files still being written grow as they are flushed
+	if !ok || metadataCacheTTL == 0 || r.CMD != p.CMD || writing(r) {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.order.Remove(e)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key, r, time.Now().Add(metadataCacheTTL)})
+	for c.order.Len() > metadataCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate drops the answers for the paths a mutating request changes, or
+// the requests of a BATCH change, and for the paths below them, which a
+// rename or delete of a directory moves
+func (c *metadataCache) invalidate(p Packet) {
+	paths := make([]string, 0, len(p.Headers)+len(p.Renamed)+1)
+	for _, h := range p.Headers {
+		paths = append(paths, h.Filename)
+	}
+	for _, h := range p.Renamed {
+		paths = append(paths, h.Filename)
+	}
+	if p.Data.Header.Filename != "" {
+		paths = append(paths, p.Data.Header.Filename)
+	}
+	for _, op := range p.Commands {
+		for _, h := range op.Headers {
+			paths = append(paths, h.Filename)
+		}
+		for _, h := range op.Renamed {
+			paths = append(paths, h.Filename)
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, e := range c.entries {
+		for _, path := range paths {
+			if key.path == path || path == "/" || strings.HasPrefix(key.path, strings.TrimSuffix(path, "/")+"/") {
+				c.order.Remove(e)
+				delete(c.entries, key)
+				break
+			}
+		}
+	}
+}
+
+// clear drops every cached answer
+func (c *metadataCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[cacheKey]*list.Element)
+	c.order.Init()
+}
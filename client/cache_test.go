@@ -0,0 +1,79 @@
+package client
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMetadataCache(t *testing.T) {
+
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+	metadataCacheTTL = time.Minute
+	metadataCacheSize = 2
+	defer func() { metadataCacheTTL, metadataCacheSize = 0, 1024; metadata.clear() }()
+
+	// the namenode counts the requests it is asked
+	var asked int64
+	go func() {
+		dec := json.NewDecoder(server)
+		enc := json.NewEncoder(server)
+		for {
+			var p Packet
+			if dec.Decode(&p) != nil {
+				return
+			}
+			atomic.AddInt64(&asked, 1)
+			r := Packet{SRC: "NN", DST: p.SRC, CMD: p.CMD, RequestID: p.RequestID}
+			switch p.CMD {
+			case STAT:
+				r.Status = []FileStatus{{Path: p.Headers[0].Filename}}
+			case GETHEADERS:
+				r.Headers = []BlockHeader{{"DN1", p.Headers[0].Filename, 1, 0, 1, 0, "", 0, ""}}
+			default:
+				r.CMD = ACK
+			}
+			enc.Encode(r)
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		if st, err := Stat("/dir/a"); err != nil || st.Path != "/dir/a" {
+			t.Fatalf("Stat %v %v", st, err)
+		}
+		if r, err := fileHeaders("/dir/a"); err != nil || len(r.Headers) != 1 {
+			t.Fatalf("Headers %v %v", r, err)
+		}
+	}
+	if n := atomic.LoadInt64(&asked); n != 2 {
+		t.Errorf("Namenode asked %d times, expected 2", n)
+	}
+
+	// changing a directory drops the answers for the paths below it
+	if err := Rename("/dir", "/moved"); err != nil {
+		t.Fatal(err)
+	}
+	Stat("/dir/a")
+	if n := atomic.LoadInt64(&asked); n != 4 {
+		t.Errorf("Namenode asked %d times after a rename, expected 4", n)
+	}
+
+	// the least recently used path is dropped, and answers expire
+	Stat("/b")
+	Stat("/c")
+	Stat("/dir/a")
+	if n := atomic.LoadInt64(&asked); n != 7 {
+		t.Errorf("Namenode asked %d times, expected the oldest path dropped", n)
+	}
+	metadataCacheTTL = time.Nanosecond
+	Stat("/e")
+	time.Sleep(time.Millisecond)
+	Stat("/e")
+	if n := atomic.LoadInt64(&asked); n != 9 {
+		t.Errorf("Namenode asked %d times, expected expired answers asked again", n)
+	}
+}
@@ -0,0 +1,213 @@
+package client
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checksumAlgorithm names the composite checksum of a file
+const checksumAlgorithm = "MD5-of-CRC32"
+
+var checksumTimeout = 10 * time.Second // wait for a datanode to checksum a Block before asking another replica
+
+// FileChecksum is the composite checksum of a file: the MD5 of the CRC-32 of
+// the data of each of its Blocks in order, as HDFS computes it. It depends
+// on where the Blocks of the file begin, so a local copy has the same
+// checksum only when it is cut into Blocks of the same BlockSize.
+type FileChecksum struct {
+	Algorithm string
+	BlockSize int // bytes of each Block but the last, 0 if they differ
+	Size      int64
+	NumBlocks int
+	Sum       string // MD5 in hex
+}
+
+// Checksum returns the composite checksum of the file at remotename, from
+// the CRC-32 of each Block computed by a datanode holding it, without
+// retrieving the file's data. Erasure coded files, encrypted files, packed
+// files and files still being written have no checksum.
+func Checksum(remotename string) (FileChecksum, error) {
+	r, err := fileHeaders(remotename)
+	if err != nil {
+		return FileChecksum{}, err
+	}
+	if archived(r) || writing(r) {
+		return FileChecksum{}, errors.New("Packed files and files being written have no checksum " + remotename)
+	}
+	if len(r.Status) == 1 && (r.Status[0].Erasure != "" || r.Status[0].Key != nil) {
+		return FileChecksum{}, errors.New("Erasure coded and encrypted files have no checksum " + remotename)
+	}
+	headers := r.Headers
+	if len(headers) != headers[0].NumBlocks {
+		return FileChecksum{}, fmt.Errorf("%s lists %d of its %d Blocks", remotename, len(headers), headers[0].NumBlocks)
+	}
+
+	// the Blocks are checksummed up to parallelism at once
+	sums := make([]uint32, len(headers))
+	errs := make(chan error, len(headers))
+	slots := make(chan struct{}, parallelism)
+	for i, h := range headers {
+		slots <- struct{}{}
+		go func(i int, h BlockHeader) {
+			var err error
+			sums[i], err = blockChecksum(h)
+			<-slots
+			errs <- err
+		}(i, h)
+	}
+	for range headers {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return FileChecksum{}, err
+	}
+
+	sizes := make([]int, len(headers))
+	for i, h := range headers {
+		sizes[i] = h.Size
+	}
+	return newFileChecksum(sizes, sums), nil
+}
+
+// blockChecksum asks a datanode holding the Block h names for the CRC-32
+// of its data, starting with the replica h names. A replica which fails or
+// does not answer within checksumTimeout is given up, and the namenode is
+// asked for another, naming in Message the datanodes tried.
+func blockChecksum(h BlockHeader) (uint32, error) {
+	tried := make([]string, 0)
+	for {
+		sum, err := replicaChecksum(h)
+		if err == nil {
+			return sum, nil
+		}
+		log.Println("Could not checksum Block ", h.BlockNum, " of ", h.Filename, " on ", h.DatanodeID, err)
+		tried = append(tried, h.DatanodeID)
+
+		r, rerr := roundTrip(Packet{SRC: id, DST: "NN", CMD: CHECKSUM, Headers: []BlockHeader{h}, Message: strings.Join(tried, ",")})
+		if rerr != nil {
+			return 0, rerr
+		}
+		if r.CMD == ERROR {
+			return 0, fmt.Errorf("%v, tried %s: %v", responseError(r), strings.Join(tried, ", "), err)
+		}
+		if r.CMD != CHECKSUM || len(r.Headers) != 1 {
+			return 0, fmt.Errorf("Bad response packet %v", r)
+		}
+		h = r.Headers[0]
+	}
+}
+
+// replicaChecksum asks the datanode named by h for the CRC-32 of the data of
+// its replica, waiting up to checksumTimeout
+func replicaChecksum(h BlockHeader) (uint32, error) {
+	p := Packet{SRC: id, DST: "NN", CMD: CHECKSUM, Headers: []BlockHeader{h}}
+	leave, err := enterNamespace(p)
+	if err != nil {
+		return 0, err
+	}
+	defer leave()
+	ch, requestID, err := startRequest(p, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	timer := time.NewTimer(checksumTimeout)
+	defer timer.Stop()
+	var r Packet
+	var ok bool
+	select {
+	case r, ok = <-ch:
+		if !ok {
+			return 0, errConnectionLost
+		}
+	case <-timer.C:
+		cancel(requestID)
+		return 0, fmt.Errorf("No answer from datanode %s within %s", h.DatanodeID, checksumTimeout)
+	}
+	if r.CMD == ERROR {
+		return 0, responseError(r)
+	}
+	if r.CMD != CHECKSUMACK || len(r.Headers) != 1 || r.Headers[0].Filename != h.Filename || r.Headers[0].BlockNum != h.BlockNum {
+		return 0, fmt.Errorf("Bad response packet %v", r)
+	}
+	sum, err := strconv.ParseUint(r.Message, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Bad checksum of Block %d of %s: %v", h.BlockNum, h.Filename, err)
+	}
+	return uint32(sum), nil
+}
+
+// LocalChecksum returns the composite checksum of a local file cut into
+// Blocks of blockSize bytes, to compare with that of a remote copy
+func LocalChecksum(localname string, blockSize int) (FileChecksum, error) {
+	fi, err := os.Open(localname)
+	if err != nil {
+		return FileChecksum{}, err
+	}
+	defer fi.Close()
+	return ChecksumReader(fi, blockSize)
+}
+
+// ChecksumReader returns the composite checksum of the data read from r
+// cut into Blocks of blockSize bytes
+func ChecksumReader(r io.Reader, blockSize int) (FileChecksum, error) {
+	if blockSize <= 0 {
+		return FileChecksum{}, errors.New("Invalid block size " + strconv.Itoa(blockSize))
+	}
+	buf := make([]byte, blockSize)
+	sizes := make([]int, 0)
+	sums := make([]uint32, 0)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			sizes = append(sizes, n)
+			sums = append(sums, crc32.ChecksumIEEE(buf[:n]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return FileChecksum{}, err
+		}
+	}
+	return newFileChecksum(sizes, sums), nil
+}
+
+// newFileChecksum composes the checksum of a file from the size and CRC-32
+// of each of its Blocks
+func newFileChecksum(sizes []int, sums []uint32) FileChecksum {
+	c := FileChecksum{Algorithm: checksumAlgorithm, NumBlocks: len(sums)}
+	md := md5.New()
+	var crc [4]byte
+	for i, sum := range sums {
+		binary.BigEndian.PutUint32(crc[:], sum)
+		md.Write(crc[:])
+		c.Size += int64(sizes[i])
+	}
+	c.Sum = hex.EncodeToString(md.Sum(nil))
+	if len(sizes) > 0 {
+		c.BlockSize = sizes[0]
+	}
+	for i := 1; i < len(sizes)-1; i++ {
+		if sizes[i] != c.BlockSize {
+			c.BlockSize = 0
+		}
+	}
+	// only the last Block may be shorter
+	if n := len(sizes); n > 1 && sizes[n-1] > c.BlockSize {
+		c.BlockSize = 0
+	}
+	return c
+}
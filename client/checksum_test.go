@@ -0,0 +1,67 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/crc32"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestChecksumRetry(t *testing.T) {
+
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+	defer func(d time.Duration) { checksumTimeout = d }(checksumTimeout)
+	checksumTimeout = 50 * time.Millisecond
+
+	// DN1 never answers, and only Block 0 of each file has a replica on DN2
+	files := map[string][][]byte{"/one": {[]byte("abcd")}, "/two": {[]byte("abcd"), []byte("ef")}}
+	go func() {
+		enc, dec := json.NewEncoder(server), json.NewDecoder(server)
+		for {
+			var p Packet
+			if err := dec.Decode(&p); err != nil {
+				return
+			}
+			r := Packet{SRC: "NN", DST: p.SRC, CMD: ACK, RequestID: p.RequestID}
+			switch p.CMD {
+			case GETHEADERS:
+				r.CMD = GETHEADERS
+				name := p.Headers[0].Filename
+				for i, d := range files[name] {
+					r.Headers = append(r.Headers, BlockHeader{"DN1", name, len(d), i, len(files[name]), 1, "", 0, ""})
+				}
+			case CHECKSUM:
+				h := p.Headers[0]
+				if p.Message == "DN1" && h.BlockNum == 0 {
+					h.DatanodeID = "DN2"
+					r.CMD, r.Headers = CHECKSUM, []BlockHeader{h}
+				} else if p.Message != "" {
+					r.CMD, r.Message = ERROR, "No other replica"
+				} else if h.DatanodeID == "DN1" {
+					continue
+				} else {
+					r.CMD, r.Headers = CHECKSUMACK, p.Headers
+					r.Message = strconv.FormatUint(uint64(crc32.ChecksumIEEE(files[h.Filename][h.BlockNum])), 10)
+				}
+			}
+			enc.Encode(r)
+		}
+	}()
+
+	if _, err := Checksum("/two"); err == nil {
+		t.Fatalf("Checksummed a Block with no replica answering")
+	}
+	sum, err := Checksum("/one")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	local, err := ChecksumReader(bytes.NewReader([]byte("abcd")), 4)
+	if err != nil || sum != local {
+		t.Errorf("Checksum %+v differs from the local %+v %v", sum, local, err)
+	}
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Block data longer than the namenode accepts in one packet is streamed in
+// parts. The Packet carries the first part, with Chunked set to the bytes
+// still to come, and the CHUNK packets following it on the connection carry
+// the rest in order.
+
+// maxBlockSize is the longest Block data accepted in parts, longer streams
+// are skipped
+var maxBlockSize int64 = 1 << 30
+
+// packetOverhead is the room kept in a packet for all but its Block data
+const packetOverhead = 64 << 10
+
+// chunkSize returns the most bytes of Block data to send in a packet to a
+// peer accepting packets of limit bytes, as base64 makes data a third longer
+// in JSON
+func chunkSize(limit int64) int {
+	return int(limit/4*3 - packetOverhead)
+}
+
+// chunked returns encoder streaming long Blocks in parts to the namenode,
+// if it agreed to the chunks feature, no longer than either side accepts
+func chunked(encoder packetEncoder, h Hello) packetEncoder {
+	if !hasFeature(h.Features, "chunks") {
+		return encoder
+	}
+	limit := maxPacketSize
+	if h.MaxPacketSize > 0 && h.MaxPacketSize < limit {
+		limit = h.MaxPacketSize
+	}
+	return newChunkEncoder(encoder, chunkSize(limit))
+}
+
+// chunkEncoder writes Packets, streaming Block data longer than size bytes
+// in parts
+type chunkEncoder struct {
+	lock    sync.Mutex // keeps the parts of a Block together
+	encoder packetEncoder
+	size    int
+}
+
+func newChunkEncoder(encoder packetEncoder, size int) *chunkEncoder {
+	return &chunkEncoder{encoder: encoder, size: size}
+}
+
+// Encode writes a Packet, or a pointer to one, followed by the CHUNK packets
+// of its Block data if it is long
+func (e *chunkEncoder) Encode(v interface{}) error {
+	var p Packet
+	switch v := v.(type) {
+	case Packet:
+		p = v
+	case *Packet:
+		p = *v
+	default:
+		return fmt.Errorf("Cannot encode a %T", v)
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	data := p.Data.Data
+	if len(data) <= e.size {
+		return e.encoder.Encode(p)
+	}
+	p.Data.Data, p.Chunked = data[:e.size], int64(len(data)-e.size)
+	err := e.encoder.Encode(p)
+	for start := e.size; err == nil && start < len(data); start += e.size {
+		end := start + e.size
+		if end > len(data) {
+			end = len(data)
+		}
+		err = e.encoder.Encode(Packet{SRC: p.SRC, DST: p.DST, CMD: CHUNK, RequestID: p.RequestID, Data: Block{Data: data[start:end]}})
+	}
+	return err
+}
+
+// chunkDecoder reads Packets, putting back together the Block data streamed
+// in parts
+type chunkDecoder struct {
+	decoder packetDecoder
+	next    *Packet // packet read in place of a part, returned next
+}
+
+func newChunkDecoder(decoder packetDecoder) *chunkDecoder {
+	return &chunkDecoder{decoder: decoder}
+}
+
+// Decode reads the next Packet into a *Packet, with all of its Block data.
+// Block data longer than maxBlockSize, or missing a part, is skipped with
+// an error satisfying isBadFrame, keeping the rest of a Packet whose data is
+// too long so it can be answered.
+func (d *chunkDecoder) Decode(v interface{}) error {
+	p, ok := v.(*Packet)
+	if !ok {
+		return d.decoder.Decode(v)
+	}
+	if d.next != nil {
+		*p, d.next = *d.next, nil
+		return nil
+	}
+	err := d.decoder.Decode(p)
+	if err != nil {
+		return err
+	}
+	if p.CMD == CHUNK {
+		return fmt.Errorf("%w: part of no Block", errBadFrame)
+	}
+	if p.Chunked <= 0 {
+		return nil
+	}
+
+	size := int64(len(p.Data.Data)) + p.Chunked
+	keep := size <= maxBlockSize
+	data := p.Data.Data
+	for left := p.Chunked; left > 0; {
+		var c Packet
+		err := d.decoder.Decode(&c)
+		if isBadFrame(err) {
+			return fmt.Errorf("%w: part of a Block lost, %s", errBadFrame, err)
+		}
+		if err != nil {
+			return err
+		}
+		if c.CMD != CHUNK || c.RequestID != p.RequestID || int64(len(c.Data.Data)) > left {
+			d.next = &c
+			return fmt.Errorf("%w: %d bytes of a Block missing", errBadFrame, left)
+		}
+		left -= int64(len(c.Data.Data))
+		if keep {
+			data = append(data, c.Data.Data...)
+		}
+	}
+	p.Chunked = 0
+	if !keep {
+		p.Data.Data = nil
+		return &tooLong{size: size, limit: maxBlockSize, block: true, skipped: true}
+	}
+	p.Data.Data = data
+	return nil
+}
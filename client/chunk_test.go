@@ -0,0 +1,34 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestChunks(t *testing.T) {
+
+	maxPacketSize = 1 << 20
+	defer func() { maxPacketSize = 1 << 28 }()
+	var buf bytes.Buffer
+	if _, ok := chunked(json.NewEncoder(&buf), Hello{}).(*chunkEncoder); ok {
+		t.Errorf("Blocks sent in parts to a namenode without the feature")
+	}
+	e, ok := chunked(json.NewEncoder(&buf), Hello{Features: []string{"chunks"}}).(*chunkEncoder)
+	if !ok || e.size != chunkSize(1<<20) {
+		t.Fatalf("Unexpected part size %v", e)
+	}
+
+	// a Block longer than a packet is sent in parts, each of which fits
+	data := bytes.Repeat([]byte("0123456789"), 200000)
+	e.Encode(Packet{SRC: "C", DST: "NN", CMD: DISTRIBUTE, RequestID: 4, Data: Block{BlockHeader{"", "/f", len(data), 0, 1, 0, "", 0, ""}, data}})
+	e.Encode(Packet{SRC: "C", DST: "NN", CMD: STAT})
+	d := newChunkDecoder(newJSONDecoder(&buf))
+	var p Packet
+	if err := d.Decode(&p); err != nil || p.CMD != DISTRIBUTE || p.Data.Header.Filename != "/f" || !bytes.Equal(p.Data.Data, data) {
+		t.Errorf("Block not put back together %v %v", p.Data.Header, err)
+	}
+	if err := d.Decode(&p); err != nil || p.CMD != STAT {
+		t.Errorf("Packet after a Block not read %v %s", p, err)
+	}
+}
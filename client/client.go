@@ -10,39 +10,127 @@ import (
 
 	"errors"
 	"fmt"
+	"github.com/sjarvie/godfs/quicconn"
 	"io"
 	"log"
 	"net"
 	"os"
+	osuser "os/user"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Config Options
 var serverhost string                // serverhost
 var serverport string                // serverport
 var SIZEOFBLOCK int                  //size of block in bytes
+var blockSizeSet bool                // sizeofblock was configured, rather than taken from the namenode
 var id string                        // the namenode id
+var holder string                    // names this client as the holder of write leases
+var user string                      // user requests are made as, recorded by the namenode's audit log
 var state = HB                       // internal statemachine
 var sendChannel chan Packet          // for outbound Packets
 var receiveChannel chan Packet       // for in bound Packets
-var sendMap map[string]*json.Encoder // maps DatanodeIDs to their connections
+var sendMap map[string]packetEncoder // maps DatanodeIDs to their connections
 var sendMapLock sync.Mutex
 
-var encoder *json.Encoder
-var decoder *json.Decoder
+var conn net.Conn // connection to the namenode
+var encoder packetEncoder
+var decoder packetDecoder
 
-// commands for node communication
+// commands for node communication, sent by number, so new commands are
+// only added at the end
 const (
-	HB            = iota // heartbeat
-	LIST          = iota // list directorys
-	ACK           = iota // acknowledgement
-	BLOCK         = iota // handle the incoming Block
-	BLOCKACK      = iota // notifcation that Block was written to disc
-	RETRIEVEBLOCK = iota // request to retrieve a Block
-	DISTRIBUTE    = iota // request to distribute a Block to a datanode
-	GETHEADERS    = iota // request to retrieve the headers of a given filename
-	ERROR         = iota // notification of a failed request
+	HB                = iota // heartbeat
+	LIST              = iota // list directorys
+	ACK               = iota // acknowledgement
+	BLOCK             = iota // handle the incoming Block
+	BLOCKACK          = iota // notifcation that Block was written to disc
+	RETRIEVEBLOCK     = iota // request to retrieve a Block
+	DISTRIBUTE        = iota // request to distribute a Block to a datanode
+	GETHEADERS        = iota // request to retrieve the headers of a given filename
+	ERROR             = iota // notification of a failed request
+	INVALIDATE        = iota // request to delete the listed Blocks from a datanode
+	INVALIDATEACK     = iota // notification that invalidated Blocks were deleted
+	DELETE            = iota // request to delete a file
+	BLOCKREPORT       = iota // incremental report of Blocks added and removed on a datanode
+	STAT              = iota // request the status of a file or directory
+	LISTDIR           = iota // request the status of the entries of a directory
+	MKDIR             = iota // request to create a directory
+	SETQUOTA          = iota // request to set the quotas of a directory
+	GETQUOTA          = iota // request the quotas and usage of a directory
+	RENAME            = iota // request to move a file or directory, or the listed Blocks of a datanode
+	RENAMEACK         = iota // notification that renamed Blocks were moved
+	CREATESNAPSHOT    = iota // request to capture a directory as a named snapshot
+	DELETESNAPSHOT    = iota // request to delete a snapshot of a directory
+	LISTSNAPSHOT      = iota // request the snapshots of a directory
+	DECOMMISSION      = iota // request to drain a datanode and remove it from the cluster
+	BALANCE           = iota // request to move Blocks until datanodes store similar amounts
+	LEASE             = iota // request the lease on a file before writing it
+	RELEASE           = iota // notification that a file is written and its lease may be given up
+	ERASURECODE       = iota // request to store a file being written with an erasure coding policy
+	CORRUPTBLOCK      = iota // notification that the listed Blocks of a datanode failed verification
+	HELLO             = iota // handshake describing a node, the first packet of a connection
+	CREATEZONE        = iota // request to make an empty directory an encryption zone
+	LISTZONES         = iota // request the encryption zones and their keys
+	FILEKEY           = iota // request to store the wrapped data key of a file being written in an encryption zone
+	REPORT            = iota // request a report on the datanodes and the namespace
+	SAFEMODE          = iota // request to enter, leave or report the read-only safe mode
+	REFRESHNODES      = iota // request to reload the host lists and the topology of the datanodes
+	SETBLOCKSIZE      = iota // request to change the default size of new Blocks
+	LISTLEASES        = iota // request the leases on files being written
+	TRIGGERREPORT     = iota // request a full block report from a datanode, or from all of them
+	SETREP            = iota // request to change the replication factor of a file or of the files below a directory
+	REPLICATE         = iota // request to copy a Block directly to another datanode
+	REPLICATEACK      = iota // notification that a datanode copied a Block to another, or could not
+	TRUNCATE          = iota // request to shorten a file to a length
+	GLOB              = iota // request the status of the files and directories matching a pattern
+	SETTIMES          = iota // request to set the modification and access times of a file or directory
+	SETXATTR          = iota // request to set an extended attribute of a file or directory
+	GETXATTR          = iota // request the value of an extended attribute
+	LISTXATTRS        = iota // request the names of the extended attributes of a file or directory
+	REMOVEXATTR       = iota // request to remove an extended attribute
+	CREATESYMLINK     = iota // request to create a symbolic link to a path
+	READLINK          = iota // request the target of a symbolic link
+	SUBSCRIBE         = iota // request the events of the namespace below a path
+	UNSUBSCRIBE       = iota // request to end a subscription to events
+	EVENT             = iota // a change to the namespace, sent to a subscription
+	HASTATE           = iota // request to make a namenode the active or the standby, or report which it is
+	REGISTER          = iota // request the ID a datanode is known by, following its HELLO
+	SHUTDOWN          = iota // request to stop a datanode, sent with the answer to its heartbeat
+	SETBANDWIDTH      = iota // request to cap the bytes per second a datanode sends copying replicas to other datanodes
+	BATCH             = iota // request to perform many namespace requests at once, answered with the answer to each
+	ARCHIVE           = iota // request to index the files packed into an archive file, which are then read through it
+	CACHE             = iota // request to keep the Blocks of the files at or below a path in the memory of their datanodes
+	UNCACHE           = iota // request to stop keeping the Blocks of a path given to CACHE in memory
+	LISTCACHE         = iota // request the paths whose Blocks are kept in memory
+	STORAGEPOLICY     = iota // request to set the storage policy of a file or directory, or to clear it
+	MIGRATE           = iota // request a datanode to move Blocks to its volumes of another storage type
+	SNAPSHOTDIFF      = iota // request the paths changed below a directory between two of its snapshots
+	TENANTS           = iota // request the use each tenant makes of the namenode, or to reload the tenants
+	CHUNK             = iota // part of the Block data of the packet before it
+	GETREPORT         = iota // request the datanodes and totals of the cluster as JSON
+	RECOVERLEASE      = iota // request to take the lease on a file from its writer at once
+	GETBLOCKLOCATIONS = iota // request the replicas of the Blocks of a file within the byte range of Offset and Limit
+	FLUSH             = iota // request to make the Blocks of a file being written durable on a number of replicas, answered with its visible length
+	SYNC              = iota // request a datanode to force the listed Blocks to stable storage
+	SYNCACK           = iota // notification that the listed Blocks are on stable storage
+	RESUME            = iota // request to write a file resumably, or to resume its write, answered with the Blocks it is missing
+	CHECKSUM          = iota // request for the CRC-32 of a Block's data, answered by its datanode
+	CHECKSUMACK       = iota // answer to a CHECKSUM with the CRC-32 of the Block's uncompressed data
+)
+
+// flags modifying commands
+const (
+	RECURSIVE = 1 << iota // DELETE and LISTDIR directory contents
+	PARENTS               // MKDIR creates missing parent directories
+	SKIPTRASH             // DELETE removes immediately rather than moving to the trash
+	CAPACITY              // HB reports the free storage of a datanode in Capacity
+	FSYNC                 // DISTRIBUTE and BLOCK have the datanode force the Block to stable storage before its BLOCKACK, which has it set
+	CREATE                // LEASE may create the file if it does not exist
+	OVERWRITE             // LEASE empties an existing file, so the Blocks written replace its own
+	EXCLUSIVE             // LEASE fails if the file exists
 )
 
 // The XML parsing structures for configuration options
@@ -69,16 +157,70 @@ type BlockHeader struct {
 	Size       int    // size of Block in bytes
 	BlockNum   int    // the 0 indexed position of Block within file
 	NumBlocks  int    // total number of Blocks in file
+	GenStamp   int64  // generation of the Block, assigned by the namenode when it is written
+	Codec      string // compression of the Block's data, empty if it is not compressed
+	BlockID    int64  // unique ID of the Block given by the namenode, 0 for Blocks written before IDs
+	Nonce      string // base64 nonce the client sealed the Block's data with, empty if it is not encrypted
 }
 
 // Packets are sent over the network
 type Packet struct {
-	SRC     string        // source ID
-	DST     string        // destination ID
-	CMD     int           // command for the handler
-	Message string        // optional packet contents explanation
-	Data    Block         // optional Block
-	Headers []BlockHeader // optional BlockHeader list
+	SRC       string          // source ID
+	DST       string          // destination ID
+	CMD       int             // command for the handler
+	Message   string          // optional packet contents explanation
+	Data      Block           // optional Block
+	Headers   []BlockHeader   // optional BlockHeader list
+	Removed   []BlockHeader   // optional BlockHeader list of deleted Blocks
+	Renamed   []BlockHeader   // optional new BlockHeaders of the renamed Blocks in Headers
+	ReportID  int64           // identifies a block report and its acknowledgement
+	RequestID int64           // identifies a request, echoed in its response
+	Flags     int             // optional command flags
+	Status    []FileStatus    // optional file and directory descriptions
+	Address   string          // optional HTTP address a datanode serves on
+	Capacity  int64           // optional free bytes of a datanode, with CAPACITY set
+	Hello     *Hello          // optional description of a node, with HELLO
+	User      string          // user a client request is made as, for the audit log
+	Key       string          // optional idempotency key of a mutating client request, the same in its retries
+	Commands  []Packet        // optional commands for a datanode, with the answer to its heartbeat, or the requests of a BATCH and their answers
+	Offset    int             // optional first item of a paged answer, such as the block number of the first header, or the first byte of GETBLOCKLOCATIONS
+	Limit     int             // optional most items of a paged answer, or bytes of GETBLOCKLOCATIONS, 0 for all
+	Cache     *CacheStats     // optional use of a datanode's block cache, with its heartbeat
+	Storage   string          // storage type a Block is written or moved to, such as SSD
+	Storages  []string        // storage type of each of the Headers of a block report, or of a datanode's volumes with its heartbeat
+	Chunked   int64           // optional bytes of the Block data sent after it in CHUNK packets
+	Code      string          // machine-readable kind of an ERROR, such as FILE_NOT_FOUND
+	Locations []BlockLocation // the Blocks of a byte range and their replicas, answering GETBLOCKLOCATIONS
+}
+
+// FileStatus describes a file or directory in the namespace
+type FileStatus struct {
+	Path        string // absolute path
+	IsDir       bool   // true for directories
+	Size        int64  // total size of a file in bytes
+	NumBlocks   int    // number of Blocks in a file
+	Replication int    // fewest replicas of any Block in a file
+	Children    int    // number of entries in a directory
+	FileQuota   int    // maximum number of files below a directory, 0 for none
+	SpaceQuota  int64  // maximum bytes of file data below a directory, 0 for none
+	FileCount   int    // number of files below a directory, when its usage was requested
+	SpaceUsed   int64  // bytes of file data below a directory, when its usage was requested
+	Erasure     string // erasure coding policy of a file, such as RS-6-3, empty for replicated files
+	Zone        string // zone key of an encryption zone or of the files within it, empty outside zones
+	Key         []byte // data key of an encrypted file, wrapped with its zone key
+	BlockSize   int    // size of the Blocks of a file
+	Replicas    int    // replicas wanted of each Block of a file
+	ModTime     int64  // last change, in milliseconds since the epoch
+	AccessTime  int64  // last read of a file, in milliseconds since the epoch
+	Symlink     string // target of a symbolic link, empty for files and directories
+	Archive     string // archive holding a packed file, or the path of an archive itself
+	Offset      int64  // offset of a packed file within its archive
+	Storage     string // storage policy of a file or directory, set on it or inherited, empty for none
+	Change      string // change to a path in a snapshot diff: + created, - deleted, M modified or R renamed
+	Source      string // path a renamed path had before, in a snapshot diff
+	Writing     bool   // file still being written, whose Size is the bytes its writer flushed, in answers to GETHEADERS
+
+	XAttrs map[string][]byte // extended attributes, in requests and answers about them
 }
 
 // Error formatting stucture
@@ -96,7 +238,7 @@ func New(text string) error {
 }
 
 // SendPackets encodes packets and transmits them to their proper recipients
-func SendPackets(encoder *json.Encoder, ch chan Packet) {
+func SendPackets(encoder packetEncoder, ch chan Packet) {
 	for p := range ch {
 		err := encoder.Encode(p)
 		if err != nil {
@@ -112,8 +254,9 @@ func SendHeartbeat() {
 	p.SRC = id
 	p.DST = "NN"
 	p.CMD = HB
+	p.Message = holder // renews the client's leases
 
-	encoder.Encode(*p)
+	sendPacket(*p)
 }
 
 // BlocksHeadersFromFile generates Blockheaders without datanodeID assignments
@@ -154,7 +297,7 @@ func BlockHeadersFromFile(localname, remotename string) []BlockHeader {
 
 		}
 
-		h := BlockHeader{"", remotename, n, blocknum, numblocks}
+		h := BlockHeader{"", remotename, n, blocknum, numblocks, 0, "", 0, ""}
 
 		// load balance via roundrobin
 		blocknum++
@@ -194,21 +337,82 @@ func DistributeBlocksFromFile(localname, remotename string) error {
 	if err != nil {
 		return err
 	}
-	r := bufio.NewReader(fi)
+	defer fi.Close()
+
+	return DistributeBlocksFromReader(bufio.NewReader(fi), info.Size(), remotename)
+}
+
+// DistributeBlocksFromReader splits size bytes read from r into Blocks and
+// distributes them as the file remotename
+func DistributeBlocksFromReader(r io.Reader, size int64, remotename string) error {
+	return DistributeWithLayoutFromReader(r, size, remotename, 0, 0)
+}
+
+// DistributeWithLayout stores a local file in Blocks of blockSize bytes,
+// each kept on replicas datanodes, rather than with the defaults where they
+// are not 0
+func DistributeWithLayout(localname, remotename string, blockSize, replicas int) error {
+	return DistributeWithFlags(localname, remotename, blockSize, replicas, CREATE|OVERWRITE)
+}
+
+// DistributeWithFlags stores a local file as DistributeWithLayout does,
+// creating the remote file as flags allow: CREATE|OVERWRITE replaces an
+// existing file, and CREATE|EXCLUSIVE fails with ErrFileExists if there is
+// one
+func DistributeWithFlags(localname, remotename string, blockSize, replicas, flags int) error {
+	info, err := os.Lstat(localname)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Open(localname)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+
+	return distributeFromReader(bufio.NewReader(fi), info.Size(), remotename, blockSize, replicas, flags)
+}
+
+// DistributeWithLayoutFromReader splits size bytes read from r into Blocks
+// of blockSize bytes, each kept on replicas datanodes, and distributes them
+// as the file remotename, replacing any file there. The defaults are used
+// where they are 0.
+func DistributeWithLayoutFromReader(r io.Reader, size int64, remotename string, blockSize, replicas int) error {
+	return distributeFromReader(r, size, remotename, blockSize, replicas, CREATE|OVERWRITE)
+}
+
+// distributeFromReader distributes the file remotename as
+// DistributeWithLayoutFromReader does, creating it as the create flags allow
+func distributeFromReader(r io.Reader, size int64, remotename string, blockSize, replicas, flags int) error {
+
+	if strings.Index(remotename, "/") != 0 {
+		remotename = "/" + remotename
+	}
+	err := acquireLease(remotename, flags, FileStatus{Path: remotename, BlockSize: blockSize, Replicas: replicas})
+	if err != nil {
+		return err
+	}
+	defer releaseLease(remotename)
+	if blockSize == 0 {
+		blockSize = SIZEOFBLOCK
+	}
 
 	// Create Blocks
-	total := int((info.Size() / int64(SIZEOFBLOCK)) + 1)
+	total := int((size / int64(blockSize)) + 1)
 
 	num := 0
+	pl := newPipeline()
+	pl.progress = newTransfer(remotename, true, size, int((size+int64(blockSize)-1)/int64(blockSize)))
 
 	for num < total {
 
 		// read a chunk from file into Block data buffer
-		buf := make([]byte, SIZEOFBLOCK)
+		buf := make([]byte, blockSize)
 		w := bytes.NewBuffer(nil)
 
-		n, err := r.Read(buf)
-		if err != nil && err != io.EOF {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			pl.wait()
 			return err
 		}
 		if n == 0 {
@@ -216,49 +420,61 @@ func DistributeBlocksFromFile(localname, remotename string) error {
 		}
 
 		if _, err := w.Write(buf[:n]); err != nil {
+			pl.wait()
 			return err
 		}
 
-		// write full Block to disc
-		if strings.Index(remotename, "/") != 0 {
-			remotename = "/" + remotename
-		}
-
-		h := BlockHeader{"", remotename, n, num, total}
+		h := BlockHeader{"", remotename, n, num, total, 0, "", 0, ""}
 
 		data := make([]byte, 0, n)
 		data = w.Bytes()[0:n]
 		b := Block{h, data}
 
-		err = DistributeBlock(b)
+		err = pl.distribute(b)
 		if err != nil {
+			pl.wait()
 			return err
 		}
 
-		fmt.Printf(".")
 		// generate new Block
 		num += 1
 
 	}
-	if err := fi.Close(); err != nil {
+	err = pl.wait()
+	if err != nil {
 		return err
 	}
 
-	fmt.Printf(" Done! \n")
+	pl.progress.done()
 	return nil
 }
 
 func DistributeBlock(b Block) error {
+	return distributeBlock(b, 0)
+}
+
+// distributeBlock distributes b with the DISTRIBUTE flags, such as FSYNC
+func distributeBlock(b Block, flags int) error {
 
 	p := new(Packet)
 	p.SRC = id
 	p.DST = "NN"
 	p.CMD = DISTRIBUTE
-	p.Data = b
-	encoder.Encode(*p)
-
-	var r Packet
-	decoder.Decode(&r)
+	p.Message = holder
+	p.Flags = flags
+	// compressed before encrypting, as encrypted data does not compress
+	data, err := sealBlock(compressBlock(b))
+	if err != nil {
+		return err
+	}
+	p.Data = data
+	r, err := roundTrip(*p)
+	if err != nil {
+		return err
+	}
+	if r.CMD == ERROR {
+		return responseError(r)
+	}
 	if r.CMD != ACK {
 		return errors.New("Could not distribute block to namenode")
 	}
@@ -268,113 +484,277 @@ func DistributeBlock(b Block) error {
 func DistributeBlocks(blocks []Block) error {
 
 	fmt.Println("Distributing file blocks")
+	if len(blocks) > 0 {
+		err := acquireLease(blocks[0].Header.Filename, CREATE|OVERWRITE, FileStatus{})
+		if err != nil {
+			return err
+		}
+		defer releaseLease(blocks[0].Header.Filename)
+	}
+	size := int64(0)
+	for _, b := range blocks {
+		size += int64(len(b.Data))
+	}
+	pl := newPipeline()
+	if len(blocks) > 0 {
+		pl.progress = newTransfer(blocks[0].Header.Filename, true, size, len(blocks))
+	}
 	for _, b := range blocks {
 
-		err := DistributeBlock(b)
+		err := pl.distribute(b)
 		if err != nil {
+			pl.wait()
 			return errors.New("Distrubution Error: " + err.Error())
 		}
-		fmt.Printf(".")
 	}
-	fmt.Printf(" Done! \n")
+	err := pl.wait()
+	if err != nil {
+		return errors.New("Distrubution Error: " + err.Error())
+	}
+	pl.progress.done()
 
 	return nil
 }
 
 // RetrieveFile queries the filesystem for the File located at remotename,
 // and saves its contents to the file localname
-func RetrieveFile(localname, remotename string) {
-	// TODO make this handle errors gracefully
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Recovered from panic ", r)
-			fmt.Println("Unable to retrieve file")
-			return
-		}
-	}()
-	// send header request
-	p := new(Packet)
-	p.DST = "NN"
-	p.SRC = id
-	p.CMD = GETHEADERS
-	p.Headers = make([]BlockHeader, 1, 1)
-	p.Headers[0] = BlockHeader{"", remotename, 0, 0, 0}
-	encoder.Encode(*p)
-
-	// get header list
-	var r Packet
-	decoder.Decode(&r)
-
-	if r.CMD == ERROR {
-		fmt.Println(r.Message)
-		return
-	}
-
-	if r.CMD != GETHEADERS || r.Headers == nil {
-		if r.CMD == ERROR {
-			fmt.Println(r.Message)
-		} else {
-			fmt.Println("Bad response packet ", r)
-		}
-		return
-	}
+func RetrieveFile(localname, remotename string) error {
 
 	// setup writer
 	outFile, err := os.Create(localname)
 	if err != nil {
-		fmt.Println("error constructing file: ", err)
-		return
+		return fmt.Errorf("error constructing file: %v", err)
 	}
 	defer outFile.Close()
 	w := bufio.NewWriterSize(outFile, SIZEOFBLOCK)
 
-	// for each header, retrieve its block and write to disc
-	headers := r.Headers
+	err = RetrieveToWriter(w, remotename)
+	if err != nil {
+		os.Remove(localname)
+		return err
+	}
+	fmt.Println("Wrote file to disc at ", localname)
+	return nil
+}
 
-	fmt.Println("Received File Headers for ", p.Headers[0].Filename, ". Retrieving ", r.Headers[0].NumBlocks, " Blocks ")
+// RetrieveToWriter queries the filesystem for the File located at remotename,
+// and writes its contents to w
+func RetrieveToWriter(w *bufio.Writer, remotename string) error {
+	r, err := fileHeaderPage(remotename, 0)
+	if err != nil {
+		return err
+	}
 
-	for _, h := range headers {
+	// packed files are read from the Blocks of the archive they span
+	if archived(r) {
+		f, err := openPacked(remotename, r.Status[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		if err != nil {
+			return err
+		}
+		return w.Flush()
+	}
 
-		// send request
-		p := new(Packet)
-		p.DST = "NN"
-		p.SRC = id
-		p.CMD = RETRIEVEBLOCK
-		p.Headers = make([]BlockHeader, 1, 1)
-		p.Headers[0] = h
+	// erasure coded files list the Blocks left, and are rebuilt from them
+	if len(r.Status) == 1 && r.Status[0].Erasure != "" {
+		if r.Status[0].Key != nil {
+			err = openFileKey(r.Status[0], r.Headers)
+			if err != nil {
+				return err
+			}
+			for _, name := range blockNames(r.Headers) {
+				defer releaseKey(name)
+			}
+		}
+		return retrieveErasureCoded(w, r.Status[0], r.Headers)
+	}
+
+	var t *transfer
+	if writing(r) {
+		fmt.Println("Received File Headers for ", remotename, ", still being written. Retrieving ", len(r.Headers), " Blocks flushed")
+		t = newTransfer(remotename, false, r.Status[0].Size, len(r.Headers))
+	} else {
+		fmt.Println("Received File Headers for ", remotename, ". Retrieving ", r.Headers[0].NumBlocks, " Blocks ")
+		// the size is known once every header is
+		size := int64(0)
+		if len(r.Headers) == r.Headers[0].NumBlocks {
+			for _, h := range r.Headers {
+				size += int64(h.Size)
+			}
+		}
+		t = newTransfer(remotename, false, size, r.Headers[0].NumBlocks)
+	}
 
-		encoder.Encode(*p)
+	// the next page of headers is asked for while the Blocks of this one are
+	// retrieved
+	stop := make(chan struct{})
+	defer close(stop)
+	pages := prefetchHeaders(remotename, r, stop)
+	for {
+		// encrypted files are decrypted with their data key as Blocks arrive
+		if len(r.Status) == 1 && r.Status[0].Key != nil {
+			err = openFileKey(r.Status[0], r.Headers)
+			if err != nil {
+				return err
+			}
+			for _, name := range blockNames(r.Headers) {
+				defer releaseKey(name)
+			}
+		}
 
-		// receive block
-		var r Packet
-		decoder.Decode(&r)
+		err = retrieveBlocks(r.Headers, func(b Block) error {
+			n := b.Header.Size
+			// the last Block flushed may since hold more than was flushed
+			if i := b.Header.BlockNum - r.Offset; writing(r) && i >= 0 && i < len(r.Headers) && r.Headers[i].Size < n {
+				n = r.Headers[i].Size
+			}
+			if n < 0 || n > len(b.Data) {
+				return fmt.Errorf("Block %d of %s holds %d bytes, expected %d", b.Header.BlockNum, remotename, len(b.Data), n)
+			}
 
-		if r.CMD != BLOCK {
-			if r.CMD == ERROR {
-				fmt.Println(r.Message)
-			} else {
-				fmt.Println("Bad response packet ", r)
+			_, err := w.Write(b.Data[:n])
+			if err != nil {
+				return err
 			}
-			return
+			t.block(n)
+			return w.Flush()
+		})
+		if err != nil {
+			return err
 		}
-		b := r.Data
-		n := b.Header.Size
 
-		_, err := w.Write(b.Data[:n])
+		page, ok := <-pages
+		if !ok {
+			break
+		}
+		if page.err != nil {
+			return page.err
+		}
+		r = page.r
+	}
+
+	t.done()
+	return nil
+}
+
+// fileHeaders asks the namenode for a header of each Block of the file at
+// remotename, a page at a time, answered with the file's status if it is
+// erasure coded or encrypted
+func fileHeaders(remotename string) (Packet, error) {
+	r, err := fileHeaderPage(remotename, 0)
+	if err != nil || archived(r) || writing(r) {
+		return r, err
+	}
+	for len(r.Headers) < r.Headers[0].NumBlocks && (len(r.Status) == 0 || r.Status[0].Erasure == "") {
+		page, err := fileHeaderPage(remotename, len(r.Headers))
 		if err != nil {
-			panic(err)
+			return Packet{}, err
 		}
-		fmt.Printf(".")
-		w.Flush()
+		r.Headers = append(r.Headers, page.Headers...)
 	}
+	return r, nil
+}
 
-	fmt.Printf(" Done! \n")
-	fmt.Println("Wrote file to disc at ", localname)
+// fileHeaderPage asks the namenode for the headers of up to headerPageSize
+// Blocks of the file at remotename, from block number offset. Erasure coded
+// files and files still being written are answered with all their headers,
+// and packed files with only the archive they are read from.
+func fileHeaderPage(remotename string, offset int) (Packet, error) {
+	p := new(Packet)
+	p.DST = "NN"
+	p.SRC = id
+	p.CMD = GETHEADERS
+	p.Headers = make([]BlockHeader, 1, 1)
+	p.Headers[0] = BlockHeader{"", remotename, 0, 0, 0, 0, "", 0, ""}
+	p.Offset = offset
+	p.Limit = headerPageSize
+
+	r, err := roundTrip(*p)
+	if err != nil {
+		return Packet{}, err
+	}
+	if r.CMD == ERROR {
+		return Packet{}, responseError(r)
+	}
+	if r.CMD != GETHEADERS || (len(r.Headers) == 0 && !archived(r) && !writing(r)) {
+		return Packet{}, fmt.Errorf("Bad response packet %v", r)
+	}
+	return r, nil
+}
+
+// archived reports whether an answer to GETHEADERS is that of a file packed
+// into an archive
+func archived(r Packet) bool {
+	return len(r.Status) == 1 && r.Status[0].Archive != ""
+}
+
+// writing reports whether an answer to GETHEADERS is that of a file still
+// being written, holding the headers of the Blocks its writer flushed
+func writing(r Packet) bool {
+	return len(r.Status) == 1 && r.Status[0].Writing
+}
+
+// retrieveBlock retrieves the Block described by h through the namenode,
+// hedged by a request for another replica if it is slow to arrive
+func retrieveBlock(h BlockHeader) (Block, error) {
+	// send request
+	p := new(Packet)
+	p.DST = "NN"
+	p.SRC = id
+	p.CMD = RETRIEVEBLOCK
+	p.Headers = make([]BlockHeader, 1, 1)
+	p.Headers[0] = h
+
+	// receive block
+	var r Packet
+	var err error
+	if hedgeThreshold > 0 {
+		r, err = hedgedRoundTrip(*p)
+	} else {
+		r, err = roundTrip(*p)
+	}
+	if err != nil {
+		return Block{}, err
+	}
+	if r.CMD != BLOCK {
+		if r.CMD == ERROR {
+			return Block{}, responseError(r)
+		}
+		return Block{}, fmt.Errorf("Bad response packet %v", r)
+	}
+	if r.Data.Header.Filename != h.Filename || r.Data.Header.BlockNum != h.BlockNum {
+		return Block{}, fmt.Errorf("Block not found on datanode %v", h)
+	}
+	r.Data, err = openBlock(r.Data)
+	if err != nil {
+		return Block{}, err
+	}
+	if r.Data.Header.Codec != "" {
+		data, err := decompress(r.Data.Header.Codec, r.Data.Data)
+		if err != nil {
+			return Block{}, err
+		}
+		if len(data) != r.Data.Header.Size {
+			return Block{}, fmt.Errorf("Block decompressed to %d bytes, expected %d", len(data), r.Data.Header.Size)
+		}
+		r.Data.Data = data
+		r.Data.Header.Codec = ""
+	}
+	return r.Data, nil
+}
+
+// DeleteFile removes the File located at remotename from the filesystem
+func DeleteFile(remotename string) error {
+	return Delete(remotename, false, false)
 }
 
 // ReceiveInput provides user interaction and file placement/retrieval from remote filesystem
 func ReceiveInput() {
-	fmt.Printf("Valid Commands: \n \t put [localinput] [remoteoutput] \n \t get [remoteinput] [localoutput] \n \t list\n ")
+	fmt.Printf("Valid Commands: \n \t put [localinput] [remoteoutput] \n \t get [remoteinput] [localoutput] \n \t rm [remotepath] \n \t list\n ")
 	for {
 		fmt.Printf(">>> ")
 		var cmd string
@@ -382,8 +762,8 @@ func ReceiveInput() {
 		var file2 string
 		fmt.Scan(&cmd)
 
-		if !(cmd == "put" || cmd == "get" || cmd == "list") {
-			fmt.Printf("Incorrect command\n Valid Commands: \n \t put [localinput] [remoteoutput] \n \t get [remoteinput] [localoutput] \n \t list")
+		if !(cmd == "put" || cmd == "get" || cmd == "rm" || cmd == "list") {
+			fmt.Printf("Incorrect command\n Valid Commands: \n \t put [localinput] [remoteoutput] \n \t get [remoteinput] [localoutput] \n \t rm [remotepath] \n \t list")
 			continue
 		}
 
@@ -412,7 +792,20 @@ func ReceiveInput() {
 			remotename := file1
 			localname := file2
 			fmt.Println("Retrieving file")
-			RetrieveFile(localname, remotename)
+			err := RetrieveFile(localname, remotename)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+		case "rm":
+			fmt.Scan(&file1)
+			err := DeleteFile(file1)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Println("Deleted ", file1)
 
 		case "list":
 			fmt.Println("Retrieving List")
@@ -424,25 +817,19 @@ func ReceiveInput() {
 
 // RetrieveList gets a file listing from the namenode
 func RetrieveList() {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Recovered from panic ", r)
-			fmt.Println("Unable to retrieve file")
-			return
-		}
-	}()
 
 	// send header request
 	p := new(Packet)
 	p.DST = "NN"
 	p.SRC = id
 	p.CMD = LIST
-	encoder.Encode(*p)
 
 	// get header list
-	var r Packet
-	decoder.Decode(&r)
-
+	r, err := roundTrip(*p)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	if r.CMD == ERROR {
 		fmt.Println(r.Message)
 		return
@@ -481,6 +868,157 @@ func ParseConfigXML(configpath string) error {
 			serverhost = o.Value
 		case "serverport":
 			serverport = o.Value
+		case "namenodes":
+			namenodeAddresses = nil
+			for _, a := range strings.Split(o.Value, ",") {
+				if a = strings.TrimSpace(a); a != "" {
+					namenodeAddresses = append(namenodeAddresses, a)
+				}
+			}
+		case "mount":
+			err := parseMount(o.Value)
+			if err != nil {
+				return err
+			}
+		case "parallelism":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Parallelism must be at least 1")
+			}
+			parallelism = n
+		case "headerpagesize":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Header page size must be at least 1")
+			}
+			headerPageSize = n
+		case "wireformat":
+			if o.Value != "binary" && o.Value != "json" {
+				return errors.New("Wire format must be binary or json")
+			}
+			wireFormat = o.Value
+		case "codec":
+			if codecVersion(o.Value) == 0 {
+				return errors.New("Codec must be json, gob or msgpack")
+			}
+			wireCodec = o.Value
+		case "transport":
+			if o.Value != "tcp" && o.Value != "quic" {
+				return errors.New("Transport must be tcp or quic")
+			}
+			if o.Value == "quic" && !quicconn.Supported {
+				return quicconn.ErrNotBuilt
+			}
+			transport = o.Value
+		case "maxpacketsize":
+			n, err := strconv.ParseInt(o.Value, 10, 64)
+			if err != nil {
+				return err
+			}
+
+			if n < 1<<20 {
+				return errors.New("Longest packet must be at least 1048576 bytes")
+			}
+			maxPacketSize = n
+		case "maxblocksize":
+			n, err := strconv.ParseInt(o.Value, 10, 64)
+			if err != nil {
+				return err
+			}
+
+			if n < 4096 {
+				return errors.New("Longest Block must be at least 4096 bytes")
+			}
+			maxBlockSize = n
+		case "user":
+			user = o.Value
+		case "keystore":
+			SetKeyProvider(NewKeyStore(o.Value))
+		case "compression":
+			switch o.Value {
+			case "none":
+				compression = ""
+			case codecGzip, codecSnappy:
+				compression = o.Value
+			default:
+				return errors.New("Compression must be none, gzip or snappy")
+			}
+		case "maxattempts":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Max attempts must be at least 1")
+			}
+			maxAttempts = n
+		case "retrybackoff":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Retry backoff must be at least 1 millisecond")
+			}
+			retryBackoff = time.Duration(n) * time.Millisecond
+		case "hedgethreshold":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Hedge threshold must not be negative")
+			}
+			hedgeThreshold = time.Duration(n) * time.Millisecond
+		case "metadatacachettl":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Metadata cache TTL must not be negative")
+			}
+			metadataCacheTTL = time.Duration(n) * time.Millisecond
+		case "metadatacachesize":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Metadata cache must hold at least 1 path")
+			}
+			metadataCacheSize = n
+		case "maxretrybackoff":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Max retry backoff must be at least 1 millisecond")
+			}
+			maxRetryBackoff = time.Duration(n) * time.Millisecond
+		case "s3endpoint":
+			s3Config.Endpoint = o.Value
+		case "s3region":
+			s3Config.Region = o.Value
+		case "s3accesskey":
+			s3Config.AccessKey = o.Value
+		case "s3secretkey":
+			s3Config.SecretKey = o.Value
 		case "sizeofblock":
 			n, err := strconv.Atoi(o.Value)
 			if err != nil {
@@ -491,6 +1029,7 @@ func ParseConfigXML(configpath string) error {
 				return errors.New("Buffer size must be greater than or equal to 4096 bytes")
 			}
 			SIZEOFBLOCK = n
+			blockSizeSet = true
 		default:
 			return errors.New("Bad ConfigOption received Key : " + o.Key + " Value : " + o.Value)
 		}
@@ -499,18 +1038,68 @@ func ParseConfigXML(configpath string) error {
 	return nil
 }
 
+// Connect opens the connection to the namenode at address host:port
+func Connect(address string) error {
+	id = "C"
+	host, _ := os.Hostname()
+	holder = host + "-" + strconv.Itoa(os.Getpid()) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if user == "" {
+		if u, err := osuser.Current(); err == nil {
+			user = u.Username
+		}
+	}
+	err := dial(address)
+	if err != nil {
+		return err
+	}
+	namenodeAddress = address
+	return nil
+}
+
+// dial connects to the namenode at address, replacing any earlier
+// connection, and hands its responses to the requests waiting for them
+func dial(address string) error {
+	c, err := dialNamenode(address, 0)
+	if err != nil {
+		return err
+	}
+
+	// no request is sent before the handshake is done
+	sendLock.Lock()
+	defer sendLock.Unlock()
+	encoder, decoder = newPacketCodec(c)
+	err = Handshake()
+	if err != nil {
+		c.Close()
+		return err
+	}
+	decodedWith(decoder, namenodeHello.Codec)
+	encoder = chunked(encodedWith(encoder, namenodeHello.Codec), namenodeHello)
+	if conn != nil {
+		conn.Close()
+	}
+	conn = c
+	startDispatch(decoder)
+	return nil
+}
+
+// Address returns the namenode address set by the configuration file, the
+// first of the namenodes if several are listed
+func Address() string {
+	if len(namenodeAddresses) > 0 {
+		return namenodeAddresses[0]
+	}
+	return serverhost + ":" + serverport
+}
+
 // Initializes the client and begins communication
 func Run(configpath string) {
 
 	ParseConfigXML(configpath)
 
-	id = "C"
-	conn, err := net.Dial("tcp", serverhost+":"+serverport)
+	err := Connect(Address())
 	CheckError(err)
 
-	encoder = json.NewEncoder(conn)
-	decoder = json.NewDecoder(conn)
-
 	// Start communication
 	//	SendHeartbeat()
 	ReceiveInput()
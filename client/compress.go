@@ -0,0 +1,235 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// codecs Blocks can be compressed with. zstd has no implementation in the
+// standard library and is not supported.
+const (
+	codecGzip   = "gzip"
+	codecSnappy = "snappy"
+)
+
+var compression = "" // codec Blocks are compressed with before they are distributed, none if empty
+
+// maxDecodedSize bounds the size a compressed Block may claim
+const maxDecodedSize = 1 << 30
+
+// compress returns data compressed with codec
+func compress(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case codecGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(data)
+		if err == nil {
+			err = w.Close()
+		}
+		return buf.Bytes(), err
+	case codecSnappy:
+		return snappyEncode(data), nil
+	}
+	return nil, errors.New("Unknown compression codec " + codec)
+}
+
+// decompress returns data compressed with codec in its original form
+func decompress(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case "":
+		return data, nil
+	case codecGzip:
+		return gunzip(data, maxDecodedSize)
+	case codecSnappy:
+		return snappyDecode(data)
+	}
+	return nil, errors.New("Unknown compression codec " + codec)
+}
+
+// compressBlock compresses the data of b with the configured codec, if the
+// namenode supports compression and the data shrinks
+func compressBlock(b Block) Block {
+	if compression == "" || !namenodeSupports("compression") {
+		return b
+	}
+	data, err := compress(compression, b.Data)
+	if err != nil || len(data) >= len(b.Data) {
+		return b
+	}
+	b.Data = data
+	b.Header.Codec = compression
+	return b
+}
+
+// namenodeSupports reports whether the namenode agreed to use a feature
+func namenodeSupports(feature string) bool {
+	for _, f := range namenodeHello.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// snappyEncode compresses src in the snappy block format: the length of
+// src as a varint, then literals and copies of earlier data
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+
+	// positions of 4 byte sequences by their hash, offset by one so zero is empty
+	var table [1 << 14]int
+	literal := 0 // start of the data not yet emitted
+	i := 0
+	for i+4 <= len(src) {
+		v := binary.LittleEndian.Uint32(src[i:])
+		hash := (v * 0x1e35a7bd) >> (32 - 14)
+		candidate := table[hash] - 1
+		table[hash] = i + 1
+		if candidate < 0 || i-candidate > 65535 || binary.LittleEndian.Uint32(src[candidate:]) != v {
+			i++
+			continue
+		}
+		n := 4
+		for i+n < len(src) && src[candidate+n] == src[i+n] {
+			n++
+		}
+		dst = snappyLiteral(dst, src[literal:i])
+		dst = snappyCopy(dst, i-candidate, n)
+		i += n
+		literal = i
+	}
+	return snappyLiteral(dst, src[literal:])
+}
+
+// snappyLiteral appends a literal element holding lit
+func snappyLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	n := len(lit) - 1
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2)
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// snappyCopy appends copy elements repeating length bytes from offset bytes
+// back, each copying at most 64
+func snappyCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = append(dst, 63<<2|2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		// leave at least 4 bytes for the last element
+		dst = append(dst, 59<<2|2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length <= 11 && offset < 2048 {
+		return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|1, byte(offset))
+	}
+	return append(dst, byte(length-1)<<2|2, byte(offset), byte(offset>>8))
+}
+
+// gunzip decompresses gzip data, refusing data which decompresses to more
+// than limit bytes
+func gunzip(data []byte, limit int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	out, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, errors.New("Gzip data decompresses to more than " + strconv.FormatInt(limit, 10) + " bytes")
+	}
+	return out, nil
+}
+
+var errCorruptSnappy = errors.New("Corrupt snappy data")
+
+// snappyDecode decompresses data in the snappy block format
+func snappyDecode(src []byte) ([]byte, error) {
+	size, k := binary.Uvarint(src)
+	if k <= 0 || size > maxDecodedSize {
+		return nil, errCorruptSnappy
+	}
+	dst := make([]byte, 0, size)
+	s := k
+	for s < len(src) {
+		tag := src[s]
+		var length, offset int
+		switch tag & 3 {
+		case 0:
+			length = int(tag >> 2)
+			s++
+			if length >= 60 {
+				extra := length - 59
+				if s+extra > len(src) {
+					return nil, errCorruptSnappy
+				}
+				length = 0
+				for j := 0; j < extra; j++ {
+					length |= int(src[s+j]) << (8 * uint(j))
+				}
+				s += extra
+			}
+			length++
+			if length <= 0 || length > len(src)-s || len(dst)+length > int(size) {
+				return nil, errCorruptSnappy
+			}
+			dst = append(dst, src[s:s+length]...)
+			s += length
+			continue
+		case 1:
+			if s+2 > len(src) {
+				return nil, errCorruptSnappy
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag>>5)<<8 | int(src[s+1])
+			s += 2
+		case 2:
+			if s+3 > len(src) {
+				return nil, errCorruptSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case 3:
+			if s+5 > len(src) {
+				return nil, errCorruptSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(size) {
+			return nil, errCorruptSnappy
+		}
+		// copies may overlap the bytes they produce
+		for j := 0; j < length; j++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != int(size) {
+		return nil, errCorruptSnappy
+	}
+	return dst, nil
+}
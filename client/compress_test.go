@@ -0,0 +1,91 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+
+	random := make([]byte, 100000)
+	rand.Read(random)
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), 2000)
+	overlap := append([]byte("ab"), bytes.Repeat([]byte{'a'}, 300)...)
+	inputs := [][]byte{{}, []byte("abc"), random, text, overlap, append(text, random...)}
+
+	for _, codec := range []string{codecGzip, codecSnappy} {
+		for _, in := range inputs {
+			c, err := compress(codec, in)
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			out, err := decompress(codec, c)
+			if err != nil || !bytes.Equal(in, out) {
+				t.Errorf("%s round trip of %d bytes failed %s", codec, len(in), err)
+			}
+		}
+		if c, _ := compress(codec, text); len(c) > len(text)/10 {
+			t.Errorf("%s compressed repeated text to %d bytes", codec, len(c))
+		}
+	}
+
+	// gzip data decompressing to more than the limit is refused
+	c, _ := compress(codecGzip, text)
+	if out, err := gunzip(c, int64(len(text))); err != nil || !bytes.Equal(out, text) {
+		t.Errorf("Gzip data of the limit refused %s", err)
+	}
+	if _, err := gunzip(c, int64(len(text)-1)); err == nil {
+		t.Errorf("Gzip data past the limit decompressed")
+	}
+
+	// truncated or garbled input is refused rather than misread
+	c = snappyEncode(text)
+	for _, bad := range [][]byte{c[:len(c)/2], append([]byte{0xff, 0xff, 0xff, 0xff, 0x7f}, c...), {10, 1<<2 | 1, 9}} {
+		if _, err := snappyDecode(bad); err == nil {
+			t.Errorf("Corrupt snappy data %v decoded", bad[:3])
+		}
+	}
+}
+
+func TestCompressedTransfer(t *testing.T) {
+
+	SIZEOFBLOCK = 4096
+	compression = codecSnappy
+	namenodeHello = Hello{Features: []string{"compression"}}
+	defer func() { compression = ""; namenodeHello = Hello{} }()
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+	blocks := make(map[int]Block)
+	go batchingNamenode(server, blocks)
+
+	// a compressible Block and a random one, which is sent as it is
+	data := bytes.Repeat([]byte("0123456789"), 410)[:4096]
+	random := make([]byte, 100)
+	rand.Read(random)
+	data = append(data, random...)
+	err := DistributeBlocksFromReader(bytes.NewReader(data), int64(len(data)), "/mixed.bin")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if b := blocks[0]; b.Header.Codec != codecSnappy || len(b.Data) >= 4096 || b.Header.Size != 4096 {
+		t.Errorf("Expected a compressed Block, got %d bytes with codec %q", len(b.Data), b.Header.Codec)
+	}
+	if b := blocks[1]; b.Header.Codec != "" {
+		t.Errorf("Random Block compressed with %q", b.Header.Codec)
+	}
+
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	err = RetrieveToWriter(w, "/mixed.bin")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("Downloaded file differs from the one uploaded")
+	}
+}
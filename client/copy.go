@@ -0,0 +1,442 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CopyProgress describes how far a copy has got
+type CopyProgress struct {
+	Files      int    // files copied
+	TotalFiles int    // files to copy
+	Bytes      int64  // bytes copied
+	TotalBytes int64  // bytes to copy
+	Path       string // last file copied, relative to the source
+}
+
+// copyEntry is a file or directory below the root of a copy
+type copyEntry struct {
+	rel   string // path relative to the root, starting with a slash, empty for the root itself
+	isDir bool
+	size  int64
+}
+
+// copyStore is one end of a copy: the local filesystem, the cluster the
+// client is connected to, or another cluster reached over WebHDFS
+type copyStore interface {
+	walk(root string) ([]copyEntry, error) // root and everything below it, parents first
+	open(path string) (io.ReadCloser, error)
+	create(path string, r io.Reader, size int64) error
+	mkdir(path string) error
+}
+
+// parseCopyPath finds the store of a path given to Copy: file:///path is
+// local, webhdfs://host:port/path is on another cluster, and any other
+// path is on the cluster the client is connected to
+func parseCopyPath(p string) (copyStore, string, error) {
+	switch {
+	case strings.HasPrefix(p, "file://"):
+		return localStore{}, strings.TrimPrefix(p, "file://"), nil
+	case strings.HasPrefix(p, "webhdfs://"):
+		u, err := url.Parse(p)
+		if err != nil {
+			return nil, "", err
+		}
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		return webhdfsStore{u.Host}, u.Path, nil
+	case strings.HasPrefix(p, "/"):
+		return clusterStore{}, p, nil
+	}
+	return nil, "", errors.New("Invalid path " + p + ", expected /path, file:///path or webhdfs://host:port/path")
+}
+
+// joinCopyPath returns the path of rel below root
+func joinCopyPath(root, rel string) string {
+	if rel == "" {
+		return root
+	}
+	return strings.TrimSuffix(root, "/") + rel
+}
+
+// Copy copies the file or directory src, with everything below it, to dst.
+// Either may be a path on the cluster the client is connected to, a local
+// path written file:///path or a path on another cluster written
+// webhdfs://host:port/path. Up to parallelism files are copied at once,
+// each starting again on failure up to maxattempts times in all. progress,
+// if not nil, is called after each file is copied.
+func Copy(src, dst string, progress func(CopyProgress)) error {
+	from, srcPath, err := parseCopyPath(src)
+	if err != nil {
+		return err
+	}
+	to, dstPath, err := parseCopyPath(dst)
+	if err != nil {
+		return err
+	}
+	entries, err := from.walk(srcPath)
+	if err != nil {
+		return err
+	}
+
+	var files []copyEntry
+	var p CopyProgress
+	for _, e := range entries {
+		if e.isDir {
+			err = to.mkdir(joinCopyPath(dstPath, e.rel))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		files = append(files, e)
+		p.TotalFiles++
+		p.TotalBytes += e.size
+	}
+
+	var mu sync.Mutex // guards p and failed
+	var failed error
+	var wg sync.WaitGroup
+	jobs := make(chan copyEntry)
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				err := copyFile(from, to, joinCopyPath(srcPath, e.rel), joinCopyPath(dstPath, e.rel), e.size)
+				mu.Lock()
+				if err != nil && failed == nil {
+					failed = fmt.Errorf("Could not copy %s: %v", joinCopyPath(src, e.rel), err)
+				}
+				if err == nil {
+					p.Files++
+					p.Bytes += e.size
+					p.Path = e.rel
+					if progress != nil {
+						progress(p)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, e := range files {
+		jobs <- e
+	}
+	close(jobs)
+	wg.Wait()
+	return failed
+}
+
+// copyFile copies a single file, starting again up to maxAttempts times in
+// all while it fails, such as when a datanode is lost during the transfer
+func copyFile(from, to copyStore, src, dst string, size int64) error {
+	var err error
+	for n := 1; n <= maxAttempts; n++ {
+		if n > 1 {
+			log.Println("Copy of ", src, " failed, retrying ", err)
+			time.Sleep(retryBackoff)
+		}
+		var r io.ReadCloser
+		r, err = from.open(src)
+		if err != nil {
+			continue
+		}
+		err = to.create(dst, r, size)
+		r.Close()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// GetMerge retrieves the files in the remote directory dir, in the order of
+// their paths, one after another into the local file localname
+func GetMerge(dir, localname string) error {
+	list, err := ListDir(dir, false)
+	if err != nil {
+		return err
+	}
+	files := make([]string, 0, len(list))
+	for _, st := range list {
+		if !st.IsDir {
+			files = append(files, st.Path)
+		}
+	}
+	sort.Strings(files)
+
+	f, err := os.Create(localname)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, name := range files {
+		err = RetrieveToWriter(w, name)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(localname)
+		return err
+	}
+	return nil
+}
+
+// clusterStore copies to and from the cluster the client is connected to
+type clusterStore struct{}
+
+func (clusterStore) walk(root string) ([]copyEntry, error) {
+	st, err := Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir {
+		return []copyEntry{{size: st.Size}}, nil
+	}
+	list, err := ListDir(root, true)
+	if err != nil {
+		return nil, err
+	}
+	entries := []copyEntry{{isDir: true}}
+	for _, st := range list {
+		if st.Symlink != "" {
+			// links are not copied, as their targets may lie outside root
+			continue
+		}
+		rel := strings.TrimPrefix(st.Path, strings.TrimSuffix(root, "/"))
+		entries = append(entries, copyEntry{rel, st.IsDir, st.Size})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].rel < entries[j].rel })
+	return entries, nil
+}
+
+func (clusterStore) open(path string) (io.ReadCloser, error) {
+	r, w := io.Pipe()
+	go func() {
+		bw := bufio.NewWriterSize(w, SIZEOFBLOCK)
+		err := RetrieveToWriter(bw, path)
+		if err == nil {
+			err = bw.Flush()
+		}
+		w.CloseWithError(err)
+	}()
+	return r, nil
+}
+
+func (clusterStore) create(path string, r io.Reader, size int64) error {
+	return DistributeBlocksFromReader(r, size, path)
+}
+
+func (clusterStore) mkdir(path string) error {
+	return Mkdir(path, true)
+}
+
+// localStore copies to and from the local filesystem
+type localStore struct{}
+
+func (localStore) walk(root string) ([]copyEntry, error) {
+	root = filepath.Clean(root)
+	entries := make([]copyEntry, 0)
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := filepath.ToSlash(strings.TrimPrefix(p, root))
+		if rel != "" && !strings.HasPrefix(rel, "/") {
+			rel = "/" + rel
+		}
+		entries = append(entries, copyEntry{rel, fi.IsDir(), fi.Size()})
+		return nil
+	})
+	return entries, err
+}
+
+func (localStore) open(path string) (io.ReadCloser, error) {
+	return os.Open(filepath.FromSlash(path))
+}
+
+func (localStore) create(path string, r io.Reader, size int64) error {
+	path = filepath.FromSlash(path)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (localStore) mkdir(path string) error {
+	return os.MkdirAll(filepath.FromSlash(path), 0755)
+}
+
+// webhdfsStore copies to and from another cluster through the WebHDFS API
+// of its namenode at addr
+type webhdfsStore struct {
+	addr string
+}
+
+// webhdfsStatus is the part of a WebHDFS FileStatus a copy or a mirror needs
+type webhdfsStatus struct {
+	PathSuffix       string `json:"pathSuffix"`
+	Type             string `json:"type"`
+	Length           int64  `json:"length"`
+	ModificationTime int64  `json:"modificationTime"`
+}
+
+// url returns the WebHDFS URL of op on path
+func (s webhdfsStore) url(path, op string) string {
+	u := url.URL{Scheme: "http", Host: s.addr, Path: "/webhdfs/v1" + path, RawQuery: "op=" + op}
+	return u.String()
+}
+
+// webhdfsError returns the error of an unsuccessful WebHDFS response
+func webhdfsError(resp *http.Response) error {
+	var e struct {
+		RemoteException struct {
+			Message string `json:"message"`
+		}
+	}
+	if json.NewDecoder(resp.Body).Decode(&e) == nil && e.RemoteException.Message != "" {
+		return errors.New(e.RemoteException.Message)
+	}
+	return errors.New("WebHDFS request failed: " + resp.Status)
+}
+
+// get decodes the JSON answer to a WebHDFS GET request into v
+func (s webhdfsStore) get(path, op string, v interface{}) error {
+	resp, err := http.Get(s.url(path, op))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return webhdfsError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (s webhdfsStore) walk(root string) ([]copyEntry, error) {
+	var st struct{ FileStatus webhdfsStatus }
+	err := s.get(root, "GETFILESTATUS", &st)
+	if err != nil {
+		return nil, err
+	}
+	if st.FileStatus.Type != "DIRECTORY" {
+		return []copyEntry{{size: st.FileStatus.Length}}, nil
+	}
+
+	entries := []copyEntry{{isDir: true}}
+	dirs := []string{""}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		var list struct {
+			FileStatuses struct{ FileStatus []webhdfsStatus }
+		}
+		err := s.get(joinCopyPath(root, dir), "LISTSTATUS", &list)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range list.FileStatuses.FileStatus {
+			rel := dir + "/" + c.PathSuffix
+			entries = append(entries, copyEntry{rel, c.Type == "DIRECTORY", c.Length})
+			if c.Type == "DIRECTORY" {
+				dirs = append(dirs, rel)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].rel < entries[j].rel })
+	return entries, nil
+}
+
+func (s webhdfsStore) open(path string) (io.ReadCloser, error) {
+	resp, err := http.Get(s.url(path, "OPEN"))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, webhdfsError(resp)
+	}
+	return resp.Body, nil
+}
+
+// create asks the namenode where to write, and sends the data to the
+// datanode it redirects to
+func (s webhdfsStore) create(path string, r io.Reader, size int64) error {
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	req, err := http.NewRequest("PUT", s.url(path, "CREATE")+"&overwrite=true", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := noRedirect.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		defer resp.Body.Close()
+		return webhdfsError(resp)
+	}
+	resp.Body.Close()
+
+	req, err = http.NewRequest("PUT", resp.Header.Get("Location"), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return webhdfsError(resp)
+	}
+	return nil
+}
+
+func (s webhdfsStore) mkdir(path string) error {
+	req, err := http.NewRequest("PUT", s.url(path, "MKDIRS"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return webhdfsError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWebHDFSCopy(t *testing.T) {
+
+	// a namenode serving /d/a and /d/e/b over WebHDFS, redirecting reads and
+	// writes to itself as a datanode
+	var lock sync.Mutex
+	files := map[string]string{"/d/a": "first", "/d/e/b": "second"}
+	dirs := map[string][]string{"/d": {"a", "e"}, "/d/e": {"b"}}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		p := strings.TrimPrefix(r.URL.Path, "/webhdfs/v1")
+		status := func(p string) map[string]interface{} {
+			if _, ok := dirs[p]; ok {
+				return map[string]interface{}{"pathSuffix": filepath.Base(p), "type": "DIRECTORY"}
+			}
+			return map[string]interface{}{"pathSuffix": filepath.Base(p), "type": "FILE", "length": len(files[p])}
+		}
+		switch q := r.URL.Query(); {
+		case q.Get("datanode") == "1" && r.Method == "GET":
+			w.Write([]byte(files[p]))
+		case q.Get("datanode") == "1":
+			data, _ := ioutil.ReadAll(r.Body)
+			files[p] = string(data)
+			w.WriteHeader(http.StatusCreated)
+		case q.Get("op") == "GETFILESTATUS":
+			json.NewEncoder(w).Encode(map[string]interface{}{"FileStatus": status(p)})
+		case q.Get("op") == "LISTSTATUS":
+			list := make([]map[string]interface{}, 0)
+			for _, c := range dirs[p] {
+				list = append(list, status(p+"/"+c))
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"FileStatuses": map[string]interface{}{"FileStatus": list}})
+		case q.Get("op") == "MKDIRS":
+			dirs[p] = nil
+			w.Write([]byte(`{"boolean":true}`))
+		default:
+			http.Redirect(w, r, server.URL+r.URL.Path+"?datanode=1", http.StatusTemporaryRedirect)
+		}
+	}))
+	defer server.Close()
+	remote := "webhdfs://" + strings.TrimPrefix(server.URL, "http://")
+
+	local := t.TempDir()
+	if err := Copy(remote+"/d", "file://"+local+"/out", nil); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(local, "out", "e", "b")); string(data) != "second" {
+		t.Errorf("Expected the nested file copied, got %q", data)
+	}
+
+	ioutil.WriteFile(filepath.Join(local, "c"), []byte("third"), 0644)
+	if err := Copy("file://"+local+"/c", remote+"/d/c", nil); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if files["/d/c"] != "third" {
+		t.Errorf("Expected the local file written over WebHDFS, got %q", files["/d/c"])
+	}
+}
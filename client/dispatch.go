@@ -0,0 +1,172 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+var sendLock sync.Mutex // serializes writes to the namenode connection
+
+// requests waiting for their response, by RequestID
+var pending = make(map[int64]chan Packet)
+var pendingLock sync.Mutex
+var canceled = make(map[int64]bool) // requests whose responses are no longer waited for
+var lastRequestID int64
+var dispatchErr error // why the dispatcher stopped, once it has
+var dispatchers int   // dispatchers started, the last reading the current connection
+
+// errConnectionLost fails requests which were waiting when the connection
+// to the namenode failed
+var errConnectionLost = errors.New("Connection to the namenode lost")
+
+// sendPacket writes p to the namenode, safely from any goroutine
+func sendPacket(p Packet) error {
+	sendLock.Lock()
+	defer sendLock.Unlock()
+	return encoder.Encode(p)
+}
+
+// attempt sends p to the namenode with a new RequestID and waits for the
+// response carrying it, so requests may be made concurrently over the one
+// connection
+func attempt(p Packet) (Packet, error) {
+	return attemptWatch(p, nil)
+}
+
+// attemptWatch is attempt, also handing the events carrying the RequestID of
+// p to w, if not nil, from before p is sent
+func attemptWatch(p Packet, w *Watcher) (Packet, error) {
+	ch, _, err := startRequest(p, w)
+	if err != nil {
+		return Packet{}, err
+	}
+	r, ok := <-ch
+	if !ok {
+		return Packet{}, errConnectionLost
+	}
+	return r, nil
+}
+
+// startRequest sends p to the namenode with a new RequestID, which it returns with
+// the channel the response arrives on, closed if the connection is lost
+func startRequest(p Packet, w *Watcher) (chan Packet, int64, error) {
+	ch := make(chan Packet, 1)
+	pendingLock.Lock()
+	if dispatchErr != nil {
+		pendingLock.Unlock()
+		return nil, 0, dispatchErr
+	}
+	lastRequestID++
+	p.RequestID = lastRequestID
+	p.User = user
+	pending[p.RequestID] = ch
+	if w != nil {
+		w.id = p.RequestID
+		watchers[w.id] = w
+	}
+	generation := dispatchers
+	pendingLock.Unlock()
+
+	err := sendPacket(p)
+	if err != nil {
+		// the connection is broken for the requests waiting on it too
+		pendingLock.Lock()
+		delete(pending, p.RequestID)
+		if w != nil {
+			w.end(err)
+		}
+		if generation == dispatchers {
+			failPending()
+		}
+		pendingLock.Unlock()
+		return nil, 0, err
+	}
+	return ch, p.RequestID, nil
+}
+
+// cancel stops waiting for the response to the request requestID, which is
+// dropped when it arrives
+func cancel(requestID int64) {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	if _, ok := pending[requestID]; ok {
+		delete(pending, requestID)
+		canceled[requestID] = true
+	}
+}
+
+// startDispatch hands the responses read from decoder to the requests
+// waiting for them, from a new goroutine. Requests still waiting on an
+// earlier connection fail.
+func startDispatch(decoder packetDecoder) {
+	pendingLock.Lock()
+	failPending()
+	dispatchErr = nil
+	dispatchers++
+	generation := dispatchers
+	pendingLock.Unlock()
+	go dispatch(decoder, generation)
+}
+
+// dispatch reads responses from the namenode and hands each to the request
+// waiting for its RequestID. It returns once the connection fails, failing
+// the requests still waiting unless a newer connection replaced it.
+func dispatch(decoder packetDecoder, generation int) {
+	for {
+		var r Packet
+		err := decoder.Decode(&r)
+		if isTooLong(err) && isBadFrame(err) && r.RequestID != 0 {
+			// the request waiting for a response too long fails with it
+			r, err = Packet{SRC: r.SRC, DST: r.DST, CMD: ERROR, Message: err.Error(), RequestID: r.RequestID}, nil
+		}
+		if isBadFrame(err) {
+			log.Println("Skipped frame ", err)
+			continue
+		}
+		if err != nil {
+			pendingLock.Lock()
+			if generation == dispatchers {
+				failPending()
+			}
+			pendingLock.Unlock()
+			return
+		}
+
+		if r.CMD == EVENT {
+			deliver(r)
+			continue
+		}
+
+		pendingLock.Lock()
+		ch, ok := pending[r.RequestID]
+		delete(pending, r.RequestID)
+		dropped := canceled[r.RequestID]
+		delete(canceled, r.RequestID)
+		pendingLock.Unlock()
+		if dropped {
+			continue
+		}
+		if !ok {
+			log.Println("Dropping response to no request ", r.RequestID, r.CMD)
+			continue
+		}
+		ch <- r
+	}
+}
+
+// failPending marks the connection lost and fails the requests waiting on
+// it. The caller must hold pendingLock.
+func failPending() {
+	dispatchErr = errConnectionLost
+	for requestID, ch := range pending {
+		close(ch)
+		delete(pending, requestID)
+	}
+	for requestID := range canceled {
+		delete(canceled, requestID)
+	}
+	for _, w := range watchers {
+		w.end(errConnectionLost)
+	}
+}
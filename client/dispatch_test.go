@@ -0,0 +1,58 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConcurrentRequests(t *testing.T) {
+
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+
+	// the namenode answers three requests in the reverse order, then fails
+	go func() {
+		dec := json.NewDecoder(server)
+		var requests []Packet
+		for len(requests) < 3 {
+			var p Packet
+			if err := dec.Decode(&p); err != nil {
+				return
+			}
+			requests = append(requests, p)
+		}
+		enc := json.NewEncoder(server)
+		for i := len(requests) - 1; i >= 0; i-- {
+			p := requests[i]
+			enc.Encode(Packet{SRC: "NN", DST: p.SRC, CMD: STAT, RequestID: p.RequestID, Status: []FileStatus{{Path: p.Headers[0].Filename}}})
+		}
+		var p Packet
+		dec.Decode(&p)
+		server.Close()
+	}()
+
+	errs := make(chan error)
+	for _, path := range []string{"/a", "/b", "/c"} {
+		go func(path string) {
+			st, err := Stat(path)
+			if err == nil && st.Path != path {
+				t.Errorf("Response to %s given to %s", st.Path, path)
+			}
+			errs <- err
+		}(path)
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("%s", err)
+		}
+	}
+
+	if _, err := Stat("/d"); err != errConnectionLost {
+		t.Errorf("Expected the lost connection to fail the request, got %v", err)
+	}
+	if _, err := Stat("/e"); err != errConnectionLost {
+		t.Errorf("Expected requests on a lost connection to fail, got %v", err)
+	}
+}
@@ -0,0 +1,322 @@
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// KeyProvider is a key management service holding the zone keys of
+// encryption zones. Clients wrap the data key of each file they write in a
+// zone with its zone key, so only clients trusted with the zone key can
+// read the file.
+type KeyProvider interface {
+	CreateKey(name string) error                           // creates the zone key name
+	WrapKey(name string, key []byte) ([]byte, error)       // encrypts a data key with the zone key name
+	UnwrapKey(name string, wrapped []byte) ([]byte, error) // decrypts a data key wrapped with the zone key name
+}
+
+var keyProvider KeyProvider // holds zone keys, set by the keystore option or SetKeyProvider
+
+// SetKeyProvider sets the key management service zone keys are kept in
+func SetKeyProvider(kp KeyProvider) {
+	keyProvider = kp
+}
+
+// dataKeySize is the length of a file's data key, an AES-256 key
+const dataKeySize = 32
+
+// keyStore is a KeyProvider keeping each zone key in a file of its own
+type keyStore struct {
+	dir string
+}
+
+// NewKeyStore returns a KeyProvider keeping zone keys in the directory dir,
+// which must only be readable by the users trusted with them
+func NewKeyStore(dir string) KeyProvider {
+	return &keyStore{dir: dir}
+}
+
+func (ks *keyStore) keyPath(name string) (string, error) {
+	if name == "" || filepath.Base(name) != name || name == "." || name == ".." {
+		return "", errors.New("Invalid key name " + name)
+	}
+	return filepath.Join(ks.dir, name+".key"), nil
+}
+
+func (ks *keyStore) CreateKey(name string) error {
+	p, err := ks.keyPath(name)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return errors.New("Key exists " + name)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(key)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// aead reads the zone key name, sealing data keys with AES-GCM
+func (ks *keyStore) aead(name string) (cipher.AEAD, error) {
+	p, err := ks.keyPath(name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, errors.New("No such key " + name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (ks *keyStore) WrapKey(name string, key []byte) ([]byte, error) {
+	gcm, err := ks.aead(name)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, key, []byte(name)), nil
+}
+
+func (ks *keyStore) UnwrapKey(name string, wrapped []byte) ([]byte, error) {
+	gcm, err := ks.aead(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, errors.New("Invalid wrapped key")
+	}
+	n := gcm.NonceSize()
+	return gcm.Open(nil, wrapped[:n], wrapped[n:], []byte(name))
+}
+
+// heldKey is the data key of a file being written or read
+type heldKey struct {
+	key   []byte
+	users int // transfers using the key
+}
+
+// fileKeys maps the names in Block headers to the data keys of the files
+// being transferred
+var fileKeys = make(map[string]*heldKey)
+var fileKeysLock sync.Mutex
+
+// holdKey keeps key for the Blocks named name until releaseKey
+func holdKey(name string, key []byte) {
+	fileKeysLock.Lock()
+	defer fileKeysLock.Unlock()
+	k, ok := fileKeys[name]
+	if !ok {
+		k = &heldKey{key: key}
+		fileKeys[name] = k
+	}
+	k.users++
+}
+
+func releaseKey(name string) {
+	fileKeysLock.Lock()
+	defer fileKeysLock.Unlock()
+	if k, ok := fileKeys[name]; ok {
+		k.users--
+		if k.users == 0 {
+			delete(fileKeys, name)
+		}
+	}
+}
+
+func heldKeyOf(name string) []byte {
+	fileKeysLock.Lock()
+	defer fileKeysLock.Unlock()
+	if k, ok := fileKeys[name]; ok {
+		return k.key
+	}
+	return nil
+}
+
+// createFileKey gives the file at path, about to be written in the
+// encryption zone using the zone key zone, a new data key
+func createFileKey(path, zone string) error {
+	if keyProvider == nil {
+		return errors.New("No key provider for the encryption zone of " + path + ", set keystore")
+	}
+	key := make([]byte, dataKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return err
+	}
+	wrapped, err := keyProvider.WrapKey(zone, key)
+	if err != nil {
+		return err
+	}
+	p := Packet{SRC: id, DST: "NN", CMD: FILEKEY, Message: holder}
+	p.Headers = []BlockHeader{{Filename: path}}
+	p.Status = []FileStatus{{Path: path, Zone: zone, Key: wrapped}}
+	err = send(p)
+	if err != nil {
+		return err
+	}
+	holdKey(path, key)
+	return nil
+}
+
+// openFileKey unwraps the data key of an encrypted file described by st, and
+// holds it for the Blocks of headers until releaseKey
+func openFileKey(st FileStatus, headers []BlockHeader) error {
+	if keyProvider == nil {
+		return errors.New("No key provider for the encrypted file " + st.Path + ", set keystore")
+	}
+	key, err := keyProvider.UnwrapKey(st.Zone, st.Key)
+	if err != nil {
+		return fmt.Errorf("Could not unwrap the key of %s: %v", st.Path, err)
+	}
+	if len(key) != dataKeySize {
+		return errors.New("Invalid data key for " + st.Path)
+	}
+	for _, name := range blockNames(headers) {
+		holdKey(name, key)
+	}
+	return nil
+}
+
+// blockNames lists the distinct file names in headers, which differ from the
+// path read for snapshots of moved or deleted files
+func blockNames(headers []BlockHeader) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0, 1)
+	for _, h := range headers {
+		if !seen[h.Filename] {
+			seen[h.Filename] = true
+			names = append(names, h.Filename)
+		}
+	}
+	return names
+}
+
+// blockAEAD returns the AES-GCM cipher of the data key held for the file of
+// b, or nil if its file is not encrypted
+func blockAEAD(b Block) (cipher.AEAD, error) {
+	key := heldKeyOf(b.Header.Filename)
+	if key == nil {
+		return nil, nil
+	}
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
+
+// blockNumber is the data each Block's seal authenticates, so that Blocks
+// of a file cannot be swapped. The file name is not, as it changes when the
+// file is renamed.
+func blockNumber(b Block) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(b.Header.BlockNum))
+}
+
+// sealBlock encrypts the data of b with the data key held for its file, if
+// any, with AES-GCM under a new random nonce kept in its header. Each write
+// of a Block is sealed under a nonce of its own, so rewriting a Block does
+// not reuse one. The data is copied, as a sender may still use it.
+func sealBlock(b Block) (Block, error) {
+	gcm, err := blockAEAD(b)
+	if gcm == nil || err != nil {
+		return b, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return b, err
+	}
+	b.Header.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	b.Data = gcm.Seal(nil, nonce, b.Data, blockNumber(b))
+	return b, nil
+}
+
+// openBlock decrypts the data of b sealed by sealBlock, refusing data which
+// was altered or a Block of an encrypted file which was not sealed
+func openBlock(b Block) (Block, error) {
+	gcm, err := blockAEAD(b)
+	if gcm == nil || err != nil {
+		return b, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(b.Header.Nonce)
+	if err != nil || len(nonce) != gcm.NonceSize() {
+		return b, errors.New("Block " + strconv.Itoa(b.Header.BlockNum) + " of the encrypted file " + b.Header.Filename + " has no valid nonce")
+	}
+	data, err := gcm.Open(nil, nonce, b.Data, blockNumber(b))
+	if err != nil {
+		return b, errors.New("Block " + strconv.Itoa(b.Header.BlockNum) + " of " + b.Header.Filename + " failed to decrypt, it was altered or sealed with another key")
+	}
+	b.Data = data
+	b.Header.Nonce = ""
+	return b, nil
+}
+
+// CreateKey creates the zone key name in the configured key provider
+func CreateKey(name string) error {
+	if keyProvider == nil {
+		return errors.New("No key provider configured, set keystore")
+	}
+	return keyProvider.CreateKey(name)
+}
+
+// CreateEncryptionZone makes the empty directory at path an encryption zone,
+// whose files are encrypted with data keys wrapped by the zone key named key
+func CreateEncryptionZone(path, key string) error {
+	if keyProvider != nil {
+		// refuse keys the client could not wrap data keys with
+		_, err := keyProvider.WrapKey(key, make([]byte, dataKeySize))
+		if err != nil {
+			return err
+		}
+	}
+	p := Packet{SRC: id, DST: "NN", CMD: CREATEZONE, Message: key}
+	p.Headers = []BlockHeader{{Filename: path}}
+	return send(p)
+}
+
+// ListEncryptionZones describes the encryption zones, with their key names
+// in Zone
+func ListEncryptionZones() ([]FileStatus, error) {
+	r, err := request(LISTZONES, "/", 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.CMD != LISTZONES {
+		return nil, fmt.Errorf("Bad response packet %v", r)
+	}
+	return r.Status, nil
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestKeyStore(t *testing.T) {
+
+	ks := NewKeyStore(t.TempDir())
+	if ks.CreateKey("../k") == nil {
+		t.Errorf("Key created outside the store")
+	}
+	if err := ks.CreateKey("k1"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if ks.CreateKey("k1") == nil {
+		t.Errorf("Key overwritten")
+	}
+	ks.CreateKey("k2")
+
+	key := []byte("a data key")
+	wrapped, err := ks.WrapKey("k1", key)
+	if err != nil || bytes.Contains(wrapped, key) {
+		t.Fatalf("Key not wrapped %s", err)
+	}
+	if got, err := ks.UnwrapKey("k1", wrapped); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("Expected the data key, got %q %s", got, err)
+	}
+	if _, err := ks.UnwrapKey("k2", wrapped); err == nil {
+		t.Errorf("Key unwrapped with another zone key")
+	}
+	if _, err := ks.WrapKey("missing", key); err == nil {
+		t.Errorf("Key wrapped with a missing zone key")
+	}
+}
+
+// zoneNamenode serves a single encryption zone /secret using the zone key k1
+func zoneNamenode(conn net.Conn, blocks map[int]Block) {
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+	var key FileStatus
+	for {
+		var p Packet
+		if err := dec.Decode(&p); err != nil {
+			return
+		}
+		r := Packet{SRC: "NN", DST: p.SRC, CMD: ACK, RequestID: p.RequestID}
+		switch p.CMD {
+		case LEASE:
+			r.Status = []FileStatus{{Path: "/secret", IsDir: true, Zone: "k1"}}
+		case FILEKEY:
+			key = p.Status[0]
+		case DISTRIBUTE:
+			if key.Key == nil {
+				r.CMD = ERROR
+			}
+			blocks[p.Data.Header.BlockNum] = p.Data
+		case GETHEADERS:
+			r.CMD = GETHEADERS
+			for num := 0; num < len(blocks); num++ {
+				r.Headers = append(r.Headers, blocks[num].Header)
+			}
+			r.Status = []FileStatus{key}
+		case RETRIEVEBLOCK:
+			r.CMD = BLOCK
+			r.Data = blocks[p.Headers[0].BlockNum]
+		}
+		enc.Encode(r)
+	}
+}
+
+func TestEncryptedTransfer(t *testing.T) {
+
+	SIZEOFBLOCK = 4096
+	ks := NewKeyStore(t.TempDir())
+	ks.CreateKey("k1")
+	SetKeyProvider(ks)
+	compression = codecGzip
+	namenodeHello = Hello{Features: []string{"compression"}}
+	defer func() { SetKeyProvider(nil); compression = ""; namenodeHello = Hello{} }()
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+	blocks := make(map[int]Block)
+	go zoneNamenode(server, blocks)
+
+	data := bytes.Repeat([]byte("attack at dawn "), 600)
+	err := DistributeBlocksFromReader(bytes.NewReader(data), int64(len(data)), "/secret/plan.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(blocks) != 3 || len(fileKeys) != 0 {
+		t.Fatalf("Expected 3 Blocks and no keys held, got %d and %d", len(blocks), len(fileKeys))
+	}
+	for _, b := range blocks {
+		if bytes.Contains(b.Data, []byte("attack")) || b.Header.Codec != codecGzip {
+			t.Errorf("Block %d stored in plain or uncompressed", b.Header.BlockNum)
+		}
+	}
+
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	err = RetrieveToWriter(w, "/secret/plan.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("Downloaded file differs from the one uploaded")
+	}
+
+	// each Block is sealed under a nonce of its own, and one altered or
+	// swapped for another is refused
+	nonces := make(map[string]bool)
+	for _, b := range blocks {
+		nonces[b.Header.Nonce] = true
+	}
+	if len(nonces) != 3 || nonces[""] {
+		t.Errorf("Blocks sealed under nonces %v", nonces)
+	}
+	first, second := blocks[0], blocks[1]
+	altered := first
+	altered.Data = append([]byte{first.Data[0] ^ 1}, first.Data[1:]...)
+	swapped := first
+	swapped.Data, swapped.Header.Nonce = second.Data, second.Header.Nonce
+	for _, bad := range []Block{altered, swapped} {
+		blocks[0] = bad
+		if RetrieveToWriter(bufio.NewWriter(&out), "/secret/plan.txt") == nil {
+			t.Errorf("Tampered Block decrypted")
+		}
+	}
+	blocks[0] = first
+
+	// without the zone key the file can be neither written nor read
+	SetKeyProvider(NewKeyStore(t.TempDir()))
+	if DistributeBlocksFromReader(bytes.NewReader(data), int64(len(data)), "/secret/other.txt") == nil {
+		t.Errorf("File written without the zone key")
+	}
+	if RetrieveToWriter(bufio.NewWriter(&out), "/secret/plan.txt") == nil {
+		t.Errorf("File read without the zone key")
+	}
+}
@@ -0,0 +1,385 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// erasurePolicy is a Reed-Solomon code which stores each stripe of up to
+// Data consecutive Blocks of a file with Parity parity Blocks, any Data of
+// which rebuild the stripe. The data Blocks of a file come first, followed
+// by the parity Blocks of each stripe in turn.
+type erasurePolicy struct {
+	Data   int
+	Parity int
+}
+
+// parsePolicy parses a policy name of the form RS-<data>-<parity>
+func parsePolicy(name string) (erasurePolicy, error) {
+	var e erasurePolicy
+	parts := strings.Split(name, "-")
+	if len(parts) != 3 || parts[0] != "RS" {
+		return e, errors.New("Invalid erasure coding policy " + name)
+	}
+	var err error
+	e.Data, err = strconv.Atoi(parts[1])
+	if err != nil || e.Data < 1 {
+		return e, errors.New("Invalid erasure coding policy " + name)
+	}
+	e.Parity, err = strconv.Atoi(parts[2])
+	if err != nil || e.Parity < 1 || e.Data+e.Parity > 256 {
+		return e, errors.New("Invalid erasure coding policy " + name)
+	}
+	return e, nil
+}
+
+// dataBlocks is the number of data Blocks of a file of numBlocks Blocks
+func (e erasurePolicy) dataBlocks(numBlocks int) int {
+	n := 0
+	if numBlocks > e.Parity {
+		n = (numBlocks - e.Parity) * e.Data / (e.Data + e.Parity)
+	}
+	for n+(n+e.Data-1)/e.Data*e.Parity < numBlocks {
+		n++
+	}
+	return n
+}
+
+// stripeBlocks lists the data and parity Blocks of a stripe of a file with n
+// data Blocks
+func (e erasurePolicy) stripeBlocks(stripe, n int) (data, parity []int) {
+	for i := stripe * e.Data; i < n && i < (stripe+1)*e.Data; i++ {
+		data = append(data, i)
+	}
+	for i := 0; i < e.Parity; i++ {
+		parity = append(parity, n+stripe*e.Parity+i)
+	}
+	return data, parity
+}
+
+// arithmetic in GF(2^8), with the polynomial x^8 + x^4 + x^3 + x^2 + 1
+var gfExp [512]byte
+var gfLog [256]int
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-gfLog[a]]
+}
+
+// parityCoefficient is the weight of data Block j in parity Block i. The
+// parity rows form a Cauchy matrix, so any Data rows of the code are
+// independent.
+func (e erasurePolicy) parityCoefficient(i, j int) byte {
+	return gfInv(byte(e.Data+i) ^ byte(j))
+}
+
+// codeRow is row num of the code of a stripe of k data Blocks: the identity
+// for data Blocks, followed by the parity rows
+func (e erasurePolicy) codeRow(num, k int) []byte {
+	row := make([]byte, k)
+	if num < k {
+		row[num] = 1
+		return row
+	}
+	for j := range row {
+		row[j] = e.parityCoefficient(num-k, j)
+	}
+	return row
+}
+
+// encodeStripe computes the parity Blocks of a stripe of data Blocks, each
+// as long as the first
+func (e erasurePolicy) encodeStripe(data [][]byte) [][]byte {
+	parity := make([][]byte, e.Parity)
+	for i := range parity {
+		parity[i] = make([]byte, len(data[0]))
+		for j, d := range data {
+			c := e.parityCoefficient(i, j)
+			for x, v := range d {
+				parity[i][x] ^= gfMul(c, v)
+			}
+		}
+	}
+	return parity
+}
+
+// invert returns the inverse of a square matrix by Gauss-Jordan elimination
+func invert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	a := make([][]byte, n)
+	for i := range m {
+		a[i] = make([]byte, 2*n)
+		copy(a[i], m[i])
+		a[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && a[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("Singular matrix")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		inv := gfInv(a[col][col])
+		for x := range a[col] {
+			a[col][x] = gfMul(a[col][x], inv)
+		}
+		for r := 0; r < n; r++ {
+			if r == col || a[r][col] == 0 {
+				continue
+			}
+			c := a[r][col]
+			for x := range a[r] {
+				a[r][x] ^= gfMul(c, a[col][x])
+			}
+		}
+	}
+	for i := range a {
+		a[i] = a[i][n:]
+	}
+	return a, nil
+}
+
+// reconstructStripe rebuilds the missing data Blocks of a stripe of k data
+// Blocks. shards maps the positions within the stripe of at least k
+// available Blocks, data first, to their contents padded to the length of
+// the parity Blocks.
+func (e erasurePolicy) reconstructStripe(k int, shards map[int][]byte, missing []int) (map[int][]byte, error) {
+	rows := make([][]byte, 0, k)
+	inputs := make([][]byte, 0, k)
+	for num := 0; num < k+e.Parity && len(rows) < k; num++ {
+		if s, ok := shards[num]; ok {
+			rows = append(rows, e.codeRow(num, k))
+			inputs = append(inputs, s)
+		}
+	}
+	if len(rows) < k {
+		return nil, errors.New("Too many Blocks lost to rebuild the stripe")
+	}
+	decode, err := invert(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	rebuilt := make(map[int][]byte, len(missing))
+	for _, j := range missing {
+		out := make([]byte, len(inputs[0]))
+		for t, in := range inputs {
+			c := decode[j][t]
+			for x, v := range in {
+				out[x] ^= gfMul(c, v)
+			}
+		}
+		rebuilt[j] = out
+	}
+	return rebuilt, nil
+}
+
+// DistributeErasureCodedFromFile stores a local file with the erasure coding
+// policy, such as RS-6-3, rather than replicas
+func DistributeErasureCodedFromFile(localname, remotename, policy string) error {
+	info, err := os.Lstat(localname)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Open(localname)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+
+	return DistributeErasureCodedFromReader(bufio.NewReader(fi), info.Size(), remotename, policy)
+}
+
+// DistributeErasureCodedFromReader splits size bytes read from r into
+// stripes of data Blocks, and distributes each stripe with its parity
+// Blocks as the file remotename
+func DistributeErasureCodedFromReader(r io.Reader, size int64, remotename, policy string) error {
+	e, err := parsePolicy(policy)
+	if err != nil {
+		return err
+	}
+	if size <= 0 {
+		return errors.New("Cannot erasure code an empty file")
+	}
+	if strings.Index(remotename, "/") != 0 {
+		remotename = "/" + remotename
+	}
+	err = acquireLease(remotename, CREATE|OVERWRITE, FileStatus{})
+	if err != nil {
+		return err
+	}
+	defer releaseLease(remotename)
+
+	p := Packet{SRC: id, DST: "NN", CMD: ERASURECODE, Message: holder}
+	p.Headers = []BlockHeader{{Filename: remotename}}
+	p.Status = []FileStatus{{Path: remotename, Size: size, Erasure: policy}}
+	err = send(p)
+	if err != nil {
+		return err
+	}
+
+	n := int((size + int64(SIZEOFBLOCK) - 1) / int64(SIZEOFBLOCK))
+	total := n + (n+e.Data-1)/e.Data*e.Parity
+	pl := newPipeline()
+	// parity Blocks are counted as they are sent, so the bytes sent are not known
+	pl.progress = newTransfer(remotename, true, 0, total)
+	defer pl.wait()
+	for stripe := 0; stripe*e.Data < n; stripe++ {
+		dataNums, parityNums := e.stripeBlocks(stripe, n)
+		data := make([][]byte, len(dataNums))
+		for i, num := range dataNums {
+			buf := make([]byte, SIZEOFBLOCK)
+			m, err := io.ReadFull(r, buf)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			data[i] = buf[:m]
+			err = pl.distribute(Block{BlockHeader{"", remotename, m, num, total, 0, "", 0, ""}, data[i]})
+			if err != nil {
+				return err
+			}
+		}
+
+		// parity is computed over data padded to the first Block's length
+		padded := make([][]byte, len(data))
+		for i, d := range data {
+			padded[i] = make([]byte, len(data[0]))
+			copy(padded[i], d)
+		}
+		for i, par := range e.encodeStripe(padded) {
+			err = pl.distribute(Block{BlockHeader{"", remotename, len(par), parityNums[i], total, 0, "", 0, ""}, par})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = pl.wait()
+	if err != nil {
+		return err
+	}
+	pl.progress.done()
+	return nil
+}
+
+// retrieveErasureCoded writes the data of an erasure coded file of size
+// bytes to w, rebuilding the data Blocks which cannot be read from the
+// parity of their stripes
+func retrieveErasureCoded(w *bufio.Writer, st FileStatus, headers []BlockHeader) error {
+	e, err := parsePolicy(st.Erasure)
+	if err != nil {
+		return err
+	}
+	if len(headers) == 0 {
+		return errors.New("No Blocks of " + st.Path + " can be read")
+	}
+	available := make(map[int]BlockHeader, len(headers))
+	for _, h := range headers {
+		available[h.BlockNum] = h
+	}
+	n := e.dataBlocks(headers[0].NumBlocks)
+
+	t := newTransfer(st.Path, false, st.Size, n)
+	for stripe := 0; stripe*e.Data < n; stripe++ {
+		dataNums, parityNums := e.stripeBlocks(stripe, n)
+		k := len(dataNums)
+
+		// shards by position within the stripe
+		shards := make(map[int][]byte)
+		missing := make([]int, 0)
+		for j, num := range dataNums {
+			b, err := fetchBlock(available, num)
+			if err != nil {
+				missing = append(missing, j)
+				continue
+			}
+			shards[j] = b.Data[:b.Header.Size]
+		}
+
+		if len(missing) > 0 {
+			cell := 0
+			for i, num := range parityNums {
+				if len(shards) >= k {
+					break
+				}
+				b, err := fetchBlock(available, num)
+				if err != nil {
+					continue
+				}
+				shards[k+i] = b.Data[:b.Header.Size]
+				cell = b.Header.Size
+			}
+			if len(shards) < k {
+				return errors.New("Too many Blocks lost to rebuild " + st.Path)
+			}
+			padded := make(map[int][]byte, len(shards))
+			for j, s := range shards {
+				padded[j] = make([]byte, cell)
+				copy(padded[j], s)
+			}
+
+			rebuilt, err := e.reconstructStripe(k, padded, missing)
+			if err != nil {
+				return err
+			}
+			for _, j := range missing {
+				// only the last Block of a file may be shorter than the stripe's first
+				size := int64(cell)
+				if dataNums[j] == n-1 && k > 1 {
+					size = st.Size - int64(n-1)*int64(cell)
+				}
+				shards[j] = rebuilt[j][:size]
+			}
+		}
+
+		for j := range dataNums {
+			_, err := w.Write(shards[j])
+			if err != nil {
+				return err
+			}
+			t.block(len(shards[j]))
+		}
+		err := w.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	t.done()
+	return nil
+}
+
+// fetchBlock retrieves Block num of a file from the replica listed for it
+func fetchBlock(available map[int]BlockHeader, num int) (Block, error) {
+	h, ok := available[num]
+	if !ok {
+		return Block{}, errors.New("Block " + strconv.Itoa(num) + " is lost")
+	}
+	return retrieveBlock(h)
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+// connectPair returns the two ends of a TCP connection, which unlike
+// net.Pipe buffers a message while its reader is busy writing
+func connectPair(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return client, server
+}
+
+// fakeNamenode stores the Blocks distributed to it and serves them back,
+// answering until the connection closes
+func fakeNamenode(conn net.Conn, blocks map[int]Block, st *FileStatus) {
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+	for {
+		var p Packet
+		if err := dec.Decode(&p); err != nil {
+			return
+		}
+		r := Packet{SRC: "NN", DST: p.SRC, CMD: ACK, RequestID: p.RequestID}
+		switch p.CMD {
+		case ERASURECODE:
+			*st = p.Status[0]
+		case DISTRIBUTE:
+			blocks[p.Data.Header.BlockNum] = p.Data
+		case GETHEADERS:
+			r.CMD = GETHEADERS
+			r.Headers = []BlockHeader{}
+			for i := 0; i < len(blocks)+10; i++ {
+				if b, ok := blocks[i]; ok {
+					r.Headers = append(r.Headers, b.Header)
+				}
+			}
+			r.Status = []FileStatus{*st}
+		case RETRIEVEBLOCK:
+			r.CMD = BLOCK
+			r.Data = blocks[p.Headers[0].BlockNum]
+		}
+		enc.Encode(r)
+	}
+}
+
+func TestErasureCodedFile(t *testing.T) {
+
+	SIZEOFBLOCK = 64
+	for _, c := range []struct {
+		policy string
+		size   int
+		lost   []int
+	}{
+		{"RS-3-2", 64*7 + 5, []int{0, 2, 6}},   // two Blocks of the first stripe and the short last Block
+		{"RS-3-2", 64 * 7, []int{6}},           // the last Block alone in its stripe
+		{"RS-6-3", 64*4 + 1, []int{1, 3, 4}},   // any three of a stripe of five
+		{"RS-2-1", 10, []int{0}},               // a file of one short Block
+		{"RS-4-2", 64 * 8, []int{8, 3, 10, 5}}, // parity and data of each stripe
+	} {
+		client, server := connectPair(t)
+		encoder = json.NewEncoder(client)
+		startDispatch(json.NewDecoder(client))
+		blocks := make(map[int]Block)
+		var st FileStatus
+		go fakeNamenode(server, blocks, &st)
+
+		data := make([]byte, c.size)
+		rand.Read(data)
+		err := DistributeErasureCodedFromReader(bytes.NewReader(data), int64(c.size), "/cold.bin", c.policy)
+		if err != nil {
+			t.Fatalf("%s: %s", c.policy, err)
+		}
+		e, _ := parsePolicy(c.policy)
+		n := (c.size + SIZEOFBLOCK - 1) / SIZEOFBLOCK
+		if len(blocks) != n+(n+e.Data-1)/e.Data*e.Parity || e.dataBlocks(blocks[0].Header.NumBlocks) != n {
+			t.Fatalf("%s: Expected %d data Blocks and their parity, got %d Blocks", c.policy, n, len(blocks))
+		}
+
+		for _, num := range c.lost {
+			delete(blocks, num)
+		}
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		err = RetrieveToWriter(w, "/cold.bin")
+		if err != nil {
+			t.Fatalf("%s: %s", c.policy, err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("%s: Rebuilt file of %d bytes differs from the %d written", c.policy, out.Len(), len(data))
+		}
+		client.Close()
+	}
+}
+
+func TestTooManyBlocksLost(t *testing.T) {
+
+	SIZEOFBLOCK = 64
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+	blocks := make(map[int]Block)
+	var st FileStatus
+	go fakeNamenode(server, blocks, &st)
+
+	err := DistributeErasureCodedFromReader(bytes.NewReader(make([]byte, 200)), 200, "/cold.bin", "RS-2-1")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	delete(blocks, 0)
+	delete(blocks, 4)
+	if err := RetrieveToWriter(bufio.NewWriter(&bytes.Buffer{}), "/cold.bin"); err == nil {
+		t.Errorf("Expected an error reading a stripe missing two Blocks")
+	}
+}
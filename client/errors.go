@@ -0,0 +1,37 @@
+package client
+
+// Error is an error the namenode answered a request with. Its Code tells
+// the kinds of failures apart, and is empty for those of no kind.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is an Error of the same code, so that
+// errors.Is(err, ErrFileNotFound) holds for every missing file
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// kinds of errors the namenode answers requests with
+var (
+	ErrNoDatanodes   = &Error{Code: "NO_DATANODES", Message: "No datanodes are connected"}
+	ErrFileNotFound  = &Error{Code: "FILE_NOT_FOUND", Message: "File not found"}
+	ErrInvalidHeader = &Error{Code: "INVALID_HEADER", Message: "Invalid Header received"}
+	ErrUnauthorized  = &Error{Code: "UNAUTHORIZED", Message: "Permission denied"}
+	ErrFileExists    = &Error{Code: "FILE_EXISTS", Message: "File exists"}
+	ErrWriteConflict = &Error{Code: "WRITE_CONFLICT", Message: "File is being written by another writer"}
+	ErrStandby       = &Error{Code: "STANDBY", Message: standbyMessage}
+	ErrSafeMode      = &Error{Code: "SAFE_MODE", Message: "Namenode is in safe mode, the namespace is read-only"}
+	ErrBlocksLost    = &Error{Code: "BLOCKS_LOST", Message: "Blocks of the file are lost"}
+)
+
+// responseError returns the error of an ERROR answer
+func responseError(r Packet) error {
+	return &Error{Code: r.Code, Message: r.Message}
+}
@@ -0,0 +1,20 @@
+package client
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResponseError(t *testing.T) {
+	err := responseError(Packet{CMD: ERROR, Code: "FILE_NOT_FOUND", Message: "File not found /a"})
+	if !errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrNoDatanodes) || err.Error() != "File not found /a" {
+		t.Errorf("Unexpected error %v", err)
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Code != "FILE_NOT_FOUND" {
+		t.Errorf("Code of the error lost")
+	}
+	if errors.Is(responseError(Packet{CMD: ERROR, Message: "other"}), &Error{}) {
+		t.Errorf("Error without a code matched one")
+	}
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"errors"
+	"strconv"
+)
+
+// kinds of namespace events
+const (
+	EventCreate = "create" // a file, directory or symbolic link was created
+	EventClose  = "close"  // the writer of a file released its lease, so the file is complete
+	EventDelete = "delete" // a path was deleted
+	EventRename = "rename" // a path was renamed, or moved to the trash
+)
+
+// number of events a Watcher holds before they are lost
+const watchBuffer = 1024
+
+// errEventsLost ends a Watcher whose events were not read fast enough
+var errEventsLost = errors.New("Events lost, the Watcher was not read fast enough")
+
+// Event is a change to the namespace
+type Event struct {
+	Kind   string     // EventCreate, EventClose, EventDelete or EventRename
+	Path   string     // path changed
+	Dst    string     // new path of a rename
+	Status FileStatus // the file or directory after a create or close
+}
+
+// Watcher receives the events of the namespace below a path
+type Watcher struct {
+	Events <-chan Event // closed when the Watcher ends
+
+	id     int64 // RequestID of the subscription
+	events chan Event
+	err    error
+}
+
+// watchers receiving events, by the RequestID of their subscription, guarded
+// by pendingLock
+var watchers = make(map[int64]*Watcher)
+
+// Watch subscribes to the events of prefix and the paths below it.
+// Subscriptions are made on the connection to the namenode, so a Watcher
+// ends when the connection is lost, or replaced by one to the namespace of
+// another mount point, and must be made again once the client reconnects.
+func Watch(prefix string) (*Watcher, error) {
+	w := &Watcher{events: make(chan Event, watchBuffer)}
+	w.Events = w.events
+	p := Packet{SRC: id, DST: "NN", CMD: SUBSCRIBE}
+	p.Headers = []BlockHeader{{Filename: prefix}}
+	leave, err := enterNamespace(p)
+	if err != nil {
+		return nil, err
+	}
+	r, err := attemptWatch(p, w)
+	leave()
+	if err == nil && r.CMD == ERROR {
+		err = responseError(r)
+	}
+	if err != nil {
+		pendingLock.Lock()
+		w.end(err)
+		pendingLock.Unlock()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Err returns why the Watcher ended, or nil while it runs or after Close
+func (w *Watcher) Err() error {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	return w.err
+}
+
+// Close ends the subscription of the Watcher
+func (w *Watcher) Close() error {
+	pendingLock.Lock()
+	w.end(nil)
+	pendingLock.Unlock()
+	return send(unsubscribe(w.id))
+}
+
+// unsubscribe returns the request ending the subscription subID
+func unsubscribe(subID int64) Packet {
+	p := Packet{SRC: id, DST: "NN", CMD: UNSUBSCRIBE, Message: strconv.FormatInt(subID, 10)}
+	p.Headers = []BlockHeader{{Filename: "/"}}
+	return p
+}
+
+// end stops delivering events to w, closing its Events for the reason err.
+// The caller must hold pendingLock.
+func (w *Watcher) end(err error) {
+	if watchers[w.id] != w {
+		return
+	}
+	delete(watchers, w.id)
+	w.err = err
+	close(w.events)
+}
+
+// deliver hands an event to the Watcher of its subscription. A Watcher whose
+// events are not read is ended rather than holding up the responses read
+// after the event.
+func deliver(r Packet) {
+	e := Event{Kind: r.Message}
+	if len(r.Headers) == 1 {
+		e.Path = r.Headers[0].Filename
+	}
+	if len(r.Renamed) == 1 {
+		e.Dst = r.Renamed[0].Filename
+	}
+	if len(r.Status) == 1 {
+		e.Status = r.Status[0]
+	}
+
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	w, ok := watchers[r.RequestID]
+	if !ok {
+		return
+	}
+	select {
+	case w.events <- e:
+	default:
+		w.end(errEventsLost)
+		go send(unsubscribe(w.id))
+	}
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+
+	// the namenode acknowledges the subscription and sends its events
+	unsubscribed := make(chan string, 1)
+	go func() {
+		enc, dec := json.NewEncoder(server), json.NewDecoder(server)
+		for {
+			var p Packet
+			if err := dec.Decode(&p); err != nil {
+				return
+			}
+			enc.Encode(Packet{SRC: "NN", DST: p.SRC, CMD: ACK, RequestID: p.RequestID})
+			switch p.CMD {
+			case SUBSCRIBE:
+				enc.Encode(Packet{SRC: "NN", DST: "C", CMD: EVENT, RequestID: p.RequestID, Message: EventCreate,
+					Headers: []BlockHeader{{Filename: "/data/new"}}})
+				enc.Encode(Packet{SRC: "NN", DST: "C", CMD: EVENT, RequestID: p.RequestID, Message: EventRename,
+					Headers: []BlockHeader{{Filename: "/data/new"}}, Renamed: []BlockHeader{{Filename: "/data/old"}}})
+				// events of other subscriptions are dropped
+				enc.Encode(Packet{SRC: "NN", DST: "C", CMD: EVENT, RequestID: p.RequestID + 100, Message: EventDelete})
+			case UNSUBSCRIBE:
+				unsubscribed <- p.Message
+			}
+		}
+	}()
+
+	w, err := Watch("/data")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	e := <-w.Events
+	if e.Kind != EventCreate || e.Path != "/data/new" {
+		t.Errorf("Unexpected event %v", e)
+	}
+	e = <-w.Events
+	if e.Kind != EventRename || e.Path != "/data/new" || e.Dst != "/data/old" {
+		t.Errorf("Unexpected event %v", e)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if id := <-unsubscribed; id == "" || id == "0" {
+		t.Errorf("Unexpected subscription ended %q", id)
+	}
+	if _, ok := <-w.Events; ok || w.Err() != nil {
+		t.Errorf("Watcher not ended by Close %v", w.Err())
+	}
+
+	// a lost connection ends the Watchers on it
+	w, err = Watch("/data")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	<-w.Events
+	<-w.Events
+	server.Close()
+	if _, ok := <-w.Events; ok || w.Err() != errConnectionLost {
+		t.Errorf("Watcher not ended by the lost connection %v", w.Err())
+	}
+}
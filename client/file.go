@@ -0,0 +1,198 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+// File is a remote file opened for reading at any offset. Only the Blocks
+// covering the bytes read are retrieved, so a reader such as one of
+// Parquet can read a footer without retrieving the whole file.
+type File struct {
+	path    string
+	headers []BlockHeader // a replica of each Block, by block number
+	offsets []int64       // offset of each Block within the file
+	size    int64
+	base    int64    // offset of a packed file within the archive whose Blocks these are
+	keys    []string // data keys held until Close, for encrypted files
+
+	lock   sync.Mutex // guards pos and cached
+	pos    int64      // offset of the next Read
+	cached *Block     // last Block retrieved, for reads within it
+}
+
+// Open opens the file at path for random access reads. Erasure coded files
+// are rebuilt from their stripes, and can only be read whole. Files packed
+// into an archive are read from the Blocks of the archive.
+func Open(path string) (*File, error) {
+	r, err := fileHeaders(path)
+	if err != nil {
+		return nil, err
+	}
+	if archived(r) {
+		return openPacked(path, r.Status[0])
+	}
+	f := &File{path: path, headers: r.Headers}
+	if len(r.Status) == 1 && r.Status[0].Erasure != "" {
+		return nil, errors.New("Erasure coded file " + path + " cannot be read at an offset")
+	}
+	if len(r.Status) == 1 && r.Status[0].Key != nil {
+		err = openFileKey(r.Status[0], r.Headers)
+		if err != nil {
+			return nil, err
+		}
+		f.keys = blockNames(r.Headers)
+	}
+
+	f.offsets = make([]int64, len(f.headers))
+	for i, h := range f.headers {
+		if h.BlockNum != i {
+			f.Close()
+			return nil, errors.New("Missing Block of " + path)
+		}
+		f.offsets[i] = f.size
+		f.size += int64(h.Size)
+	}
+	return f, nil
+}
+
+// openPacked opens the file at path packed into an archive, as described by
+// st, as the bytes of the archive it spans
+func openPacked(path string, st FileStatus) (*File, error) {
+	f, err := Open(st.Archive)
+	if err != nil {
+		return nil, err
+	}
+	if st.Offset < 0 || st.Offset+st.Size > f.size {
+		f.Close()
+		return nil, errors.New("Packed file " + path + " lies outside its archive")
+	}
+	f.path, f.base, f.size = path, st.Offset, st.Size
+	return f, nil
+}
+
+// Size returns the length of the file in bytes
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// blockAt returns the number of the Block holding the byte at off
+func (f *File) blockAt(off int64) int {
+	return sort.Search(len(f.offsets), func(i int) bool { return f.offsets[i] > off }) - 1
+}
+
+// ReadAt reads len(p) bytes from off, retrieving the Blocks covering them.
+// Fewer bytes are read only at the end of the file, with io.EOF.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("Negative offset")
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > f.size {
+		end = f.size
+	}
+	if end == off {
+		return 0, nil
+	}
+	off, end = off+f.base, end+f.base
+
+	n := 0
+	err := f.blocks(f.blockAt(off), f.blockAt(end-1), func(b Block) error {
+		start := f.offsets[b.Header.BlockNum]
+		from, to := int64(0), int64(b.Header.Size)
+		if off > start {
+			from = off - start
+		}
+		if end < start+to {
+			to = end - start
+		}
+		if int64(len(b.Data)) < to {
+			return errors.New("Short Block of " + f.path)
+		}
+		n += copy(p[n:], b.Data[from:to])
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// blocks passes the Blocks numbered first to last to each in order, using
+// the last Block retrieved rather than retrieving it again
+func (f *File) blocks(first, last int, each func(Block) error) error {
+	f.lock.Lock()
+	cached := f.cached
+	f.lock.Unlock()
+	if cached != nil && cached.Header.BlockNum == first {
+		err := each(*cached)
+		if err != nil {
+			return err
+		}
+		first++
+	}
+	if first > last {
+		return nil
+	}
+	return retrieveBlocks(f.headers[first:last+1], func(b Block) error {
+		f.lock.Lock()
+		f.cached = &b
+		f.lock.Unlock()
+		return each(b)
+	})
+}
+
+// Read reads from the offset reached by earlier Reads and Seeks
+func (f *File) Read(p []byte) (int, error) {
+	f.lock.Lock()
+	off := f.pos
+	f.lock.Unlock()
+
+	n, err := f.ReadAt(p, off)
+	f.lock.Lock()
+	f.pos = off + int64(n)
+	f.lock.Unlock()
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read, relative to the start of the file,
+// the current offset or the end of the file as whence is io.SeekStart,
+// io.SeekCurrent or io.SeekEnd
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("Invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("Negative offset")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Close releases the data keys of an encrypted file
+func (f *File) Close() error {
+	for _, name := range f.keys {
+		releaseKey(name)
+	}
+	f.keys = nil
+	return nil
+}
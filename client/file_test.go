@@ -0,0 +1,99 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestRandomAccessRead(t *testing.T) {
+
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+
+	// a file of three full Blocks and a short last Block, served by a
+	// namenode counting the Blocks retrieved
+	data := make([]byte, 64*3+10)
+	rand.Read(data)
+	blocks := splitBlocks("/big.bin", data, 64)
+	retrieved := make(chan int, 10)
+	go serveFile(server, func() []Block { return blocks }, retrieved)
+
+	f, err := Open("/big.bin")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer f.Close()
+	if f.Size() != int64(len(data)) {
+		t.Errorf("Expected size %d, got %d", len(data), f.Size())
+	}
+
+	// a range within the second and third Blocks retrieves only those
+	p := make([]byte, 70)
+	n, err := f.ReadAt(p, 100)
+	if err != nil || n != 70 || !bytes.Equal(p, data[100:170]) {
+		t.Fatalf("Wrong read at 100: %d bytes, %v", n, err)
+	}
+	if a, b := <-retrieved, <-retrieved; a+b != 3 || a*b != 2 || len(retrieved) != 0 {
+		t.Errorf("Expected Blocks 1 and 2 retrieved, got %d, %d and %d more", a, b, len(retrieved))
+	}
+
+	// a read past the end is short, and reads from the end of the file
+	if _, err := f.Seek(-20, io.SeekEnd); err != nil {
+		t.Fatalf("%s", err)
+	}
+	n, err = f.Read(p)
+	if err != nil || n != 20 || !bytes.Equal(p[:n], data[len(data)-20:]) {
+		t.Errorf("Wrong read of the last 20 bytes: %d bytes, %v", n, err)
+	}
+	if n, err = f.Read(p); n != 0 || err != io.EOF {
+		t.Errorf("Expected EOF, got %d bytes and %v", n, err)
+	}
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("Expected an error seeking before the start")
+	}
+}
+
+// splitBlocks divides data into Blocks of the file at path of size bytes
+func splitBlocks(path string, data []byte, size int) []Block {
+	n := (len(data) + size - 1) / size
+	blocks := make([]Block, n)
+	for i := range blocks {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		blocks[i] = Block{Header: BlockHeader{"DN1", path, end - i*size, i, n, 1, "", 0, ""}, Data: data[i*size : end]}
+	}
+	return blocks
+}
+
+// serveFile answers requests for the headers and Blocks of the file given by
+// file until conn closes, sending the number of each Block retrieved to
+// retrieved if it is not nil
+func serveFile(conn net.Conn, file func() []Block, retrieved chan int) {
+	enc, dec := json.NewEncoder(conn), json.NewDecoder(conn)
+	for {
+		var p Packet
+		if err := dec.Decode(&p); err != nil {
+			return
+		}
+		blocks := file()
+		r := Packet{SRC: "NN", DST: p.SRC, CMD: GETHEADERS, RequestID: p.RequestID}
+		for _, b := range blocks {
+			r.Headers = append(r.Headers, b.Header)
+		}
+		if p.CMD == RETRIEVEBLOCK {
+			r = Packet{SRC: "NN", DST: p.SRC, CMD: BLOCK, RequestID: p.RequestID, Data: blocks[p.Headers[0].BlockNum]}
+			if retrieved != nil {
+				retrieved <- p.Headers[0].BlockNum
+			}
+		}
+		enc.Encode(r)
+	}
+}
@@ -0,0 +1,282 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+)
+
+// Packets are sent either as a stream of JSON values, or in binary frames.
+// A frame starts with frameMagic and its version, naming the codec of the
+// Packet, followed by the lengths of the encoded Packet and of its Block's
+// data, and a CRC-32 of the frame after the magic. The Packet comes next,
+// without its Block's data, which follows as raw bytes rather than base64.
+const frameMagic = "GDFS"
+const frameVersion = 1
+const frameHeaderSize = 17
+
+// maxPacketSize is the longest packet accepted, in a frame or as JSON.
+// Longer frames are skipped, while a longer JSON value fails the connection.
+var maxPacketSize int64 = 1 << 28
+
+var wireFormat = "binary" // "binary" frames, or "json" for namenodes without them
+
+// errBadFrame is wrapped by the errors of frames which were skipped, after
+// which the connection can still be read
+var errBadFrame = errors.New("Bad frame")
+
+// isBadFrame reports whether a decoding error only lost a single frame
+func isBadFrame(err error) bool {
+	return errors.Is(err, errBadFrame)
+}
+
+// tooLong is the error of a packet, or of the Block data streamed with one,
+// longer than accepted. One which was skipped lost only itself, and is a bad
+// frame.
+type tooLong struct {
+	size    int64 // bytes of the packet or Block data, 0 if not known
+	limit   int64 // most bytes accepted
+	block   bool  // the Block data streamed with the packet was too long
+	skipped bool  // the connection can still be read
+}
+
+func (e *tooLong) Error() string {
+	what := "Packet"
+	if e.block {
+		what = "Block data"
+	}
+	if e.size == 0 {
+		return fmt.Sprintf("%s is longer than %d bytes", what, e.limit)
+	}
+	return fmt.Sprintf("%s of %d bytes is longer than %d bytes", what, e.size, e.limit)
+}
+
+// Is makes a packet which was skipped a bad frame
+func (e *tooLong) Is(target error) bool {
+	return e.skipped && target == errBadFrame
+}
+
+// isTooLong reports whether a decoding error refused a packet too long
+func isTooLong(err error) bool {
+	var e *tooLong
+	return errors.As(err, &e)
+}
+
+// packetEncoder writes Packets to a connection, as a json.Encoder does
+type packetEncoder interface {
+	Encode(v interface{}) error
+}
+
+// packetDecoder reads Packets from a connection, as a json.Decoder does
+type packetDecoder interface {
+	Decode(v interface{}) error
+}
+
+// frameEncoder writes Packets as binary frames
+type frameEncoder struct {
+	w       io.Writer
+	version byte // codec of the Packets, frameVersion for JSON
+}
+
+func newFrameEncoder(w io.Writer) *frameEncoder {
+	return &frameEncoder{w, frameVersion}
+}
+
+// Encode writes a Packet, or a pointer to one, as a single frame
+func (e *frameEncoder) Encode(v interface{}) error {
+	var p Packet
+	switch v := v.(type) {
+	case Packet:
+		p = v
+	case *Packet:
+		p = *v
+	default:
+		return fmt.Errorf("Cannot frame a %T", v)
+	}
+	data := p.Data.Data
+	p.Data.Data = nil
+	packet, err := frameCodec(e.version).Marshal(&p)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(packet)+len(data))
+	copy(frame, frameMagic)
+	frame[4] = e.version
+	binary.BigEndian.PutUint32(frame[5:9], uint32(len(packet)))
+	binary.BigEndian.PutUint32(frame[9:13], uint32(len(data)))
+	frame = append(frame, packet...)
+	frame = append(frame, data...)
+	sum := crc32.NewIEEE()
+	sum.Write(frame[4:13])
+	sum.Write(frame[frameHeaderSize:])
+	binary.BigEndian.PutUint32(frame[13:17], sum.Sum32())
+
+	// a single write keeps frames from concurrent senders whole
+	_, err = e.w.Write(frame)
+	return err
+}
+
+// frameDecoder reads Packets from binary frames
+type frameDecoder struct {
+	r     *bufio.Reader
+	codec byte // version of the codec agreed with the namenode, read besides JSON
+}
+
+func newFrameDecoder(r io.Reader) *frameDecoder {
+	if br, ok := r.(*bufio.Reader); ok {
+		return &frameDecoder{r: br}
+	}
+	return &frameDecoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next frame into a *Packet. A frame which is corrupt, too
+// long or of an unknown version gives an error satisfying isBadFrame, and
+// the next call reads the frame after it.
+func (d *frameDecoder) Decode(v interface{}) error {
+	p, ok := v.(*Packet)
+	if !ok {
+		return fmt.Errorf("Cannot decode a frame into a %T", v)
+	}
+	header, err := d.r.Peek(frameHeaderSize)
+	if err == io.EOF && len(header) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if string(header[:4]) != frameMagic {
+		return d.resync()
+	}
+	version := header[4]
+	// only JSON is read from peers which did not choose another codec
+	var codec packetCodec
+	if version == frameVersion || version == d.codec {
+		codec = frameCodec(version)
+	}
+	packetLen := int64(binary.BigEndian.Uint32(header[5:9]))
+	dataLen := int64(binary.BigEndian.Uint32(header[9:13]))
+	sum := binary.BigEndian.Uint32(header[13:17])
+	lengths := make([]byte, 9)
+	copy(lengths, header[4:13])
+	d.r.Discard(frameHeaderSize)
+
+	if packetLen+dataLen > maxPacketSize {
+		// the Packet is read without its data if it fits, so it can be
+		// answered, though its checksum cannot be verified
+		*p = Packet{}
+		skip := packetLen + dataLen
+		if packetLen <= maxPacketSize {
+			packet := make([]byte, packetLen)
+			_, err := io.ReadFull(d.r, packet)
+			if err != nil {
+				return err
+			}
+			if codec != nil {
+				codec.Unmarshal(packet, p)
+			}
+			p.Data.Data = nil
+			skip = dataLen
+		}
+		_, err = d.r.Discard(int(skip))
+		if err != nil {
+			return err
+		}
+		return &tooLong{size: packetLen + dataLen, limit: maxPacketSize, skipped: true}
+	}
+	body := make([]byte, packetLen+dataLen)
+	_, err = io.ReadFull(d.r, body)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+
+	check := crc32.NewIEEE()
+	check.Write(lengths)
+	check.Write(body)
+	if check.Sum32() != sum {
+		return fmt.Errorf("%w: checksum mismatch", errBadFrame)
+	}
+	if codec == nil {
+		return fmt.Errorf("%w: unsupported version %d", errBadFrame, version)
+	}
+	*p = Packet{}
+	err = codec.Unmarshal(body[:packetLen], p)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errBadFrame, err)
+	}
+	if dataLen > 0 {
+		p.Data.Data = body[packetLen:]
+	}
+	return nil
+}
+
+// resync skips bytes up to the magic of the next frame
+func (d *frameDecoder) resync() error {
+	skipped := 0
+	for {
+		b, err := d.r.Peek(len(frameMagic))
+		if err != nil {
+			return err
+		}
+		if string(b) == frameMagic {
+			return fmt.Errorf("%w: skipped %d bytes without a frame", errBadFrame, skipped)
+		}
+		d.r.Discard(1)
+		skipped++
+	}
+}
+
+// jsonDecoder reads Packets from a stream of JSON values, failing once a
+// value is longer than maxPacketSize rather than buffering it
+type jsonDecoder struct {
+	*json.Decoder
+	limit *packetLimit
+}
+
+func newJSONDecoder(r io.Reader) *jsonDecoder {
+	l := &packetLimit{r: r}
+	return &jsonDecoder{json.NewDecoder(l), l}
+}
+
+// Decode reads the next JSON value into v
+func (d *jsonDecoder) Decode(v interface{}) error {
+	d.limit.end = d.Decoder.InputOffset() + maxPacketSize
+	return d.Decoder.Decode(v)
+}
+
+// packetLimit reads a connection up to end, the furthest the value being
+// decoded may reach
+type packetLimit struct {
+	r    io.Reader
+	read int64 // bytes read so far
+	end  int64
+}
+
+func (l *packetLimit) Read(b []byte) (int, error) {
+	if l.read >= l.end {
+		return 0, &tooLong{limit: maxPacketSize}
+	}
+	if int64(len(b)) > l.end-l.read {
+		b = b[:l.end-l.read]
+	}
+	n, err := l.r.Read(b)
+	l.read += int64(n)
+	return n, err
+}
+
+// newPacketCodec sends and receives Packets on conn in the configured wire
+// format, which the namenode detects on its own
+func newPacketCodec(conn net.Conn) (packetEncoder, packetDecoder) {
+	if wireFormat == "json" {
+		return json.NewEncoder(conn), newChunkDecoder(newJSONDecoder(conn))
+	}
+	return newFrameEncoder(conn), newChunkDecoder(newFrameDecoder(conn))
+}
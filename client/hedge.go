@@ -0,0 +1,73 @@
+package client
+
+import (
+	"time"
+)
+
+var hedgeThreshold time.Duration // wait for a Block before asking for another replica, 0 disables hedged reads
+
+// hedgedRoundTrip sends the RETRIEVEBLOCK p, and if no Block arrives within
+// hedgeThreshold, asks the namenode for the same Block from another replica.
+// Whichever Block arrives first is used, and the other response is dropped.
+// The hedge fails at once if there is no other replica, leaving the first
+// request to finish. A lost connection is retried as by roundTrip.
+func hedgedRoundTrip(p Packet) (Packet, error) {
+	leave, err := enterNamespace(p)
+	if err != nil {
+		return Packet{}, err
+	}
+	defer leave()
+	first, firstID, err := startRequest(p, nil)
+	if err != nil {
+		return retryRoundTrip(p)
+	}
+
+	timer := time.NewTimer(hedgeThreshold)
+	defer timer.Stop()
+	var r Packet
+	ok := false
+	select {
+	case r, ok = <-first:
+		if !ok {
+			return retryRoundTrip(p)
+		}
+		return r, nil
+	case <-timer.C:
+	}
+
+	hedge := p
+	hedge.Message = p.Headers[0].DatanodeID
+	second, secondID, err := startRequest(hedge, nil)
+	if err != nil {
+		second = nil
+	}
+
+	// the first Block to arrive wins, and an error waits for the other request
+	var answers [2]*Packet
+	for first != nil || second != nil {
+		i := 0
+		select {
+		case r, ok = <-first:
+			first = nil
+		case r, ok = <-second:
+			second = nil
+			i = 1
+		}
+		if !ok {
+			continue
+		}
+		if r.CMD == BLOCK {
+			cancel(firstID)
+			cancel(secondID)
+			return r, nil
+		}
+		answer := r
+		answers[i] = &answer
+	}
+	for _, a := range answers {
+		if a != nil {
+			return *a, nil
+		}
+	}
+	return retryRoundTrip(p)
+}
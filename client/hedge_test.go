@@ -0,0 +1,59 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHedgedRead(t *testing.T) {
+
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+	hedgeThreshold = 20 * time.Millisecond
+	defer func() { hedgeThreshold = 0 }()
+
+	// the first datanode answers only after the hedge to the second, whose
+	// Block is used. The next hedge finds no other replica, so the first
+	// request is waited for.
+	hedges := make(chan Packet, 2)
+	go func() {
+		dec := json.NewDecoder(server)
+		enc := json.NewEncoder(server)
+		for n := 0; ; n++ {
+			var first, hedge Packet
+			if dec.Decode(&first) != nil || dec.Decode(&hedge) != nil {
+				return
+			}
+			hedges <- hedge
+			b := Block{Header: BlockHeader{"DN2", "/out.txt", 1, 0, 1, 0, "", 0, ""}, Data: []byte("a")}
+			if n == 0 {
+				enc.Encode(Packet{SRC: "NN", CMD: BLOCK, RequestID: hedge.RequestID, Data: b})
+			} else {
+				enc.Encode(Packet{SRC: "NN", CMD: ERROR, RequestID: hedge.RequestID, Message: "No other replica"})
+			}
+			b.Header.DatanodeID = "DN1"
+			enc.Encode(Packet{SRC: "NN", CMD: BLOCK, RequestID: first.RequestID, Data: b})
+		}
+	}()
+
+	b, err := retrieveBlock(BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""})
+	if err != nil || b.Header.DatanodeID != "DN2" {
+		t.Fatalf("Expected the hedged Block from DN2, got %v %v", b.Header, err)
+	}
+	if hedge := <-hedges; hedge.CMD != RETRIEVEBLOCK || hedge.Message != "DN1" {
+		t.Errorf("Unexpected hedge %v", hedge)
+	}
+
+	b, err = retrieveBlock(BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""})
+	if err != nil || b.Header.DatanodeID != "DN1" {
+		t.Fatalf("Expected the first Block after a failed hedge, got %v %v", b.Header, err)
+	}
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	if len(pending) != 0 || len(canceled) != 0 {
+		t.Errorf("Requests left waiting %v, canceled %v", pending, canceled)
+	}
+}
@@ -0,0 +1,43 @@
+package client
+
+import "fmt"
+
+// ReplicaLocation describes a replica of a Block in a BlockLocation
+type ReplicaLocation struct {
+	DatanodeID  string
+	Address     string // host the datanode connected from
+	HTTPAddress string // host:port of the datanode's HTTP server, if any
+	Rack        string
+	State       string // online, offline or decommissioning, unknown for a datanode no longer in the cluster
+	Cached      bool   // the replica is kept in the datanode's block cache
+}
+
+// BlockLocation describes a Block of a file and where its replicas are, for
+// scheduling work next to the data
+type BlockLocation struct {
+	BlockNum int
+	Offset   int64 // offset of the Block's first byte in the file
+	Length   int64
+	GenStamp int64
+	BlockID  int64
+	Replicas []ReplicaLocation // ordered from the closest to the client
+}
+
+// GetBlockLocations describes the file at path, with its storage policy and
+// replication, and the Blocks holding its bytes from offset on, length of
+// them or the rest of the file if length is 0
+func GetBlockLocations(path string, offset, length int64) (FileStatus, []BlockLocation, error) {
+	p := Packet{SRC: id, DST: "NN", CMD: GETBLOCKLOCATIONS, Offset: int(offset), Limit: int(length)}
+	p.Headers = []BlockHeader{{Filename: path}}
+	r, err := roundTrip(p)
+	if err != nil {
+		return FileStatus{}, nil, err
+	}
+	if r.CMD == ERROR {
+		return FileStatus{}, nil, responseError(r)
+	}
+	if r.CMD != GETBLOCKLOCATIONS || len(r.Status) != 1 {
+		return FileStatus{}, nil, fmt.Errorf("Bad response packet %v", r)
+	}
+	return r.Status[0], r.Locations, nil
+}
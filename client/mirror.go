@@ -0,0 +1,501 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// policies of a Mirror for files changed on both clusters
+const (
+	ConflictSource      = "source"      // the source's version replaces the destination's
+	ConflictDestination = "destination" // the destination's version is kept, and the source's skipped
+	ConflictBoth        = "both"        // the destination's version is moved aside, to the path with .conflict added
+)
+
+// default time between reads of the source's edit log
+const defaultMirrorInterval = 5 * time.Second
+
+// time the source namenode has to serve its edit log
+const mirrorTimeout = 30 * time.Second
+
+// errMirrorDropped is answered for edits a checkpoint dropped from the
+// source's edit log, which are copied again in full
+var errMirrorDropped = errors.New("Edits were dropped from the source's edit log by a checkpoint")
+
+// mirrorEdit is the part of an edit of the source's edit log a Mirror needs
+type mirrorEdit struct {
+	Seq     int64
+	Request *Packet      `json:",omitempty"`
+	Added   *BlockHeader `json:",omitempty"`
+}
+
+// MirrorStats describes how far a Mirror has got
+type MirrorStats struct {
+	Seq       int64         // last edit of the source applied
+	SourceSeq int64         // newest edit of the source, as it last answered
+	Lag       time.Duration // time since the mirror was last caught up with the source, 0 while it is
+	Files     int64         // files copied
+	Bytes     int64         // bytes copied
+	Conflicts int64         // files changed on both clusters
+	Refused   int64         // edits the destination refused, which are skipped
+	LastError string        // last failure, of an edit or of a read of the source
+}
+
+// Mirror copies the changes made below a directory of another cluster to
+// the cluster the client is connected to, asynchronously, for disaster
+// recovery. It tails the source namenode's edit log, replays its namespace
+// changes and reads the files written there over WebHDFS.
+type Mirror struct {
+	Source    string        // HTTP host:port of the source namenode, serving its edit log and WebHDFS
+	Path      string        // directory of the source mirrored, / for the whole namespace
+	Target    string        // directory of the destination the changes are made below
+	Conflict  string        // ConflictSource, ConflictDestination or ConflictBoth
+	Interval  time.Duration // time between reads of the source's edit log
+	StateFile string        // local file keeping the last edit applied across restarts, if not empty
+
+	mu       sync.Mutex
+	stats    MirrorStats
+	caughtUp time.Time
+	open     map[string]bool // source files being written, copied once their lease is released
+	dirty    map[string]bool // source files with new replicas, copied before the next namespace change
+}
+
+// NewMirror returns a Mirror of the directory path of the cluster whose
+// namenode serves HTTP at source to the directory target of the cluster the
+// client is connected to, with the source's version winning conflicts. The
+// edit it starts after is read from stateFile if it exists.
+func NewMirror(source, path, target, stateFile string) (*Mirror, error) {
+	m := &Mirror{
+		Source:    source,
+		Path:      cleanDir(path),
+		Target:    cleanDir(target),
+		Conflict:  ConflictSource,
+		Interval:  defaultMirrorInterval,
+		StateFile: stateFile,
+		caughtUp:  time.Now(),
+		open:      make(map[string]bool),
+		dirty:     make(map[string]bool),
+	}
+	if stateFile == "" {
+		return m, nil
+	}
+	data, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.stats.Seq, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, errors.New("Bad mirror state in " + stateFile + ": " + err.Error())
+	}
+	return m, nil
+}
+
+// cleanDir returns path as an absolute path without a trailing slash
+func cleanDir(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path
+}
+
+// mapPath returns the destination path of the source path p, and whether p
+// lies in the mirrored directory
+func (m *Mirror) mapPath(p string) (string, bool) {
+	switch {
+	case m.Path == "/":
+		return cleanDir(m.Target + p), true
+	case p == m.Path:
+		return m.Target, true
+	case strings.HasPrefix(p, m.Path+"/"):
+		return cleanDir(m.Target + strings.TrimPrefix(p, m.Path)), true
+	}
+	return "", false
+}
+
+// Stats returns how far the Mirror has got
+func (m *Mirror) Stats() MirrorStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.stats
+	if st.Seq < st.SourceSeq {
+		st.Lag = time.Since(m.caughtUp)
+	}
+	return st
+}
+
+// fail records the failure err
+func (m *Mirror) fail(err error) {
+	m.mu.Lock()
+	m.stats.LastError = err.Error()
+	m.mu.Unlock()
+	log.Println("Mirror of ", m.Source, " failed ", err)
+}
+
+// Run applies the source's changes every Interval until stop is closed.
+// Failures are logged and the edit which failed is tried again.
+func (m *Mirror) Run(stop <-chan struct{}) {
+	tick := time.NewTicker(m.Interval)
+	defer tick.Stop()
+
+	for {
+		err := m.Sync()
+		if err != nil {
+			m.fail(err)
+		}
+		select {
+		case <-tick.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Sync applies the edits the source made since the last one applied. Edits
+// the destination refuses are counted and skipped, while a failure to read
+// the source or write the destination stops the sync at the edit, to be
+// tried again. Once the source has dropped edits not yet applied, the
+// mirrored directory is copied again in full.
+func (m *Mirror) Sync() error {
+	seq := m.Stats().Seq
+	edits, head, err := m.fetch(seq)
+	if err == errMirrorDropped {
+		if head == 0 {
+			return err
+		}
+		log.Println("Mirror of ", m.Source, " lost edits after ", seq, ", copying ", m.Path, " again")
+		err = m.mirrorPath(m.Path, m.Target)
+		if err != nil {
+			return err
+		}
+		m.setSeq(head)
+		return m.save()
+	}
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.stats.SourceSeq = head
+	m.mu.Unlock()
+	for _, e := range edits {
+		err = m.apply(e)
+		if err != nil {
+			break
+		}
+		m.setSeq(e.Seq)
+	}
+	if err == nil {
+		err = m.flush()
+	}
+	if serr := m.save(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// setSeq records seq as the last edit applied
+func (m *Mirror) setSeq(seq int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats.Seq = seq
+	if m.stats.SourceSeq < seq {
+		m.stats.SourceSeq = seq
+	}
+	if seq >= m.stats.SourceSeq {
+		m.caughtUp = time.Now()
+	}
+}
+
+// save writes the last edit applied to the StateFile
+func (m *Mirror) save() error {
+	if m.StateFile == "" {
+		return nil
+	}
+	tmp := m.StateFile + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(m.Stats().Seq, 10)+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.StateFile)
+}
+
+// fetch reads the source's edits after since, and the newest edit it has
+func (m *Mirror) fetch(since int64) ([]mirrorEdit, int64, error) {
+	c := http.Client{Timeout: mirrorTimeout}
+	resp, err := c.Get("http://" + m.Source + "/editlog?since=" + strconv.FormatInt(since, 10))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	head, _ := strconv.ParseInt(resp.Header.Get("X-Edit-Seq"), 10, 64)
+	if resp.StatusCode == http.StatusGone {
+		return nil, head, errMirrorDropped
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, errors.New("Source namenode answered " + resp.Status)
+	}
+
+	var edits []mirrorEdit
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var e mirrorEdit
+		err = decoder.Decode(&e)
+		if err == io.EOF {
+			return edits, head, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		edits = append(edits, e)
+	}
+}
+
+// apply makes the change of an edit of the source on the destination
+func (m *Mirror) apply(e mirrorEdit) error {
+	if e.Added != nil {
+		if _, ok := m.mapPath(e.Added.Filename); ok && !m.open[e.Added.Filename] {
+			m.dirty[e.Added.Filename] = true
+		}
+		return nil
+	}
+	if e.Request == nil || len(e.Request.Headers) != 1 {
+		// replicas dropped by the source are its own business
+		return nil
+	}
+	p := *e.Request
+	path := p.Headers[0].Filename
+	dst, inside := m.mapPath(path)
+
+	switch p.CMD {
+	case LEASE:
+		if inside {
+			m.open[path] = true
+		}
+		return nil
+	case RELEASE:
+		delete(m.open, path)
+		if inside {
+			m.dirty[path] = true
+		}
+		return m.flush()
+	case TRUNCATE:
+		if inside {
+			m.dirty[path] = true
+		}
+		return m.flush()
+	case RENAME:
+		if len(p.Renamed) != 1 {
+			return nil
+		}
+		err := m.flush()
+		if err != nil {
+			return err
+		}
+		to, toInside := m.mapPath(p.Renamed[0].Filename)
+		switch {
+		case inside && toInside:
+			refused, err := m.replay(Packet{CMD: RENAME, Headers: []BlockHeader{{Filename: dst}}, Renamed: []BlockHeader{{Filename: to}}})
+			if refused {
+				// the file was renamed before the mirror copied it
+				return m.mirrorPath(p.Renamed[0].Filename, to)
+			}
+			return err
+		case inside:
+			// the path left the mirrored directory
+			_, err = m.replay(Packet{CMD: DELETE, Headers: []BlockHeader{{Filename: dst}}, Flags: RECURSIVE})
+			return err
+		case toInside:
+			return m.mirrorPath(p.Renamed[0].Filename, to)
+		}
+		return nil
+	case DELETE, MKDIR, SETQUOTA, CREATESNAPSHOT, DELETESNAPSHOT, SETREP, SETTIMES, SETXATTR, REMOVEXATTR,
+		CREATESYMLINK, STORAGEPOLICY:
+	default:
+		// layouts, encryption zones, archives and cache directives are left
+		// to the destination's administrators
+		return nil
+	}
+	if !inside {
+		return nil
+	}
+	err := m.flush()
+	if err != nil {
+		return err
+	}
+
+	r := Packet{CMD: p.CMD, Flags: p.Flags, Message: p.Message, Headers: []BlockHeader{{Filename: dst}}}
+	for _, st := range p.Status {
+		st.Path = dst
+		r.Status = append(r.Status, st)
+	}
+	if p.CMD == CREATESYMLINK {
+		if target, ok := m.mapPath(p.Message); ok {
+			r.Message = target
+		}
+	}
+	_, err = m.replay(r)
+	return err
+}
+
+// replay sends a namespace request to the destination, and reports whether
+// the destination refused it. A refused request is counted and skipped.
+func (m *Mirror) replay(p Packet) (bool, error) {
+	p.SRC, p.DST = id, "NN"
+	r, err := roundTrip(p)
+	if err != nil {
+		return false, err
+	}
+	if r.CMD != ERROR {
+		return false, nil
+	}
+	m.mu.Lock()
+	m.stats.Refused++
+	m.stats.LastError = r.Message
+	m.mu.Unlock()
+	log.Println("Mirror could not apply an edit of ", p.Headers[0].Filename, ": ", r.Message)
+	return true, nil
+}
+
+// flush copies the files written on the source since the last namespace
+// change, so later changes find them
+func (m *Mirror) flush() error {
+	for path := range m.dirty {
+		dst, _ := m.mapPath(path)
+		err := m.mirrorPath(path, dst)
+		if err != nil {
+			return err
+		}
+		delete(m.dirty, path)
+	}
+	return nil
+}
+
+// mirrorPath copies the file or directory at the source path src to dst,
+// unless the source no longer has it
+func (m *Mirror) mirrorPath(src, dst string) error {
+	st, found, err := m.sourceStatus(src)
+	if err != nil || !found {
+		return err
+	}
+	if st.Type != "DIRECTORY" {
+		return m.mirrorFile(src, dst, st)
+	}
+
+	entries, err := webhdfsStore{m.Source}.walk(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.isDir {
+			err = Mkdir(joinCopyPath(dst, e.rel), true)
+		} else if st, found, err = m.sourceStatus(joinCopyPath(src, e.rel)); found {
+			err = m.mirrorFile(joinCopyPath(src, e.rel), joinCopyPath(dst, e.rel), st)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceStatus describes the source path p, and reports whether it exists
+func (m *Mirror) sourceStatus(p string) (webhdfsStatus, bool, error) {
+	source := webhdfsStore{m.Source}
+	resp, err := http.Get(source.url(p, "GETFILESTATUS"))
+	if err != nil {
+		return webhdfsStatus{}, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return webhdfsStatus{}, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return webhdfsStatus{}, false, webhdfsError(resp)
+	}
+	var st struct{ FileStatus webhdfsStatus }
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	return st.FileStatus, err == nil, err
+}
+
+// mirrorFile copies the source file src, described by st, to dst, unless
+// dst already holds that version. A destination file changed since the
+// source's version is a conflict, settled by the Conflict policy. The copy
+// is given the source's modification time, by which it is known later.
+func (m *Mirror) mirrorFile(src, dst string, st webhdfsStatus) error {
+	r, err := roundTrip(Packet{SRC: id, DST: "NN", CMD: STAT, Headers: []BlockHeader{{Filename: dst}}})
+	if err != nil {
+		return err
+	}
+	if r.CMD == STAT && len(r.Status) == 1 {
+		existing := r.Status[0]
+		if !existing.IsDir && existing.ModTime == st.ModificationTime && existing.Size == st.Length {
+			return nil
+		}
+		if existing.ModTime > st.ModificationTime {
+			m.mu.Lock()
+			m.stats.Conflicts++
+			m.mu.Unlock()
+			log.Println("Mirror found ", dst, " changed on both clusters, keeping the ", m.Conflict, "'s version")
+			switch m.Conflict {
+			case ConflictDestination:
+				return nil
+			case ConflictBoth:
+				err = Rename(dst, dst+".conflict")
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	err = copyFile(webhdfsStore{m.Source}, clusterStore{}, src, dst, st.Length)
+	if err != nil {
+		return fmt.Errorf("Could not copy %s: %v", src, err)
+	}
+	err = SetTimes(dst, time.Unix(0, st.ModificationTime*int64(time.Millisecond)), time.Time{})
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.stats.Files++
+	m.stats.Bytes += st.Length
+	m.mu.Unlock()
+	return nil
+}
+
+// ServeMetrics exports the Mirror's progress in the Prometheus text format
+func (m *Mirror) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	st := m.Stats()
+	for _, metric := range []struct {
+		name, kind, help string
+		value            interface{}
+	}{
+		{"godfs_mirror_seq", "gauge", "Last edit of the source applied.", st.Seq},
+		{"godfs_mirror_source_seq", "gauge", "Newest edit of the source.", st.SourceSeq},
+		{"godfs_mirror_lag_edits", "gauge", "Edits of the source not yet applied.", st.SourceSeq - st.Seq},
+		{"godfs_mirror_lag_seconds", "gauge", "Time since the mirror was last caught up with the source.", st.Lag.Seconds()},
+		{"godfs_mirror_files_total", "counter", "Files copied.", st.Files},
+		{"godfs_mirror_bytes_total", "counter", "Bytes copied.", st.Bytes},
+		{"godfs_mirror_conflicts_total", "counter", "Files changed on both clusters.", st.Conflicts},
+		{"godfs_mirror_refused_total", "counter", "Edits the destination refused.", st.Refused},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value)
+	}
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// mount routes the requests on the paths at or below Prefix to the
+// namenodes of another namespace
+type mount struct {
+	Prefix    string
+	Addresses []string // the active and standby namenodes of the namespace
+	active    string   // the namenode last connected to
+}
+
+var mounts []*mount        // the mount table, longest prefix first
+var mounted *mount         // mount of the namespace connected to, nil for the default namespace
+var mountLock sync.RWMutex // held for reading by requests on the namespace connected to, and for writing to connect to another
+var rootNamenode string    // namenode of the default namespace last connected to, while on another
+var rootNamenodes []string // namenodes of the default namespace, while on another
+
+// parseMount parses a mount table entry of the form
+// /prefix=host:port[,host:port], adding it to the table or replacing the
+// entry of the same prefix
+func parseMount(value string) error {
+	i := strings.Index(value, "=")
+	if i < 0 {
+		return errors.New("Mount must be given as /prefix=host:port " + value)
+	}
+	prefix := strings.TrimSpace(value[:i])
+	if !path.IsAbs(prefix) || path.Clean(prefix) != prefix || prefix == "/" {
+		return errors.New("Invalid mount point " + prefix)
+	}
+	m := &mount{Prefix: prefix}
+	for _, a := range strings.Split(value[i+1:], ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			m.Addresses = append(m.Addresses, a)
+		}
+	}
+	if len(m.Addresses) == 0 {
+		return errors.New("No namenode given for mount point " + prefix)
+	}
+
+	table := make([]*mount, 0, len(mounts)+1)
+	for _, other := range mounts {
+		if other.Prefix != prefix {
+			table = append(table, other)
+		}
+	}
+	table = append(table, m)
+	sort.SliceStable(table, func(i, j int) bool { return len(table[i].Prefix) > len(table[j].Prefix) })
+	mounts = table
+	return nil
+}
+
+// mountOf returns the mount of the namespace holding name, nil for the
+// default namespace
+func mountOf(name string) *mount {
+	for _, m := range mounts {
+		if name == m.Prefix || strings.HasPrefix(name, m.Prefix+"/") {
+			return m
+		}
+	}
+	return nil
+}
+
+// requestNamespace returns the mount of the namespace p is made of, from the
+// path it names, or sets stay if p belongs to the connection it is sent on.
+// Requests naming no path are made of the default namespace. A request
+// naming paths of two namespaces, such as a rename from one to another,
+// cannot be made.
+func requestNamespace(p Packet) (m *mount, stay bool, err error) {
+	names := make([]string, 0, 2)
+	switch p.CMD {
+	case HB, UNSUBSCRIBE:
+		return nil, true, nil
+	case DISTRIBUTE:
+		names = append(names, p.Data.Header.Filename)
+	case BATCH:
+		for _, c := range p.Commands {
+			names = appendNames(appendNames(names, c.Headers), c.Renamed)
+		}
+	}
+	names = appendNames(appendNames(names, p.Headers), p.Renamed)
+	if len(names) == 0 {
+		return nil, false, nil
+	}
+	m = mountOf(names[0])
+	for _, name := range names[1:] {
+		if mountOf(name) != m {
+			return nil, false, errors.New("Paths of different mount points cannot be used in one request " + names[0] + " " + name)
+		}
+	}
+	return m, false, nil
+}
+
+// appendNames appends the paths of headers to names
+func appendNames(names []string, headers []BlockHeader) []string {
+	for _, h := range headers {
+		names = append(names, h.Filename)
+	}
+	return names
+}
+
+// enterNamespace connects to the namespace p is made of, unless it is the
+// one connected to, once the requests on the one connected to are done. It
+// returns holding mountLock for reading, released by calling leave, so the
+// client stays on the namespace until p is answered.
+func enterNamespace(p Packet) (leave func(), err error) {
+	m, stay, err := requestNamespace(p)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		mountLock.RLock()
+		if stay || mounted == m {
+			return mountLock.RUnlock, nil
+		}
+		mountLock.RUnlock()
+
+		mountLock.Lock()
+		if mounted != m {
+			err = switchNamespace(m)
+		}
+		mountLock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// switchNamespace replaces the connection with one to the namenodes of the
+// namespace of m, starting with the one last connected to. Subscriptions
+// end with the connection they were made on. The caller must hold mountLock
+// for writing.
+func switchNamespace(m *mount) error {
+	reconnectLock.Lock()
+	defer reconnectLock.Unlock()
+
+	configured, active := rootNamenodes, rootNamenode
+	if m != nil {
+		configured, active = m.Addresses, m.active
+	}
+	addresses := configured
+	if len(addresses) == 0 {
+		addresses = []string{active}
+	}
+	start := 0
+	for i, a := range addresses {
+		if a == active {
+			start = i
+		}
+	}
+
+	var err error
+	for i := range addresses {
+		a := addresses[(start+i)%len(addresses)]
+		err = dial(a)
+		if err != nil {
+			continue
+		}
+		if mounted == nil {
+			rootNamenode, rootNamenodes = namenodeAddress, namenodeAddresses
+		} else {
+			mounted.active = namenodeAddress
+		}
+		namenodeAddress, namenodeAddresses, mounted = a, configured, m
+		if m != nil {
+			m.active = a
+		}
+		return nil
+	}
+	return err
+}
+
+// mountPoints describes the mount points directly below the directory dir
+// which are missing from its entries
+func mountPoints(dir string, entries []FileStatus) []FileStatus {
+	listed := make(map[string]bool, len(entries))
+	for _, st := range entries {
+		listed[st.Path] = true
+	}
+	points := make([]FileStatus, 0)
+	for _, m := range mounts {
+		if path.Dir(m.Prefix) == path.Clean(dir) && !listed[m.Prefix] {
+			points = append(points, FileStatus{Path: m.Prefix, IsDir: true})
+		}
+	}
+	return points
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestMountTable(t *testing.T) {
+
+	defer func() { mounts = nil }()
+	for _, value := range []string{"/projects=nn2:8080", "/projects/big=nn3:8080, nn3b:8080", "/projects=nn4:8080"} {
+		if err := parseMount(value); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	for _, value := range []string{"/projects", "relative=nn2:8080", "/=nn2:8080", "/a/../b=nn2:8080", "/empty="} {
+		if parseMount(value) == nil {
+			t.Errorf("Accepted mount %q", value)
+		}
+	}
+	if len(mounts) != 2 || mounts[0].Prefix != "/projects/big" || len(mounts[0].Addresses) != 2 || mounts[1].Addresses[0] != "nn4:8080" {
+		t.Fatalf("Unexpected mount table %+v %+v", mounts[0], mounts[1])
+	}
+
+	for name, prefix := range map[string]string{"/projects": "/projects", "/projects/a.txt": "/projects", "/projects/big/b.txt": "/projects/big",
+		"/projectsx": "", "/": "", "": ""} {
+		m := mountOf(name)
+		if (m == nil && prefix != "") || (m != nil && m.Prefix != prefix) {
+			t.Errorf("%s mounted from %+v, expected %q", name, m, prefix)
+		}
+	}
+
+	rename := Packet{CMD: RENAME, Headers: []BlockHeader{{Filename: "/projects/a"}}, Renamed: []BlockHeader{{Filename: "/b"}}}
+	if _, _, err := requestNamespace(rename); err == nil {
+		t.Errorf("Renamed across mount points")
+	}
+	batch := Packet{CMD: BATCH, Commands: []Packet{{CMD: STAT, Headers: []BlockHeader{{Filename: "/projects/a"}}}, {CMD: STAT, Headers: []BlockHeader{{Filename: "/projects/big/b"}}}}}
+	if _, _, err := requestNamespace(batch); err == nil {
+		t.Errorf("Batched requests across mount points")
+	}
+	write := Packet{CMD: DISTRIBUTE, Data: Block{Header: BlockHeader{Filename: "/projects/big/c"}}}
+	if m, _, err := requestNamespace(write); err != nil || m != mounts[0] {
+		t.Errorf("Block written to %+v %v", m, err)
+	}
+	if m, stay, err := requestNamespace(Packet{CMD: REPORT}); m != nil || stay || err != nil {
+		t.Errorf("Request without a path made of %+v", m)
+	}
+	if _, stay, _ := requestNamespace(Packet{CMD: HB}); !stay {
+		t.Errorf("Heartbeat moved to another namespace")
+	}
+}
+
+func TestMountSwitch(t *testing.T) {
+
+	format := wireFormat
+	defer func() {
+		mounts, mounted, rootNamenode, rootNamenodes = nil, nil, "", nil
+		namenodeAddress, namenodeAddresses, wireFormat = "", nil, format
+	}()
+	wireFormat = "json"
+
+	// each namenode answers with its number in the Size of the paths asked
+	// about, and lists the namespace's root with one directory
+	serve := func(l net.Listener, n int64) {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				dec, enc := json.NewDecoder(c), json.NewEncoder(c)
+				var p Packet
+				dec.Decode(&p)
+				enc.Encode(Packet{SRC: "NN", DST: "C", CMD: HELLO, Hello: &Hello{Version: protocolVersion, MinVersion: protocolVersion}})
+				for dec.Decode(&p) == nil {
+					st := FileStatus{Path: p.Headers[0].Filename, Size: n}
+					if p.CMD == LISTDIR {
+						st = FileStatus{Path: "/home", IsDir: true}
+					}
+					enc.Encode(Packet{SRC: "NN", DST: "C", CMD: p.CMD, RequestID: p.RequestID, Status: []FileStatus{st}})
+				}
+			}()
+		}
+	}
+	var addresses []string
+	for n := int64(1); n <= 2; n++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		defer l.Close()
+		go serve(l, n)
+		addresses = append(addresses, l.Addr().String())
+	}
+	if err := parseMount("/projects=" + addresses[1]); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if err := Connect(addresses[0]); err != nil {
+		t.Fatalf("%s", err)
+	}
+	for _, c := range []struct {
+		path string
+		n    int64
+	}{
+		{"/a.txt", 1}, {"/projects/b.txt", 2}, {"/projects/c.txt", 2}, {"/d.txt", 1}, {"/projects", 2},
+	} {
+		st, err := Stat(c.path)
+		if err != nil || st.Size != c.n {
+			t.Errorf("%s stat by namenode %d, expected %d %v", c.path, st.Size, c.n, err)
+		}
+	}
+	if namenodeAddress != addresses[1] || mounted != mounts[0] || rootNamenode != addresses[0] {
+		t.Errorf("Connected to %s of %+v, with the default namespace at %s", namenodeAddress, mounted, rootNamenode)
+	}
+
+	list, err := ListDir("/", false)
+	if err != nil || len(list) != 2 || list[0].Path != "/home" || list[1].Path != "/projects" || !list[1].IsDir {
+		t.Errorf("Mount point not listed %+v %v", list, err)
+	}
+	if namenodeAddress != addresses[0] || mounted != nil {
+		t.Errorf("Listed the root of %s", namenodeAddress)
+	}
+	if err := Rename("/projects/b.txt", "/b.txt"); err == nil {
+		t.Errorf("Renamed across mount points")
+	}
+}
@@ -0,0 +1,441 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var leasedLock sync.Mutex
+var leased = make(map[string]bool) // paths this client holds the lease on, each written by one writer at a time
+
+// request sends a namespace request for path to the namenode and returns its
+// response, converting ERROR responses to errors
+func request(cmd int, path string, flags int) (Packet, error) {
+	p := new(Packet)
+	p.DST = "NN"
+	p.SRC = id
+	p.CMD = cmd
+	p.Flags = flags
+	p.Headers = []BlockHeader{{Filename: path}}
+
+	r, err := roundTrip(*p)
+	if err != nil {
+		return r, err
+	}
+
+	if r.CMD == ERROR {
+		return r, responseError(r)
+	}
+	return r, nil
+}
+
+// Stat describes the file or directory at path
+func Stat(path string) (FileStatus, error) {
+	r, err := request(STAT, path, 0)
+	if err != nil {
+		return FileStatus{}, err
+	}
+	if r.CMD != STAT || len(r.Status) != 1 {
+		return FileStatus{}, fmt.Errorf("Bad response packet %v", r)
+	}
+	return r.Status[0], nil
+}
+
+// ListDir describes the entries of the directory at path, including the
+// contents of subdirectories if recursive is set. Mount points directly below
+// the directory are listed as directories.
+func ListDir(path string, recursive bool) ([]FileStatus, error) {
+	flags := 0
+	if recursive {
+		flags |= RECURSIVE
+	}
+	r, err := request(LISTDIR, path, flags)
+	if err != nil {
+		return nil, err
+	}
+	if r.CMD != LISTDIR {
+		return nil, fmt.Errorf("Bad response packet %v", r)
+	}
+	if points := mountPoints(path, r.Status); len(points) > 0 && !recursive {
+		// mount points are shown as directories of the namespace holding them
+		r.Status = append(r.Status, points...)
+		sort.SliceStable(r.Status, func(i, j int) bool { return r.Status[i].Path < r.Status[j].Path })
+	}
+	return r.Status, nil
+}
+
+// Glob describes the files and directories matching pattern, in order of
+// path. Each component of pattern may use the wildcards of path.Match, such
+// as /logs/2024-*/part-*.
+func Glob(pattern string) ([]FileStatus, error) {
+	r, err := request(GLOB, pattern, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.CMD != GLOB {
+		return nil, fmt.Errorf("Bad response packet %v", r)
+	}
+	return r.Status, nil
+}
+
+// Mkdir creates the directory at path, and any missing parents if parents is set
+func Mkdir(path string, parents bool) error {
+	flags := 0
+	if parents {
+		flags |= PARENTS
+	}
+	r, err := request(MKDIR, path, flags)
+	if err != nil {
+		return err
+	}
+	if r.CMD != ACK {
+		return fmt.Errorf("Bad response packet %v", r)
+	}
+	return nil
+}
+
+// Delete removes the file or directory at path. Directories that are not
+// empty are only removed if recursive is set. When the namenode keeps a trash
+// the path is moved there, unless skipTrash is set.
+func Delete(path string, recursive, skipTrash bool) error {
+	flags := 0
+	if recursive {
+		flags |= RECURSIVE
+	}
+	if skipTrash {
+		flags |= SKIPTRASH
+	}
+	r, err := request(DELETE, path, flags)
+	if err != nil {
+		return err
+	}
+	if r.CMD != ACK {
+		return fmt.Errorf("Bad response packet %v", r)
+	}
+	if r.Message != "" {
+		fmt.Println(r.Message)
+	}
+	return nil
+}
+
+// Rename moves the file or directory at src to dst
+func Rename(src, dst string) error {
+	p := Packet{SRC: id, DST: "NN", CMD: RENAME}
+	p.Headers = []BlockHeader{{Filename: src}}
+	p.Renamed = []BlockHeader{{Filename: dst}}
+	return send(p)
+}
+
+// CreateSymlink creates a symbolic link at link to the absolute path target,
+// and any missing parents of link if parents is set. Requests naming a path
+// through the link act on target, except for deleting, renaming and reading
+// the link itself.
+func CreateSymlink(target, link string, parents bool) error {
+	p := Packet{SRC: id, DST: "NN", CMD: CREATESYMLINK, Message: target}
+	if parents {
+		p.Flags |= PARENTS
+	}
+	p.Headers = []BlockHeader{{Filename: link}}
+	return send(p)
+}
+
+// Readlink returns the target of the symbolic link at path
+func Readlink(path string) (string, error) {
+	r, err := request(READLINK, path, 0)
+	if err != nil {
+		return "", err
+	}
+	if r.CMD != READLINK {
+		return "", fmt.Errorf("Bad response packet %v", r)
+	}
+	return r.Message, nil
+}
+
+// Truncate shortens the file at path to length bytes, which must not be
+// more than its size
+func Truncate(path string, length int64) error {
+	p := Packet{SRC: id, DST: "NN", CMD: TRUNCATE, Message: strconv.FormatInt(length, 10)}
+	p.Headers = []BlockHeader{{Filename: path}}
+	return send(p)
+}
+
+// SetTimes sets the modification and access times of the file or directory
+// at path. A zero time is left as it is.
+func SetTimes(path string, mtime, atime time.Time) error {
+	st := FileStatus{Path: path, ModTime: -1, AccessTime: -1}
+	if !mtime.IsZero() {
+		st.ModTime = mtime.UnixNano() / int64(time.Millisecond)
+	}
+	if !atime.IsZero() {
+		st.AccessTime = atime.UnixNano() / int64(time.Millisecond)
+	}
+	p := Packet{SRC: id, DST: "NN", CMD: SETTIMES, Status: []FileStatus{st}}
+	p.Headers = []BlockHeader{{Filename: path}}
+	return send(p)
+}
+
+// SetQuota limits the number of files and bytes of file data below the
+// directory at path. A limit of 0 removes it.
+func SetQuota(path string, files int, space int64) error {
+	p := Packet{SRC: id, DST: "NN", CMD: SETQUOTA}
+	p.Headers = []BlockHeader{{Filename: path}}
+	p.Status = []FileStatus{{Path: path, IsDir: true, FileQuota: files, SpaceQuota: space}}
+	return send(p)
+}
+
+// send sends a request to the namenode which is answered with an ACK
+func send(p Packet) error {
+	r, err := roundTrip(p)
+	if err != nil {
+		return err
+	}
+	if r.CMD == ERROR {
+		return responseError(r)
+	}
+	if r.CMD != ACK {
+		return fmt.Errorf("Bad response packet %v", r)
+	}
+	return nil
+}
+
+// GetQuota describes the quotas and usage of the directory at path
+func GetQuota(path string) (FileStatus, error) {
+	r, err := request(GETQUOTA, path, 0)
+	if err != nil {
+		return FileStatus{}, err
+	}
+	if r.CMD != GETQUOTA || len(r.Status) != 1 {
+		return FileStatus{}, fmt.Errorf("Bad response packet %v", r)
+	}
+	return r.Status[0], nil
+}
+
+// CreateSnapshot captures the directory at dir as the snapshot name, which
+// is read at dir/.snapshot/name
+func CreateSnapshot(dir, name string) error {
+	p := Packet{SRC: id, DST: "NN", CMD: CREATESNAPSHOT, Message: name}
+	p.Headers = []BlockHeader{{Filename: dir}}
+	return send(p)
+}
+
+// DeleteSnapshot deletes the snapshot name of the directory at dir
+func DeleteSnapshot(dir, name string) error {
+	p := Packet{SRC: id, DST: "NN", CMD: DELETESNAPSHOT, Message: name}
+	p.Headers = []BlockHeader{{Filename: dir}}
+	return send(p)
+}
+
+// ListSnapshots describes the snapshots of the directory at dir
+func ListSnapshots(dir string) ([]FileStatus, error) {
+	r, err := request(LISTSNAPSHOT, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.CMD != LISTSNAPSHOT {
+		return nil, fmt.Errorf("Bad response packet %v", r)
+	}
+	return r.Status, nil
+}
+
+// SnapshotDiff describes the paths below the directory at dir which changed
+// between its snapshots from and to, or between from and the directory as it
+// is now if to is empty, ordered by path. Change is + for a created path, -
+// for a deleted one, M for a file whose data changed and R for a path
+// renamed from Source. A renamed or deleted directory stands for the paths
+// below it.
+func SnapshotDiff(dir, from, to string) ([]FileStatus, error) {
+	p := Packet{SRC: id, DST: "NN", CMD: SNAPSHOTDIFF, Message: from}
+	if to != "" {
+		p.Message += "/" + to
+	}
+	p.Headers = []BlockHeader{{Filename: dir}}
+	r, err := roundTrip(p)
+	if err != nil {
+		return nil, err
+	}
+	if r.CMD == ERROR {
+		return nil, responseError(r)
+	}
+	if r.CMD != SNAPSHOTDIFF {
+		return nil, fmt.Errorf("Bad response packet %v", r)
+	}
+	return r.Status, nil
+}
+
+// Decommission asks the namenode to drain the datanode id and remove it from
+// the cluster, returning the progress of the decommission
+func Decommission(datanodeID string) (string, error) {
+	return admin(Packet{SRC: id, DST: "NN", CMD: DECOMMISSION, Message: datanodeID})
+}
+
+// Balance asks the namenode to move Blocks between datanodes until they store
+// similar amounts, moving at most bandwidth bytes per second or the
+// namenode's configured bandwidth if it is 0. It returns the progress of the
+// balancer.
+func Balance(bandwidth int64) (string, error) {
+	return admin(Packet{SRC: id, DST: "NN", CMD: BALANCE, Message: strconv.FormatInt(bandwidth, 10)})
+}
+
+// SetReplication changes the replication factor of the file at path, or of
+// the files below the directory at path, to n. The namenode copies or
+// deletes replicas in the background.
+func SetReplication(path string, n int) (string, error) {
+	p := Packet{SRC: id, DST: "NN", CMD: SETREP, Message: strconv.Itoa(n)}
+	p.Headers = []BlockHeader{{Filename: path}}
+	return admin(p)
+}
+
+// Report describes the datanodes and the namespace
+func Report() (string, error) {
+	return admin(Packet{SRC: id, DST: "NN", CMD: REPORT})
+}
+
+// SafeMode enters or leaves the namenode's safe mode, in which the namespace
+// is read-only, or reports whether it is on, as action is enter, leave or get
+func SafeMode(action string) (string, error) {
+	return admin(Packet{SRC: id, DST: "NN", CMD: SAFEMODE, Message: action})
+}
+
+// RefreshNodes asks the namenode to reload its include and exclude files and
+// the racks of the datanodes
+func RefreshNodes() (string, error) {
+	return admin(Packet{SRC: id, DST: "NN", CMD: REFRESHNODES})
+}
+
+// Tenants describes the files, bytes and requests of each tenant of the
+// namenode against its limits, once the namenode reloads its tenants file if
+// action is refresh
+func Tenants(action string) (string, error) {
+	return admin(Packet{SRC: id, DST: "NN", CMD: TENANTS, Message: action})
+}
+
+// SetBlockSize changes the default size of the Blocks of new files to n bytes,
+// used by clients without a configured block size
+func SetBlockSize(n int) (string, error) {
+	return admin(Packet{SRC: id, DST: "NN", CMD: SETBLOCKSIZE, Message: strconv.Itoa(n)})
+}
+
+// ListOpenLeases describes the leases on the files being written, with their
+// writers, how long they have held them and the Blocks written so far
+func ListOpenLeases() (string, error) {
+	return admin(Packet{SRC: id, DST: "NN", CMD: LISTLEASES})
+}
+
+// RecoverLease takes the lease on the file at path from its writer at once,
+// for a file left open by a writer which crashed. A file left without all of
+// its Blocks is deleted.
+func RecoverLease(path string) (string, error) {
+	p := Packet{SRC: id, DST: "NN", CMD: RECOVERLEASE}
+	p.Headers = []BlockHeader{{Filename: path}}
+	return admin(p)
+}
+
+// TriggerBlockReport asks the datanode datanodeID, or every datanode if it is
+// empty, for a full block report
+func TriggerBlockReport(datanodeID string) (string, error) {
+	return admin(Packet{SRC: id, DST: "NN", CMD: TRIGGERREPORT, Message: datanodeID})
+}
+
+// ShutdownDatanode asks the datanode datanodeID to stop, with the answer to
+// its next heartbeat
+func ShutdownDatanode(datanodeID string) (string, error) {
+	return admin(Packet{SRC: id, DST: "NN", CMD: SHUTDOWN, Message: datanodeID})
+}
+
+// SetTransferBandwidth caps the bytes per second each datanode sends copying
+// replicas to other datanodes, or lifts the cap if bandwidth is 0
+func SetTransferBandwidth(bandwidth int64) (string, error) {
+	return admin(Packet{SRC: id, DST: "NN", CMD: SETBANDWIDTH, Message: strconv.FormatInt(bandwidth, 10)})
+}
+
+// HAState makes the namenode connected to the active or the standby, as
+// action is active or standby, or reports which it is if action is empty
+func HAState(action string) (string, error) {
+	return admin(Packet{SRC: id, DST: "NN", CMD: HASTATE, Message: action})
+}
+
+// admin sends an administrative request to the namenode, returning the
+// message of its ACK
+func admin(p Packet) (string, error) {
+	r, err := roundTrip(p)
+	if err != nil {
+		return "", err
+	}
+	if r.CMD == ERROR {
+		return "", responseError(r)
+	}
+	if r.CMD != ACK {
+		return "", fmt.Errorf("Bad response packet %v", r)
+	}
+	return r.Message, nil
+}
+
+// acquireLease takes the lease on the file at path, which is needed to write
+// it, creating or replacing the file as the create flags allow and asking
+// for the block size and replication in layout where they are set. A file in
+// an encryption zone is given a data key. A second writer of a path this
+// client is writing fails with ErrWriteConflict, as the namenode cannot tell
+// it from the first.
+func acquireLease(path string, flags int, layout FileStatus) error {
+	err := holdLease(path)
+	if err != nil {
+		return err
+	}
+
+	p := Packet{SRC: id, DST: "NN", CMD: LEASE, Message: holder, Flags: flags}
+	p.Headers = []BlockHeader{{Filename: path}}
+	if layout.BlockSize != 0 || layout.Replicas != 0 {
+		p.Status = []FileStatus{layout}
+	}
+	r, err := roundTrip(p)
+	if err == nil && r.CMD == ERROR {
+		err = responseError(r)
+	} else if err == nil && r.CMD != ACK {
+		err = fmt.Errorf("Bad response packet %v", r)
+	}
+	if err != nil {
+		forgetLease(path)
+		return err
+	}
+	if len(r.Status) == 1 && r.Status[0].Zone != "" {
+		err = createFileKey(path, r.Status[0].Zone)
+		if err != nil {
+			releaseLease(path)
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseLease gives up the lease on the file at path once it is written
+func releaseLease(path string) error {
+	releaseKey(path)
+	p := Packet{SRC: id, DST: "NN", CMD: RELEASE, Message: holder}
+	p.Headers = []BlockHeader{{Filename: path}}
+	// the next writer's LEASE follows the RELEASE
+	err := send(p)
+	forgetLease(path)
+	return err
+}
+
+// holdLease claims path for a writer of this client, failing if another
+// is writing it
+func holdLease(path string) error {
+	leasedLock.Lock()
+	defer leasedLock.Unlock()
+	if leased[path] {
+		return &Error{Code: ErrWriteConflict.Code, Message: "File is being written by this client " + path}
+	}
+	leased[path] = true
+	return nil
+}
+
+// forgetLease lets another writer of this client write path
+func forgetLease(path string) {
+	leasedLock.Lock()
+	defer leasedLock.Unlock()
+	delete(leased, path)
+}
@@ -0,0 +1,292 @@
+package client
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config locates an S3 compatible object store holding backups
+type S3Config struct {
+	Endpoint  string // base URL of the object store, https://s3.REGION.amazonaws.com if empty
+	Region    string // region used to sign requests
+	AccessKey string // access key ID, requests are unsigned without one
+	SecretKey string // secret access key
+}
+
+var s3Config = S3Config{Region: "us-east-1"} // object store of s3:// and gs:// backups
+
+// the XML API of Google Cloud Storage, which takes S3 requests signed with
+// HMAC keys
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// size of the parts of a multipart upload, all but the last of which must
+// be at least 5 MB
+var uploadPartSize = 8 << 20
+
+// objectStore reads and writes the objects of a bucket of an S3 compatible
+// store, addressed by path
+type objectStore struct {
+	endpoint string
+	bucket   string
+	client   *http.Client
+}
+
+// parseObjectURL returns the store and key of an s3://bucket/key or
+// gs://bucket/key URL
+func parseObjectURL(u string) (*objectStore, string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, "", err
+	}
+	key := strings.TrimPrefix(parsed.Path, "/")
+	if parsed.Host == "" || key == "" {
+		return nil, "", errors.New("Invalid object URL " + u + ", expected s3://bucket/key or gs://bucket/key")
+	}
+	endpoint := s3Config.Endpoint
+	switch {
+	case parsed.Scheme == "gs":
+		endpoint = gcsEndpoint
+	case endpoint == "":
+		endpoint = "https://s3." + s3Config.Region + ".amazonaws.com"
+	}
+	return &objectStore{strings.TrimSuffix(endpoint, "/"), parsed.Host, &http.Client{Timeout: 10 * time.Minute}}, key, nil
+}
+
+// open streams the object key
+func (s *objectStore) open(key string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// create returns a writer uploading the object key in parts, which holds
+// the data written once it is closed
+func (s *objectStore) create(key string) (io.WriteCloser, error) {
+	resp, err := s.do("POST", key, url.Values{"uploads": {""}}, []byte{})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		UploadId string
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &objectWriter{store: s, key: key, uploadID: result.UploadId}, nil
+}
+
+// completedPart names an uploaded part of an object
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// objectWriter uploads the data written to it as the parts of an object
+type objectWriter struct {
+	store    *objectStore
+	key      string
+	uploadID string
+	parts    []completedPart
+	buf      bytes.Buffer
+	err      error
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf.Write(p)
+	for w.buf.Len() >= uploadPartSize && w.err == nil {
+		w.err = w.upload(w.buf.Next(uploadPartSize))
+	}
+	if w.err != nil {
+		w.abort()
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+// upload sends the next part of the object
+func (w *objectWriter) upload(part []byte) error {
+	n := len(w.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {w.uploadID}}
+	resp, err := w.store.do("PUT", w.key, query, part)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	w.parts = append(w.parts, completedPart{n, resp.Header.Get("ETag")})
+	return nil
+}
+
+// Close uploads the last part and completes the object
+func (w *objectWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.buf.Len() > 0 || len(w.parts) == 0 {
+		w.err = w.upload(w.buf.Bytes())
+		if w.err != nil {
+			w.abort()
+			return w.err
+		}
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	resp, err := w.store.do("POST", w.key, url.Values{"uploadId": {w.uploadID}}, body)
+	if err != nil {
+		w.abort()
+		return err
+	}
+	resp.Body.Close()
+	w.err = errors.New("Object " + w.key + " is closed")
+	return nil
+}
+
+// abort drops the parts uploaded of a failed object
+func (w *objectWriter) abort() {
+	resp, err := w.store.do("DELETE", w.key, url.Values{"uploadId": {w.uploadID}}, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+// do sends a signed request for an object key. A missing object gives
+// os.ErrNotExist, and any other failure status an error with the response
+// body.
+func (s *objectStore) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	escapedPath := "/" + s.bucket + "/" + awsEscape(key, false)
+	u := s.endpoint + escapedPath
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	signRequest(req, escapedPath, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, errors.New("Object store " + method + " " + key + " failed: " + resp.Status + " " + string(msg))
+	}
+	return resp, nil
+}
+
+// signRequest adds AWS Signature Version 4 headers to a request for the
+// escaped path
+func signRequest(req *http.Request, escapedPath string, body []byte, now time.Time) {
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	if s3Config.AccessKey == "" {
+		return
+	}
+
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		escapedPath,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+	date := now.Format("20060102")
+	scope := date + "/" + s3Config.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+s3Config.SecretKey), date)
+	key = hmacSHA256(key, s3Config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s3Config.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// awsEscape percent encodes all but the unreserved characters of s, and
+// slashes unless escapeSlash is set, as signed requests require
+func awsEscape(s string, escapeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !escapeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes a query string sorted by key, as signed requests
+// require
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 keeps the objects of an S3 compatible store in memory
+type fakeS3 struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+	parts   map[string][][]byte
+	signed  bool
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		s.signed = false
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	q := r.URL.Query()
+	key := r.URL.Path
+	switch {
+	case r.Method == "POST" && q.Get("uploads") == "" && len(q["uploads"]) == 1:
+		s.parts[key] = nil
+		w.Write([]byte("<InitiateMultipartUploadResult><UploadId>" + key + "</UploadId></InitiateMultipartUploadResult>"))
+	case r.Method == "PUT" && q.Get("uploadId") == key:
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		s.parts[key] = append(s.parts[key], body)
+		w.Header().Set("ETag", strconv.Itoa(n))
+	case r.Method == "POST" && q.Get("uploadId") == key:
+		var complete struct {
+			Parts []completedPart `xml:"Part"`
+		}
+		xml.Unmarshal(body, &complete)
+		data := make([]byte, 0)
+		for i, part := range complete.Parts {
+			if part.ETag != strconv.Itoa(i+1) {
+				http.Error(w, "bad part", http.StatusBadRequest)
+				return
+			}
+			data = append(data, s.parts[key][part.PartNumber-1]...)
+		}
+		s.objects[key] = data
+	case r.Method == "DELETE":
+		delete(s.parts, key)
+	case r.Method == "GET":
+		data, ok := s.objects[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestObjectStore(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte), parts: make(map[string][][]byte), signed: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	saved, savedSize := s3Config, uploadPartSize
+	defer func() { s3Config, uploadPartSize = saved, savedSize }()
+	s3Config = S3Config{Endpoint: server.URL, Region: "us-east-1", AccessKey: "key", SecretKey: "secret"}
+	uploadPartSize = 1000
+
+	// objects are uploaded in parts and read back whole
+	data := bytes.Repeat([]byte("0123456789"), 250)
+	w, err := createBackup("s3://bucket/backups/a b.tar")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for i := 0; i < len(data); i += 300 {
+		end := i + 300
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := w.Write(data[i:end]); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if n := len(fake.parts["/bucket/backups/a b.tar"]); n != 3 {
+		t.Errorf("Uploaded %d parts, expected 3", n)
+	}
+	r, err := openBackup("s3://bucket/backups/a b.tar")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	read, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(read, data) {
+		t.Errorf("Read %d bytes back, expected %d %v", len(read), len(data), err)
+	}
+	if !fake.signed {
+		t.Errorf("Requests were not signed")
+	}
+
+	// an empty object is uploaded as a single empty part
+	w, err = createBackup("s3://bucket/empty")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if data, ok := fake.objects["/bucket/empty"]; !ok || len(data) != 0 {
+		t.Errorf("Empty object stored as %q %v", data, ok)
+	}
+
+	if _, err := openBackup("s3://bucket/missing"); err != os.ErrNotExist {
+		t.Errorf("Opened a missing object %v", err)
+	}
+	if _, err := openBackup("s3://bucket"); err == nil {
+		t.Errorf("Opened an object URL without a key")
+	}
+	if _, err := openBackup("ftp://bucket/key"); err == nil {
+		t.Errorf("Opened an unknown backup location")
+	}
+}
+
+func TestAWSEscape(t *testing.T) {
+	if s := awsEscape("a b/c~d+e", false); s != "a%20b/c~d%2Be" {
+		t.Errorf("Escaped path as %s", s)
+	}
+	if s := awsEscape("a/b", true); s != "a%2Fb" {
+		t.Errorf("Escaped query value as %s", s)
+	}
+	if q := canonicalQuery(map[string][]string{"uploadId": {"x y"}, "partNumber": {"2"}}); q != "partNumber=2&uploadId=x%20y" {
+		t.Errorf("Canonical query %s", q)
+	}
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"sync"
+)
+
+var parallelism = 4       // Blocks transferred at once by a single upload or download
+var headerPageSize = 1024 // headers asked of the namenode at once by a download
+
+// pipeline distributes Blocks with up to parallelism DISTRIBUTE requests
+// waiting for their ACK, rather than one at a time
+type pipeline struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	err   error // first failure
+
+	progress *transfer // told of each Block acknowledged, if set
+}
+
+func newPipeline() *pipeline {
+	return &pipeline{slots: make(chan struct{}, parallelism)}
+}
+
+// distribute sends b once a slot is free, returning the failure of an
+// earlier Block if there was one
+func (pl *pipeline) distribute(b Block) error {
+	return pl.distributeFlags(b, 0)
+}
+
+// distributeFlags sends b as distribute does, with the DISTRIBUTE flags
+func (pl *pipeline) distributeFlags(b Block, flags int) error {
+	pl.slots <- struct{}{}
+	if err := pl.failure(); err != nil {
+		<-pl.slots
+		return err
+	}
+	pl.wg.Add(1)
+	go func() {
+		defer pl.wg.Done()
+		err := distributeBlock(b, flags)
+		<-pl.slots
+		if err == nil {
+			pl.progress.block(len(b.Data))
+		} else {
+			pl.mu.Lock()
+			if pl.err == nil {
+				pl.err = err
+			}
+			pl.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (pl *pipeline) failure() error {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return pl.err
+}
+
+// wait returns once every Block has been acknowledged, with the first
+// failure if any
+func (pl *pipeline) wait() error {
+	pl.wg.Wait()
+	return pl.failure()
+}
+
+// retrieveBlocks retrieves the Blocks of headers, up to parallelism at
+// once, and passes them to write in the order of headers. Only parallelism
+// Blocks are held at a time.
+func retrieveBlocks(headers []BlockHeader, write func(Block) error) error {
+	type result struct {
+		b   Block
+		err error
+	}
+	results := make([]chan result, len(headers))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	slots := make(chan struct{}, parallelism)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		for i, h := range headers {
+			select {
+			case slots <- struct{}{}:
+			case <-stop:
+				return
+			}
+			go func(i int, h BlockHeader) {
+				b, err := retrieveBlock(h)
+				results[i] <- result{b, err}
+			}(i, h)
+		}
+	}()
+
+	for i := range headers {
+		r := <-results[i]
+		<-slots
+		if r.err != nil {
+			return r.err
+		}
+		err := write(r.b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// headerPage is a page of headers of a file, or the failure to get it
+type headerPage struct {
+	r   Packet
+	err error
+}
+
+// prefetchHeaders asks the namenode for the pages of headers of remotename
+// after first, one page ahead of the reader, and sends them in order until
+// the last page, a failure, or stop is closed. Files still being written
+// have no more pages.
+func prefetchHeaders(remotename string, first Packet, stop chan struct{}) chan headerPage {
+	pages := make(chan headerPage, 1)
+	go func() {
+		defer close(pages)
+		if writing(first) {
+			return
+		}
+		numBlocks := first.Headers[0].NumBlocks
+		for offset := first.Offset + len(first.Headers); offset < numBlocks; {
+			r, err := fileHeaderPage(remotename, offset)
+			select {
+			case pages <- headerPage{r, err}:
+			case <-stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+			offset = r.Offset + len(r.Headers)
+		}
+	}()
+	return pages
+}
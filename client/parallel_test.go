@@ -0,0 +1,166 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+// batchingNamenode only answers once parallelism requests are waiting, then
+// answers them newest first, so a client sending one request at a time or
+// assembling Blocks in the order they arrive fails
+func batchingNamenode(conn net.Conn, blocks map[int]Block) {
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+	var waiting []Packet
+	handled := make(map[int]int) // requests answered by command
+	for {
+		var p Packet
+		if err := dec.Decode(&p); err != nil {
+			return
+		}
+		r := Packet{SRC: "NN", DST: p.SRC, CMD: ACK, RequestID: p.RequestID}
+		switch p.CMD {
+		case GETHEADERS:
+			r.CMD = GETHEADERS
+			for num := 0; num < len(blocks); num++ {
+				r.Headers = append(r.Headers, blocks[num].Header)
+			}
+		case DISTRIBUTE, RETRIEVEBLOCK:
+			waiting = append(waiting, p)
+			total := p.Data.Header.NumBlocks
+			if p.CMD == RETRIEVEBLOCK {
+				total = p.Headers[0].NumBlocks
+			}
+			if len(waiting) < parallelism && handled[p.CMD]+len(waiting) < total {
+				continue
+			}
+			handled[p.CMD] += len(waiting)
+			for i := len(waiting) - 1; i >= 0; i-- {
+				w := waiting[i]
+				r := Packet{SRC: "NN", DST: w.SRC, CMD: ACK, RequestID: w.RequestID}
+				if w.CMD == RETRIEVEBLOCK {
+					r.CMD = BLOCK
+					r.Data = blocks[w.Headers[0].BlockNum]
+				} else {
+					blocks[w.Data.Header.BlockNum] = w.Data
+				}
+				enc.Encode(r)
+			}
+			waiting = nil
+			continue
+		}
+		enc.Encode(r)
+	}
+}
+
+func TestParallelTransfers(t *testing.T) {
+
+	SIZEOFBLOCK = 64
+	parallelism = 4
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+	blocks := make(map[int]Block)
+	go batchingNamenode(server, blocks)
+
+	// 10 Blocks, the last short
+	data := make([]byte, 64*9+10)
+	rand.Read(data)
+	err := DistributeBlocksFromReader(bytes.NewReader(data), int64(len(data)), "/big.bin")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	err = RetrieveToWriter(w, "/big.bin")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("Downloaded file of %d bytes differs from the %d uploaded", out.Len(), len(data))
+	}
+}
+
+func TestPagedHeaders(t *testing.T) {
+
+	SIZEOFBLOCK = 64
+	headerPageSize = 3
+	defer func() { headerPageSize = 1024 }()
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+
+	// the namenode answers a page of headers at a time, recording the first
+	// block number of each page asked for
+	blocks := make(map[int]Block)
+	offsets := make(chan int, 100)
+	go func() {
+		enc := json.NewEncoder(server)
+		dec := json.NewDecoder(server)
+		for {
+			var p Packet
+			if dec.Decode(&p) != nil {
+				close(offsets)
+				return
+			}
+			r := Packet{SRC: "NN", DST: p.SRC, CMD: ACK, RequestID: p.RequestID}
+			switch p.CMD {
+			case DISTRIBUTE:
+				blocks[p.Data.Header.BlockNum] = p.Data
+			case GETHEADERS:
+				offsets <- p.Offset
+				r.CMD = GETHEADERS
+				r.Offset = p.Offset
+				for num := p.Offset; num < len(blocks) && num < p.Offset+p.Limit; num++ {
+					r.Headers = append(r.Headers, blocks[num].Header)
+				}
+			case RETRIEVEBLOCK:
+				r.CMD = BLOCK
+				r.Data = blocks[p.Headers[0].BlockNum]
+			}
+			enc.Encode(r)
+		}
+	}()
+
+	data := make([]byte, 64*9+10)
+	rand.Read(data)
+	err := DistributeBlocksFromReader(bytes.NewReader(data), int64(len(data)), "/paged.bin")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	err = RetrieveToWriter(w, "/paged.bin")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("Downloaded file of %d bytes differs from the %d uploaded", out.Len(), len(data))
+	}
+
+	f, err := Open("/paged.bin")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer f.Close()
+	if f.Size() != int64(len(data)) {
+		t.Errorf("Opened file of %d bytes, expected %d", f.Size(), len(data))
+	}
+
+	client.Close()
+	var asked []int
+	for off := range offsets {
+		asked = append(asked, off)
+	}
+	if len(asked) != 8 || asked[0] != 0 || asked[3] != 9 || asked[4] != 0 || asked[7] != 9 {
+		t.Errorf("Pages asked from %v, expected 0, 3, 6 and 9 for each read", asked)
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Progress is how far an upload or download of a file has got, passed to
+// the ProgressFunc as each Block is acknowledged or retrieved
+type Progress struct {
+	Path        string
+	Upload      bool
+	Bytes       int64 // bytes transferred so far
+	TotalBytes  int64 // bytes of the file, 0 if not known
+	Blocks      int   // Blocks acknowledged by the namenode, or retrieved
+	TotalBlocks int
+	Throughput  float64       // bytes per second over the last few seconds
+	Elapsed     time.Duration // since the transfer started
+	ETA         time.Duration // left at the current throughput, 0 if not known
+	Done        bool          // the transfer completed
+}
+
+// ProgressFunc is called with the progress of each upload and download
+type ProgressFunc func(Progress)
+
+var progressFunc ProgressFunc // set by SetProgress, dots are printed without one
+
+// time throughput is measured over
+const progressWindow = 5 * time.Second
+
+// SetProgress sets the function called with the progress of uploads and
+// downloads, nil to print a dot per Block. It may be called by several
+// transfers at once.
+func SetProgress(f ProgressFunc) {
+	progressFunc = f
+}
+
+// transfer tracks the progress of an upload or download
+type transfer struct {
+	mu      sync.Mutex
+	p       Progress
+	f       ProgressFunc
+	start   time.Time
+	samples []progressSample // bytes transferred at recent times, oldest first
+}
+
+type progressSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// newTransfer starts tracking a transfer of the file at path, of totalBytes
+// if known in totalBlocks Blocks
+func newTransfer(path string, upload bool, totalBytes int64, totalBlocks int) *transfer {
+	now := time.Now()
+	t := &transfer{f: progressFunc, start: now, samples: []progressSample{{now, 0}}}
+	t.p = Progress{Path: path, Upload: upload, TotalBytes: totalBytes, TotalBlocks: totalBlocks}
+	return t
+}
+
+// block records a Block of n bytes acknowledged or retrieved
+func (t *transfer) block(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.p.Bytes += int64(n)
+	t.p.Blocks++
+	t.samples = append(t.samples, progressSample{now, t.p.Bytes})
+	for len(t.samples) > 2 && now.Sub(t.samples[1].at) >= progressWindow {
+		t.samples = t.samples[1:]
+	}
+	oldest := t.samples[0]
+	if d := now.Sub(oldest.at); d > 0 {
+		t.p.Throughput = float64(t.p.Bytes-oldest.bytes) / d.Seconds()
+	}
+	t.p.Elapsed = now.Sub(t.start)
+	t.p.ETA = 0
+	if left := t.p.TotalBytes - t.p.Bytes; t.p.TotalBytes > 0 && left > 0 && t.p.Throughput > 0 {
+		t.p.ETA = time.Duration(float64(left) / t.p.Throughput * float64(time.Second))
+	} else if left := t.p.TotalBlocks - t.p.Blocks; t.p.TotalBytes == 0 && left > 0 {
+		// without a size, the Blocks left are expected to take as long as those done
+		t.p.ETA = t.p.Elapsed / time.Duration(t.p.Blocks) * time.Duration(left)
+	}
+	if t.f == nil {
+		fmt.Printf(".")
+		return
+	}
+	t.f(t.p)
+}
+
+// done records the transfer completed
+func (t *transfer) done() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.f == nil {
+		fmt.Printf(" Done! \n")
+		return
+	}
+	t.p.Done = true
+	t.p.Elapsed = time.Since(t.start)
+	t.p.ETA = 0
+	t.f(t.p)
+}
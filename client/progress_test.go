@@ -0,0 +1,66 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+
+	defer func(size int) { SIZEOFBLOCK = size }(SIZEOFBLOCK)
+	SIZEOFBLOCK = 4
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+	blocks := make(map[int]Block)
+	var st FileStatus
+	go fakeNamenode(server, blocks, &st)
+
+	var lock sync.Mutex
+	var reports []Progress
+	SetProgress(func(p Progress) {
+		lock.Lock()
+		reports = append(reports, p)
+		lock.Unlock()
+	})
+	defer SetProgress(nil)
+
+	check := func(upload bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		if len(reports) != 5 {
+			t.Fatalf("Expected a report of each of 4 Blocks and one when done, got %v", reports)
+		}
+		for i, p := range reports[:4] {
+			if p.Path != "/data.bin" || p.Upload != upload || p.Blocks != i+1 || p.TotalBlocks != 4 || p.TotalBytes != 14 || p.Done {
+				t.Errorf("Bad report of Block %d: %+v", i, p)
+			}
+		}
+		last := reports[4]
+		if !last.Done || last.Bytes != 14 || last.Blocks != 4 || last.ETA != 0 {
+			t.Errorf("Bad report once done: %+v", last)
+		}
+		reports = nil
+	}
+
+	data := []byte("abcdefghijklmn")
+	err := DistributeBlocksFromReader(bytes.NewReader(data), int64(len(data)), "/data.bin")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	check(true)
+
+	var out bytes.Buffer
+	err = RetrieveToWriter(bufio.NewWriter(&out), "/data.bin")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if out.String() != string(data) {
+		t.Fatalf("Retrieved %q, expected %q", out.String(), data)
+	}
+	check(false)
+}
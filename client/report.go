@@ -0,0 +1,49 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DatanodeReport describes a datanode in a ClusterReport
+type DatanodeReport struct {
+	ID            string
+	Address       string    // host the datanode connected from
+	HTTPAddress   string    // host:port of the datanode's HTTP server, if any
+	State         string    // online, offline, decommissioning or decommissioned
+	Capacity      int64     // free bytes on its healthy volumes, -1 if it has not reported them
+	Used          int64     // bytes of the Blocks it holds
+	LastHeartbeat time.Time // zero if it never sent one
+	Blocks        int       // replicas it holds
+	Rack          string
+	FailedVolumes string   // storage directories which failed, if any
+	Storages      []string // storage types of its volumes, DISK alone if empty
+	Slow          bool     // its latencies are outliers
+}
+
+// ClusterReport describes the datanodes of a cluster and its totals
+type ClusterReport struct {
+	ID              string // ID of the namenode
+	SafeMode        bool
+	Files           int
+	Blocks          int
+	UnderReplicated int
+	Replication     int
+	BlockSize       int
+	Live            int   // datanodes online or decommissioning
+	Capacity        int64 // free bytes of the datanodes which reported them
+	Used            int64 // bytes of the Blocks on every datanode
+	Datanodes       []DatanodeReport
+}
+
+// GetReport describes the datanodes of the cluster, ordered by ID, and its
+// totals
+func GetReport() (ClusterReport, error) {
+	var report ClusterReport
+	message, err := admin(Packet{SRC: id, DST: "NN", CMD: GETREPORT})
+	if err != nil {
+		return report, err
+	}
+	err = json.Unmarshal([]byte(message), &report)
+	return report, err
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Resume stores a local file as remotename resumably, in Blocks of
+// blockSize bytes each kept on replicas datanodes where they are not 0. An
+// upload which is interrupted, such as by a crash of the client, keeps the
+// Blocks the namenode acknowledged, and calling Resume again uploads only
+// the Blocks the file is missing.
+func Resume(localname, remotename string, blockSize, replicas int) error {
+	fi, err := os.Open(localname)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	info, err := fi.Stat()
+	if err != nil {
+		return err
+	}
+	return ResumeFromReader(fi, info.Size(), remotename, blockSize, replicas)
+}
+
+// ResumeFromReader stores size bytes read from r as remotename resumably,
+// as Resume does, reading only the Blocks the file is missing
+func ResumeFromReader(r io.ReaderAt, size int64, remotename string, blockSize, replicas int) error {
+	if !strings.HasPrefix(remotename, "/") {
+		remotename = "/" + remotename
+	}
+	st, missing, err := resumeLease(remotename, FileStatus{Path: remotename, BlockSize: blockSize, Replicas: replicas})
+	if err != nil {
+		return err
+	}
+	defer releaseLease(remotename)
+
+	if st.BlockSize != 0 {
+		blockSize = st.BlockSize
+	}
+	if blockSize == 0 {
+		blockSize = SIZEOFBLOCK
+	}
+	total := int((size + int64(blockSize) - 1) / int64(blockSize))
+	if st.NumBlocks == 0 {
+		// nothing was written yet, so every Block is missing
+		for num := 0; num < total; num++ {
+			missing = append(missing, BlockHeader{"", remotename, 0, num, total, 0, "", 0, ""})
+		}
+	} else if st.NumBlocks != total {
+		return fmt.Errorf("Cannot resume %s of %d Blocks from %d bytes in %d Blocks", remotename, st.NumBlocks, size, total)
+	}
+	fmt.Println("Resuming ", remotename, ", uploading ", len(missing), " of ", total, " Blocks")
+
+	left := int64(0)
+	for _, h := range missing {
+		n := size - int64(h.BlockNum)*int64(blockSize)
+		if n > int64(blockSize) {
+			n = int64(blockSize)
+		}
+		left += n
+	}
+	pl := newPipeline()
+	pl.progress = newTransfer(remotename, true, left, len(missing))
+	for _, h := range missing {
+		off := int64(h.BlockNum) * int64(blockSize)
+		n := int64(blockSize)
+		if off+n > size {
+			n = size - off
+		}
+		data := make([]byte, n)
+		_, err := r.ReadAt(data, off)
+		if err != nil && err != io.EOF {
+			pl.wait()
+			return err
+		}
+		err = pl.distribute(Block{BlockHeader{"", remotename, len(data), h.BlockNum, total, 0, "", 0, ""}, data})
+		if err != nil {
+			pl.wait()
+			return err
+		}
+	}
+	err = pl.wait()
+	if err != nil {
+		return err
+	}
+	pl.progress.done()
+	return nil
+}
+
+// resumeLease takes the lease on the file at path to write it resumably,
+// asking for the layout of a new file, and returns the status of the file
+// and the headers of the Blocks it is missing
+func resumeLease(path string, layout FileStatus) (FileStatus, []BlockHeader, error) {
+	err := holdLease(path)
+	if err != nil {
+		return FileStatus{}, nil, err
+	}
+	p := Packet{SRC: id, DST: "NN", CMD: RESUME, Message: holder}
+	p.Headers = []BlockHeader{{Filename: path}}
+	p.Status = []FileStatus{layout}
+	r, err := roundTrip(p)
+	if err == nil && r.CMD == ERROR {
+		err = responseError(r)
+	} else if err == nil && (r.CMD != ACK || len(r.Status) != 1) {
+		err = fmt.Errorf("Bad response packet %v", r)
+	}
+	if err != nil {
+		forgetLease(path)
+		return FileStatus{}, nil, err
+	}
+	return r.Status[0], r.Headers, nil
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+func TestResume(t *testing.T) {
+
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+
+	// the namenode holds Blocks 0 and 2 of an interrupted upload
+	var lock sync.Mutex
+	var distributed []BlockHeader
+	var data []byte
+	go func() {
+		enc, dec := json.NewEncoder(server), json.NewDecoder(server)
+		for {
+			var p Packet
+			if err := dec.Decode(&p); err != nil {
+				return
+			}
+			r := Packet{SRC: "NN", DST: p.SRC, CMD: ACK, RequestID: p.RequestID}
+			lock.Lock()
+			switch p.CMD {
+			case RESUME:
+				r.Status = []FileStatus{{Path: p.Headers[0].Filename, NumBlocks: 3, BlockSize: 4}}
+				r.Headers = []BlockHeader{{Filename: p.Headers[0].Filename, BlockNum: 1, NumBlocks: 3}}
+			case DISTRIBUTE:
+				distributed = append(distributed, p.Data.Header)
+				data = p.Data.Data
+			}
+			lock.Unlock()
+			enc.Encode(r)
+		}
+	}()
+
+	content := []byte("abcdefghij")
+	if err := ResumeFromReader(bytes.NewReader(content), int64(len(content)), "/big.bin", 0, 0); err != nil {
+		t.Fatalf("%s", err)
+	}
+	lock.Lock()
+	if len(distributed) != 1 || distributed[0].BlockNum != 1 || distributed[0].NumBlocks != 3 || string(data) != "efgh" {
+		t.Errorf("Expected only Block 1 uploaded, got %v %q", distributed, data)
+	}
+	lock.Unlock()
+
+	// a file of another size cannot be resumed
+	if ResumeFromReader(bytes.NewReader(content[:4]), 4, "/big.bin", 0, 0) == nil {
+		t.Errorf("Resumed a file from a local file of another size")
+	}
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var maxAttempts = 4                       // attempts made at a request before its error is returned
+var retryBackoff = 100 * time.Millisecond // wait before the first retry, doubled after each further one
+var maxRetryBackoff = 5 * time.Second     // longest wait between attempts
+var namenodeAddress string                // address Connect dialled, redialled to retry requests
+var namenodeAddresses []string            // host:port addresses of the active and standby namenodes, tried in turn on failover
+var reconnectLock sync.Mutex              // serializes reconnections
+var lastKey int64                         // numbers the idempotency keys of this client
+
+// mutates reports whether cmd changes the namespace, so a retry must carry
+// the idempotency key of the first attempt for the namenode to answer it
+// without doing it again
+func mutates(cmd int) bool {
+	switch cmd {
+	case DISTRIBUTE, DELETE, MKDIR, SETQUOTA, RENAME, CREATESNAPSHOT, DELETESNAPSHOT, LEASE, RELEASE, ERASURECODE,
+		CREATEZONE, FILEKEY, SETREP, BALANCE, DECOMMISSION, TRUNCATE, SETTIMES, SETXATTR, REMOVEXATTR,
+		CREATESYMLINK, BATCH, ARCHIVE, CACHE, UNCACHE, STORAGEPOLICY, RESUME:
+		return true
+	}
+	return false
+}
+
+// newKey returns an idempotency key no other request of any client has
+func newKey() string {
+	return holder + "-" + strconv.FormatInt(atomic.AddInt64(&lastKey, 1), 10)
+}
+
+// errStandby fails requests refused by a standby namenode, which are made
+// again of the next namenode
+var errStandby = errors.New(standbyMessage)
+
+// standbyMessage is the error a standby namenode answers requests with
+const standbyMessage = "Namenode is in standby"
+
+// errThrottled fails requests the namenode refused for a rate or
+// concurrency limit, which are made again after a backoff
+var errThrottled = errors.New(throttledMessage)
+
+// throttledMessage starts the errors of requests the namenode refused for a
+// rate or concurrency limit
+const throttledMessage = "Too many requests"
+
+// retryable reports whether a request failing with err may succeed if it
+// is made again on a new connection. Errors answered by the namenode are
+// not retried, unless it is the standby or the request was throttled.
+func retryable(err error) bool {
+	if err == errConnectionLost || err == errStandby || err == errThrottled || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// roundTrip sends p to the namenode of the namespace holding its path and
+// waits for its response, retrying with exponential backoff on a new
+// connection while the connection fails, and on the same connection while
+// the namenode throttles the request. Mutating requests carry an
+// idempotency key, so the namenode answers a retry of a request it has done
+// from its retry cache. Headers and statuses are answered from the metadata
+// cache while it holds them.
+func roundTrip(p Packet) (Packet, error) {
+	leave, err := enterNamespace(p)
+	if err != nil {
+		return Packet{}, err
+	}
+	defer leave()
+	return retryRoundTrip(p)
+}
+
+// retryRoundTrip is roundTrip on the namespace connected to
+func retryRoundTrip(p Packet) (Packet, error) {
+	if r, ok := metadata.get(p); ok {
+		return r, nil
+	}
+	if mutates(p.CMD) && p.Key == "" {
+		p.Key = newKey()
+	}
+	if mutates(p.CMD) {
+		metadata.invalidate(p)
+	}
+	backoff := retryBackoff
+	for n := 1; ; n++ {
+		address := currentNamenode()
+		r, err := attempt(p)
+		refused := ""
+		if err == nil && r.CMD == ERROR && r.Message == standbyMessage {
+			err = errStandby
+			refused = address
+		}
+		if err == nil && r.CMD == ERROR && strings.HasPrefix(r.Message, throttledMessage) && n < maxAttempts && namenodeAddress != "" {
+			// the last attempt answers with the namenode's error
+			err = errThrottled
+		}
+		if err == nil {
+			metadata.observe(p, r)
+		}
+		if err == nil || !retryable(err) || n >= maxAttempts || namenodeAddress == "" {
+			return r, err
+		}
+
+		// jitter keeps clients which lost the namenode together from
+		// retrying in step
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		log.Println("Request failed, retrying in ", wait, err)
+		time.Sleep(wait)
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+		err = reconnect(refused)
+		if err != nil {
+			log.Println("Could not reconnect to the namenode ", err)
+		}
+	}
+}
+
+// currentNamenode returns the address of the namenode connected to
+func currentNamenode() string {
+	reconnectLock.Lock()
+	defer reconnectLock.Unlock()
+	return namenodeAddress
+}
+
+// reconnect dials the namenode again unless another request already
+// replaced the lost connection. Once the standby at refused has refused a
+// request, the next of the namenodes is dialled instead, unless another
+// request already failed over.
+func reconnect(refused string) error {
+	reconnectLock.Lock()
+	defer reconnectLock.Unlock()
+
+	pendingLock.Lock()
+	lost := dispatchErr != nil
+	pendingLock.Unlock()
+	failover := refused != "" && refused == namenodeAddress
+	if !lost && !failover {
+		return nil
+	}
+	// the namespace may have changed while the client was not connected
+	metadata.clear()
+
+	var err error
+	for _, a := range failoverOrder(namenodeAddress, failover) {
+		err = dial(a)
+		if err == nil {
+			if a != namenodeAddress {
+				log.Println("Failed over to namenode ", a)
+			}
+			namenodeAddress = a
+			return nil
+		}
+	}
+	return err
+}
+
+// failoverOrder returns the namenodes to dial in place of the one at
+// current, starting with it, or with the next namenode to skip a standby.
+// A namenode missing from the configured ones is the only one dialled.
+func failoverOrder(current string, skip bool) []string {
+	for i, a := range namenodeAddresses {
+		if a != current {
+			continue
+		}
+		if skip {
+			i++
+		}
+		order := make([]string, 0, len(namenodeAddresses))
+		for j := range namenodeAddresses {
+			order = append(order, namenodeAddresses[(i+j)%len(namenodeAddresses)])
+		}
+		return order
+	}
+	return []string{current}
+}
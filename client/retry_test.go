@@ -0,0 +1,166 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer l.Close()
+	backoff, format := retryBackoff, wireFormat
+	defer func() { namenodeAddress, retryBackoff, wireFormat = "", backoff, format }()
+	retryBackoff = time.Millisecond
+	wireFormat = "json"
+
+	// the namenode loses the connection before answering the first MKDIR,
+	// and answers its retry on the next connection
+	keys := make(chan string, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			dec, enc := json.NewDecoder(c), json.NewEncoder(c)
+			var p Packet
+			dec.Decode(&p)
+			enc.Encode(Packet{SRC: "NN", DST: "C", CMD: HELLO, Hello: &Hello{Version: protocolVersion, MinVersion: protocolVersion}})
+			dec.Decode(&p)
+			keys <- p.Key
+			if i == 0 {
+				c.Close()
+				continue
+			}
+			enc.Encode(Packet{SRC: "NN", DST: "C", CMD: ACK, RequestID: p.RequestID})
+		}
+	}()
+
+	if err := Connect(l.Addr().String()); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := Mkdir("/d", false); err != nil {
+		t.Fatalf("%s", err)
+	}
+	first, retried := <-keys, <-keys
+	if first == "" || first != retried {
+		t.Errorf("Expected the retry to carry the key of the first attempt, got %q and %q", first, retried)
+	}
+
+	if !retryable(errConnectionLost) || retryable(errors.New("File not found /d")) {
+		t.Errorf("Wrong errors retried")
+	}
+	if mutates(STAT) || !mutates(DISTRIBUTE) {
+		t.Errorf("Wrong requests given idempotency keys")
+	}
+}
+
+func TestFailover(t *testing.T) {
+
+	backoff, format := retryBackoff, wireFormat
+	defer func() { namenodeAddress, namenodeAddresses, retryBackoff, wireFormat = "", nil, backoff, format }()
+	retryBackoff = time.Millisecond
+	wireFormat = "json"
+
+	// the first namenode is the standby, refusing requests, and the second
+	// the active
+	serve := func(l net.Listener, answer Packet) {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				dec, enc := json.NewDecoder(c), json.NewEncoder(c)
+				var p Packet
+				dec.Decode(&p)
+				enc.Encode(Packet{SRC: "NN", DST: "C", CMD: HELLO, Hello: &Hello{Version: protocolVersion, MinVersion: protocolVersion}})
+				for dec.Decode(&p) == nil {
+					r := answer
+					r.RequestID = p.RequestID
+					enc.Encode(r)
+				}
+			}()
+		}
+	}
+	var addresses []string
+	for _, answer := range []Packet{{CMD: ERROR, Message: standbyMessage}, {CMD: ACK}} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		defer l.Close()
+		go serve(l, answer)
+		addresses = append(addresses, l.Addr().String())
+	}
+	namenodeAddresses = addresses
+
+	if err := Connect(Address()); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := Mkdir("/d", false); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if namenodeAddress != addresses[1] {
+		t.Errorf("Expected to fail over to %s, connected to %s", addresses[1], namenodeAddress)
+	}
+
+	if order := failoverOrder(addresses[1], true); len(order) != 2 || order[0] != addresses[0] {
+		t.Errorf("Wrong failover order %v", order)
+	}
+	if order := failoverOrder("elsewhere:8080", true); len(order) != 1 || order[0] != "elsewhere:8080" {
+		t.Errorf("Namenode given by address replaced %v", order)
+	}
+}
+
+func TestThrottledRetry(t *testing.T) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer l.Close()
+	backoff, format := retryBackoff, wireFormat
+	defer func() { namenodeAddress, retryBackoff, wireFormat = "", backoff, format }()
+	retryBackoff = time.Millisecond
+	wireFormat = "json"
+
+	// the namenode throttles all requests but every third, on one connection
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		dec, enc := json.NewDecoder(c), json.NewEncoder(c)
+		var p Packet
+		dec.Decode(&p)
+		enc.Encode(Packet{SRC: "NN", DST: "C", CMD: HELLO, Hello: &Hello{Version: protocolVersion, MinVersion: protocolVersion}})
+		for i := 1; dec.Decode(&p) == nil; i++ {
+			r := Packet{SRC: "NN", DST: "C", CMD: ERROR, Message: throttledMessage + ": rate limit exceeded", RequestID: p.RequestID}
+			if i%3 == 0 {
+				r.CMD = ACK
+			}
+			enc.Encode(r)
+		}
+	}()
+
+	if err := Connect(l.Addr().String()); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := Mkdir("/d", false); err != nil {
+		t.Fatalf("Throttled request not retried %s", err)
+	}
+	attempts := maxAttempts
+	defer func() { maxAttempts = attempts }()
+	maxAttempts = 2
+	if err := Mkdir("/d", false); err == nil || err.Error() != throttledMessage+": rate limit exceeded" {
+		t.Errorf("Expected the namenode's error after the last attempt, got %v", err)
+	}
+}
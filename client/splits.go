@@ -0,0 +1,85 @@
+package client
+
+import (
+	"errors"
+	"sort"
+)
+
+// Split is a range of a file for one task of a compute framework, with the
+// hosts holding its data
+type Split struct {
+	Path   string
+	Offset int64
+	Length int64
+	Hosts  []string // hosts of the datanodes holding replicas of the split's Blocks, those holding the most of its bytes first
+}
+
+// Splits divides the file at path into splits of splitSize bytes, the last
+// holding the rest, or into one split per Block if splitSize is 0. Each
+// split lists the hosts it is cheapest to read on, so a scheduler can place
+// its task next to the data. Datanodes which are offline are not listed.
+func Splits(path string, splitSize int64) ([]Split, error) {
+	if splitSize < 0 {
+		return nil, errors.New("Invalid split size")
+	}
+	st, locations, err := GetBlockLocations(path, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if splitSize == 0 {
+		splitSize = int64(st.BlockSize)
+	}
+	return fileSplits(st.Path, st.Size, splitSize, locations), nil
+}
+
+// fileSplits divides a file of size bytes, whose Blocks are at locations,
+// into splits of splitSize bytes
+func fileSplits(path string, size, splitSize int64, locations []BlockLocation) []Split {
+	if splitSize <= 0 {
+		splitSize = size
+	}
+	splits := make([]Split, 0)
+	for offset := int64(0); offset < size; offset += splitSize {
+		s := Split{Path: path, Offset: offset, Length: splitSize}
+		if offset+s.Length > size {
+			s.Length = size - offset
+		}
+		s.Hosts = splitHosts(s, locations)
+		splits = append(splits, s)
+	}
+	return splits
+}
+
+// splitHosts lists the hosts holding the bytes of a split, those holding the
+// most first, and otherwise in the order of the replicas
+func splitHosts(s Split, locations []BlockLocation) []string {
+	held := make(map[string]int64)
+	hosts := make([]string, 0)
+	for _, loc := range locations {
+		start, end := loc.Offset, loc.Offset+loc.Length
+		if start < s.Offset {
+			start = s.Offset
+		}
+		if end > s.Offset+s.Length {
+			end = s.Offset + s.Length
+		}
+		if start >= end {
+			continue
+		}
+		for _, r := range loc.Replicas {
+			if r.State != "online" && r.State != "decommissioning" {
+				continue
+			}
+			host := r.Address
+			if host == "" {
+				host = r.DatanodeID
+			}
+			if _, ok := held[host]; !ok {
+				hosts = append(hosts, host)
+			}
+			held[host] += end - start
+		}
+	}
+	sort.SliceStable(hosts, func(i, j int) bool { return held[hosts[i]] > held[hosts[j]] })
+	return hosts
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFileSplits(t *testing.T) {
+
+	replica := func(id, state string) ReplicaLocation {
+		return ReplicaLocation{DatanodeID: id, Address: "host-" + id, State: state}
+	}
+	locations := []BlockLocation{
+		{BlockNum: 0, Offset: 0, Length: 4, Replicas: []ReplicaLocation{replica("DN1", "online"), replica("DN2", "online")}},
+		{BlockNum: 1, Offset: 4, Length: 4, Replicas: []ReplicaLocation{replica("DN2", "online"), replica("DN3", "offline")}},
+		{BlockNum: 2, Offset: 8, Length: 2, Replicas: []ReplicaLocation{replica("DN3", "decommissioning"), {DatanodeID: "DN4", State: "online"}}},
+	}
+
+	// a split per Block
+	splits := fileSplits("/data.txt", 10, 4, locations)
+	expected := []Split{
+		{"/data.txt", 0, 4, []string{"host-DN1", "host-DN2"}},
+		{"/data.txt", 4, 4, []string{"host-DN2"}},
+		{"/data.txt", 8, 2, []string{"host-DN3", "DN4"}},
+	}
+	if !reflect.DeepEqual(splits, expected) {
+		t.Errorf("Unexpected splits %+v", splits)
+	}
+
+	// splits spanning Blocks prefer the hosts holding the most of them
+	splits = fileSplits("/data.txt", 10, 6, locations)
+	expected = []Split{
+		{"/data.txt", 0, 6, []string{"host-DN2", "host-DN1"}},
+		{"/data.txt", 6, 4, []string{"host-DN2", "host-DN3", "DN4"}},
+	}
+	if !reflect.DeepEqual(splits, expected) {
+		t.Errorf("Unexpected spanning splits %+v", splits)
+	}
+
+	if splits := fileSplits("/empty.txt", 0, 4, nil); len(splits) != 0 {
+		t.Errorf("Empty file split into %+v", splits)
+	}
+	if splits := fileSplits("/data.txt", 10, 0, locations); len(splits) != 1 || len(splits[0].Hosts) != 4 {
+		t.Errorf("Unexpected split of the whole file %+v", splits)
+	}
+}
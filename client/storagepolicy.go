@@ -0,0 +1,12 @@
+package client
+
+// SetStoragePolicy sets the storage policy of the file or directory at path:
+// HOT keeps every replica on SSD, WARM one on SSD and the rest on DISK, and
+// COLD all on ARCHIVE. Files below a directory inherit its policy, and the
+// namenode moves existing replicas in the background. An empty policy
+// clears it.
+func SetStoragePolicy(path, policy string) error {
+	p := Packet{SRC: id, DST: "NN", CMD: STORAGEPOLICY, Message: policy}
+	p.Headers = []BlockHeader{{Filename: path}}
+	return send(p)
+}
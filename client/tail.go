@@ -0,0 +1,59 @@
+package client
+
+import (
+	"io"
+	"log"
+	"time"
+)
+
+// Tail writes the last n bytes of the file at path to w, retrieving only the
+// Blocks holding them, and returns the size of the file
+func Tail(path string, n int64, w io.Writer) (int64, error) {
+	f, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	size := f.Size()
+	start := size - n
+	if start < 0 {
+		start = 0
+	}
+	_, err = io.Copy(w, io.NewSectionReader(f, start, size-start))
+	return size, err
+}
+
+// Follow writes the file at path to w from offset as it grows, asking the
+// namenode for its Blocks every interval until stop is closed. A file still
+// being written is followed as far as its writer flushed it. A file which
+// shrinks is followed from its new end, and one which cannot be read, such
+// as while it is written again, is tried again at the next interval.
+func Follow(path string, offset int64, interval time.Duration, w io.Writer, stop <-chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(interval):
+		}
+
+		f, err := Open(path)
+		if err != nil {
+			log.Println("Could not read ", path, " ", err)
+			continue
+		}
+		size := f.Size()
+		if size < offset {
+			log.Println("File truncated ", path)
+			offset = size
+		}
+		if size > offset {
+			_, err = io.Copy(w, io.NewSectionReader(f, offset, size-offset))
+			offset = size
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTail(t *testing.T) {
+
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+
+	var lock sync.Mutex
+	data := []byte("first line\nsecond line\n")
+	blocks := splitBlocks("/log", data, 8)
+	retrieved := make(chan int, 100)
+	go serveFile(server, func() []Block {
+		lock.Lock()
+		defer lock.Unlock()
+		return blocks
+	}, retrieved)
+
+	var out bytes.Buffer
+	size, err := Tail("/log", 12, &out)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if out.String() != "second line\n" || size != int64(len(data)) {
+		t.Errorf("Expected the last line of a %d byte file, got %q of %d bytes", len(data), out.String(), size)
+	}
+	if len(retrieved) != 2 {
+		t.Errorf("Expected the last 2 Blocks retrieved, got %d", len(retrieved))
+	}
+
+	// the file is written again with another line, which is followed
+	stop := make(chan struct{})
+	done := make(chan error)
+	var followed syncBuffer
+	go func() { done <- Follow("/log", size, time.Millisecond, &followed, stop) }()
+	lock.Lock()
+	blocks = splitBlocks("/log", append(data, "third line\n"...), 8)
+	lock.Unlock()
+	for i := 0; i < 1000 && followed.String() != "third line\n"; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("%s", err)
+	}
+	if followed.String() != "third line\n" {
+		t.Errorf("Expected the new line followed, got %q", followed.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer which may be read while it is written
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestFollowWriting(t *testing.T) {
+
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+
+	// the datanodes hold more of the file than its writer flushed
+	var lock sync.Mutex
+	data := []byte("first line\nsecond line\n")
+	blocks := splitBlocks("/log", data, 8)
+	var visible int64
+	go func() {
+		enc, dec := json.NewEncoder(server), json.NewDecoder(server)
+		for {
+			var p Packet
+			if err := dec.Decode(&p); err != nil {
+				return
+			}
+			lock.Lock()
+			r := Packet{SRC: "NN", DST: p.SRC, CMD: GETHEADERS, RequestID: p.RequestID}
+			st := FileStatus{Path: "/log", Writing: true}
+			for _, b := range blocks {
+				h := b.Header
+				if st.Size+int64(h.Size) > visible {
+					h.Size = int(visible - st.Size)
+				}
+				if h.Size == 0 {
+					break
+				}
+				r.Headers = append(r.Headers, h)
+				st.Size += int64(h.Size)
+			}
+			r.Status = []FileStatus{st}
+			if p.CMD == RETRIEVEBLOCK {
+				r = Packet{SRC: "NN", DST: p.SRC, CMD: BLOCK, RequestID: p.RequestID, Data: blocks[p.Headers[0].BlockNum]}
+			}
+			lock.Unlock()
+			enc.Encode(r)
+		}
+	}()
+
+	f, err := Open("/log")
+	if err != nil || f.Size() != 0 {
+		t.Fatalf("Expected nothing readable before a flush, got %v %v", f, err)
+	}
+	f.Close()
+
+	lock.Lock()
+	visible = 11
+	lock.Unlock()
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	if err := RetrieveToWriter(w, "/log"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if out.String() != "first line\n" {
+		t.Errorf("Expected the flushed line, got %q", out.String())
+	}
+
+	// the next line is followed once it is flushed
+	stop := make(chan struct{})
+	done := make(chan error)
+	var followed syncBuffer
+	go func() { done <- Follow("/log", 11, time.Millisecond, &followed, stop) }()
+	time.Sleep(10 * time.Millisecond)
+	if followed.String() != "" {
+		t.Errorf("Followed %q before it was flushed", followed.String())
+	}
+	lock.Lock()
+	visible = int64(len(data))
+	lock.Unlock()
+	for i := 0; i < 1000 && followed.String() != "second line\n"; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("%s", err)
+	}
+	if followed.String() != "second line\n" {
+		t.Errorf("Expected the flushed line followed, got %q", followed.String())
+	}
+}
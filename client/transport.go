@@ -0,0 +1,18 @@
+package client
+
+import (
+	"github.com/sjarvie/godfs/quicconn"
+	"net"
+	"time"
+)
+
+var transport = "tcp" // transport of the connection to the namenode: tcp or quic
+
+// dialNamenode connects to the namenode at address over the configured
+// transport, giving up after timeout unless it is 0
+func dialNamenode(address string, timeout time.Duration) (net.Conn, error) {
+	if transport == "quic" {
+		return quicconn.Dial(address, timeout)
+	}
+	return net.DialTimeout("tcp", address, timeout)
+}
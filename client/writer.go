@@ -0,0 +1,164 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var flushTimeout = time.Minute             // time Flush waits for the Blocks written to be durable
+var flushInterval = 100 * time.Millisecond // time between the FLUSH requests of a waiting Flush
+
+// Writer writes a file of a known size as its bytes are written, holding
+// the lease on the file until it is closed. Flush makes the bytes written so
+// far durable, and readable while the rest is written.
+type Writer struct {
+	path      string
+	size      int64 // bytes the file will hold
+	blockSize int
+	numBlocks int
+	num       int    // block number of buf
+	buf       []byte // bytes of Block num, distributed in full once it fills
+	written   int64
+	pl        *pipeline
+	closed    bool
+}
+
+// Create opens the file remotename, of size bytes, for writing, replacing
+// any file there
+func Create(remotename string, size int64) (*Writer, error) {
+	return CreateFile(remotename, size, CREATE|OVERWRITE)
+}
+
+// CreateFile opens the file remotename, of size bytes, for writing as the
+// create flags allow. CREATE|EXCLUSIVE fails with ErrFileExists if there is
+// a file, so only one of several writers creates it.
+func CreateFile(remotename string, size int64, flags int) (*Writer, error) {
+	if size < 0 {
+		return nil, errors.New("Invalid size " + strconv.FormatInt(size, 10) + " of " + remotename)
+	}
+	if !strings.HasPrefix(remotename, "/") {
+		remotename = "/" + remotename
+	}
+	err := acquireLease(remotename, flags, FileStatus{})
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{path: remotename, size: size, blockSize: SIZEOFBLOCK, pl: newPipeline()}
+	w.numBlocks = int((size + int64(w.blockSize) - 1) / int64(w.blockSize))
+	w.buf = make([]byte, 0, w.blockSize)
+	return w, nil
+}
+
+// Write writes p to the file, distributing each Block once it fills
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("Write to closed file " + w.path)
+	}
+	if w.written+int64(len(p)) > w.size {
+		return 0, errors.New("Write past the " + strconv.FormatInt(w.size, 10) + " bytes of " + w.path)
+	}
+	n := 0
+	for n < len(p) {
+		k := w.blockSize - len(w.buf)
+		if k > len(p)-n {
+			k = len(p) - n
+		}
+		w.buf = append(w.buf, p[n:n+k]...)
+		n += k
+		w.written += int64(k)
+		if len(w.buf) == w.blockSize {
+			err := w.pl.distribute(w.block())
+			if err != nil {
+				return n, err
+			}
+			w.num++
+			w.buf = make([]byte, 0, w.blockSize)
+		}
+	}
+	return n, nil
+}
+
+// block is the Block of the bytes in buf
+func (w *Writer) block() Block {
+	data := make([]byte, len(w.buf))
+	copy(data, w.buf)
+	return Block{BlockHeader{"", w.path, len(data), w.num, w.numBlocks, 0, "", 0, ""}, data}
+}
+
+// Flush makes the bytes written so far durable on replicas datanodes, 1 if
+// it is 0, each of which forces them to stable storage, and readable by
+// readers of the file. A Block partly written is distributed as far as it
+// goes, and written again in full once it fills.
+func (w *Writer) Flush(replicas int) error {
+	if w.closed {
+		return errors.New("Flush of closed file " + w.path)
+	}
+	if len(w.buf) > 0 {
+		err := w.pl.distributeFlags(w.block(), FSYNC)
+		if err != nil {
+			return err
+		}
+	}
+	err := w.pl.wait()
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(flushTimeout)
+	for {
+		visible, err := flush(w.path, replicas)
+		if err != nil {
+			return err
+		}
+		if visible >= w.written {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out flushing %s, %d of %d bytes are durable", w.path, visible, w.written)
+		}
+		time.Sleep(flushInterval)
+	}
+}
+
+// Close distributes the rest of the file and gives up its lease. A file
+// closed before all of its bytes are written is left incomplete.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	var err error
+	if len(w.buf) > 0 {
+		err = w.pl.distribute(w.block())
+	}
+	if werr := w.pl.wait(); err == nil {
+		err = werr
+	}
+	releaseLease(w.path)
+	if err == nil && w.written < w.size {
+		err = fmt.Errorf("Closed %s after %d of its %d bytes", w.path, w.written, w.size)
+	}
+	return err
+}
+
+// flush asks the namenode to make the Blocks of the file at path stored so
+// far durable on replicas datanodes, returning the bytes which are
+func flush(path string, replicas int) (int64, error) {
+	p := Packet{SRC: id, DST: "NN", CMD: FLUSH, Message: holder}
+	p.Headers = []BlockHeader{{Filename: path}}
+	p.Status = []FileStatus{{Path: path, Replicas: replicas}}
+	r, err := roundTrip(p)
+	if err != nil {
+		return 0, err
+	}
+	if r.CMD == ERROR {
+		return 0, responseError(r)
+	}
+	if r.CMD != ACK || len(r.Status) != 1 {
+		return 0, fmt.Errorf("Bad response packet %v", r)
+	}
+	return r.Status[0].Size, nil
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriterFlush(t *testing.T) {
+
+	format, size, interval, timeout := wireFormat, SIZEOFBLOCK, flushInterval, flushTimeout
+	defer func() {
+		namenodeAddress, namenodeAddresses, wireFormat = "", nil, format
+		SIZEOFBLOCK, flushInterval, flushTimeout = size, interval, timeout
+	}()
+	wireFormat, SIZEOFBLOCK, flushInterval, flushTimeout = "json", 4, time.Millisecond, 5*time.Second
+
+	// the namenode finds the Blocks durable on the second FLUSH of each
+	var lock sync.Mutex
+	var received []BlockHeader
+	var flags []int
+	var commands []int
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		dec, enc := json.NewDecoder(c), json.NewEncoder(c)
+		var p Packet
+		dec.Decode(&p)
+		enc.Encode(Packet{SRC: "NN", DST: "C", CMD: HELLO, Hello: &Hello{Version: protocolVersion, MinVersion: protocolVersion}})
+		var stored int64
+		flushes := 0
+		for {
+			p = Packet{}
+			if dec.Decode(&p) != nil {
+				return
+			}
+			r := Packet{SRC: "NN", DST: "C", CMD: ACK, RequestID: p.RequestID}
+			lock.Lock()
+			commands = append(commands, p.CMD)
+			switch p.CMD {
+			case DISTRIBUTE:
+				received = append(received, p.Data.Header)
+				flags = append(flags, p.Flags)
+				if end := int64(p.Data.Header.BlockNum*SIZEOFBLOCK + p.Data.Header.Size); end > stored {
+					stored = end
+				}
+			case FLUSH:
+				flushes++
+				var visible int64
+				if flushes%2 == 0 {
+					visible = stored
+				}
+				r.Status = []FileStatus{{Path: p.Headers[0].Filename, Size: visible}}
+			}
+			lock.Unlock()
+			enc.Encode(r)
+		}
+	}()
+	if err := Connect(l.Addr().String()); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	w, err := Create("/log.txt", 10)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := w.Write([]byte("abcdef")); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := w.Flush(1); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := w.Write([]byte("ghijk")); err == nil {
+		t.Errorf("Wrote past the size of the file")
+	}
+	if _, err := w.Write([]byte("ghij")); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	// Blocks are sent in parallel, so in no particular order
+	expected := []struct {
+		num, size, flags int
+	}{{0, 4, 0}, {1, 2, FSYNC}, {1, 4, 0}, {2, 2, 0}}
+	if len(received) != len(expected) {
+		t.Fatalf("Expected %d Blocks, got %v", len(expected), received)
+	}
+	order := make([]int, len(received))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := received[order[i]], received[order[j]]
+		return a.BlockNum < b.BlockNum || (a.BlockNum == b.BlockNum && a.Size < b.Size)
+	})
+	for i, e := range expected {
+		h, f := received[order[i]], flags[order[i]]
+		if h.BlockNum != e.num || h.Size != e.size || h.NumBlocks != 3 || f != e.flags {
+			t.Errorf("Block %d distributed as %+v with flags %d, expected %+v", i, h, f, e)
+		}
+	}
+	if commands[0] != LEASE || commands[len(commands)-1] != RELEASE {
+		t.Errorf("Writer did not hold the lease %v", commands)
+	}
+}
+
+func TestWriterConflict(t *testing.T) {
+
+	size := SIZEOFBLOCK
+	defer func() { SIZEOFBLOCK = size }()
+	SIZEOFBLOCK = 4
+	client, server := connectPair(t)
+	defer client.Close()
+	encoder = json.NewEncoder(client)
+	startDispatch(json.NewDecoder(client))
+	var st FileStatus
+	go fakeNamenode(server, make(map[int]Block), &st)
+
+	// a second writer of a file this client is writing is refused
+	w, err := Create("/log.txt", 0)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := Create("/log.txt", 0); !errors.Is(err, ErrWriteConflict) {
+		t.Errorf("Second writer of an open file got %v", err)
+	}
+	if other, err := Create("/other.txt", 0); err != nil {
+		t.Errorf("Writer of another file refused %v", err)
+	} else {
+		other.Close()
+	}
+
+	// the file may be written again once it is closed
+	if err := w.Close(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	w, err = Create("/log.txt", 0)
+	if err != nil {
+		t.Fatalf("Writer refused after the first closed %v", err)
+	}
+	w.Close()
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetXAttr sets the extended attribute name of the file or directory at path
+// to value. Names are in the user namespace, such as user.schema, or the
+// system namespace, which only administrators may change.
+func SetXAttr(path, name string, value []byte) error {
+	p := Packet{SRC: id, DST: "NN", CMD: SETXATTR, Message: name}
+	p.Headers = []BlockHeader{{Filename: path}}
+	p.Status = []FileStatus{{Path: path, XAttrs: map[string][]byte{name: value}}}
+	return send(p)
+}
+
+// GetXAttr returns the value of the extended attribute name of the file or
+// directory at path
+func GetXAttr(path, name string) ([]byte, error) {
+	p := Packet{SRC: id, DST: "NN", CMD: GETXATTR, Message: name}
+	p.Headers = []BlockHeader{{Filename: path}}
+	r, err := roundTrip(p)
+	if err != nil {
+		return nil, err
+	}
+	if r.CMD == ERROR {
+		return nil, responseError(r)
+	}
+	if r.CMD != GETXATTR || len(r.Status) != 1 {
+		return nil, fmt.Errorf("Bad response packet %v", r)
+	}
+	return r.Status[0].XAttrs[name], nil
+}
+
+// ListXAttrs returns the names of the extended attributes of the file or
+// directory at path, in order
+func ListXAttrs(path string) ([]string, error) {
+	r, err := request(LISTXATTRS, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.CMD != LISTXATTRS {
+		return nil, fmt.Errorf("Bad response packet %v", r)
+	}
+	if r.Message == "" {
+		return []string{}, nil
+	}
+	return strings.Split(r.Message, "\n"), nil
+}
+
+// RemoveXAttr removes the extended attribute name of the file or directory
+// at path
+func RemoveXAttr(path, name string) error {
+	p := Packet{SRC: id, DST: "NN", CMD: REMOVEXATTR, Message: name}
+	p.Headers = []BlockHeader{{Filename: path}}
+	return send(p)
+}
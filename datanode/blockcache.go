@@ -0,0 +1,164 @@
+package datanode
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+var cacheSize int64 // bytes of Blocks kept in memory for reads, 0 disables the cache
+
+// CacheStats describes the use of a datanode's block cache
+type CacheStats struct {
+	Capacity int64 // bytes the cache may hold
+	Used     int64 // bytes of the Blocks in the cache
+	Blocks   int   // Blocks in the cache
+	Pinned   int   // Blocks the namenode asked to keep, which are never evicted
+	Hits     int64 // reads answered from the cache
+	Misses   int64 // reads of Blocks missing from the cache
+}
+
+// cache keeps recently read and pinned Blocks in memory
+var cache = newBlockCache()
+
+type cachedBlock struct {
+	name   string
+	b      Block
+	pinned bool
+}
+
+// blockCache keeps the Blocks read most recently in memory, up to cacheSize
+// bytes, so reads of hot Blocks skip the disc and their verification. Blocks
+// the namenode pins for a cache directive are kept until it unpins them,
+// while the others are evicted least recently used first.
+type blockCache struct {
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+	used    int64
+	pinned  int
+	hits    int64
+	misses  int64
+}
+
+func newBlockCache() *blockCache {
+	return &blockCache{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// get returns the cached Block named by h
+func (c *blockCache) get(h BlockHeader) (Block, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[blockName(h)]
+	if !ok {
+		c.misses++
+		return Block{}, false
+	}
+	c.hits++
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedBlock).b, true
+}
+
+// add caches a Block read from the store, evicting the least recently used
+// Blocks which are not pinned to make room. A Block which does not fit is
+// not cached, and an error is returned if it was to be pinned.
+func (c *blockCache) add(b Block, pin bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	name := blockName(b.Header)
+	if e, ok := c.entries[name]; ok {
+		entry := e.Value.(*cachedBlock)
+		if pin && !entry.pinned {
+			entry.pinned = true
+			c.pinned++
+		}
+		c.order.MoveToFront(e)
+		return nil
+	}
+
+	size := int64(len(b.Data))
+	for e := c.order.Back(); e != nil && c.used+size > cacheSize; {
+		prev := e.Prev()
+		if entry := e.Value.(*cachedBlock); !entry.pinned {
+			c.remove(e)
+		}
+		e = prev
+	}
+	if c.used+size > cacheSize {
+		if pin {
+			return errors.New("No room in the block cache for " + name)
+		}
+		return nil
+	}
+	c.entries[name] = c.order.PushFront(&cachedBlock{name, b, pin})
+	c.used += size
+	if pin {
+		c.pinned++
+	}
+	return nil
+}
+
+// remove drops an entry, with the lock held
+func (c *blockCache) remove(e *list.Element) {
+	entry := e.Value.(*cachedBlock)
+	c.order.Remove(e)
+	delete(c.entries, entry.name)
+	c.used -= int64(len(entry.b.Data))
+	if entry.pinned {
+		c.pinned--
+	}
+}
+
+// drop removes the Block named by h, which was written, renamed or deleted
+func (c *blockCache) drop(h BlockHeader) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[blockName(h)]; ok {
+		c.remove(e)
+	}
+}
+
+// unpin lets the Block named by h be evicted again
+func (c *blockCache) unpin(h BlockHeader) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[blockName(h)]; ok {
+		if entry := e.Value.(*cachedBlock); entry.pinned {
+			entry.pinned = false
+			c.pinned--
+		}
+	}
+}
+
+// stats describes the use of the cache
+func (c *blockCache) stats() *CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return &CacheStats{cacheSize, c.used, len(c.entries), c.pinned, c.hits, c.misses}
+}
+
+// pinBlock reads the Block described by h into the cache, and keeps it
+// there until it is unpinned
+func pinBlock(h BlockHeader) error {
+	if cacheSize == 0 {
+		return errors.New("The block cache is disabled")
+	}
+	b, err := store.Get(h)
+	if err != nil {
+		return err
+	}
+	if b.Header.GenStamp < h.GenStamp {
+		return errors.New("Stale Block " + blockName(h))
+	}
+	err = verify(b)
+	if err != nil {
+		suspect(h)
+		return err
+	}
+	return cache.add(b, true)
+}
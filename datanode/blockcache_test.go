@@ -0,0 +1,73 @@
+package datanode
+
+import (
+	"testing"
+)
+
+func TestBlockCache(t *testing.T) {
+
+	store = NewMemStore()
+	addedBlocks = nil
+	removedBlocks = nil
+	cache = newBlockCache()
+	cacheSize = 8
+	defer func() { cacheSize = 0 }()
+
+	a := BlockHeader{"DN1", "/a.txt", 4, 0, 1, 0, "", 0, ""}
+	b := BlockHeader{"DN1", "/b.txt", 4, 0, 1, 0, "", 0, ""}
+	c := BlockHeader{"DN1", "/c.txt", 4, 0, 1, 0, "", 0, ""}
+	WriteBlock(Block{a, []byte("aaaa"), 0})
+	WriteBlock(Block{b, []byte("bbbb"), 0})
+	WriteBlock(Block{c, []byte("cccc"), 0})
+
+	// reads fill the cache, and a repeated read is answered from it
+	BlockFromHeader(a)
+	BlockFromHeader(b)
+	if got := BlockFromHeader(a); string(got.Data) != "aaaa" {
+		t.Fatalf("Wrong cached Block %v", got)
+	}
+	if s := cache.stats(); s.Hits != 1 || s.Misses != 2 || s.Used != 8 || s.Blocks != 2 {
+		t.Fatalf("Wrong cache stats %+v", s)
+	}
+
+	// the least recently used Block is evicted to make room
+	BlockFromHeader(c)
+	if _, ok := cache.get(b); ok {
+		t.Errorf("Least recently used Block not evicted")
+	}
+	if _, ok := cache.get(a); !ok {
+		t.Errorf("Recently used Block evicted")
+	}
+
+	// pinned Blocks stay however much else is read, until unpinned
+	if err := pinBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	BlockFromHeader(a)
+	BlockFromHeader(c)
+	if _, ok := cache.get(b); !ok {
+		t.Errorf("Pinned Block evicted")
+	}
+	if err := pinBlock(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinBlock(c); err == nil {
+		t.Errorf("Pinned a Block into a cache full of pinned Blocks")
+	}
+	cache.unpin(a)
+	if s := cache.stats(); s.Pinned != 1 {
+		t.Errorf("Expected 1 pinned Block, got %+v", s)
+	}
+
+	// a rewritten Block is read from the store again
+	WriteBlock(Block{b, []byte("BBBB"), 0})
+	if got := BlockFromHeader(b); string(got.Data) != "BBBB" {
+		t.Errorf("Stale Block served from the cache %v", got)
+	}
+	if err := DeleteBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.get(b); ok {
+		t.Errorf("Deleted Block still cached")
+	}
+}
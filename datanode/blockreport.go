@@ -0,0 +1,87 @@
+package datanode
+
+import (
+	"time"
+)
+
+var fullReportInterval = time.Hour // time between unsolicited full block reports
+
+var lastReport int64            // ID of the last block report acknowledged by the namenode
+var pendingReport *Packet       // report sent but not yet acknowledged
+var addedBlocks []BlockHeader   // Blocks written since the last report was built
+var removedBlocks []BlockHeader // Blocks deleted since the last report was built
+
+// recordAdded notes a Block written to disc for the next block report
+func recordAdded(h BlockHeader) {
+	for i, v := range removedBlocks {
+		if v == h {
+			removedBlocks = append(removedBlocks[:i], removedBlocks[i+1:]...)
+			break
+		}
+	}
+	addedBlocks = append(addedBlocks, h)
+}
+
+// recordRemoved notes a Block deleted from disc for the next block report
+func recordRemoved(h BlockHeader) {
+	for i, v := range addedBlocks {
+		if v == h {
+			addedBlocks = append(addedBlocks[:i], addedBlocks[i+1:]...)
+			break
+		}
+	}
+	removedBlocks = append(removedBlocks, h)
+}
+
+// currentReportID is the report ID the namenode should have applied last,
+// which is sent with each heartbeat so it can detect missed reports
+func currentReportID() int64 {
+	if pendingReport != nil {
+		return pendingReport.ReportID
+	}
+	return lastReport
+}
+
+// FullReport lists every stored Block. It starts a new sequence of report IDs,
+// so changes recorded before it are discarded.
+func FullReport() Packet {
+	r := Packet{SRC: id, DST: "NN", CMD: LIST}
+	r.Headers, r.Storages = storedBlocks()
+
+	// IDs from the clock do not repeat when the datanode restarts
+	r.ReportID = time.Now().UnixNano()
+	addedBlocks = nil
+	removedBlocks = nil
+	pendingReport = &r
+	return r
+}
+
+// IncrementalReport returns the report of Blocks added and removed since the
+// last acknowledged report, or nil if nothing changed. An unacknowledged
+// report is sent again rather than starting a new one.
+func IncrementalReport() *Packet {
+	if pendingReport != nil {
+		return pendingReport
+	}
+	if len(addedBlocks) == 0 && len(removedBlocks) == 0 {
+		return nil
+	}
+
+	r := Packet{SRC: id, DST: "NN", CMD: BLOCKREPORT}
+	r.ReportID = lastReport + 1
+	r.Headers = addedBlocks
+	r.Storages = reportStorages(addedBlocks)
+	r.Removed = removedBlocks
+	addedBlocks = nil
+	removedBlocks = nil
+	pendingReport = &r
+	return pendingReport
+}
+
+// AcknowledgeReport records that the namenode applied the report reportID
+func AcknowledgeReport(reportID int64) {
+	if pendingReport != nil && pendingReport.ReportID == reportID {
+		lastReport = reportID
+		pendingReport = nil
+	}
+}
@@ -0,0 +1,43 @@
+package datanode
+
+import (
+	"testing"
+)
+
+func TestIncrementalReportSequence(t *testing.T) {
+
+	lastReport = 10
+	pendingReport = nil
+	addedBlocks = nil
+	removedBlocks = nil
+
+	if IncrementalReport() != nil {
+		t.Errorf("Report sent without changes")
+	}
+
+	h1 := BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""}
+	h2 := BlockHeader{"DN1", "/tmp.txt", 1, 0, 1, 0, "", 0, ""}
+	recordAdded(h1)
+	recordAdded(h2)
+	recordRemoved(h2)
+
+	r := IncrementalReport()
+	if r == nil || r.ReportID != 11 {
+		t.Fatalf("Expected report 11, got %v", r)
+	}
+	if len(r.Headers) != 1 || r.Headers[0] != h1 || len(r.Removed) != 1 {
+		t.Errorf("Unexpected report contents %v", r)
+	}
+
+	// unacknowledged reports are resent unchanged
+	recordAdded(h2)
+	if again := IncrementalReport(); again.ReportID != 11 || currentReportID() != 11 {
+		t.Errorf("Pending report was not resent")
+	}
+
+	AcknowledgeReport(11)
+	r = IncrementalReport()
+	if r == nil || r.ReportID != 12 || len(r.Headers) != 1 || r.Headers[0] != h2 {
+		t.Errorf("Expected report 12 with the new block, got %v", r)
+	}
+}
@@ -0,0 +1,138 @@
+package datanode
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var storage = "disk" // kind of BlockStore holding the Blocks
+var store BlockStore // where the datanode keeps its Blocks
+
+// BlockStore keeps the Blocks of a datanode. Blocks are identified by the
+// Filename and BlockNum of their headers. A Block which is not stored gives
+// an error satisfying os.IsNotExist.
+type BlockStore interface {
+	Put(b Block) error                       // store b, replacing any stored Block with its name
+	Get(h BlockHeader) (Block, error)        // retrieve the Block named by h
+	Delete(h BlockHeader) error              // remove the Block named by h
+	List() ([]BlockHeader, error)            // headers of every stored Block
+	Stat(h BlockHeader) (BlockHeader, error) // header of the stored Block named by h
+}
+
+// relabeler is implemented by stores which can change the header of a
+// stored Block without rewriting its data, for a Block renamed under its ID
+type relabeler interface {
+	Relabel(h, to BlockHeader) error // give the Block named by h the header to, of the same name
+}
+
+// OpenStore returns the BlockStore chosen by the storage configuration option
+func OpenStore() (BlockStore, error) {
+	switch storage {
+	case "disk":
+		if len(volumeDirs) == 0 {
+			return nil, errors.New("Disk storage needs blockfileroot")
+		}
+		s := NewVolumeStore(volumeDirs)
+		// the layout of each volume is checked before its Blocks are served
+		s.(*volumeStore).repair()
+		return s, nil
+	case "memory":
+		return NewMemStore(), nil
+	case "s3":
+		return NewS3Store(s3Config)
+	}
+	return nil, errors.New("Unknown storage " + storage)
+}
+
+// blockName is the name of a Block within a store, made of its ID, or for a
+// Block written before IDs its file name, with the slashes escaped, and its
+// Block number
+func blockName(h BlockHeader) string {
+	if h.BlockID != 0 {
+		return "blk_" + strconv.FormatInt(h.BlockID, 10)
+	}
+	escaper := strings.NewReplacer("%", "%25", "/", "%2F")
+	return escaper.Replace(strings.TrimPrefix(h.Filename, "/")) + "/" + strconv.Itoa(h.BlockNum)
+}
+
+// memStore keeps Blocks in memory, which is lost when the datanode stops
+type memStore struct {
+	lock   sync.Mutex
+	blocks map[string]Block
+}
+
+// NewMemStore returns an empty BlockStore keeping Blocks in memory
+func NewMemStore() BlockStore {
+	return &memStore{blocks: make(map[string]Block)}
+}
+
+func (s *memStore) Put(b Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data := make([]byte, len(b.Data))
+	copy(data, b.Data)
+	s.blocks[blockName(b.Header)] = Block{b.Header, data, b.Checksum}
+	return nil
+}
+
+func (s *memStore) Get(h BlockHeader) (Block, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	b, ok := s.blocks[blockName(h)]
+	if !ok {
+		return Block{}, os.ErrNotExist
+	}
+	return b, nil
+}
+
+func (s *memStore) Relabel(h, to BlockHeader) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	b, ok := s.blocks[blockName(h)]
+	if !ok {
+		return os.ErrNotExist
+	}
+	b.Header = to
+	s.blocks[blockName(to)] = b
+	return nil
+}
+
+func (s *memStore) Delete(h BlockHeader) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	name := blockName(h)
+	if _, ok := s.blocks[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(s.blocks, name)
+	return nil
+}
+
+func (s *memStore) List() ([]BlockHeader, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	names := make([]string, 0, len(s.blocks))
+	for name := range s.blocks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	headers := make([]BlockHeader, 0, len(names))
+	for _, name := range names {
+		headers = append(headers, s.blocks[name].Header)
+	}
+	return headers, nil
+}
+
+func (s *memStore) Stat(h BlockHeader) (BlockHeader, error) {
+	b, err := s.Get(h)
+	return b.Header, err
+}
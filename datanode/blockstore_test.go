@@ -0,0 +1,135 @@
+package datanode
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 serves a single bucket of objects over the S3 REST API
+func fakeS3(t *testing.T, bucket string) *httptest.Server {
+	var lock sync.Mutex
+	objects := make(map[string][]byte)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=KEY/") {
+			t.Errorf("Request not signed %v", r.Header)
+		}
+		if r.URL.Path == "/"+bucket && r.Method == "GET" {
+			result := listBucketResult{}
+			keys := make([]string, 0)
+			for k := range objects {
+				if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				result.Contents = append(result.Contents, struct{ Key string }{k})
+			}
+			xml.NewEncoder(w).Encode(result)
+			return
+		}
+
+		key := strings.TrimPrefix(r.URL.Path, "/"+bucket+"/")
+		switch r.Method {
+		case "PUT":
+			objects[key], _ = ioutil.ReadAll(r.Body)
+		case "GET":
+			data, ok := objects[key]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		case "DELETE":
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+func TestBlockStores(t *testing.T) {
+
+	server := fakeS3(t, "blocks")
+	defer server.Close()
+	s3, err := NewS3Store(S3Config{Endpoint: server.URL, Bucket: "blocks", Prefix: "DN1/", Region: "us-east-1", AccessKey: "KEY", SecretKey: "SECRET"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	stores := map[string]BlockStore{
+		"disk":   NewDiskStore(t.TempDir()),
+		"memory": NewMemStore(),
+		"s3":     s3,
+	}
+	for name, s := range stores {
+		a := Block{BlockHeader{"DN1", "/dir/a%b.txt", 4, 0, 2, 1, "", 0, ""}, []byte("data"), 0}
+		b := Block{BlockHeader{"DN1", "/dir/a%b.txt", 2, 1, 2, 1, "", 0, ""}, []byte("da"), 0}
+		for _, blk := range []Block{a, b} {
+			if err := s.Put(blk); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+		}
+
+		got, err := s.Get(a.Header)
+		if err != nil || got.Header != a.Header || string(got.Data) != "data" {
+			t.Errorf("%s: Get returned %v %v", name, got, err)
+		}
+		h, err := s.Stat(BlockHeader{Filename: "/dir/a%b.txt", BlockNum: 1})
+		if err != nil || h != b.Header {
+			t.Errorf("%s: Stat returned %v %v", name, h, err)
+		}
+		headers, err := s.List()
+		if err != nil || len(headers) != 2 {
+			t.Errorf("%s: List returned %v %v", name, headers, err)
+		}
+
+		if err := s.Delete(a.Header); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if _, err := s.Get(a.Header); !os.IsNotExist(err) {
+			t.Errorf("%s: Expected deleted Block to be missing, got %v", name, err)
+		}
+		if err := s.Delete(a.Header); !os.IsNotExist(err) {
+			t.Errorf("%s: Expected deleting a missing Block to fail, got %v", name, err)
+		}
+		headers, _ = s.List()
+		if len(headers) != 1 || headers[0] != b.Header {
+			t.Errorf("%s: Expected only the remaining Block, got %v", name, headers)
+		}
+	}
+}
+
+func TestDeleteKeepsNewerGeneration(t *testing.T) {
+
+	store = NewMemStore()
+	addedBlocks = nil
+	removedBlocks = nil
+
+	old := BlockHeader{"DN1", "/out.txt", 4, 0, 1, 1, "", 0, ""}
+	newer := BlockHeader{"DN1", "/out.txt", 4, 0, 1, 2, "", 0, ""}
+	WriteBlock(Block{newer, []byte("data"), 0})
+
+	if err := DeleteBlock(old); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if BlockFromHeader(old).Header != newer {
+		t.Errorf("Newer generation was deleted")
+	}
+	if err := DeleteBlock(newer); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(GetBlockHeaders()) != 0 || len(removedBlocks) != 1 {
+		t.Errorf("Block was not deleted %v", removedBlocks)
+	}
+}
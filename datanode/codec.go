@@ -0,0 +1,116 @@
+package datanode
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/sjarvie/godfs/msgpack"
+)
+
+var wireCodec = "json" // codec of frames offered to the namenode: json, gob or msgpack
+
+// packetCodec encodes the Packet of a frame, without its Block's data,
+// which follows it as raw bytes
+type packetCodec interface {
+	Marshal(p *Packet) ([]byte, error)
+	Unmarshal(b []byte, p *Packet) error
+}
+
+// frameCodecs are the codecs the Packet of a frame may be in, numbered from
+// 1 by the version byte of the frame. Version 1 is JSON, which every node
+// reads, while the others are only sent and read once the namenode chose
+// them in the HELLO.
+var frameCodecs = []struct {
+	name  string
+	codec packetCodec
+}{
+	{"json", jsonCodec{}},
+	{"gob", gobCodec{}},
+	{"msgpack", msgpackCodec{}},
+}
+
+// codecVersion returns the frame version of the codec named, 0 if it is not
+// known
+func codecVersion(name string) byte {
+	for i, c := range frameCodecs {
+		if c.name == name {
+			return byte(i + 1)
+		}
+	}
+	return 0
+}
+
+// frameCodec returns the codec of frames of version, nil if it is not known
+func frameCodec(version byte) packetCodec {
+	if version < 1 || int(version) > len(frameCodecs) {
+		return nil
+	}
+	return frameCodecs[version-1].codec
+}
+
+// jsonCodec encodes Packets as JSON, which is the easiest to debug
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(p *Packet) ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (jsonCodec) Unmarshal(b []byte, p *Packet) error {
+	return json.Unmarshal(b, p)
+}
+
+// gobCodec encodes Packets with encoding/gob. Each frame stands alone, so
+// it carries the description of the Packet's types as well. gob is not
+// hardened against hostile input, so it suits trusted networks only.
+type gobCodec struct{}
+
+func (gobCodec) Marshal(p *Packet) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(p)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(b []byte, p *Packet) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(p)
+}
+
+// offeredCodecs lists the codecs offered to the namenode in the HELLO
+func offeredCodecs() []string {
+	if wireFormat == "json" || wireCodec == "json" {
+		return nil
+	}
+	return []string{wireCodec}
+}
+
+// msgpackCodec encodes Packets as MessagePack, which is smaller than JSON
+// and as quick to read
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(p *Packet) ([]byte, error) {
+	return msgpack.Marshal(p)
+}
+
+func (msgpackCodec) Unmarshal(b []byte, p *Packet) error {
+	return msgpack.Unmarshal(b, p)
+}
+
+// encodedWith returns encoder sending frames in the codec named, if it
+// sends frames and the codec is known
+func encodedWith(encoder packetEncoder, name string) packetEncoder {
+	e, ok := encoder.(*frameEncoder)
+	if v := codecVersion(name); ok && v != 0 {
+		return &frameEncoder{e.w, v}
+	}
+	return encoder
+}
+
+// decodedWith lets decoder read frames in the codec named, once it is agreed
+// in the HELLO, as well as JSON
+func decodedWith(decoder packetDecoder, name string) {
+	if c, ok := decoder.(*chunkDecoder); ok {
+		decoder = c.decoder
+	}
+	if d, ok := decoder.(*frameDecoder); ok {
+		d.codec = codecVersion(name)
+	}
+}
@@ -0,0 +1,42 @@
+package datanode
+
+import (
+	"testing"
+)
+
+// recorder keeps the Packets encoded to it
+type recorder struct {
+	sent []Packet
+}
+
+func (r *recorder) Encode(v interface{}) error {
+	r.sent = append(r.sent, v.(Packet))
+	return nil
+}
+
+func TestHeartbeatCommands(t *testing.T) {
+
+	store = NewMemStore()
+	id = "DN1"
+	defer func() { stopping, pendingReport = false, nil }()
+	h := BlockHeader{"DN1", "/out.txt", 4, 0, 1, 1, "", 0, ""}
+	WriteBlock(Block{h, []byte("data"), 0})
+
+	// the commands of a heartbeat's answer are carried out in order, each
+	// answered on its own
+	var r recorder
+	HandleResponse(Packet{SRC: "NN", DST: "DN1", CMD: ACK, Commands: []Packet{
+		{SRC: "NN", DST: "DN1", CMD: LIST},
+		{SRC: "NN", DST: "DN1", CMD: INVALIDATE, Headers: []BlockHeader{h}},
+		{SRC: "NN", DST: "DN1", CMD: SHUTDOWN},
+	}}, &r)
+	if len(r.sent) != 2 || r.sent[0].CMD != LIST || len(r.sent[0].Headers) != 1 || r.sent[1].CMD != INVALIDATEACK {
+		t.Fatalf("Wrong answers to commands %v", r.sent)
+	}
+	if _, err := store.Stat(h); err == nil {
+		t.Errorf("Invalidated Block kept")
+	}
+	if !stopping {
+		t.Errorf("Datanode not stopping")
+	}
+}
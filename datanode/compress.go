@@ -0,0 +1,132 @@
+package datanode
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// maxDecodedSize bounds the size a compressed Block may claim
+const maxDecodedSize = 1 << 30
+
+// decompress returns the data of a Block compressed by the client with codec
+// in its original form
+func decompress(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case "":
+		return data, nil
+	case "gzip":
+		return gunzip(data, maxDecodedSize)
+	case "snappy":
+		return snappyDecode(data)
+	}
+	return nil, errors.New("Unknown compression codec " + codec)
+}
+
+// blockData returns the data of b as the client wrote it, still sealed if
+// it is encrypted
+func blockData(b Block) ([]byte, error) {
+	if b.Header.Nonce != "" {
+		return b.Data, nil
+	}
+	data, err := decompress(b.Header.Codec, b.Data)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) < b.Header.Size {
+		return nil, errors.New("Block holds " + strconv.Itoa(len(data)) + " bytes, expected " + strconv.FormatInt(b.Header.Size, 10))
+	}
+	return data[:b.Header.Size], nil
+}
+
+// gunzip decompresses gzip data, refusing data which decompresses to more
+// than limit bytes
+func gunzip(data []byte, limit int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	out, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, errors.New("Gzip data decompresses to more than " + strconv.FormatInt(limit, 10) + " bytes")
+	}
+	return out, nil
+}
+
+var errCorruptSnappy = errors.New("Corrupt snappy data")
+
+// snappyDecode decompresses data in the snappy block format
+func snappyDecode(src []byte) ([]byte, error) {
+	size, k := binary.Uvarint(src)
+	if k <= 0 || size > maxDecodedSize {
+		return nil, errCorruptSnappy
+	}
+	dst := make([]byte, 0, size)
+	s := k
+	for s < len(src) {
+		tag := src[s]
+		var length, offset int
+		switch tag & 3 {
+		case 0:
+			length = int(tag >> 2)
+			s++
+			if length >= 60 {
+				extra := length - 59
+				if s+extra > len(src) {
+					return nil, errCorruptSnappy
+				}
+				length = 0
+				for j := 0; j < extra; j++ {
+					length |= int(src[s+j]) << (8 * uint(j))
+				}
+				s += extra
+			}
+			length++
+			if length <= 0 || length > len(src)-s || len(dst)+length > int(size) {
+				return nil, errCorruptSnappy
+			}
+			dst = append(dst, src[s:s+length]...)
+			s += length
+			continue
+		case 1:
+			if s+2 > len(src) {
+				return nil, errCorruptSnappy
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag>>5)<<8 | int(src[s+1])
+			s += 2
+		case 2:
+			if s+3 > len(src) {
+				return nil, errCorruptSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case 3:
+			if s+5 > len(src) {
+				return nil, errCorruptSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(size) {
+			return nil, errCorruptSnappy
+		}
+		// copies may overlap the bytes they produce
+		for j := 0; j < length; j++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != int(size) {
+		return nil, errCorruptSnappy
+	}
+	return dst, nil
+}
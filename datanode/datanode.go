@@ -6,12 +6,12 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"github.com/sjarvie/godfs/quicconn"
 	"log"
-	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,17 +24,100 @@ var root string       // the root location on disk to store Blocks
 
 var state = HB // internal statemachine
 
-// commands for node communication
+var stopping bool // the namenode asked the datanode to shut down
+
+// commands for node communication, sent by number, so new commands are
+// only added at the end
+const (
+	HB                = iota // heartbeat
+	LIST              = iota // list directorys
+	ACK               = iota // acknowledgement
+	BLOCK             = iota // handle the incoming Block
+	BLOCKACK          = iota // notifcation that Block was written to disc
+	RETRIEVEBLOCK     = iota // request to retrieve a Block
+	DISTRIBUTE        = iota // request to distribute a Block to a datanode
+	GETHEADERS        = iota // request to retrieve the headers of a given filename
+	ERROR             = iota // notification of a failed request
+	INVALIDATE        = iota // request to delete the listed Blocks from a datanode
+	INVALIDATEACK     = iota // notification that invalidated Blocks were deleted
+	DELETE            = iota // request to delete a file
+	BLOCKREPORT       = iota // incremental report of Blocks added and removed on a datanode
+	STAT              = iota // request the status of a file or directory
+	LISTDIR           = iota // request the status of the entries of a directory
+	MKDIR             = iota // request to create a directory
+	SETQUOTA          = iota // request to set the quotas of a directory
+	GETQUOTA          = iota // request the quotas and usage of a directory
+	RENAME            = iota // request to move a file or directory, or the listed Blocks of a datanode
+	RENAMEACK         = iota // notification that renamed Blocks were moved
+	CREATESNAPSHOT    = iota // request to capture a directory as a named snapshot
+	DELETESNAPSHOT    = iota // request to delete a snapshot of a directory
+	LISTSNAPSHOT      = iota // request the snapshots of a directory
+	DECOMMISSION      = iota // request to drain a datanode and remove it from the cluster
+	BALANCE           = iota // request to move Blocks until datanodes store similar amounts
+	LEASE             = iota // request the lease on a file before writing it
+	RELEASE           = iota // notification that a file is written and its lease may be given up
+	ERASURECODE       = iota // request to store a file being written with an erasure coding policy
+	CORRUPTBLOCK      = iota // notification that the listed Blocks of a datanode failed verification
+	HELLO             = iota // handshake describing a node, the first packet of a connection
+	CREATEZONE        = iota // request to make an empty directory an encryption zone
+	LISTZONES         = iota // request the encryption zones and their keys
+	FILEKEY           = iota // request to store the wrapped data key of a file being written in an encryption zone
+	REPORT            = iota // request a report on the datanodes and the namespace
+	SAFEMODE          = iota // request to enter, leave or report the read-only safe mode
+	REFRESHNODES      = iota // request to reload the host lists and the topology of the datanodes
+	SETBLOCKSIZE      = iota // request to change the default size of new Blocks
+	LISTLEASES        = iota // request the leases on files being written
+	TRIGGERREPORT     = iota // request a full block report from a datanode, or from all of them
+	SETREP            = iota // request to change the replication factor of a file or of the files below a directory
+	REPLICATE         = iota // request to copy a Block directly to another datanode
+	REPLICATEACK      = iota // notification that a datanode copied a Block to another, or could not
+	TRUNCATE          = iota // request to shorten a file to a length
+	GLOB              = iota // request the status of the files and directories matching a pattern
+	SETTIMES          = iota // request to set the modification and access times of a file or directory
+	SETXATTR          = iota // request to set an extended attribute of a file or directory
+	GETXATTR          = iota // request the value of an extended attribute
+	LISTXATTRS        = iota // request the names of the extended attributes of a file or directory
+	REMOVEXATTR       = iota // request to remove an extended attribute
+	CREATESYMLINK     = iota // request to create a symbolic link to a path
+	READLINK          = iota // request the target of a symbolic link
+	SUBSCRIBE         = iota // request the events of the namespace below a path
+	UNSUBSCRIBE       = iota // request to end a subscription to events
+	EVENT             = iota // a change to the namespace, sent to a subscription
+	HASTATE           = iota // request to make a namenode the active or the standby, or report which it is
+	REGISTER          = iota // request the ID a datanode is known by, following its HELLO
+	SHUTDOWN          = iota // request to stop a datanode, sent with the answer to its heartbeat
+	SETBANDWIDTH      = iota // request to cap the bytes per second a datanode sends copying replicas to other datanodes
+	BATCH             = iota // request to perform many namespace requests at once, answered with the answer to each
+	ARCHIVE           = iota // request to index the files packed into an archive file, which are then read through it
+	CACHE             = iota // request to keep the Blocks of the files at or below a path in the memory of their datanodes
+	UNCACHE           = iota // request to stop keeping the Blocks of a path given to CACHE in memory
+	LISTCACHE         = iota // request the paths whose Blocks are kept in memory
+	STORAGEPOLICY     = iota // request to set the storage policy of a file or directory, or to clear it
+	MIGRATE           = iota // request a datanode to move Blocks to its volumes of another storage type
+	SNAPSHOTDIFF      = iota // request the paths changed below a directory between two of its snapshots
+	TENANTS           = iota // request the use each tenant makes of the namenode, or to reload the tenants
+	CHUNK             = iota // part of the Block data of the packet before it
+	GETREPORT         = iota // request the datanodes and totals of the cluster as JSON
+	RECOVERLEASE      = iota // request to take the lease on a file from its writer at once
+	GETBLOCKLOCATIONS = iota // request the replicas of the Blocks of a file within the byte range of Offset and Limit
+	FLUSH             = iota // request to make the Blocks of a file being written durable on a number of replicas, answered with its visible length
+	SYNC              = iota // request a datanode to force the listed Blocks to stable storage
+	SYNCACK           = iota // notification that the listed Blocks are on stable storage
+	RESUME            = iota // request to write a file resumably, or to resume its write, answered with the Blocks it is missing
+	CHECKSUM          = iota // request for the CRC-32 of a Block's data, answered by its datanode
+	CHECKSUMACK       = iota // answer to a CHECKSUM with the CRC-32 of the Block's uncompressed data
+)
+
+// flags modifying commands
 const (
-	HB            = iota // heartbeat
-	LIST          = iota // list directorys
-	ACK           = iota // acknowledgement
-	BLOCK         = iota // handle the incoming Block
-	BLOCKACK      = iota // notifcation that Block was written to disc
-	RETRIEVEBLOCK = iota // request to retrieve a Block
-	DISTRIBUTE    = iota // request to distribute a Block to a datanode
-	GETHEADERS    = iota // request to retrieve the headers of a given filename
-	ERROR         = iota // notification of a failed request
+	RECURSIVE = 1 << iota // DELETE and LISTDIR directory contents
+	PARENTS               // MKDIR creates missing parent directories
+	SKIPTRASH             // DELETE removes immediately rather than moving to the trash
+	CAPACITY              // HB reports the free storage of a datanode in Capacity
+	FSYNC                 // DISTRIBUTE and BLOCK have the datanode force the Block to stable storage before its BLOCKACK, which has it set
+	CREATE                // LEASE may create the file if it does not exist
+	OVERWRITE             // LEASE empties an existing file, so the Blocks written replace its own
+	EXCLUSIVE             // LEASE fails if the file exists
 )
 
 // The XML parsing structures for configuration options
@@ -50,8 +133,9 @@ type ConfigOption struct {
 
 // A file is composed of one or more Blocks
 type Block struct {
-	Header BlockHeader // metadata
-	Data   []byte      // data contents
+	Header   BlockHeader // metadata
+	Data     []byte      // data contents
+	Checksum uint32      // CRC-32 of Data, set by the datanode storing the Block
 }
 
 // Blockheaders hold Block metadata
@@ -61,16 +145,54 @@ type BlockHeader struct {
 	Size       int64  // size of Block in bytes
 	BlockNum   int    // the 0 indexed position of Block within file
 	NumBlocks  int    // total number of Blocks in file
+	GenStamp   int64  // generation of the Block, assigned by the namenode when it is written
+	Codec      string // compression of the Block's data, empty if it is not compressed
+	BlockID    int64  // unique ID of the Block given by the namenode, 0 for Blocks written before IDs
+	Nonce      string // base64 nonce the client sealed the Block's data with, empty if it is not encrypted
 }
 
 // Packets are sent over the network
 type Packet struct {
-	SRC     string        // source ID
-	DST     string        // destination ID
-	CMD     int           // command for the handler
-	Message string        // optional packet contents explanation
-	Data    Block         // optional Block
-	Headers []BlockHeader // optional BlockHeader list
+	SRC       string        // source ID
+	DST       string        // destination ID
+	CMD       int           // command for the handler
+	Message   string        // optional packet contents explanation
+	Data      Block         // optional Block
+	Headers   []BlockHeader // optional BlockHeader list
+	Removed   []BlockHeader // optional BlockHeader list of deleted Blocks
+	Renamed   []BlockHeader // optional new BlockHeaders of the renamed Blocks in Headers
+	ReportID  int64         // identifies a block report and its acknowledgement
+	RequestID int64         // identifies a request, echoed in its response
+	Flags     int           // optional command flags
+	Status    []FileStatus  // optional file and directory descriptions
+	Address   string        // optional HTTP address a datanode serves on
+	Capacity  int64         // optional free bytes of a datanode, with CAPACITY set
+	Hello     *Hello        // optional description of a node, with HELLO
+	User      string        // user a client request is made as, for the audit log
+	Key       string        // optional idempotency key of a mutating client request, the same in its retries
+	Commands  []Packet      // optional commands for a datanode, with the answer to its heartbeat, or the requests of a BATCH and their answers
+	Offset    int           // optional first item of a paged answer, such as the block number of the first header, or the first byte of GETBLOCKLOCATIONS
+	Limit     int           // optional most items of a paged answer, or bytes of GETBLOCKLOCATIONS, 0 for all
+	Cache     *CacheStats   // optional use of a datanode's block cache, with its heartbeat
+	Storage   string        // storage type a Block is written or moved to, such as SSD
+	Storages  []string      // storage type of each of the Headers of a block report, or of a datanode's volumes with its heartbeat
+	Chunked   int64         // optional bytes of the Block data sent after it in CHUNK packets
+	Code      string        // machine-readable kind of an ERROR, such as FILE_NOT_FOUND
+}
+
+// FileStatus describes a file or directory in the namespace
+type FileStatus struct {
+	Path        string // absolute path
+	IsDir       bool   // true for directories
+	Size        int64  // total size of a file in bytes
+	NumBlocks   int    // number of Blocks in a file
+	Replication int    // fewest replicas of any Block in a file
+	Children    int    // number of entries in a directory
+	FileQuota   int    // maximum number of files below a directory, 0 for none
+	SpaceQuota  int64  // maximum bytes of file data below a directory, 0 for none
+	FileCount   int    // number of files below a directory, when its usage was requested
+	SpaceUsed   int64  // bytes of file data below a directory, when its usage was requested
+	Erasure     string // erasure coding policy of a file, such as RS-6-3, empty for replicated files
 }
 type errorString struct {
 	s string
@@ -86,61 +208,193 @@ func New(text string) error {
 }
 
 // ReceivePacket decodes a packet and adds it to the handler channel
-// for processing by the datanode
-func ReceivePackets(decoder *json.Decoder, p chan Packet) {
+// for processing by the datanode, closing the channel once the connection
+// fails
+func ReceivePackets(decoder packetDecoder, p chan Packet) {
 	for {
 		r := new(Packet)
-		decoder.Decode(r)
+		err := decoder.Decode(r)
+		if isBadFrame(err) {
+			log.Println("Skipped frame ", err)
+			continue
+		}
+		if err != nil {
+			log.Println("Could not read from namenode ", err)
+			close(p)
+			return
+		}
 		p <- *r
 	}
 }
 
 // SendHeartbeat is used to notify the namenode of a valid connection
 // on a periodic basis
-func SendHeartbeat(encoder *json.Encoder) {
+func SendHeartbeat(encoder packetEncoder) {
 	p := new(Packet)
 	p.SRC = id
 	p.DST = "NN"
 	p.CMD = HB
+	p.ReportID = currentReportID()
+	p.Address = httpAddress
+	addCapacity(p)
+	p.Storages = onlyTiered(storages())
+	if cacheSize > 0 {
+		p.Cache = cache.stats()
+	}
 	encoder.Encode(p)
 }
 
+// SendBlockReport sends the namenode any Blocks added or removed since the
+// last acknowledged report
+func SendBlockReport(encoder packetEncoder) {
+	r := IncrementalReport()
+	if r != nil {
+		encoder.Encode(*r)
+	}
+}
+
 // HandleResponse delegates actions to perform based on the
 // contents of a recieved Packet, and encodes a response
-func HandleResponse(p Packet, encoder *json.Encoder) {
+func HandleResponse(p Packet, encoder packetEncoder) {
+	// commands sent with the answer to a heartbeat are carried out in order
+	for _, c := range p.Commands {
+		HandleResponse(c, encoder)
+	}
+
 	r := new(Packet)
 	r.SRC = id
 	r.DST = p.SRC
 
 	switch p.CMD {
 	case ACK:
+		if p.ReportID != 0 {
+			AcknowledgeReport(p.ReportID)
+		}
+		return
+	case ERROR:
+		log.Println("Namenode reported an error ", p.Message)
 		return
 	case LIST:
-		list := GetBlockHeaders()
-		r.Headers = make([]BlockHeader, len(list))
-
-		for i, b := range list {
-			r.Headers[i] = b
-		}
-		r.CMD = LIST
+		*r = FullReport()
 	case BLOCK:
 		r.CMD = BLOCKACK
-		WriteBlock(p.Data)
+		writeBlockOn(p.Data, p.Storage)
 		r.Headers = make([]BlockHeader, 0, 2)
 		r.Headers = append(r.Headers, p.Data.Header)
+		// a Block being flushed is acknowledged once it is on stable storage
+		if p.Flags&FSYNC != 0 {
+			err := syncBlock(p.Data.Header)
+			if err != nil {
+				log.Println("Could not sync Block ", blockName(p.Data.Header), err)
+			} else {
+				r.Flags = FSYNC
+			}
+		}
 
 	case RETRIEVEBLOCK:
 		fmt.Println("retrieving block from ", p.Headers[0])
 		b := BlockFromHeader(p.Headers[0])
 		r.CMD = BLOCK
 		r.Data = b
+
+	case CHECKSUM:
+		r.CMD = CHECKSUMACK
+		sum, err := blockChecksum(p.Headers[0])
+		if err != nil {
+			// answered without the header, so the client is told it failed
+			log.Println("Could not checksum Block ", blockName(p.Headers[0]), err)
+			r.Message = err.Error()
+			break
+		}
+		r.Headers = p.Headers
+		r.Message = strconv.FormatUint(uint64(sum), 10)
+
+	case SHUTDOWN:
+		log.Println("Namenode asked the datanode to shut down")
+		stopping = true
+		return
+
+	case SETBANDWIDTH:
+		n, err := strconv.ParseInt(p.Message, 10, 64)
+		if err != nil || n < 0 {
+			log.Println("Invalid transfer bandwidth ", p.Message)
+			return
+		}
+		atomic.StoreInt64(&transferBandwidth, n)
+		log.Println("Transfer bandwidth set to ", n, " bytes per second")
+		return
+
+	case CACHE:
+		for _, h := range p.Headers {
+			err := pinBlock(h)
+			if err != nil {
+				log.Println("Could not cache Block ", blockName(h), err)
+			}
+		}
+		return
+
+	case UNCACHE:
+		for _, h := range p.Headers {
+			cache.unpin(h)
+		}
+		return
+
+	case MIGRATE:
+		migrateBlocks(p)
+		return
+
+	case REPLICATE:
+		// the transfer is reported once done, without holding up the main loop
+		if len(p.Headers) == 1 {
+			go replicateBlock(p)
+		}
+		return
+
+	case INVALIDATE:
+		r.CMD = INVALIDATEACK
+		r.Headers = make([]BlockHeader, 0, len(p.Headers))
+		for _, h := range p.Headers {
+			err := DeleteBlock(h)
+			if err != nil {
+				log.Println("Could not delete Block ", err)
+				continue
+			}
+			r.Headers = append(r.Headers, h)
+		}
+
+	case SYNC:
+		*r = syncBlocks(p)
+
+	case RENAME:
+		r.CMD = RENAMEACK
+		r.Headers = make([]BlockHeader, 0, len(p.Headers))
+		r.Renamed = make([]BlockHeader, 0, len(p.Headers))
+		for i, h := range p.Headers {
+			if i >= len(p.Renamed) {
+				break
+			}
+			err := RenameBlock(h, p.Renamed[i])
+			if err != nil {
+				log.Println("Could not rename Block ", err)
+				continue
+			}
+			r.Headers = append(r.Headers, h)
+			r.Renamed = append(r.Renamed, p.Renamed[i])
+		}
 	}
+	r.RequestID = p.RequestID
 	encoder.Encode(*r)
 }
 
 // WriteBlock performs all functionality necessary to write a Block b
-// to the local filesystem
+// to the BlockStore
 func WriteBlock(b Block) {
+	writeBlockOn(b, "")
+}
+
+// writeBlockOn writes the Block b to a volume of the storage type, if the
+// datanode has one
+func writeBlockOn(b Block, storage string) {
 	defer func() {
 		if r := recover(); r != nil {
 			fmt.Println("Recovered from panic ", r)
@@ -149,113 +403,174 @@ func WriteBlock(b Block) {
 		}
 	}()
 
-	list, err := ioutil.ReadDir(root)
 	h := b.Header
-	fname := h.Filename + "/" + strconv.Itoa(h.BlockNum)
-
-	for _, dir := range list {
-
-		if "/"+dir.Name() == h.Filename {
-			WriteJSON(root+fname, b)
-			log.Println("Wrote Block ", root+fname, "to disc")
-			return
-		}
+	if b.Checksum == 0 {
+		b.Checksum = checksum(b.Data)
 	}
-	// create directory
-	err = os.Mkdir(root+h.Filename, 0700)
+	cache.drop(h)
+	err := putBlock(b, storage)
 	if err != nil {
-		fmt.Println("path error : ", err)
+		fmt.Println("Unable to write Block ", err)
 		return
 	}
-
-	fname = h.Filename + "/" + strconv.Itoa(h.BlockNum)
-	WriteJSON(root+fname, b)
-	log.Println("Wrote Block ", root+fname, "to disc")
+	recordAdded(h)
+	log.Println("Wrote Block ", blockName(h))
 	return
 
 }
 
-// GetBlockHeaders retrieves the list of all Blockheaders found within
-// the filesystem specified by the user.
-func GetBlockHeaders() []BlockHeader {
-
-	list, err := ioutil.ReadDir(root)
-
-	CheckError(err)
-	headers := make([]BlockHeader, 0, len(list))
+// DeleteBlock removes the Block described by h from the BlockStore.
+// Deleting a Block which is not stored, or only stored in a newer
+// generation, is not an error.
+func DeleteBlock(h BlockHeader) error {
+	// a newer generation written since the deletion was requested is kept
+	stored, err := store.Stat(h)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if stored.GenStamp > h.GenStamp {
+		log.Println("Keeping newer generation of Block ", blockName(h))
+		return nil
+	}
 
-	// each directory is Filename, which holds Block files within
-	for _, dir := range list {
+	cache.drop(h)
+	err = store.Delete(h)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	recordRemoved(h)
+	log.Println("Deleted Block ", blockName(h))
+	return nil
+}
 
-		files, err := ioutil.ReadDir(dir.Name())
+// RenameBlock moves the Block described by from to the file and header of
+// to. Renaming a Block which is not stored is not an error, so a repeated
+// request succeeds. A Block of a truncated file keeps its name, and is
+// trimmed to the smaller size of to. A Block kept under its ID only has its
+// header changed, when the store can do so without rewriting its data.
+func RenameBlock(from, to BlockHeader) error {
+	if r, ok := store.(relabeler); ok && blockName(from) == blockName(to) && to.Size == from.Size {
+		return relabelBlock(r, from, to)
+	}
+	b, err := store.Get(from)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if b.Header == to {
+		return nil
+	}
+	if b.Header != from {
+		return errors.New("Stored Block does not match " + blockName(from))
+	}
+	if to.Size < from.Size {
+		data, err := blockData(b)
 		if err != nil {
-			log.Println("Error reading directory ", err)
-		} else {
-			for _, fi := range files {
-				var b Block
-				fpath := strings.Join([]string{root, dir.Name(), fi.Name()}, "/")
-				ReadJSON(fpath, &b)
-				headers = append(headers, b.Header)
-			}
+			return err
 		}
+		b.Data = data[:to.Size]
+		b.Checksum = 0
 	}
-	return headers
+	b.Header = to
+	WriteBlock(b)
+	if blockName(from) == blockName(to) {
+		recordRemoved(from)
+		return nil
+	}
+	return DeleteBlock(from)
 }
 
-// BlockFromHeader retrieves a Block using metadata from the Blockheader h
-func BlockFromHeader(h BlockHeader) Block {
-
-	list, err := ioutil.ReadDir(root)
-	var errBlock Block
+// relabelBlock gives the stored Block described by from the header to,
+// leaving its data where it is
+func relabelBlock(r relabeler, from, to BlockHeader) error {
+	stored, err := store.Stat(from)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
-		log.Println("Error reading directory ", err)
-		return errBlock
+		return err
 	}
-
-	fname := h.Filename + "/" + strconv.Itoa(h.BlockNum)
-	for _, dir := range list {
-		var b Block
-		if "/"+dir.Name() == h.Filename {
-			ReadJSON(root+fname, &b)
-			return b
-		}
+	if stored == to {
+		return nil
 	}
-	fmt.Println("Block not found ", root+fname)
-	return errBlock
+	if stored != from {
+		return errors.New("Stored Block does not match " + blockName(from))
+	}
+	cache.drop(from)
+	err = r.Relabel(from, to)
+	if err != nil {
+		return err
+	}
+	recordAdded(to)
+	recordRemoved(from)
+	return nil
 }
 
-// ReadJSON reads a JSON encoded interface to disc
-func ReadJSON(fname string, key interface{}) {
-	fi, err := os.Open(fname)
-	defer fi.Close()
+// GetBlockHeaders retrieves the list of all Blockheaders found within
+// the BlockStore.
+func GetBlockHeaders() []BlockHeader {
+	headers, err := store.List()
+	CheckError(err)
+	return headers
+}
 
+// BlockFromHeader retrieves a Block using metadata from the Blockheader h.
+// A Block failing verification is not returned, and is left for the
+// datanode to check again and report.
+func BlockFromHeader(h BlockHeader) Block {
+	if cacheSize > 0 {
+		if b, ok := cache.get(h); ok && b.Header.GenStamp >= h.GenStamp {
+			return corruptBlock(b)
+		}
+	}
+	b, err := store.Get(h)
 	if err != nil {
-		fmt.Println("Couldnt Read JSON ")
-		return
+		fmt.Println("Block not found ", blockName(h), err)
+		return Block{}
+	}
+	if b.Header.GenStamp < h.GenStamp {
+		log.Println("Not serving stale Block ", blockName(h))
+		return Block{}
+	}
+	if err := verify(b); err != nil {
+		log.Println("Not serving Block ", blockName(h), err)
+		suspect(h)
+		return Block{}
 	}
+	if cacheSize > 0 {
+		cache.add(b, false)
+	}
+	return corruptBlock(b)
+}
 
-	decoder := json.NewDecoder(fi)
-	err = decoder.Decode(key)
+// ReadJSON reads a JSON encoded interface from disc
+func ReadJSON(fname string, key interface{}) error {
+	fi, err := os.Open(fname)
 	if err != nil {
-		log.Println("Error encoding JSON ", err)
-		return
+		return err
 	}
+	defer fi.Close()
+
+	return json.NewDecoder(fi).Decode(key)
 }
 
 // WriteJSON writes a JSON encoded interface to disc
-func WriteJSON(fileName string, key interface{}) {
+func WriteJSON(fileName string, key interface{}) error {
 	outFile, err := os.Create(fileName)
-	defer outFile.Close()
-	if err != nil {
-		log.Println("Error opening JSON ", err)
-		return
-	}
-	encoder := json.NewEncoder(outFile)
-	err = encoder.Encode(key)
 	if err != nil {
-		log.Println("Error encoding JSON ", err)
-		return
+		return err
 	}
+	defer outFile.Close()
+
+	return json.NewEncoder(outFile).Encode(key)
 }
 
 // Parse Config sets up the node with the provided XML file
@@ -276,12 +591,213 @@ func ParseConfigXML(configpath string) error {
 		switch o.Key {
 		case "id":
 			id = o.Value
+		case "idfile":
+			idFile = o.Value
 		case "blockfileroot":
-			root = o.Value
+			_, dir, err := parseVolume(o.Value)
+			if err != nil {
+				return err
+			}
+			root = dir
+			volumeDirs = append(volumeDirs, o.Value)
+		case "storagetype":
+			t := strings.ToUpper(o.Value)
+			if !validStorage(t) {
+				return errors.New("Storage type must be SSD, DISK or ARCHIVE")
+			}
+			storageType = t
+		case "failedvolumestolerated":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Failed volumes tolerated must not be negative")
+			}
+			failedVolumesTolerated = n
+		case "scaninterval":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Scan interval must not be negative")
+			}
+			scanInterval = time.Duration(n) * time.Second
+		case "verifyonstart":
+			b, err := strconv.ParseBool(o.Value)
+			if err != nil {
+				return err
+			}
+			verifyOnStart = b
+		case "scanbandwidth":
+			n, err := strconv.ParseInt(o.Value, 0, 64)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Scan bandwidth must be at least 1 byte per second")
+			}
+			scanBandwidth = n
+		case "transferbandwidth":
+			n, err := strconv.ParseInt(o.Value, 0, 64)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Transfer bandwidth must not be negative")
+			}
+			transferBandwidth = n
+		case "wireformat":
+			if o.Value != "binary" && o.Value != "json" {
+				return errors.New("Wire format must be binary or json")
+			}
+			wireFormat = o.Value
+		case "codec":
+			if codecVersion(o.Value) == 0 {
+				return errors.New("Codec must be json, gob or msgpack")
+			}
+			wireCodec = o.Value
+		case "transport":
+			if o.Value != "tcp" && o.Value != "quic" {
+				return errors.New("Transport must be tcp or quic")
+			}
+			if o.Value == "quic" && !quicconn.Supported {
+				return quicconn.ErrNotBuilt
+			}
+			transport = o.Value
+		case "maxpacketsize":
+			n, err := strconv.ParseInt(o.Value, 10, 64)
+			if err != nil {
+				return err
+			}
+
+			if n < 1<<20 {
+				return errors.New("Longest packet must be at least 1048576 bytes")
+			}
+			maxPacketSize = n
+		case "maxblocksize":
+			n, err := strconv.ParseInt(o.Value, 10, 64)
+			if err != nil {
+				return err
+			}
+
+			if n < 4096 {
+				return errors.New("Longest Block must be at least 4096 bytes")
+			}
+			maxBlockSize = n
+		case "storage":
+			storage = o.Value
+		case "s3endpoint":
+			s3Config.Endpoint = o.Value
+		case "s3bucket":
+			s3Config.Bucket = o.Value
+		case "s3prefix":
+			s3Config.Prefix = o.Value
+		case "s3region":
+			s3Config.Region = o.Value
+		case "s3accesskey":
+			s3Config.AccessKey = o.Value
+		case "s3secretkey":
+			s3Config.SecretKey = o.Value
 		case "serverhost":
 			serverhost = o.Value
 		case "serverport":
 			serverport = o.Value
+		case "namenodes":
+			namenodeAddresses = parseAddresses(o.Value)
+		case "maxreconnectbackoff":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Reconnect backoff must be at least 1 second")
+			}
+			maxReconnectBackoff = time.Duration(n) * time.Second
+		case "httpaddress":
+			httpAddress = o.Value
+		case "fullreportinterval":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Full report interval must be at least 1 second")
+			}
+			fullReportInterval = time.Duration(n) * time.Second
+		case "faultseed":
+			n, err := strconv.ParseInt(o.Value, 0, 64)
+			if err != nil {
+				return err
+			}
+			faultSeed = n
+		case "faultdroprate":
+			f, err := strconv.ParseFloat(o.Value, 64)
+			if err != nil {
+				return err
+			}
+
+			if f < 0 || f > 1 {
+				return errors.New("Fault probability must be between 0 and 1")
+			}
+			faultDropRate = f
+		case "faultdelayrate":
+			f, err := strconv.ParseFloat(o.Value, 64)
+			if err != nil {
+				return err
+			}
+
+			if f < 0 || f > 1 {
+				return errors.New("Fault probability must be between 0 and 1")
+			}
+			faultDelayRate = f
+		case "faultcorruptrate":
+			f, err := strconv.ParseFloat(o.Value, 64)
+			if err != nil {
+				return err
+			}
+
+			if f < 0 || f > 1 {
+				return errors.New("Fault probability must be between 0 and 1")
+			}
+			faultCorruptRate = f
+		case "faultcloserate":
+			f, err := strconv.ParseFloat(o.Value, 64)
+			if err != nil {
+				return err
+			}
+
+			if f < 0 || f > 1 {
+				return errors.New("Fault probability must be between 0 and 1")
+			}
+			faultCloseRate = f
+		case "faultdelay":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Fault delay must be at least 1 second")
+			}
+			faultDelay = time.Duration(n) * time.Second
+		case "cachesize":
+			n, err := strconv.ParseInt(o.Value, 0, 64)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Cache size must not be negative")
+			}
+			cacheSize = n
 		case "sizeofblock":
 			n, err := strconv.ParseInt(o.Value, 0, 64)
 			if err != nil {
@@ -304,28 +820,86 @@ func Run(configpath string) {
 
 	ParseConfigXML(configpath)
 
-	err := os.Chdir(root)
+	var err error
+	store, err = OpenStore()
 	CheckError(err)
+	CheckError(loadID())
+	if verifyOnStart {
+		VerifyStore()
+	}
+	if storage == "disk" {
+		err = os.Chdir(root)
+		CheckError(err)
+	}
 
-	conn, err := net.Dial("tcp", serverhost+":"+serverport)
-	CheckError(err)
+	if httpAddress != "" {
+		go ServeHTTP()
+	}
+	if scanInterval > 0 {
+		go ScanBlocks()
+	}
+	for {
+		conn, encoder, decoder := ConnectNamenode()
+		Serve(encoder, decoder)
+		conn.Close()
+		if stopping {
+			log.Println("Datanode stopped")
+			return
+		}
+		log.Println("Lost connection to the namenode, reconnecting")
+	}
+}
 
-	encoder := json.NewEncoder(conn)
-	decoder := json.NewDecoder(conn)
+// Serve handles the packets of a connection to the namenode, starting with a
+// full block report so a restarted namenode learns the stored Blocks. It
+// returns once the connection is lost, or the namenode asks the datanode to
+// shut down.
+func Serve(encoder packetEncoder, decoder packetDecoder) {
 	PacketChannel := make(chan Packet)
+	encoder = withFaults(encoder)
 	// start communication
 	go ReceivePackets(decoder, PacketChannel)
-	tick := time.Tick(2 * time.Second)
+	encoder.Encode(FullReport())
+	SendHeartbeat(encoder)
+
+	tick := time.NewTicker(2 * time.Second)
+	defer tick.Stop()
+	fullReport := time.NewTicker(fullReportInterval)
+	defer fullReport.Stop()
 	for {
 		select {
-		case <-tick:
+		case <-tick.C:
+			checkVolumes(encoder)
+			SendBlockReport(encoder)
+			delayHeartbeat()
 			SendHeartbeat(encoder)
-		case r := <-PacketChannel:
+		case <-fullReport.C:
+			encoder.Encode(FullReport())
+		case r, ok := <-PacketChannel:
+			if !ok {
+				return
+			}
 			HandleResponse(r, encoder)
+			if closeAfterPacket() || stopping {
+				return
+			}
+		case h := <-suspectBlocks:
+			CheckSuspect(h, encoder)
+		case req := <-writeRequests:
+			for _, b := range req.blocks {
+				writeBlockOn(b, req.storage)
+				if req.ack {
+					encoder.Encode(Packet{SRC: id, DST: "NN", CMD: BLOCKACK, Headers: []BlockHeader{b.Header}})
+				}
+			}
+			// let the namenode know without waiting for the next heartbeat
+			SendBlockReport(encoder)
+			req.done <- nil
+		case r := <-transfers:
+			encoder.Encode(r)
 		}
 
 	}
-	os.Exit(0)
 }
 
 func CheckError(err error) {
@@ -0,0 +1,91 @@
+package datanode
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Faults can be injected into a datanode to test how the namenode and clients
+// recover. Each happens with its configured probability, drawn from a source
+// seeded with faultSeed so a run can be repeated.
+var faultSeed int64 = 1           // seed of the probabilities of faults
+var faultDropRate float64         // probability a packet to the namenode is dropped
+var faultDelayRate float64        // probability a heartbeat is delayed
+var faultDelay = 10 * time.Second // time a delayed heartbeat waits, stalling the datanode
+var faultCorruptRate float64      // probability the data of a Block served is corrupted
+var faultCloseRate float64        // probability the connection is closed after a packet from the namenode
+var faultLock sync.Mutex          // guards faultRand
+var faultRand *rand.Rand          // created from faultSeed on the first draw
+
+// injectFault reports whether a fault of probability rate happens
+func injectFault(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	faultLock.Lock()
+	defer faultLock.Unlock()
+	if faultRand == nil {
+		faultRand = rand.New(rand.NewSource(faultSeed))
+	}
+	return faultRand.Float64() < rate
+}
+
+// faultyEncoder drops packets with probability faultDropRate
+type faultyEncoder struct {
+	packetEncoder
+}
+
+func (e faultyEncoder) Encode(v interface{}) error {
+	if injectFault(faultDropRate) {
+		log.Println("Injected fault: dropped packet")
+		return nil
+	}
+	return e.packetEncoder.Encode(v)
+}
+
+// withFaults returns encoder, dropping packets if faultDropRate is set
+func withFaults(encoder packetEncoder) packetEncoder {
+	if faultDropRate > 0 {
+		return faultyEncoder{encoder}
+	}
+	return encoder
+}
+
+// delayHeartbeat waits faultDelay before a heartbeat with probability
+// faultDelayRate
+func delayHeartbeat() {
+	if injectFault(faultDelayRate) {
+		log.Println("Injected fault: delaying heartbeat by ", faultDelay)
+		time.Sleep(faultDelay)
+	}
+}
+
+// corruptBlock returns b with a byte of a copy of its data flipped, with
+// probability faultCorruptRate
+func corruptBlock(b Block) Block {
+	if len(b.Data) == 0 || !injectFault(faultCorruptRate) {
+		return b
+	}
+	faultLock.Lock()
+	i := faultRand.Intn(len(b.Data))
+	faultLock.Unlock()
+
+	data := make([]byte, len(b.Data))
+	copy(data, b.Data)
+	data[i] ^= 0xff
+	b.Data = data
+	log.Println("Injected fault: corrupted Block ", blockName(b.Header))
+	return b
+}
+
+// closeAfterPacket reports whether the connection is to be closed after a
+// packet from the namenode, with probability faultCloseRate
+func closeAfterPacket() bool {
+	if injectFault(faultCloseRate) {
+		log.Println("Injected fault: closing the connection to the namenode")
+		return true
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package datanode
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestFaultInjection(t *testing.T) {
+
+	defer func() {
+		faultSeed, faultDropRate, faultCorruptRate, faultCloseRate = 1, 0, 0, 0
+		faultRand = nil
+	}()
+
+	// the same seed injects the same faults
+	draw := func() []bool {
+		faultRand = nil
+		faults := make([]bool, 20)
+		for i := range faults {
+			faults[i] = injectFault(0.5)
+		}
+		return faults
+	}
+	faultSeed = 7
+	first := draw()
+	second := draw()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Seeded faults differ at draw %d", i)
+		}
+	}
+	if injectFault(0) || !injectFault(1) {
+		t.Errorf("Fault probabilities of 0 and 1 not respected")
+	}
+
+	// dropped packets are never written
+	var buf bytes.Buffer
+	faultDropRate = 1
+	withFaults(json.NewEncoder(&buf)).Encode(Packet{CMD: HB})
+	if buf.Len() != 0 {
+		t.Errorf("Dropped packet written")
+	}
+
+	// a corrupted Block fails verification, while the stored data is kept
+	data := []byte("fault injection")
+	b := Block{Header: BlockHeader{Filename: "/f", Size: int64(len(data))}, Data: data, Checksum: checksum(data)}
+	faultCorruptRate = 1
+	corrupted := corruptBlock(b)
+	if verify(corrupted) == nil {
+		t.Errorf("Corrupted Block passed verification")
+	}
+	if verify(b) != nil {
+		t.Errorf("Original Block changed by the corruption")
+	}
+
+	faultCloseRate = 1
+	if !closeAfterPacket() {
+		t.Errorf("Connection not closed")
+	}
+}
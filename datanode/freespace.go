@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package datanode
+
+import (
+	"syscall"
+)
+
+// freeSpace is the number of bytes available below dir
+func freeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(dir, &st)
+	if err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package datanode
+
+// freeSpace is not known on this platform, so no capacity is reported
+func freeSpace(dir string) (int64, error) {
+	return 0, errNoFreeSpace
+}
@@ -0,0 +1,74 @@
+package datanode
+
+import (
+	"errors"
+	"log"
+	"strconv"
+)
+
+// protocolVersion is the protocol spoken by the datanode, which begins each
+// connection with a HELLO
+const protocolVersion = 2
+
+// softwareVersion is the release the datanode runs
+const softwareVersion = "0.3.0"
+
+// features lists the optional parts of the protocol the datanode supports
+var features = []string{"frames", "capacity", "corruptblock", "compression", "replicate", "register", "commands", "cache", "storage", "chunks", "sync"}
+
+// Hello describes a node to its peer in a HELLO, the first packet of a
+// connection
+type Hello struct {
+	Version       int      // newest protocol version spoken
+	MinVersion    int      // oldest protocol version spoken
+	Software      string   // release of GoDFS the node runs
+	Features      []string // optional features supported
+	BlockSize     int      // default size of Blocks, sent by the namenode
+	MaxPacketSize int64    // longest packet accepted, 0 if not known
+	Codecs        []string // codecs of frames the node reads besides JSON, most preferred first
+	Codec         string   // codec of the frames both sides send once the namenode answers, chosen by it
+}
+
+// namenodeHello is what the namenode accepted in its answer to the HELLO
+var namenodeHello Hello
+
+// Handshake describes the datanode to the namenode and waits for its
+// answer, returning an error if the namenode refuses the datanode
+func Handshake(encoder packetEncoder, decoder packetDecoder) error {
+	own := Hello{Version: protocolVersion, MinVersion: protocolVersion, Software: softwareVersion, Features: features, MaxPacketSize: maxPacketSize, Codecs: offeredCodecs()}
+	err := encoder.Encode(Packet{SRC: id, DST: "NN", CMD: HELLO, Hello: &own})
+	if err != nil {
+		return err
+	}
+
+	var r Packet
+	err = decoder.Decode(&r)
+	for isBadFrame(err) {
+		log.Println("Skipped frame ", err)
+		err = decoder.Decode(&r)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case r.CMD == ERROR:
+		return errors.New("Namenode refused the datanode: " + r.Message)
+	case r.CMD != HELLO || r.Hello == nil:
+		return errors.New("Namenode did not answer the HELLO")
+	case r.Hello.Version != protocolVersion:
+		return errors.New("Namenode chose protocol version " + strconv.Itoa(r.Hello.Version) + ", the datanode speaks " + strconv.Itoa(protocolVersion))
+	}
+	namenodeHello = *r.Hello
+	log.Println("Connected to namenode", namenodeHello.Software, "protocol", namenodeHello.Version, "features", namenodeHello.Features, "codec", namenodeHello.Codec)
+	return nil
+}
+
+// hasFeature reports whether feature is among features
+func hasFeature(features []string, feature string) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,381 @@
+package datanode
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// layoutVersion is the version of the on-disk layout of the storage
+// directories. Version 1 kept each Block as a JSON file in a directory named
+// after its file. Version 2 keeps the data of each Block in a file of its own,
+// with a .meta file beside it, spread over hashed subdirectories of current.
+const layoutVersion = 2
+
+// names within a storage directory
+const (
+	versionFile   = "VERSION"
+	currentDir    = "current"
+	quarantineDir = "quarantine" // corrupt Blocks, kept for inspection
+	metaSuffix    = ".meta"
+	tmpSuffix     = ".tmp"
+)
+
+// subdirectories on each level below current, keeping directories small
+const layoutFanout = 32
+
+// storageVersion is kept in the VERSION file of a storage directory
+type storageVersion struct {
+	LayoutVersion int
+	DatanodeID    string `json:",omitempty"` // ID the datanode registered under
+}
+
+// blockMeta is kept in the .meta file beside the data of a Block
+type blockMeta struct {
+	Header       BlockHeader
+	Checksum     uint32 // CRC-32 of the data file
+	DataChecksum uint32 `json:",omitempty"` // CRC-32 of the uncompressed data of a compressed Block
+}
+
+// diskStore keeps Blocks as files below root in the layout of layoutVersion
+type diskStore struct {
+	root string
+}
+
+// NewDiskStore returns a BlockStore keeping Blocks on the local filesystem
+// below root
+func NewDiskStore(root string) BlockStore {
+	return &diskStore{root}
+}
+
+// blockPath is the data file of the Block named by h, named by a hash of its
+// name in a subdirectory chosen by the hash. The .meta file is beside it.
+func (s *diskStore) blockPath(h BlockHeader) string {
+	sum := sha1.Sum([]byte(blockName(h)))
+	return filepath.Join(s.root, currentDir,
+		"subdir"+strconv.Itoa(int(sum[0])%layoutFanout),
+		"subdir"+strconv.Itoa(int(sum[1])%layoutFanout),
+		"blk_"+hex.EncodeToString(sum[:]))
+}
+
+// writeFile replaces name with data, so a crash leaves the old file or the
+// new one, never part of it
+func writeFile(name string, data []byte) error {
+	err := ioutil.WriteFile(name+tmpSuffix, data, 0600)
+	if err == nil {
+		err = os.Rename(name+tmpSuffix, name)
+	}
+	if err != nil {
+		os.Remove(name + tmpSuffix)
+	}
+	return err
+}
+
+// readMeta reads the .meta file of the Block whose data file is name
+func readMeta(name string) (blockMeta, error) {
+	var m blockMeta
+	err := ReadJSON(name+metaSuffix, &m)
+	return m, err
+}
+
+func (s *diskStore) Put(b Block) error {
+	name := s.blockPath(b.Header)
+	err := os.MkdirAll(filepath.Dir(name), 0700)
+	if err != nil {
+		return err
+	}
+	m := blockMeta{Header: b.Header, Checksum: b.Checksum}
+	if b.Header.Codec != "" {
+		if data, err := blockData(b); err == nil {
+			m.DataChecksum = checksum(data)
+		}
+	}
+	meta, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	// the .meta file is written last, completing the Block
+	err = writeFile(name, b.Data)
+	if err == nil {
+		err = writeFile(name+metaSuffix, meta)
+	}
+	return err
+}
+
+func (s *diskStore) Get(h BlockHeader) (Block, error) {
+	name := s.blockPath(h)
+	m, err := readMeta(name)
+	if err != nil {
+		return Block{}, err
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return Block{}, err
+	}
+	return Block{m.Header, data, m.Checksum}, nil
+}
+
+func (s *diskStore) Delete(h BlockHeader) error {
+	// without its .meta file the Block is gone, even if the data remains
+	name := s.blockPath(h)
+	err := os.Remove(name + metaSuffix)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *diskStore) List() ([]BlockHeader, error) {
+	_, err := os.Stat(s.root)
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]BlockHeader, 0)
+	err = s.walk(func(name string) {
+		m, err := readMeta(name)
+		if err != nil {
+			log.Println("Error reading Block ", err)
+			return
+		}
+		headers = append(headers, m.Header)
+	})
+	return headers, err
+}
+
+func (s *diskStore) Stat(h BlockHeader) (BlockHeader, error) {
+	m, err := readMeta(s.blockPath(h))
+	return m.Header, err
+}
+
+// Relabel rewrites the .meta file of the Block named by h with the header
+// to, leaving its data file as it is
+func (s *diskStore) Relabel(h, to BlockHeader) error {
+	name := s.blockPath(h)
+	m, err := readMeta(name)
+	if err != nil {
+		return err
+	}
+	m.Header = to
+	meta, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeFile(name+metaSuffix, meta)
+}
+
+// DataChecksum returns the header of the stored Block named by h and the
+// CRC-32 of its uncompressed data, read from its .meta file alone. It is 0
+// for compressed Blocks stored before it was kept.
+func (s *diskStore) DataChecksum(h BlockHeader) (BlockHeader, uint32, error) {
+	m, err := readMeta(s.blockPath(h))
+	if m.Header.Codec == "" {
+		return m.Header, m.Checksum, err
+	}
+	return m.Header, m.DataChecksum, err
+}
+
+// walk calls fn with the data file of each Block having a .meta file
+func (s *diskStore) walk(fn func(name string)) error {
+	return filepath.Walk(filepath.Join(s.root, currentDir), func(p string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && strings.HasSuffix(p, metaSuffix) {
+			fn(strings.TrimSuffix(p, metaSuffix))
+		}
+		return nil
+	})
+}
+
+// Quarantine moves the Block named by h from current to quarantine, where it
+// is neither served nor reported, but kept for inspection
+func (s *diskStore) Quarantine(h BlockHeader) error {
+	name := s.blockPath(h)
+	dst := filepath.Join(s.root, quarantineDir, filepath.Base(name))
+	err := os.MkdirAll(filepath.Dir(dst), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(name+metaSuffix, dst+metaSuffix)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(name, dst)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// readVersion reads the VERSION file of the storage directory, which a
+// directory without one has not written yet
+func (s *diskStore) readVersion() (storageVersion, error) {
+	var v storageVersion
+	err := ReadJSON(filepath.Join(s.root, versionFile), &v)
+	return v, err
+}
+
+// writeVersion replaces the VERSION file of the storage directory
+func (s *diskStore) writeVersion(v storageVersion) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(s.root, versionFile), append(js, '\n'))
+}
+
+// DatanodeID returns the ID kept in the VERSION file, if any
+func (s *diskStore) DatanodeID() string {
+	v, _ := s.readVersion()
+	return v.DatanodeID
+}
+
+// SetDatanodeID keeps the ID the datanode registered under in the VERSION
+// file
+func (s *diskStore) SetDatanodeID(id string) error {
+	v, err := s.readVersion()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	v.LayoutVersion = layoutVersion
+	v.DatanodeID = id
+	return s.writeVersion(v)
+}
+
+// repair brings the storage directory to the current layout before its
+// Blocks are served. Blocks of the first layout are moved to the current one,
+// and what a crash left behind is removed: temporary files, data without a
+// .meta file, and .meta files which cannot be read or have no data. A Block
+// found in the wrong subdirectory is moved to its own.
+func (s *diskStore) repair() error {
+	err := os.MkdirAll(s.root, 0700)
+	if err != nil {
+		return err
+	}
+	v, err := s.readVersion()
+	if os.IsNotExist(err) {
+		v = storageVersion{LayoutVersion: 1}
+	} else if err != nil {
+		return err
+	}
+	if v.LayoutVersion > layoutVersion {
+		return errors.New("Storage directory " + s.root + " has layout version " + strconv.Itoa(v.LayoutVersion) +
+			", newer than " + strconv.Itoa(layoutVersion))
+	}
+	if v.LayoutVersion < 2 {
+		err = s.upgrade()
+		if err != nil {
+			return err
+		}
+	}
+
+	var removed, moved int
+	err = filepath.Walk(filepath.Join(s.root, currentDir), func(p string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		name := strings.TrimSuffix(p, metaSuffix)
+		switch {
+		case strings.HasSuffix(p, tmpSuffix):
+			removed++
+			return os.Remove(p)
+		case p == name:
+			// data is checked with its .meta file
+			if _, err := os.Stat(name + metaSuffix); os.IsNotExist(err) {
+				removed++
+				return os.Remove(p)
+			}
+			return nil
+		}
+
+		m, err := readMeta(name)
+		_, dataErr := os.Stat(name)
+		if err != nil || dataErr != nil {
+			log.Println("Removing incomplete Block ", name, err, dataErr)
+			removed++
+			os.Remove(name)
+			return os.Remove(p)
+		}
+		if want := s.blockPath(m.Header); want != name {
+			moved++
+			err = os.MkdirAll(filepath.Dir(want), 0700)
+			if err == nil {
+				err = os.Rename(name, want)
+			}
+			if err == nil {
+				err = os.Rename(p, want+metaSuffix)
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if removed > 0 || moved > 0 {
+		log.Println("Repaired storage directory ", s.root, ", removed ", removed, " files and moved ", moved, " Blocks")
+	}
+
+	v.LayoutVersion = layoutVersion
+	return s.writeVersion(v)
+}
+
+// upgrade moves the Blocks of the first layout, JSON files in a directory for
+// each file, to the current layout
+func (s *diskStore) upgrade() error {
+	list, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return err
+	}
+	upgraded := 0
+	for _, dir := range list {
+		if !dir.IsDir() || dir.Name() == currentDir || dir.Name() == quarantineDir {
+			continue
+		}
+		old := filepath.Join(s.root, dir.Name())
+		files, err := ioutil.ReadDir(old)
+		if err != nil {
+			return err
+		}
+		for _, fi := range files {
+			var b Block
+			err := ReadJSON(filepath.Join(old, fi.Name()), &b)
+			if err != nil {
+				log.Println("Skipping unreadable Block ", filepath.Join(old, fi.Name()), err)
+				continue
+			}
+			if b.Checksum == 0 {
+				b.Checksum = checksum(b.Data)
+			}
+			err = s.Put(b)
+			if err != nil {
+				return err
+			}
+			os.Remove(filepath.Join(old, fi.Name()))
+			upgraded++
+		}
+		os.Remove(old)
+	}
+	if upgraded > 0 {
+		log.Println("Upgraded ", upgraded, " Blocks in ", s.root, " to layout version ", layoutVersion)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package datanode
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLayoutRepair(t *testing.T) {
+
+	dir := t.TempDir()
+	s := NewDiskStore(dir).(*diskStore)
+
+	// a Block of the first layout is upgraded
+	legacy := Block{BlockHeader{"DN1", "/dir/old.txt", 4, 0, 1, 1, "", 0, ""}, []byte("data"), 0}
+	os.MkdirAll(filepath.Join(dir, "dir%2Fold.txt"), 0700)
+	if err := WriteJSON(filepath.Join(dir, "dir%2Fold.txt", "0"), legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	// a misplaced Block is moved, and what a crash left behind removed
+	moved := Block{BlockHeader{"DN1", "/moved.txt", 4, 0, 1, 1, "", 0, ""}, []byte("data"), 1}
+	s.Put(moved)
+	name := s.blockPath(moved.Header)
+	misplaced := filepath.Join(dir, currentDir, "subdir0", "subdir0", filepath.Base(name))
+	if misplaced == name {
+		misplaced = filepath.Join(dir, currentDir, "subdir1", "subdir1", filepath.Base(name))
+	}
+	os.MkdirAll(filepath.Dir(misplaced), 0700)
+	os.Rename(name, misplaced)
+	os.Rename(name+metaSuffix, misplaced+metaSuffix)
+	leftovers := []string{
+		filepath.Join(dir, currentDir, "subdir2", "blk_partial"),
+		filepath.Join(dir, currentDir, "subdir2", "blk_nodata"+metaSuffix),
+		filepath.Join(dir, currentDir, "subdir2", "blk_torn"),
+		filepath.Join(dir, currentDir, "subdir2", "blk_torn"+metaSuffix),
+		filepath.Join(dir, currentDir, "subdir2", "blk_write"+tmpSuffix),
+	}
+	os.MkdirAll(filepath.Join(dir, currentDir, "subdir2"), 0700)
+	for _, f := range leftovers {
+		ioutil.WriteFile(f, []byte(`{"Header":`), 0600)
+	}
+
+	if err := s.repair(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range leftovers {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", f)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dir%2Fold.txt")); !os.IsNotExist(err) {
+		t.Errorf("Directory of the first layout kept")
+	}
+	for _, b := range []Block{legacy, moved} {
+		got, err := s.Get(b.Header)
+		if err != nil || got.Header != b.Header || string(got.Data) != "data" || got.Checksum == 0 {
+			t.Errorf("Block %v not repaired %v %v", b.Header, got, err)
+		}
+	}
+	if headers, err := s.List(); err != nil || len(headers) != 2 {
+		t.Errorf("Wrong Blocks after repairing %v %v", headers, err)
+	}
+	if v, err := s.readVersion(); err != nil || v.LayoutVersion != layoutVersion {
+		t.Errorf("Wrong VERSION %v %v", v, err)
+	}
+
+	// a newer layout is not touched
+	s.writeVersion(storageVersion{LayoutVersion: layoutVersion + 1})
+	if err := s.repair(); err == nil {
+		t.Errorf("Newer layout repaired")
+	}
+}
+
+func TestVolumeOwner(t *testing.T) {
+
+	dirs := []string{t.TempDir(), t.TempDir()}
+	for i, owner := range []string{"DN1", "DN2"} {
+		NewDiskStore(dirs[i]).(*diskStore).SetDatanodeID(owner)
+	}
+	vs := NewVolumeStore(dirs).(*volumeStore)
+	vs.repair()
+	if failed := vs.Failed(); len(failed) != 1 || failed[0] != dirs[1] || vs.DatanodeID() != "DN1" {
+		t.Errorf("Volume of another datanode not failed %v", failed)
+	}
+}
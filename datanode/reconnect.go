@@ -0,0 +1,95 @@
+package datanode
+
+import (
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+var namenodeAddresses []string          // host:port addresses the namenode may be reached at, tried in order
+var maxReconnectBackoff = time.Minute   // longest wait between attempts to reach the namenode
+var handshakeTimeout = 10 * time.Second // time a namenode has to accept a connection and answer its HELLO
+
+// parseAddresses splits a comma separated list of host:port addresses
+func parseAddresses(list string) []string {
+	var addresses []string
+	for _, a := range strings.Split(list, ",") {
+		a = strings.TrimSpace(a)
+		if a != "" {
+			addresses = append(addresses, a)
+		}
+	}
+	return addresses
+}
+
+// candidates returns the addresses to try for the namenode, with each host
+// resolved to every address its name has, so a DNS name can list several
+// namenodes
+func candidates() []string {
+	configured := namenodeAddresses
+	if len(configured) == 0 {
+		configured = []string{net.JoinHostPort(serverhost, serverport)}
+	}
+
+	var addresses []string
+	for _, a := range configured {
+		host, port, err := net.SplitHostPort(a)
+		if err != nil {
+			log.Println("Bad namenode address ", a, err)
+			continue
+		}
+		ips, err := net.LookupHost(host)
+		if err != nil {
+			log.Println("Could not resolve namenode ", host, err)
+			continue
+		}
+		for _, ip := range ips {
+			addresses = append(addresses, net.JoinHostPort(ip, port))
+		}
+	}
+	return addresses
+}
+
+// ConnectNamenode tries each candidate address of the namenode in turn
+// until one accepts the datanode's HELLO, waiting with exponential backoff
+// between rounds of attempts. It returns once connected.
+func ConnectNamenode() (net.Conn, packetEncoder, packetDecoder) {
+	backoff := time.Second
+	for {
+		for _, a := range candidates() {
+			conn, err := dialNamenode(a, handshakeTimeout)
+			if err != nil {
+				log.Println("Could not connect to namenode ", a, err)
+				continue
+			}
+			encoder, decoder := newPacketCodec(conn)
+			conn.SetDeadline(time.Now().Add(handshakeTimeout))
+			err = Handshake(encoder, decoder)
+			if err == nil {
+				encoder = encodedWith(encoder, namenodeHello.Codec)
+				decodedWith(decoder, namenodeHello.Codec)
+			}
+			if err == nil && hasFeature(namenodeHello.Features, "register") {
+				err = Register(encoder, decoder)
+			}
+			if err != nil {
+				log.Println("Handshake with namenode failed ", a, err)
+				conn.Close()
+				continue
+			}
+			conn.SetDeadline(time.Time{})
+			return conn, chunked(encoder, namenodeHello), decoder
+		}
+
+		// jitter keeps datanodes from reconnecting in step after a restart
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		log.Println("Could not reach the namenode, retrying in ", wait)
+		time.Sleep(wait)
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
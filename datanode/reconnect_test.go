@@ -0,0 +1,59 @@
+package datanode
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestReconnect(t *testing.T) {
+
+	store = NewDiskStore(t.TempDir())
+	id = "DN1"
+	wireFormat = "json"
+	defer func() { wireFormat = "binary"; namenodeAddresses = nil }()
+	WriteBlock(Block{BlockHeader{"DN1", "/out.txt", 4, 0, 1, 1, "", 0, ""}, []byte("data"), 0})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer l.Close()
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	namenodeAddresses = parseAddresses(closed.Addr().String() + ", " + l.Addr().String())
+
+	// the namenode answers the HELLO of each connection, expects a full
+	// block report, then drops the connection
+	reports := make(chan Packet)
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			dec := json.NewDecoder(conn)
+			enc := json.NewEncoder(conn)
+			var p Packet
+			dec.Decode(&p)
+			enc.Encode(Packet{SRC: "NN", DST: p.SRC, CMD: HELLO, Hello: &Hello{Version: protocolVersion}})
+			dec.Decode(&p)
+			conn.Close()
+			reports <- p
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		conn, encoder, decoder := ConnectNamenode()
+		done := make(chan bool)
+		go func() {
+			Serve(encoder, decoder)
+			done <- true
+		}()
+		if p := <-reports; p.CMD != LIST || len(p.Headers) != 1 {
+			t.Errorf("Expected a full block report on connecting, got %v", p)
+		}
+		<-done
+		conn.Close()
+	}
+}
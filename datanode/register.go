@@ -0,0 +1,86 @@
+package datanode
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+var idFile string // file keeping the ID the namenode registered the datanode under, for stores without one
+
+// idKeeper is implemented by stores which keep the ID the datanode
+// registered under, such as in the VERSION file of each storage directory
+type idKeeper interface {
+	DatanodeID() string
+	SetDatanodeID(id string) error
+}
+
+// loadID reads the ID the datanode registered under before, which replaces
+// the configured id, and leaves id alone if the datanode never registered
+func loadID() error {
+	if idFile == "" {
+		if k, ok := store.(idKeeper); ok && k.DatanodeID() != "" {
+			id = k.DatanodeID()
+		}
+		return nil
+	}
+	b, err := ioutil.ReadFile(idFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	id = strings.TrimSpace(string(b))
+	return nil
+}
+
+// saveID keeps the ID the datanode registered under in idFile, or in the
+// store
+func saveID(registered string) error {
+	if idFile != "" {
+		return ioutil.WriteFile(idFile, []byte(registered+"\n"), 0600)
+	}
+	if k, ok := store.(idKeeper); ok {
+		return k.SetDatanodeID(registered)
+	}
+	return nil
+}
+
+// Register asks the namenode for the datanode's ID once it has answered the
+// HELLO. A datanode without an ID asks for a new one, which it keeps so it is
+// known by the same ID after restarting.
+func Register(encoder packetEncoder, decoder packetDecoder) error {
+	err := encoder.Encode(Packet{SRC: id, DST: "NN", CMD: REGISTER, Message: id})
+	if err != nil {
+		return err
+	}
+
+	var r Packet
+	err = decoder.Decode(&r)
+	for isBadFrame(err) {
+		log.Println("Skipped frame ", err)
+		err = decoder.Decode(&r)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case r.CMD == ERROR:
+		return errors.New("Namenode refused the registration: " + r.Message)
+	case r.CMD != REGISTER || r.Message == "":
+		return errors.New("Namenode did not answer the REGISTER")
+	}
+
+	if r.Message != id {
+		err = saveID(r.Message)
+		if err != nil {
+			return err
+		}
+		log.Println("Registered as datanode ", r.Message)
+	}
+	id = r.Message
+	return nil
+}
@@ -0,0 +1,68 @@
+package datanode
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// registerWith registers the datanode with a namenode answering answer
+func registerWith(answer Packet) (Packet, error) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	sent := make(chan Packet, 1)
+	go func() {
+		var p Packet
+		json.NewDecoder(remote).Decode(&p)
+		sent <- p
+		json.NewEncoder(remote).Encode(answer)
+	}()
+	err := Register(json.NewEncoder(local), json.NewDecoder(local))
+	return <-sent, err
+}
+
+func TestRegister(t *testing.T) {
+
+	dir := t.TempDir()
+	id, idFile = "", ""
+	store = NewVolumeStore([]string{dir})
+	defer func() { id = "" }()
+	if err := loadID(); err != nil || id != "" {
+		t.Fatalf("ID %q loaded before registering %v", id, err)
+	}
+
+	// the ID given by the namenode is kept in the VERSION file
+	p, err := registerWith(Packet{SRC: "NN", CMD: REGISTER, Message: "5e0b6c1a-0000-4000-8000-000000000001"})
+	if err != nil || p.CMD != REGISTER || p.Message != "" {
+		t.Fatalf("Wrong registration %v %v", p, err)
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(dir, versionFile))
+	if id != "5e0b6c1a-0000-4000-8000-000000000001" || !strings.Contains(string(b), id) {
+		t.Fatalf("Registered ID %q not kept, VERSION has %q", id, b)
+	}
+
+	// a restarted datanode registers with the kept ID
+	id = "DN1"
+	store = NewVolumeStore([]string{dir})
+	if err := loadID(); err != nil || id != "5e0b6c1a-0000-4000-8000-000000000001" {
+		t.Fatalf("Kept ID not loaded %q %v", id, err)
+	}
+	p, err = registerWith(Packet{SRC: "NN", CMD: ERROR, Message: "Datanode is already registered"})
+	if err == nil || p.Message != id {
+		t.Errorf("Refused registration not reported %v %v", p, err)
+	}
+
+	// stores without a VERSION file keep the ID in idfile
+	store = NewMemStore()
+	id, idFile = "", filepath.Join(dir, "datanode.id")
+	defer func() { idFile = "" }()
+	registerWith(Packet{SRC: "NN", CMD: REGISTER, Message: "5e0b6c1a-0000-4000-8000-000000000002"})
+	id = ""
+	if err := loadID(); err != nil || id != "5e0b6c1a-0000-4000-8000-000000000002" {
+		t.Errorf("ID not kept in the ID file %q %v", id, err)
+	}
+}
@@ -0,0 +1,115 @@
+package datanode
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRenameNestedBlock(t *testing.T) {
+
+	root = t.TempDir()
+	store = NewDiskStore(root)
+	addedBlocks = nil
+	removedBlocks = nil
+
+	from := BlockHeader{"DN1", "/dir/out.txt", 4, 0, 1, 0, "", 0, ""}
+	to := BlockHeader{"DN1", "/.Trash/C/dir/out.txt", 4, 0, 1, 0, "", 0, ""}
+	WriteBlock(Block{from, []byte("data"), 0})
+
+	b := BlockFromHeader(from)
+	if b.Header != from || string(b.Data) != "data" {
+		t.Fatalf("Could not read nested Block %v", b)
+	}
+
+	err := RenameBlock(from, to)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	b = BlockFromHeader(to)
+	if b.Header != to || string(b.Data) != "data" {
+		t.Errorf("Renamed Block not found %v", b)
+	}
+	if BlockFromHeader(from).Header == from {
+		t.Errorf("Block still stored under its old name")
+	}
+
+	headers := GetBlockHeaders()
+	if len(headers) != 1 || headers[0] != to {
+		t.Errorf("Expected only the renamed Block, got %v", headers)
+	}
+
+	// repeated renames succeed
+	if err := RenameBlock(from, to); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestTruncateBlock(t *testing.T) {
+
+	store = NewMemStore()
+	addedBlocks = nil
+	removedBlocks = nil
+
+	from := BlockHeader{"DN1", "/out.txt", 4, 1, 2, 1, "", 0, ""}
+	to := BlockHeader{"DN1", "/out.txt", 2, 1, 2, 2, "", 0, ""}
+	WriteBlock(Block{from, []byte("data"), 0})
+
+	err := RenameBlock(from, to)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	b := BlockFromHeader(to)
+	if b.Header != to || string(b.Data) != "da" {
+		t.Errorf("Expected the Block trimmed to 2 bytes, got %v", b)
+	}
+	if len(removedBlocks) != 1 || removedBlocks[0] != from {
+		t.Errorf("Expected the untrimmed Block reported removed, got %v", removedBlocks)
+	}
+	if err := RenameBlock(from, to); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	// a replica older than the one asked for is not served
+	newer := to
+	newer.GenStamp = 3
+	if b := BlockFromHeader(newer); b.Data != nil {
+		t.Errorf("Stale Block served %v", b)
+	}
+}
+
+func TestRenameBlockByID(t *testing.T) {
+
+	root = t.TempDir()
+	store = NewDiskStore(root)
+	addedBlocks = nil
+	removedBlocks = nil
+
+	from := BlockHeader{"DN1", "/out.txt", 4, 0, 1, 3, "", 12, ""}
+	to := BlockHeader{"DN1", "/moved.txt", 4, 0, 1, 3, "", 12, ""}
+	WriteBlock(Block{from, []byte("data"), 0})
+	name := store.(*diskStore).blockPath(from)
+	before, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// only the .meta file of a Block kept under its ID is rewritten
+	if err := RenameBlock(from, to); err != nil {
+		t.Fatalf("%s", err)
+	}
+	after, err := os.Stat(name)
+	if err != nil || !os.SameFile(before, after) {
+		t.Errorf("Data file of renamed Block was replaced %v", err)
+	}
+	b := BlockFromHeader(to)
+	if b.Header != to || string(b.Data) != "data" {
+		t.Errorf("Renamed Block not found %v", b)
+	}
+	headers := GetBlockHeaders()
+	if len(headers) != 1 || headers[0] != to {
+		t.Errorf("Expected only the renamed Block, got %v", headers)
+	}
+	if len(addedBlocks) != 1 || addedBlocks[0] != to || len(removedBlocks) != 1 || removedBlocks[0] != from {
+		t.Errorf("Expected the rename reported, got %v %v", addedBlocks, removedBlocks)
+	}
+}
@@ -0,0 +1,271 @@
+package datanode
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config locates the bucket of an S3 compatible object store
+type S3Config struct {
+	Endpoint  string // base URL of the object store, such as https://s3.us-east-1.amazonaws.com
+	Bucket    string // bucket holding the Blocks
+	Prefix    string // prefix of the object keys, so datanodes can share a bucket
+	Region    string // region used to sign requests
+	AccessKey string // access key ID, requests are unsigned without one
+	SecretKey string // secret access key
+}
+
+var s3Config = S3Config{Region: "us-east-1"} // object store used by the s3 storage
+
+// s3Store keeps each Block as a JSON object in a bucket, addressed by path
+type s3Store struct {
+	config S3Config
+	client *http.Client
+}
+
+// NewS3Store returns a BlockStore keeping Blocks in an S3 compatible bucket
+func NewS3Store(config S3Config) (BlockStore, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("S3 storage needs s3endpoint and s3bucket")
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &s3Store{config, &http.Client{Timeout: time.Minute}}, nil
+}
+
+// objectKey is the key of the object holding the Block named by h
+func (s *s3Store) objectKey(h BlockHeader) string {
+	return s.config.Prefix + blockName(h)
+}
+
+func (s *s3Store) Put(b Block) error {
+	body, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do("PUT", s.objectKey(b.Header), nil, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Store) Get(h BlockHeader) (Block, error) {
+	return s.getObject(s.objectKey(h))
+}
+
+func (s *s3Store) Delete(h BlockHeader) error {
+	// deleting a missing object succeeds, so check first
+	_, err := s.Stat(h)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do("DELETE", s.objectKey(h), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listBucketResult is the part of a ListObjectsV2 response naming the objects
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *s3Store) List() ([]BlockHeader, error) {
+	headers := make([]BlockHeader, 0)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.config.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do("GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		// the headers are only kept with the Blocks
+		for _, o := range result.Contents {
+			b, err := s.getObject(o.Key)
+			if err != nil {
+				return nil, err
+			}
+			headers = append(headers, b.Header)
+		}
+		if !result.IsTruncated {
+			return headers, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3Store) Stat(h BlockHeader) (BlockHeader, error) {
+	b, err := s.Get(h)
+	return b.Header, err
+}
+
+// getObject retrieves the Block held by the object key
+func (s *s3Store) getObject(key string) (Block, error) {
+	var b Block
+	resp, err := s.do("GET", key, nil, nil)
+	if err != nil {
+		return b, err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&b)
+	return b, err
+}
+
+// do sends a signed request for an object key, or for the bucket if key is
+// empty. A missing object gives os.ErrNotExist, and any other failure status
+// an error with the response body.
+func (s *s3Store) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	escapedPath := "/" + s.config.Bucket
+	if key != "" {
+		escapedPath += "/" + awsEscape(key, false)
+	}
+	u := s.config.Endpoint + escapedPath
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	s.sign(req, escapedPath, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		resp.Body.Close()
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, errors.New("S3 " + method + " " + key + " failed: " + resp.Status + " " + string(msg))
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to a request for the escaped
+// path
+func (s *s3Store) sign(req *http.Request, escapedPath string, body []byte, now time.Time) {
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	if s.config.AccessKey == "" {
+		return
+	}
+
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		names = append(names, "content-type")
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		escapedPath,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+	date := now.Format("20060102")
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.config.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// awsEscape percent encodes all but the unreserved characters of s, and
+// slashes unless escapeSlash is set, as signed requests require
+func awsEscape(s string, escapeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !escapeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes a query string sorted by key, as signed requests
+// require
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,204 @@
+package datanode
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+var scanInterval = 24 * time.Hour // time between scans of every stored Block, 0 to disable
+var scanBandwidth int64 = 1 << 20 // bytes a second the scanner reads at most
+var verifyOnStart = true          // verify every stored Block before the first report
+
+// suspectBlocks holds Blocks which failed verification, for the main loop to
+// check again and report
+var suspectBlocks = make(chan BlockHeader, 100)
+
+// checksum is the CRC-32 of the data of a Block
+func checksum(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data)
+}
+
+// checksummer is implemented by stores which keep the CRC-32 of the
+// uncompressed data of each Block with its metadata, read without its data
+type checksummer interface {
+	DataChecksum(h BlockHeader) (BlockHeader, uint32, error)
+}
+
+// blockChecksum returns the CRC-32 of the data of the Block h names as the
+// client wrote it, before any compression. The CRC kept when the Block was
+// stored is used where there is one, and otherwise the Block is read.
+func blockChecksum(h BlockHeader) (uint32, error) {
+	if c, ok := store.(checksummer); ok {
+		stored, sum, err := c.DataChecksum(h)
+		if err == nil && sum != 0 && stored.GenStamp >= h.GenStamp {
+			return sum, nil
+		}
+	}
+	b := BlockFromHeader(h)
+	if b.Header.Filename == "" {
+		return 0, errors.New("Block not found")
+	}
+	data, err := blockData(b)
+	if err != nil {
+		return 0, err
+	}
+	return checksum(data), nil
+}
+
+// verify checks that the data of a Block matches its header and checksum.
+// Blocks stored before checksums were kept have none, and are only checked
+// for their size. Compressed Blocks are checked for their size once
+// decompressed, and encrypted ones, whose data only the client can read,
+// for their checksum alone.
+func verify(b Block) error {
+	if b.Checksum != 0 && checksum(b.Data) != b.Checksum {
+		return errors.New("Checksum mismatch")
+	}
+	if b.Header.Nonce != "" {
+		return nil
+	}
+	if b.Header.Codec != "" {
+		data, err := decompress(b.Header.Codec, b.Data)
+		if err != nil {
+			return err
+		}
+		b.Data = data
+	}
+	if int64(len(b.Data)) != b.Header.Size {
+		return errors.New("Block holds " + strconv.Itoa(len(b.Data)) + " bytes, expected " + strconv.FormatInt(b.Header.Size, 10))
+	}
+	return nil
+}
+
+// isDecodeError reports whether a Block could be read but not decoded, as
+// when its file was truncated or overwritten
+func isDecodeError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// quarantiner is implemented by stores which set aside corrupt Blocks rather
+// than deleting them
+type quarantiner interface {
+	Quarantine(h BlockHeader) error
+}
+
+// discardCorrupt takes a corrupt Block out of the store, keeping it aside
+// where the store can
+func discardCorrupt(h BlockHeader) error {
+	if q, ok := store.(quarantiner); ok {
+		return q.Quarantine(h)
+	}
+	return store.Delete(h)
+}
+
+// VerifyStore verifies every stored Block as the datanode starts, before its
+// first report, and takes those failing verification out of the store, so the
+// namenode is never told of them. It returns the number of Blocks taken out.
+func VerifyStore() int {
+	headers, err := store.List()
+	if err != nil {
+		log.Println("Could not list Blocks to verify ", err)
+		return 0
+	}
+	corrupt := 0
+	for _, h := range headers {
+		b, err := store.Get(h)
+		if err == nil {
+			err = verify(b)
+		} else if !isDecodeError(err) {
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		log.Println("Quarantining corrupt Block ", blockName(h), err)
+		derr := discardCorrupt(h)
+		if derr != nil && !os.IsNotExist(derr) {
+			log.Println("Could not quarantine corrupt Block ", derr)
+			continue
+		}
+		corrupt++
+	}
+	log.Println("Verified ", len(headers), " Blocks, ", corrupt, " corrupt")
+	return corrupt
+}
+
+// suspect queues a Block to be checked again, dropping it if the queue is
+// full, as the next scan finds it again
+func suspect(h BlockHeader) {
+	select {
+	case suspectBlocks <- h:
+	default:
+	}
+}
+
+// ScanBlocks reads back every stored Block once each scanInterval, at no
+// more than scanBandwidth bytes a second, and queues those failing
+// verification. It runs until the datanode stops.
+func ScanBlocks() {
+	for {
+		start := time.Now()
+		scanAll()
+		time.Sleep(scanInterval - time.Since(start))
+	}
+}
+
+// scanAll verifies each stored Block once
+func scanAll() {
+	headers, err := store.List()
+	if err != nil {
+		log.Println("Could not list Blocks to scan ", err)
+		return
+	}
+	for _, h := range headers {
+		b, err := store.Get(h)
+		if err == nil {
+			err = verify(b)
+		} else if !isDecodeError(err) {
+			continue
+		}
+		if err != nil {
+			log.Println("Block failed verification ", blockName(h), err)
+			suspect(h)
+		}
+		time.Sleep(time.Duration(h.Size) * time.Second / time.Duration(scanBandwidth))
+	}
+}
+
+// CheckSuspect verifies a Block again from the main loop, where it cannot
+// be read while it is being written. A corrupt Block is quarantined or
+// deleted, so it is never served, and reported to the namenode to be
+// replicated again from a good copy.
+func CheckSuspect(h BlockHeader, encoder packetEncoder) {
+	b, err := store.Get(h)
+	if err == nil {
+		h = b.Header
+		err = verify(b)
+	} else if !isDecodeError(err) {
+		if !os.IsNotExist(err) {
+			log.Println("Could not verify Block ", blockName(h), err)
+		}
+		return
+	}
+	if err == nil {
+		return
+	}
+
+	log.Println("Corrupt Block ", blockName(h), err)
+	derr := discardCorrupt(h)
+	if derr != nil && !os.IsNotExist(derr) {
+		log.Println("Could not discard corrupt Block ", derr)
+	}
+	recordRemoved(h)
+	p := Packet{SRC: id, DST: "NN", CMD: CORRUPTBLOCK, Message: err.Error()}
+	p.Headers = []BlockHeader{h}
+	encoder.Encode(p)
+}
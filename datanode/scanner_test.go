@@ -0,0 +1,158 @@
+package datanode
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockScanner(t *testing.T) {
+
+	store = NewDiskStore(t.TempDir())
+	scanBandwidth = 1 << 30
+	addedBlocks = nil
+	removedBlocks = nil
+
+	good := BlockHeader{"DN1", "/out.txt", 4, 0, 3, 1, "", 0, ""}
+	bad := BlockHeader{"DN1", "/out.txt", 4, 1, 3, 1, "", 0, ""}
+	truncated := BlockHeader{"DN1", "/out.txt", 4, 2, 3, 1, "", 0, ""}
+	for _, h := range []BlockHeader{good, bad, truncated} {
+		WriteBlock(Block{h, []byte("data"), 0})
+	}
+
+	// flip a bit of one Block, keeping its checksum, and truncate another
+	b, _ := store.Get(bad)
+	b.Data[0] ^= 1
+	store.Put(b)
+	if err := ioutil.WriteFile(store.(*diskStore).blockPath(truncated)+metaSuffix, []byte(`{"Header":`), 0600); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	scanAll()
+	if len(suspectBlocks) != 1 || <-suspectBlocks != bad {
+		t.Fatalf("Expected the corrupt Block to be suspected")
+	}
+	if BlockFromHeader(bad).Header == bad {
+		t.Errorf("Corrupt Block was served")
+	}
+	<-suspectBlocks
+	if BlockFromHeader(good).Header != good {
+		t.Errorf("Good Block was not served")
+	}
+
+	var out bytes.Buffer
+	for _, h := range []BlockHeader{good, bad, truncated} {
+		CheckSuspect(h, json.NewEncoder(&out))
+	}
+	dec := json.NewDecoder(&out)
+	for _, h := range []BlockHeader{bad, truncated} {
+		var p Packet
+		if err := dec.Decode(&p); err != nil {
+			t.Fatalf("Expected a report of %v: %s", h, err)
+		}
+		if p.CMD != CORRUPTBLOCK || len(p.Headers) != 1 || p.Headers[0] != h {
+			t.Errorf("Unexpected report %v", p)
+		}
+		if _, err := store.Get(h); !os.IsNotExist(err) {
+			t.Errorf("Corrupt Block was kept %v", err)
+		}
+	}
+	if dec.More() {
+		t.Errorf("Good Block was reported")
+	}
+	if len(removedBlocks) != 2 {
+		t.Errorf("Expected the corrupt Blocks in the next report, got %v", removedBlocks)
+	}
+}
+
+func TestVerifyCompressed(t *testing.T) {
+
+	// "aaaaaaaa" in the snappy block format: a literal then a copy
+	data := []byte{8, 0, 'a', 6<<2 | 2, 1, 0}
+	b := Block{BlockHeader{"DN1", "/out.txt", 8, 0, 1, 1, "snappy", 0, ""}, data, checksum(data)}
+	if err := verify(b); err != nil {
+		t.Errorf("Compressed Block failed verification %s", err)
+	}
+	b.Header.Size = 6
+	if err := verify(b); err == nil {
+		t.Errorf("Compressed Block of the wrong size verified")
+	}
+	if got, err := blockData(Block{Header: BlockHeader{Size: 8, Codec: "snappy"}, Data: data}); err != nil || string(got) != "aaaaaaaa" {
+		t.Errorf("Expected the decompressed data, got %q %s", got, err)
+	}
+
+	// an encrypted Block is sealed by the client, and only its checksum is
+	// checked
+	sealed := []byte("not snappy, and longer than the Block")
+	b = Block{BlockHeader{"DN1", "/out.txt", 8, 0, 1, 1, "snappy", 0, "bm9uY2U="}, sealed, checksum(sealed)}
+	if err := verify(b); err != nil {
+		t.Errorf("Encrypted Block failed verification %s", err)
+	}
+	b.Data = append([]byte{'N'}, sealed[1:]...)
+	if err := verify(b); err == nil {
+		t.Errorf("Corrupt encrypted Block verified")
+	}
+}
+
+func TestVerifyStore(t *testing.T) {
+
+	dir := t.TempDir()
+	store = NewVolumeStore([]string{dir})
+	good := BlockHeader{"DN1", "/out.txt", 4, 0, 3, 1, "", 0, ""}
+	flipped := BlockHeader{"DN1", "/out.txt", 4, 1, 3, 1, "", 0, ""}
+	short := BlockHeader{"DN1", "/out.txt", 4, 2, 3, 1, "", 0, ""}
+	store.Put(Block{good, []byte("data"), checksum([]byte("data"))})
+	store.Put(Block{flipped, []byte("dbta"), checksum([]byte("data"))})
+	store.Put(Block{short, []byte("dat"), checksum([]byte("dat"))})
+
+	// only healthy Blocks are in the first report, the others are kept aside
+	if n := VerifyStore(); n != 2 {
+		t.Errorf("Expected 2 corrupt Blocks, got %d", n)
+	}
+	if r := FullReport(); len(r.Headers) != 1 || r.Headers[0] != good {
+		t.Errorf("Expected only the healthy Block in the report, got %v", r.Headers)
+	}
+	pendingReport = nil
+	quarantined, _ := filepath.Glob(filepath.Join(dir, quarantineDir, "blk_*"+metaSuffix))
+	if len(quarantined) != 2 {
+		t.Errorf("Expected 2 quarantined Blocks, got %v", quarantined)
+	}
+}
+
+func TestBlockChecksum(t *testing.T) {
+
+	store = NewDiskStore(t.TempDir())
+	data := []byte{8, 0, 'a', 6<<2 | 2, 1, 0}
+	h := BlockHeader{"DN1", "/out.txt", 8, 0, 1, 1, "snappy", 0, ""}
+	store.Put(Block{h, data, checksum(data)})
+
+	// the checksum is of the data as the client wrote it, kept in the .meta
+	// file so the data is not read
+	name := store.(*diskStore).blockPath(h)
+	if err := ioutil.WriteFile(name, []byte("garbage"), 0600); err != nil {
+		t.Fatalf("%s", err)
+	}
+	sum, err := blockChecksum(h)
+	if err != nil || sum != checksum([]byte("aaaaaaaa")) {
+		t.Errorf("Expected the stored checksum of the decompressed data, got %d %v", sum, err)
+	}
+
+	// a Block stored before it was kept is read
+	store.Put(Block{h, data, checksum(data)})
+	meta, _ := json.Marshal(map[string]interface{}{"Header": h, "Checksum": checksum(data)})
+	if err := ioutil.WriteFile(name+metaSuffix, meta, 0600); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, sum, _ := store.(*diskStore).DataChecksum(h); sum != 0 {
+		t.Errorf("Expected no stored checksum, got %d", sum)
+	}
+	if sum, err := blockChecksum(h); err != nil || sum != checksum([]byte("aaaaaaaa")) {
+		t.Errorf("Expected the checksum of the decompressed data, got %d %v", sum, err)
+	}
+	if _, err := blockChecksum(BlockHeader{"DN1", "/out.txt", 8, 1, 2, 1, "", 0, ""}); err == nil {
+		t.Errorf("Missing Block had a checksum")
+	}
+}
@@ -0,0 +1,133 @@
+package datanode
+
+import (
+	"errors"
+	"log"
+	"strings"
+)
+
+// storage types a volume may be tagged with, fastest first
+const (
+	storageSSD     = "SSD"
+	storageDisk    = "DISK"
+	storageArchive = "ARCHIVE"
+)
+
+var storageType = storageDisk // storage type of the blockfileroot and of volumes not tagged with their own
+
+// validStorage reports whether t is a storage type
+func validStorage(t string) bool {
+	return t == storageSSD || t == storageDisk || t == storageArchive
+}
+
+// parseVolume splits a volume option such as [SSD]/mnt/ssd into its storage
+// type and directory. Volumes without a type are of storageType.
+func parseVolume(option string) (string, string, error) {
+	if !strings.HasPrefix(option, "[") {
+		return storageType, option, nil
+	}
+	end := strings.Index(option, "]")
+	if end < 0 {
+		return "", "", errors.New("Bad volume " + option + ", expected [TYPE]directory")
+	}
+	t := strings.ToUpper(option[1:end])
+	if !validStorage(t) {
+		return "", "", errors.New("Unknown storage type " + t + ", expected SSD, DISK or ARCHIVE")
+	}
+	return t, option[end+1:], nil
+}
+
+// tieredStore is implemented by stores whose volumes have different storage
+// types, which place Blocks by type and move them between types
+type tieredStore interface {
+	PutOn(b Block, storage string) error           // stores b on a volume of storage if there is one, or on any volume
+	StorageOf(h BlockHeader) (string, error)       // storage type of the volume holding the Block named by h
+	ListStorage() ([]BlockHeader, []string, error) // every stored Block, with the storage type of its volume
+	Storages() []string                            // storage types of the healthy volumes
+	Migrate(h BlockHeader, storage string) error   // moves the Block named by h to a volume of storage
+}
+
+// putBlock stores b, on a volume of the storage type if the store has one
+func putBlock(b Block, storage string) error {
+	if ts, ok := store.(tieredStore); ok && storage != "" {
+		return ts.PutOn(b, storage)
+	}
+	return store.Put(b)
+}
+
+// storageOf returns the storage type of the stored Block named by h
+func storageOf(h BlockHeader) string {
+	ts, ok := store.(tieredStore)
+	if !ok {
+		return storageType
+	}
+	t, err := ts.StorageOf(h)
+	if err != nil {
+		return storageType
+	}
+	return t
+}
+
+// storages lists the storage types the datanode stores Blocks on
+func storages() []string {
+	if ts, ok := store.(tieredStore); ok {
+		return ts.Storages()
+	}
+	return []string{storageType}
+}
+
+// reportStorages lists the storage type of each of headers for a block
+// report, or nil where every Block is on DISK, which the namenode assumes
+func reportStorages(headers []BlockHeader) []string {
+	if _, ok := store.(tieredStore); !ok && storageType == storageDisk {
+		return nil
+	}
+	list := make([]string, len(headers))
+	for i, h := range headers {
+		list[i] = storageOf(h)
+	}
+	return onlyTiered(list)
+}
+
+// onlyTiered returns nil for storage types which are all DISK
+func onlyTiered(types []string) []string {
+	for _, t := range types {
+		if t != storageDisk {
+			return types
+		}
+	}
+	return nil
+}
+
+// storedBlocks lists every stored Block for a full block report, with the
+// storage type of each as reportStorages gives them
+func storedBlocks() ([]BlockHeader, []string) {
+	ts, ok := store.(tieredStore)
+	if !ok {
+		headers := GetBlockHeaders()
+		return headers, reportStorages(headers)
+	}
+	headers, types, err := ts.ListStorage()
+	CheckError(err)
+	return headers, onlyTiered(types)
+}
+
+// migrateBlocks moves the Blocks of a MIGRATE request to volumes of its
+// storage type, recording each moved Block for the next block report so the
+// namenode learns its new type
+func migrateBlocks(p Packet) {
+	ts, ok := store.(tieredStore)
+	if !ok {
+		log.Println("Cannot move Blocks to ", p.Storage, ", the volumes have no storage types")
+		return
+	}
+	for _, h := range p.Headers {
+		err := ts.Migrate(h, p.Storage)
+		if err != nil {
+			log.Println("Could not move Block ", blockName(h), " to ", p.Storage, err)
+			continue
+		}
+		recordAdded(h)
+		log.Println("Moved Block ", blockName(h), " to ", p.Storage)
+	}
+}
@@ -0,0 +1,50 @@
+package datanode
+
+import (
+	"testing"
+)
+
+func TestStorageTypes(t *testing.T) {
+
+	if _, _, err := parseVolume("[TAPE]/mnt/tape"); err == nil {
+		t.Errorf("Parsed an unknown storage type")
+	}
+	if s, dir, err := parseVolume("[ssd]/mnt/ssd"); err != nil || s != "SSD" || dir != "/mnt/ssd" {
+		t.Errorf("Wrong volume %s %s %v", s, dir, err)
+	}
+
+	ssd, disk := t.TempDir(), t.TempDir()
+	store = NewVolumeStore([]string{"[SSD]" + ssd, disk})
+	addedBlocks = nil
+	removedBlocks = nil
+	if s := storages(); len(s) != 2 || s[0] != "DISK" || s[1] != "SSD" {
+		t.Errorf("Wrong storage types %v", s)
+	}
+
+	h := BlockHeader{"DN1", "/hot.txt", 1, 0, 1, 0, "", 0, ""}
+	if err := putBlock(Block{h, []byte("d"), 0}, "SSD"); err != nil {
+		t.Fatal(err)
+	}
+	if s := storageOf(h); s != "SSD" {
+		t.Errorf("Block written to %s", s)
+	}
+	if s := reportStorages([]BlockHeader{h}); len(s) != 1 || s[0] != "SSD" {
+		t.Errorf("Wrong reported storage %v", s)
+	}
+
+	// a MIGRATE request moves the Block and reports it again
+	migrateBlocks(Packet{CMD: MIGRATE, Headers: []BlockHeader{h}, Storage: "DISK"})
+	if s := storageOf(h); s != "DISK" {
+		t.Errorf("Block not moved, on %s", s)
+	}
+	if b := BlockFromHeader(h); string(b.Data) != "d" {
+		t.Errorf("Block lost moving it %v", b)
+	}
+	if len(addedBlocks) == 0 || addedBlocks[len(addedBlocks)-1] != h {
+		t.Errorf("Moved Block not reported %v", addedBlocks)
+	}
+	headers, types := storedBlocks()
+	if len(headers) != 1 || types != nil {
+		t.Errorf("Wrong full report %v %v", headers, types)
+	}
+}
@@ -0,0 +1,78 @@
+package datanode
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// syncedStore is implemented by stores keeping Blocks in files, which can
+// force a stored Block to stable storage so it survives a crash
+type syncedStore interface {
+	Sync(h BlockHeader) error // forces the Block named by h to stable storage
+}
+
+// syncBlock forces the stored Block named by h to stable storage. Stores
+// which need no syncing, such as memory and S3, have nothing to do.
+func syncBlock(h BlockHeader) error {
+	if s, ok := store.(syncedStore); ok {
+		return s.Sync(h)
+	}
+	_, err := store.Stat(h)
+	return err
+}
+
+// syncBlocks forces the Blocks of a SYNC request to stable storage, and
+// describes those synced in a SYNCACK
+func syncBlocks(p Packet) Packet {
+	r := Packet{SRC: id, DST: p.SRC, CMD: SYNCACK, Headers: make([]BlockHeader, 0, len(p.Headers))}
+	for _, h := range p.Headers {
+		err := syncBlock(h)
+		if err != nil {
+			log.Println("Could not sync Block ", blockName(h), err)
+			continue
+		}
+		r.Headers = append(r.Headers, h)
+	}
+	return r
+}
+
+// Sync forces the data and .meta files of the Block named by h, and the
+// directory holding them, to disc
+func (s *diskStore) Sync(h BlockHeader) error {
+	name := s.blockPath(h)
+	for _, f := range []string{name, name + metaSuffix, filepath.Dir(name)} {
+		err := syncFile(f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncFile forces a file or directory to disc
+func syncFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// Sync forces the Block named by h to stable storage on the volume holding it
+func (s *volumeStore) Sync(h BlockHeader) error {
+	for _, v := range s.healthy() {
+		_, err := v.store.Stat(h)
+		if err != nil {
+			s.check(v, err)
+			continue
+		}
+		if vs, ok := v.store.(syncedStore); ok {
+			err = vs.Sync(h)
+			s.check(v, err)
+		}
+		return err
+	}
+	return os.ErrNotExist
+}
@@ -0,0 +1,38 @@
+package datanode
+
+import (
+	"testing"
+)
+
+func TestSyncBlocks(t *testing.T) {
+
+	store = NewVolumeStore([]string{t.TempDir()})
+	id = "DN1"
+	addedBlocks = nil
+	removedBlocks = nil
+
+	// a Block being flushed is synced before its BLOCKACK
+	h := BlockHeader{"DN1", "/log.txt", 4, 0, 2, 1, "", 0, ""}
+	var r recorder
+	HandleResponse(Packet{SRC: "NN", DST: "DN1", CMD: BLOCK, Data: Block{h, []byte("data"), 0}, Flags: FSYNC}, &r)
+	if len(r.sent) != 1 || r.sent[0].CMD != BLOCKACK || r.sent[0].Flags&FSYNC == 0 {
+		t.Fatalf("Expected a synced BLOCKACK, got %v", r.sent)
+	}
+	HandleResponse(Packet{SRC: "NN", DST: "DN1", CMD: BLOCK, Data: Block{BlockHeader{"DN1", "/log.txt", 4, 1, 2, 1, "", 0, ""}, []byte("more"), 0}}, &r)
+	if len(r.sent) != 2 || r.sent[1].Flags&FSYNC != 0 {
+		t.Fatalf("Expected an unsynced BLOCKACK, got %v", r.sent[1:])
+	}
+
+	// only the Blocks stored are reported synced
+	missing := BlockHeader{"DN1", "/log.txt", 4, 5, 6, 1, "", 0, ""}
+	HandleResponse(Packet{SRC: "NN", DST: "DN1", CMD: SYNC, Headers: []BlockHeader{h, missing}, RequestID: 3}, &r)
+	ack := r.sent[2]
+	if ack.CMD != SYNCACK || len(ack.Headers) != 1 || ack.Headers[0] != h || ack.RequestID != 3 {
+		t.Errorf("Expected a SYNCACK of %v, got %v", h, ack)
+	}
+
+	store = NewMemStore()
+	if syncBlock(h) == nil {
+		t.Errorf("Synced a Block the store does not hold")
+	}
+}
@@ -0,0 +1,143 @@
+package datanode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the HTTP path datanodes receive replicas from other datanodes on
+const transferPath = "/transfer"
+
+// bytes sent between waits of a throttled transfer
+const throttleChunk = 64 * 1024
+
+var transferBandwidth int64 // bytes a second sent copying replicas to other datanodes, 0 for no limit, accessed atomically
+
+// transferThrottle paces the replicas being sent, so together they stay
+// under transferBandwidth and leave room for client traffic
+var transferThrottle throttle
+
+// throttle spaces out the chunks of data sent through it
+type throttle struct {
+	lock sync.Mutex
+	next time.Time // when the next chunk may be sent
+}
+
+// wait blocks until n more bytes may be sent at rate bytes a second, not at
+// all if rate is 0
+func (t *throttle) wait(n int, rate int64) {
+	if rate <= 0 {
+		return
+	}
+	t.lock.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	delay := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	t.lock.Unlock()
+	time.Sleep(delay)
+}
+
+// throttledReader reads through transferThrottle
+type throttledReader struct {
+	r io.Reader
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	transferThrottle.wait(n, atomic.LoadInt64(&transferBandwidth))
+	return n, err
+}
+
+// the outcomes of REPLICATE requests, sent to the namenode by the main loop
+var transfers = make(chan Packet)
+
+// replicateBlock copies the Block of a REPLICATE request to the datanode
+// named by its Message, whose HTTP server is at its Address, and reports the
+// outcome to the namenode in a REPLICATEACK
+func replicateBlock(p Packet) {
+	r := Packet{SRC: id, DST: p.SRC, CMD: REPLICATEACK, Headers: p.Headers, RequestID: p.RequestID}
+	err := transferBlock(p.Headers[0], p.Message, p.Address, p.Storage)
+	if err != nil {
+		log.Println("Could not replicate Block ", blockName(p.Headers[0]), " to ", p.Message, err)
+		r.Message = err.Error()
+	} else {
+		log.Println("Replicated Block ", blockName(p.Headers[0]), " to ", p.Message)
+	}
+	transfers <- r
+}
+
+// transferBlock sends the stored Block described by h to the datanode
+// target, at most transferBandwidth bytes a second, to be written to its
+// volumes of the storage type if given
+func transferBlock(h BlockHeader, target, address, storage string) error {
+	b := BlockFromHeader(h)
+	if b.Header.Filename != h.Filename || b.Header.BlockNum != h.BlockNum {
+		return errors.New("Block not found " + blockName(h))
+	}
+	b.Header.DatanodeID = target
+	body, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	u := "http://" + address + transferPath
+	if storage != "" {
+		u += "?storage=" + url.QueryEscape(storage)
+	}
+	resp, err := http.Post(u, "application/json", throttledReader{bytes.NewReader(body)})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return errors.New("Datanode " + target + " returned " + resp.Status + ": " + strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// ServeTransfer stores a replica sent by another datanode, which the main
+// loop acknowledges to the namenode
+func ServeTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Replicas must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	var b Block
+	err := json.NewDecoder(r.Body).Decode(&b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if b.Header.DatanodeID != id {
+		http.Error(w, "Block is meant for datanode "+b.Header.DatanodeID, http.StatusBadRequest)
+		return
+	}
+	err = verify(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := writeRequest{[]Block{b}, make(chan error, 1), true, r.URL.Query().Get("storage")}
+	writeRequests <- req
+	err = <-req.done
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,81 @@
+package datanode
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransferBlock(t *testing.T) {
+
+	store = NewMemStore()
+	addedBlocks = nil
+	removedBlocks = nil
+	id = "DN2"
+	h := BlockHeader{"DN1", "/out.txt", 4, 0, 1, 1, "", 0, ""}
+	WriteBlock(Block{h, []byte("data"), 0})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(transferPath, ServeTransfer)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	// stand in for the main loop of the target datanode
+	received := make(chan writeRequest, 1)
+	go func() {
+		req := <-writeRequests
+		received <- req
+		req.done <- nil
+	}()
+	err := transferBlock(h, "DN2", address, "")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	req := <-received
+	if !req.ack || len(req.blocks) != 1 {
+		t.Fatalf("Unexpected write request %v", req)
+	}
+	if b := req.blocks[0]; b.Header.DatanodeID != "DN2" || string(b.Data) != "data" {
+		t.Errorf("Unexpected replica %v", b)
+	}
+
+	if err := transferBlock(h, "DN3", address, ""); err == nil {
+		t.Errorf("Replica meant for another datanode accepted")
+	}
+	if err := transferBlock(BlockHeader{"DN1", "/missing.txt", 4, 0, 1, 1, "", 0, ""}, "DN2", address, ""); err == nil {
+		t.Errorf("Missing Block transferred")
+	}
+}
+
+func TestTransferThrottle(t *testing.T) {
+
+	defer func() { transferBandwidth = 0 }()
+	var r recorder
+	HandleResponse(Packet{SRC: "NN", DST: "DN1", CMD: SETBANDWIDTH, Message: "1048576"}, &r)
+	if transferBandwidth != 1<<20 || len(r.sent) != 0 {
+		t.Fatalf("Bandwidth %d after SETBANDWIDTH, answered %v", transferBandwidth, r.sent)
+	}
+
+	// after the first chunk each waits for its share of the bandwidth
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, throttledReader{bytes.NewReader(make([]byte, 4*throttleChunk))})
+	if err != nil || n != 4*throttleChunk {
+		t.Fatalf("Read %d bytes %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("256KiB sent at 1MiB per second in %v", elapsed)
+	}
+
+	HandleResponse(Packet{SRC: "NN", DST: "DN1", CMD: SETBANDWIDTH, Message: "0"}, &r)
+	start = time.Now()
+	io.Copy(ioutil.Discard, throttledReader{bytes.NewReader(make([]byte, 4*throttleChunk))})
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Unlimited transfer took %v", elapsed)
+	}
+}
@@ -0,0 +1,449 @@
+package datanode
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var volumeDirs []string        // storage directories, usually one per disk
+var failedVolumesTolerated = 0 // volumes which may fail before the datanode stops
+var reportedFailures = 0       // failed volumes the namenode was sent a full report for
+
+// errNoFreeSpace is returned where the free space of a volume is not known
+var errNoFreeSpace = errors.New("Free space is not supported")
+
+// volume is a storage directory of a datanode
+type volume struct {
+	dir     string
+	storage string // storage type of the volume, such as SSD
+	store   BlockStore
+	failed  bool
+}
+
+// volumeStore spreads Blocks over several disk volumes, writing to each in
+// turn. A volume giving an IO error is taken out of service, and the
+// remaining volumes keep serving their Blocks.
+type volumeStore struct {
+	lock    sync.Mutex
+	volumes []*volume
+	next    int // volume for the next new Block
+}
+
+// NewVolumeStore returns a BlockStore keeping Blocks below the directories,
+// each of which may be tagged with its storage type, as in [SSD]/mnt/ssd
+func NewVolumeStore(dirs []string) BlockStore {
+	s := &volumeStore{}
+	for _, option := range dirs {
+		storage, dir, err := parseVolume(option)
+		if err != nil {
+			// volume options are checked as the configuration is read
+			storage, dir = storageType, option
+		}
+		s.volumes = append(s.volumes, &volume{dir: dir, storage: storage, store: NewDiskStore(dir)})
+	}
+	return s
+}
+
+// healthy returns the volumes which have not failed, starting from the
+// volume for the next new Block
+func (s *volumeStore) healthy() []*volume {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]*volume, 0, len(s.volumes))
+	for i := range s.volumes {
+		v := s.volumes[(s.next+i)%len(s.volumes)]
+		if !v.failed {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// fail takes a volume out of service after an IO error
+func (s *volumeStore) fail(v *volume, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !v.failed {
+		v.failed = true
+		log.Println("Volume failed ", v.dir, err)
+	}
+}
+
+// Failed lists the directories of the failed volumes
+func (s *volumeStore) Failed() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	failed := make([]string, 0)
+	for _, v := range s.volumes {
+		if v.failed {
+			failed = append(failed, v.dir)
+		}
+	}
+	return failed
+}
+
+// Capacity is the free space on the healthy volumes
+func (s *volumeStore) Capacity() (int64, error) {
+	var total int64
+	reported := false
+	for _, v := range s.healthy() {
+		n, err := freeSpace(v.dir)
+		if err == errNoFreeSpace {
+			return 0, err
+		}
+		if err != nil {
+			s.fail(v, err)
+			continue
+		}
+		total += n
+		reported = true
+	}
+	if !reported {
+		return 0, errors.New("No healthy volumes")
+	}
+	return total, nil
+}
+
+// isFull reports whether an error came from a volume running out of space,
+// which does not fail the volume
+func isFull(err error) bool {
+	var errno syscall.Errno
+	return errors.As(err, &errno) && errno == syscall.ENOSPC
+}
+
+// check fails a volume for an IO error other than a missing Block or lack
+// of space
+func (s *volumeStore) check(v *volume, err error) {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) && !os.IsNotExist(err) && !isFull(err) {
+		s.fail(v, err)
+	}
+}
+
+// find returns the volume holding the Block named by h, and the Block
+func (s *volumeStore) find(h BlockHeader) (*volume, Block, error) {
+	for _, v := range s.healthy() {
+		b, err := v.store.Get(h)
+		if err == nil {
+			return v, b, nil
+		}
+		s.check(v, err)
+	}
+	return nil, Block{}, os.ErrNotExist
+}
+
+func (s *volumeStore) Put(b Block) error {
+	return s.PutOn(b, "")
+}
+
+// PutOn stores b on the next volume of the storage type, or of any type if
+// none of them takes it. A Block written again replaces the copy on its
+// volume, unless that volume is of another type than the one asked for.
+func (s *volumeStore) PutOn(b Block, storage string) error {
+	holder, _, err := s.find(b.Header)
+	if err == nil {
+		if storage == "" || holder.storage == storage {
+			err = holder.store.Put(b)
+			if err == nil {
+				return nil
+			}
+			s.check(holder, err)
+		}
+		holder.store.Delete(b.Header)
+	}
+
+	for _, v := range s.byStorage(storage) {
+		err = v.store.Put(b)
+		if err == nil {
+			s.lock.Lock()
+			s.next++
+			s.lock.Unlock()
+			return nil
+		}
+		s.check(v, err)
+	}
+	if err == nil {
+		err = errors.New("No healthy volumes")
+	}
+	return err
+}
+
+// byStorage returns the healthy volumes, those of the storage type first
+func (s *volumeStore) byStorage(storage string) []*volume {
+	healthy := s.healthy()
+	list := make([]*volume, 0, len(healthy))
+	for _, v := range healthy {
+		if v.storage == storage {
+			list = append(list, v)
+		}
+	}
+	for _, v := range healthy {
+		if v.storage != storage {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// StorageOf returns the storage type of the volume holding the Block named
+// by h
+func (s *volumeStore) StorageOf(h BlockHeader) (string, error) {
+	for _, v := range s.healthy() {
+		_, err := v.store.Stat(h)
+		if err == nil {
+			return v.storage, nil
+		}
+		s.check(v, err)
+	}
+	return "", os.ErrNotExist
+}
+
+// Storages lists the storage types of the healthy volumes
+func (s *volumeStore) Storages() []string {
+	seen := make(map[string]bool)
+	list := make([]string, 0)
+	for _, v := range s.healthy() {
+		if !seen[v.storage] {
+			seen[v.storage] = true
+			list = append(list, v.storage)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Migrate moves the Block named by h to a volume of the storage type, which
+// the datanode must have
+func (s *volumeStore) Migrate(h BlockHeader, storage string) error {
+	holder, b, err := s.find(h)
+	if err != nil {
+		return err
+	}
+	if holder.storage == storage {
+		return nil
+	}
+	err = errors.New("No healthy " + storage + " volume")
+	for _, v := range s.byStorage(storage) {
+		if v.storage != storage {
+			break
+		}
+		err = v.store.Put(b)
+		if err == nil {
+			s.lock.Lock()
+			s.next++
+			s.lock.Unlock()
+			err = holder.store.Delete(h)
+			s.check(holder, err)
+			return err
+		}
+		s.check(v, err)
+	}
+	return err
+}
+
+func (s *volumeStore) Get(h BlockHeader) (Block, error) {
+	_, b, err := s.find(h)
+	return b, err
+}
+
+func (s *volumeStore) Delete(h BlockHeader) error {
+	v, _, err := s.find(h)
+	if err != nil {
+		return err
+	}
+	err = v.store.Delete(h)
+	s.check(v, err)
+	return err
+}
+
+func (s *volumeStore) List() ([]BlockHeader, error) {
+	headers := make([]BlockHeader, 0)
+	for _, v := range s.healthy() {
+		list, err := v.store.List()
+		if err != nil {
+			s.check(v, err)
+			continue
+		}
+		headers = append(headers, list...)
+	}
+	if len(s.healthy()) == 0 {
+		return nil, errors.New("No healthy volumes")
+	}
+	return headers, nil
+}
+
+// ListStorage lists the Blocks on the healthy volumes, with the storage type
+// of the volume holding each
+func (s *volumeStore) ListStorage() ([]BlockHeader, []string, error) {
+	headers := make([]BlockHeader, 0)
+	types := make([]string, 0)
+	for _, v := range s.healthy() {
+		list, err := v.store.List()
+		if err != nil {
+			s.check(v, err)
+			continue
+		}
+		headers = append(headers, list...)
+		for range list {
+			types = append(types, v.storage)
+		}
+	}
+	if len(s.healthy()) == 0 {
+		return nil, nil, errors.New("No healthy volumes")
+	}
+	return headers, types, nil
+}
+
+func (s *volumeStore) Stat(h BlockHeader) (BlockHeader, error) {
+	_, b, err := s.find(h)
+	return b.Header, err
+}
+
+// DataChecksum returns the header and stored CRC-32 of the uncompressed data
+// of the Block named by h from the volume holding it
+func (s *volumeStore) DataChecksum(h BlockHeader) (BlockHeader, uint32, error) {
+	for _, v := range s.healthy() {
+		c, ok := v.store.(checksummer)
+		if !ok {
+			continue
+		}
+		stored, sum, err := c.DataChecksum(h)
+		if err == nil {
+			return stored, sum, nil
+		}
+		s.check(v, err)
+	}
+	return BlockHeader{}, 0, os.ErrNotExist
+}
+
+// Relabel changes the header of the Block named by h on the volume holding
+// it, without reading its data
+func (s *volumeStore) Relabel(h, to BlockHeader) error {
+	for _, v := range s.healthy() {
+		if _, err := v.store.Stat(h); err != nil {
+			s.check(v, err)
+			continue
+		}
+		r, ok := v.store.(relabeler)
+		if !ok {
+			return errors.New("Volume cannot relabel Block " + blockName(h))
+		}
+		err := r.Relabel(h, to)
+		s.check(v, err)
+		return err
+	}
+	return os.ErrNotExist
+}
+
+// Quarantine sets aside the Block named by h on the volume holding it
+func (s *volumeStore) Quarantine(h BlockHeader) error {
+	v, _, err := s.find(h)
+	if err != nil {
+		return err
+	}
+	q, ok := v.store.(quarantiner)
+	if !ok {
+		return v.store.Delete(h)
+	}
+	err = q.Quarantine(h)
+	s.check(v, err)
+	return err
+}
+
+// repair checks the layout of each volume, taking those which cannot be
+// repaired, or belong to another datanode, out of service
+func (s *volumeStore) repair() {
+	owner := ""
+	for _, v := range s.healthy() {
+		ds, ok := v.store.(*diskStore)
+		if !ok {
+			continue
+		}
+		err := ds.repair()
+		if err == nil {
+			id := ds.DatanodeID()
+			if owner == "" {
+				owner = id
+			} else if id != "" && id != owner {
+				err = errors.New("Volume belongs to datanode " + id + ", not " + owner)
+			}
+		}
+		if err != nil {
+			s.fail(v, err)
+		}
+	}
+}
+
+// DatanodeID returns the ID kept by the healthy volumes, if any
+func (s *volumeStore) DatanodeID() string {
+	for _, v := range s.healthy() {
+		if k, ok := v.store.(idKeeper); ok && k.DatanodeID() != "" {
+			return k.DatanodeID()
+		}
+	}
+	return ""
+}
+
+// SetDatanodeID keeps the ID the datanode registered under on each healthy
+// volume
+func (s *volumeStore) SetDatanodeID(id string) error {
+	for _, v := range s.healthy() {
+		if k, ok := v.store.(idKeeper); ok {
+			err := k.SetDatanodeID(id)
+			if err != nil {
+				s.check(v, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// volumeStatus is implemented by stores which know their free space and
+// failed volumes
+type volumeStatus interface {
+	Capacity() (int64, error)
+	Failed() []string
+}
+
+// addCapacity reports the free space and failed volumes of the store in a
+// heartbeat
+func addCapacity(p *Packet) {
+	vs, ok := store.(volumeStatus)
+	if !ok {
+		return
+	}
+	n, err := vs.Capacity()
+	if err != nil {
+		return
+	}
+	p.Flags |= CAPACITY
+	p.Capacity = n
+	p.Message = strings.Join(vs.Failed(), ",")
+}
+
+// checkVolumes sends a full report once a volume has failed, so the namenode
+// learns which Blocks were lost with it. The datanode stops when more
+// volumes failed than tolerated.
+func checkVolumes(encoder packetEncoder) {
+	vs, ok := store.(volumeStatus)
+	if !ok {
+		return
+	}
+	failed := vs.Failed()
+	if len(failed) > failedVolumesTolerated {
+		CheckError(errors.New("Too many failed volumes " + strings.Join(failed, ",")))
+	}
+	if len(failed) > reportedFailures {
+		reportedFailures = len(failed)
+		encoder.Encode(FullReport())
+	}
+}
@@ -0,0 +1,56 @@
+package datanode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVolumeFailure(t *testing.T) {
+
+	dirs := []string{t.TempDir(), t.TempDir()}
+	store = NewVolumeStore(dirs)
+	addedBlocks = nil
+	removedBlocks = nil
+
+	for i := 0; i < 4; i++ {
+		WriteBlock(Block{BlockHeader{"DN1", "/out.txt", 1, i, 4, 0, "", 0, ""}, []byte("d"), 0})
+	}
+
+	// Blocks are written to each volume in turn
+	for _, dir := range dirs {
+		files, _ := filepath.Glob(filepath.Join(dir, currentDir, "subdir*", "subdir*", "blk_*"+metaSuffix))
+		if len(files) != 2 {
+			t.Fatalf("Expected 2 Blocks in %s, got %v", dir, files)
+		}
+	}
+
+	p := new(Packet)
+	addCapacity(p)
+	if p.Flags&CAPACITY == 0 || p.Capacity <= 0 || p.Message != "" {
+		t.Errorf("Expected capacity of healthy volumes, got %v", p)
+	}
+
+	// the first volume's disk goes away
+	os.RemoveAll(dirs[0])
+	os.WriteFile(dirs[0], []byte("not a directory"), 0600)
+
+	if len(GetBlockHeaders()) != 2 {
+		t.Errorf("Expected the Blocks of the surviving volume, got %v", GetBlockHeaders())
+	}
+	failed := store.(*volumeStore).Failed()
+	if len(failed) != 1 || failed[0] != dirs[0] {
+		t.Fatalf("Expected %s to fail, got %v", dirs[0], failed)
+	}
+
+	WriteBlock(Block{BlockHeader{"DN1", "/new.txt", 1, 0, 1, 0, "", 0, ""}, []byte("d"), 0})
+	if b := BlockFromHeader(BlockHeader{Filename: "/new.txt"}); string(b.Data) != "d" {
+		t.Errorf("Block not written to the surviving volume %v", b)
+	}
+
+	p = new(Packet)
+	addCapacity(p)
+	if p.Flags&CAPACITY == 0 || p.Message != dirs[0] {
+		t.Errorf("Expected the failed volume in the heartbeat, got %v", p)
+	}
+}
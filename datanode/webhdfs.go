@@ -0,0 +1,247 @@
+package datanode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// the URL prefix of WebHDFS requests
+const webhdfsPrefix = "/webhdfs/v1"
+
+var httpAddress string // host:port to serve WebHDFS data transfers on
+
+// writeRequest asks the main loop to store Blocks received over HTTP, so
+// that Blocks and block reports are only modified by that loop
+type writeRequest struct {
+	blocks  []Block
+	done    chan error
+	ack     bool   // each Block is acknowledged with a BLOCKACK, as when sent by the namenode
+	storage string // storage type the Blocks are written to, any if empty
+}
+
+var writeRequests = make(chan writeRequest)
+
+// blockLocation describes the replicas of a Block, as listed by the namenode
+type blockLocation struct {
+	Offset int64    `json:"offset"`
+	Length int64    `json:"length"`
+	Hosts  []string `json:"hosts"`
+	Names  []string `json:"names"`
+}
+
+// ServeWebHDFS serves the data transfers of the WebHDFS REST API, which the
+// namenode redirects clients to
+func ServeWebHDFS(w http.ResponseWriter, r *http.Request) {
+	p := path.Clean("/" + strings.TrimPrefix(r.URL.Path, webhdfsPrefix))
+	q := r.URL.Query()
+	op := strings.ToUpper(q.Get("op"))
+
+	switch {
+	case op == "OPEN" && r.Method == "GET":
+		openFile(w, r, p)
+	case op == "READBLOCK" && r.Method == "GET":
+		n, err := strconv.Atoi(q.Get("block"))
+		if err != nil {
+			webhdfsError(w, http.StatusBadRequest, "IllegalArgumentException", "Invalid block number")
+			return
+		}
+		b := BlockFromHeader(BlockHeader{Filename: p, BlockNum: n})
+		if b.Header.Filename != p {
+			webhdfsError(w, http.StatusNotFound, "FileNotFoundException", "Block not found")
+			return
+		}
+		data, err := blockData(b)
+		if err != nil {
+			webhdfsError(w, http.StatusInternalServerError, "IOException", err.Error())
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(b.Header.Size, 10))
+		w.Write(data)
+	case op == "CREATE" && r.Method == "PUT":
+		createFile(w, r, p)
+	default:
+		webhdfsError(w, http.StatusBadRequest, "IllegalArgumentException", "Unsupported operation "+r.Method+" op="+op)
+	}
+}
+
+// openFile streams a file, reading Blocks stored elsewhere from the
+// datanodes the namenode lists for them
+func openFile(w http.ResponseWriter, r *http.Request, p string) {
+	q := r.URL.Query()
+	offset, _ := strconv.ParseInt(q.Get("offset"), 10, 64)
+	length := int64(-1)
+	if q.Get("length") != "" {
+		length, _ = strconv.ParseInt(q.Get("length"), 10, 64)
+	}
+
+	locations, err := getBlockLocations(q.Get("namenodeaddress"), p)
+	if err != nil {
+		webhdfsError(w, http.StatusBadGateway, "IOException", err.Error())
+		return
+	}
+
+	var size int64
+	for _, loc := range locations {
+		size += loc.Length
+	}
+	if offset < 0 || offset > size {
+		webhdfsError(w, http.StatusBadRequest, "IllegalArgumentException", "Offset out of range")
+		return
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	for i, loc := range locations {
+		if length <= 0 {
+			break
+		}
+		if offset >= loc.Length {
+			offset -= loc.Length
+			continue
+		}
+
+		data, err := readBlock(p, i, loc)
+		if err != nil {
+			// the response is already under way, so it can only be cut short
+			log.Println("Could not read Block ", p, i, err)
+			return
+		}
+		data = data[offset:]
+		offset = 0
+		if int64(len(data)) > length {
+			data = data[:length]
+		}
+		w.Write(data)
+		length -= int64(len(data))
+	}
+}
+
+// getBlockLocations asks the namenode at address for the Blocks of a file
+func getBlockLocations(address, p string) ([]blockLocation, error) {
+	if address == "" {
+		return nil, errors.New("Missing namenodeaddress")
+	}
+	u := url.URL{Scheme: "http", Host: address, Path: webhdfsPrefix + p, RawQuery: "op=GETFILEBLOCKLOCATIONS"}
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Namenode returned %s", resp.Status)
+	}
+
+	var result struct {
+		BlockLocations struct {
+			BlockLocation []blockLocation
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return result.BlockLocations.BlockLocation, nil
+}
+
+// readBlock reads a Block from disc, or from one of the datanodes holding it
+func readBlock(p string, n int, loc blockLocation) ([]byte, error) {
+	for _, name := range loc.Names {
+		if name != httpAddress {
+			continue
+		}
+		b := BlockFromHeader(BlockHeader{Filename: p, BlockNum: n})
+		if b.Header.Filename == p {
+			return blockData(b)
+		}
+	}
+
+	for _, name := range loc.Names {
+		if name == httpAddress {
+			continue
+		}
+		u := url.URL{Scheme: "http", Host: name, Path: webhdfsPrefix + p, RawQuery: "op=READBLOCK&block=" + strconv.Itoa(n)}
+		resp, err := http.Get(u.String())
+		if err != nil {
+			log.Println("Could not reach datanode ", name, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil && resp.StatusCode == http.StatusOK && int64(len(data)) == loc.Length {
+			return data, nil
+		}
+	}
+	return nil, errors.New("No replica of the Block could be read")
+}
+
+// createFile stores an uploaded file as Blocks on this datanode, which are
+// reported to the namenode by the main loop
+func createFile(w http.ResponseWriter, r *http.Request, p string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		webhdfsError(w, http.StatusBadRequest, "IOException", err.Error())
+		return
+	}
+	if len(data) == 0 {
+		webhdfsError(w, http.StatusBadRequest, "IllegalArgumentException", "Empty files are not supported")
+		return
+	}
+
+	total := int((int64(len(data)) + SIZEOFBLOCK - 1) / SIZEOFBLOCK)
+	blocks := make([]Block, 0, total)
+	for i := 0; i < total; i++ {
+		start := int64(i) * SIZEOFBLOCK
+		end := start + SIZEOFBLOCK
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		h := BlockHeader{id, p, end - start, i, total, 0, "", 0, ""}
+		blocks = append(blocks, Block{Header: h, Data: data[start:end]})
+	}
+
+	req := writeRequest{blocks, make(chan error, 1), false, ""}
+	writeRequests <- req
+	err = <-req.done
+	if err != nil {
+		webhdfsError(w, http.StatusInternalServerError, "IOException", err.Error())
+		return
+	}
+	w.Header().Set("Location", "webhdfs://"+r.URL.Query().Get("namenodeaddress")+p)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// ServeHTTP serves WebHDFS data transfers, and replicas sent by other
+// datanodes, on httpAddress
+func ServeHTTP() {
+	mux := http.NewServeMux()
+	mux.HandleFunc(webhdfsPrefix+"/", ServeWebHDFS)
+	mux.HandleFunc(transferPath, ServeTransfer)
+	err := http.ListenAndServe(httpAddress, mux)
+	if err != nil {
+		log.Println("HTTP server error ", err)
+	}
+}
+
+// webhdfsError writes a WebHDFS RemoteException
+func webhdfsError(w http.ResponseWriter, status int, exception, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"RemoteException": map[string]string{
+			"exception": exception,
+			"message":   message,
+		},
+	})
+}
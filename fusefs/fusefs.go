@@ -0,0 +1,273 @@
+//go:build fuse
+
+// Package fusefs mounts GoDFS as a local filesystem using FUSE
+package fusefs
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/sjarvie/godfs/client"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+)
+
+// FS is the root of a mounted GoDFS filesystem. The client keeps a single
+// connection to the namenode, so requests are serialized by lock.
+type FS struct {
+	lock    sync.Mutex
+	created map[string]bool // files created but not yet written
+}
+
+// Mount mounts the filesystem of the connected namenode at mountpoint and
+// serves it until it is unmounted
+func Mount(mountpoint string) error {
+	c, err := fuse.Mount(mountpoint, fuse.FSName("godfs"), fuse.Subtype("godfs"))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return fs.Serve(c, &FS{created: make(map[string]bool)})
+}
+
+// Root returns the root directory
+func (f *FS) Root() (fs.Node, error) {
+	return &Dir{fs: f, path: "/"}, nil
+}
+
+// stat describes a remote path, including files created but not yet written
+func (f *FS) stat(p string) (client.FileStatus, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	st, err := client.Stat(p)
+	if err != nil {
+		if f.created[p] {
+			return client.FileStatus{Path: p}, nil
+		}
+		return st, fuse.ENOENT
+	}
+	return st, nil
+}
+
+// Dir is a directory of the filesystem
+type Dir struct {
+	fs   *FS
+	path string
+}
+
+// Attr describes the directory
+func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0755
+	return nil
+}
+
+// Lookup finds an entry of the directory
+func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	p := path.Join(d.path, name)
+	st, err := d.fs.stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if st.IsDir {
+		return &Dir{fs: d.fs, path: p}, nil
+	}
+	return &File{fs: d.fs, path: p, size: uint64(st.Size)}, nil
+}
+
+// ReadDirAll lists the entries of the directory
+func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	d.fs.lock.Lock()
+	defer d.fs.lock.Unlock()
+
+	list, err := client.ListDir(d.path, false)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+
+	entries := make([]fuse.Dirent, 0, len(list))
+	for _, st := range list {
+		e := fuse.Dirent{Name: path.Base(st.Path), Type: fuse.DT_File}
+		if st.IsDir {
+			e.Type = fuse.DT_Dir
+		}
+		entries = append(entries, e)
+	}
+	for p := range d.fs.created {
+		if path.Dir(p) == d.path {
+			entries = append(entries, fuse.Dirent{Name: path.Base(p), Type: fuse.DT_File})
+		}
+	}
+	return entries, nil
+}
+
+// Mkdir creates a subdirectory
+func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	d.fs.lock.Lock()
+	defer d.fs.lock.Unlock()
+
+	p := path.Join(d.path, req.Name)
+	err := client.Mkdir(p, false)
+	if err != nil {
+		return nil, fuse.Errno(syscall.EEXIST)
+	}
+	return &Dir{fs: d.fs, path: p}, nil
+}
+
+// Create creates an empty file, which is written to the filesystem once the
+// handle is flushed
+func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	d.fs.lock.Lock()
+	defer d.fs.lock.Unlock()
+
+	p := path.Join(d.path, req.Name)
+	d.fs.created[p] = true
+	f := &File{fs: d.fs, path: p}
+	return f, &Handle{file: f, dirty: true}, nil
+}
+
+// Remove deletes an entry of the directory
+func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	d.fs.lock.Lock()
+	defer d.fs.lock.Unlock()
+
+	p := path.Join(d.path, req.Name)
+	if d.fs.created[p] {
+		delete(d.fs.created, p)
+		return nil
+	}
+	err := client.Delete(p, false, false)
+	if err != nil {
+		return fuse.Errno(syscall.ENOTEMPTY)
+	}
+	return nil
+}
+
+// File is a file of the filesystem
+type File struct {
+	fs   *FS
+	path string
+	size uint64
+}
+
+// Attr describes the file
+func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0644
+	a.Size = f.size
+	return nil
+}
+
+// Open retrieves the contents of the file, which GoDFS only stores whole
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	h := &Handle{file: f}
+	if req.Flags&fuse.OpenTruncate != 0 {
+		h.dirty = true
+		return h, nil
+	}
+
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+	if f.fs.created[f.path] {
+		return h, nil
+	}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	err := client.RetrieveToWriter(w, f.path)
+	if err != nil {
+		return nil, fuse.Errno(syscall.EIO)
+	}
+	h.data = buf.Bytes()
+	return h, nil
+}
+
+// Setattr handles truncation of the file
+func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if req.Valid.Size() {
+		f.size = req.Size
+	}
+	resp.Attr.Mode = 0644
+	resp.Attr.Size = f.size
+	return nil
+}
+
+// Handle buffers the contents of an open file
+type Handle struct {
+	file  *File
+	data  []byte
+	dirty bool // data must be written back on flush
+}
+
+// Read reads from the buffered contents
+func (h *Handle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	if req.Offset >= int64(len(h.data)) {
+		return nil
+	}
+	end := req.Offset + int64(req.Size)
+	if end > int64(len(h.data)) {
+		end = int64(len(h.data))
+	}
+	resp.Data = h.data[req.Offset:end]
+	return nil
+}
+
+// Write writes to the buffered contents
+func (h *Handle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	end := req.Offset + int64(len(req.Data))
+	if end > int64(len(h.data)) {
+		data := make([]byte, end)
+		copy(data, h.data)
+		h.data = data
+	}
+	copy(h.data[req.Offset:], req.Data)
+	h.dirty = true
+	h.file.size = uint64(len(h.data))
+	resp.Size = len(req.Data)
+	return nil
+}
+
+// Flush replaces the remote file with the buffered contents
+func (h *Handle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	if !h.dirty || len(h.data) == 0 {
+		return nil
+	}
+
+	f := h.file.fs
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// files are written whole, so remove the previous version first
+	client.Delete(h.file.path, false, true)
+	err := client.DistributeBlocksFromReader(bytes.NewReader(h.data), int64(len(h.data)), h.file.path)
+	if err != nil {
+		return fuse.Errno(syscall.EIO)
+	}
+	delete(f.created, h.file.path)
+	h.dirty = false
+	return nil
+}
+
+// Release flushes the contents on the last close of the handle
+func (h *Handle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return h.Flush(ctx, nil)
+}
+
+// check the operations each node supports
+var (
+	_ fs.NodeStringLookuper = (*Dir)(nil)
+	_ fs.HandleReadDirAller = (*Dir)(nil)
+	_ fs.NodeMkdirer        = (*Dir)(nil)
+	_ fs.NodeCreater        = (*Dir)(nil)
+	_ fs.NodeRemover        = (*Dir)(nil)
+	_ fs.NodeOpener         = (*File)(nil)
+	_ fs.NodeSetattrer      = (*File)(nil)
+	_ fs.HandleReader       = (*Handle)(nil)
+	_ fs.HandleWriter       = (*Handle)(nil)
+	_ fs.HandleFlusher      = (*Handle)(nil)
+	_ fs.HandleReleaser     = (*Handle)(nil)
+)
@@ -0,0 +1,14 @@
+//go:build !fuse
+
+// Package fusefs mounts GoDFS as a local filesystem using FUSE
+package fusefs
+
+import (
+	"errors"
+)
+
+// Mount reports that FUSE support was not built in. Build with -tags fuse
+// to enable it.
+func Mount(mountpoint string) error {
+	return errors.New("godfs was built without FUSE support, rebuild with -tags fuse")
+}
@@ -1,21 +1,35 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/sjarvie/godfs/client"
 	"github.com/sjarvie/godfs/datanode"
 	"github.com/sjarvie/godfs/namenode"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// time allowed for the namenode to drain and save its metadata
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 
-	if !(len(os.Args) == 3 && (os.Args[1] == "namenode" || os.Args[1] == "client" || os.Args[1] == "datanode")) {
-		fmt.Println("Invalid command, usage : ")
-		fmt.Println(" \t godfs namenode [location of namenode configuration file] ")
-		fmt.Println(" \t godfs datanode [location of datanode configuration file] ")
-		fmt.Println(" \t godfs client [location of client configuration file] ")
+	if len(os.Args) > 1 {
+		if _, ok := commands[os.Args[1]]; ok {
+			err := runCommand(os.Args[1], os.Args[2:])
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
 
+	if !(len(os.Args) == 3 && (os.Args[1] == "namenode" || os.Args[1] == "client" || os.Args[1] == "datanode")) {
+		printUsage()
 		os.Exit(1)
 	}
 
@@ -24,6 +38,19 @@ func main() {
 
 	switch cmd {
 	case "namenode":
+		// shut down gracefully on SIGTERM or interrupt
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+		go func() {
+			sig := <-sigs
+			fmt.Println("Received ", sig, ", shutting down")
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			err := namenode.Shutdown(ctx)
+			if err != nil {
+				fmt.Println("Shutdown error ", err)
+			}
+		}()
 		namenode.Run(configpath)
 	case "datanode":
 		datanode.Run(configpath)
@@ -0,0 +1,82 @@
+package minicluster
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/sjarvie/godfs/client"
+	"io"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+
+	c, err := Start(2, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer c.Close()
+	if err := c.Connect(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// small files spanning Blocks of the archive are packed one after another
+	files := map[string][]byte{
+		"/small/a.txt":     bytes.Repeat([]byte("a"), 3000),
+		"/small/b.txt":     []byte("b"),
+		"/small/sub/c.txt": bytes.Repeat([]byte("c"), 9000),
+	}
+	for name, data := range files {
+		if err := client.DistributeBlocksFromReader(bytes.NewReader(data), int64(len(data)), name); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if err := client.CreateArchive("/small", "/small.har"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := client.Delete("/small", true, true); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	st, err := client.Stat("/small.har")
+	if err != nil || !st.IsDir || st.Archive != "/small.har" || st.Size != 12001 {
+		t.Fatalf("Archive described as %+v %v", st, err)
+	}
+	list, err := client.ListDir("/small.har", true)
+	if err != nil || len(list) != 4 {
+		t.Errorf("Archive listed as %+v %v", list, err)
+	}
+
+	// packed files read as they were, whole and at an offset
+	for name, data := range files {
+		packed := "/small.har" + name[len("/small"):]
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		if err := client.RetrieveToWriter(w, packed); err != nil || !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("Read %d bytes of %s, expected %d: %v", out.Len(), packed, len(data), err)
+		}
+	}
+	f, err := client.Open("/small.har/sub/c.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	part := make([]byte, 10)
+	if n, err := f.ReadAt(part, 8995); n != 5 || err != io.EOF || string(part[:5]) != "ccccc" {
+		t.Errorf("Read %q at the end of a packed file: %v", part[:n], err)
+	}
+	f.Close()
+
+	// archives are read-only, and extracted as files of their own
+	if err := client.Delete("/small.har/b.txt", false, true); err == nil {
+		t.Errorf("Deleted a packed file")
+	}
+	if err := client.ExtractArchive("/small.har", "/out"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	for name, data := range files {
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		if err := client.RetrieveToWriter(w, "/out"+name[len("/small"):]); err != nil || !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("Extracted %s differs: %v", name, err)
+		}
+	}
+}
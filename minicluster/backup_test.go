@@ -0,0 +1,119 @@
+package minicluster
+
+import (
+	"bytes"
+	"github.com/sjarvie/godfs/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+
+	c, err := Start(2, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer c.Close()
+	if err := c.Connect(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	tmp, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(tmp)
+	full := "file://" + filepath.ToSlash(filepath.Join(tmp, "full.tar"))
+	incremental := "file://" + filepath.ToSlash(filepath.Join(tmp, "incremental.tar"))
+
+	files := map[string][]byte{
+		"/data/a.txt":     bytes.Repeat([]byte("a"), 3000),
+		"/data/b.txt":     []byte("b"),
+		"/data/old/c.txt": bytes.Repeat([]byte("c"), 9000),
+	}
+	for name, data := range files {
+		if err := c.WriteFile(name, data); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if err := client.SetXAttr("/data/a.txt", "user.owner", []byte("ops")); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := client.CreateSymlink("/data/a.txt", "/data/link", false); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	base, err := client.Backup("/data", full, "", nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// the incremental backup holds only the data of the files written since
+	// the full one, and the renamed file's new path. Moving the only file out
+	// of /data/old deletes it.
+	if err := client.Rename("/data/old/c.txt", "/data/c.txt"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := client.Delete("/data/b.txt", true, true); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := c.WriteFile("/data/b.txt", []byte("bb")); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := c.WriteFile("/data/new.txt", []byte("new")); err != nil {
+		t.Fatalf("%s", err)
+	}
+	var backedUp []string
+	next, err := client.Backup("/data", incremental, base, func(p client.CopyProgress) {
+		backedUp = append(backedUp, p.Path)
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(backedUp) != 2 {
+		t.Errorf("Incremental backup held %v, expected /b.txt and /new.txt", backedUp)
+	}
+
+	if err := client.Restore(incremental, "/restored", nil); err == nil {
+		t.Errorf("Incremental backup restored without its base")
+	}
+	if err := client.Restore(full, "/restored", nil); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := client.Restore(full, "/restored", nil); err == nil {
+		t.Errorf("Full backup restored over a directory which is not empty")
+	}
+	data, err := c.ReadFile("/restored/old/c.txt")
+	if err != nil || !bytes.Equal(data, files["/data/old/c.txt"]) {
+		t.Errorf("Full backup restored %d bytes %v", len(data), err)
+	}
+	if err := client.Restore(incremental, "/restored", nil); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	want := map[string][]byte{
+		"/restored/a.txt":   files["/data/a.txt"],
+		"/restored/b.txt":   []byte("bb"),
+		"/restored/new.txt": []byte("new"),
+		"/restored/c.txt":   files["/data/old/c.txt"],
+	}
+	for name, expected := range want {
+		data, err := c.ReadFile(name)
+		if err != nil || !bytes.Equal(data, expected) {
+			t.Errorf("Read %d bytes of %s, expected %d %v", len(data), name, len(expected), err)
+		}
+	}
+	if _, err := client.Stat("/restored/old"); err == nil {
+		t.Errorf("Directory deleted since the full backup was restored")
+	}
+	if target, err := client.Readlink("/restored/link"); err != nil || target != "/restored/a.txt" {
+		t.Errorf("Link restored to %q %v", target, err)
+	}
+	if value, err := client.GetXAttr("/restored/a.txt", "user.owner"); err != nil || string(value) != "ops" {
+		t.Errorf("Extended attribute restored as %q %v", value, err)
+	}
+	if held, err := client.GetXAttr("/restored", "user.backup.snapshot"); err != nil || string(held) != next {
+		t.Errorf("Restored directory holds snapshot %q %v, expected %s", held, err, next)
+	}
+}
@@ -0,0 +1,44 @@
+package minicluster
+
+import (
+	"bytes"
+	"github.com/sjarvie/godfs/client"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+
+	c, err := Start(2, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer c.Close()
+	if err := c.Connect(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	data := bytes.Repeat([]byte("checksum "), 2000)
+	if err := client.DistributeBlocksFromReader(bytes.NewReader(data), int64(len(data)), "/sums/data"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	sum, err := client.Checksum("/sums/data")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if sum.Size != int64(len(data)) || sum.NumBlocks < 2 || sum.BlockSize == 0 {
+		t.Fatalf("Expected the checksum of %d bytes in several Blocks, got %+v", len(data), sum)
+	}
+
+	// a local copy cut the same way has the same checksum, a changed one not
+	local, err := client.ChecksumReader(bytes.NewReader(data), sum.BlockSize)
+	if err != nil || local != sum {
+		t.Errorf("Local checksum %+v differs from %+v %v", local, sum, err)
+	}
+	data[len(data)-1] ^= 1
+	if local, _ := client.ChecksumReader(bytes.NewReader(data), sum.BlockSize); local.Sum == sum.Sum {
+		t.Errorf("Changed copy has the same checksum")
+	}
+	if _, err := client.Checksum("/sums/missing"); err == nil {
+		t.Errorf("Missing file had a checksum")
+	}
+}
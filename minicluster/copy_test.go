@@ -0,0 +1,64 @@
+package minicluster
+
+import (
+	"bytes"
+	"github.com/sjarvie/godfs/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+
+	c, err := Start(2, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer c.Close()
+	if err := c.Connect(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// a local tree is copied into the cluster and back out again
+	src, dst := t.TempDir(), t.TempDir()
+	files := map[string][]byte{
+		"a.txt":       bytes.Repeat([]byte("a"), 5000),
+		"sub/b.txt":   []byte("b"),
+		"sub/c/d.txt": bytes.Repeat([]byte("d"), 9000),
+	}
+	for name, data := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(src, name)), 0755)
+		ioutil.WriteFile(filepath.Join(src, name), data, 0644)
+	}
+	var last client.CopyProgress
+	err = client.Copy("file://"+src, "/copy", func(p client.CopyProgress) { last = p })
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if last.Files != 3 || last.TotalFiles != 3 || last.Bytes != 14001 || last.TotalBytes != 14001 {
+		t.Errorf("Expected 3 files of 14001 bytes copied, got %+v", last)
+	}
+	if err := client.Copy("/copy", "file://"+dst+"/out", nil); err != nil {
+		t.Fatalf("%s", err)
+	}
+	for name, data := range files {
+		read, err := ioutil.ReadFile(filepath.Join(dst, "out", name))
+		if err != nil || !bytes.Equal(read, data) {
+			t.Errorf("Copied %s differs: %v", name, err)
+		}
+	}
+
+	// getmerge joins the files of a directory in order
+	merged := filepath.Join(dst, "merged")
+	if err := client.GetMerge("/copy/sub", merged); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if read, _ := ioutil.ReadFile(merged); string(read) != "b" {
+		t.Errorf("Expected only the file directly in the directory, got %d bytes", len(read))
+	}
+
+	if err := client.Copy("relative", "/copy", nil); err == nil {
+		t.Errorf("Expected an error copying a relative path")
+	}
+}
@@ -0,0 +1,246 @@
+package minicluster
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/sjarvie/godfs/namenode"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// interval between the heartbeats of a Datanode
+const heartbeatInterval = 100 * time.Millisecond
+
+// blockKey identifies a Block stored by a Datanode
+type blockKey struct {
+	Filename string
+	BlockNum int
+}
+
+// Datanode is an in-memory datanode speaking the namenode's protocol over
+// TCP. Its Blocks survive a Kill, so a restarted Datanode reports them again.
+type Datanode struct {
+	ID string
+
+	address string // of the namenode
+	lock    sync.Mutex
+	blocks  map[blockKey]namenode.Block
+	conn    net.Conn
+	encoder *json.Encoder
+	report  int64         // ID of the last full report sent
+	applied int64         // ID of the last full report the namenode applied
+	stop    chan struct{} // closed by kill
+	done    chan struct{} // closed once the connection is closed
+}
+
+// newDatanode returns a stopped Datanode of the namenode at address
+func newDatanode(id, address string) *Datanode {
+	return &Datanode{ID: id, address: address, blocks: make(map[blockKey]namenode.Block)}
+}
+
+// start connects to the namenode, handshakes, sends a full report and
+// starts heartbeating
+func (d *Datanode) start() error {
+	conn, err := net.Dial("tcp", d.address)
+	if err != nil {
+		return err
+	}
+	encoder, decoder := json.NewEncoder(conn), json.NewDecoder(conn)
+	own := namenode.Hello{Version: 2, MinVersion: 2, Software: "minicluster", Features: []string{"capacity"}}
+	err = encoder.Encode(namenode.Packet{SRC: d.ID, DST: "NN", CMD: namenode.HELLO, Hello: &own})
+	if err == nil {
+		var r namenode.Packet
+		err = decoder.Decode(&r)
+		if err == nil && r.CMD != namenode.HELLO {
+			err = errors.New("Namenode refused datanode " + d.ID + ": " + r.Message)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	d.lock.Lock()
+	d.conn, d.encoder = conn, encoder
+	d.stop, d.done = stop, done
+	d.lock.Unlock()
+	d.send(d.fullReport())
+	go d.heartbeat(stop)
+	go d.serve(decoder, done)
+	return nil
+}
+
+// kill closes the connection, as a crashed datanode would
+func (d *Datanode) kill() {
+	d.lock.Lock()
+	if d.conn == nil {
+		d.lock.Unlock()
+		return
+	}
+	close(d.stop)
+	d.conn.Close()
+	d.conn = nil
+	done := d.done
+	d.lock.Unlock()
+	<-done
+}
+
+// send encodes p on the connection, if there is one
+func (d *Datanode) send(p namenode.Packet) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.conn == nil {
+		return
+	}
+	p.SRC = d.ID
+	d.encoder.Encode(p)
+}
+
+// heartbeat sends a heartbeat every heartbeatInterval until stop is closed
+func (d *Datanode) heartbeat(stop chan struct{}) {
+	tick := time.NewTicker(heartbeatInterval)
+	defer tick.Stop()
+	for {
+		d.lock.Lock()
+		report := d.report
+		d.lock.Unlock()
+		d.send(namenode.Packet{DST: "NN", CMD: namenode.HB, ReportID: report})
+
+		select {
+		case <-tick.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// serve answers the packets of the namenode until the connection is closed,
+// then closes done
+func (d *Datanode) serve(decoder *json.Decoder, done chan struct{}) {
+	defer close(done)
+	for {
+		var p namenode.Packet
+		if err := decoder.Decode(&p); err != nil {
+			return
+		}
+		r := namenode.Packet{DST: p.SRC, RequestID: p.RequestID}
+
+		switch p.CMD {
+		case namenode.ACK:
+			if p.ReportID != 0 {
+				d.lock.Lock()
+				d.applied = p.ReportID
+				d.lock.Unlock()
+			}
+			continue
+		case namenode.LIST:
+			r = d.fullReport()
+		case namenode.BLOCK:
+			d.lock.Lock()
+			d.blocks[blockKey{p.Data.Header.Filename, p.Data.Header.BlockNum}] = p.Data
+			d.lock.Unlock()
+			r.CMD = namenode.BLOCKACK
+			r.Headers = []namenode.BlockHeader{p.Data.Header}
+		case namenode.RETRIEVEBLOCK:
+			if len(p.Headers) == 0 {
+				continue
+			}
+			h := p.Headers[0]
+			d.lock.Lock()
+			b, ok := d.blocks[blockKey{h.Filename, h.BlockNum}]
+			d.lock.Unlock()
+			if !ok {
+				r.CMD = namenode.ERROR
+				r.Message = "Block not found " + h.Filename
+				break
+			}
+			r.CMD = namenode.BLOCK
+			r.Data = b
+		case namenode.CHECKSUM:
+			if len(p.Headers) == 0 {
+				continue
+			}
+			h := p.Headers[0]
+			d.lock.Lock()
+			b, ok := d.blocks[blockKey{h.Filename, h.BlockNum}]
+			d.lock.Unlock()
+			// a missing Block is answered without its header
+			r.CMD = namenode.CHECKSUMACK
+			if !ok {
+				r.Message = "Block not found " + h.Filename
+				break
+			}
+			r.Headers = p.Headers
+			r.Message = strconv.FormatUint(uint64(crc32.ChecksumIEEE(b.Data)), 10)
+		case namenode.INVALIDATE:
+			d.lock.Lock()
+			for _, h := range p.Headers {
+				delete(d.blocks, blockKey{h.Filename, h.BlockNum})
+			}
+			d.lock.Unlock()
+			r.CMD = namenode.INVALIDATEACK
+			r.Headers = p.Headers
+		case namenode.RENAME:
+			d.lock.Lock()
+			for i, h := range p.Headers {
+				if i >= len(p.Renamed) {
+					break
+				}
+				k := blockKey{h.Filename, h.BlockNum}
+				if b, ok := d.blocks[k]; ok {
+					delete(d.blocks, k)
+					b.Header = p.Renamed[i]
+					// a Block of a truncated file is trimmed
+					if b.Header.Size < len(b.Data) {
+						b.Data = b.Data[:b.Header.Size]
+					}
+					d.blocks[blockKey{b.Header.Filename, b.Header.BlockNum}] = b
+				}
+			}
+			d.lock.Unlock()
+			r.CMD = namenode.RENAMEACK
+			r.Headers = p.Headers
+			r.Renamed = p.Renamed
+		default:
+			continue
+		}
+		d.send(r)
+	}
+}
+
+// fullReport lists every stored Block under a new report ID
+func (d *Datanode) fullReport() namenode.Packet {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.report++
+	r := namenode.Packet{DST: "NN", CMD: namenode.LIST, ReportID: d.report}
+	r.Headers = make([]namenode.BlockHeader, 0, len(d.blocks))
+	for _, b := range d.blocks {
+		h := b.Header
+		h.DatanodeID = d.ID
+		r.Headers = append(r.Headers, h)
+	}
+	return r
+}
+
+// listed reports whether the namenode applied the last full report
+func (d *Datanode) listed() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.report > 0 && d.applied == d.report
+}
+
+// Blocks returns the headers of the Blocks the Datanode stores
+func (d *Datanode) Blocks() []namenode.BlockHeader {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	headers := make([]namenode.BlockHeader, 0, len(d.blocks))
+	for _, b := range d.blocks {
+		headers = append(headers, b.Header)
+	}
+	return headers
+}
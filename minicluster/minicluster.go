@@ -0,0 +1,274 @@
+// Package minicluster runs a namenode and in-memory datanodes in the test
+// process, so features spanning several nodes can be tested end to end. The
+// client package keeps a single connection, so only one Cluster at a time can
+// be written to and read from.
+package minicluster
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"github.com/sjarvie/godfs/client"
+	"github.com/sjarvie/godfs/namenode"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how long helpers wait for the namespace to reach the expected state
+const defaultTimeout = 10 * time.Second
+
+// Cluster is a namenode listening on an ephemeral port of the loopback
+// interface, with its datanodes
+type Cluster struct {
+	NameNode *namenode.NameNode
+	Addr     string // address of the namenode
+
+	dir       string
+	lock      sync.Mutex
+	datanodes map[string]*Datanode
+	connected bool // whether the client is connected to the namenode
+}
+
+// Start starts a namenode configured with options, which override the
+// defaults of a 4096 byte block size and a replication factor of 1, and n
+// datanodes named DN1 to DNn. It returns once the datanodes have reported.
+func Start(n int, options map[string]string) (*Cluster, error) {
+	dir, err := ioutil.TempDir("", "minicluster")
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{dir: dir, datanodes: make(map[string]*Datanode)}
+	config := map[string]string{
+		"namenodeid":   "NN",
+		"sizeofblock":  "4096",
+		"replication":  "1",
+		"metadatafile": filepath.Join(dir, "metadata.json"),
+		"loglevel":     "warn",
+	}
+	for k, v := range options {
+		config[k] = v
+	}
+	err = c.startNamenode(config)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	for i := 1; i <= n; i++ {
+		err = c.StartDatanode("DN" + strconv.Itoa(i))
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// startNamenode writes config to the cluster's directory and serves a
+// namenode configured by it
+func (c *Cluster) startNamenode(config map[string]string) error {
+	var list namenode.ConfigOptionList
+	for k, v := range config {
+		list.ConfigOptions = append(list.ConfigOptions, namenode.ConfigOption{Key: k, Value: v})
+	}
+	sort.Slice(list.ConfigOptions, func(i, j int) bool { return list.ConfigOptions[i].Key < list.ConfigOptions[j].Key })
+	data, err := xml.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(c.dir, "namenode.xml")
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	nn := namenode.New()
+	err = nn.ParseConfigXML(path)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	c.NameNode = nn
+	c.Addr = l.Addr().String()
+	go nn.Serve(l)
+	return nil
+}
+
+// Close stops the datanodes and shuts the namenode down
+func (c *Cluster) Close() error {
+	c.lock.Lock()
+	for _, d := range c.datanodes {
+		d.kill()
+	}
+	c.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	err := c.NameNode.Shutdown(ctx)
+	os.RemoveAll(c.dir)
+	return err
+}
+
+// StartDatanode adds a datanode to the cluster, and returns once the namenode
+// has its block report
+func (c *Cluster) StartDatanode(id string) error {
+	c.lock.Lock()
+	if _, ok := c.datanodes[id]; ok {
+		c.lock.Unlock()
+		return errors.New("Datanode " + id + " already exists")
+	}
+	d := newDatanode(id, c.Addr)
+	c.datanodes[id] = d
+	c.lock.Unlock()
+
+	err := d.start()
+	if err != nil {
+		return err
+	}
+	return c.waitListed(id)
+}
+
+// Datanode returns the datanode id, or nil if the cluster has none
+func (c *Cluster) Datanode(id string) *Datanode {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.datanodes[id]
+}
+
+// KillDatanode closes the connection of the datanode id, as if it had
+// crashed. It keeps its Blocks.
+func (c *Cluster) KillDatanode(id string) error {
+	d := c.Datanode(id)
+	if d == nil {
+		return errors.New("Unknown datanode " + id)
+	}
+	d.kill()
+	return nil
+}
+
+// RestartDatanode reconnects a killed datanode, which reports the Blocks it
+// kept, and returns once the namenode has its report
+func (c *Cluster) RestartDatanode(id string) error {
+	d := c.Datanode(id)
+	if d == nil {
+		return errors.New("Unknown datanode " + id)
+	}
+	d.kill()
+	err := d.start()
+	if err != nil {
+		return err
+	}
+	return c.waitListed(id)
+}
+
+// waitListed waits for the namenode to apply a full report the datanode id
+// sent since it connected
+func (c *Cluster) waitListed(id string) error {
+	d := c.Datanode(id)
+	return c.wait(d.listed, "Datanode "+id+" did not register")
+}
+
+// wait polls done until it returns true, or returns an error with message
+// once defaultTimeout has passed
+func (c *Cluster) wait(done func() bool, message string) error {
+	deadline := time.Now().Add(defaultTimeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			return errors.New(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// Connect connects the client package to the namenode, if it is not yet
+func (c *Cluster) Connect() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.connected {
+		return nil
+	}
+	err := client.Connect(c.Addr)
+	if err != nil {
+		return err
+	}
+	c.connected = true
+	return nil
+}
+
+// WriteFile stores data at path through the client, and returns once each
+// of its Blocks has a replica
+func (c *Cluster) WriteFile(path string, data []byte) error {
+	err := c.Connect()
+	if err != nil {
+		return err
+	}
+	err = client.DistributeBlocksFromReader(bytes.NewReader(data), int64(len(data)), path)
+	if err != nil {
+		return err
+	}
+	return c.waitBlocks(path, func(replicas int) bool { return replicas > 0 })
+}
+
+// ReadFile retrieves the contents of the file at path through the client
+func (c *Cluster) ReadFile(path string) ([]byte, error) {
+	err := c.Connect()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	err = client.RetrieveToWriter(w, path)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Flush()
+	return buf.Bytes(), err
+}
+
+// Replicas returns the datanodes the namenode places each Block of the file
+// at path on, by block number
+func (c *Cluster) Replicas(path string) map[int][]string {
+	replicas := make(map[int][]string)
+	blocks, _ := c.NameNode.FileBlocks(path)
+	for num, headers := range blocks {
+		for _, h := range headers {
+			replicas[num] = append(replicas[num], h.DatanodeID)
+		}
+		sort.Strings(replicas[num])
+	}
+	return replicas
+}
+
+// WaitReplicas waits until every Block of the file at path has exactly n
+// replicas
+func (c *Cluster) WaitReplicas(path string, n int) error {
+	return c.waitBlocks(path, func(replicas int) bool { return replicas == n })
+}
+
+// waitBlocks waits until every Block of the file at path is known, with a
+// number of replicas accepted by ok
+func (c *Cluster) waitBlocks(path string, ok func(replicas int) bool) error {
+	return c.wait(func() bool {
+		blocks, _ := c.NameNode.FileBlocks(path)
+		if len(blocks) == 0 {
+			return false
+		}
+		for _, headers := range blocks {
+			if len(headers) == 0 || len(blocks) != headers[0].NumBlocks || !ok(len(headers)) {
+				return false
+			}
+		}
+		return true
+	}, "Blocks of "+path+" did not reach the expected replicas")
+}
@@ -0,0 +1,85 @@
+package minicluster
+
+import (
+	"bytes"
+	"github.com/sjarvie/godfs/client"
+	"testing"
+)
+
+func TestMiniCluster(t *testing.T) {
+
+	c, err := Start(3, map[string]string{"replication": "2", "replicationinterval": "1"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer c.Close()
+
+	data := bytes.Repeat([]byte("minicluster "), 1000)
+	if err := c.WriteFile("/test/data", data); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := c.WaitReplicas("/test/data", 2); err != nil {
+		t.Fatalf("%s: %v", err, c.Replicas("/test/data"))
+	}
+	read, err := c.ReadFile("/test/data")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatalf("Read %d bytes back, wrote %d", len(read), len(data))
+	}
+
+	// a restarted datanode reports the Blocks it kept
+	replicas := c.Replicas("/test/data")
+	id := replicas[0][0]
+	stored := len(c.Datanode(id).Blocks())
+	if err := c.KillDatanode(id); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := c.RestartDatanode(id); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(c.Datanode(id).Blocks()) != stored || len(c.Replicas("/test/data")[0]) != 2 {
+		t.Errorf("Expected the replicas of %s kept, got %v", id, c.Replicas("/test/data"))
+	}
+
+	// raising the replication copies each Block to the third datanode
+	if _, err := client.SetReplication("/test/data", 3); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := c.WaitReplicas("/test/data", 3); err != nil {
+		t.Fatalf("%s: %v", err, c.Replicas("/test/data"))
+	}
+	for _, id := range []string{"DN1", "DN2", "DN3"} {
+		if n := len(c.Datanode(id).Blocks()); n != len(replicas) {
+			t.Errorf("Expected %d Blocks on %s, got %d", len(replicas), id, n)
+		}
+	}
+
+	// truncating drops the last Block and trims the one holding the new end
+	if err := client.Truncate("/test/data", 5000); err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = c.wait(func() bool {
+		for _, id := range []string{"DN1", "DN2", "DN3"} {
+			size := 0
+			for _, h := range c.Datanode(id).Blocks() {
+				size += h.Size
+			}
+			if size != 5000 {
+				return false
+			}
+		}
+		return true
+	}, "Datanodes did not truncate their Blocks")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	read, err = c.ReadFile("/test/data")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !bytes.Equal(read, data[:5000]) {
+		t.Errorf("Read %d bytes of the truncated file, expected 5000", len(read))
+	}
+}
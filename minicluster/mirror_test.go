@@ -0,0 +1,181 @@
+package minicluster
+
+import (
+	"encoding/json"
+	"github.com/sjarvie/godfs/client"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sourceCluster stands in for the namenode of another cluster, serving its
+// edit log and its files over WebHDFS
+type sourceCluster struct {
+	lock   sync.Mutex
+	edits  []string
+	head   int64
+	gone   bool
+	files  map[string]string
+	mtimes map[string]int64
+	dirs   map[string][]string
+}
+
+func (s *sourceCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if r.URL.Path == "/editlog" {
+		w.Header().Set("X-Edit-Seq", strconv.FormatInt(s.head, 10))
+		if s.gone {
+			http.Error(w, "dropped", http.StatusGone)
+			return
+		}
+		since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+		for i, e := range s.edits {
+			if i+1 > since {
+				w.Write([]byte(e + "\n"))
+			}
+		}
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/webhdfs/v1")
+	status := func(p string) map[string]interface{} {
+		if _, ok := s.dirs[p]; ok {
+			return map[string]interface{}{"pathSuffix": filepath.Base(p), "type": "DIRECTORY"}
+		}
+		return map[string]interface{}{"pathSuffix": filepath.Base(p), "type": "FILE", "length": len(s.files[p]), "modificationTime": s.mtimes[p]}
+	}
+	_, isFile := s.files[p]
+	_, isDir := s.dirs[p]
+	switch r.URL.Query().Get("op") {
+	case "OPEN":
+		w.Write([]byte(s.files[p]))
+	case "GETFILESTATUS":
+		if !isFile && !isDir {
+			http.Error(w, `{"RemoteException":{"message":"No such file"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"FileStatus": status(p)})
+	case "LISTSTATUS":
+		list := make([]map[string]interface{}, 0)
+		for _, c := range s.dirs[p] {
+			list = append(list, status(p+"/"+c))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"FileStatuses": map[string]interface{}{"FileStatus": list}})
+	}
+}
+
+// log appends edits of the requests reqs, as JSON packets, or of replicas
+// added to paths given as +path
+func (s *sourceCluster) log(reqs ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, r := range reqs {
+		s.head++
+		seq := strconv.FormatInt(s.head, 10)
+		if strings.HasPrefix(r, "+") {
+			s.edits = append(s.edits, `{"Seq":`+seq+`,"Added":{"Filename":"`+r[1:]+`"}}`)
+		} else {
+			s.edits = append(s.edits, `{"Seq":`+seq+`,"Request":`+r+`}`)
+		}
+	}
+}
+
+func TestMirror(t *testing.T) {
+
+	c, err := Start(1, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer c.Close()
+	if err := c.Connect(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	src := &sourceCluster{
+		files:  map[string]string{"/src/d/b": "hello"},
+		mtimes: map[string]int64{"/src/d/b": 1000},
+		dirs:   map[string][]string{"/src": {"d"}, "/src/d": {"b"}},
+	}
+	server := httptest.NewServer(src)
+	defer server.Close()
+	// a file written and renamed before the mirror reads it, and a directory
+	// outside the mirrored one
+	src.log(
+		`{"CMD":`+strconv.Itoa(client.MKDIR)+`,"Flags":`+strconv.Itoa(client.PARENTS)+`,"Headers":[{"Filename":"/src/d"}]}`,
+		`{"CMD":`+strconv.Itoa(client.LEASE)+`,"Headers":[{"Filename":"/src/d/a"}]}`,
+		"+/src/d/a",
+		`{"CMD":`+strconv.Itoa(client.RELEASE)+`,"Headers":[{"Filename":"/src/d/a"}]}`,
+		`{"CMD":`+strconv.Itoa(client.MKDIR)+`,"Headers":[{"Filename":"/other"}]}`,
+		`{"CMD":`+strconv.Itoa(client.RENAME)+`,"Headers":[{"Filename":"/src/d/a"}],"Renamed":[{"Filename":"/src/d/b"}]}`,
+	)
+
+	state := filepath.Join(t.TempDir(), "mirror.state")
+	m, err := client.NewMirror(strings.TrimPrefix(server.URL, "http://"), "/src", "/dr", state)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	m.Conflict = client.ConflictBoth
+	if err := m.Sync(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if data, err := c.ReadFile("/dr/d/b"); err != nil || string(data) != "hello" {
+		t.Errorf("Renamed file not mirrored %q %v", data, err)
+	}
+	if st, err := client.Stat("/dr/d/b"); err != nil || st.ModTime != 1000 {
+		t.Errorf("Mirrored file without the source's time %+v %v", st, err)
+	}
+	if _, err := client.Stat("/other"); err == nil {
+		t.Errorf("Directory outside the mirrored one created")
+	}
+	if st := m.Stats(); st.Seq != 6 || st.SourceSeq != 6 || st.Files != 1 || st.Lag != 0 {
+		t.Errorf("Wrong stats %+v", st)
+	}
+	if data, _ := ioutil.ReadFile(state); strings.TrimSpace(string(data)) != "6" {
+		t.Errorf("Wrong state saved %q", data)
+	}
+
+	// a file changed on both clusters keeps both versions
+	if err := c.WriteFile("/dr/d/b", []byte("local")); err != nil {
+		t.Fatalf("%s", err)
+	}
+	src.lock.Lock()
+	src.files["/src/d/b"], src.mtimes["/src/d/b"] = "world", 2000
+	src.lock.Unlock()
+	src.log("+/src/d/b")
+	if err := m.Sync(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if data, _ := c.ReadFile("/dr/d/b"); string(data) != "world" {
+		t.Errorf("Source's version not mirrored %q", data)
+	}
+	if data, _ := c.ReadFile("/dr/d/b.conflict"); string(data) != "local" {
+		t.Errorf("Destination's version not kept %q", data)
+	}
+	if st := m.Stats(); st.Conflicts != 1 || st.Files != 2 {
+		t.Errorf("Wrong stats %+v", st)
+	}
+
+	// a restarted mirror carries on from its state, and copies the directory
+	// again once the source dropped the edits it needs
+	src.lock.Lock()
+	src.gone, src.head = true, 20
+	src.lock.Unlock()
+	m, err = client.NewMirror(strings.TrimPrefix(server.URL, "http://"), "/src", "/dr", state)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if st := m.Stats(); st.Seq != 7 {
+		t.Errorf("State not restored %+v", st)
+	}
+	if err := m.Sync(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if st := m.Stats(); st.Seq != 20 || st.Files != 0 {
+		t.Errorf("Wrong stats after copying again %+v", st)
+	}
+}
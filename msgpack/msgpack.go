@@ -0,0 +1,589 @@
+// Package msgpack encodes the packets of GoDFS as MessagePack. Structs are
+// maps from their field names to the fields which are set, so nodes ignore
+// fields they do not know and leave missing ones zero, as with JSON.
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// Marshal returns the MessagePack encoding of v
+func Marshal(v interface{}) ([]byte, error) {
+	e := &encoder{make([]byte, 0, 256)}
+	err := e.encode(reflect.ValueOf(v))
+	return e.b, err
+}
+
+// Unmarshal decodes the MessagePack value b holds into the value v points
+// to. Values nested deeper than maxDepth, and lengths longer than the data
+// left, are refused rather than allocated.
+func Unmarshal(b []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("msgpack: Unmarshal needs a non-nil pointer")
+	}
+	d := &decoder{b: b}
+	err := d.decode(rv.Elem())
+	if err == nil && d.off != len(b) {
+		err = fmt.Errorf("msgpack: %d bytes after the value", len(b)-d.off)
+	}
+	return err
+}
+
+var errShort = errors.New("msgpack: unexpected end of data")
+
+// maxDepth is the deepest nesting of arrays and maps decoded
+const maxDepth = 10000
+
+// structFields is the exported fields of a struct type
+type structFields struct {
+	names  []string
+	fields []int          // index of each of names
+	byName map[string]int // index of each field
+}
+
+var structs sync.Map // struct types to their *structFields
+
+func structOf(t reflect.Type) *structFields {
+	if s, ok := structs.Load(t); ok {
+		return s.(*structFields)
+	}
+	s := &structFields{byName: make(map[string]int)}
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.IsExported() {
+			s.names = append(s.names, f.Name)
+			s.fields = append(s.fields, i)
+			s.byName[f.Name] = i
+		}
+	}
+	structs.Store(t, s)
+	return s
+}
+
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.b = append(e.b, 0xc3)
+		} else {
+			e.b = append(e.b, 0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.integer(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.unsigned(v.Uint())
+	case reflect.Float32:
+		e.b = append(e.b, 0xca)
+		e.b = binary.BigEndian.AppendUint32(e.b, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.b = append(e.b, 0xcb)
+		e.b = binary.BigEndian.AppendUint64(e.b, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.str(v.String())
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.b = append(e.b, 0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.b = append(e.b, 0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.bin(v.Bytes())
+			return nil
+		}
+		fallthrough
+	case reflect.Array:
+		e.header(v.Len(), 0x90, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.b = append(e.b, 0xc0)
+			return nil
+		}
+		e.header(v.Len(), 0x80, 0xde, 0xdf)
+		it := v.MapRange()
+		for it.Next() {
+			if err := e.encode(it.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(it.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		st := structOf(v.Type())
+		set := 0
+		for _, i := range st.fields {
+			if !v.Field(i).IsZero() {
+				set++
+			}
+		}
+		e.header(set, 0x80, 0xde, 0xdf)
+		for k, i := range st.fields {
+			if v.Field(i).IsZero() {
+				continue
+			}
+			e.str(st.names[k])
+			if err := e.encode(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: cannot encode a %s", v.Type())
+	}
+	return nil
+}
+
+// header writes the length of an array or map, in the fixed format up to
+// 15 items
+func (e *encoder) header(n int, fixed, short, long byte) {
+	switch {
+	case n < 16:
+		e.b = append(e.b, fixed|byte(n))
+	case n <= math.MaxUint16:
+		e.b = append(e.b, short)
+		e.b = binary.BigEndian.AppendUint16(e.b, uint16(n))
+	default:
+		e.b = append(e.b, long)
+		e.b = binary.BigEndian.AppendUint32(e.b, uint32(n))
+	}
+}
+
+func (e *encoder) integer(i int64) {
+	switch {
+	case i >= 0:
+		e.unsigned(uint64(i))
+	case i >= -32:
+		e.b = append(e.b, byte(i))
+	case i >= math.MinInt8:
+		e.b = append(e.b, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		e.b = append(e.b, 0xd1)
+		e.b = binary.BigEndian.AppendUint16(e.b, uint16(i))
+	case i >= math.MinInt32:
+		e.b = append(e.b, 0xd2)
+		e.b = binary.BigEndian.AppendUint32(e.b, uint32(i))
+	default:
+		e.b = append(e.b, 0xd3)
+		e.b = binary.BigEndian.AppendUint64(e.b, uint64(i))
+	}
+}
+
+func (e *encoder) unsigned(u uint64) {
+	switch {
+	case u < 128:
+		e.b = append(e.b, byte(u))
+	case u <= math.MaxUint8:
+		e.b = append(e.b, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		e.b = append(e.b, 0xcd)
+		e.b = binary.BigEndian.AppendUint16(e.b, uint16(u))
+	case u <= math.MaxUint32:
+		e.b = append(e.b, 0xce)
+		e.b = binary.BigEndian.AppendUint32(e.b, uint32(u))
+	default:
+		e.b = append(e.b, 0xcf)
+		e.b = binary.BigEndian.AppendUint64(e.b, u)
+	}
+}
+
+func (e *encoder) str(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		e.b = append(e.b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.b = append(e.b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.b = append(e.b, 0xda)
+		e.b = binary.BigEndian.AppendUint16(e.b, uint16(n))
+	default:
+		e.b = append(e.b, 0xdb)
+		e.b = binary.BigEndian.AppendUint32(e.b, uint32(n))
+	}
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) bin(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		e.b = append(e.b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.b = append(e.b, 0xc5)
+		e.b = binary.BigEndian.AppendUint16(e.b, uint16(n))
+	default:
+		e.b = append(e.b, 0xc6)
+		e.b = binary.BigEndian.AppendUint32(e.b, uint32(n))
+	}
+	e.b = append(e.b, b...)
+}
+
+type decoder struct {
+	b     []byte
+	off   int
+	depth int // arrays and maps the value being read is within
+}
+
+// next returns the next n bytes
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.b)-d.off < n {
+		return nil, errShort
+	}
+	b := d.b[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+// length reads a big endian length of size bytes
+func (d *decoder) length(size int) (int, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	}
+	return int(binary.BigEndian.Uint32(b)), nil
+}
+
+func (d *decoder) decode(v reflect.Value) error {
+	if d.off >= len(d.b) {
+		return errShort
+	}
+	d.depth++
+	if d.depth > maxDepth {
+		return errors.New("msgpack: nested too deeply")
+	}
+	err := d.value(v)
+	d.depth--
+	return err
+}
+
+// value decodes the next value into v
+func (d *decoder) value(v reflect.Value) error {
+	c := d.b[d.off]
+	if c == 0xc0 {
+		d.off++
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem())
+	case reflect.Bool:
+		d.off++
+		switch c {
+		case 0xc2:
+			v.SetBool(false)
+		case 0xc3:
+			v.SetBool(true)
+		default:
+			return d.mismatch(c, v)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		u, negative, err := d.integer(v)
+		if err != nil {
+			return err
+		}
+		if (!negative && u > math.MaxInt64) || v.OverflowInt(int64(u)) {
+			return fmt.Errorf("msgpack: integer overflows %s", v.Type())
+		}
+		v.SetInt(int64(u))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, negative, err := d.integer(v)
+		if err != nil {
+			return err
+		}
+		if negative || v.OverflowUint(u) {
+			return fmt.Errorf("msgpack: integer overflows %s", v.Type())
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		d.off++
+		switch c {
+		case 0xca:
+			b, err := d.next(4)
+			if err != nil {
+				return err
+			}
+			v.SetFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(b))))
+		case 0xcb:
+			b, err := d.next(8)
+			if err != nil {
+				return err
+			}
+			v.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(b)))
+		default:
+			d.off--
+			u, negative, err := d.integer(v)
+			if err != nil {
+				return err
+			}
+			if negative {
+				v.SetFloat(float64(int64(u)))
+			} else {
+				v.SetFloat(float64(u))
+			}
+		}
+	case reflect.String:
+		b, err := d.bytes(v)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.bytes(v)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+		n, err := d.header(v, 0x90, 0xdc, 0xdd)
+		if err != nil {
+			return err
+		}
+		// each item takes at least a byte, so a bad length is not allocated
+		if n > len(d.b)-d.off {
+			return errShort
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := d.decode(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		n, err := d.header(v, 0x90, 0xdc, 0xdd)
+		if err != nil {
+			return err
+		}
+		if n != v.Len() {
+			return fmt.Errorf("msgpack: %d items for a %s", n, v.Type())
+		}
+		for i := 0; i < n; i++ {
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, err := d.header(v, 0x80, 0xde, 0xdf)
+		if err != nil {
+			return err
+		}
+		if n > len(d.b)-d.off {
+			return errShort
+		}
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.decode(key); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(value); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		n, err := d.header(v, 0x80, 0xde, 0xdf)
+		if err != nil {
+			return err
+		}
+		st := structOf(v.Type())
+		for i := 0; i < n; i++ {
+			if d.off >= len(d.b) {
+				return errShort
+			}
+			name, err := d.bytes(v)
+			if err != nil {
+				return err
+			}
+			f, ok := st.byName[string(name)]
+			if !ok {
+				err = d.skip()
+			} else {
+				err = d.decode(v.Field(f))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: cannot decode into a %s", v.Type())
+	}
+	return nil
+}
+
+func (d *decoder) mismatch(c byte, v reflect.Value) error {
+	return fmt.Errorf("msgpack: cannot decode 0x%02x into a %s", c, v.Type())
+}
+
+// integer reads an integer of any width, returning its bits as a uint64
+// and whether it is negative
+func (d *decoder) integer(v reflect.Value) (uint64, bool, error) {
+	c := d.b[d.off]
+	d.off++
+	switch {
+	case c < 0x80:
+		return uint64(c), false, nil
+	case c >= 0xe0:
+		return uint64(int8(c)), true, nil
+	}
+	if c < 0xcc || c > 0xd3 {
+		return 0, false, d.mismatch(c, v)
+	}
+	size := 1 << ((c - 0xcc) % 4)
+	b, err := d.next(size)
+	if err != nil {
+		return 0, false, err
+	}
+	var u uint64
+	for _, x := range b {
+		u = u<<8 | uint64(x)
+	}
+	if c < 0xd0 {
+		return u, false, nil
+	}
+	// sign extend the narrower signed integers
+	shift := 64 - 8*size
+	i := int64(u<<shift) >> shift
+	return uint64(i), i < 0, nil
+}
+
+// bytes reads a str or bin
+func (d *decoder) bytes(v reflect.Value) ([]byte, error) {
+	c := d.b[d.off]
+	d.off++
+	var n int
+	var err error
+	switch {
+	case c&0xe0 == 0xa0:
+		n = int(c & 0x1f)
+	case c == 0xd9 || c == 0xc4:
+		n, err = d.length(1)
+	case c == 0xda || c == 0xc5:
+		n, err = d.length(2)
+	case c == 0xdb || c == 0xc6:
+		n, err = d.length(4)
+	default:
+		return nil, d.mismatch(c, v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.next(n)
+}
+
+// header reads the length of an array or map
+func (d *decoder) header(v reflect.Value, fixed, short, long byte) (int, error) {
+	c := d.b[d.off]
+	d.off++
+	switch c {
+	case short:
+		return d.length(2)
+	case long:
+		return d.length(4)
+	}
+	if c&0xf0 != fixed {
+		return 0, d.mismatch(c, v)
+	}
+	return int(c & 0x0f), nil
+}
+
+// skip passes over the next value, of a field the struct does not have
+func (d *decoder) skip() error {
+	d.depth++
+	if d.depth > maxDepth {
+		return errors.New("msgpack: nested too deeply")
+	}
+	err := d.skipValue()
+	d.depth--
+	return err
+}
+
+// skipValue passes over the next value
+func (d *decoder) skipValue() error {
+	b, err := d.next(1)
+	if err != nil {
+		return err
+	}
+	c := b[0]
+	items := 0
+	switch {
+	case c < 0x80 || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		return nil
+	case c&0xf0 == 0x80:
+		items = 2 * int(c&0x0f)
+	case c&0xf0 == 0x90:
+		items = int(c & 0x0f)
+	case c&0xe0 == 0xa0:
+		_, err = d.next(int(c & 0x1f))
+		return err
+	case c == 0xcc || c == 0xd0:
+		_, err = d.next(1)
+		return err
+	case c == 0xcd || c == 0xd1:
+		_, err = d.next(2)
+		return err
+	case c == 0xce || c == 0xd2 || c == 0xca:
+		_, err = d.next(4)
+		return err
+	case c == 0xcf || c == 0xd3 || c == 0xcb:
+		_, err = d.next(8)
+		return err
+	case c == 0xd9 || c == 0xc4 || c == 0xda || c == 0xc5 || c == 0xdb || c == 0xc6:
+		size := map[byte]int{0xd9: 1, 0xc4: 1, 0xda: 2, 0xc5: 2, 0xdb: 4, 0xc6: 4}[c]
+		n, err := d.length(size)
+		if err == nil {
+			_, err = d.next(n)
+		}
+		return err
+	case c == 0xdc || c == 0xde:
+		items, err = d.length(2)
+	case c == 0xdd || c == 0xdf:
+		items, err = d.length(4)
+	default:
+		return fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+	}
+	if err != nil {
+		return err
+	}
+	if c == 0xde || c == 0xdf {
+		items *= 2
+	}
+	for i := 0; i < items; i++ {
+		if err := d.skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type inner struct {
+	Name  string
+	Flags uint8
+}
+
+type value struct {
+	B      bool
+	I      int
+	I8     int8
+	I64    int64
+	U16    uint16
+	U64    uint64
+	F32    float32
+	F64    float64
+	S      string
+	Long   string
+	Data   []byte
+	List   []inner
+	Array  [2]int
+	Map    map[string][]byte
+	Ptr    *inner
+	Nested [][]int
+	hidden int
+}
+
+func TestRoundTrip(t *testing.T) {
+
+	v := value{B: true, I: -1 << 40, I8: -100, I64: math.MinInt64, U16: 1 << 15, U64: math.MaxUint64,
+		F32: 1.5, F64: -2.25, S: "short", Long: strings.Repeat("x", 70000), Data: []byte{0, 1, 2},
+		List: []inner{{"a", 1}, {}}, Array: [2]int{-32, 127}, Map: map[string][]byte{"k": []byte("v")},
+		Ptr: &inner{Name: "p"}, Nested: [][]int{{1}, nil, {2, 3}}}
+	b, err := Marshal(&v)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var got value
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("Expected %+v, got %+v", v, got)
+	}
+
+	// fields which are not set are left out, and unexported ones ignored
+	if b, _ := Marshal(value{hidden: 1}); !bytes.Equal(b, []byte{0x80}) {
+		t.Errorf("Expected an empty map, got %x", b)
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+
+	// a node ignores the fields of a newer node it does not know
+	type newer struct {
+		Name  string
+		Extra map[string][]int
+		More  []interface{}
+		Flags uint8
+	}
+	b, err := Marshal(newer{Name: "n", Extra: map[string][]int{"a": {1, 1 << 20}}, More: []interface{}{"s", 1.5, true, nil}, Flags: 3})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var got inner
+	if err := Unmarshal(b, &got); err != nil || got != (inner{"n", 3}) {
+		t.Errorf("Expected the known fields, got %+v %v", got, err)
+	}
+}
+
+func TestBadInput(t *testing.T) {
+
+	var v value
+	for _, b := range [][]byte{
+		{},                                 // nothing
+		{0x81, 0xa1, 'S'},                  // truncated
+		{0x81, 0xa1, 'S', 0x01},            // mismatched type
+		{0x81, 0xa2, 'I', '8', 0xcc, 0xff}, // overflows an int8
+		{0x81, 0xa3, 'U', '1', '6', 0xff},  // negative for a uint16
+		{0x81, 0xa4, 'L', 'i', 's', 't', 0xdd, 0xff, 0xff, 0xff, 0xff}, // longer than the data
+		{0x80, 0x00}, // trailing bytes
+	} {
+		if err := Unmarshal(b, &v); err == nil {
+			t.Errorf("Decoded bad input %x", b)
+		}
+	}
+
+	// nesting beyond maxDepth is refused, rather than exhausting the stack
+	deep := append([]byte{0x81, 0xa1, 'X'}, bytes.Repeat([]byte{0x91}, maxDepth+1)...)
+	if err := Unmarshal(deep, &v); err == nil {
+		t.Errorf("Decoded values nested too deeply")
+	}
+	if err := Unmarshal([]byte{0x80}, v); err == nil {
+		t.Errorf("Decoded into a value")
+	}
+}
@@ -0,0 +1,242 @@
+package namenode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// changesNamespace reports whether a client request changes the namespace,
+// which is refused in safe mode
+func changesNamespace(cmd int) bool {
+	switch cmd {
+	case DISTRIBUTE, DELETE, MKDIR, SETQUOTA, RENAME, CREATESNAPSHOT, DELETESNAPSHOT, LEASE, ERASURECODE,
+		CREATEZONE, FILEKEY, SETREP, BALANCE, DECOMMISSION, TRUNCATE, SETTIMES, SETXATTR, REMOVEXATTR,
+		CREATESYMLINK, ARCHIVE, CACHE, UNCACHE, STORAGEPOLICY, RESUME:
+		return true
+	}
+	return false
+}
+
+// authorize returns an error unless user may make administrative requests:
+// the user running the namenode and those listed in adminusers may
+func (nn *NameNode) authorize(user string) error {
+	if user != "" && (user == nn.superuser || nn.admins[user]) {
+		return nil
+	}
+	if user == "" {
+		user = "anonymous"
+	}
+	return newError(ErrUnauthorized, "Permission denied: "+user+" is not an administrator")
+}
+
+// handleAdmin answers the administrative request p in r, with the outcome in
+// the Message of an ACK
+func (nn *NameNode) handleAdmin(p Packet, r *Packet) {
+	err := nn.authorize(p.User)
+	var message string
+	if err == nil {
+		switch p.CMD {
+		case BALANCE:
+			var bandwidth int64
+			bandwidth, err = strconv.ParseInt(p.Message, 10, 64)
+			if err == nil {
+				message, err = nn.Balance(bandwidth)
+			}
+		case DECOMMISSION:
+			message, err = nn.Decommission(p.Message)
+		case REPORT:
+			message = nn.Report()
+		case GETREPORT:
+			message, err = nn.GetReport()
+		case SAFEMODE:
+			message, err = nn.SafeMode(p.Message)
+		case REFRESHNODES:
+			message, err = nn.RefreshNodes()
+		case SETBLOCKSIZE:
+			var n int
+			n, err = strconv.Atoi(p.Message)
+			if err == nil {
+				message, err = nn.SetBlockSize(n)
+			}
+		case LISTLEASES:
+			message = nn.ListLeases()
+		case RECOVERLEASE:
+			if len(p.Headers) != 1 {
+				err = ErrInvalidHeader
+				break
+			}
+			message, err = nn.RecoverLease(p.Headers[0].Filename)
+		case TRIGGERREPORT:
+			message, err = nn.TriggerBlockReport(p.Message)
+		case HASTATE:
+			message, err = nn.HAState(p.Message)
+		case SHUTDOWN:
+			message, err = nn.ShutdownDatanode(p.Message)
+		case SETBANDWIDTH:
+			var bandwidth int64
+			bandwidth, err = strconv.ParseInt(p.Message, 10, 64)
+			if err == nil {
+				message, err = nn.SetTransferBandwidth(bandwidth)
+			}
+		case TENANTS:
+			message, err = nn.Tenants(p.Message)
+		}
+	}
+	r.CMD = ACK
+	r.Message = message
+	if err != nil {
+		nn.log.Warn("Refused administrative request", "cmd", CommandName(p.CMD), "user", p.User, "err", err)
+		fail(r, err)
+	}
+}
+
+// Report describes the datanodes and the namespace, as the status page does
+func (nn *NameNode) Report() string {
+	s := nn.status()
+	var buf bytes.Buffer
+	mode := "OFF"
+	if nn.safeMode {
+		mode = "ON"
+	}
+	fmt.Fprintf(&buf, "Namenode %s, safe mode is %s\n", s.ID, mode)
+	fmt.Fprintf(&buf, "Files: %d, Blocks: %d, under replicated: %d\n", s.Files, s.Blocks, s.UnderReplicated)
+	fmt.Fprintf(&buf, "Replication: %d, block size: %d bytes\n", s.Replication, nn.sizeofblock)
+	queued := nn.needed.lengths()
+	fmt.Fprintf(&buf, "Replication queue: %d highest, %d high, %d normal priority, %d Blocks started per second\n",
+		queued[priorityHighest], queued[priorityHigh], queued[priorityNormal], nn.replicationRate)
+
+	var used int64
+	live := 0
+	for _, d := range s.Datanodes {
+		used += d.Used
+		if d.State == "online" || d.State == "decommissioning" {
+			live++
+		}
+	}
+	fmt.Fprintf(&buf, "Datanodes: %d live of %d, %d bytes used\n\n", live, len(s.Datanodes), used)
+
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tState\tLast heartbeat\tUsed\tBlocks\tRack\tFree\tVersion\tLatency\tCache")
+	for _, d := range s.Datanodes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n", d.ID, d.State, d.LastHeartbeat, d.Used, d.Blocks, d.Rack, d.Free, d.Version, d.Latency, d.Cache)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// SafeMode enters safe mode, in which clients may read but not change the
+// namespace, leaves it or reports whether it is on, as action is enter,
+// leave or get
+func (nn *NameNode) SafeMode(action string) (string, error) {
+	switch action {
+	case "enter":
+		nn.safeMode = true
+		nn.log.Warn("Entered safe mode")
+	case "leave":
+		nn.safeMode = false
+		nn.log.Warn("Left safe mode")
+	case "get", "":
+	default:
+		return "", errors.New("Safe mode action must be enter, leave or get")
+	}
+	if nn.safeMode {
+		return "Safe mode is ON", nil
+	}
+	return "Safe mode is OFF", nil
+}
+
+// RefreshNodes reloads the include and exclude files, decommissioning the
+// datanodes newly excluded, then reloads the topology file and forgets the
+// racks printed by the topology script, so datanodes moved between racks
+// are placed by their new rack
+func (nn *NameNode) RefreshNodes() (string, error) {
+	err := nn.LoadHosts()
+	if err != nil {
+		return "", err
+	}
+	nn.hostsLock.Lock()
+	message := "Any datanode may register"
+	if nn.includeHosts != nil {
+		message = strconv.Itoa(len(nn.includeHosts)) + " datanodes may register"
+	}
+	message += ", " + strconv.Itoa(len(nn.excludeHosts)) + " are excluded"
+	nn.hostsLock.Unlock()
+
+	nn.topologyLock.Lock()
+	nn.topology = make(map[string]string)
+	nn.topologyLock.Unlock()
+	if nn.topologyFile == "" {
+		return message + "\nTopology cleared", nil
+	}
+	err = nn.LoadTopology(nn.topologyFile)
+	if err != nil {
+		return "", err
+	}
+	nn.topologyLock.Lock()
+	defer nn.topologyLock.Unlock()
+	nn.placementLog.Info("Reloaded topology", "file", nn.topologyFile, "entries", len(nn.topology))
+	return message + "\nReloaded " + strconv.Itoa(len(nn.topology)) + " racks from " + nn.topologyFile, nil
+}
+
+// SetBlockSize changes the default size of Blocks, which is given to clients
+// connecting without a configured block size
+func (nn *NameNode) SetBlockSize(n int) (string, error) {
+	if n < 4096 {
+		return "", errors.New("Buffer size must be greater than or equal to 4096 bytes")
+	}
+	if int64(n) > maxBlockSize {
+		return "", errors.New("Block size must not be longer than " + strconv.FormatInt(maxBlockSize, 10) + " bytes")
+	}
+	nn.sizeofblock = n
+	nn.log.Info("Changed block size", "bytes", n)
+	return "Block size set to " + strconv.Itoa(n) + " bytes", nil
+}
+
+// ListLeases describes the leases on the files being written: their holders,
+// how long they have held them and the Blocks written so far
+func (nn *NameNode) ListLeases() string {
+	nn.leaseLock.Lock()
+	paths := make([]string, 0, len(nn.leases))
+	for path := range nn.leases {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Path\tHolder\tHeld\tRenewed\tBlocks")
+	for _, path := range paths {
+		l := nn.leases[path]
+		holder, held := l.Holder, time.Since(l.Acquired).Truncate(time.Second).String()
+		if holder == "" {
+			holder, held = "(recovered)", "-"
+		}
+		blocks, _ := nn.filemap.Get(path)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%d\n", path, holder, held, time.Since(l.Renewed).Truncate(time.Second), len(blocks))
+	}
+	nn.leaseLock.Unlock()
+	w.Flush()
+	return buf.String()
+}
+
+// TriggerBlockReport asks the datanode id, or every datanode if id is empty,
+// to send a full block report with its next heartbeat
+func (nn *NameNode) TriggerBlockReport(id string) (string, error) {
+	if id != "" {
+		dn, ok := nn.datanodemap[id]
+		if !ok {
+			return "", errors.New("Unknown datanode " + id)
+		}
+		dn.reportRequested = true
+		return "Block report requested from " + id, nil
+	}
+	for _, dn := range nn.datanodemap {
+		dn.reportRequested = true
+	}
+	return "Block report requested from " + strconv.Itoa(len(nn.datanodemap)) + " datanodes", nil
+}
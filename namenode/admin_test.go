@@ -0,0 +1,119 @@
+package namenode
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminRequests(t *testing.T) {
+
+	nn := New()
+	nn.superuser = "hdfs"
+	nn.admins["alice"] = true
+	nn.sizeofblock = 4096
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/dir/out.txt", 1, 0, 1, 0, "", 0, ""})
+
+	var r Packet
+	for _, user := range []string{"", "bob"} {
+		nn.handleAdmin(Packet{SRC: "C", CMD: SAFEMODE, Message: "enter", User: user}, &r)
+		if r.CMD != ERROR || nn.safeMode {
+			t.Errorf("Administrative request allowed for %q", user)
+		}
+	}
+	for _, user := range []string{"hdfs", "alice"} {
+		nn.handleAdmin(Packet{SRC: "C", CMD: REPORT, User: user}, &r)
+		if r.CMD != ACK || !strings.Contains(r.Message, "DN1") || !strings.Contains(r.Message, "Files: 1") {
+			t.Errorf("Wrong report for %s %v", user, r)
+		}
+	}
+
+	// safe mode refuses changes to the namespace but not reads
+	nn.handleAdmin(Packet{SRC: "C", CMD: SAFEMODE, Message: "enter", User: "alice"}, &r)
+	if r.Message != "Safe mode is ON" {
+		t.Fatalf("Safe mode not entered %v", r)
+	}
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/new"}}})
+	if nn.lookup("/new") != nil {
+		t.Errorf("Directory created in safe mode")
+	}
+	if !changesNamespace(DISTRIBUTE) || changesNamespace(STAT) || changesNamespace(GETHEADERS) {
+		t.Errorf("Wrong requests refused in safe mode")
+	}
+	nn.handleAdmin(Packet{SRC: "C", CMD: SAFEMODE, Message: "leave", User: "alice"}, &r)
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/new"}}})
+	if nn.lookup("/new") == nil {
+		t.Errorf("Directory not created after leaving safe mode")
+	}
+
+	nn.handleAdmin(Packet{SRC: "C", CMD: SETBLOCKSIZE, Message: "100", User: "alice"}, &r)
+	if r.CMD != ERROR || nn.sizeofblock != 4096 {
+		t.Errorf("Block size below 4096 bytes accepted")
+	}
+	nn.handleAdmin(Packet{SRC: "C", CMD: SETBLOCKSIZE, Message: "65536", User: "alice"}, &r)
+	if r.CMD != ACK || nn.sizeofblock != 65536 {
+		t.Errorf("Block size not changed %v", r)
+	}
+
+	nn.AcquireLease("/dir/log.txt", "W1")
+	nn.handleAdmin(Packet{SRC: "C", CMD: LISTLEASES, User: "alice"}, &r)
+	if !strings.Contains(r.Message, "/dir/log.txt") || !strings.Contains(r.Message, "W1") {
+		t.Errorf("Lease not listed %v", r)
+	}
+}
+
+func TestTriggerBlockReport(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	local, peer := net.Pipe()
+	defer peer.Close()
+	nn.SetOutbound("DN1", local)
+	decoder := json.NewDecoder(peer)
+
+	if _, err := nn.TriggerBlockReport("DN2"); err == nil {
+		t.Errorf("Block report requested from an unknown datanode")
+	}
+	nn.TriggerBlockReport("")
+
+	// the next heartbeat is answered by asking for a full report, once
+	for _, want := range []int{LIST, ACK} {
+		go nn.HandlePacket(Packet{SRC: "DN1", DST: "NN", CMD: HB})
+		var r Packet
+		decoder.Decode(&r)
+		if r.CMD != want {
+			t.Errorf("Expected %s, got %s", CommandName(want), CommandName(r.CMD))
+		}
+	}
+}
+
+func TestRefreshNodes(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "topology.xml")
+	write := func(rack string) {
+		xml := `<ConfigOptionList><ConfigOption key="DN1">` + rack + `</ConfigOption></ConfigOptionList>`
+		if err := ioutil.WriteFile(path, []byte(xml), 0644); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	nn := New()
+	write("/rack1")
+	nn.LoadTopology(path)
+	nn.topologyFile = path
+	dn := &datanode{ID: "DN1"}
+
+	write("/rack2")
+	if rack := nn.datanodeRack(dn); rack != "/rack1" {
+		t.Errorf("Expected /rack1 before the refresh, got %s", rack)
+	}
+	if _, err := nn.RefreshNodes(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if rack := nn.datanodeRack(dn); rack != "/rack2" {
+		t.Errorf("Expected /rack2 after the refresh, got %s", rack)
+	}
+}
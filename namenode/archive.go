@@ -0,0 +1,157 @@
+package namenode
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+)
+
+// archive indexes the small files packed one after another into a single
+// container file, so they take one entry of filemap and a few Blocks rather
+// than an entry and a Block each. The packed files are read-only, and are
+// read through the container at <container><rel>.
+type archive struct {
+	Path  string                // the container file
+	Files map[string]FileStatus // packed files relative to Path, with their Offset and Size
+	Dirs  map[string]bool       // directories relative to Path
+}
+
+// CreateArchive indexes the files packed into the file at container, given
+// by entries with their paths relative to it. The container then reads as a
+// directory holding them.
+func (nn *NameNode) CreateArchive(container string, entries []FileStatus) error {
+	blocks, ok := nn.filemap.Get(container)
+	if !ok {
+		return newError(ErrFileNotFound, "File not found "+container)
+	}
+	if _, ok := nn.archives[container]; ok {
+		return errors.New("Archive exists " + container)
+	}
+	if _, ok := nn.erasure[container]; ok {
+		return errors.New("Cannot archive into an erasure coded file " + container)
+	}
+	if nn.leaseHolder(container) != "" {
+		return errors.New("Archive is being written " + container)
+	}
+	size := blocksStatus(container, blocks).Size
+
+	a := &archive{Path: container, Files: make(map[string]FileStatus), Dirs: make(map[string]bool)}
+	for _, st := range entries {
+		rel := st.Path
+		if !strings.HasPrefix(rel, "/") || rel == "/" || path.Clean(rel) != rel {
+			return errors.New("Invalid path in archive " + rel)
+		}
+		if st.IsDir {
+			a.Dirs[rel] = true
+			continue
+		}
+		if st.Offset < 0 || st.Size < 0 || st.Offset+st.Size > size {
+			return errors.New("Packed file " + rel + " lies outside " + container)
+		}
+		a.Files[rel] = FileStatus{Path: rel, Size: st.Size, Offset: st.Offset, ModTime: st.ModTime}
+	}
+	// every directory above a packed file exists
+	for rel := range a.Files {
+		for dir := path.Dir(rel); dir != "/"; dir = path.Dir(dir) {
+			if _, ok := a.Files[dir]; ok {
+				return errors.New("Packed file " + dir + " is also a directory")
+			}
+			a.Dirs[dir] = true
+		}
+	}
+
+	nn.archives[container] = a
+	nn.metaLog.Info("Created archive", "path", container, "files", len(a.Files))
+	return nil
+}
+
+// splitArchivePath finds the archive holding path, and the path relative to
+// it, empty for the container itself
+func (nn *NameNode) splitArchivePath(p string) (*archive, string, bool) {
+	if len(nn.archives) == 0 {
+		return nil, "", false
+	}
+	for i := len(p); i > 0; i = strings.LastIndex(p[:i], "/") {
+		if a, ok := nn.archives[p[:i]]; ok {
+			return a, p[i:], true
+		}
+	}
+	return nil, "", false
+}
+
+// inArchive reports whether path is within a read-only archive
+func (nn *NameNode) inArchive(p string) bool {
+	_, rel, ok := nn.splitArchivePath(p)
+	return ok && rel != ""
+}
+
+// archiveStat describes the path rel within an archive. Packed files name
+// their archive and their Offset within it, from which they are read.
+func (nn *NameNode) archiveStat(a *archive, rel string) (FileStatus, error) {
+	p := a.Path + rel
+	if st, ok := a.Files[rel]; ok {
+		st.Path, st.Archive = p, a.Path
+		return st, nil
+	}
+	if rel != "" && !a.Dirs[rel] {
+		return FileStatus{}, newError(ErrFileNotFound, "No such file or directory "+p)
+	}
+	if rel == "" {
+		return nn.fileStatus(nn.lookup(a.Path)), nil
+	}
+	return FileStatus{Path: p, IsDir: true, Archive: a.Path, Children: len(a.children(rel, false))}, nil
+}
+
+// children lists the paths directly below rel in an archive, or every path
+// below it if recursive is set, ordered by path
+func (a *archive) children(rel string, recursive bool) []string {
+	list := make([]string, 0)
+	add := func(p string) {
+		if !strings.HasPrefix(p, rel+"/") {
+			return
+		}
+		if !recursive && strings.Contains(p[len(rel)+1:], "/") {
+			return
+		}
+		list = append(list, p)
+	}
+	for p := range a.Files {
+		add(p)
+	}
+	for p := range a.Dirs {
+		add(p)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// listArchivePath lists a directory within an archive, or the archive
+func (nn *NameNode) listArchivePath(a *archive, rel string, recursive bool) ([]FileStatus, error) {
+	st, err := nn.archiveStat(a, rel)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir {
+		return []FileStatus{st}, nil
+	}
+
+	children := a.children(rel, recursive)
+	list := make([]FileStatus, 0, len(children))
+	for _, c := range children {
+		st, _ := nn.archiveStat(a, c)
+		list = append(list, st)
+	}
+	return list, nil
+}
+
+// renameArchives moves the archives at or below src to dst
+func (nn *NameNode) renameArchives(src, dst string) {
+	for p, a := range nn.archives {
+		if p == src || strings.HasPrefix(p, src+"/") {
+			delete(nn.archives, p)
+			a.Path = dst + strings.TrimPrefix(p, src)
+			nn.archives[a.Path] = a
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1"}
+	nn.MergeNode(BlockHeader{"DN1", "/logs.har", 100, 0, 2, 0, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/logs.har", 50, 1, 2, 0, "", 0, ""})
+
+	entries := []FileStatus{
+		{Path: "/a.log", Size: 120},
+		{Path: "/2024/b.log", Offset: 120, Size: 30},
+	}
+	if nn.CreateArchive("/logs.har", []FileStatus{{Path: "/c.log", Offset: 140, Size: 20}}) == nil {
+		t.Errorf("Archived a file lying past the end of its archive")
+	}
+	if nn.CreateArchive("/logs.har", []FileStatus{{Path: "../c.log", Size: 20}}) == nil {
+		t.Errorf("Archived a file with an invalid path")
+	}
+	if err := nn.CreateArchive("/logs.har", entries); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := nn.Stat("/logs.har/2024/b.log")
+	if err != nil || st.Archive != "/logs.har" || st.Offset != 120 || st.Size != 30 || st.Path != "/logs.har/2024/b.log" {
+		t.Errorf("Packed file described as %+v %v", st, err)
+	}
+	if st, err := nn.Stat("/logs.har"); err != nil || !st.IsDir || st.Children != 2 || st.Size != 150 {
+		t.Errorf("Archive described as %+v %v", st, err)
+	}
+	if list, err := nn.ListDir("/logs.har", true); err != nil || len(list) != 3 || list[0].Path != "/logs.har/2024" {
+		t.Errorf("Archive listed as %+v %v", list, err)
+	}
+	if _, err := nn.Stat("/logs.har/missing"); err == nil {
+		t.Errorf("Described a file missing from the archive")
+	}
+
+	// packed files are read-only, and writing the archive again is refused
+	var r Packet
+	nn.handleNamespace(Packet{SRC: "C", CMD: DELETE, Headers: []BlockHeader{{Filename: "/logs.har/a.log"}}}, &r)
+	if r.CMD != ERROR {
+		t.Errorf("Deleted a packed file")
+	}
+	nn.handleNamespace(Packet{SRC: "C", CMD: TRUNCATE, Message: "0", Headers: []BlockHeader{{Filename: "/logs.har"}}}, &r)
+	if r.CMD != ERROR {
+		t.Errorf("Truncated an archive")
+	}
+
+	// the index moves with the archive, and goes with it
+	if err := nn.Rename("/logs.har", "/old.har"); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := nn.Stat("/old.har/a.log"); err != nil || st.Archive != "/old.har" {
+		t.Errorf("Index not moved with its archive %+v %v", st, err)
+	}
+	img := nn.image()
+	if len(img.Archives) != 1 {
+		t.Errorf("Archives saved as %v", img.Archives)
+	}
+	if err := nn.Delete("/old.har", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(nn.archives) != 0 {
+		t.Errorf("Index of a deleted archive kept %v", nn.archives)
+	}
+}
@@ -0,0 +1,186 @@
+package namenode
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditRecord describes a client request, one JSON object a line in the
+// audit log
+type AuditRecord struct {
+	Time    time.Time
+	User    string // user the client made the request as
+	Host    string // host the client connected from
+	Command string
+	Path    string // file or directory the request acted on, if any
+	Dst     string // destination of a RENAME
+	Arg     string // other argument, such as a snapshot name, a datanode or the holder of a lease
+	Result  string // "ok", or the error the request failed with
+}
+
+// auditLog appends AuditRecords to a file, which is rotated to <path>.1,
+// <path>.2 and so on once it grows past maxSize, keeping keep old files
+type auditLog struct {
+	lock    sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+func newAuditLog(path string, maxSize int64, keep int) *auditLog {
+	return &auditLog{path: path, maxSize: maxSize, keep: keep}
+}
+
+// write appends rec, opening or rotating the file as needed
+func (a *auditLog) write(rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.file != nil && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		err = a.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	if a.file == nil {
+		a.file, err = os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		info, err := a.file.Stat()
+		if err != nil {
+			return err
+		}
+		a.size = info.Size()
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+// rotate closes the file and shifts it and the older files up by one,
+// removing the oldest. The caller must hold lock.
+func (a *auditLog) rotate() error {
+	a.file.Close()
+	a.file = nil
+	os.Remove(a.path + "." + strconv.Itoa(a.keep))
+	for i := a.keep - 1; i > 0; i-- {
+		os.Rename(a.path+"."+strconv.Itoa(i), a.path+"."+strconv.Itoa(i+1))
+	}
+	if a.keep == 0 {
+		return os.Remove(a.path)
+	}
+	return os.Rename(a.path, a.path+".1")
+}
+
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// audited reports whether a client request is recorded in the audit log.
+// Transfers of single Blocks are left out, apart from the first Block of a
+// file, which creates it, and a BATCH is recorded as each of its requests.
+func audited(p Packet) bool {
+	switch p.CMD {
+	case HB, HELLO, RETRIEVEBLOCK, CHECKSUM, BATCH:
+		return false
+	case DISTRIBUTE:
+		return p.Data.Header.BlockNum == 0
+	}
+	return true
+}
+
+// audit records the client request p, which was answered with r, if the
+// audit log is enabled
+func (nn *NameNode) audit(p Packet, r Packet) {
+	if nn.auditLog == nil || !audited(p) {
+		return
+	}
+	rec := AuditRecord{
+		Time:    time.Now().UTC(),
+		User:    p.User,
+		Host:    nn.clientHost,
+		Command: CommandName(p.CMD),
+		Arg:     p.Message,
+		Result:  "ok",
+	}
+	if len(p.Headers) > 0 {
+		rec.Path = p.Headers[0].Filename
+	}
+	if p.CMD == DISTRIBUTE {
+		rec.Path = p.Data.Header.Filename
+	}
+	if len(p.Renamed) == 1 {
+		rec.Dst = p.Renamed[0].Filename
+	}
+	if r.CMD == ERROR {
+		rec.Result = r.Message
+	}
+	err := nn.auditLog.write(rec)
+	if err != nil {
+		nn.log.Error("Could not write audit log", "file", nn.auditLog.path, "err", err)
+	}
+}
+
+// ReadAudit passes the records of the audit log at path to fn, oldest first
+// starting with the rotated files, until fn returns false
+func ReadAudit(path string, fn func(AuditRecord) bool) error {
+	files := []string{path}
+	for i := 1; ; i++ {
+		name := path + "." + strconv.Itoa(i)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		files = append([]string{name}, files...)
+	}
+
+	for _, name := range files {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) && name == path {
+			// rotated away with nothing written since
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			var rec AuditRecord
+			if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+				continue
+			}
+			if !fn(rec) {
+				f.Close()
+				return nil
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
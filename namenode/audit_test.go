@@ -0,0 +1,73 @@
+package namenode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	nn := New()
+	nn.auditLog = newAuditLog(path, 300, 2)
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/dir/out.txt", 1, 0, 1, 0, "", 0, ""})
+
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: HB, User: "alice"})
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: STAT, User: "alice", Headers: []BlockHeader{{Filename: "/dir"}}})
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: DELETE, User: "bob", Headers: []BlockHeader{{Filename: "/dir"}}})
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: DELETE, User: "bob", Flags: RECURSIVE, Headers: []BlockHeader{{Filename: "/dir"}}})
+	b := Block{BlockHeader{"", "/new.txt", 1, 1, 2, 0, "", 0, ""}, []byte{1}}
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: DISTRIBUTE, User: "carol", Data: b})
+	for i := 0; i < 5; i++ {
+		nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: MKDIR, User: "dave", Headers: []BlockHeader{{Filename: "/d" + string(rune('0'+i))}}})
+	}
+	nn.auditLog.Close()
+
+	// the log was rotated, keeping two old files
+	if _, err := os.Stat(path + ".2"); err != nil {
+		t.Errorf("Audit log not rotated %s", err)
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Errorf("Too many rotated audit logs kept")
+	}
+
+	var records []AuditRecord
+	err := ReadAudit(path, func(rec AuditRecord) bool {
+		records = append(records, rec)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	last := records[len(records)-1]
+	if last.User != "dave" || last.Command != "MKDIR" || last.Path != "/d4" || last.Result != "ok" {
+		t.Errorf("Wrong last record %v", last)
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i].Time.Before(records[i-1].Time) {
+			t.Errorf("Records read out of order")
+		}
+	}
+
+	// both the refused and the recursive delete of /dir are recorded, while
+	// a later Block of a file is not
+	nn.auditLog = newAuditLog(filepath.Join(t.TempDir(), "audit.log"), 1<<20, 2)
+	nn.MergeNode(BlockHeader{"DN1", "/dir/out.txt", 1, 0, 1, 0, "", 0, ""})
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: DELETE, User: "bob", Headers: []BlockHeader{{Filename: "/dir"}}})
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: DELETE, User: "bob", Flags: RECURSIVE, Headers: []BlockHeader{{Filename: "/dir"}}})
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: DISTRIBUTE, User: "carol", Data: b})
+	nn.auditLog.Close()
+	records = nil
+	ReadAudit(nn.auditLog.path, func(rec AuditRecord) bool {
+		records = append(records, rec)
+		return true
+	})
+	if len(records) != 2 {
+		t.Fatalf("Expected the two deletes alone, got %v", records)
+	}
+	if records[0].Result == "ok" || records[1].Result != "ok" || records[1].User != "bob" || records[1].Path != "/dir" {
+		t.Errorf("Wrong delete records %v", records)
+	}
+}
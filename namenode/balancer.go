@@ -0,0 +1,283 @@
+package namenode
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// time between the rounds of moves planned by the balancer
+const balanceInterval = 10 * time.Second
+
+// fraction of the mean usage by which a datanode may differ from the mean
+// before the balancer moves Blocks to or from it
+const balanceThreshold = 0.1
+
+// default bytes per second the balancer may move across the cluster
+const defaultBalanceBandwidth = 1 << 20
+
+// Balance starts moving Blocks from datanodes using more than the mean
+// storage to datanodes using less, moving at most bandwidth bytes per
+// second, or the configured bandwidth if it is 0. The balancer stops once
+// the cluster is balanced. The returned message describes the progress, so
+// repeating the request reports on a balancer already running.
+func (nn *NameNode) Balance(bandwidth int64) (string, error) {
+	if bandwidth < 0 {
+		return "", errors.New("Bandwidth must not be negative")
+	}
+	if bandwidth == 0 {
+		bandwidth = nn.balanceBandwidth
+	}
+
+	nn.balanceLock.Lock()
+	defer nn.balanceLock.Unlock()
+	nn.balanceRate = bandwidth
+	if !nn.balancing {
+		if len(nn.planMoves(0)) == 0 {
+			return "Cluster is balanced", nil
+		}
+		nn.balancing = true
+		nn.log.Info("Starting balancer", "bandwidth", bandwidth)
+		go nn.runBalancer()
+	}
+	return "Balancing at " + strconv.FormatInt(bandwidth, 10) + " bytes per second, " +
+		strconv.Itoa(nn.pendingMoves()) + " Blocks moving", nil
+}
+
+// runBalancer schedules a round of moves every balanceInterval until the
+// cluster is balanced and every move has completed
+func (nn *NameNode) runBalancer() {
+	tick := time.NewTicker(balanceInterval)
+	defer tick.Stop()
+
+	for {
+		var balancing bool
+		nn.update(func() { balancing = nn.balanceRound() })
+		if !balancing {
+			nn.log.Info("Cluster is balanced")
+			return
+		}
+		select {
+		case <-tick.C:
+		case <-nn.quit:
+			return
+		}
+	}
+}
+
+// balanceRound schedules the moves which fit in one interval's bandwidth,
+// less the Blocks still moving. It returns false once nothing is left to
+// move, and stops the balancer.
+func (nn *NameNode) balanceRound() bool {
+	nn.balanceLock.Lock()
+	defer nn.balanceLock.Unlock()
+
+	moves := make([]blockMove, 0)
+	budget := nn.balanceRate*int64(balanceInterval/time.Second) - nn.movingBytes()
+	if budget > 0 {
+		moves = nn.planMoves(budget)
+	}
+	for _, m := range moves {
+		nn.move(m.Source, m.Target)
+	}
+	if len(moves) == 0 && nn.pendingMoves() == 0 {
+		nn.balancing = false
+		return false
+	}
+	return true
+}
+
+// blockMove is a replica planned to move to another datanode
+type blockMove struct {
+	Source BlockHeader
+	Target string
+}
+
+// planMoves chooses replicas to move from datanodes using more than the mean
+// storage to datanodes using less, until either side is within the
+// threshold of the mean or budget bytes are planned. The first move is
+// planned whatever its size, so a budget of 0 finds whether the cluster is
+// balanced.
+func (nn *NameNode) planMoves(budget int64) []blockMove {
+	nodes := make([]*datanode, 0, len(nn.datanodemap))
+	var total int64
+	for _, dn := range nn.datanodemap {
+		if nn.offline[dn.ID] || dn.decommissioning {
+			continue
+		}
+		nodes = append(nodes, dn)
+		total += dn.size
+	}
+	if len(nodes) < 2 {
+		return nil
+	}
+	mean := total / int64(len(nodes))
+	margin := int64(float64(mean) * balanceThreshold)
+
+	// planned usage, updated as moves are chosen
+	usage := make(map[string]int64, len(nodes))
+	for _, dn := range nodes {
+		usage[dn.ID] = dn.size
+	}
+	// Blocks being moved count as moved, and acknowledged copies already
+	// count towards their target
+	nn.replicateLock.Lock()
+	for h, o := range nn.replications {
+		if o.Move {
+			usage[h.DatanodeID] -= int64(h.Size)
+			usage[o.Target] += int64(h.Size)
+		}
+	}
+	for _, m := range nn.moving {
+		usage[m.Source.DatanodeID] -= int64(m.Source.Size)
+	}
+	nn.replicateLock.Unlock()
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].size < nodes[j].size })
+
+	moves := make([]blockMove, 0)
+	var planned int64
+	nn.filemap.Range(func(path string, blocks map[int][]BlockHeader) bool {
+		for _, replicas := range blocks {
+			if nn.isMoving(replicas) {
+				continue
+			}
+			for _, h := range replicas {
+				if usage[h.DatanodeID] <= mean+margin || nn.offline[h.DatanodeID] {
+					continue
+				}
+				target := ""
+				for _, dn := range nodes {
+					if usage[dn.ID] < mean-margin && !holds(replicas, dn.ID) && nn.keepsRacks(replicas, h, dn) {
+						target = dn.ID
+						break
+					}
+				}
+				if target == "" {
+					continue
+				}
+				if len(moves) > 0 && planned+int64(h.Size) > budget {
+					return false
+				}
+				moves = append(moves, blockMove{h, target})
+				planned += int64(h.Size)
+				usage[h.DatanodeID] -= int64(h.Size)
+				usage[target] += int64(h.Size)
+				break
+			}
+		}
+		return true
+	})
+	return moves
+}
+
+// holds reports whether a datanode holds one of the replicas
+func holds(replicas []BlockHeader, datanodeID string) bool {
+	for _, h := range replicas {
+		if h.DatanodeID == datanodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// keepsRacks reports whether moving the replica h to target leaves the Block
+// on as many racks
+func (nn *NameNode) keepsRacks(replicas []BlockHeader, h BlockHeader, target *datanode) bool {
+	moved := make([]BlockHeader, 0, len(replicas))
+	for _, r := range replicas {
+		if r == h {
+			r.DatanodeID = target.ID
+		}
+		moved = append(moved, r)
+	}
+	return nn.rackCount(moved) >= nn.rackCount(replicas)
+}
+
+// pendingMove is the original of a replica being copied by the balancer
+type pendingMove struct {
+	Source BlockHeader
+	Sent   time.Time
+}
+
+// move copies a replica to the target datanode, deleting the original once
+// the copy is acknowledged
+func (nn *NameNode) move(source BlockHeader, target string) {
+	nn.replicateLock.Lock()
+	defer nn.replicateLock.Unlock()
+
+	nn.placementLog.Debug("Moving Block", "file", source.Filename, "block", source.BlockNum, "from", source.DatanodeID, "to", target)
+	nn.sendReplication(source, target, true)
+}
+
+// completeMove invalidates the original of a moved replica once the target
+// datanode acknowledged its copy
+func (nn *NameNode) completeMove(h BlockHeader) {
+	nn.replicateLock.Lock()
+	m, ok := nn.moving[h]
+	delete(nn.moving, h)
+	nn.replicateLock.Unlock()
+
+	if ok {
+		nn.Invalidate(m.Source)
+	}
+}
+
+// isMoving reports whether any of a Block's replicas is being copied
+func (nn *NameNode) isMoving(replicas []BlockHeader) bool {
+	nn.replicateLock.Lock()
+	defer nn.replicateLock.Unlock()
+
+	for _, h := range replicas {
+		if _, ok := nn.replications[h]; ok {
+			return true
+		}
+		for _, m := range nn.moving {
+			if m.Source == h {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pendingMoves counts the Blocks being moved by the balancer
+func (nn *NameNode) pendingMoves() int {
+	nn.replicateLock.Lock()
+	defer nn.replicateLock.Unlock()
+
+	// moves which were not acknowledged in time are given up
+	n := 0
+	for h, m := range nn.moving {
+		if time.Since(m.Sent) < replicationTimeout {
+			n++
+		} else {
+			delete(nn.moving, h)
+		}
+	}
+	for h, o := range nn.replications {
+		if o.Move && time.Since(o.Sent) < replicationTimeout {
+			n++
+		} else if o.Move {
+			delete(nn.replications, h)
+		}
+	}
+	return n
+}
+
+// movingBytes is the size of the Blocks being moved by the balancer
+func (nn *NameNode) movingBytes() int64 {
+	nn.replicateLock.Lock()
+	defer nn.replicateLock.Unlock()
+
+	var n int64
+	for h := range nn.moving {
+		n += int64(h.Size)
+	}
+	for h, o := range nn.replications {
+		if o.Move {
+			n += int64(h.Size)
+		}
+	}
+	return n
+}
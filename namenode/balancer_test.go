@@ -0,0 +1,62 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestBalancer(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", listed: true}
+	for i := 0; i < 4; i++ {
+		nn.MergeNode(BlockHeader{"DN1", "/out.txt", 10, i, 4, 0, "", 0, ""})
+	}
+
+	moves := nn.planMoves(15)
+	if len(moves) != 1 || moves[0].Target != "DN2" {
+		t.Fatalf("Unexpected moves within the budget %v", moves)
+	}
+	moves = nn.planMoves(100)
+	if len(moves) != 2 {
+		t.Fatalf("Expected moves until balanced, got %v", moves)
+	}
+
+	h := moves[0].Source
+	nn.move(h, "DN2")
+	if len(nn.planMoves(100)) != 1 {
+		t.Errorf("Planned a Block which is already moving")
+	}
+	target, ok := nn.takeReplication(h)
+	if !ok || target != "DN2" {
+		t.Fatalf("Move not pending")
+	}
+	copied := h
+	copied.DatanodeID = "DN2"
+	nn.MergeNode(copied)
+	nn.completeMove(copied)
+	invalidated := nn.PendingInvalidations("DN1")
+	if len(invalidated) != 1 || invalidated[0] != h {
+		t.Errorf("Original was not invalidated %v", invalidated)
+	}
+	if nn.pendingMoves() != 0 {
+		t.Errorf("Move still pending")
+	}
+
+	nn.CompleteInvalidation("DN1", invalidated)
+	nn.move(moves[1].Source, "DN2")
+	nn.takeReplication(moves[1].Source)
+	copied = moves[1].Source
+	copied.DatanodeID = "DN2"
+	nn.MergeNode(copied)
+	nn.completeMove(copied)
+	nn.CompleteInvalidation("DN1", nn.PendingInvalidations("DN1"))
+
+	message, err := nn.Balance(0)
+	if err != nil || message != "Cluster is balanced" {
+		t.Errorf("Unexpected balancer progress %q %v", message, err)
+	}
+	if _, err := nn.Balance(-1); err == nil {
+		t.Errorf("Accepted a negative bandwidth")
+	}
+}
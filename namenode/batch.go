@@ -0,0 +1,73 @@
+package namenode
+
+import (
+	"strconv"
+)
+
+// requests a single BATCH may carry, so one does not hold the namespace for long
+const maxBatchSize = 10000
+
+// batchable reports whether a namespace request may be one of a BATCH
+func batchable(cmd int) bool {
+	switch cmd {
+	case DELETE, STAT, LISTDIR, MKDIR, SETQUOTA, GETQUOTA, RENAME, SETREP, SETTIMES, SETXATTR, GETXATTR, LISTXATTRS,
+		REMOVEXATTR, CREATESYMLINK, READLINK, STORAGEPOLICY:
+		return true
+	}
+	return false
+}
+
+// batchReads reports whether every request of a BATCH only reads the
+// namespace, so it may be handled alongside other readers
+func batchReads(p Packet) bool {
+	for _, op := range p.Commands {
+		if !readsNamespace(Packet{SRC: p.SRC, CMD: op.CMD}) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleBatch performs each of the requests in the Commands of a BATCH in
+// turn, as if sent alone, and answers with their answers in the same order.
+// Each request succeeds or fails by itself, and is journaled and audited as
+// it would be were it sent alone.
+func (nn *NameNode) handleBatch(p Packet, r *Packet) {
+	if len(p.Commands) > maxBatchSize {
+		r.CMD = ERROR
+		r.Message = "Batch of " + strconv.Itoa(len(p.Commands)) + " requests is larger than " + strconv.Itoa(maxBatchSize)
+		return
+	}
+	nn.metaLog.Debug("Handling batch", "requests", len(p.Commands))
+
+	r.CMD = BATCH
+	r.Commands = make([]Packet, len(p.Commands))
+	for i, op := range p.Commands {
+		op.SRC, op.DST, op.User = p.SRC, nn.id, p.User
+		or := Packet{SRC: nn.id, DST: p.SRC, CMD: ACK, Headers: make([]BlockHeader, 0)}
+
+		err := nn.cleanPaths(&op)
+		if err == nil {
+			err = nn.resolvePaths(&op)
+		}
+		if t := nn.requestTenant(op.User); t != nil && err == nil {
+			err = nn.confine(t, op)
+		}
+		switch {
+		case !batchable(op.CMD):
+			or.CMD = ERROR
+			or.Message = CommandName(op.CMD) + " cannot be batched"
+		case len(op.Headers) != 1:
+			fail(&or, ErrInvalidHeader)
+		case err != nil:
+			fail(&or, err)
+		case nn.safeMode && changesNamespace(op.CMD):
+			fail(&or, ErrSafeMode)
+		default:
+			nn.handleNamespace(op, &or)
+		}
+
+		nn.audit(op, or)
+		r.Commands[i] = or
+	}
+}
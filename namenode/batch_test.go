@@ -0,0 +1,59 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1"}
+	for _, name := range []string{"/logs/a", "/logs/b", "/logs/c"} {
+		nn.MergeNode(BlockHeader{"DN1", name, 1, 0, 1, 0, "", 0, ""})
+	}
+	op := func(cmd int, path string) Packet {
+		return Packet{CMD: cmd, Headers: []BlockHeader{{Filename: path}}}
+	}
+
+	var r Packet
+	stats := Packet{SRC: "C", CMD: BATCH, Commands: []Packet{op(STAT, "/logs/a"), op(STAT, "/missing"), op(STAT, "/logs//c")}}
+	if !readsNamespace(stats) {
+		t.Errorf("Batch of stats not handled as a reader")
+	}
+	nn.handleBatch(stats, &r)
+	if r.CMD != BATCH || len(r.Commands) != 3 {
+		t.Fatalf("Wrong answer to a batch %v", r)
+	}
+	if r.Commands[0].CMD != STAT || r.Commands[0].Status[0].Path != "/logs/a" {
+		t.Errorf("Wrong status %v", r.Commands[0])
+	}
+	if r.Commands[1].CMD != ERROR || r.Commands[2].CMD != STAT || r.Commands[2].Status[0].Path != "/logs/c" {
+		t.Errorf("Requests of a batch not answered each by itself %v", r.Commands)
+	}
+
+	// a request which fails does not stop the others
+	deletes := Packet{SRC: "C", CMD: BATCH, Commands: []Packet{op(DELETE, "/logs/a"), op(DISTRIBUTE, "/logs/b"), op(DELETE, "/missing"), op(DELETE, "/logs/c")}}
+	if readsNamespace(deletes) {
+		t.Errorf("Batch of deletes handled as a reader")
+	}
+	nn.handleBatch(deletes, &r)
+	if r.Commands[0].CMD != ACK || r.Commands[1].CMD != ERROR || r.Commands[2].CMD != ERROR || r.Commands[3].CMD != ACK {
+		t.Errorf("Wrong answers %v", r.Commands)
+	}
+	if nn.lookup("/logs/a") != nil || nn.lookup("/logs/c") != nil || nn.lookup("/logs/b") == nil {
+		t.Errorf("Wrong files deleted\n%s", nn.ListFiles())
+	}
+
+	// changes are refused in safe mode, reads are not
+	nn.safeMode = true
+	nn.handleBatch(Packet{SRC: "C", CMD: BATCH, Commands: []Packet{op(DELETE, "/logs/b"), op(STAT, "/logs/b")}}, &r)
+	if r.Commands[0].CMD != ERROR || r.Commands[1].CMD != STAT || nn.lookup("/logs/b") == nil {
+		t.Errorf("Wrong answers in safe mode %v", r.Commands)
+	}
+	nn.safeMode = false
+
+	nn.handleBatch(Packet{SRC: "C", CMD: BATCH, Commands: make([]Packet, maxBatchSize+1)}, &r)
+	if r.CMD != ERROR {
+		t.Errorf("Batch larger than %d answered %v", maxBatchSize, r)
+	}
+}
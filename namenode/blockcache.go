@@ -0,0 +1,212 @@
+package namenode
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default time between scans placing the Blocks of cache directives
+const defaultCacheInterval = 30 * time.Second
+
+// CacheStats describes the use of a datanode's block cache
+type CacheStats struct {
+	Capacity int64 // bytes the cache may hold
+	Used     int64 // bytes of the Blocks in the cache
+	Blocks   int   // Blocks in the cache
+	Pinned   int   // Blocks the namenode asked to keep, which are never evicted
+	Hits     int64 // reads answered from the cache
+	Misses   int64 // reads of Blocks missing from the cache
+}
+
+// updateCache records the use of its block cache a datanode reported in a
+// heartbeat
+func (nn *NameNode) updateCache(dn *datanode, p Packet) {
+	if p.Cache == nil {
+		dn.cache = CacheStats{}
+		return
+	}
+	dn.cache = *p.Cache
+}
+
+// cacheSummary describes the use of a datanode's block cache for the report
+// and the status page
+func (dn *datanode) cacheSummary() string {
+	if dn.cache.Capacity == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(dn.cache.Used, 10) + " of " + strconv.FormatInt(dn.cache.Capacity, 10) +
+		", " + strconv.Itoa(dn.cache.Pinned) + " pinned, " + strconv.FormatInt(dn.cache.Hits, 10) + " hits, " +
+		strconv.FormatInt(dn.cache.Misses, 10) + " misses"
+}
+
+// AddCacheDirective keeps one replica of each Block of the files at or below
+// path in the memory of its datanode, with the next heartbeats. Files created
+// below path later are cached by the next scan.
+func (nn *NameNode) AddCacheDirective(path string) error {
+	if nn.lookup(path) == nil {
+		return newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+	if nn.cacheDirectives[path] {
+		return errors.New("Already cached " + path)
+	}
+	nn.cacheDirectives[path] = true
+	nn.metaLog.Info("Added cache directive", "path", path)
+	nn.rescanCache()
+	return nil
+}
+
+// RemoveCacheDirective lets the datanodes evict the Blocks kept in memory
+// for path
+func (nn *NameNode) RemoveCacheDirective(path string) error {
+	if !nn.cacheDirectives[path] {
+		return errors.New("Not cached " + path)
+	}
+	delete(nn.cacheDirectives, path)
+	nn.metaLog.Info("Removed cache directive", "path", path)
+	nn.rescanCache()
+	return nil
+}
+
+// ListCacheDirectives describes the paths whose Blocks are kept in memory,
+// with the size and number of the Blocks below each, ordered by path
+func (nn *NameNode) ListCacheDirectives() []FileStatus {
+	list := make([]FileStatus, 0, len(nn.cacheDirectives))
+	for path := range nn.cacheDirectives {
+		st := FileStatus{Path: path}
+		for _, blocks := range nn.cachedBlocks(path) {
+			for _, replicas := range blocks {
+				if len(replicas) > 0 {
+					st.Size += int64(replicas[0].Size)
+					st.NumBlocks++
+				}
+			}
+		}
+		list = append(list, st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// cachedBlocks returns the Blocks of the files at or below path, by file
+func (nn *NameNode) cachedBlocks(path string) map[string]map[int][]BlockHeader {
+	files := make(map[string]map[int][]BlockHeader)
+	n := nn.lookup(path)
+	if n == nil {
+		return files
+	}
+	nn.walk(n, func(f *filenode) {
+		if blocks, ok := nn.filemap.Get(f.path); ok {
+			files[f.path] = blocks
+		}
+	})
+	return files
+}
+
+// MonitorCache periodically places the Blocks of the cache directives on
+// datanodes, as files are written and datanodes come and go, until the
+// namenode shuts down
+func (nn *NameNode) MonitorCache() {
+	tick := time.NewTicker(nn.cacheInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			nn.update(nn.rescanCache)
+		case <-nn.quit:
+			return
+		}
+	}
+}
+
+// rescanCache chooses a replica of each Block under the cache directives to
+// keep in memory, and queues the CACHE and UNCACHE commands bringing the
+// datanodes' caches in line. A Block stays with the datanode already caching
+// it while that replica lasts, and is otherwise placed on the first datanode
+// holding a replica which takes commands and has room in its cache.
+func (nn *NameNode) rescanCache() {
+	if nn.replaying || nn.standby {
+		return
+	}
+
+	// room left in each cache, less the Blocks pinned there
+	room := make(map[string]int64)
+	for id, dn := range nn.datanodemap {
+		room[id] = dn.cache.Capacity
+		for h := range dn.pinned {
+			room[id] -= int64(h.Size)
+		}
+	}
+	cacheable := func(h BlockHeader) bool {
+		dn, ok := nn.datanodemap[h.DatanodeID]
+		return ok && !nn.offline[h.DatanodeID] && dn.supports("cache") && room[h.DatanodeID] >= int64(h.Size)
+	}
+
+	wanted := make(map[BlockHeader]bool)
+	added := make(map[string][]BlockHeader)
+	for path := range nn.cacheDirectives {
+		for _, blocks := range nn.cachedBlocks(path) {
+			for _, replicas := range blocks {
+				replicas = nn.keptReplicas(replicas)
+				var chosen *BlockHeader
+				for i, h := range replicas {
+					if dn := nn.datanodemap[h.DatanodeID]; dn != nil && dn.pinned[h] && !nn.offline[h.DatanodeID] {
+						chosen = &replicas[i]
+						break
+					}
+				}
+				if chosen == nil {
+					for i, h := range replicas {
+						if cacheable(h) {
+							chosen = &replicas[i]
+							room[h.DatanodeID] -= int64(h.Size)
+							added[h.DatanodeID] = append(added[h.DatanodeID], h)
+							break
+						}
+					}
+				}
+				if chosen != nil {
+					wanted[*chosen] = true
+				}
+			}
+		}
+	}
+
+	for id, dn := range nn.datanodemap {
+		removed := make([]BlockHeader, 0)
+		for h := range dn.pinned {
+			if !wanted[h] {
+				delete(dn.pinned, h)
+				removed = append(removed, h)
+			}
+		}
+		if len(removed) > 0 && !nn.offline[id] && dn.supports("cache") {
+			sortHeaders(removed)
+			nn.queueCommand(Packet{SRC: nn.id, DST: id, CMD: UNCACHE, Headers: removed})
+		}
+		if headers := added[id]; len(headers) > 0 {
+			if dn.pinned == nil {
+				dn.pinned = make(map[BlockHeader]bool)
+			}
+			for _, h := range headers {
+				dn.pinned[h] = true
+			}
+			sortHeaders(headers)
+			nn.placementLog.Debug("Caching Blocks", "datanode", id, "blocks", len(headers))
+			nn.queueCommand(Packet{SRC: nn.id, DST: id, CMD: CACHE, Headers: headers})
+		}
+	}
+}
+
+// sortHeaders orders headers by file and Block number
+func sortHeaders(headers []BlockHeader) {
+	sort.Slice(headers, func(i, j int) bool {
+		if c := strings.Compare(headers[i].Filename, headers[j].Filename); c != 0 {
+			return c < 0
+		}
+		return headers[i].BlockNum < headers[j].BlockNum
+	})
+}
@@ -0,0 +1,74 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestCacheDirectives(t *testing.T) {
+
+	nn := New()
+	features := []string{"commands", "cache"}
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", features: features, cache: CacheStats{Capacity: 100}}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", features: features, cache: CacheStats{Capacity: 15}}
+	nn.datanodemap["DN3"] = &datanode{ID: "DN3"}
+	for _, h := range []BlockHeader{
+		{"DN2", "/hot/a.txt", 10, 0, 2, 0, "", 0, ""},
+		{"DN1", "/hot/a.txt", 10, 0, 2, 0, "", 0, ""},
+		{"DN2", "/hot/a.txt", 10, 1, 2, 0, "", 0, ""},
+		{"DN1", "/hot/a.txt", 10, 1, 2, 0, "", 0, ""},
+		{"DN3", "/hot/b.txt", 10, 0, 1, 0, "", 0, ""},
+		{"DN1", "/cold.txt", 10, 0, 1, 0, "", 0, ""},
+	} {
+		nn.MergeNode(h)
+	}
+
+	if err := nn.AddCacheDirective("/missing"); err == nil {
+		t.Errorf("Cached a missing path")
+	}
+	if err := nn.AddCacheDirective("/hot"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nn.AddCacheDirective("/hot"); err == nil {
+		t.Errorf("Cached a path twice")
+	}
+
+	// each Block is pinned once, on a datanode with room, and Blocks only on
+	// datanodes without the cache are left alone
+	pinned := len(nn.datanodemap["DN1"].pinned) + len(nn.datanodemap["DN2"].pinned)
+	if pinned != 2 || len(nn.datanodemap["DN2"].pinned) != 1 {
+		t.Fatalf("Wrong Blocks pinned, DN1 %v, DN2 %v", nn.datanodemap["DN1"].pinned, nn.datanodemap["DN2"].pinned)
+	}
+	if c := nn.takeCommands("DN1"); len(c) != 1 || c[0].CMD != CACHE || len(c[0].Headers) != 1 {
+		t.Errorf("Wrong commands for DN1 %v", commandNamesOf(c))
+	}
+	if c := nn.takeCommands("DN2"); len(c) != 1 || c[0].CMD != CACHE {
+		t.Errorf("Wrong commands for DN2 %v", commandNamesOf(c))
+	}
+	if c := nn.takeCommands("DN3"); len(c) != 0 {
+		t.Errorf("Commands for a datanode without a cache %v", commandNamesOf(c))
+	}
+
+	// a rescan keeps the Blocks where they are
+	nn.rescanCache()
+	if c := nn.takeCommands("DN1"); len(c) != 0 {
+		t.Errorf("Rescan moved cached Blocks %v", commandNamesOf(c))
+	}
+
+	list := nn.ListCacheDirectives()
+	if len(list) != 1 || list[0].Path != "/hot" || list[0].NumBlocks != 3 || list[0].Size != 30 {
+		t.Errorf("Wrong cache directives %v", list)
+	}
+
+	if err := nn.RemoveCacheDirective("/hot"); err != nil {
+		t.Fatal(err)
+	}
+	if len(nn.datanodemap["DN1"].pinned)+len(nn.datanodemap["DN2"].pinned) != 0 {
+		t.Errorf("Blocks still pinned after the directive was removed")
+	}
+	if c := nn.takeCommands("DN1"); len(c) != 1 || c[0].CMD != UNCACHE {
+		t.Errorf("Wrong commands for DN1 %v", commandNamesOf(c))
+	}
+	if err := nn.RemoveCacheDirective("/hot"); err == nil {
+		t.Errorf("Removed a missing directive")
+	}
+}
@@ -0,0 +1,22 @@
+package namenode
+
+import (
+	"sync/atomic"
+)
+
+// nextBlockID returns a new ID for a Block being written. Datanodes keep a
+// Block with an ID under it, so a renamed file's Blocks stay where they are.
+func (nn *NameNode) nextBlockID() int64 {
+	return atomic.AddInt64(&nn.blockID, 1)
+}
+
+// observeBlockID makes sure IDs given out later are greater than one seen
+// in a saved or reported header
+func (nn *NameNode) observeBlockID(id int64) {
+	for {
+		current := atomic.LoadInt64(&nn.blockID)
+		if id <= current || atomic.CompareAndSwapInt64(&nn.blockID, current, id) {
+			return
+		}
+	}
+}
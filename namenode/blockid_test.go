@@ -0,0 +1,58 @@
+package namenode
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockIDs(t *testing.T) {
+
+	nn := New()
+	nn.metadatafile = filepath.Join(t.TempDir(), "metadata.json")
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+
+	p, err := nn.AssignBlock(Block{BlockHeader{"", "/out.txt", 1, 0, 2, 0, "", 0, ""}, []byte{1}})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	first := p.Data.Header
+	p, _ = nn.AssignBlock(Block{BlockHeader{"", "/out.txt", 1, 1, 2, 0, "", 0, ""}, []byte{2}})
+	second := p.Data.Header
+	if first.BlockID < 1 || second.BlockID <= first.BlockID {
+		t.Fatalf("Block IDs not increasing %d %d", first.BlockID, second.BlockID)
+	}
+	nn.MergeNode(first)
+	nn.MergeNode(second)
+
+	// a renamed file keeps the IDs of its Blocks
+	if err := nn.Rename("/out.txt", "/moved.txt"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, locations, err := nn.BlockLocations("/moved.txt", 0, 0, "")
+	if err != nil || len(locations) != 2 || locations[0].BlockID != first.BlockID || locations[1].BlockID != second.BlockID {
+		t.Errorf("Expected Blocks %d and %d, got %v %v", first.BlockID, second.BlockID, locations, err)
+	}
+
+	// IDs given out after a restart are greater than any given out before
+	nn.nextBlockID()
+	if err := nn.Shutdown(context.Background()); err != nil {
+		t.Fatalf("%s", err)
+	}
+	restarted := New()
+	restarted.metadatafile = nn.metadatafile
+	if err := restarted.LoadMetadata(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if id := restarted.nextBlockID(); id != second.BlockID+2 {
+		t.Errorf("Expected Block ID %d, got %d", second.BlockID+2, id)
+	}
+
+	// and greater than any reported
+	nn = New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/old.txt", 1, 0, 1, 0, "", 7, ""})
+	if id := nn.nextBlockID(); id != 8 {
+		t.Errorf("Expected Block ID 8, got %d", id)
+	}
+}
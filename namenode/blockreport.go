@@ -0,0 +1,95 @@
+package namenode
+
+// ApplyFullReport merges every header listed by a datanode and removes any
+// replica attributed to it which it did not list. Replicas of no file are
+// held back as orphans. reportID becomes the base which the datanode's
+// following incremental reports build on.
+func (nn *NameNode) ApplyFullReport(dn *datanode, reportID int64, headers []BlockHeader) {
+	reported := make(map[BlockHeader]struct{}, len(headers))
+	for _, h := range headers {
+		reported[h] = struct{}{}
+	}
+	nn.forgetOrphans(dn, reported)
+	for _, h := range headers {
+		if !nn.holdOrphan(h) {
+			nn.mergeReported(h)
+		}
+	}
+
+	// reconcile replicas which the datanode no longer holds
+	stale := make([]BlockHeader, 0)
+	nn.filemap.Range(func(path string, blocks map[int][]BlockHeader) bool {
+		for _, replicas := range blocks {
+			for _, h := range replicas {
+				if _, ok := reported[h]; !ok && h.DatanodeID == dn.ID && !nn.isRenameTarget(h) {
+					stale = append(stale, h)
+				}
+			}
+		}
+		return true
+	})
+	for _, h := range stale {
+		nn.metaLog.Info("Removing replica missing from full report", "header", h)
+		nn.removeReplica(h)
+	}
+	nn.pruneExcess(headers)
+
+	dn.listed = true
+	dn.lastReport = reportID
+}
+
+// ApplyBlockReport applies the Blocks added and removed on a datanode since
+// its previous report. Reports are numbered consecutively, so a report which
+// was already applied is ignored, and false is returned when a report was
+// missed and the datanode must send a full report.
+func (nn *NameNode) ApplyBlockReport(dn *datanode, reportID int64, added, removed []BlockHeader) bool {
+	if !dn.listed {
+		return false
+	}
+	if reportID <= dn.lastReport {
+		// retransmission of a report already applied
+		return true
+	}
+	if reportID != dn.lastReport+1 {
+		nn.metaLog.Warn("Missed block report", "datanode", dn.ID, "expected", dn.lastReport+1, "got", reportID)
+		return false
+	}
+
+	for _, h := range added {
+		nn.mergeReported(h)
+	}
+	nn.pruneExcess(added)
+	for _, h := range removed {
+		if h.DatanodeID == dn.ID {
+			nn.removeReplica(h)
+		}
+	}
+	dn.lastReport = reportID
+	return true
+}
+
+// pruneExcess invalidates the replicas beyond those needed of the Blocks in
+// a block report, as when a datanode thought dead returns with Blocks which
+// were copied elsewhere meanwhile. The replicas on the most used datanodes
+// go first, as chosen by excessReplicas. Blocks being copied are left alone,
+// as a move holds an extra replica until the original is deleted.
+func (nn *NameNode) pruneExcess(reported []BlockHeader) {
+	checked := make(map[blockKey]bool)
+	for _, r := range reported {
+		key := blockKey{r.Filename, r.BlockNum}
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+
+		kept := nn.keptReplicas(nn.replicas(r.Filename, r.BlockNum))
+		needed := nn.neededReplicas(r.Filename)
+		if len(kept) <= needed || nn.isMoving(kept) {
+			continue
+		}
+		for _, h := range nn.excessReplicas(kept, len(kept)-needed) {
+			nn.placementLog.Info("Deleting excess replica", "file", h.Filename, "block", h.BlockNum, "datanode", h.DatanodeID)
+			nn.Invalidate(h)
+		}
+	}
+}
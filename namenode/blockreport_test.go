@@ -0,0 +1,110 @@
+package namenode
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFullReportReconciles(t *testing.T) {
+
+	nn := New()
+	dn := &datanode{ID: "DN1"}
+	nn.datanodemap["DN1"] = dn
+
+	inh1 := BlockHeader{"DN1", "/out.txt", 1, 0, 2, 0, "", 0, ""}
+	inh2 := BlockHeader{"DN1", "/out.txt", 1, 1, 2, 0, "", 0, ""}
+	nn.ApplyFullReport(dn, 100, []BlockHeader{inh1, inh2})
+	if !dn.listed || dn.lastReport != 100 {
+		t.Errorf("Full report not recorded")
+	}
+
+	// the second block was lost on the datanode
+	nn.ApplyFullReport(dn, 200, []BlockHeader{inh1})
+	blks, _ := nn.filemap.Get("/out.txt")
+	if _, ok := blks[1]; ok {
+		t.Errorf("Missing replica was not removed")
+	}
+	if len(blks[0]) != 1 {
+		t.Errorf("Reported replica was removed")
+	}
+}
+
+func TestIncrementalReports(t *testing.T) {
+
+	nn := New()
+	dn := &datanode{ID: "DN1"}
+	nn.datanodemap["DN1"] = dn
+
+	inh1 := BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""}
+	inh2 := BlockHeader{"DN1", "/other.txt", 1, 0, 1, 0, "", 0, ""}
+
+	if nn.ApplyBlockReport(dn, 1, []BlockHeader{inh1}, nil) {
+		t.Errorf("Applied incremental report before a full report")
+	}
+
+	nn.ApplyFullReport(dn, 10, []BlockHeader{inh1})
+
+	if !nn.ApplyBlockReport(dn, 11, []BlockHeader{inh2}, []BlockHeader{inh1}) {
+		t.Errorf("Rejected consecutive report")
+	}
+	if _, ok := nn.filemap.Get("/out.txt"); ok {
+		t.Errorf("Removed block still in filemap")
+	}
+	if _, ok := nn.filemap.Get("/other.txt"); !ok {
+		t.Errorf("Added block not in filemap")
+	}
+
+	if !nn.ApplyBlockReport(dn, 11, []BlockHeader{inh2}, []BlockHeader{inh1}) {
+		t.Errorf("Rejected retransmitted report")
+	}
+
+	if nn.ApplyBlockReport(dn, 13, nil, nil) {
+		t.Errorf("Accepted report after a missed report")
+	}
+}
+
+func TestReportPrunesExcess(t *testing.T) {
+
+	nn := New()
+	nn.replication = 2
+	for i, id := range []string{"DN1", "DN2", "DN3"} {
+		nn.datanodemap[id] = &datanode{ID: id, listed: true, size: int64(10 * (i + 1))}
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN2", "/out.txt", 1, 0, 1, 0, "", 0, ""})
+
+	// DN3 returns with a replica copied elsewhere while it was away
+	back := BlockHeader{"DN3", "/out.txt", 1, 0, 1, 0, "", 0, ""}
+	nn.ApplyFullReport(nn.datanodemap["DN3"], 1, []BlockHeader{back})
+	if invalidated := nn.PendingInvalidations("DN3"); len(invalidated) != 1 || invalidated[0] != back {
+		t.Errorf("Expected the replica on the most used datanode deleted, got %v", invalidated)
+	}
+	if len(nn.PendingInvalidations("DN1"))+len(nn.PendingInvalidations("DN2")) != 0 {
+		t.Errorf("Needed replicas deleted")
+	}
+
+	// a replica being moved is kept until the move completes
+	nn.CompleteInvalidation("DN3", nn.PendingInvalidations("DN3"))
+	h := BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""}
+	nn.move(h, "DN3")
+	nn.ApplyBlockReport(nn.datanodemap["DN3"], 2, []BlockHeader{back}, nil)
+	if len(nn.PendingInvalidations("DN1"))+len(nn.PendingInvalidations("DN3")) != 0 {
+		t.Errorf("Replica of a Block being moved deleted")
+	}
+}
+
+// BenchmarkFullReport applies a full report of a datanode holding 20000
+// Blocks, each of which is looked up in the report once
+func BenchmarkFullReport(b *testing.B) {
+	nn := New()
+	dn := &datanode{ID: "DN1"}
+	nn.datanodemap["DN1"] = dn
+	headers := make([]BlockHeader, 0, 20000)
+	for i := 0; i < 20000; i++ {
+		headers = append(headers, BlockHeader{"DN1", "/bench/report" + strconv.Itoa(i/10), 1, i % 10, 10, 1, "", int64(i + 1), ""})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nn.ApplyFullReport(dn, int64(i+1), headers)
+	}
+}
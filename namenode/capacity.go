@@ -0,0 +1,24 @@
+package namenode
+
+// updateCapacity records the free storage and failed volumes a datanode
+// reported in a heartbeat
+func (nn *NameNode) updateCapacity(dn *datanode, p Packet) {
+	if p.Flags&CAPACITY == 0 {
+		dn.hasCapacity = false
+		return
+	}
+	if p.Message != dn.failedVolumes {
+		if p.Message != "" {
+			nn.connLog.Warn("Datanode has failed volumes", "datanode", dn.ID, "volumes", p.Message)
+		}
+		dn.failedVolumes = p.Message
+	}
+	dn.capacity = p.Capacity
+	dn.hasCapacity = true
+}
+
+// hasRoom reports whether a datanode may have room for size more bytes.
+// Datanodes which do not report their capacity are assumed to.
+func (dn *datanode) hasRoom(size int64) bool {
+	return !dn.hasCapacity || dn.capacity >= size
+}
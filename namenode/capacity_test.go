@@ -0,0 +1,37 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestFullDatanodesSkipped(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", listed: true}
+
+	nn.updateCapacity(nn.datanodemap["DN1"], Packet{Flags: CAPACITY, Capacity: 10, Message: "/disk2"})
+	nn.updateCapacity(nn.datanodemap["DN2"], Packet{Flags: CAPACITY, Capacity: 1000})
+	if nn.datanodemap["DN1"].failedVolumes != "/disk2" {
+		t.Errorf("Failed volumes not recorded")
+	}
+
+	for i := 0; i < 10; i++ {
+		p, err := nn.AssignBlock(Block{BlockHeader{"", "/out.txt", 100, 0, 1, 0, "", 0, ""}, make([]byte, 100)})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if p.DST != "DN2" {
+			t.Fatalf("Block placed on a full datanode %s", p.DST)
+		}
+	}
+	if target := nn.chooseTarget([]BlockHeader{{"DN2", "/out.txt", 100, 0, 1, 0, "", 0, ""}}); target != nil {
+		t.Errorf("Replica placed on a full datanode %v", target)
+	}
+
+	// datanodes which stop reporting their capacity are assumed to have room
+	nn.updateCapacity(nn.datanodemap["DN1"], Packet{})
+	if target := nn.chooseTarget([]BlockHeader{{"DN2", "/out.txt", 100, 0, 1, 0, "", 0, ""}}); target == nil || target.ID != "DN1" {
+		t.Errorf("Expected DN1 as target, got %v", target)
+	}
+}
@@ -0,0 +1,231 @@
+package namenode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// time a namenode has to serve or accept an image or its edits
+const checkpointTimeout = time.Minute
+
+// ServeImage serves the metadata file for GET, and for PUT replaces it with
+// a checkpoint, dropping the edits the checkpoint includes from the edit log
+func (nn *NameNode) ServeImage(w http.ResponseWriter, r *http.Request) {
+	if nn.metadatafile == "" {
+		http.Error(w, "No metadata file is kept", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		f, err := os.Open(nn.metadatafile)
+		if os.IsNotExist(err) {
+			// a namenode which never saved its namespace starts from nothing
+			writeWebHDFS(w, metadataImage{})
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/json")
+		io.Copy(w, f)
+
+	case "PUT":
+		var img metadataImage
+		err := json.NewDecoder(r.Body).Decode(&img)
+		if err != nil {
+			http.Error(w, "Invalid image "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		nn.update(func() { err = nn.installCheckpoint(img) })
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Image is read with GET and replaced with PUT", http.StatusMethodNotAllowed)
+	}
+}
+
+// installCheckpoint saves img as the metadata file and drops the edits it
+// includes from the edit log. The checkpoint must be newer than the metadata
+// file, and may not include edits the namenode has not made.
+func (nn *NameNode) installCheckpoint(img metadataImage) error {
+	if img.EditSeq <= nn.imageSeq || img.EditSeq > nn.editSeq {
+		return errors.New("Checkpoint at edit " + strconv.FormatInt(img.EditSeq, 10) + " is not after the metadata file at " +
+			strconv.FormatInt(nn.imageSeq, 10) + " and up to the last edit " + strconv.FormatInt(nn.editSeq, 10))
+	}
+
+	// the image is replaced whole, so a crash leaves the old one or the new
+	tmp := nn.metadatafile + ".checkpoint"
+	err := WriteJSON(tmp, img)
+	if err == nil {
+		err = os.Rename(tmp, nn.metadatafile)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	nn.imageSeq = img.EditSeq
+	nn.metaLog.Info("Installed checkpoint", "file", nn.metadatafile, "seq", img.EditSeq)
+	return nn.truncateEditLog(img.EditSeq)
+}
+
+// truncateEditLog drops the edits up to seq from the edit log
+func (nn *NameNode) truncateEditLog(seq int64) error {
+	if nn.editLogPath == "" {
+		return nil
+	}
+	f, err := os.Open(nn.editLogPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	edits, err := readEdits(f, seq)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	tmp := nn.editLogPath + ".checkpoint"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(out)
+	for _, e := range edits {
+		if err == nil {
+			err = encoder.Encode(e)
+		}
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	out.Close()
+	if err == nil {
+		err = os.Rename(tmp, nn.editLogPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// later edits are appended to the new file
+	if nn.editLog != nil {
+		nn.closeEditLog()
+		err = nn.openEditLog()
+	}
+	nn.metaLog.Info("Truncated edit log", "file", nn.editLogPath, "seq", seq, "edits", len(edits))
+	return err
+}
+
+// Checkpoint merges the edit log of the namenode whose HTTP server is at
+// address into the namespace it last saved, and uploads the result, so the
+// namenode replaces its metadata file and drops the edits merged. It
+// returns the number of edits merged, doing nothing if there are none.
+func Checkpoint(address string) (int, error) {
+	c := http.Client{Timeout: checkpointTimeout}
+	var img metadataImage
+	resp, err := c.Get("http://" + address + "/image")
+	if err != nil {
+		return 0, err
+	}
+	err = checkpointResponse(resp)
+	if err == nil {
+		err = json.NewDecoder(resp.Body).Decode(&img)
+	}
+	resp.Body.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err = c.Get("http://" + address + "/editlog?since=" + strconv.FormatInt(img.EditSeq, 10))
+	if err != nil {
+		return 0, err
+	}
+	var edits []edit
+	err = checkpointResponse(resp)
+	if err == nil {
+		edits, err = readEdits(resp.Body, img.EditSeq)
+	}
+	resp.Body.Close()
+	if err != nil || len(edits) == 0 {
+		return 0, err
+	}
+
+	// the namespace is rebuilt as a restarted namenode would
+	nn := New()
+	err = nn.restoreImage(img)
+	if err != nil {
+		return 0, err
+	}
+	nn.editSeq = img.EditSeq
+	for _, e := range edits {
+		nn.applyEdit(e)
+	}
+
+	body, err := json.Marshal(nn.image())
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest("PUT", "http://"+address+"/image", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	err = checkpointResponse(resp)
+	resp.Body.Close()
+	if err != nil {
+		return 0, err
+	}
+	return len(edits), nil
+}
+
+// checkpointResponse returns the error a namenode answered with, if any
+func checkpointResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return errors.New("Namenode answered " + resp.Status + ": " + strings.TrimSpace(string(msg)))
+}
+
+// RunCheckpointer checkpoints the namenode whose HTTP server is at address
+// every interval, logging the outcome, until stop is closed
+func RunCheckpointer(address string, interval time.Duration, stop <-chan struct{}) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		n, err := Checkpoint(address)
+		if err != nil {
+			log.Println("Checkpoint of", address, "failed:", err)
+		} else if n > 0 {
+			log.Println("Checkpointed", n, "edits of", address)
+		}
+		select {
+		case <-tick.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package namenode
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+
+	dir := t.TempDir()
+	nn := New()
+	nn.metadatafile = filepath.Join(dir, "metadata.json")
+	nn.editLogPath = filepath.Join(dir, "edits.log")
+	if err := nn.LoadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(nn.Handler())
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/dir/a.txt", 1, 0, 1, 0, "", 0, ""})
+	var r Packet
+	nn.handleNamespace(Packet{SRC: "C", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/b"}}}, &r)
+	nn.handleNamespace(Packet{SRC: "C", CMD: SETXATTR, Message: "user.k", Headers: []BlockHeader{{Filename: "/b"}},
+		Status: []FileStatus{{XAttrs: map[string][]byte{"user.k": []byte("v")}}}}, &r)
+
+	n, err := Checkpoint(address)
+	if err != nil || n != 3 {
+		t.Fatalf("Checkpointed %d edits: %v", n, err)
+	}
+	if nn.imageSeq != 3 {
+		t.Errorf("Metadata file at edit %d", nn.imageSeq)
+	}
+	if st, err := os.Stat(nn.editLogPath); err != nil || st.Size() != 0 {
+		t.Errorf("Edit log not truncated %v", err)
+	}
+	if n, err := Checkpoint(address); err != nil || n != 0 {
+		t.Errorf("Checkpointed %d edits without any new edits: %v", n, err)
+	}
+
+	// edits after the checkpoint are appended to the truncated log, and a
+	// reader of the dropped edits is refused
+	nn.handleNamespace(Packet{SRC: "C", CMD: RENAME, Headers: []BlockHeader{{Filename: "/dir/a.txt"}}, Renamed: []BlockHeader{{Filename: "/b/a.txt"}}}, &r)
+	standby := New()
+	standby.activeAddress = address
+	if _, err := standby.fetchEdits(0); err == nil {
+		t.Errorf("Dropped edits read")
+	}
+	if err := nn.installCheckpoint(metadataImage{EditSeq: 2}); err == nil {
+		t.Errorf("Older checkpoint installed")
+	}
+
+	nn.closeEditLog()
+	restarted := New()
+	restarted.metadatafile = nn.metadatafile
+	restarted.editLogPath = nn.editLogPath
+	if err := restarted.LoadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.closeEditLog()
+	if restarted.editSeq != 4 || restarted.lookup("/b/a.txt") == nil || restarted.lookup("/dir/a.txt") != nil {
+		t.Fatalf("Wrong namespace at edit %d after restarting\n%s", restarted.editSeq, restarted.ListFiles())
+	}
+	if v, err := restarted.GetXAttr("/b", "user.k"); err != nil || string(v) != "v" {
+		t.Errorf("Checkpointed attribute lost %q %v", v, err)
+	}
+}
@@ -0,0 +1,157 @@
+package namenode
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestChunks(t *testing.T) {
+
+	var buf bytes.Buffer
+	e := newChunkEncoder(newFrameEncoder(&buf), 10)
+	data := make([]byte, 25)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	h := BlockHeader{"DN1", "/f", 25, 0, 1, 1, "", 0, ""}
+	e.Encode(Packet{SRC: "NN", DST: "DN1", CMD: BLOCK, RequestID: 3, Data: Block{h, data}})
+	e.Encode(&Packet{SRC: "NN", DST: "DN1", CMD: HB})
+
+	// the parts are sent as packets of their own, and read as one
+	var parts []Packet
+	raw := newFrameDecoder(bytes.NewReader(buf.Bytes()))
+	for {
+		var p Packet
+		if raw.Decode(&p) != nil {
+			break
+		}
+		parts = append(parts, p)
+	}
+	if len(parts) != 4 || parts[0].Chunked != 15 || parts[1].CMD != CHUNK || len(parts[2].Data.Data) != 5 || parts[3].CMD != HB {
+		t.Fatalf("Unexpected parts %v", parts)
+	}
+	d := newChunkDecoder(newFrameDecoder(bytes.NewReader(buf.Bytes())))
+	var p Packet
+	if err := d.Decode(&p); err != nil || p.CMD != BLOCK || p.Chunked != 0 || p.Data.Header != h || !bytes.Equal(p.Data.Data, data) {
+		t.Errorf("Block not put back together %v %s", p, err)
+	}
+	if err := d.Decode(&p); err != nil || p.CMD != HB {
+		t.Errorf("Packet after a Block not read %v %s", p, err)
+	}
+
+	// a Block too long is skipped, keeping the rest of its Packet
+	maxBlockSize = 20
+	defer func() { maxBlockSize = 1 << 30 }()
+	d = newChunkDecoder(newFrameDecoder(bytes.NewReader(buf.Bytes())))
+	if err := d.Decode(&p); !isTooLong(err) || !isBadFrame(err) || p.RequestID != 3 || p.Data.Data != nil {
+		t.Errorf("Block too long read as %v %v", p, err)
+	}
+	if err := d.Decode(&p); err != nil || p.CMD != HB {
+		t.Errorf("Packet after a Block too long not read %v %s", p, err)
+	}
+	maxBlockSize = 1 << 30
+
+	// a packet in place of a part loses the Block, but not itself, and
+	// parts of no Block are skipped
+	var broken bytes.Buffer
+	fe := newFrameEncoder(&broken)
+	fe.Encode(parts[0])
+	fe.Encode(parts[3])
+	fe.Encode(parts[1])
+	d = newChunkDecoder(newFrameDecoder(&broken))
+	if err := d.Decode(&p); !isBadFrame(err) {
+		t.Errorf("Block missing parts read %v %v", p, err)
+	}
+	if err := d.Decode(&p); err != nil || p.CMD != HB {
+		t.Errorf("Packet interrupting a Block lost %v %s", p, err)
+	}
+	if err := d.Decode(&p); !isBadFrame(err) {
+		t.Errorf("Part of no Block read %v %v", p, err)
+	}
+
+	// nodes agreeing to the feature are sent parts no longer than they accept
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	if _, ok := chunked(local, Hello{}).(*chunkedConn); ok {
+		t.Errorf("Blocks sent in parts to a node without the feature")
+	}
+	c, ok := chunked(local, Hello{Features: []string{"chunks"}, MaxPacketSize: 1 << 20}).(*chunkedConn)
+	if !ok || c.size != chunkSize(1<<20) || c.size <= 0 {
+		t.Errorf("Unexpected part size %v", c)
+	}
+	if _, ok := newPacketEncoder(c).(*chunkEncoder); !ok {
+		t.Errorf("Blocks not sent in parts to a chunked connection")
+	}
+}
+
+func TestPacketLimits(t *testing.T) {
+
+	maxPacketSize = 1000
+	defer func() { maxPacketSize = 1 << 28 }()
+
+	// a JSON value too long fails the connection before it is buffered
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(Packet{SRC: "C", CMD: STAT})
+	json.NewEncoder(&buf).Encode(Packet{SRC: "C", CMD: STAT, Message: strings.Repeat("x", 2000)})
+	d := newJSONDecoder(&buf)
+	var p Packet
+	if err := d.Decode(&p); err != nil || p.CMD != STAT {
+		t.Errorf("Short packet not read %v %s", p, err)
+	}
+	if err := d.Decode(&p); !isTooLong(err) || isBadFrame(err) {
+		t.Errorf("Long packet read %s", err)
+	}
+
+	// a frame too long is skipped, keeping its Packet if that fits
+	buf.Reset()
+	e := newFrameEncoder(&buf)
+	e.Encode(Packet{SRC: "C", CMD: STAT, RequestID: 5, Data: Block{Data: make([]byte, 2000)}})
+	e.Encode(Packet{SRC: "C", CMD: STAT, RequestID: 6, Message: strings.Repeat("x", 2000)})
+	e.Encode(Packet{SRC: "C", CMD: HB})
+	fd := newFrameDecoder(&buf)
+	if err := fd.Decode(&p); !isTooLong(err) || !isBadFrame(err) || p.RequestID != 5 || p.Data.Data != nil {
+		t.Errorf("Long frame read as %v %v", p, err)
+	}
+	if err := fd.Decode(&p); !isTooLong(err) || p.RequestID != 0 {
+		t.Errorf("Long frame read as %v %v", p, err)
+	}
+	if err := fd.Decode(&p); err != nil || p.CMD != HB {
+		t.Errorf("Frame after long frames not read %v %s", p, err)
+	}
+
+	// clients are answered why their request was refused
+	nn := New()
+	nn.Mkdir("/dir", false)
+	local, remote := net.Pipe()
+	defer local.Close()
+	go nn.HandleConnection(remote)
+	go func() {
+		e := newFrameEncoder(local)
+		e.Encode(Packet{SRC: "C", DST: "NN", CMD: STAT, RequestID: 1, Headers: []BlockHeader{{Filename: "/dir"}}})
+		e.Encode(Packet{SRC: "C", DST: "NN", CMD: STAT, RequestID: 2, Headers: []BlockHeader{{Filename: "/dir"}}, Data: Block{Data: make([]byte, 2000)}})
+	}()
+	fd = newFrameDecoder(local)
+	for _, id := range []int64{1, 2} {
+		var r Packet
+		if err := fd.Decode(&r); err != nil || r.RequestID != id {
+			t.Fatalf("Unexpected answer %v %v", r, err)
+		}
+		if id == 2 && (r.CMD != ERROR || !strings.Contains(r.Message, "longer than 1000 bytes")) {
+			t.Errorf("Long request answered with %v", r)
+		}
+	}
+
+	b := Block{BlockHeader{"", "/dir/f", 4096, 0, 1, 0, "", 0, ""}, nil}
+	maxBlockSize = 4000
+	defer func() { maxBlockSize = 1 << 30 }()
+	if _, err := nn.AssignBlock(b); err == nil {
+		t.Errorf("Block longer than the limit assigned")
+	}
+	if _, err := nn.SetBlockSize(8192); err == nil {
+		t.Errorf("Block size longer than the limit set")
+	}
+}
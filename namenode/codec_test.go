@@ -0,0 +1,148 @@
+package namenode
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+
+	p := Packet{SRC: "NN", DST: "DN1", CMD: BLOCK, Message: "hello", RequestID: -3, Flags: 1 << 40,
+		Data:     Block{BlockHeader{"DN1", "/codec/data", 4, 2, 3, 7, "snappy", 1 << 33, ""}, []byte("data")},
+		Headers:  []BlockHeader{{"DN2", "/codec/data", 4, 0, 3, 7, "", 1, ""}, {"DN3", "/codec/data", 0, 1, 3, 7, "", 2, ""}},
+		Status:   []FileStatus{{Path: "/codec", IsDir: true, Key: []byte{1, 2}, XAttrs: map[string][]byte{"user.a": []byte("b")}}},
+		Hello:    &Hello{Version: 2, Features: []string{"frames"}, Codecs: []string{"msgpack"}},
+		Cache:    &CacheStats{Capacity: 1 << 20, Hits: 5},
+		Commands: []Packet{{CMD: RENAME, Storages: []string{"SSD", "DISK"}}},
+		Locations: []BlockLocation{{BlockNum: 1, Offset: 4, GenStamp: 7, BlockID: 2,
+			Replicas: []ReplicaLocation{{DatanodeID: "DN2", Cached: true}}}},
+	}
+	for _, c := range frameCodecs {
+		var buf bytes.Buffer
+		if err := (&frameEncoder{&buf, codecVersion(c.name)}).Encode(p); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		var got Packet
+		decoder := newFrameDecoder(&buf)
+		decoder.codec = codecVersion(c.name)
+		if err := decoder.Decode(&got); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if !reflect.DeepEqual(got, p) {
+			t.Errorf("%s: expected %+v, got %+v", c.name, p, got)
+		}
+	}
+
+	// frames of an unknown codec, or of one the peer did not choose, are
+	// skipped
+	for _, version := range []byte{byte(len(frameCodecs) + 1), codecVersion("gob")} {
+		var buf bytes.Buffer
+		(&frameEncoder{&buf, codecVersion("gob")}).Encode(Packet{CMD: HB})
+		frame := buf.Bytes()
+		frame[4] = version
+		var got Packet
+		if err := newFrameDecoder(bytes.NewReader(frame)).Decode(&got); !isBadFrame(err) {
+			t.Errorf("Expected a bad frame of version %d, got %v", version, err)
+		}
+	}
+}
+
+func TestCodecNegotiation(t *testing.T) {
+
+	nn := New()
+	local, remote := net.Pipe()
+	defer local.Close()
+	go nn.HandleConnection(remote)
+
+	hello := Hello{Version: 2, MinVersion: 2, Codecs: []string{"cbor", "msgpack", "gob"}}
+	go newFrameEncoder(local).Encode(Packet{SRC: "DN1", DST: "NN", CMD: HELLO, Hello: &hello})
+	decoder := newFrameDecoder(local)
+	var r Packet
+	if err := decoder.Decode(&r); err != nil || r.CMD != HELLO || r.Hello.Codec != "msgpack" {
+		t.Fatalf("Expected msgpack to be chosen, got %v %v", r.Hello, err)
+	}
+
+	// the answers which follow are in the codec chosen
+	decodedWith(decoder, r.Hello.Codec)
+	go (&frameEncoder{local, codecVersion("msgpack")}).Encode(Packet{SRC: "DN1", DST: "NN", CMD: HB})
+	header, err := decoder.r.Peek(frameHeaderSize)
+	if err != nil || header[4] != codecVersion("msgpack") {
+		t.Fatalf("Expected a msgpack frame, got %v %v", header, err)
+	}
+	if err := decoder.Decode(&r); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	// nodes sending JSON values keep to them
+	r, _ = connect(t, nn, Packet{SRC: "DN2", DST: "NN", CMD: HELLO, Hello: &hello})
+	if r.CMD != HELLO || r.Hello.Codec != "" {
+		t.Errorf("Expected no codec for a JSON connection, got %v", r.Hello)
+	}
+}
+
+func TestCodecAllowlist(t *testing.T) {
+
+	// gob is not chosen unless the namenode is configured to accept it
+	nn := New()
+	offer := &Hello{Version: 2, MinVersion: 2, Codecs: []string{"gob", "msgpack"}}
+	if agreed, err := nn.negotiate(offer); err != nil || agreed.Codec != "msgpack" {
+		t.Errorf("Expected msgpack rather than gob, got %v %v", agreed.Codec, err)
+	}
+	nn.codecs = []string{"json", "gob"}
+	if agreed, err := nn.negotiate(offer); err != nil || agreed.Codec != "gob" {
+		t.Errorf("Expected gob once accepted, got %v %v", agreed.Codec, err)
+	}
+	nn.codecs = []string{"json"}
+	if agreed, err := nn.negotiate(offer); err != nil || agreed.Codec != "" {
+		t.Errorf("Expected no codec, got %v %v", agreed.Codec, err)
+	}
+}
+
+// BenchmarkBlockTransfer frames a 64 KB Block under each codec, whose data
+// is sent raw whichever the codec
+func BenchmarkBlockTransfer(b *testing.B) {
+	data := make([]byte, 64<<10)
+	p := Packet{SRC: "NN", DST: "DN1", CMD: BLOCK, RequestID: 1,
+		Data: Block{BlockHeader{"DN1", "/bench/data", len(data), 3, 10, 7, "", 42, ""}, data}}
+	benchmarkCodecs(b, p, int64(len(data)))
+}
+
+// BenchmarkBlockReport frames a report of 1000 Blocks under each codec,
+// where the codec is most of the work
+func BenchmarkBlockReport(b *testing.B) {
+	p := Packet{SRC: "DN1", DST: "NN", CMD: BLOCKREPORT, ReportID: 9}
+	for i := 0; i < 1000; i++ {
+		p.Headers = append(p.Headers, BlockHeader{"DN1", "/bench/report/part-00017", 1 << 26, i, 1000, 12, "", int64(i + 1), ""})
+	}
+	benchmarkCodecs(b, p, 0)
+}
+
+func benchmarkCodecs(b *testing.B, p Packet, size int64) {
+	for _, c := range frameCodecs {
+		b.Run(c.name, func(b *testing.B) {
+			var buf bytes.Buffer
+			encoder := &frameEncoder{&buf, codecVersion(c.name)}
+			decoder := newFrameDecoder(&buf)
+			decoder.codec = encoder.version
+			encoder.Encode(p)
+			n := buf.Len()
+			buf.Reset()
+			if size > 0 {
+				b.SetBytes(size)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := encoder.Encode(p); err != nil {
+					b.Fatal(err)
+				}
+				var r Packet
+				if err := decoder.Decode(&r); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(n), "bytes/frame")
+		})
+	}
+}
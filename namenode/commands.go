@@ -0,0 +1,99 @@
+package namenode
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// queueCommand keeps the command p for its datanode, which is sent it with
+// the answer to its next heartbeat
+func (nn *NameNode) queueCommand(p Packet) {
+	nn.commandLock.Lock()
+	defer nn.commandLock.Unlock()
+
+	nn.commands[p.DST] = append(nn.commands[p.DST], p)
+}
+
+// takeCommands returns the commands queued for a datanode, which are sent
+// once
+func (nn *NameNode) takeCommands(datanodeID string) []Packet {
+	nn.commandLock.Lock()
+	defer nn.commandLock.Unlock()
+
+	commands := nn.commands[datanodeID]
+	delete(nn.commands, datanodeID)
+	return commands
+}
+
+// heartbeatCommands gathers what a datanode taking commands with its
+// heartbeats should do next, which it carries out in order. A datanode whose
+// reports are not up to date sends a full report first, after which it is
+// sent the renames and invalidations waiting for it, repeated until it
+// acknowledges them, then the commands queued for it.
+func (nn *NameNode) heartbeatCommands(dn *datanode, listed bool, reportID int64) []Packet {
+	var commands []Packet
+	if !listed || reportID != dn.lastReport || dn.reportRequested {
+		commands = append(commands, Packet{SRC: nn.id, DST: dn.ID, CMD: LIST})
+		dn.reportRequested = false
+	} else {
+		// renames go first, as invalidations may refer to the new names
+		if len(nn.PendingRenames(dn.ID)) > 0 {
+			commands = append(commands, nn.renamePacket(dn.ID))
+		}
+		if pending := nn.PendingInvalidations(dn.ID); len(pending) > 0 {
+			commands = append(commands, Packet{SRC: nn.id, DST: dn.ID, CMD: INVALIDATE, Headers: pending})
+		}
+	}
+	return append(commands, nn.takeCommands(dn.ID)...)
+}
+
+// ShutdownDatanode asks the datanode id to stop with the answer to its next
+// heartbeat
+func (nn *NameNode) ShutdownDatanode(id string) (string, error) {
+	dn, ok := nn.datanodemap[id]
+	if !ok {
+		return "", errors.New("Unknown datanode " + id)
+	}
+	if !dn.supports("commands") {
+		return "", errors.New("Datanode " + id + " does not take commands with its heartbeats")
+	}
+	nn.queueCommand(Packet{SRC: nn.id, DST: id, CMD: SHUTDOWN})
+	return "Shutdown requested from " + id, nil
+}
+
+// SetTransferBandwidth caps the bytes per second each connected datanode
+// sends when copying replicas to other datanodes, for re-replication and the
+// balancer, with the answers to their next heartbeats. A bandwidth of 0 lifts
+// the cap. Datanodes keep the new cap until they restart.
+func (nn *NameNode) SetTransferBandwidth(bandwidth int64) (string, error) {
+	if bandwidth < 0 {
+		return "", errors.New("Bandwidth must not be negative")
+	}
+	set := 0
+	skipped := make([]string, 0)
+	for id, dn := range nn.datanodemap {
+		if nn.offline[id] {
+			continue
+		}
+		if !dn.supports("commands") {
+			skipped = append(skipped, id)
+			continue
+		}
+		nn.queueCommand(Packet{SRC: nn.id, DST: id, CMD: SETBANDWIDTH, Message: strconv.FormatInt(bandwidth, 10)})
+		set++
+	}
+	nn.log.Info("Setting transfer bandwidth", "bandwidth", bandwidth, "datanodes", set)
+
+	limit := strconv.FormatInt(bandwidth, 10) + " bytes per second"
+	if bandwidth == 0 {
+		limit = "unlimited"
+	}
+	message := "Transfer bandwidth of " + strconv.Itoa(set) + " datanodes set to " + limit
+	if len(skipped) > 0 {
+		sort.Strings(skipped)
+		message += ", datanodes not taking commands left alone: " + strings.Join(skipped, ", ")
+	}
+	return message, nil
+}
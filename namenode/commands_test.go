@@ -0,0 +1,92 @@
+package namenode
+
+import (
+	"strings"
+	"testing"
+)
+
+// commandNamesOf lists the commands of a heartbeat's answer
+func commandNamesOf(commands []Packet) []string {
+	names := make([]string, 0, len(commands))
+	for _, c := range commands {
+		names = append(names, CommandName(c.CMD))
+	}
+	return names
+}
+
+func TestHeartbeatCommands(t *testing.T) {
+
+	nn := New()
+	dn := &datanode{ID: "DN1", features: []string{"replicate", "commands"}}
+	nn.datanodemap["DN1"] = dn
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", listed: true, httpAddr: "localhost:50075"}
+	nn.datanodemap["DN3"] = &datanode{ID: "DN3", listed: true}
+	h := BlockHeader{"DN1", "/out.txt", 10, 0, 1, 0, "", 0, ""}
+	nn.MergeNode(h)
+
+	// a datanode which has not reported sends a full report first, and copies
+	// ordered meanwhile are sent with the same answer
+	nn.move(h, "DN2")
+	if c := nn.heartbeatCommands(dn, false, 0); len(c) != 2 || c[0].CMD != LIST || c[1].CMD != REPLICATE || c[1].Message != "DN2" {
+		t.Fatalf("Wrong commands before a full report %v", commandNamesOf(c))
+	}
+
+	// invalidations are repeated until acknowledged, queued commands sent once
+	nn.Invalidate(BlockHeader{"DN1", "/old.txt", 10, 0, 1, 0, "", 0, ""})
+	if _, err := nn.ShutdownDatanode("DN1"); err != nil {
+		t.Fatal(err)
+	}
+	if c := nn.heartbeatCommands(dn, true, 0); len(c) != 2 || c[0].CMD != INVALIDATE || c[1].CMD != SHUTDOWN {
+		t.Errorf("Wrong commands %v", commandNamesOf(c))
+	}
+	if c := nn.heartbeatCommands(dn, true, 0); len(c) != 1 || c[0].CMD != INVALIDATE {
+		t.Errorf("Wrong repeated commands %v", commandNamesOf(c))
+	}
+
+	// datanodes without the command channel cannot be asked to shut down
+	if _, err := nn.ShutdownDatanode("DN3"); err == nil {
+		t.Errorf("Shutdown queued for a datanode without commands")
+	}
+	if _, err := nn.ShutdownDatanode("DN4"); err == nil {
+		t.Errorf("Shutdown queued for an unknown datanode")
+	}
+}
+
+func TestSetTransferBandwidth(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", features: []string{"commands"}}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2"}
+	nn.datanodemap["DN3"] = &datanode{ID: "DN3", features: []string{"commands"}}
+	nn.offline["DN3"] = true
+
+	message, err := nn.SetTransferBandwidth(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(message, "1 datanodes") || !strings.Contains(message, "DN2") {
+		t.Errorf("Unexpected answer %q", message)
+	}
+	if c := nn.takeCommands("DN1"); len(c) != 1 || c[0].CMD != SETBANDWIDTH || c[0].Message != "1048576" {
+		t.Errorf("Wrong commands %v", c)
+	}
+	if len(nn.takeCommands("DN2")) != 0 || len(nn.takeCommands("DN3")) != 0 {
+		t.Errorf("Bandwidth sent to a datanode without commands or offline")
+	}
+	if _, err := nn.SetTransferBandwidth(-1); err == nil {
+		t.Errorf("Negative bandwidth accepted")
+	}
+}
+
+func TestCommandNumbers(t *testing.T) {
+
+	// commands are sent by number, so those of older nodes keep theirs
+	for cmd, n := range map[int]int{TENANTS: 66, CHUNK: 67, GETREPORT: 68} {
+		if cmd != n {
+			t.Errorf("%s is %d, expected %d", CommandName(cmd), cmd, n)
+		}
+	}
+	if len(commandNames) != CHECKSUMACK+1 || CommandName(CHUNK) != "CHUNK" || CommandName(CHECKSUMACK) != "CHECKSUMACK" {
+		t.Errorf("Command names do not match the commands")
+	}
+}
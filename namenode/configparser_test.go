@@ -6,24 +6,25 @@ import (
 
 func TestValidXML(t *testing.T) {
 
-	err := ParseConfigXML("examplenamenode.xml")
+	nn := New()
+	err := nn.ParseConfigXML("examplenamenode.xml")
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 
-	if id != "NN" {
+	if nn.id != "NN" {
 		t.Errorf("Config did not set id correctly")
 	}
 
-	if host != "localhost" {
+	if nn.host != "localhost" {
 		t.Errorf("Config did not set host correctly")
 	}
 
-	if port != "8080" {
+	if nn.port != "8080" {
 		t.Errorf("Config did not set port correctly")
 	}
 
-	if SIZEOFBLOCK != 4096 {
+	if nn.sizeofblock != 4096 {
 		t.Errorf("Config did not set SIZEOFBLOCK correctly")
 	}
 
@@ -0,0 +1,65 @@
+package namenode
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// reasons connections are refused, as counted in the metrics
+const (
+	refusedConnections     = "total"
+	refusedHostConnections = "host"
+)
+
+// admitConn returns an error if a new connection would pass maxConnections,
+// or maxConnectionsPerIP for its host, and otherwise counts it as open. The
+// caller must hold nn.mu.
+func (nn *NameNode) admitConn(conn net.Conn) error {
+	host := remoteHost(conn.RemoteAddr().String())
+	if nn.maxConnections > 0 && len(nn.conns) >= nn.maxConnections {
+		nn.metrics.countConnRefused(refusedConnections)
+		return errors.New(strconv.Itoa(nn.maxConnections) + " connections are open")
+	}
+	if nn.maxConnectionsPerIP > 0 && nn.hostConns[host] >= nn.maxConnectionsPerIP {
+		nn.metrics.countConnRefused(refusedHostConnections)
+		return errors.New(strconv.Itoa(nn.maxConnectionsPerIP) + " connections are open from " + host)
+	}
+	nn.conns[conn] = true
+	nn.hostConns[host]++
+	return nil
+}
+
+// closedConn stops counting a connection as open. The caller must hold nn.mu.
+func (nn *NameNode) closedConn(conn net.Conn) {
+	host := remoteHost(conn.RemoteAddr().String())
+	delete(nn.conns, conn)
+	if nn.hostConns[host]--; nn.hostConns[host] <= 0 {
+		delete(nn.hostConns, host)
+	}
+}
+
+// awaitPacket sets the time by which a connection must send its next packet
+// before it is closed as idle
+func (nn *NameNode) awaitPacket(conn net.Conn) {
+	if nn.idleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(nn.idleTimeout))
+	}
+}
+
+// isIdle reports whether a read failed as the connection sent no packet
+// within the idle timeout
+func (nn *NameNode) isIdle(err error) bool {
+	var netErr net.Error
+	return nn.idleTimeout > 0 && errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// closeIdle closes a connection which sent no packet within the idle
+// timeout, whose reader then drops the queue of packets for its node src
+func (nn *NameNode) closeIdle(src string, conn net.Conn) {
+	atomic.AddInt64(&nn.idleClosed, 1)
+	nn.connLog.Info("Closing idle connection", "src", src, "remote", conn.RemoteAddr().String(), "timeout", nn.idleTimeout)
+	conn.Close()
+}
@@ -0,0 +1,95 @@
+package namenode
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectionLimits(t *testing.T) {
+
+	nn := New()
+	nn.maxConnections = 3
+	nn.maxConnectionsPerIP = 2
+	nn.idleTimeout = 200 * time.Millisecond
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	go nn.Serve(l)
+	defer nn.Shutdown(context.Background())
+
+	// a connection beyond the limit of its host is closed at once
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+	conns[2].SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conns[2].Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Errorf("Connection beyond the limit kept open %v", err)
+	}
+	nn.mu.Lock()
+	open, host := len(nn.conns), nn.hostConns["127.0.0.1"]
+	nn.mu.Unlock()
+	if open != 2 || host != 2 {
+		t.Errorf("Counted %d connections and %d from the host", open, host)
+	}
+
+	// a connection sending packets is kept, and an idle one closed
+	json.NewEncoder(conns[1]).Encode(Packet{SRC: "C", DST: "NN", CMD: LIST, RequestID: 1})
+	decoder := json.NewDecoder(conns[1])
+	var r Packet
+	if err := decoder.Decode(&r); err != nil || r.RequestID != 1 {
+		t.Fatalf("No answer %v %v", r, err)
+	}
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		json.NewEncoder(conns[1]).Encode(Packet{SRC: "C", DST: "NN", CMD: HB})
+	}
+	conns[0].SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conns[0].Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Errorf("Idle connection kept open %v", err)
+	}
+	nn.sendMapLock.Lock()
+	_, ok := nn.sendMap["C"]
+	nn.sendMapLock.Unlock()
+	if !ok {
+		t.Errorf("Queue of the active connection removed")
+	}
+
+	// the connection closed as idle takes its queue with it
+	time.Sleep(400 * time.Millisecond)
+	nn.sendMapLock.Lock()
+	_, ok = nn.sendMap["C"]
+	nn.sendMapLock.Unlock()
+	if ok {
+		t.Errorf("Queue of an idle connection kept")
+	}
+
+	rec := httptest.NewRecorder()
+	nn.ServeMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`godfs_connections_refused_total{reason="host"} 1`,
+		"godfs_connections_idle_closed_total 2",
+		"godfs_connections_open 0",
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("Missing metric %s", line)
+		}
+	}
+}
+
+// isTimeout reports whether a read failed for its deadline
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
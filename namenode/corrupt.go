@@ -0,0 +1,29 @@
+package namenode
+
+// ReportCorrupt handles a datanode's report of replicas which failed
+// verification and which it deleted. Each is dropped from the filesystem,
+// and its Block copied again from a remaining replica.
+func (nn *NameNode) ReportCorrupt(dn *datanode, headers []BlockHeader, reason string) {
+	for _, h := range headers {
+		if h.DatanodeID != dn.ID {
+			continue
+		}
+		nn.metaLog.Warn("Corrupt replica reported", "header", h, "reason", reason)
+		nn.metrics.countCorrupt()
+		_, coded := nn.erasure[h.Filename]
+		nn.removeReplica(h)
+
+		remaining := nn.replicas(h.Filename, h.BlockNum)
+		if len(remaining) == 0 {
+			if coded {
+				nn.metaLog.Warn("Erasure coded Block lost, readers rebuild it from parity", "file", h.Filename, "block", h.BlockNum)
+			} else {
+				nn.metaLog.Error("No good replica of Block is left", "file", h.Filename, "block", h.BlockNum)
+			}
+			continue
+		}
+		if len(remaining) < nn.neededReplicas(h.Filename) {
+			nn.replicate(remaining, remaining[0])
+		}
+	}
+}
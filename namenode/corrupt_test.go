@@ -0,0 +1,38 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestCorruptReplica(t *testing.T) {
+
+	nn := New()
+	nn.replication = 2
+	for _, id := range []string{"DN1", "DN2", "DN3"} {
+		nn.datanodemap[id] = &datanode{ID: id, listed: true}
+	}
+	corrupt := BlockHeader{"DN1", "/out.txt", 1, 0, 1, 1, "", 0, ""}
+	good := BlockHeader{"DN2", "/out.txt", 1, 0, 1, 1, "", 0, ""}
+	nn.MergeNode(corrupt)
+	nn.MergeNode(good)
+
+	// reports only apply to the replicas of the reporting datanode
+	nn.HandlePacket(Packet{SRC: "DN2", DST: "NN", CMD: CORRUPTBLOCK, Headers: []BlockHeader{corrupt}})
+	if len(nn.replicas("/out.txt", 0)) != 2 {
+		t.Fatalf("Replica removed on another datanode's report")
+	}
+
+	nn.HandlePacket(Packet{SRC: "DN1", DST: "NN", CMD: CORRUPTBLOCK, Headers: []BlockHeader{corrupt}, Message: "Checksum mismatch"})
+	replicas := nn.replicas("/out.txt", 0)
+	if len(replicas) != 1 || replicas[0] != good {
+		t.Fatalf("Corrupt replica kept %v", replicas)
+	}
+	if o, ok := nn.replications[good]; !ok {
+		t.Errorf("Block was not replicated from the good copy")
+	} else if o.Target == "DN2" {
+		t.Errorf("Block replicated to a datanode holding it")
+	}
+	if nn.metrics.corrupt != 1 {
+		t.Errorf("Expected 1 corrupt replica counted, got %d", nn.metrics.corrupt)
+	}
+}
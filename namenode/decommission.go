@@ -0,0 +1,172 @@
+package namenode
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// time after which a replication which was not acknowledged is retried
+const replicationTimeout = time.Minute
+
+// replicationOrder is a copy of a replica to another datanode, sent by the
+// source datanode itself or forwarded when it returns the Block
+type replicationOrder struct {
+	Target string
+	Sent   time.Time
+	Move   bool // the source replica is deleted once the copy is acknowledged
+}
+
+// Decommission stops placing Blocks on a datanode and copies the Blocks it
+// holds to other datanodes. Once every Block is replicated elsewhere the
+// datanode is removed from the cluster. The returned message describes the
+// progress, so repeating the request reports on a decommission under way.
+func (nn *NameNode) Decommission(id string) (string, error) {
+	if nn.decommissioned[id] {
+		return "Datanode " + id + " is decommissioned", nil
+	}
+	dn, ok := nn.datanodemap[id]
+	if !ok {
+		return "", errors.New("No such datanode " + id)
+	}
+	if !dn.decommissioning {
+		dn.decommissioning = true
+		nn.log.Info("Decommissioning datanode", "datanode", id)
+	}
+
+	remaining := nn.checkDecommission(dn)
+	if remaining == 0 {
+		return "Datanode " + id + " is decommissioned", nil
+	}
+	return "Decommissioning " + id + ", " + strconv.Itoa(remaining) + " Blocks to replicate", nil
+}
+
+// checkDecommission replicates the Blocks which would have too few replicas
+// without a decommissioning datanode, removing the datanode once there are
+// none. It returns the number of Blocks still to replicate.
+func (nn *NameNode) checkDecommission(dn *datanode) int {
+	remaining := 0
+	nn.filemap.Range(func(path string, blocks map[int][]BlockHeader) bool {
+		needed := nn.neededReplicas(path)
+		if needed < 1 {
+			needed = 1
+		}
+		for _, replicas := range blocks {
+			var held *BlockHeader
+			available := 0
+			for i, h := range replicas {
+				if h.DatanodeID == dn.ID {
+					held = &replicas[i]
+				} else if other, ok := nn.datanodemap[h.DatanodeID]; ok && !other.decommissioning {
+					available++
+				}
+			}
+			if held == nil || available >= needed {
+				continue
+			}
+			remaining++
+			nn.replicate(replicas, *held)
+		}
+		return true
+	})
+
+	if remaining == 0 {
+		nn.completeDecommission(dn)
+	}
+	return remaining
+}
+
+// startReplication copies a Block to a datanode chosen by chooseTarget,
+// reading it from the preferred replica if that datanode is connected. Nothing
+// is done while an earlier copy of the Block is outstanding. It reports
+// whether a copy was started.
+func (nn *NameNode) startReplication(replicas []BlockHeader, preferred BlockHeader) bool {
+	nn.replicateLock.Lock()
+	defer nn.replicateLock.Unlock()
+
+	for _, h := range replicas {
+		if o, ok := nn.replications[h]; ok {
+			if time.Since(o.Sent) < replicationTimeout {
+				return false
+			}
+			delete(nn.replications, h)
+		}
+	}
+
+	source := preferred
+	if nn.offline[source.DatanodeID] {
+		found := false
+		for _, h := range replicas {
+			if _, ok := nn.datanodemap[h.DatanodeID]; ok && !nn.offline[h.DatanodeID] {
+				source, found = h, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	target := nn.chooseTarget(replicas)
+	if target == nil {
+		nn.placementLog.Warn("No datanode to replicate Block to", "file", source.Filename, "block", source.BlockNum)
+		return false
+	}
+
+	nn.placementLog.Debug("Replicating Block", "file", source.Filename, "block", source.BlockNum, "from", source.DatanodeID, "to", target.ID)
+	nn.sendReplication(source, target.ID, false)
+	return true
+}
+
+// takeReplication returns the datanode a Block returned by a datanode is to
+// be copied to, if it was retrieved for replication
+func (nn *NameNode) takeReplication(h BlockHeader) (string, bool) {
+	nn.replicateLock.Lock()
+	defer nn.replicateLock.Unlock()
+
+	o, ok := nn.replications[h]
+	if !ok {
+		return "", false
+	}
+	delete(nn.replications, h)
+	if o.Move {
+		copied := h
+		copied.DatanodeID = o.Target
+		nn.moving[copied] = pendingMove{h, time.Now()}
+	}
+	return o.Target, true
+}
+
+// completeDecommission removes a drained datanode and its replicas from the
+// cluster
+func (nn *NameNode) completeDecommission(dn *datanode) {
+	held := make([]BlockHeader, 0)
+	nn.filemap.Range(func(path string, blocks map[int][]BlockHeader) bool {
+		for _, replicas := range blocks {
+			for _, h := range replicas {
+				if h.DatanodeID == dn.ID {
+					held = append(held, h)
+				}
+			}
+		}
+		return true
+	})
+	for _, h := range held {
+		nn.removeReplica(h)
+	}
+
+	nn.invalidateLock.Lock()
+	delete(nn.invalidations, dn.ID)
+	nn.invalidateLock.Unlock()
+	nn.renameLock.Lock()
+	delete(nn.renames, dn.ID)
+	nn.renameLock.Unlock()
+	nn.sendMapLock.Lock()
+	delete(nn.held, dn.ID)
+	nn.sendMapLock.Unlock()
+
+	delete(nn.datanodemap, dn.ID)
+	delete(nn.offline, dn.ID)
+	nn.decommissioned[dn.ID] = true
+	nn.log.Info("Datanode decommissioned", "datanode", dn.ID)
+}
@@ -0,0 +1,56 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestDecommission(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", listed: true}
+	h := BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""}
+	nn.MergeNode(h)
+
+	message, err := nn.Decommission("DN1")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if message != "Decommissioning DN1, 1 Blocks to replicate" {
+		t.Errorf("Unexpected progress %q", message)
+	}
+	if _, ok := nn.replications[h]; !ok || nn.replications[h].Target != "DN2" {
+		t.Fatalf("Block was not replicated to DN2 %v", nn.replications)
+	}
+	for i := 0; i < 10; i++ {
+		p, err := nn.AssignBlock(Block{BlockHeader{"", "/new.txt", 1, 0, 1, 0, "", 0, ""}, []byte{1}})
+		if err != nil || p.DST != "DN2" {
+			t.Fatalf("Block placed on decommissioning datanode %v %v", p.DST, err)
+		}
+	}
+
+	// the retrieved Block is forwarded to the target
+	target, ok := nn.takeReplication(h)
+	if !ok || target != "DN2" {
+		t.Fatalf("Replication not pending")
+	}
+	copied := h
+	copied.DatanodeID = "DN2"
+	nn.MergeNode(copied)
+
+	message, err = nn.Decommission("DN1")
+	if err != nil || message != "Datanode DN1 is decommissioned" {
+		t.Errorf("Unexpected progress %q %v", message, err)
+	}
+	if _, ok := nn.datanodemap["DN1"]; ok || !nn.decommissioned["DN1"] {
+		t.Errorf("Datanode was not removed")
+	}
+	replicas := nn.replicas("/out.txt", 0)
+	if len(replicas) != 1 || replicas[0] != copied {
+		t.Errorf("Unexpected replicas %v", replicas)
+	}
+
+	if _, err := nn.Decommission("DN3"); err == nil {
+		t.Errorf("Decommissioned an unknown datanode")
+	}
+}
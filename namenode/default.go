@@ -0,0 +1,119 @@
+package namenode
+
+import (
+	"context"
+	"log"
+	"net"
+)
+
+// SIZEOFBLOCK mirrors the block size of the default namenode
+var SIZEOFBLOCK int //size of block in bytes
+
+// defaultNameNode backs the package level functions, which are kept
+// for callers written before the NameNode type existed. Unlike the methods
+// they call, they hold the namespace themselves.
+var defaultNameNode = New()
+
+// Init initializes the default namenode from the configuration file,
+// restoring any namespace saved by a previous run
+func Init(configpath string) {
+	defaultNameNode = New()
+
+	// Read config
+	err := ParseConfigXML(configpath)
+	if err != nil {
+		log.Fatal("Fatal error ", err.Error())
+	}
+
+	err = defaultNameNode.LoadMetadata()
+	if err != nil {
+		log.Fatal("Fatal error ", err.Error())
+	}
+}
+
+// Run starts the default namenode, returning once it has been shut down
+func Run(configpath string) {
+
+	// setup filesystem
+	Init(configpath)
+
+	err := defaultNameNode.ListenAndServe()
+	if err != nil {
+		log.Fatal("Fatal error ", err.Error())
+	}
+	<-defaultNameNode.Done()
+}
+
+// Shutdown gracefully stops the default namenode
+func Shutdown(ctx context.Context) error {
+	return defaultNameNode.Shutdown(ctx)
+}
+
+// ParseConfigXML configures the default namenode with the provided XML file
+func ParseConfigXML(configpath string) error {
+	err := defaultNameNode.ParseConfigXML(configpath)
+	SIZEOFBLOCK = defaultNameNode.sizeofblock
+	return err
+}
+
+// HandleBlockHeaders merges incoming BlockHeaders into the default namenode
+func HandleBlockHeaders() {
+	defaultNameNode.HandleBlockHeaders()
+}
+
+// ListFiles lists the filesystem of the default namenode
+func ListFiles() string {
+	var files string
+	defaultNameNode.view(func() { files = defaultNameNode.ListFiles() })
+	return files
+}
+
+// MergeNode adds a BlockHeader to the filesystem of the default namenode
+func MergeNode(h BlockHeader) error {
+	var err error
+	defaultNameNode.update(func() { err = defaultNameNode.MergeNode(h) })
+	return err
+}
+
+// AssignBlocks assigns Blocks to datanodes of the default namenode
+func AssignBlocks(bls []Block) {
+	defaultNameNode.update(func() { defaultNameNode.AssignBlocks(bls) })
+}
+
+// AssignBlock chooses a datanode of the default namenode for a Block
+func AssignBlock(b Block) (Packet, error) {
+	var p Packet
+	var err error
+	defaultNameNode.update(func() { p, err = defaultNameNode.AssignBlock(b) })
+	return p, err
+}
+
+// SendPacket enqueues a packet on the default namenode
+func SendPacket(p Packet) error {
+	return defaultNameNode.SendPacket(p)
+}
+
+// SetOutbound registers a connection with the default namenode
+func SetOutbound(nodeID string, conn net.Conn) {
+	defaultNameNode.SetOutbound(nodeID, conn)
+}
+
+// SendStats reports the outbound queues of the default namenode
+func SendStats() []SendQueueStats {
+	return defaultNameNode.SendStats()
+}
+
+// HandlePacket handles a packet on the default namenode
+func HandlePacket(p Packet) {
+	defaultNameNode.handle(p)
+}
+
+// CheckConnection adds or updates a connection to the default namenode
+func CheckConnection(conn net.Conn, p Packet) {
+	defaultNameNode.update(func() { defaultNameNode.CheckConnection(conn, p) })
+}
+
+// HandleConnection serves a connection on the default namenode
+func HandleConnection(conn net.Conn) {
+	defaultNameNode.HandleConnection(conn)
+}
@@ -0,0 +1,184 @@
+package namenode
+
+import (
+	"sync"
+)
+
+// defaults for handling the packets of connections
+const (
+	defaultHandlerCount     = 8  // workers handling packets
+	defaultHandlerQueueSize = 32 // packets queued per connection before it stops being read
+)
+
+// dispatcher queues the packets read from each connection and hands them to
+// a fixed pool of workers. Connections take turns, one packet at a time, so a
+// node sending many packets only delays its own, and the packets of a
+// connection are handled one after another, in the order they were read.
+type dispatcher struct {
+	mu      sync.Mutex
+	ready   *sync.Cond // signalled when a connection has a packet to handle
+	changed *sync.Cond // broadcast when a packet is taken or handled
+	queues  map[int64][]Packet
+	busy    map[int64]bool // connections with a packet being handled
+	turns   []int64        // connections with packets waiting, in the order they are served
+	limit   int            // packets queued per connection
+	closed  bool
+
+	start sync.Once
+}
+
+func newDispatcher() *dispatcher {
+	d := &dispatcher{queues: make(map[int64][]Packet), busy: make(map[int64]bool)}
+	d.ready = sync.NewCond(&d.mu)
+	d.changed = sync.NewCond(&d.mu)
+	return d
+}
+
+// submit queues a packet read from the connection conn, waiting while the
+// connection already has limit packets queued. It reports false once the
+// dispatcher is closed.
+func (d *dispatcher) submit(conn int64, p Packet) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for !d.closed && len(d.queues[conn]) >= d.limit {
+		d.changed.Wait()
+	}
+	if d.closed {
+		return false
+	}
+	waiting := len(d.queues[conn]) > 0
+	d.queues[conn] = append(d.queues[conn], p)
+	if !waiting && !d.busy[conn] {
+		d.turns = append(d.turns, conn)
+		d.ready.Signal()
+	}
+	return true
+}
+
+// next takes the packet of the connection whose turn it is, waiting for one.
+// It reports false once the dispatcher is closed and nothing is left.
+func (d *dispatcher) next() (int64, Packet, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for len(d.turns) == 0 {
+		if d.closed {
+			return 0, Packet{}, false
+		}
+		d.ready.Wait()
+	}
+	conn := d.turns[0]
+	d.turns = d.turns[1:]
+	p := d.queues[conn][0]
+	d.queues[conn] = d.queues[conn][1:]
+	d.busy[conn] = true
+	d.changed.Broadcast()
+	return conn, p, true
+}
+
+// done records that the packet taken from conn was handled, giving the
+// connection another turn if it has more packets
+func (d *dispatcher) done(conn int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.busy, conn)
+	if len(d.queues[conn]) > 0 {
+		d.turns = append(d.turns, conn)
+		d.ready.Signal()
+	} else {
+		delete(d.queues, conn)
+	}
+	d.changed.Broadcast()
+}
+
+// drain waits until every packet read from conn has been handled
+func (d *dispatcher) drain(conn int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for len(d.queues[conn]) > 0 || d.busy[conn] {
+		d.changed.Wait()
+	}
+}
+
+// pending counts the packets waiting for a worker
+func (d *dispatcher) pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := 0
+	for _, q := range d.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// close stops the workers once the queued packets are handled
+func (d *dispatcher) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = true
+	d.ready.Broadcast()
+	d.changed.Broadcast()
+}
+
+// dispatch queues a packet read from the connection conn for the workers,
+// starting them with the first packet
+func (nn *NameNode) dispatch(conn int64, p Packet) {
+	d := nn.dispatcher
+	d.start.Do(func() {
+		d.mu.Lock()
+		d.limit = nn.handlerQueueSize
+		d.mu.Unlock()
+		for i := 0; i < nn.handlerCount; i++ {
+			go nn.work()
+		}
+	})
+	nn.inFlight(p, 1)
+	if !d.submit(conn, p) {
+		nn.inFlight(p, -1)
+		nn.connLog.Warn("Dropping packet received during shutdown", nn.packetAttr(p))
+	}
+}
+
+// work handles the packets handed out by the dispatcher until it is closed
+func (nn *NameNode) work() {
+	for {
+		conn, p, ok := nn.dispatcher.next()
+		if !ok {
+			return
+		}
+		nn.handle(p)
+		nn.inFlight(p, -1)
+		nn.dispatcher.done(conn)
+	}
+}
+
+// handle handles a packet holding the namespace, shared with other readers
+// for the client requests which only read it
+func (nn *NameNode) handle(p Packet) {
+	if readsNamespace(p) {
+		nn.view(func() { nn.HandlePacket(p) })
+	} else {
+		nn.update(func() { nn.HandlePacket(p) })
+	}
+}
+
+// readsNamespace reports whether a packet is a client request which reads the
+// namespace without changing it
+func readsNamespace(p Packet) bool {
+	if p.SRC != "C" {
+		return false
+	}
+	switch p.CMD {
+	case LIST, GETHEADERS, STAT, LISTDIR, GETQUOTA, LISTSNAPSHOT, LISTZONES, GETXATTR, LISTXATTRS, READLINK, LISTCACHE,
+		SNAPSHOTDIFF, GETBLOCKLOCATIONS:
+		return true
+	case BATCH:
+		return batchReads(p)
+	}
+	return false
+}
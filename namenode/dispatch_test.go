@@ -0,0 +1,75 @@
+package namenode
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestDispatcherFairness(t *testing.T) {
+
+	d := newDispatcher()
+	d.limit = 16
+	for i := 0; i < 10; i++ {
+		d.submit(1, Packet{SRC: "C", CMD: STAT, RequestID: int64(i)})
+	}
+	d.submit(2, Packet{SRC: "DN1", CMD: HB})
+
+	// a connection has one packet handled at a time, so the other goes next
+	conn, p, _ := d.next()
+	if conn != 1 || p.RequestID != 0 {
+		t.Fatalf("Expected the first packet of connection 1, got %d %v", conn, p)
+	}
+	conn, p, _ = d.next()
+	if conn != 2 || p.CMD != HB {
+		t.Fatalf("Connection 2 waited behind connection 1, got %d %v", conn, p)
+	}
+	d.done(2)
+	d.done(1)
+	for i := 1; i < 10; i++ {
+		conn, p, _ = d.next()
+		if conn != 1 || p.RequestID != int64(i) {
+			t.Fatalf("Packets of connection 1 out of order, got %d %v", conn, p)
+		}
+		d.done(conn)
+	}
+	if d.pending() != 0 {
+		t.Errorf("Packets left queued")
+	}
+
+	d.close()
+	if _, _, ok := d.next(); ok {
+		t.Errorf("Closed dispatcher handed out a packet")
+	}
+	if d.submit(1, Packet{SRC: "C"}) {
+		t.Errorf("Closed dispatcher accepted a packet")
+	}
+}
+
+func TestConcurrentHandlers(t *testing.T) {
+
+	nn := New()
+	nn.handlerCount = 4
+	var wg sync.WaitGroup
+	for c := 1; c <= 8; c++ {
+		wg.Add(1)
+		go func(conn int64) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				dir := "/c" + strconv.FormatInt(conn, 10) + "/d" + strconv.Itoa(i)
+				nn.dispatch(conn, Packet{SRC: "C", DST: "NN", CMD: MKDIR, Flags: PARENTS, Headers: []BlockHeader{{Filename: dir}}})
+				nn.dispatch(conn, Packet{SRC: "C", DST: "NN", CMD: STAT, Headers: []BlockHeader{{Filename: dir}}})
+			}
+			nn.dispatcher.drain(conn)
+		}(int64(c))
+	}
+	wg.Wait()
+
+	for c := 1; c <= 8; c++ {
+		list, err := nn.ListDir("/c"+strconv.Itoa(c), false)
+		if err != nil || len(list) != 20 {
+			t.Errorf("Expected 20 directories below /c%d, got %d %v", c, len(list), err)
+		}
+	}
+	nn.dispatcher.close()
+}
@@ -0,0 +1,243 @@
+package namenode
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+// longest line of the edit log read
+const maxEditSize = 64 << 20
+
+// edit is a change to the namespace, kept in the edit log as a line of JSON.
+// It is a namespace request of a client, or a replica added or removed.
+type edit struct {
+	Seq      int64        // number of the edit, one more than the edit before it
+	GenStamp int64        // the newest generation stamp given out before the edit
+	BlockID  int64        `json:",omitempty"` // the newest Block ID given out before the edit
+	Request  *Packet      `json:",omitempty"` // a client's namespace request, as handled
+	Added    *BlockHeader `json:",omitempty"` // a replica merged into the namespace
+	Removed  *BlockHeader `json:",omitempty"` // a replica dropped from the namespace
+}
+
+// journaled reports whether a namespace request changes the namespace, so
+// it is kept in the edit log once done
+func journaled(cmd int) bool {
+	switch cmd {
+	case DELETE, MKDIR, SETQUOTA, RENAME, CREATESNAPSHOT, DELETESNAPSHOT, LEASE, RELEASE, ERASURECODE,
+		CREATEZONE, FILEKEY, SETREP, TRUNCATE, SETTIMES, SETXATTR, REMOVEXATTR, CREATESYMLINK, ARCHIVE,
+		CACHE, UNCACHE, STORAGEPOLICY, RESUME:
+		return true
+	}
+	return false
+}
+
+// namespaceEdit is the edit of a change made other than by a client, such as
+// over WebHDFS, as the request which makes it
+func namespaceEdit(cmd int, path string, flags int) edit {
+	return edit{Request: &Packet{SRC: "C", DST: "NN", CMD: cmd, Flags: flags, Headers: []BlockHeader{{Filename: path}}}}
+}
+
+// openEditLog opens the edit log for appending the namenode's edits
+func (nn *NameNode) openEditLog() error {
+	if nn.editLogPath == "" || nn.editLog != nil {
+		return nil
+	}
+	f, err := os.OpenFile(nn.editLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	nn.editLog = f
+	return nil
+}
+
+// closeEditLog stops appending to the edit log
+func (nn *NameNode) closeEditLog() {
+	if nn.editLog != nil {
+		nn.editLog.Close()
+		nn.editLog = nil
+	}
+}
+
+// logEdit numbers e and appends it to the edit log, unless the namenode is
+// the standby or the change is part of an edit already being logged or
+// replayed
+func (nn *NameNode) logEdit(e edit) {
+	if nn.editLog == nil || nn.standby || nn.replaying || nn.logging {
+		return
+	}
+	if e.GenStamp == 0 {
+		e.GenStamp = atomic.LoadInt64(&nn.genStamp)
+	}
+	if e.BlockID == 0 {
+		e.BlockID = atomic.LoadInt64(&nn.blockID)
+	}
+	nn.editSeq++
+	e.Seq = nn.editSeq
+	nn.writeEdit(e)
+}
+
+// writeEdit appends e to the edit log and waits for it to reach the disc
+func (nn *NameNode) writeEdit(e edit) {
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = nn.editLog.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = nn.editLog.Sync()
+	}
+	if err != nil {
+		nn.metaLog.Error("Could not write to the edit log", "file", nn.editLogPath, "seq", e.Seq, "err", err)
+		nn.recentErrors.add("Could not write to the edit log " + err.Error())
+	}
+}
+
+// applyEdit replays an edit made by the active namenode, or before a
+// restart, unless it is already part of the namespace. A standby copying the
+// edits of the active over the network keeps them in its own edit log.
+func (nn *NameNode) applyEdit(e edit) {
+	if e.Seq <= nn.editSeq {
+		return
+	}
+	nn.replaying = true
+	nn.observeGenStamp(e.GenStamp)
+	nn.observeBlockID(e.BlockID)
+	var err error
+	switch {
+	case e.Request != nil && len(e.Request.Headers) == 1:
+		var r Packet
+		nn.handleNamespace(*e.Request, &r)
+		if r.CMD == ERROR {
+			err = errors.New(r.Message)
+		}
+	case e.Added != nil:
+		if _, ok := nn.datanodemap[e.Added.DatanodeID]; !ok {
+			// the datanode connects once this namenode is the active
+			nn.datanodemap[e.Added.DatanodeID] = &datanode{ID: e.Added.DatanodeID}
+			nn.offline[e.Added.DatanodeID] = true
+		}
+		err = nn.MergeNode(*e.Added)
+	case e.Removed != nil:
+		nn.removeReplica(*e.Removed)
+	default:
+		err = errors.New("Empty edit")
+	}
+	nn.replaying = false
+	if err != nil {
+		// the active namenode's outcome is kept, as it was when it logged the edit
+		nn.metaLog.Warn("Replayed edit failed", "seq", e.Seq, "err", err)
+	}
+
+	nn.editSeq = e.Seq
+	if nn.editLog != nil {
+		nn.writeEdit(e)
+	}
+}
+
+// loadEditLog replays the edits logged since the namespace was last saved,
+// then opens the edit log for appending, unless the namenode is a standby
+// sharing the edit log with the active
+func (nn *NameNode) loadEditLog() error {
+	if nn.editLogPath == "" {
+		return nil
+	}
+	f, err := os.Open(nn.editLogPath)
+	if err == nil {
+		var edits []edit
+		edits, err = readEdits(f, nn.editSeq)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		for _, e := range edits {
+			nn.applyEdit(e)
+		}
+		nn.metaLog.Info("Replayed edit log", "file", nn.editLogPath, "edits", len(edits), "seq", nn.editSeq)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if !nn.standby || nn.activeAddress != "" {
+		return nn.openEditLog()
+	}
+	return nil
+}
+
+// errEditsDropped fails a read of edits which a checkpoint dropped from the
+// edit log
+var errEditsDropped = errors.New("Edits were dropped from the edit log by a checkpoint")
+
+// readEdits reads the edits after since from an edit log. A last line which
+// is not yet whole is left for a later read.
+func readEdits(r io.Reader, since int64) ([]edit, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxEditSize)
+	var edits []edit
+	var bad error
+	for scanner.Scan() {
+		if bad != nil {
+			return nil, bad
+		}
+		var e edit
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			bad = errors.New("Corrupt edit after " + strconv.FormatInt(since, 10) + ": " + err.Error())
+			continue
+		}
+		if e.Seq > since+1 {
+			return nil, errEditsDropped
+		}
+		if e.Seq > since {
+			edits = append(edits, e)
+			since = e.Seq
+		}
+	}
+	return edits, scanner.Err()
+}
+
+// ServeEditLog serves the edits after the sequence number in the since
+// parameter as lines of JSON, for a standby tailing the edit log
+func (nn *NameNode) ServeEditLog(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid since", http.StatusBadRequest)
+		return
+	}
+	// the newest edit, so a reader knows how far behind it is
+	var seq int64
+	nn.view(func() { seq = nn.editSeq })
+	w.Header().Set("X-Edit-Seq", strconv.FormatInt(seq, 10))
+	if nn.editLogPath == "" {
+		http.Error(w, "No edit log is kept", http.StatusNotFound)
+		return
+	}
+	f, err := os.Open(nn.editLogPath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	edits, err := readEdits(f, since)
+	f.Close()
+	if err == errEditsDropped {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	for _, e := range edits {
+		encoder.Encode(e)
+	}
+}
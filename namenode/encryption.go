@@ -0,0 +1,153 @@
+package namenode
+
+import (
+	"errors"
+	"strings"
+)
+
+// CreateZone makes the empty directory at path an encryption zone. Clients
+// encrypt the files written below it with a data key of their own for each
+// file, which they wrap with the zone key named key from their key provider.
+// The namenode only keeps the name of the zone key and the wrapped data
+// keys, so neither it nor the datanodes can read the files.
+func (nn *NameNode) CreateZone(path, key string) error {
+	if key == "" {
+		return errors.New("Missing zone key")
+	}
+	n := nn.lookup(path)
+	if n == nil || nn.isFile(n) {
+		return errors.New("No such directory " + path)
+	}
+	if len(n.children) > 0 {
+		return errors.New("Encryption zones can only be created on empty directories " + path)
+	}
+	if zone := nn.zoneOf(path); zone != nil {
+		return errors.New("Directory is already in the encryption zone " + zone.path)
+	}
+
+	n.zoneKey = key
+	if n != nn.root {
+		// keep the zone when it is emptied
+		n.explicit = true
+	}
+	nn.metaLog.Info("Created encryption zone", "path", path, "key", key)
+	return nil
+}
+
+// zoneOf returns the root of the encryption zone holding path, which need
+// not exist yet, or nil if path is in no zone
+func (nn *NameNode) zoneOf(path string) *filenode {
+	for {
+		if n := nn.lookup(path); n != nil && n.zoneKey != "" {
+			return n
+		}
+		if path == "/" || path == "" {
+			return nil
+		}
+		path = path[:strings.LastIndex(path, "/")]
+		if path == "" {
+			path = "/"
+		}
+	}
+}
+
+// ListZones describes every encryption zone, with the name of its zone key
+func (nn *NameNode) ListZones() []FileStatus {
+	list := make([]FileStatus, 0)
+	nn.walk(nn.root, func(n *filenode) {
+		if n.zoneKey != "" {
+			list = append(list, FileStatus{Path: n.path, IsDir: true, Zone: n.zoneKey})
+		}
+	})
+	return list
+}
+
+// SetFileKey stores the data key of the file at path, which holder is about
+// to write in an encryption zone, wrapped with the zone key named zone
+func (nn *NameNode) SetFileKey(path, holder, zone string, key []byte) error {
+	z := nn.zoneOf(path)
+	if z == nil {
+		return errors.New("Not in an encryption zone " + path)
+	}
+	if zone != z.zoneKey {
+		return errors.New("Encryption zone " + z.path + " uses the key " + z.zoneKey)
+	}
+	if len(key) == 0 {
+		return errors.New("Missing data key for " + path)
+	}
+	err := nn.checkLease(path, holder)
+	if err != nil {
+		return err
+	}
+	if _, ok := nn.filemap.Get(path); ok {
+		return errors.New("Cannot change the key of a written file " + path)
+	}
+	nn.encrypted[path] = FileStatus{Path: path, Zone: zone, Key: key}
+	nn.metaLog.Debug("Set data key", "path", path, "zone", z.path)
+	return nil
+}
+
+// keyOf returns the wrapped data key of the file at path, which may be
+// within a snapshot. ok is false for files which are not encrypted.
+func (nn *NameNode) keyOf(path string) (st FileStatus, ok bool) {
+	dir, name, rel, isSnapshot := splitSnapshotPath(path)
+	if !isSnapshot {
+		st, ok = nn.encrypted[path]
+		return st, ok
+	}
+	s, err := nn.getSnapshot(dir, name)
+	if err != nil {
+		return st, false
+	}
+	st, ok = s.Keys[rel]
+	return st, ok
+}
+
+// checkEncrypted returns an error if the file at path is in an encryption
+// zone but its writer has not given it a data key, so plain data is never
+// stored in a zone
+func (nn *NameNode) checkEncrypted(path string) error {
+	if nn.zoneOf(path) == nil {
+		return nil
+	}
+	if _, ok := nn.encrypted[path]; !ok {
+		return errors.New("No data key for " + path + " in an encryption zone")
+	}
+	return nil
+}
+
+// checkZoneRename returns an error if moving src to dst would put a file in
+// an encryption zone whose key did not wrap its data key. Encrypted files
+// may leave their zone, as they do for the trash.
+func (nn *NameNode) checkZoneRename(src, dst string) error {
+	n := nn.lookup(src)
+	zone := nn.zoneOf(dst)
+	if n == nil || zone == nil {
+		return nil
+	}
+	var err error
+	nn.walk(n, func(c *filenode) {
+		if c.zoneKey != "" && err == nil {
+			err = errors.New("Cannot move the encryption zone " + c.path + " into another zone")
+		}
+		if !nn.isFile(c) || err != nil {
+			return
+		}
+		if k, ok := nn.encrypted[c.path]; !ok || k.Zone != zone.zoneKey {
+			err = errors.New("Cannot move " + c.path + " into the encryption zone " + zone.path)
+		}
+	})
+	return err
+}
+
+// renameKeys moves the data keys of the encrypted files at or below src to
+// dst
+func (nn *NameNode) renameKeys(src, dst string) {
+	for path, st := range nn.encrypted {
+		if path == src || strings.HasPrefix(path, src+"/") {
+			delete(nn.encrypted, path)
+			st.Path = dst + strings.TrimPrefix(path, src)
+			nn.encrypted[st.Path] = st
+		}
+	}
+}
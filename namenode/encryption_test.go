@@ -0,0 +1,89 @@
+package namenode
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEncryptionZones(t *testing.T) {
+
+	nn := New()
+	nn.metadatafile = filepath.Join(t.TempDir(), "metadata.json")
+	nn.trashInterval = time.Hour
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/plain/old.txt", 1, 0, 1, 0, "", 0, ""})
+	nn.Mkdir("/secret", false)
+
+	if nn.CreateZone("/plain", "k1") == nil {
+		t.Errorf("Zone created on a directory with files")
+	}
+	if err := nn.CreateZone("/secret", "k1"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	nn.Mkdir("/secret/sub", false)
+	if nn.CreateZone("/secret/sub", "k2") == nil {
+		t.Errorf("Zone created within a zone")
+	}
+
+	// the lease tells the writer the zone key, and Blocks are refused until
+	// the file has a data key
+	var r Packet
+	nn.handleNamespace(Packet{SRC: "C", CMD: LEASE, Message: "W1", Headers: []BlockHeader{{Filename: "/secret/sub/f"}}}, &r)
+	if r.CMD != ACK || len(r.Status) != 1 || r.Status[0].Zone != "k1" {
+		t.Fatalf("Expected the zone key with the lease, got %v", r)
+	}
+	b := Block{BlockHeader{"", "/secret/sub/f", 1, 0, 1, 0, "", 0, ""}, []byte{1}}
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: DISTRIBUTE, Data: b, Message: "W1"})
+	if len(nn.metrics.distributing) != 0 {
+		t.Fatalf("Block distributed without a data key")
+	}
+	if nn.SetFileKey("/secret/sub/f", "W1", "k2", []byte("wrapped")) == nil || nn.SetFileKey("/plain/f", "W1", "k1", []byte("wrapped")) == nil {
+		t.Errorf("Data key stored for the wrong zone")
+	}
+	if err := nn.SetFileKey("/secret/sub/f", "W1", "k1", []byte("wrapped")); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := nn.checkEncrypted("/secret/sub/f"); err != nil {
+		t.Errorf("%s", err)
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/secret/sub/f", 1, 0, 1, 0, "", 0, ""})
+	nn.ReleaseLease("/secret/sub/f", "W1")
+
+	if st, _ := nn.Stat("/secret/sub/f"); st.Zone != "k1" || string(st.Key) != "wrapped" {
+		t.Errorf("Expected the wrapped data key in the status, got %v", st)
+	}
+
+	// plain files may not move into the zone, encrypted ones may leave it
+	if nn.checkZoneRename("/plain/old.txt", "/secret/old.txt") == nil {
+		t.Errorf("Plain file moved into a zone")
+	}
+	nn.handleNamespace(Packet{SRC: "C", CMD: DELETE, Headers: []BlockHeader{{Filename: "/secret/sub/f"}}}, &r)
+	if _, ok := nn.keyOf("/.Trash/C/secret/sub/f"); !ok {
+		t.Fatalf("Data key lost moving to the trash")
+	}
+	if err := nn.checkZoneRename("/.Trash/C/secret/sub/f", "/secret/f"); err != nil {
+		t.Errorf("Could not restore from the trash %s", err)
+	}
+	if zones := nn.ListZones(); len(zones) != 1 || zones[0].Path != "/secret" {
+		t.Errorf("Expected one zone, got %v", zones)
+	}
+
+	err := nn.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	restarted := New()
+	restarted.metadatafile = nn.metadatafile
+	err = restarted.LoadMetadata()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if z := restarted.zoneOf("/secret/new"); z == nil || z.zoneKey != "k1" {
+		t.Errorf("Zone not restored")
+	}
+	if k, ok := restarted.keyOf("/.Trash/C/secret/sub/f"); !ok || string(k.Key) != "wrapped" {
+		t.Errorf("Data key not restored")
+	}
+}
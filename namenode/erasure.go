@@ -0,0 +1,215 @@
+package namenode
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// erasurePolicy is a Reed-Solomon code which stores each stripe of up to
+// Data consecutive Blocks of a file with Parity parity Blocks, any Data of
+// which rebuild the stripe. The data Blocks of a file come first, followed
+// by the parity Blocks of each stripe in turn.
+type erasurePolicy struct {
+	Data   int
+	Parity int
+}
+
+// parsePolicy parses a policy name of the form RS-<data>-<parity>
+func parsePolicy(name string) (erasurePolicy, error) {
+	var e erasurePolicy
+	parts := strings.Split(name, "-")
+	if len(parts) != 3 || parts[0] != "RS" {
+		return e, errors.New("Invalid erasure coding policy " + name)
+	}
+	var err error
+	e.Data, err = strconv.Atoi(parts[1])
+	if err != nil || e.Data < 1 {
+		return e, errors.New("Invalid erasure coding policy " + name)
+	}
+	e.Parity, err = strconv.Atoi(parts[2])
+	if err != nil || e.Parity < 1 || e.Data+e.Parity > 256 {
+		return e, errors.New("Invalid erasure coding policy " + name)
+	}
+	return e, nil
+}
+
+// dataBlocks is the number of data Blocks of a file of numBlocks Blocks
+func (e erasurePolicy) dataBlocks(numBlocks int) int {
+	n := 0
+	if numBlocks > e.Parity {
+		n = (numBlocks - e.Parity) * e.Data / (e.Data + e.Parity)
+	}
+	for n+(n+e.Data-1)/e.Data*e.Parity < numBlocks {
+		n++
+	}
+	return n
+}
+
+// stripeOf is the stripe holding Block blockNum of a file with n data Blocks
+func (e erasurePolicy) stripeOf(blockNum, n int) int {
+	if blockNum < n {
+		return blockNum / e.Data
+	}
+	return (blockNum - n) / e.Parity
+}
+
+// stripeBlocks lists the data and parity Blocks of a stripe of a file with n
+// data Blocks
+func (e erasurePolicy) stripeBlocks(stripe, n int) (data, parity []int) {
+	for i := stripe * e.Data; i < n && i < (stripe+1)*e.Data; i++ {
+		data = append(data, i)
+	}
+	for i := 0; i < e.Parity; i++ {
+		parity = append(parity, n+stripe*e.Parity+i)
+	}
+	return data, parity
+}
+
+// SetErasure stores the file at path, which holder is about to write, with
+// an erasure coding policy rather than replicas. size is the length of the
+// file, which readers need to rebuild its last Block.
+func (nn *NameNode) SetErasure(path, policy, holder string, size int64) error {
+	if _, err := parsePolicy(policy); err != nil {
+		return err
+	}
+	if size <= 0 {
+		return errors.New("Cannot erasure code an empty file " + path)
+	}
+	err := nn.checkLease(path, holder)
+	if err != nil {
+		return err
+	}
+	if _, ok := nn.filemap.Get(path); ok {
+		return errors.New("Cannot change the policy of a written file " + path)
+	}
+	nn.erasure[path] = FileStatus{Path: path, Size: size, Erasure: policy}
+	nn.metaLog.Debug("Set erasure coding policy", "path", path, "policy", policy)
+	return nil
+}
+
+// erasureOf returns the erasure coding policy and size of the file at path,
+// which may be within a snapshot. ok is false for replicated files.
+func (nn *NameNode) erasureOf(path string) (st FileStatus, ok bool) {
+	dir, name, rel, isSnapshot := splitSnapshotPath(path)
+	if !isSnapshot {
+		st, ok = nn.erasure[path]
+		return st, ok
+	}
+	s, err := nn.getSnapshot(dir, name)
+	if err != nil {
+		return st, false
+	}
+	st, ok = s.Erasure[rel]
+	return st, ok
+}
+
+// fileBlocksStatus describes the file at path made of blocks. An erasure
+// coded file's size excludes its parity, and its replication is 0 only
+// when a stripe has lost too many Blocks to be rebuilt.
+func (nn *NameNode) fileBlocksStatus(path string, blocks map[int][]BlockHeader) FileStatus {
+	st := blocksStatus(path, blocks)
+	st.BlockSize = nn.blockSize(path)
+	st.Replicas = nn.neededReplicas(path)
+	if k, ok := nn.keyOf(path); ok {
+		st.Zone, st.Key = k.Zone, k.Key
+	}
+	ec, ok := nn.erasureOf(path)
+	if !ok {
+		return st
+	}
+	st.Size = ec.Size
+	st.Erasure = ec.Erasure
+	e, _ := parsePolicy(ec.Erasure)
+	n := e.dataBlocks(st.NumBlocks)
+
+	st.Replication = 0
+	for stripe := 0; stripe*e.Data < n; stripe++ {
+		data, parity := e.stripeBlocks(stripe, n)
+		available := 0
+		fewest := 0
+		for _, num := range append(data, parity...) {
+			if replicas := len(blocks[num]); replicas > 0 {
+				available++
+				if fewest == 0 || replicas < fewest {
+					fewest = replicas
+				}
+			}
+		}
+		if available < len(data) {
+			st.Replication = 0
+			return st
+		}
+		if st.Replication == 0 || fewest < st.Replication {
+			st.Replication = fewest
+		}
+	}
+	return st
+}
+
+// neededReplicas is the number of replicas each Block of the file at path
+// should have. Blocks of erasure coded files are stored once.
+func (nn *NameNode) neededReplicas(path string) int {
+	if _, ok := nn.erasure[path]; ok {
+		return 1
+	}
+	if l, ok := nn.layouts[path]; ok && l.Replicas > 0 {
+		return l.Replicas
+	}
+	return nn.replication
+}
+
+// spreadStripe removes the datanodes holding other Blocks of the stripe of
+// h from nodeIDs, so losing a datanode loses at most one Block of a stripe.
+// Replicated files are placed anywhere.
+func (nn *NameNode) spreadStripe(h BlockHeader, nodeIDs []string) []string {
+	ec, ok := nn.erasure[h.Filename]
+	if !ok {
+		return nodeIDs
+	}
+	e, _ := parsePolicy(ec.Erasure)
+	n := e.dataBlocks(h.NumBlocks)
+	data, parity := e.stripeBlocks(e.stripeOf(h.BlockNum, n), n)
+
+	holders := make(map[string]bool)
+	for _, num := range append(data, parity...) {
+		for _, r := range nn.replicas(h.Filename, num) {
+			holders[r.DatanodeID] = true
+		}
+	}
+	spread := make([]string, 0, len(nodeIDs))
+	for _, id := range nodeIDs {
+		if !holders[id] {
+			spread = append(spread, id)
+		}
+	}
+	return spread
+}
+
+// erasureHeaders lists a replica of each Block of an erasure coded file held
+// by a connected datanode, closest first to the reader. Missing Blocks are
+// left out, for the reader to rebuild.
+func (nn *NameNode) erasureHeaders(blocks map[int][]BlockHeader, numBlocks int) []BlockHeader {
+	headers := make([]BlockHeader, 0, numBlocks)
+	for i := 0; i < numBlocks; i++ {
+		for _, h := range nn.sortByDistance(nn.clientHost, blocks[i]) {
+			if !nn.offline[h.DatanodeID] {
+				headers = append(headers, h)
+				break
+			}
+		}
+	}
+	return headers
+}
+
+// renameErasure moves the policies of the erasure coded files at or below
+// src to dst
+func (nn *NameNode) renameErasure(src, dst string) {
+	for path, st := range nn.erasure {
+		if path == src || strings.HasPrefix(path, src+"/") {
+			delete(nn.erasure, path)
+			st.Path = dst + strings.TrimPrefix(path, src)
+			nn.erasure[st.Path] = st
+		}
+	}
+}
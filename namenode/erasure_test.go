@@ -0,0 +1,100 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestErasurePolicies(t *testing.T) {
+
+	for _, name := range []string{"RS", "RS-0-2", "RS-3-0", "XOR-2-1", "RS-200-100"} {
+		if _, err := parsePolicy(name); err == nil {
+			t.Errorf("Expected %s to be invalid", name)
+		}
+	}
+	e, err := parsePolicy("RS-3-2")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for n := 1; n < 20; n++ {
+		if got := e.dataBlocks(n + (n+2)/3*2); got != n {
+			t.Errorf("Expected %d data Blocks, got %d", n, got)
+		}
+	}
+	data, parity := e.stripeBlocks(1, 5)
+	if len(data) != 2 || data[0] != 3 || len(parity) != 2 || parity[0] != 7 {
+		t.Errorf("Wrong Blocks in the last stripe %v %v", data, parity)
+	}
+	if e.stripeOf(4, 5) != 1 || e.stripeOf(6, 5) != 0 || e.stripeOf(8, 5) != 1 {
+		t.Errorf("Wrong stripe of a Block")
+	}
+}
+
+func TestErasureCodedFile(t *testing.T) {
+
+	nn := New()
+	for _, id := range []string{"DN1", "DN2", "DN3", "DN4", "DN5", "DN6"} {
+		nn.datanodemap[id] = &datanode{ID: id, listed: true}
+	}
+	if nn.SetErasure("/cold.bin", "RS-2-1", "W1", 300) == nil {
+		t.Errorf("Policy set without the lease")
+	}
+	nn.AcquireLease("/cold.bin", "W1")
+	if err := nn.SetErasure("/cold.bin", "RS-2-1", "W1", 300); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// 3 data Blocks of 100 bytes in two stripes, each with one parity Block
+	for num := 0; num < 5; num++ {
+		p, err := nn.AssignBlock(Block{BlockHeader{"", "/cold.bin", 100, num, 5, 0, "", 0, ""}, make([]byte, 100)})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		nn.MergeNode(p.Data.Header)
+	}
+	e, _ := parsePolicy("RS-2-1")
+	for stripe := 0; stripe < 2; stripe++ {
+		data, parity := e.stripeBlocks(stripe, 3)
+		holders := make(map[string]bool)
+		for _, num := range append(data, parity...) {
+			holders[nn.replicas("/cold.bin", num)[0].DatanodeID] = true
+		}
+		if len(holders) != len(data)+len(parity) {
+			t.Errorf("Stripe %d placed on %d datanodes", stripe, len(holders))
+		}
+	}
+	if nn.neededReplicas("/cold.bin") != 1 || nn.neededReplicas("/other.txt") != nn.replication {
+		t.Errorf("Wrong replicas needed")
+	}
+
+	blocks, _ := nn.filemap.Get("/cold.bin")
+	st := nn.fileBlocksStatus("/cold.bin", blocks)
+	if st.Size != 300 || st.Erasure != "RS-2-1" || st.Replication != 1 {
+		t.Errorf("Wrong status of an erasure coded file %v", st)
+	}
+	// one lost Block of a stripe is rebuilt from its parity, two are not
+	lost := blocks[0]
+	delete(blocks, 0)
+	if st := nn.fileBlocksStatus("/cold.bin", blocks); st.Replication != 1 {
+		t.Errorf("Expected a readable file, got replication %d", st.Replication)
+	}
+	delete(blocks, 3)
+	if st := nn.fileBlocksStatus("/cold.bin", blocks); st.Replication != 0 {
+		t.Errorf("Expected an unreadable file, got replication %d", st.Replication)
+	}
+	blocks[0] = lost
+
+	if nn.SetErasure("/cold.bin", "RS-3-2", "W1", 300) == nil {
+		t.Errorf("Policy of a written file changed")
+	}
+	nn.ReleaseLease("/cold.bin", "W1")
+	if err := nn.Rename("/cold.bin", "/archive.bin"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, ok := nn.erasure["/archive.bin"]; !ok || len(nn.erasure) != 1 {
+		t.Errorf("Policy not renamed with the file %v", nn.erasure)
+	}
+	nn.removeFile("/archive.bin")
+	if len(nn.erasure) != 0 {
+		t.Errorf("Policy kept for a deleted file %v", nn.erasure)
+	}
+}
@@ -0,0 +1,58 @@
+package namenode
+
+import (
+	"errors"
+)
+
+// Error is an error with a machine-readable code, carried by the ERROR
+// packets answering requests so clients can tell failures apart
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is an Error of the same code, so that
+// errors.Is(err, ErrFileNotFound) holds for every missing file
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// kinds of errors requests fail with
+var (
+	ErrNoDatanodes     = &Error{Code: "NO_DATANODES", Message: "No datanodes are connected"}
+	ErrFileNotFound    = &Error{Code: "FILE_NOT_FOUND", Message: "File not found"}
+	ErrInvalidHeader   = &Error{Code: "INVALID_HEADER", Message: "Invalid Header received"}
+	ErrUnauthorized    = &Error{Code: "UNAUTHORIZED", Message: "Permission denied"}
+	ErrFileExists      = &Error{Code: "FILE_EXISTS", Message: "File exists"}
+	ErrWriteConflict   = &Error{Code: "WRITE_CONFLICT", Message: "File is being written by another writer"}
+	ErrStandby         = &Error{Code: "STANDBY", Message: standbyMessage}
+	ErrSafeMode        = &Error{Code: "SAFE_MODE", Message: "Namenode is in safe mode, the namespace is read-only"}
+	ErrBlocksLost      = &Error{Code: "BLOCKS_LOST", Message: "Blocks of the file are lost"}
+	ErrStaleGeneration = &Error{Code: "STALE_GENERATION", Message: "Outdated generation of Block"}
+)
+
+// newError returns an error of the kind of base, described by message
+func newError(base *Error, message string) error {
+	return &Error{Code: base.Code, Message: message}
+}
+
+// errorCode returns the code of an Error, or "" for other errors
+func errorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// fail makes r an ERROR answering with err
+func fail(r *Packet, err error) {
+	r.CMD = ERROR
+	r.Message = err.Error()
+	r.Code = errorCode(err)
+}
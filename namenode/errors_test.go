@@ -0,0 +1,80 @@
+package namenode
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+
+	nn := New()
+	local, peer := net.Pipe()
+	defer peer.Close()
+	nn.SetOutbound("C", local)
+	decoder := json.NewDecoder(peer)
+	request := func(p Packet) Packet {
+		go nn.HandlePacket(p)
+		var r Packet
+		decoder.Decode(&r)
+		return r
+	}
+
+	// requests fail with the code of their kind of error
+	nn.AcquireLease("/new.txt", "writer")
+	for _, c := range []struct {
+		p    Packet
+		code string
+	}{
+		{Packet{SRC: "C", DST: "NN", CMD: GETHEADERS, Headers: []BlockHeader{{Filename: "/missing.txt"}}}, ErrFileNotFound.Code},
+		{Packet{SRC: "C", DST: "NN", CMD: STAT, Headers: []BlockHeader{{Filename: "/missing.txt"}}}, ErrFileNotFound.Code},
+		{Packet{SRC: "C", DST: "NN", CMD: RETRIEVEBLOCK}, ErrInvalidHeader.Code},
+		{Packet{SRC: "C", DST: "NN", CMD: RETRIEVEBLOCK, Headers: []BlockHeader{{"DN9", "/a.txt", 1, 0, 1, 1, "", 0, ""}}}, ErrNoDatanodes.Code},
+		{Packet{SRC: "C", DST: "NN", CMD: STAT, Headers: []BlockHeader{{Filename: "relative"}}}, ErrInvalidHeader.Code},
+		{Packet{SRC: "C", DST: "NN", CMD: DISTRIBUTE, Message: "writer", Data: Block{Header: BlockHeader{"", "/new.txt", 0, 0, 1, 0, "", 0, ""}}}, ErrInvalidHeader.Code},
+		{Packet{SRC: "C", DST: "NN", CMD: DISTRIBUTE, Message: "writer", Data: Block{Header: BlockHeader{"", "/new.txt", 1, 0, 1, 0, "", 0, ""}, Data: []byte("a")}}, ErrNoDatanodes.Code},
+		{Packet{SRC: "C", DST: "NN", CMD: SAFEMODE, User: "nobody", Message: "enter"}, ErrUnauthorized.Code},
+		{Packet{SRC: "C", DST: "NN", CMD: LEASE, Headers: []BlockHeader{{Filename: "/other.txt"}}}, ""},
+	} {
+		if r := request(c.p); r.CMD != ERROR || r.Code != c.code {
+			t.Errorf("%s answered with %v %q, expected code %q", CommandName(c.p.CMD), CommandName(r.CMD), r.Message, c.code)
+		}
+	}
+
+	// a file whose first Block has no replicas left is refused, not a panic
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	h := BlockHeader{"DN1", "/lost.txt", 1, 0, 1, 1, "", 0, ""}
+	nn.MergeNode(h)
+	blocks, _ := nn.filemap.Get("/lost.txt")
+	blocks[0] = blocks[0][:0]
+	nn.filemap.Put("/lost.txt", blocks)
+	if r := request(Packet{SRC: "C", DST: "NN", CMD: GETHEADERS, Headers: []BlockHeader{{Filename: "/lost.txt"}}}); r.CMD != ERROR || r.Code != ErrBlocksLost.Code {
+		t.Errorf("Headers of a file without replicas answered with %v %q", CommandName(r.CMD), r.Code)
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/kept.txt", 1, 0, 1, 1, "", 0, ""})
+	if r := request(Packet{SRC: "C", DST: "NN", CMD: GETHEADERS, Offset: 5, Headers: []BlockHeader{{Filename: "/kept.txt"}}}); r.Code != ErrInvalidHeader.Code {
+		t.Errorf("Headers past the end of a file answered with %v %q", CommandName(r.CMD), r.Code)
+	}
+
+	// so are requests refused by the namenode's state
+	nn.safeMode = true
+	if r := request(Packet{SRC: "C", DST: "NN", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/dir"}}}); r.Code != ErrSafeMode.Code {
+		t.Errorf("Change in safe mode answered with %v %q", CommandName(r.CMD), r.Code)
+	}
+	nn.safeMode = false
+	nn.standby = true
+	if r := request(Packet{SRC: "C", DST: "NN", CMD: LIST}); r.Code != ErrStandby.Code || r.Message != standbyMessage {
+		t.Errorf("Request of a standby answered with %v %q", CommandName(r.CMD), r.Code)
+	}
+	nn.standby = false
+
+	// errors of a kind match it whatever their message
+	err := nn.Rename("/missing.txt", "/other.txt")
+	if !errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrUnauthorized) || err.Error() != "No such file or directory /missing.txt" {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if errors.Is(errors.New("other"), ErrFileNotFound) || errorCode(errors.New("other")) != "" {
+		t.Errorf("Plain error given a kind")
+	}
+}
@@ -0,0 +1,90 @@
+package namenode
+
+import (
+	"strings"
+	"sync"
+)
+
+// kinds of namespace events, sent in the Message of an EVENT
+const (
+	eventCreate = "create" // a file, directory or symbolic link was created
+	eventClose  = "close"  // the writer of a file released its lease, so the file is complete
+	eventDelete = "delete" // a path was deleted
+	eventRename = "rename" // a path was renamed, or moved to the trash
+)
+
+// subscriptions are the requests of the client connection for the events
+// below path prefixes. A subscription is known by the RequestID of its
+// SUBSCRIBE, which its events carry.
+type subscriptions struct {
+	mu       sync.Mutex
+	prefixes map[int64]string
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{prefixes: make(map[int64]string)}
+}
+
+// Subscribe sends the events of the paths below prefix to the client, under
+// the RequestID id
+func (nn *NameNode) Subscribe(id int64, prefix string) {
+	s := nn.subscriptions
+	s.mu.Lock()
+	s.prefixes[id] = prefix
+	s.mu.Unlock()
+	nn.metaLog.Info("Added event subscription", "id", id, "prefix", prefix)
+}
+
+// Unsubscribe ends the subscription id
+func (nn *NameNode) Unsubscribe(id int64) {
+	s := nn.subscriptions
+	s.mu.Lock()
+	delete(s.prefixes, id)
+	s.mu.Unlock()
+}
+
+// clearSubscriptions ends every subscription, once the connection they were
+// made on is replaced
+func (nn *NameNode) clearSubscriptions() {
+	s := nn.subscriptions
+	s.mu.Lock()
+	s.prefixes = make(map[int64]string)
+	s.mu.Unlock()
+}
+
+// below reports whether path is prefix or lies beneath it
+func below(path, prefix string) bool {
+	return prefix == "/" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// notify sends an event of kind on path, and its new path dst for a rename,
+// to each subscription with a prefix above either. Events of files and
+// directories which exist carry their status.
+func (nn *NameNode) notify(kind, path, dst string) {
+	s := nn.subscriptions
+	s.mu.Lock()
+	var ids []int64
+	for id, prefix := range s.prefixes {
+		if below(path, prefix) || (dst != "" && below(dst, prefix)) {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+
+	e := Packet{SRC: nn.id, DST: "C", CMD: EVENT, Message: kind, Headers: []BlockHeader{{Filename: path}}}
+	if dst != "" {
+		e.Renamed = []BlockHeader{{Filename: dst}}
+	}
+	if kind == eventCreate || kind == eventClose {
+		if n := nn.lookup(path); n != nil {
+			e.Status = []FileStatus{nn.fileStatus(n)}
+		}
+	}
+	for _, id := range ids {
+		e.RequestID = id
+		nn.SendPacket(e)
+	}
+}
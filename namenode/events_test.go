@@ -0,0 +1,61 @@
+package namenode
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+
+	nn := New()
+	local, peer := net.Pipe()
+	defer peer.Close()
+	nn.SetOutbound("C", local)
+	events := make(chan Packet, 100)
+	go func() {
+		decoder := json.NewDecoder(peer)
+		for {
+			var p Packet
+			if decoder.Decode(&p) != nil {
+				return
+			}
+			if p.CMD == EVENT || p.CMD == STAT {
+				events <- p
+			}
+		}
+	}()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: SUBSCRIBE, RequestID: 7, Headers: []BlockHeader{{Filename: "/data"}}})
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/other"}}})
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/data"}}})
+	nn.MergeNode(BlockHeader{"DN1", "/data/part-0", 1, 0, 1, 0, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/other/part-0", 1, 0, 1, 0, "", 0, ""})
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: RENAME, Headers: []BlockHeader{{Filename: "/other/part-0"}},
+		Renamed: []BlockHeader{{Filename: "/data/part-1"}}})
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: DELETE, Headers: []BlockHeader{{Filename: "/data/part-0"}}})
+
+	expected := []struct{ kind, path, dst string }{{eventCreate, "/data", ""}, {eventCreate, "/data/part-0", ""},
+		{eventRename, "/other/part-0", "/data/part-1"}, {eventDelete, "/data/part-0", ""}}
+	for _, x := range expected {
+		e := <-events
+		if e.RequestID != 7 || e.Message != x.kind || e.Headers[0].Filename != x.path {
+			t.Errorf("Expected %s of %s, got %v", x.kind, x.path, e)
+		}
+		if x.dst != "" && (len(e.Renamed) != 1 || e.Renamed[0].Filename != x.dst) {
+			t.Errorf("Expected a rename to %s, got %v", x.dst, e)
+		}
+		if x.kind == eventCreate && len(e.Status) != 1 {
+			t.Errorf("Expected the status of %s, got %v", x.path, e)
+		}
+	}
+
+	// no events once unsubscribed
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: UNSUBSCRIBE, Message: "7", Headers: []BlockHeader{{Filename: "/"}}})
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/data/sub"}}})
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: STAT, Headers: []BlockHeader{{Filename: "/data"}}})
+	if e := <-events; e.CMD != STAT {
+		t.Errorf("Event sent after unsubscribing %v", e)
+	}
+}
@@ -0,0 +1,526 @@
+package namenode
+
+import (
+	"container/list"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// fileMap maps the files of the namespace to their Blocks by Block number,
+// and each Block to its replicas. The Blocks of a file changed in place
+// must be stored again with Put; Add and Remove change a single replica
+// without reading the whole file.
+type fileMap interface {
+	Get(path string) (map[int][]BlockHeader, bool)                 // the Blocks of the file at path
+	Put(path string, blocks map[int][]BlockHeader)                 // store the Blocks of the file at path
+	Block(path string, num int) ([]BlockHeader, bool)              // the replicas of Block num of the file at path, and whether the file exists
+	Add(h BlockHeader) bool                                        // add the replica h to its file, creating it; false if it was there already
+	Remove(h BlockHeader) (int, bool)                              // remove the replica h, returning the Blocks left of its file; false if it was not there
+	Delete(path string)                                            // remove the file at path
+	Range(fn func(path string, blocks map[int][]BlockHeader) bool) // call fn for each file until it returns false; fn may change the map
+	Len() int                                                      // number of files
+	Close() error                                                  // flush the map to disc
+}
+
+// replicas returns the replicas of Block num of the file at path
+func (nn *NameNode) replicas(path string, num int) []BlockHeader {
+	replicas, _ := nn.filemap.Block(path, num)
+	return replicas
+}
+
+// openMetadataStore keeps the Blocks of files in the configured metadata
+// store, rebuilding the namespace tree and datanode usage from the files it
+// already holds
+func (nn *NameNode) openMetadataStore() error {
+	if nn.metadatastore == "" {
+		return nil
+	}
+	m, err := openStoredFileMap(nn.metadatastore, nn.metadatacache, nn.metaLog)
+	if err != nil {
+		return err
+	}
+	nn.filemap = m
+
+	m.Range(func(path string, blocks map[int][]BlockHeader) bool {
+		nn.attach(path)
+		for _, replicas := range blocks {
+			for _, h := range replicas {
+				nn.observeGenStamp(h.GenStamp)
+				dn, ok := nn.datanodemap[h.DatanodeID]
+				if !ok {
+					// datanodes restored from the store reconnect later
+					dn = &datanode{ID: h.DatanodeID}
+					nn.datanodemap[h.DatanodeID] = dn
+					nn.offline[h.DatanodeID] = true
+				}
+				dn.size += int64(h.Size)
+			}
+		}
+		return true
+	})
+	nn.metaLog.Info("Opened metadata store", "file", nn.metadatastore, "files", m.Len())
+	return nil
+}
+
+// compactFileMap keeps every file in memory compactly. The path of a file
+// is kept once rather than in the header of each replica, each Block is one
+// record of what its replicas share, and each replica is the number of its
+// datanode in a table of interned names. Replicas differing from the record
+// of their Block are kept whole, so the Blocks of a file read back as they
+// were stored.
+type compactFileMap struct {
+	files map[string]*compactFile
+	names internTable
+}
+
+// compactFile is the Blocks of a file kept by a compactFileMap
+type compactFile struct {
+	blocks []compactBlock // by Block number
+	odd    []BlockHeader  // replicas which differ from the record of their Block
+}
+
+// compactBlock is a Block of a compactFile
+type compactBlock struct {
+	num       int
+	numBlocks int
+	size      int
+	genStamp  int64
+	id        int64
+	nonce     string
+	codec     uint32   // interned
+	replicas  []uint32 // interned datanode IDs, or with oddReplica set the index of the replica in odd
+}
+
+// marks a replica of a compactBlock kept whole
+const oddReplica = 1 << 31
+
+// internTable numbers the datanode IDs and codecs of a compactFileMap, so
+// each is kept once
+type internTable struct {
+	names   []string
+	numbers map[string]uint32
+}
+
+// number returns the number of name, adding it to the table if it is new
+func (t *internTable) number(name string) uint32 {
+	n, ok := t.numbers[name]
+	if !ok {
+		if t.numbers == nil {
+			t.numbers = make(map[string]uint32)
+		}
+		n = uint32(len(t.names))
+		t.names = append(t.names, name)
+		t.numbers[name] = n
+	}
+	return n
+}
+
+func newCompactFileMap() *compactFileMap {
+	return &compactFileMap{files: make(map[string]*compactFile)}
+}
+
+// newCompactBlock returns the record of Block h.BlockNum shared by replicas
+// like h, without replicas
+func (t *internTable) newCompactBlock(h BlockHeader) compactBlock {
+	return compactBlock{num: h.BlockNum, numBlocks: h.NumBlocks, size: h.Size, genStamp: h.GenStamp, id: h.BlockID, nonce: h.Nonce, codec: t.number(h.Codec)}
+}
+
+// replica returns the compact form of the replica h of Block b of the file
+// at path, keeping it whole in f if it differs from the record of b
+func (t *internTable) replica(path string, f *compactFile, b *compactBlock, h BlockHeader) uint32 {
+	if h.Filename == path && h.BlockNum == b.num && h.NumBlocks == b.numBlocks && h.Size == b.size &&
+		h.GenStamp == b.genStamp && h.BlockID == b.id && h.Nonce == b.nonce && h.Codec == t.names[b.codec] {
+		if n := t.number(h.DatanodeID); n < oddReplica {
+			return n
+		}
+	}
+	f.odd = append(f.odd, h)
+	return oddReplica | uint32(len(f.odd)-1)
+}
+
+// header returns the replica r of Block b of the file at path whole
+func (t *internTable) header(path string, f *compactFile, b *compactBlock, r uint32) BlockHeader {
+	if r&oddReplica != 0 {
+		return f.odd[r&^oddReplica]
+	}
+	return BlockHeader{t.names[r], path, b.size, b.num, b.numBlocks, b.genStamp, t.names[b.codec], b.id, b.nonce}
+}
+
+// headers returns the replicas of Block b of the file at path whole
+func (t *internTable) headers(path string, f *compactFile, b *compactBlock) []BlockHeader {
+	replicas := make([]BlockHeader, len(b.replicas))
+	for i, r := range b.replicas {
+		replicas[i] = t.header(path, f, b, r)
+	}
+	return replicas
+}
+
+// pack returns the compact form of the Blocks of the file at path
+func (t *internTable) pack(path string, blocks map[int][]BlockHeader) *compactFile {
+	f := &compactFile{blocks: make([]compactBlock, 0, len(blocks))}
+	for num, replicas := range blocks {
+		b := compactBlock{num: num}
+		if len(replicas) > 0 {
+			b = t.newCompactBlock(replicas[0])
+			b.num = num
+		}
+		b.replicas = make([]uint32, len(replicas))
+		for i, h := range replicas {
+			b.replicas[i] = t.replica(path, f, &b, h)
+		}
+		f.blocks = append(f.blocks, b)
+	}
+	sort.Slice(f.blocks, func(i, j int) bool { return f.blocks[i].num < f.blocks[j].num })
+	return f
+}
+
+// unpack returns the Blocks of the file at path from their compact form
+func (t *internTable) unpack(path string, f *compactFile) map[int][]BlockHeader {
+	blocks := make(map[int][]BlockHeader, len(f.blocks))
+	for i := range f.blocks {
+		b := &f.blocks[i]
+		blocks[b.num] = t.headers(path, f, b)
+	}
+	return blocks
+}
+
+// find returns the index of Block num in f.blocks, or where it belongs if
+// it is not there
+func (f *compactFile) find(num int) (int, bool) {
+	i := sort.Search(len(f.blocks), func(i int) bool { return f.blocks[i].num >= num })
+	return i, i < len(f.blocks) && f.blocks[i].num == num
+}
+
+// add adds the replica h to f, the file at path, in place. It returns false
+// if f holds h already.
+func (t *internTable) add(path string, f *compactFile, h BlockHeader) bool {
+	i, ok := f.find(h.BlockNum)
+	if !ok {
+		f.blocks = append(f.blocks, compactBlock{})
+		copy(f.blocks[i+1:], f.blocks[i:])
+		f.blocks[i] = t.newCompactBlock(h)
+	}
+	b := &f.blocks[i]
+	for _, r := range b.replicas {
+		if t.header(path, f, b, r) == h {
+			return false
+		}
+	}
+	b.replicas = append(b.replicas, t.replica(path, f, b, h))
+	return true
+}
+
+// remove removes the replica h from f, the file at path, in place, and the
+// Block of h once it has no replicas left. It returns false if f does not
+// hold h.
+func (t *internTable) remove(path string, f *compactFile, h BlockHeader) bool {
+	i, ok := f.find(h.BlockNum)
+	if !ok {
+		return false
+	}
+	b := &f.blocks[i]
+	for j, r := range b.replicas {
+		if t.header(path, f, b, r) != h {
+			continue
+		}
+		b.replicas = append(b.replicas[:j], b.replicas[j+1:]...)
+		if r&oddReplica != 0 {
+			f.dropOdd(r &^ oddReplica)
+		}
+		if len(b.replicas) == 0 {
+			f.blocks = append(f.blocks[:i], f.blocks[i+1:]...)
+		}
+		return true
+	}
+	return false
+}
+
+// dropOdd removes replica k from f.odd, renumbering those after it
+func (f *compactFile) dropOdd(k uint32) {
+	f.odd = append(f.odd[:k], f.odd[k+1:]...)
+	for i := range f.blocks {
+		for j, r := range f.blocks[i].replicas {
+			if r&oddReplica != 0 && r&^oddReplica > k {
+				f.blocks[i].replicas[j] = r - 1
+			}
+		}
+	}
+}
+
+// block returns the replicas of Block num of f, the file at path
+func (t *internTable) block(path string, f *compactFile, num int) []BlockHeader {
+	i, ok := f.find(num)
+	if !ok {
+		return nil
+	}
+	return t.headers(path, f, &f.blocks[i])
+}
+
+func (m *compactFileMap) Get(path string) (map[int][]BlockHeader, bool) {
+	f, ok := m.files[path]
+	if !ok {
+		return nil, false
+	}
+	return m.names.unpack(path, f), true
+}
+
+func (m *compactFileMap) Put(path string, blocks map[int][]BlockHeader) {
+	m.files[path] = m.names.pack(path, blocks)
+}
+
+func (m *compactFileMap) Block(path string, num int) ([]BlockHeader, bool) {
+	f, ok := m.files[path]
+	if !ok {
+		return nil, false
+	}
+	return m.names.block(path, f, num), true
+}
+
+func (m *compactFileMap) Add(h BlockHeader) bool {
+	f, ok := m.files[h.Filename]
+	if !ok {
+		f = &compactFile{}
+		m.files[h.Filename] = f
+	}
+	return m.names.add(h.Filename, f, h)
+}
+
+func (m *compactFileMap) Remove(h BlockHeader) (int, bool) {
+	f, ok := m.files[h.Filename]
+	if !ok {
+		return 0, false
+	}
+	removed := m.names.remove(h.Filename, f, h)
+	return len(f.blocks), removed
+}
+
+func (m *compactFileMap) Delete(path string) {
+	delete(m.files, path)
+}
+
+func (m *compactFileMap) Range(fn func(path string, blocks map[int][]BlockHeader) bool) {
+	for path, f := range m.files {
+		if !fn(path, m.names.unpack(path, f)) {
+			return
+		}
+	}
+}
+
+func (m *compactFileMap) Len() int {
+	return len(m.files)
+}
+
+func (m *compactFileMap) Close() error {
+	return nil
+}
+
+// cachedFile is an entry of the cache of a storedFileMap, kept compactly
+type cachedFile struct {
+	path string
+	file *compactFile
+}
+
+// storedFileMap keeps files in a logStore, so they survive a crash, with
+// the most recently used ones cached in memory. Changes are written through
+// to the store at once.
+type storedFileMap struct {
+	lock  sync.Mutex
+	store *logStore
+	log   *slog.Logger
+	size  int                      // files cached at most
+	lru   *list.List               // cached files, most recently used first
+	cache map[string]*list.Element // cached files by path
+	names internTable              // of the cached files
+}
+
+// openStoredFileMap opens the file map kept in the store at path, caching
+// up to size files
+func openStoredFileMap(path string, size int, logger *slog.Logger) (*storedFileMap, error) {
+	s, err := openLogStore(path)
+	if err != nil {
+		return nil, err
+	}
+	return &storedFileMap{
+		store: s,
+		log:   logger,
+		size:  size,
+		lru:   list.New(),
+		cache: make(map[string]*list.Element),
+	}, nil
+}
+
+// load reads the Blocks of a file from the store. The caller must hold lock.
+func (m *storedFileMap) load(path string) (map[int][]BlockHeader, bool) {
+	if e, ok := m.cache[path]; ok {
+		m.lru.MoveToFront(e)
+		return m.names.unpack(path, e.Value.(*cachedFile).file), true
+	}
+	data, ok, err := m.store.Get(path)
+	if err != nil {
+		m.log.Error("Could not read file from metadata store", "path", path, "err", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	var blocks map[int][]BlockHeader
+	err = json.Unmarshal(data, &blocks)
+	if err != nil {
+		m.log.Error("Could not decode file from metadata store", "path", path, "err", err)
+		return nil, false
+	}
+	return blocks, true
+}
+
+// remember caches the Blocks of a file, evicting the least recently used
+// files beyond the cache size, and returns their compact form. The caller
+// must hold lock.
+func (m *storedFileMap) remember(path string, blocks map[int][]BlockHeader) *compactFile {
+	f := m.names.pack(path, blocks)
+	if e, ok := m.cache[path]; ok {
+		e.Value.(*cachedFile).file = f
+		m.lru.MoveToFront(e)
+		return f
+	}
+	m.cache[path] = m.lru.PushFront(&cachedFile{path, f})
+	m.evict()
+	return f
+}
+
+// evict drops the least recently used files beyond the cache size. The
+// caller must hold lock.
+func (m *storedFileMap) evict() {
+	for m.lru.Len() > m.size {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.cache, oldest.Value.(*cachedFile).path)
+	}
+}
+
+// cached returns the compact form of the file at path, caching it if it is
+// only in the store. The caller must hold lock.
+func (m *storedFileMap) cached(path string) (*compactFile, bool) {
+	if e, ok := m.cache[path]; ok {
+		m.lru.MoveToFront(e)
+		return e.Value.(*cachedFile).file, true
+	}
+	blocks, ok := m.load(path)
+	if !ok {
+		return nil, false
+	}
+	return m.remember(path, blocks), true
+}
+
+// write writes the Blocks of the file at path to the store. The caller must
+// hold lock.
+func (m *storedFileMap) write(path string, blocks map[int][]BlockHeader) {
+	data, err := json.Marshal(blocks)
+	if err == nil {
+		err = m.store.Put(path, data)
+	}
+	if err != nil {
+		m.log.Error("Could not write file to metadata store", "path", path, "err", err)
+	}
+}
+
+func (m *storedFileMap) Get(path string) (map[int][]BlockHeader, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, cached := m.cache[path]
+	blocks, ok := m.load(path)
+	if ok && !cached {
+		m.remember(path, blocks)
+	}
+	return blocks, ok
+}
+
+func (m *storedFileMap) Put(path string, blocks map[int][]BlockHeader) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.remember(path, blocks)
+	m.write(path, blocks)
+}
+
+func (m *storedFileMap) Block(path string, num int) ([]BlockHeader, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	f, ok := m.cached(path)
+	if !ok {
+		return nil, false
+	}
+	return m.names.block(path, f, num), true
+}
+
+// Add and Remove change the cached file in place, but the store keeps each
+// file as one record, which is written again whole
+func (m *storedFileMap) Add(h BlockHeader) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	f, ok := m.cached(h.Filename)
+	if !ok {
+		f = &compactFile{}
+		m.cache[h.Filename] = m.lru.PushFront(&cachedFile{h.Filename, f})
+		m.evict()
+	}
+	if !m.names.add(h.Filename, f, h) {
+		return false
+	}
+	m.write(h.Filename, m.names.unpack(h.Filename, f))
+	return true
+}
+
+func (m *storedFileMap) Remove(h BlockHeader) (int, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	f, ok := m.cached(h.Filename)
+	if !ok {
+		return 0, false
+	}
+	if !m.names.remove(h.Filename, f, h) {
+		return len(f.blocks), false
+	}
+	m.write(h.Filename, m.names.unpack(h.Filename, f))
+	return len(f.blocks), true
+}
+
+func (m *storedFileMap) Delete(path string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if e, ok := m.cache[path]; ok {
+		m.lru.Remove(e)
+		delete(m.cache, path)
+	}
+	err := m.store.Delete(path)
+	if err != nil {
+		m.log.Error("Could not delete file from metadata store", "path", path, "err", err)
+	}
+}
+
+// Range reads files which are not cached without caching them, so a scan
+// of the namespace does not evict the files in use
+func (m *storedFileMap) Range(fn func(path string, blocks map[int][]BlockHeader) bool) {
+	for _, path := range m.store.Keys() {
+		m.lock.Lock()
+		blocks, ok := m.load(path)
+		m.lock.Unlock()
+		if ok && !fn(path, blocks) {
+			return
+		}
+	}
+}
+
+func (m *storedFileMap) Len() int {
+	return m.store.Len()
+}
+
+func (m *storedFileMap) Close() error {
+	return m.store.Close()
+}
@@ -0,0 +1,173 @@
+package namenode
+
+import (
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestMetadataStore(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "files.log")
+	nn := New()
+	nn.metadatastore = path
+	nn.metadatacache = 2
+	if err := nn.LoadMetadata(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	for i := 0; i < 5; i++ {
+		nn.MergeNode(BlockHeader{"DN1", "/dir/out" + strconv.Itoa(i) + ".txt", 1, 0, 2, int64(i + 1), "", 0, ""})
+		nn.MergeNode(BlockHeader{"DN1", "/dir/out" + strconv.Itoa(i) + ".txt", 1, 1, 2, int64(i + 1), "", 0, ""})
+	}
+	nn.removeReplica(BlockHeader{"DN1", "/dir/out0.txt", 1, 1, 2, 1, "", 0, ""})
+	nn.Delete("/dir/out1.txt", false)
+
+	// only the most recently used files are held in memory
+	m := nn.filemap.(*storedFileMap)
+	if m.lru.Len() > 2 || nn.filemap.Len() != 4 {
+		t.Errorf("Expected at most 2 of 4 files cached, got %d of %d", m.lru.Len(), nn.filemap.Len())
+	}
+	blocks, ok := nn.filemap.Get("/dir/out2.txt")
+	if !ok || len(blocks[1]) != 1 {
+		t.Errorf("Evicted file not read back %v", blocks)
+	}
+
+	// the store survives the namenode stopping without saving its metadata
+	m.Close()
+	restarted := New()
+	restarted.metadatastore = path
+	if err := restarted.LoadMetadata(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer restarted.filemap.Close()
+	if st, err := restarted.Stat("/dir/out0.txt"); err != nil || st.NumBlocks != 2 || st.Replication != 0 {
+		t.Errorf("Removed replica was restored %v %v", st, err)
+	}
+	if _, err := restarted.Stat("/dir/out1.txt"); err == nil {
+		t.Errorf("Deleted file was restored")
+	}
+	if list, err := restarted.ListDir("/dir", false); err != nil || len(list) != 4 {
+		t.Errorf("Namespace not rebuilt %v %v", list, err)
+	}
+	dn, ok := restarted.datanodemap["DN1"]
+	if !ok || !restarted.offline["DN1"] || dn.size != 7 {
+		t.Errorf("Datanode not restored %v", dn)
+	}
+	if restarted.nextGenStamp() <= 5 {
+		t.Errorf("Generation stamps restarted")
+	}
+}
+
+func TestCompactFileMap(t *testing.T) {
+
+	m := newCompactFileMap()
+	blocks := map[int][]BlockHeader{
+		0: {{"DN1", "/a.txt", 4, 0, 3, 7, "", 0, ""}, {"DN2", "/a.txt", 4, 0, 3, 7, "", 0, ""}, {"DN3", "/a.txt", 4, 0, 3, 7, "", 0, ""}},
+		// a stale replica and one under another name are kept whole, in order
+		1: {{"DN2", "/a.txt", 4, 1, 3, 8, "snappy", 0, ""}, {"DN1", "/a.txt", 4, 1, 3, 5, "snappy", 0, ""}, {"DN3", "/.snapshot/a.txt", 4, 1, 3, 8, "snappy", 0, ""}},
+		2: {},
+		9: {{"DN1", "/a.txt", 1, 9, 3, 8, "", 0, ""}},
+	}
+	m.Put("/a.txt", blocks)
+	got, ok := m.Get("/a.txt")
+	if !ok || !reflect.DeepEqual(got, blocks) {
+		t.Fatalf("Expected the Blocks stored, got %v", got)
+	}
+	if f := m.files["/a.txt"]; len(f.odd) != 2 || len(m.names.names) != 5 {
+		t.Errorf("Expected 2 replicas kept whole and 5 names, got %v %v", f.odd, m.names.names)
+	}
+
+	// the Blocks read are a copy, changed only by a Put
+	got[0] = got[0][:1]
+	if again, _ := m.Get("/a.txt"); len(again[0]) != 3 {
+		t.Errorf("Blocks changed without a Put")
+	}
+	m.Delete("/a.txt")
+	if _, ok := m.Get("/a.txt"); ok || m.Len() != 0 {
+		t.Errorf("Deleted file kept")
+	}
+}
+
+func TestCompactFileMapMemory(t *testing.T) {
+
+	// headers decoded from packets each hold their own copy of the path
+	fill := func(put func(path string, blocks map[int][]BlockHeader)) {
+		for i := 0; i < 2000; i++ {
+			path := "/data/logs/2024/part-" + strconv.Itoa(i) + ".log"
+			blocks := make(map[int][]BlockHeader, 10)
+			for num := 0; num < 10; num++ {
+				for _, dn := range []string{"DN1", "DN2", "DN3"} {
+					blocks[num] = append(blocks[num], BlockHeader{dn, string([]byte(path)), 1 << 20, num, 10, int64(i), "", 0, ""})
+				}
+			}
+			put(path, blocks)
+		}
+	}
+	heap := func() uint64 {
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		return stats.HeapAlloc
+	}
+	before := heap()
+	plain := make(map[string]map[int][]BlockHeader)
+	fill(func(path string, blocks map[int][]BlockHeader) { plain[path] = blocks })
+	full := heap() - before
+	runtime.KeepAlive(plain)
+	plain = nil
+
+	before = heap()
+	compact := newCompactFileMap()
+	fill(compact.Put)
+	packed := heap() - before
+	runtime.KeepAlive(compact)
+	if packed*3 > full {
+		t.Errorf("Compact Blocks take %d bytes, not a third of the %d of full headers", packed, full)
+	}
+}
+
+func TestCompactFileMapReplicas(t *testing.T) {
+
+	m := newCompactFileMap()
+	a := BlockHeader{"DN1", "/a.txt", 4, 2, 3, 7, "", 1, ""}
+	stale := BlockHeader{"DN2", "/a.txt", 4, 2, 3, 5, "", 1, ""}
+	b := BlockHeader{"DN3", "/a.txt", 4, 0, 3, 7, "", 2, ""}
+	for _, h := range []BlockHeader{a, stale, b} {
+		if !m.Add(h) {
+			t.Errorf("Replica %v not added", h)
+		}
+	}
+	if m.Add(a) {
+		t.Errorf("Replica added twice")
+	}
+	if replicas, ok := m.Block("/a.txt", 2); !ok || !reflect.DeepEqual(replicas, []BlockHeader{a, stale}) {
+		t.Errorf("Expected the replicas added, got %v", replicas)
+	}
+	if replicas, ok := m.Block("/a.txt", 1); !ok || replicas != nil {
+		t.Errorf("Expected no replicas of a missing Block, got %v", replicas)
+	}
+	if _, ok := m.Block("/b.txt", 0); ok {
+		t.Errorf("Missing file found")
+	}
+
+	// the Blocks are kept in order, as a Put of them would keep them
+	blocks, _ := m.Get("/a.txt")
+	if f := m.files["/a.txt"]; f.blocks[0].num != 0 || !reflect.DeepEqual(m.names.pack("/a.txt", blocks), f) {
+		t.Errorf("Expected the Blocks packed by number, got %+v", f)
+	}
+
+	// a replica kept whole is dropped from the file with its replica
+	if left, ok := m.Remove(stale); !ok || left != 2 || len(m.files["/a.txt"].odd) != 0 {
+		t.Errorf("Stale replica not removed, %d Blocks left", left)
+	}
+	if _, ok := m.Remove(stale); ok {
+		t.Errorf("Replica removed twice")
+	}
+	m.Remove(a)
+	if left, ok := m.Remove(b); !ok || left != 0 {
+		t.Errorf("Expected no Blocks left, got %d", left)
+	}
+}
@@ -0,0 +1,219 @@
+package namenode
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// time a datanode has to sync replicas before it is asked again
+const syncTimeout = 10 * time.Second
+
+// Flush makes the Blocks of the file at path its writer holder has stored so
+// far durable on replicas datanodes each, 1 if it is 0. The datanodes
+// holding replicas not yet on stable storage are asked to sync them, and
+// Blocks with too few replicas are copied. It returns the bytes of the
+// leading Blocks of the file which are durable, which the writer asks for
+// again until they cover what it wrote. The longest such length is kept
+// with the lease as the file's visible length.
+func (nn *NameNode) Flush(path, holder string, replicas int) (int64, error) {
+	err := nn.checkLease(path, holder)
+	if err != nil {
+		return 0, err
+	}
+	if replicas == 0 {
+		replicas = 1
+	}
+	if replicas < 0 || replicas > nn.neededReplicas(path) {
+		return 0, errors.New("Cannot flush " + path + " to " + strconv.Itoa(replicas) + " replicas, its replication is " + strconv.Itoa(nn.neededReplicas(path)))
+	}
+	// Blocks still on their way to a datanode are not durable yet
+	if nn.metrics.isDistributing(path) {
+		return nn.visibleLength(path), nil
+	}
+
+	blocks, _ := nn.filemap.Get(path)
+	asks := make(map[string][]BlockHeader)
+	durable := true
+	var length int64
+	for num := 0; num < len(blocks); num++ {
+		kept := nn.keptReplicas(blocks[num])
+		if len(kept) == 0 {
+			break
+		}
+		if nn.syncedReplicas(kept, asks) >= replicas && durable {
+			length += int64(kept[0].Size)
+			continue
+		}
+		durable = false
+		if len(kept) < replicas {
+			nn.queueReplication(path)
+		}
+	}
+	for id, headers := range asks {
+		err = nn.SendPacket(Packet{SRC: nn.id, DST: id, CMD: SYNC, Headers: headers})
+		if err != nil {
+			nn.metaLog.Warn("Could not ask datanode to sync Blocks", "datanode", id, "file", path, "err", err)
+		}
+	}
+
+	nn.leaseLock.Lock()
+	if l, ok := nn.leases[path]; ok && length > l.Visible {
+		l.Visible = length
+	}
+	nn.leaseLock.Unlock()
+	return length, nil
+}
+
+// syncedReplicas counts the replicas which are on stable storage, adding
+// those to ask their datanodes to sync to asks
+func (nn *NameNode) syncedReplicas(replicas []BlockHeader, asks map[string][]BlockHeader) int {
+	nn.syncLock.Lock()
+	defer nn.syncLock.Unlock()
+
+	synced := 0
+	for _, h := range replicas {
+		if nn.synced[h] {
+			synced++
+			continue
+		}
+		dn, ok := nn.datanodemap[h.DatanodeID]
+		if !ok || nn.offline[h.DatanodeID] || !dn.supports("sync") {
+			continue
+		}
+		if asked, ok := nn.syncing[h]; ok && time.Since(asked) < syncTimeout {
+			continue
+		}
+		nn.syncing[h] = time.Now()
+		asks[h.DatanodeID] = append(asks[h.DatanodeID], h)
+	}
+	return synced
+}
+
+// CompleteSync records the replicas a datanode reports are on stable storage
+func (nn *NameNode) CompleteSync(id string, headers []BlockHeader) {
+	nn.syncLock.Lock()
+	defer nn.syncLock.Unlock()
+	for _, h := range headers {
+		if h.DatanodeID != id {
+			continue
+		}
+		delete(nn.syncing, h)
+		nn.synced[h] = true
+	}
+}
+
+// forgetSyncs drops the synced replicas of the file at path once it is no
+// longer written
+func (nn *NameNode) forgetSyncs(path string) {
+	nn.syncLock.Lock()
+	defer nn.syncLock.Unlock()
+	for h := range nn.synced {
+		if h.Filename == path {
+			delete(nn.synced, h)
+		}
+	}
+	for h := range nn.syncing {
+		if h.Filename == path {
+			delete(nn.syncing, h)
+		}
+	}
+}
+
+// visibleLength is the longest length of the file at path found durable by
+// a FLUSH of its writer, 0 if it is not being written
+func (nn *NameNode) visibleLength(path string) int64 {
+	nn.leaseLock.Lock()
+	defer nn.leaseLock.Unlock()
+	if l, ok := nn.leases[path]; ok {
+		return l.Visible
+	}
+	return 0
+}
+
+// keepFlushed cuts the file at path, left incomplete by a writer which
+// flushed visible bytes of it, down to the Blocks holding them, renaming
+// their replicas to headers counting the Blocks left. It reports false if
+// no Blocks were flushed.
+func (nn *NameNode) keepFlushed(path string, visible int64) bool {
+	blocks, ok := nn.filemap.Get(path)
+	if !ok || visible <= 0 {
+		return false
+	}
+	n, length := 0, int64(0)
+	for length < visible {
+		if len(blocks[n]) == 0 {
+			return false
+		}
+		length += int64(blocks[n][0].Size)
+		n++
+	}
+	if length != visible {
+		return false
+	}
+
+	orders := make(map[string][]renameOrder)
+	for num, replicas := range blocks {
+		if num >= n {
+			for _, h := range replicas {
+				if dn, ok := nn.datanodemap[h.DatanodeID]; ok {
+					dn.size -= int64(h.Size)
+				}
+				nn.Invalidate(h)
+			}
+			delete(blocks, num)
+			continue
+		}
+		kept := make([]BlockHeader, len(replicas))
+		for i, h := range replicas {
+			kept[i] = h
+			kept[i].NumBlocks = n
+			orders[h.DatanodeID] = append(orders[h.DatanodeID], renameOrder{h, kept[i]})
+		}
+		blocks[num] = kept
+	}
+	nn.filemap.Put(path, blocks)
+	nn.modified(path)
+	for id, list := range orders {
+		nn.renameBlocks(id, list)
+	}
+	nn.metaLog.Info("Kept flushed Blocks of recovered file", "path", path, "length", visible, "blocks", n)
+	return true
+}
+
+// readableLength reports the bytes readers may read of the file at path,
+// held in blocks, while its writer holds the lease: those it flushed. Files
+// whose Blocks are all written and none flushed are read whole.
+func (nn *NameNode) readableLength(path string, blocks map[int][]BlockHeader) (int64, bool) {
+	nn.leaseLock.Lock()
+	l, ok := nn.leases[path]
+	leased := ok && l.Holder != "" && time.Since(l.Renewed) < leaseTimeout
+	var visible int64
+	if leased {
+		visible = l.Visible
+	}
+	nn.leaseLock.Unlock()
+	if !leased {
+		return 0, false
+	}
+	if visible == 0 && blocksStatus(path, blocks).Replication > 0 {
+		return 0, false
+	}
+	return visible, true
+}
+
+// visibleHeaders returns the closest replica of each leading Block of a file
+// holding its first visible bytes, the last cut down to those it holds
+func (nn *NameNode) visibleHeaders(blocks map[int][]BlockHeader, visible int64) []BlockHeader {
+	headers := []BlockHeader{}
+	var length int64
+	for num := 0; length < visible && len(blocks[num]) > 0; num++ {
+		h := nn.sortByDistance(nn.clientHost, blocks[num])[0]
+		if length+int64(h.Size) > visible {
+			h.Size = int(visible - length)
+		}
+		headers = append(headers, h)
+		length += int64(h.Size)
+	}
+	return headers
+}
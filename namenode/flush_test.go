@@ -0,0 +1,119 @@
+package namenode
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestFlush(t *testing.T) {
+
+	nn := New()
+	nn.replication = 2
+	for _, id := range []string{"DN1", "DN2"} {
+		nn.datanodemap[id] = &datanode{ID: id, listed: true, features: []string{"sync"}}
+	}
+	if err := nn.AcquireLease("/log.txt", "w1"); err != nil {
+		t.Fatal(err)
+	}
+	first := BlockHeader{"DN1", "/log.txt", 4, 0, 3, 1, "", 0, ""}
+	second := BlockHeader{"DN1", "/log.txt", 2, 1, 3, 2, "", 0, ""}
+	nn.MergeNode(first)
+	nn.MergeNode(second)
+
+	if _, err := nn.Flush("/log.txt", "w2", 1); err == nil {
+		t.Errorf("Flushed a file leased to another writer")
+	}
+	if _, err := nn.Flush("/log.txt", "w1", 3); err == nil {
+		t.Errorf("Flushed to more replicas than the file's replication")
+	}
+
+	// replicas are durable once their datanode syncs them
+	visible, err := nn.Flush("/log.txt", "w1", 1)
+	if err != nil || visible != 0 {
+		t.Fatalf("Visible length %d before syncing %v", visible, err)
+	}
+	if _, ok := nn.syncing[first]; !ok {
+		t.Errorf("Datanode not asked to sync %v", first)
+	}
+	nn.CompleteSync("DN2", []BlockHeader{first})
+	nn.CompleteSync("DN1", []BlockHeader{first})
+	if visible, _ = nn.Flush("/log.txt", "w1", 1); visible != 4 {
+		t.Errorf("Expected 4 bytes visible, got %d", visible)
+	}
+	nn.CompleteSync("DN1", []BlockHeader{second})
+	if visible, _ = nn.Flush("/log.txt", "w1", 1); visible != 6 {
+		t.Errorf("Expected 6 bytes visible, got %d", visible)
+	}
+
+	// two replicas wait for the second to be copied and synced
+	if visible, _ = nn.Flush("/log.txt", "w1", 2); visible != 0 {
+		t.Errorf("Expected nothing durable on two replicas, got %d", visible)
+	}
+	if !nn.replicationQueue["/log.txt"] {
+		t.Errorf("Replication of the file not checked")
+	}
+	if nn.visibleLength("/log.txt") != 6 {
+		t.Errorf("Visible length %d not kept", nn.visibleLength("/log.txt"))
+	}
+
+	// a recovered file keeps the Blocks its writer flushed
+	nn.leaseLock.Lock()
+	nn.recoverLease("/log.txt", nn.leases["/log.txt"])
+	nn.leaseLock.Unlock()
+	st, err := nn.Stat("/log.txt")
+	if err != nil || st.Size != 6 || st.NumBlocks != 2 || st.Replication != 1 {
+		t.Errorf("Recovered file %+v %v", st, err)
+	}
+	if len(nn.synced) != 0 || len(nn.syncing) != 0 {
+		t.Errorf("Synced replicas kept after recovery %v %v", nn.synced, nn.syncing)
+	}
+}
+
+func TestReadWhileWrite(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true, features: []string{"sync"}}
+	conn, peer := net.Pipe()
+	nn.SetOutbound("C", conn)
+	dec := json.NewDecoder(peer)
+	headers := func() Packet {
+		go nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: GETHEADERS, Headers: []BlockHeader{{Filename: "/log.txt"}}})
+		var r Packet
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	// nothing is readable until the writer flushes
+	if err := nn.AcquireLease("/log.txt", "w1"); err != nil {
+		t.Fatal(err)
+	}
+	partial := BlockHeader{"DN1", "/log.txt", 2, 1, 3, 2, "", 0, ""}
+	nn.MergeNode(BlockHeader{"DN1", "/log.txt", 4, 0, 3, 1, "", 0, ""})
+	nn.MergeNode(partial)
+	if r := headers(); r.CMD != GETHEADERS || len(r.Headers) != 0 || len(r.Status) != 1 || !r.Status[0].Writing || r.Status[0].Size != 0 {
+		t.Errorf("Expected no Blocks readable before a flush, got %v", r)
+	}
+
+	nn.CompleteSync("DN1", []BlockHeader{{"DN1", "/log.txt", 4, 0, 3, 1, "", 0, ""}, partial})
+	if visible, _ := nn.Flush("/log.txt", "w1", 1); visible != 6 {
+		t.Fatalf("Expected 6 bytes flushed, got %d", visible)
+	}
+	// the partial Block filled since is read as far as it was flushed
+	nn.MergeNode(BlockHeader{"DN1", "/log.txt", 4, 1, 3, 3, "", 0, ""})
+	r := headers()
+	if len(r.Headers) != 2 || r.Headers[1].Size != 2 || r.Headers[1].GenStamp != 3 || r.Status[0].Size != 6 || r.Status[0].NumBlocks != 2 {
+		t.Errorf("Expected the flushed 6 bytes readable, got %v", r)
+	}
+
+	// a complete file is read whole once the writer releases it
+	nn.MergeNode(BlockHeader{"DN1", "/log.txt", 1, 2, 3, 4, "", 0, ""})
+	if err := nn.ReleaseLease("/log.txt", "w1"); err != nil {
+		t.Fatal(err)
+	}
+	if r := headers(); len(r.Headers) != 3 || r.Headers[1].Size != 4 || len(r.Status) != 0 {
+		t.Errorf("Expected the whole file readable, got %v", r)
+	}
+}
@@ -0,0 +1,81 @@
+package namenode
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestFrames(t *testing.T) {
+
+	var buf bytes.Buffer
+	e := newFrameEncoder(&buf)
+	data := []byte{0, 1, 2, 255}
+	good := Packet{SRC: "DN1", DST: "NN", CMD: BLOCK, Data: Block{BlockHeader{"DN1", "/f", 4, 0, 1, 1, "", 0, ""}, data}}
+
+	e.Encode(good)
+	if bytes.Contains(buf.Bytes(), []byte("AAEC/w==")) || !bytes.HasSuffix(buf.Bytes(), data) {
+		t.Fatalf("Block data not sent raw %q", buf.Bytes())
+	}
+	oneFrame := buf.Len()
+
+	// a frame with a flipped bit, garbage between frames and a frame too long
+	e.Encode(good)
+	buf.Bytes()[oneFrame+frameHeaderSize+3] ^= 1
+	buf.WriteString("garbage")
+	e.Encode(good)
+	maxPacketSize = int64(oneFrame)
+	defer func() { maxPacketSize = 1 << 28 }()
+	e.Encode(Packet{SRC: "DN1", DST: "NN", CMD: BLOCK, Data: Block{Data: make([]byte, oneFrame)}})
+	e.Encode(&Packet{SRC: "DN1", DST: "NN", CMD: HB})
+	buf.Write([]byte(frameMagic))
+
+	d := newFrameDecoder(&buf)
+	var got []Packet
+	bad := 0
+	for {
+		var p Packet
+		err := d.Decode(&p)
+		if isBadFrame(err) {
+			bad++
+			continue
+		}
+		if err != nil {
+			break
+		}
+		got = append(got, p)
+	}
+	if bad != 3 || len(got) != 3 {
+		t.Fatalf("Expected 3 frames and 3 skipped, got %d and %d", len(got), bad)
+	}
+	if got[0].CMD != BLOCK || !bytes.Equal(got[0].Data.Data, data) || got[0].Data.Header != good.Data.Header {
+		t.Errorf("Wrong frame decoded %v", got[0])
+	}
+	if got[2].CMD != HB || got[2].Data.Data != nil {
+		t.Errorf("Wrong frame decoded %v", got[2])
+	}
+}
+
+func TestFrameDetection(t *testing.T) {
+
+	for _, framed := range []bool{false, true} {
+		local, remote := net.Pipe()
+		go func() {
+			if framed {
+				newFrameEncoder(remote).Encode(Packet{SRC: "DN1", DST: "NN", CMD: HB})
+			} else {
+				newPacketEncoder(remote).Encode(Packet{SRC: "DN1", DST: "NN", CMD: HB})
+			}
+		}()
+		decoder, conn := newPacketDecoder(local)
+		var p Packet
+		if err := decoder.Decode(&p); err != nil || p.CMD != HB {
+			t.Errorf("Could not decode %v %s", p, err)
+		}
+		if _, ok := newPacketEncoder(conn).(*frameEncoder); ok != framed {
+			t.Errorf("Expected framed replies %t", framed)
+		}
+		local.Close()
+		remote.Close()
+	}
+}
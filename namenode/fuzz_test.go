@@ -0,0 +1,214 @@
+package namenode
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// fuzzRequests are the packets the fuzz targets start from
+var fuzzRequests = []Packet{
+	{SRC: "C", DST: "NN", CMD: LIST},
+	{SRC: "C", DST: "NN", CMD: STAT, Headers: []BlockHeader{{Filename: "/dir/a.txt"}}},
+	{SRC: "C", DST: "NN", CMD: MKDIR, Flags: PARENTS, Headers: []BlockHeader{{Filename: "/dir/sub"}}},
+	{SRC: "C", DST: "NN", CMD: RENAME, Headers: []BlockHeader{{Filename: "/dir/a.txt"}}, Renamed: []BlockHeader{{Filename: "/b.txt"}}},
+	{SRC: "C", DST: "NN", CMD: GETHEADERS, Offset: 1, Limit: 1, Headers: []BlockHeader{{Filename: "/dir/a.txt"}}},
+	{SRC: "C", DST: "NN", CMD: RETRIEVEBLOCK, Headers: []BlockHeader{{"DN1", "/dir/a.txt", 1, 0, 2, 1, "", 0, ""}}},
+	{SRC: "C", DST: "NN", CMD: DISTRIBUTE, Message: "writer", Data: Block{Header: BlockHeader{"", "/new.txt", 1, 0, 1, 0, "", 0, ""}, Data: []byte("a")}},
+	{SRC: "C", DST: "NN", CMD: LEASE, Message: "writer", Headers: []BlockHeader{{Filename: "/new.txt"}}, Status: []FileStatus{{BlockSize: 1024}}},
+	{SRC: "C", DST: "NN", CMD: SETQUOTA, Headers: []BlockHeader{{Filename: "/dir"}}, Status: []FileStatus{{FileQuota: 1}}},
+	{SRC: "C", DST: "NN", CMD: BATCH, Commands: []Packet{{CMD: STAT, Headers: []BlockHeader{{Filename: "/dir"}}}, {CMD: DELETE}}},
+	{SRC: "C", DST: "NN", CMD: REPORT, User: "nobody"},
+	{SRC: "DN1", DST: "NN", CMD: BLOCKACK, Headers: []BlockHeader{{"DN1", "/dir/a.txt", 1, 1, 2, 1, "", 0, ""}}},
+	{SRC: "DN1", DST: "NN", CMD: BLOCKREPORT, ReportID: 2, Headers: []BlockHeader{{"DN1", "/c.txt", 1, 0, 1, 1, "", 0, ""}}, Storages: []string{"SSD"}},
+	{SRC: "DN1", DST: "NN", CMD: HB, Capacity: 1 << 30, ReportID: 1},
+	{SRC: "DN1", DST: "NN", CMD: BLOCK, Data: Block{Header: BlockHeader{"DN1", "/dir/a.txt", 1, 0, 2, 1, "", 0, ""}, Data: []byte("a")}},
+}
+
+// fuzzNameNode returns a quiet namenode holding a file, with a datanode
+func fuzzNameNode() *NameNode {
+	nn := New()
+	nn.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/dir/a.txt", 1, 0, 2, 1, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/dir/a.txt", 1, 1, 2, 1, "", 0, ""})
+	return nn
+}
+
+// fuzzCommands bounds the commands of fuzzed packets: every command the
+// namenode knows, and one it does not
+var fuzzCommands = len(commandNames) + 1
+
+// FuzzHandlePacket checks that a namenode handles any request without
+// panicking, and answers each client request. The request is one of
+// fuzzRequests with its fields replaced by those given.
+func FuzzHandlePacket(f *testing.F) {
+	for i := range fuzzRequests {
+		f.Add(i, -1, "", "", "", 0, 0, 0, -1, -1, -1, 0)
+	}
+	f.Add(4, -1, "", "", "", 0, -5, -1, -1, -1, -1, 0)
+	f.Add(1, BATCH, "/dir", "", "", 0, 0, 0, 0, 0, 2, 0)
+	f.Add(6, -1, "/new.txt", "", "writer", 0, 0, 0, -1, -1, -1, -3)
+	f.Add(5, -1, "", "", "", 0, 0, 0, 1, -1, -1, 0) // a Block of no datanode
+	f.Fuzz(func(t *testing.T, seed, cmd int, path, renamed, message string, flags, offset, limit, headers, status, commands, size int) {
+		if seed < 0 || seed >= len(fuzzRequests) {
+			return
+		}
+		// copied whole, as requests are changed as they are handled
+		var p Packet
+		data, _ := json.Marshal(fuzzRequests[seed])
+		json.Unmarshal(data, &p)
+		if cmd >= 0 {
+			p.CMD = cmd % fuzzCommands
+		}
+		if path != "" {
+			p.Headers = []BlockHeader{{Filename: path}}
+			p.Data.Header.Filename = path
+		}
+		if renamed != "" {
+			p.Renamed = []BlockHeader{{Filename: renamed}}
+		}
+		if message != "" {
+			p.Message = message
+		}
+		p.Flags, p.Offset, p.Limit = flags, offset, limit
+		if headers >= 0 {
+			p.Headers = make([]BlockHeader, headers%4)
+		}
+		if status >= 0 {
+			p.Status = make([]FileStatus, status%3)
+		}
+		if commands >= 0 {
+			p.Commands = make([]Packet, commands%3)
+			for i := range p.Commands {
+				p.Commands[i] = Packet{CMD: (cmd + i) % fuzzCommands, Headers: p.Headers, Status: p.Status}
+			}
+		}
+		if size != 0 {
+			p.Data.Header.Size, p.Data.Header.BlockNum, p.Data.Header.NumBlocks = size, size, -size
+		}
+		if p.Hello != nil {
+			p.Hello = &Hello{}
+		}
+
+		nn := fuzzNameNode()
+		answers := make(chan Packet, 64)
+		for _, id := range []string{"C", "DN1"} {
+			local, peer := net.Pipe()
+			defer peer.Close()
+			nn.SetOutbound(id, local)
+			go func() {
+				decoder := json.NewDecoder(peer)
+				for {
+					var r Packet
+					if decoder.Decode(&r) != nil {
+						return
+					}
+					answers <- r
+				}
+			}()
+		}
+
+		p.RequestID = 42
+		nn.update(func() { nn.HandlePacket(p) })
+		if p.SRC != "C" || p.CMD == HB || p.CMD == HELLO {
+			return
+		}
+		for {
+			select {
+			case r := <-answers:
+				if r.RequestID == 42 {
+					return
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("No answer to %+v", p)
+			}
+		}
+	})
+}
+
+// FuzzHandleConnection checks that a connection sending any bytes is read
+// until it closes without panicking or leaving goroutines behind
+func FuzzHandleConnection(f *testing.F) {
+	stream := func(framed bool, packets ...Packet) []byte {
+		var buf bytes.Buffer
+		var encoder packetEncoder = json.NewEncoder(&buf)
+		if framed {
+			encoder = newFrameEncoder(&buf)
+		}
+		for _, p := range packets {
+			encoder.Encode(p)
+		}
+		return buf.Bytes()
+	}
+	hello := Packet{SRC: "C", DST: "NN", CMD: HELLO, Hello: &Hello{Version: protocolVersion, MinVersion: 1, Features: features}}
+	f.Add(stream(false, hello, fuzzRequests[0], fuzzRequests[1]))
+	f.Add(stream(false, fuzzRequests[1], fuzzRequests[6]))
+	f.Add(stream(false, Packet{SRC: "DN1", DST: "NN", CMD: HB}, fuzzRequests[12]))
+	f.Add(append(stream(false, hello), stream(true, fuzzRequests[1], fuzzRequests[9])...))
+	f.Add(stream(true, Packet{SRC: "C", DST: "NN", CMD: STAT, Chunked: 10, Data: Block{Data: []byte("a")}}))
+	f.Add([]byte("GDFS\x01\xff\xff\xff\xff"))
+	f.Add([]byte("{\"SRC\":\"C\"}{]"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		before := runtime.NumGoroutine()
+		nn := fuzzNameNode()
+		local, remote := net.Pipe()
+		go io.Copy(io.Discard, local)
+		ended := make(chan struct{})
+		go func() {
+			nn.HandleConnection(remote)
+			close(ended)
+		}()
+		local.SetWriteDeadline(time.Now().Add(2 * time.Second))
+		local.Write(data)
+		local.Close()
+		select {
+		case <-ended:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Connection not ended for %q", data)
+		}
+
+		nn.dispatcher.close()
+		deadline := time.Now().Add(2 * time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				t.Fatalf("%d goroutines left for %q\n%s", runtime.NumGoroutine()-before, data, buf[:runtime.Stack(buf, true)])
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// FuzzCodecs checks that the Packet of a frame in JSON or MessagePack
+// decodes from any bytes without panicking, and encodes again once decoded.
+// gob is not hardened against hostile input, and is left out.
+func FuzzCodecs(f *testing.F) {
+	for _, c := range frameCodecs {
+		for _, p := range fuzzRequests[:4] {
+			b, _ := c.codec.Marshal(&p)
+			f.Add(codecVersion(c.name), b)
+		}
+	}
+	f.Add(codecVersion("msgpack"), []byte{0x81, 0xa7, 'H', 'e', 'a', 'd', 'e', 'r', 's', 0xdd, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, version byte, data []byte) {
+		codec := frameCodec(version)
+		if codec == nil || version == codecVersion("gob") {
+			return
+		}
+		var p Packet
+		if codec.Unmarshal(data, &p) != nil {
+			return
+		}
+		if _, err := codec.Marshal(&p); err != nil {
+			t.Fatalf("Decoded %+v does not encode: %s", p, err)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package namenode
+
+import (
+	"sync/atomic"
+)
+
+// nextGenStamp returns a new generation stamp for a Block being written. A
+// Block written again, such as by a client retrying a file, is given a newer
+// generation, so replicas left from the earlier write can be told apart.
+func (nn *NameNode) nextGenStamp() int64 {
+	return atomic.AddInt64(&nn.genStamp, 1)
+}
+
+// observeGenStamp makes sure stamps given out later are newer than one seen
+// in a saved or reported header
+func (nn *NameNode) observeGenStamp(stamp int64) {
+	for {
+		current := atomic.LoadInt64(&nn.genStamp)
+		if stamp <= current || atomic.CompareAndSwapInt64(&nn.genStamp, current, stamp) {
+			return
+		}
+	}
+}
+
+// isStale reports whether a replica is of an older generation than the
+// replicas of its Block in the filesystem
+func (nn *NameNode) isStale(h BlockHeader) bool {
+	current := nn.replicas(h.Filename, h.BlockNum)
+	return len(current) > 0 && h.GenStamp < current[0].GenStamp
+}
+
+// replaceStale removes the replicas of a Block older than the replica h from
+// the filesystem and schedules their deletion
+func (nn *NameNode) replaceStale(h BlockHeader) {
+	current := nn.replicas(h.Filename, h.BlockNum)
+	if len(current) == 0 || current[0].GenStamp >= h.GenStamp {
+		return
+	}
+
+	for _, old := range current {
+		nn.metaLog.Info("Replacing stale replica", "header", old, "generation", h.GenStamp)
+		if dn, ok := nn.datanodemap[old.DatanodeID]; ok {
+			dn.size -= int64(old.Size)
+		}
+		nn.Invalidate(old)
+		nn.filemap.Remove(old)
+	}
+}
@@ -0,0 +1,58 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestGenerationStamps(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", listed: true}
+
+	p, err := nn.AssignBlock(Block{BlockHeader{"", "/out.txt", 1, 0, 1, 0, "", 0, ""}, []byte{1}})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	first := p.Data.Header
+	p, _ = nn.AssignBlock(Block{BlockHeader{"", "/out.txt", 1, 0, 1, 0, "", 0, ""}, []byte{2}})
+	second := p.Data.Header
+	if first.GenStamp < 1 || second.GenStamp <= first.GenStamp {
+		t.Fatalf("Generation stamps not increasing %d %d", first.GenStamp, second.GenStamp)
+	}
+
+	old := BlockHeader{"DN1", "/out.txt", 1, 0, 1, first.GenStamp, "", 0, ""}
+	current := BlockHeader{"DN2", "/out.txt", 1, 0, 1, second.GenStamp, "", 0, ""}
+	nn.MergeNode(old)
+	nn.MergeNode(current)
+	replicas := nn.replicas("/out.txt", 0)
+	if len(replicas) != 1 || replicas[0] != current {
+		t.Errorf("Stale replica kept %v", replicas)
+	}
+	pending := nn.PendingInvalidations("DN1")
+	if len(pending) != 1 || pending[0] != old {
+		t.Errorf("Stale replica not invalidated %v", pending)
+	}
+	if nn.datanodemap["DN1"].size != 0 {
+		t.Errorf("Stale replica still counted %d", nn.datanodemap["DN1"].size)
+	}
+
+	// a late acknowledgement of the old generation is rejected
+	nn.CompleteInvalidation("DN1", pending)
+	ack := Packet{SRC: "DN1", DST: "NN", CMD: BLOCKACK, Headers: []BlockHeader{old}}
+	nn.HandlePacket(ack)
+	if !nn.isInvalidated(old) {
+		t.Errorf("Outdated BLOCKACK was not invalidated")
+	}
+	if nn.isStale(current) {
+		t.Errorf("Current replica reported stale")
+	}
+
+	// stamps given out after loading are newer than any stored
+	nn = New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/out.txt", 1, 0, 1, 7, "", 0, ""})
+	if s := nn.nextGenStamp(); s != 8 {
+		t.Errorf("Expected generation 8, got %d", s)
+	}
+}
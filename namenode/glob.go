@@ -0,0 +1,67 @@
+package namenode
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// default number of paths a glob pattern may match along the way
+const defaultGlobLimit = 100000
+
+// hasGlob reports whether a path component holds glob special characters
+func hasGlob(p string) bool {
+	return strings.ContainsAny(p, `*?[\`)
+}
+
+// Glob describes the files and directories matching pattern, in order of
+// path. Each component of pattern is matched as by path.Match, so * does not
+// cross a slash. Components without special characters are looked up
+// directly, while a pattern whose components match more than globLimit paths
+// in all is refused, so a pattern such as /*/*/* cannot walk the whole
+// namespace.
+func (nn *NameNode) Glob(pattern string) ([]FileStatus, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, errors.New("Invalid pattern " + pattern)
+	}
+	nodes := []*filenode{nn.root}
+	matched := 0
+	for _, part := range strings.Split(pattern, "/")[1:] {
+		if part == "" {
+			continue
+		}
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, errors.New("Invalid pattern " + pattern)
+		}
+
+		next := make([]*filenode, 0)
+		for _, n := range nodes {
+			for _, c := range n.children {
+				name := path.Base(c.path)
+				if !hasGlob(part) {
+					if name == part {
+						next = append(next, c)
+					}
+					continue
+				}
+				if ok, _ := path.Match(part, name); ok {
+					matched++
+					if matched > nn.globLimit {
+						return nil, errors.New("Pattern " + pattern + " matches more than " + strconv.Itoa(nn.globLimit) + " paths")
+					}
+					next = append(next, c)
+				}
+			}
+		}
+		nodes = next
+	}
+
+	list := make([]FileStatus, 0, len(nodes))
+	for _, n := range nodes {
+		list = append(list, nn.fileStatus(n))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list, nil
+}
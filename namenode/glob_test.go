@@ -0,0 +1,48 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	for _, f := range []string{"/logs/2024-01/part-0", "/logs/2024-01/part-1", "/logs/2024-01/_SUCCESS",
+		"/logs/2024-02/part-0", "/logs/2023-12/part-0"} {
+		nn.MergeNode(BlockHeader{"DN1", f, 1, 0, 1, 1, "", 0, ""})
+	}
+
+	list, err := nn.Glob("/logs/2024-*/part-*")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := []string{"/logs/2024-01/part-0", "/logs/2024-01/part-1", "/logs/2024-02/part-0"}
+	if len(list) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, list)
+	}
+	for i, st := range list {
+		if st.Path != expected[i] || st.IsDir {
+			t.Errorf("Expected file %s, got %v", expected[i], st)
+		}
+	}
+
+	if list, _ := nn.Glob("/logs/202?-0[2-9]"); len(list) != 1 || !list[0].IsDir {
+		t.Errorf("Expected the directory /logs/2024-02, got %v", list)
+	}
+	if list, err := nn.Glob("/logs/2025-*"); err != nil || len(list) != 0 {
+		t.Errorf("Expected no matches, got %v %v", list, err)
+	}
+	if _, err := nn.Glob("/logs/[2"); err == nil {
+		t.Errorf("Expected an error for a malformed pattern")
+	}
+
+	// patterns matching too many paths along the way are refused
+	nn.globLimit = 4
+	if _, err := nn.Glob("/logs/*/*"); err == nil {
+		t.Errorf("Expected an error past the glob limit")
+	}
+	if _, err := nn.Glob("/logs/2024-01/*"); err != nil {
+		t.Errorf("%s", err)
+	}
+}
@@ -0,0 +1,134 @@
+package namenode
+
+import (
+	"fmt"
+)
+
+// protocolVersion is the newest protocol spoken by the namenode. Version 1
+// is the protocol before handshakes, assumed for nodes whose first packet is
+// not a HELLO.
+const protocolVersion = 2
+
+// softwareVersion is the release the namenode runs
+const softwareVersion = "0.3.0"
+
+// features lists the optional parts of the protocol the namenode supports
+var features = []string{"frames", "leases", "erasure", "snapshots", "capacity", "corruptblock", "compression", "encryption", "replicate", "register", "commands", "cache", "storage", "chunks"}
+
+// Hello describes a node to its peer in a HELLO, the first packet of a
+// connection. The namenode answers with the protocol version and features
+// both sides support.
+type Hello struct {
+	Version       int      // newest protocol version spoken
+	MinVersion    int      // oldest protocol version spoken
+	Software      string   // release of GoDFS the node runs
+	Features      []string // optional features supported
+	BlockSize     int      // default size of Blocks, sent by the namenode
+	MaxPacketSize int64    // longest packet accepted, 0 if not known
+	Codecs        []string // codecs of frames the node reads besides JSON, most preferred first
+	Codec         string   // codec of the frames both sides send once the namenode answers, chosen by it
+}
+
+// hello describes the namenode
+func (nn *NameNode) hello() Hello {
+	return Hello{Version: protocolVersion, MinVersion: nn.minProtocol, Software: softwareVersion, Features: features, MaxPacketSize: maxPacketSize}
+}
+
+// negotiate picks the protocol version and features used with a node
+// which sent h, or returns an error if they have no version in common
+func (nn *NameNode) negotiate(h *Hello) (Hello, error) {
+	if h == nil {
+		return Hello{}, fmt.Errorf("HELLO without a description of the node")
+	}
+	if h.Version < nn.minProtocol || h.MinVersion > protocolVersion {
+		return Hello{}, fmt.Errorf("Incompatible protocol versions %d-%d, the namenode speaks %d-%d", h.MinVersion, h.Version, nn.minProtocol, protocolVersion)
+	}
+	agreed := Hello{Version: h.Version, MinVersion: h.MinVersion, Software: h.Software, MaxPacketSize: h.MaxPacketSize}
+	if agreed.Version > protocolVersion {
+		agreed.Version = protocolVersion
+	}
+	for _, f := range h.Features {
+		for _, own := range features {
+			if f == own {
+				agreed.Features = append(agreed.Features, f)
+				break
+			}
+		}
+	}
+	// the first codec offered which the namenode accepts is used
+	for _, name := range h.Codecs {
+		if nn.acceptsCodec(name) {
+			agreed.Codec = name
+			break
+		}
+	}
+	return agreed, nil
+}
+
+// handshake answers the first packet of a connection, which can be a
+// HELLO, before any other packet is sent on it. It returns what was agreed
+// with the node, or an error once the node has been refused.
+func (nn *NameNode) handshake(p Packet, encoder packetEncoder) (Hello, error) {
+	// datanodes report to the active namenode only
+	var standby bool
+	nn.view(func() { standby = nn.standby })
+	if standby && p.SRC != "C" {
+		r := Packet{SRC: nn.id, DST: p.SRC}
+		fail(&r, ErrStandby)
+		encoder.Encode(r)
+		return Hello{}, ErrStandby
+	}
+
+	if p.CMD != HELLO {
+		if nn.minProtocol > 1 {
+			err := fmt.Errorf("Node sent no HELLO, the namenode speaks protocol versions %d-%d", nn.minProtocol, protocolVersion)
+			encoder.Encode(Packet{SRC: nn.id, DST: p.SRC, CMD: ERROR, Message: err.Error()})
+			return Hello{}, err
+		}
+		nn.connLog.Debug("Node sent no HELLO, assuming protocol version 1", "src", p.SRC)
+		return Hello{Version: 1, MinVersion: 1}, nil
+	}
+
+	agreed, err := nn.negotiate(p.Hello)
+	if err != nil {
+		own := nn.hello()
+		encoder.Encode(Packet{SRC: nn.id, DST: p.SRC, CMD: ERROR, Message: err.Error(), Hello: &own})
+		return Hello{}, err
+	}
+	// a node sending JSON values rather than frames keeps to them
+	if _, framed := encoder.(*frameEncoder); !framed {
+		agreed.Codec = ""
+	}
+	reply := Hello{Version: agreed.Version, MinVersion: nn.minProtocol, Software: softwareVersion, Features: agreed.Features, BlockSize: nn.sizeofblock,
+		MaxPacketSize: maxPacketSize, Codec: agreed.Codec}
+	err = encoder.Encode(Packet{SRC: nn.id, DST: p.SRC, CMD: HELLO, Hello: &reply})
+	if err != nil {
+		return Hello{}, err
+	}
+	nn.connLog.Info("Negotiated protocol", "src", p.SRC, "version", agreed.Version, "software", agreed.Software, "features", agreed.Features, "codec", agreed.Codec)
+	return agreed, nil
+}
+
+// recordHello keeps what was agreed with a datanode for the status page
+func (nn *NameNode) recordHello(id string, h Hello) {
+	if dn, ok := nn.datanodemap[id]; ok {
+		dn.protocol = h.Version
+		dn.software = h.Software
+		dn.features = h.Features
+	}
+}
+
+// supports reports whether the datanode agreed to use an optional feature
+func (dn *datanode) supports(feature string) bool {
+	return hasFeature(dn.features, feature)
+}
+
+// hasFeature reports whether feature is among features
+func hasFeature(features []string, feature string) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package namenode
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+// connect sends first as the first packet of a connection and returns the
+// namenode's answer, and whether the connection was kept open
+func connect(t *testing.T, nn *NameNode, first Packet) (Packet, bool) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	go nn.HandleConnection(remote)
+
+	go json.NewEncoder(local).Encode(first)
+	decoder := json.NewDecoder(local)
+	var r Packet
+	if err := decoder.Decode(&r); err != nil {
+		t.Fatalf("No answer to %v: %s", first, err)
+	}
+	var next Packet
+	if r.CMD == ERROR {
+		return r, decoder.Decode(&next) == nil
+	}
+	if first.CMD == HELLO {
+		// the answer to a heartbeat follows the handling of the HELLO
+		go json.NewEncoder(local).Encode(Packet{SRC: first.SRC, DST: "NN", CMD: HB})
+		decoder.Decode(&next)
+	}
+	return r, true
+}
+
+func TestHandshake(t *testing.T) {
+
+	nn := New()
+	r, _ := connect(t, nn, Packet{SRC: "DN1", DST: "NN", CMD: HELLO, Hello: &Hello{2, 2, "0.3.0", []string{"frames", "teleport"}, 0, 0, nil, ""}})
+	if r.CMD != HELLO || r.Hello.Version != 2 || len(r.Hello.Features) != 1 || r.Hello.Features[0] != "frames" {
+		t.Errorf("Wrong answer to a HELLO %v %v", r, r.Hello)
+	}
+	if dn := nn.datanodemap["DN1"]; dn == nil || dn.protocol != 2 || dn.software != "0.3.0" {
+		t.Errorf("Handshake not recorded for the datanode")
+	}
+
+	// newer nodes which still speak this version are downgraded
+	r, _ = connect(t, nn, Packet{SRC: "DN2", DST: "NN", CMD: HELLO, Hello: &Hello{5, 1, "1.0.0", nil, 0, 0, nil, ""}})
+	if r.CMD != HELLO || r.Hello.Version != protocolVersion {
+		t.Errorf("Expected protocol version %d, got %v", protocolVersion, r.Hello)
+	}
+
+	r, open := connect(t, nn, Packet{SRC: "DN3", DST: "NN", CMD: HELLO, Hello: &Hello{5, 4, "2.0.0", nil, 0, 0, nil, ""}})
+	if r.CMD != ERROR || open {
+		t.Errorf("Incompatible node not refused %v", r)
+	}
+	if _, ok := nn.datanodemap["DN3"]; ok {
+		t.Errorf("Refused datanode was added")
+	}
+
+	// nodes without a handshake speak version 1, unless it is refused
+	r, _ = connect(t, nn, Packet{SRC: "C", DST: "NN", CMD: LIST, RequestID: 7})
+	if r.CMD != LIST || r.RequestID != 7 {
+		t.Errorf("Node without a handshake not served %v", r)
+	}
+	nn.minProtocol = 2
+	r, open = connect(t, nn, Packet{SRC: "C", DST: "NN", CMD: LIST})
+	if r.CMD != ERROR || open {
+		t.Errorf("Node without a handshake not refused %v", r)
+	}
+}
@@ -0,0 +1,88 @@
+package namenode
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+// readHosts reads a file of datanode IDs or hosts, separated by white space,
+// with comments starting at a #
+func readHosts(path string) (map[string]bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, name := range strings.Fields(line) {
+			hosts[name] = true
+		}
+	}
+	return hosts, nil
+}
+
+// LoadHosts reads the include and exclude files, where configured. Once an
+// include file is given only the datanodes it names may register, while the
+// datanodes named by the exclude file are decommissioned. Datanodes which are
+// no longer excluded are put back into service.
+func (nn *NameNode) LoadHosts() error {
+	var include, exclude map[string]bool
+	var err error
+	if nn.includeFile != "" {
+		include, err = readHosts(nn.includeFile)
+		if err != nil {
+			return err
+		}
+	}
+	if nn.excludeFile != "" {
+		exclude, err = readHosts(nn.excludeFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	nn.hostsLock.Lock()
+	previous := nn.excludeHosts
+	nn.includeHosts, nn.excludeHosts = include, exclude
+	nn.hostsLock.Unlock()
+
+	for name := range previous {
+		if exclude[name] {
+			continue
+		}
+		if nn.decommissioned[name] {
+			delete(nn.decommissioned, name)
+			nn.log.Info("Datanode no longer excluded, it may register again", "datanode", name)
+		}
+	}
+	for _, dn := range nn.datanodemap {
+		excluded := nn.isExcluded(dn.ID, dn.host)
+		if excluded && !dn.decommissioning {
+			nn.log.Info("Decommissioning excluded datanode", "datanode", dn.ID)
+			dn.decommissioning = true
+		} else if !excluded && dn.decommissioning && (previous[dn.ID] || previous[dn.host]) {
+			nn.log.Info("Datanode no longer excluded, putting it back into service", "datanode", dn.ID)
+			dn.decommissioning = false
+		}
+	}
+	return nil
+}
+
+// isIncluded reports whether a datanode with id connecting from host may
+// register
+func (nn *NameNode) isIncluded(id, host string) bool {
+	nn.hostsLock.Lock()
+	defer nn.hostsLock.Unlock()
+	return nn.includeHosts == nil || nn.includeHosts[id] || nn.includeHosts[host]
+}
+
+// isExcluded reports whether a datanode with id connecting from host is
+// named by the exclude file, and must be decommissioned
+func (nn *NameNode) isExcluded(id, host string) bool {
+	nn.hostsLock.Lock()
+	defer nn.hostsLock.Unlock()
+	return nn.excludeHosts[id] || nn.excludeHosts[host]
+}
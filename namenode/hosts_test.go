@@ -0,0 +1,65 @@
+package namenode
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestHostLists(t *testing.T) {
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("%s", err)
+		}
+		return path
+	}
+	nn := New()
+	nn.includeFile = write("include", "# datanodes of the cluster\nDN1 DN2\n")
+	nn.excludeFile = write("exclude", "DN2 # failing disc\n")
+	if err := nn.LoadHosts(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	register := func(id string) {
+		local, _ := net.Pipe()
+		nn.CheckConnection(local, Packet{SRC: id, DST: "NN", CMD: HB})
+	}
+	register("DN1")
+	register("DN2")
+	register("DN3")
+	if _, ok := nn.datanodemap["DN3"]; ok {
+		t.Errorf("Datanode missing from the include file registered")
+	}
+	if dn := nn.datanodemap["DN1"]; dn == nil || dn.decommissioning {
+		t.Errorf("Included datanode not in service")
+	}
+	if dn := nn.datanodemap["DN2"]; dn == nil || !dn.decommissioning {
+		t.Fatalf("Excluded datanode not decommissioning")
+	}
+	nn.checkDecommission(nn.datanodemap["DN2"])
+	if !nn.decommissioned["DN2"] {
+		t.Fatalf("Empty excluded datanode not decommissioned")
+	}
+
+	// the lists are reloaded without a restart
+	write("exclude", "DN1\n")
+	if _, err := nn.RefreshNodes(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !nn.datanodemap["DN1"].decommissioning {
+		t.Errorf("Newly excluded datanode not decommissioning")
+	}
+	if nn.decommissioned["DN2"] {
+		t.Errorf("Datanode no longer excluded still refused")
+	}
+
+	write("exclude", "")
+	nn.RefreshNodes()
+	if nn.datanodemap["DN1"].decommissioning {
+		t.Errorf("Datanode no longer excluded still decommissioning")
+	}
+}
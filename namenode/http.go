@@ -0,0 +1,31 @@
+package namenode
+
+import (
+	"net"
+	"net/http"
+)
+
+// Handler returns the HTTP handler serving the namenode's HTTP endpoints
+func (nn *NameNode) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", nn.ServeStatus)
+	mux.HandleFunc("/metrics", nn.ServeMetrics)
+	mux.HandleFunc("/report", nn.ServeReport)
+	mux.HandleFunc("/editlog", nn.ServeEditLog)
+	mux.HandleFunc("/image", nn.ServeImage)
+	mux.HandleFunc(webhdfsPrefix+"/", nn.ServeWebHDFS)
+	return mux
+}
+
+// ServeHTTP starts the HTTP server on l, which is closed on shutdown
+func (nn *NameNode) ServeHTTP(l net.Listener) {
+	nn.mu.Lock()
+	nn.httpServer = &http.Server{Handler: nn.Handler()}
+	server := nn.httpServer
+	nn.mu.Unlock()
+
+	err := server.Serve(l)
+	if err != nil && err != http.ErrServerClosed {
+		nn.log.Error("HTTP server error", "err", err)
+	}
+}
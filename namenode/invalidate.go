@@ -0,0 +1,151 @@
+package namenode
+
+import (
+	"strings"
+)
+
+// Invalidate schedules the replica described by h for deletion. The request
+// is sent to the replica's datanode in response to its next heartbeat, and
+// repeated until the datanode acknowledges it.
+func (nn *NameNode) Invalidate(h BlockHeader) {
+	nn.invalidateLock.Lock()
+	defer nn.invalidateLock.Unlock()
+
+	pending := nn.invalidations[h.DatanodeID]
+	if ContainsHeader(pending, h) {
+		return
+	}
+	nn.invalidations[h.DatanodeID] = append(pending, h)
+}
+
+// PendingInvalidations returns the replicas waiting to be deleted from a datanode
+func (nn *NameNode) PendingInvalidations(datanodeID string) []BlockHeader {
+	nn.invalidateLock.Lock()
+	defer nn.invalidateLock.Unlock()
+
+	pending := nn.invalidations[datanodeID]
+	headers := make([]BlockHeader, len(pending))
+	copy(headers, pending)
+	return headers
+}
+
+// isInvalidated reports whether a replica is waiting to be deleted, in which
+// case a report of it must not be merged back into the filesystem
+func (nn *NameNode) isInvalidated(h BlockHeader) bool {
+	nn.invalidateLock.Lock()
+	defer nn.invalidateLock.Unlock()
+	return ContainsHeader(nn.invalidations[h.DatanodeID], h)
+}
+
+// CompleteInvalidation handles a datanode's confirmation that replicas were
+// deleted, removing them from the filesystem and the pending list
+func (nn *NameNode) CompleteInvalidation(datanodeID string, headers []BlockHeader) {
+	nn.invalidateLock.Lock()
+	pending := nn.invalidations[datanodeID]
+	remaining := make([]BlockHeader, 0, len(pending))
+	for _, h := range pending {
+		if !ContainsHeader(headers, h) {
+			remaining = append(remaining, h)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(nn.invalidations, datanodeID)
+	} else {
+		nn.invalidations[datanodeID] = remaining
+	}
+	nn.invalidateLock.Unlock()
+
+	for _, h := range headers {
+		if h.DatanodeID == datanodeID {
+			nn.removeReplica(h)
+		}
+	}
+}
+
+// removeReplica drops a replica from filemap, removing the file from the tree
+// once none of its blocks have replicas left
+func (nn *NameNode) removeReplica(h BlockHeader) {
+	left, ok := nn.filemap.Remove(h)
+	if !ok {
+		return
+	}
+	nn.logEdit(edit{Removed: &h})
+	if dn, ok := nn.datanodemap[h.DatanodeID]; ok {
+		dn.size -= int64(h.Size)
+	}
+	if left == 0 {
+		nn.removeFile(h.Filename)
+	}
+}
+
+// DeleteFile removes a file from the namespace and invalidates all of its replicas
+func (nn *NameNode) DeleteFile(path string) error {
+	blocks, ok := nn.filemap.Get(path)
+	if !ok {
+		return newError(ErrFileNotFound, "File not found "+path)
+	}
+
+	headers := make([]BlockHeader, 0)
+	for _, replicas := range blocks {
+		for _, h := range replicas {
+			headers = append(headers, h)
+			if dn, ok := nn.datanodemap[h.DatanodeID]; ok {
+				dn.size -= int64(h.Size)
+			}
+		}
+	}
+	for _, h := range nn.preserveForSnapshots(path, headers) {
+		nn.Invalidate(h)
+	}
+	nn.removeFile(path)
+	nn.metaLog.Info("Deleted file", "path", path)
+	return nil
+}
+
+// removeFile removes a file from filemap and its filenode from the tree,
+// along with any directories left empty
+func (nn *NameNode) removeFile(path string) {
+	nn.filemap.Delete(path)
+	delete(nn.erasure, path)
+	delete(nn.archives, path)
+	delete(nn.encrypted, path)
+	delete(nn.layouts, path)
+
+	n := nn.lookup(path)
+	for n != nil && !n.explicit && len(n.children) == 0 {
+		parent := n.parent
+		for i, c := range parent.children {
+			if c == n {
+				parent.children = append(parent.children[:i], parent.children[i+1:]...)
+				break
+			}
+		}
+		parent.mtime = nowMillis()
+		n = parent
+	}
+}
+
+// lookup finds the filenode for a path, or nil if it does not exist
+func (nn *NameNode) lookup(path string) *filenode {
+	if path == "/" {
+		return nn.root
+	}
+
+	path_arr := strings.Split(path, "/")
+	q := nn.root
+	for i := 1; i < len(path_arr); i++ {
+		partial := strings.Join(path_arr[0:i+1], "/")
+		var next *filenode
+		for _, c := range q.children {
+			if c.path == partial {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		q = next
+	}
+	return q
+}
@@ -0,0 +1,68 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestDeleteInvalidatesReplicas(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+
+	inh1 := BlockHeader{"DN1", "/dir/out.txt", 1, 0, 2, 0, "", 0, ""}
+	inh2 := BlockHeader{"DN1", "/dir/out.txt", 1, 1, 2, 0, "", 0, ""}
+	nn.MergeNode(inh1)
+	nn.MergeNode(inh2)
+
+	err := nn.DeleteFile("/dir/out.txt")
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+
+	if _, ok := nn.filemap.Get("/dir/out.txt"); ok {
+		t.Errorf("File still in filemap after delete")
+	}
+	if nn.lookup("/dir") != nil {
+		t.Errorf("Empty directory was not removed")
+	}
+
+	pending := nn.PendingInvalidations("DN1")
+	if len(pending) != 2 || !ContainsHeader(pending, inh1) || !ContainsHeader(pending, inh2) {
+		t.Errorf("Expected both replicas pending invalidation, got %v", pending)
+	}
+	if !nn.isInvalidated(inh1) {
+		t.Errorf("Invalidated replica would be merged again")
+	}
+
+	nn.CompleteInvalidation("DN1", []BlockHeader{inh1})
+	pending = nn.PendingInvalidations("DN1")
+	if len(pending) != 1 || pending[0] != inh2 {
+		t.Errorf("Expected one pending invalidation, got %v", pending)
+	}
+}
+
+func TestInvalidateExcessReplica(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", listed: true}
+
+	inh1 := BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""}
+	inh2 := BlockHeader{"DN2", "/out.txt", 1, 0, 1, 0, "", 0, ""}
+	nn.MergeNode(inh1)
+	nn.MergeNode(inh2)
+
+	nn.Invalidate(inh2)
+	if len(nn.replicas("/out.txt", 0)) != 2 {
+		t.Errorf("Replica removed before datanode acknowledged")
+	}
+
+	nn.CompleteInvalidation("DN2", []BlockHeader{inh2})
+	replicas := nn.replicas("/out.txt", 0)
+	if len(replicas) != 1 || replicas[0] != inh1 {
+		t.Errorf("Expected only DN1 replica, got %v", replicas)
+	}
+	if nn.datanodemap["DN2"].size != 0 {
+		t.Errorf("DN2 size not updated, got %d", nn.datanodemap["DN2"].size)
+	}
+}
@@ -0,0 +1,300 @@
+package namenode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// tombstone is the value length of a record deleting its key
+const tombstone = 0xffffffff
+
+// logHeaderSize is the length of a record header: the checksum of the rest
+// of the record, and the lengths of its key and value
+const logHeaderSize = 12
+
+// compactMinSize is the smallest log which is compacted
+const compactMinSize = 1 << 20
+
+// logStore is an embedded key-value store keeping its records in a single
+// append only file. Only the keys and where their latest values start are
+// held in memory. A record cut short by a crash is discarded when the store
+// is opened, and the file is rewritten once most of it is overwritten or
+// deleted records.
+type logStore struct {
+	lock  sync.Mutex
+	path  string
+	file  *os.File
+	size  int64               // bytes in the file
+	live  int64               // bytes of the latest record of each key
+	index map[string]logEntry // where the latest value of each key starts
+}
+
+// logEntry locates the value of a key within the file
+type logEntry struct {
+	offset int64 // start of the value
+	length int   // length of the value
+}
+
+// recordSize is the length of the record of a key and its value entry
+func (e logEntry) recordSize(key string) int64 {
+	return int64(logHeaderSize + len(key) + e.length)
+}
+
+// openLogStore opens the store kept in the file at path, creating it if
+// it does not exist
+func openLogStore(path string) (*logStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := &logStore{path: path, file: file}
+	err = s.load()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load rebuilds the index from the file, truncating it after the last
+// complete record. A record longer than what is left of the file is one cut
+// short, so its lengths are not trusted to size the buffer it is read into.
+func (s *logStore) load() error {
+	s.index = make(map[string]logEntry)
+	s.size = 0
+	s.live = 0
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(io.NewSectionReader(s.file, 0, info.Size()))
+	header := make([]byte, logHeaderSize)
+	for {
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			break
+		}
+		sum := binary.BigEndian.Uint32(header[0:4])
+		keyLen := binary.BigEndian.Uint32(header[4:8])
+		valLen := binary.BigEndian.Uint32(header[8:12])
+		bodyLen := keyLen
+		if valLen != tombstone {
+			bodyLen += valLen
+		}
+		if int64(bodyLen) > info.Size()-s.size-logHeaderSize {
+			break
+		}
+		body := make([]byte, bodyLen)
+		_, err = io.ReadFull(r, body)
+		if err != nil {
+			break
+		}
+		hash := crc32.NewIEEE()
+		hash.Write(header[4:])
+		hash.Write(body)
+		if hash.Sum32() != sum {
+			break
+		}
+
+		key := string(body[:keyLen])
+		if old, ok := s.index[key]; ok {
+			s.live -= old.recordSize(key)
+		}
+		if valLen == tombstone {
+			delete(s.index, key)
+		} else {
+			e := logEntry{s.size + logHeaderSize + int64(keyLen), int(valLen)}
+			s.index[key] = e
+			s.live += e.recordSize(key)
+		}
+		s.size += int64(logHeaderSize) + int64(bodyLen)
+	}
+	return s.file.Truncate(s.size)
+}
+
+// record encodes a record setting key to value, or deleting key when value
+// is nil
+func record(key string, value []byte) []byte {
+	buf := make([]byte, logHeaderSize, logHeaderSize+len(key)+len(value))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(key)))
+	if value == nil {
+		binary.BigEndian.PutUint32(buf[8:12], tombstone)
+	} else {
+		binary.BigEndian.PutUint32(buf[8:12], uint32(len(value)))
+	}
+	buf = append(buf, key...)
+	buf = append(buf, value...)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// Get returns the value of key, and whether the key is stored
+func (s *logStore) Get(key string) ([]byte, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.index[key]
+	if !ok {
+		return nil, false, nil
+	}
+	value := make([]byte, e.length)
+	_, err := s.file.ReadAt(value, e.offset)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Put sets the value of key
+func (s *logStore) Put(key string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.append(key, value)
+	if err != nil {
+		return err
+	}
+	return s.compactIfWasted()
+}
+
+// Delete removes key from the store. Deleting a key which is not stored is
+// not an error.
+func (s *logStore) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.index[key]; !ok {
+		return nil
+	}
+	err := s.append(key, nil)
+	if err != nil {
+		return err
+	}
+	return s.compactIfWasted()
+}
+
+// append writes a record to the end of the file, waits for it to reach the
+// disc and indexes it
+func (s *logStore) append(key string, value []byte) error {
+	if uint64(len(value)) >= tombstone {
+		return errors.New("Value too large for key " + key)
+	}
+	rec := record(key, value)
+	_, err := s.file.WriteAt(rec, s.size)
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		return err
+	}
+
+	if old, ok := s.index[key]; ok {
+		s.live -= old.recordSize(key)
+	}
+	if value == nil {
+		delete(s.index, key)
+	} else {
+		e := logEntry{s.size + logHeaderSize + int64(len(key)), len(value)}
+		s.index[key] = e
+		s.live += e.recordSize(key)
+	}
+	s.size += int64(len(rec))
+	return nil
+}
+
+// Keys lists the stored keys in order
+func (s *logStore) Keys() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keys := make([]string, 0, len(s.index))
+	for key := range s.index {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Len is the number of stored keys
+func (s *logStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.index)
+}
+
+// compactIfWasted rewrites the file once more than half of it is records
+// which were overwritten or deleted
+func (s *logStore) compactIfWasted() error {
+	if s.size < compactMinSize || s.live > s.size/2 {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact rewrites the file with only the latest record of each key. The
+// new file replaces the old one once it is complete, so a crash leaves one
+// or the other.
+func (s *logStore) compact() error {
+	tmp, err := os.OpenFile(s.path+".compact", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for key, e := range s.index {
+		value := make([]byte, e.length)
+		_, err = s.file.ReadAt(value, e.offset)
+		if err == nil {
+			_, err = w.Write(record(key, value))
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	s.file.Close()
+	s.file = tmp
+	return s.load()
+}
+
+// Sync flushes the file to disc
+func (s *logStore) Sync() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Sync()
+}
+
+// Close flushes and closes the file
+func (s *logStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.file.Sync()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package namenode
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestLogStore(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "files.log")
+	s, err := openLogStore(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	s.Put("/a", []byte("one"))
+	s.Put("/b", []byte("two"))
+	s.Put("/a", []byte("three"))
+	s.Delete("/b")
+	s.Put("/empty", nil)
+
+	check := func(s *logStore) {
+		if v, ok, err := s.Get("/a"); !ok || err != nil || string(v) != "three" {
+			t.Errorf("Expected the latest value of /a, got %q %v %v", v, ok, err)
+		}
+		if _, ok, _ := s.Get("/b"); ok {
+			t.Errorf("Deleted key was returned")
+		}
+		if v, ok, _ := s.Get("/empty"); !ok || len(v) != 0 {
+			t.Errorf("Empty value was not kept")
+		}
+		if keys := s.Keys(); len(keys) != 2 || keys[0] != "/a" || keys[1] != "/empty" {
+			t.Errorf("Unexpected keys %v", keys)
+		}
+	}
+	check(s)
+	s.Close()
+
+	// a record cut short by a crash is dropped on reopening
+	fi, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	fi.Write(record("/c", []byte("partial"))[:15])
+	fi.Close()
+	s, err = openLogStore(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	check(s)
+	s.Put("/c", []byte("whole"))
+	if v, _, _ := s.Get("/c"); string(v) != "whole" {
+		t.Errorf("Record after a torn one not readable, got %q", v)
+	}
+	s.Delete("/c")
+	s.Close()
+
+	// so is a header giving lengths longer than the rest of the file
+	huge := record("/d", []byte("value"))
+	binary.BigEndian.PutUint32(huge[8:12], tombstone-1)
+	fi, _ = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	fi.Write(huge)
+	fi.Close()
+	s, err = openLogStore(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	check(s)
+	if _, ok, _ := s.Get("/d"); ok {
+		t.Errorf("Record longer than the file was indexed")
+	}
+	s.Close()
+}
+
+func TestLogStoreCompaction(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "files.log")
+	s, err := openLogStore(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer s.Close()
+
+	value := make([]byte, 1024)
+	for i := 0; i < 3000; i++ {
+		s.Put("/file"+strconv.Itoa(i%10), value)
+	}
+	info, _ := os.Stat(path)
+	if info.Size() > compactMinSize {
+		t.Errorf("Log of overwritten records was not compacted, %d bytes", info.Size())
+	}
+	if s.Len() != 10 {
+		t.Errorf("Expected 10 keys, got %d", s.Len())
+	}
+	if _, ok, _ := s.Get("/file9"); !ok {
+		t.Errorf("Key lost in compaction")
+	}
+}
@@ -0,0 +1,286 @@
+package namenode
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// time a lease lasts without being renewed
+const leaseTimeout = time.Minute
+
+// lease gives a single writer the right to write Blocks of a file. A lease
+// left by a recovery has no holder, and only rejects the writes of the
+// writer it was taken from.
+type lease struct {
+	Holder   string
+	Acquired time.Time // when the holder was given the lease
+	Renewed  time.Time
+	Since    int64 // Blocks with generation stamps up to Since were written under earlier leases
+	Visible  int64 // bytes of the file found durable by a FLUSH, which readers may read
+
+	NumBlocks int  // Blocks of the file being written, set by the first Block distributed
+	Resumable bool // the Blocks written are kept for a RESUME if the write stops before it completes
+}
+
+// AcquireLease gives holder the lease on the file at path, which it must hold
+// to write the file. A lease held by another writer is only given up once it
+// expires, when the file is recovered.
+func (nn *NameNode) AcquireLease(path, holder string) error {
+	if holder == "" {
+		return errors.New("Missing lease holder")
+	}
+	nn.leaseLock.Lock()
+	defer nn.leaseLock.Unlock()
+
+	now := time.Now()
+	l, ok := nn.leases[path]
+	if ok && l.Holder == holder {
+		l.Renewed = now
+		return nil
+	}
+	if ok && l.Holder != "" {
+		if now.Sub(l.Renewed) < leaseTimeout {
+			return newError(ErrWriteConflict, "File is being written by "+l.Holder+" "+path)
+		}
+		nn.recoverLease(path, l)
+	}
+
+	nn.leases[path] = &lease{Holder: holder, Acquired: now, Renewed: now, Since: atomic.LoadInt64(&nn.genStamp)}
+	nn.metaLog.Debug("Granted lease", "path", path, "holder", holder)
+	return nil
+}
+
+// CreateFile gives holder the lease on the file at path as AcquireLease
+// does, once the create flags allow it. CREATE allows a file which does not
+// exist, EXCLUSIVE refuses one which does, and OVERWRITE deletes the Blocks
+// of an existing file as the lease is given, so they are not mixed with
+// those written. Without any of them, as from older clients, the file is
+// created if missing and its Blocks replaced one by one as they arrive. A
+// writer already holding the lease is not checked again.
+func (nn *NameNode) CreateFile(path, holder string, flags int) error {
+	if flags&(CREATE|OVERWRITE|EXCLUSIVE) == 0 || nn.leaseHolder(path) == holder {
+		return nn.AcquireLease(path, holder)
+	}
+	n := nn.lookup(path)
+	if n != nil && !nn.isFile(n) {
+		return errors.New("Not a file " + path)
+	}
+	exists := n != nil
+	if exists && flags&EXCLUSIVE != 0 {
+		return newError(ErrFileExists, "File exists "+path)
+	}
+	if !exists && flags&CREATE == 0 {
+		return newError(ErrFileNotFound, "File not found "+path)
+	}
+	err := nn.AcquireLease(path, holder)
+	if err != nil || flags&OVERWRITE == 0 {
+		return err
+	}
+	// the incomplete file of an expired writer is deleted as its lease is recovered
+	if _, ok := nn.filemap.Get(path); !ok {
+		return nil
+	}
+	err = nn.DeleteFile(path)
+	if err != nil {
+		return err
+	}
+	nn.notify(eventDelete, path, "")
+	nn.metaLog.Info("Overwriting file", "path", path, "holder", holder)
+	return nil
+}
+
+// ReleaseLease gives up holder's lease on a file once it is written
+func (nn *NameNode) ReleaseLease(path, holder string) error {
+	nn.leaseLock.Lock()
+	defer nn.leaseLock.Unlock()
+
+	l, ok := nn.leases[path]
+	if !ok || l.Holder != holder {
+		return errors.New("No lease on " + path)
+	}
+	nn.forgetSyncs(path)
+	// a resumable write given up before it completes is left to be resumed
+	if l.Resumable && nn.incomplete(path) {
+		l.Holder = ""
+		l.Since = atomic.LoadInt64(&nn.genStamp)
+		nn.metaLog.Info("Released incomplete file to be resumed", "path", path, "holder", holder)
+		return nil
+	}
+	delete(nn.leases, path)
+	// a policy or key set for a write which stored nothing is forgotten,
+	// while the Blocks written are copied to the replicas they need
+	if _, ok := nn.filemap.Get(path); !ok && !nn.metrics.isDistributing(path) {
+		delete(nn.erasure, path)
+		delete(nn.encrypted, path)
+		delete(nn.layouts, path)
+	} else {
+		nn.queueReplication(path)
+	}
+	nn.metaLog.Debug("Released lease", "path", path, "holder", holder)
+	return nil
+}
+
+// RenewLeases extends every lease held by holder
+func (nn *NameNode) RenewLeases(holder string) {
+	if holder == "" {
+		return
+	}
+	nn.leaseLock.Lock()
+	defer nn.leaseLock.Unlock()
+
+	now := time.Now()
+	for _, l := range nn.leases {
+		if l.Holder == holder {
+			l.Renewed = now
+		}
+	}
+}
+
+// checkLease returns an error unless holder holds the lease on path, and
+// renews the lease
+func (nn *NameNode) checkLease(path, holder string) error {
+	nn.leaseLock.Lock()
+	defer nn.leaseLock.Unlock()
+
+	l, ok := nn.leases[path]
+	if !ok || l.Holder == "" || l.Holder != holder {
+		return errors.New("No lease on " + path)
+	}
+	l.Renewed = time.Now()
+	return nil
+}
+
+// checkWrite refuses a Block distributed under the lease on its file which
+// belongs to another version of the file than the Blocks before it, such as
+// one of a second writer sharing the holder of the first, so the Blocks of
+// two writes are not mixed in one file
+func (nn *NameNode) checkWrite(h BlockHeader) error {
+	nn.leaseLock.Lock()
+	defer nn.leaseLock.Unlock()
+
+	l, ok := nn.leases[h.Filename]
+	if !ok {
+		return nil
+	}
+	if l.NumBlocks == 0 {
+		l.NumBlocks = h.NumBlocks
+		return nil
+	}
+	if h.NumBlocks != l.NumBlocks {
+		return newError(ErrWriteConflict, "Conflicting write of "+h.Filename+": a Block of "+strconv.Itoa(h.NumBlocks)+" Blocks, the file being written has "+strconv.Itoa(l.NumBlocks))
+	}
+	return nil
+}
+
+// leaseHolder returns the writer holding an unexpired lease on path, if any
+func (nn *NameNode) leaseHolder(path string) string {
+	nn.leaseLock.Lock()
+	defer nn.leaseLock.Unlock()
+
+	l, ok := nn.leases[path]
+	if !ok || time.Since(l.Renewed) >= leaseTimeout {
+		return ""
+	}
+	return l.Holder
+}
+
+// isStaleWrite reports whether a replica was written under an earlier lease
+// than the current one on its file
+func (nn *NameNode) isStaleWrite(h BlockHeader) bool {
+	nn.leaseLock.Lock()
+	defer nn.leaseLock.Unlock()
+
+	l, ok := nn.leases[h.Filename]
+	return ok && h.GenStamp <= l.Since
+}
+
+// recoverLease takes an expired lease from its holder. A file left without
+// all of its Blocks is deleted, so the next writer starts again, unless it
+// was written resumably. The caller must hold leaseLock.
+func (nn *NameNode) recoverLease(path string, l *lease) {
+	nn.metaLog.Info("Recovering expired lease", "path", path, "holder", l.Holder)
+
+	// an incomplete file keeps the Blocks its writer flushed, if any
+	nn.forgetSyncs(path)
+	if nn.incomplete(path) && !l.Resumable && !nn.keepFlushed(path, l.Visible) {
+		err := nn.DeleteFile(path)
+		if err != nil {
+			nn.metaLog.Warn("Could not delete incomplete file", "path", path, "err", err)
+		}
+	}
+	if _, ok := nn.filemap.Get(path); !ok {
+		delete(nn.erasure, path)
+		delete(nn.encrypted, path)
+		delete(nn.layouts, path)
+	}
+	l.Holder = ""
+	l.Renewed = time.Now()
+	l.Since = atomic.LoadInt64(&nn.genStamp)
+}
+
+// RecoverLease takes the lease on the file at path from its holder at once,
+// rather than once it expires, for files left by writers which crashed. The
+// holder's later writes are refused, and a file left without all of its
+// Blocks is deleted.
+func (nn *NameNode) RecoverLease(path string) (string, error) {
+	nn.leaseLock.Lock()
+	defer nn.leaseLock.Unlock()
+
+	l, ok := nn.leases[path]
+	if !ok {
+		return "", newError(ErrFileNotFound, "No lease on "+path)
+	}
+	if l.Holder == "" {
+		return "Lease on " + path + " was already recovered", nil
+	}
+	holder := l.Holder
+	nn.recoverLease(path, l)
+	message := "Recovered lease on " + path + " from " + holder
+	if _, ok := nn.filemap.Get(path); !ok {
+		message += ", deleting the incomplete file"
+	}
+	return message, nil
+}
+
+// ExpireLeases periodically recovers the leases which were not renewed in
+// time, until the namenode shuts down
+func (nn *NameNode) ExpireLeases() {
+	tick := time.NewTicker(leaseTimeout / 4)
+	defer tick.Stop()
+
+	for {
+		select {
+		case now := <-tick.C:
+			nn.update(func() { nn.expireLeases(now) })
+		case <-nn.quit:
+			return
+		}
+	}
+}
+
+// expireLeases recovers the leases which expired by now, and forgets
+// recovered leases once their writers' Blocks can no longer arrive
+func (nn *NameNode) expireLeases(now time.Time) {
+	if nn.standby {
+		// leases are renewed at the active namenode
+		return
+	}
+	nn.leaseLock.Lock()
+	defer nn.leaseLock.Unlock()
+
+	for path, l := range nn.leases {
+		if now.Sub(l.Renewed) < leaseTimeout {
+			continue
+		}
+		if l.Holder != "" {
+			nn.recoverLease(path, l)
+			continue
+		}
+		// the incomplete file of a resumable write keeps its lease until it is resumed
+		if !l.Resumable || !nn.incomplete(path) {
+			delete(nn.leases, path)
+		}
+	}
+}
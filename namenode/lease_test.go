@@ -0,0 +1,242 @@
+package namenode
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeases(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+
+	b := Block{BlockHeader{"", "/out.txt", 1, 0, 2, 0, "", 0, ""}, []byte{1}}
+	p := Packet{SRC: "C", DST: "NN", CMD: DISTRIBUTE, Data: b, Message: "W1"}
+	nn.HandlePacket(p)
+	if len(nn.metrics.distributing) != 0 {
+		t.Fatalf("Block distributed without a lease")
+	}
+
+	err := nn.AcquireLease("/out.txt", "W1")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if nn.AcquireLease("/out.txt", "W2") == nil {
+		t.Errorf("Two writers hold the lease")
+	}
+	if nn.checkLease("/out.txt", "W1") != nil || nn.checkLease("/out.txt", "W2") == nil {
+		t.Errorf("Lease check failed")
+	}
+
+	// W1 writes the first of two Blocks and stops renewing
+	assigned, _ := nn.AssignBlock(b)
+	first := assigned.Data.Header
+	first.DatanodeID = "DN1"
+	nn.MergeNode(first)
+	nn.leases["/out.txt"].Renewed = time.Now().Add(-2 * leaseTimeout)
+
+	err = nn.AcquireLease("/out.txt", "W2")
+	if err != nil {
+		t.Fatalf("Expired lease was not recovered %s", err)
+	}
+	if _, ok := nn.filemap.Get("/out.txt"); ok {
+		t.Errorf("Incomplete file kept after recovery")
+	}
+
+	// W1's late Blocks are rejected
+	late := first
+	late.BlockNum = 1
+	if !nn.isStaleWrite(late) {
+		t.Errorf("Block of the recovered writer accepted")
+	}
+	assigned, _ = nn.AssignBlock(b)
+	if nn.isStaleWrite(assigned.Data.Header) {
+		t.Errorf("Block of the new writer rejected")
+	}
+
+	if nn.ReleaseLease("/out.txt", "W1") == nil {
+		t.Errorf("Released another writer's lease")
+	}
+	if nn.ReleaseLease("/out.txt", "W2") != nil || nn.leaseHolder("/out.txt") != "" {
+		t.Errorf("Lease was not released")
+	}
+
+	// recovered leases are forgotten once they expire
+	nn.AcquireLease("/other.txt", "W1")
+	nn.expireLeases(time.Now().Add(leaseTimeout))
+	if l := nn.leases["/other.txt"]; l == nil || l.Holder != "" {
+		t.Fatalf("Expired lease was not recovered %v", l)
+	}
+	nn.expireLeases(time.Now().Add(2 * leaseTimeout))
+	if len(nn.leases) != 0 {
+		t.Errorf("Recovered lease kept %v", nn.leases)
+	}
+}
+
+func TestRecoverLease(t *testing.T) {
+
+	nn := New()
+	nn.superuser = "hdfs"
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	for _, path := range []string{"/done.txt", "/partial.txt"} {
+		if err := nn.AcquireLease(path, "W1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/done.txt", 1, 0, 1, 1, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/partial.txt", 1, 0, 2, 2, "", 0, ""})
+	nn.leases["/done.txt"].Acquired = time.Now().Add(-90 * time.Second)
+
+	var r Packet
+	nn.handleAdmin(Packet{SRC: "C", CMD: LISTLEASES, User: "hdfs"}, &r)
+	if !strings.Contains(r.Message, "/done.txt     W1      1m30s") {
+		t.Errorf("Lease listed without its age\n%s", r.Message)
+	}
+
+	// an administrator takes the leases before they expire
+	nn.handleAdmin(Packet{SRC: "C", CMD: RECOVERLEASE, User: "hdfs", Headers: []BlockHeader{{Filename: "/done.txt"}}}, &r)
+	if r.CMD != ACK || r.Message != "Recovered lease on /done.txt from W1" {
+		t.Errorf("Unexpected recovery %v %s", CommandName(r.CMD), r.Message)
+	}
+	if nn.checkLease("/done.txt", "W1") == nil {
+		t.Errorf("Writer kept a recovered lease")
+	}
+	if _, ok := nn.filemap.Get("/done.txt"); !ok {
+		t.Errorf("Complete file deleted")
+	}
+	nn.handleAdmin(Packet{SRC: "C", CMD: RECOVERLEASE, User: "hdfs", Headers: []BlockHeader{{Filename: "/partial.txt"}}}, &r)
+	if _, ok := nn.filemap.Get("/partial.txt"); ok || !strings.HasSuffix(r.Message, "deleting the incomplete file") {
+		t.Errorf("Incomplete file kept %s", r.Message)
+	}
+	if err := nn.AcquireLease("/done.txt", "W2"); err != nil {
+		t.Errorf("Recovered lease not given to another writer %s", err)
+	}
+
+	nn.handleAdmin(Packet{SRC: "C", CMD: RECOVERLEASE, User: "hdfs", Headers: []BlockHeader{{Filename: "/partial.txt"}}}, &r)
+	if r.CMD != ACK || !strings.Contains(r.Message, "already recovered") {
+		t.Errorf("Unexpected second recovery %s", r.Message)
+	}
+	for _, p := range []Packet{
+		{SRC: "C", CMD: RECOVERLEASE, User: "hdfs", Headers: []BlockHeader{{Filename: "/other.txt"}}},
+		{SRC: "C", CMD: RECOVERLEASE, User: "hdfs"},
+		{SRC: "C", CMD: RECOVERLEASE, User: "bob", Headers: []BlockHeader{{Filename: "/done.txt"}}},
+	} {
+		nn.handleAdmin(p, &r)
+		if r.CMD != ERROR {
+			t.Errorf("Recovery allowed %+v", p)
+		}
+	}
+}
+
+func TestCreateFlags(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/old.txt", 4, 0, 3, 1, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/old.txt", 4, 1, 3, 1, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/old.txt", 4, 2, 3, 1, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/dir/a.txt", 1, 0, 1, 1, "", 0, ""})
+
+	if err := nn.CreateFile("/old.txt", "W1", CREATE|EXCLUSIVE); !errors.Is(err, ErrFileExists) {
+		t.Errorf("Exclusive create of an existing file gave %v", err)
+	}
+	if err := nn.CreateFile("/new.txt", "W1", OVERWRITE); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Overwrite without create of a missing file gave %v", err)
+	}
+	if nn.CreateFile("/dir", "W1", CREATE|OVERWRITE) == nil {
+		t.Errorf("Overwrote a directory")
+	}
+	if len(nn.leases) != 0 {
+		t.Errorf("Refused creates took leases %v", nn.leases)
+	}
+
+	// only the first of two exclusive creates of a new file succeeds
+	if err := nn.CreateFile("/new.txt", "W1", CREATE|EXCLUSIVE); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if nn.CreateFile("/new.txt", "W2", CREATE|EXCLUSIVE) == nil {
+		t.Errorf("Second exclusive create succeeded")
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/new.txt", 1, 0, 1, nn.nextGenStamp(), "", 0, ""})
+	if err := nn.CreateFile("/new.txt", "W1", CREATE|EXCLUSIVE); err != nil {
+		t.Errorf("Writer holding the lease refused again %v", err)
+	}
+
+	// an overwrite replaces the old Blocks rather than mixing with them
+	if err := nn.CreateFile("/old.txt", "W1", CREATE|OVERWRITE); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, ok := nn.filemap.Get("/old.txt"); ok {
+		t.Errorf("Old Blocks kept by an overwrite")
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/old.txt", 2, 0, 1, nn.nextGenStamp(), "", 0, ""})
+	if st, err := nn.Stat("/old.txt"); err != nil || st.NumBlocks != 1 || st.Size != 2 || st.Replication != 1 {
+		t.Errorf("Overwritten file %+v %v", st, err)
+	}
+
+	// without flags Blocks are replaced as they arrive, as before
+	if err := nn.CreateFile("/dir/a.txt", "W1", 0); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, ok := nn.filemap.Get("/dir/a.txt"); !ok {
+		t.Errorf("File deleted by a create without flags")
+	}
+}
+
+func TestWriteConflicts(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	local, peer := net.Pipe()
+	defer peer.Close()
+	nn.SetOutbound("C", local)
+	nn.SetOutbound("DN1", local)
+	decoder := json.NewDecoder(peer)
+	distribute := func(holder string, h BlockHeader) Packet {
+		go nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: DISTRIBUTE, Message: holder, Data: Block{h, []byte("ab")}})
+		for {
+			var r Packet
+			decoder.Decode(&r)
+			if r.DST == "C" {
+				return r
+			}
+		}
+	}
+
+	// a second writer of an open file is refused
+	if err := nn.CreateFile("/out.txt", "W1", CREATE|OVERWRITE); err != nil {
+		t.Fatal(err)
+	}
+	if err := nn.CreateFile("/out.txt", "W2", CREATE|OVERWRITE); !errors.Is(err, ErrWriteConflict) {
+		t.Errorf("Second writer given the lease %v", err)
+	}
+	if r := distribute("W2", BlockHeader{"", "/out.txt", 2, 0, 2, 0, "", 0, ""}); r.CMD != ERROR {
+		t.Errorf("Block of the second writer distributed %v", r)
+	}
+
+	// Blocks of two writes interleaved under one holder are not mixed
+	if r := distribute("W1", BlockHeader{"", "/out.txt", 2, 0, 2, 0, "", 0, ""}); r.CMD != ACK {
+		t.Fatalf("First Block refused %v", r)
+	}
+	if r := distribute("W1", BlockHeader{"", "/out.txt", 2, 0, 3, 0, "", 0, ""}); r.CMD != ERROR || r.Code != ErrWriteConflict.Code {
+		t.Errorf("Block of another version of the file distributed %v", r)
+	}
+	if r := distribute("W1", BlockHeader{"", "/out.txt", 2, 1, 2, 0, "", 0, ""}); r.CMD != ACK {
+		t.Errorf("Second Block refused %v", r)
+	}
+
+	// the next write of the file may have another number of Blocks
+	if err := nn.ReleaseLease("/out.txt", "W1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nn.CreateFile("/out.txt", "W2", CREATE|OVERWRITE); err != nil {
+		t.Fatal(err)
+	}
+	if r := distribute("W2", BlockHeader{"", "/out.txt", 2, 0, 3, 0, "", 0, ""}); r.CMD != ACK {
+		t.Errorf("Block of the next write refused %v", r)
+	}
+}
@@ -0,0 +1,130 @@
+package namenode
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+// errors of packets which cannot be sent
+var (
+	errNotConnected = errors.New("Node is not connected")
+	errQueueFull    = errors.New("Send queue full")
+	errShuttingDown = errors.New("Namenode is shutting down")
+)
+
+// dropOutbound removes the queue of packets for a node once conn, the
+// connection it writes to, has ended. The packets left for a datanode are
+// held, with those sent it until it reconnects.
+func (nn *NameNode) dropOutbound(nodeID string, conn net.Conn) {
+	nn.sendMapLock.Lock()
+	ob, ok := nn.sendMap[nodeID]
+	if !ok || ob.conn != conn {
+		nn.sendMapLock.Unlock()
+		return
+	}
+	delete(nn.sendMap, nodeID)
+	if nodeID != "C" {
+		nn.held[nodeID] = nil
+	}
+	nn.sendMapLock.Unlock()
+
+	conn.Close()
+	nn.retire(ob)
+}
+
+// retire stops the writer of an outbound queue which was replaced or
+// dropped, and passes on the packets it left unsent. Those for a datanode go
+// to its new connection, or are held ahead of any held since, while those
+// for a client fail, as they answer requests made on the lost connection.
+func (nn *NameNode) retire(ob *outbound) {
+	close(ob.done)
+	<-ob.stopped
+
+	pending := ob.unsent
+	for queued := true; queued; {
+		select {
+		case p, ok := <-ob.queue:
+			if ok {
+				pending = append(pending, p)
+			}
+			queued = ok
+		default:
+			queued = false
+		}
+	}
+	for {
+		var p Packet
+		ok, err := ob.spill.pop(&p)
+		if err != nil {
+			ob.log.Error("Could not read spilled packets, dropping them", "err", err)
+		}
+		if !ok {
+			break
+		}
+		pending = append(pending, p)
+	}
+	ob.spill.discard()
+	if len(pending) == 0 {
+		return
+	}
+
+	if ob.ID == "C" {
+		for _, p := range pending {
+			nn.undeliverable(p, errNotConnected)
+		}
+		return
+	}
+	nn.sendMapLock.Lock()
+	held, ok := nn.held[ob.ID]
+	if ok {
+		pending = append(pending, held...)
+		var lost []Packet
+		if len(pending) > nn.sendQueueSize {
+			pending, lost = pending[:nn.sendQueueSize], pending[nn.sendQueueSize:]
+		}
+		nn.held[ob.ID] = pending
+		nn.sendMapLock.Unlock()
+		ob.log.Info("Holding packets until the node reconnects", "packets", len(pending))
+		for _, p := range lost {
+			nn.undeliverable(p, errQueueFull)
+		}
+		return
+	}
+	nn.sendMapLock.Unlock()
+	for _, p := range pending {
+		nn.SendPacket(p)
+	}
+}
+
+// hold keeps a packet for a datanode whose connection dropped, to be sent
+// once it reconnects, up to as many as its send queue takes. The caller must
+// hold sendMapLock.
+func (nn *NameNode) hold(p Packet) error {
+	held, ok := nn.held[p.DST]
+	if !ok {
+		return errNotConnected
+	}
+	if len(held) >= nn.sendQueueSize {
+		return errQueueFull
+	}
+	nn.held[p.DST] = append(held, p)
+	return nil
+}
+
+// undeliverable counts a packet which could neither be sent nor held
+func (nn *NameNode) undeliverable(p Packet, err error) {
+	atomic.AddInt64(&nn.undelivered, 1)
+	nn.connLog.Warn("Could not deliver packet", "err", err, nn.packetAttr(p))
+}
+
+// heldPackets returns the number of packets held for datanodes to reconnect
+func (nn *NameNode) heldPackets() int {
+	nn.sendMapLock.Lock()
+	defer nn.sendMapLock.Unlock()
+	n := 0
+	for _, held := range nn.held {
+		n += len(held)
+	}
+	return n
+}
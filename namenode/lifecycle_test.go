@@ -0,0 +1,159 @@
+package namenode
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectionLifecycle(t *testing.T) {
+
+	nn := New()
+	nn.sendQueueSize = 4
+
+	// packets left by a dropped datanode are held until it reconnects
+	dead, _ := net.Pipe()
+	nn.SetOutbound("DN1", dead)
+	for id := int64(1); id <= 3; id++ {
+		if err := nn.SendPacket(Packet{SRC: "NN", DST: "DN1", CMD: ACK, RequestID: id}); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	nn.dropOutbound("DN1", dead)
+	if err := nn.SendPacket(Packet{SRC: "NN", DST: "DN1", CMD: ACK, RequestID: 4}); err != nil {
+		t.Errorf("Packet for an offline datanode not held %v", err)
+	}
+	if n := nn.heldPackets(); n != 4 {
+		t.Errorf("Held %d packets, expected 4", n)
+	}
+	if err := nn.SendPacket(Packet{SRC: "NN", DST: "DN1", CMD: ACK, RequestID: 5}); err != errQueueFull {
+		t.Errorf("Held a packet beyond the queue size %v", err)
+	}
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	nn.SetOutbound("DN1", conn)
+	decoder := json.NewDecoder(peer)
+	for id := int64(1); id <= 4; id++ {
+		var p Packet
+		peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := decoder.Decode(&p); err != nil || p.RequestID != id {
+			t.Fatalf("Received %v, expected packet %d %v", p, id, err)
+		}
+	}
+	if n := nn.heldPackets(); n != 0 {
+		t.Errorf("Still holding %d packets once reconnected", n)
+	}
+
+	// packets for a client which is gone fail
+	client, _ := net.Pipe()
+	nn.SetOutbound("C", client)
+	nn.dropOutbound("C", client)
+	if err := nn.SendPacket(Packet{SRC: "NN", DST: "C", CMD: ACK}); err != errNotConnected {
+		t.Errorf("Sent a packet to a dropped client %v", err)
+	}
+	if err := nn.SendPacket(Packet{SRC: "NN", DST: "DN9", CMD: ACK}); err != errNotConnected {
+		t.Errorf("Sent a packet to an unknown node %v", err)
+	}
+
+	// a connection which failed a write is closed, and the packet held
+	broken, other := net.Pipe()
+	other.Close()
+	nn.SetOutbound("DN2", broken)
+	nn.SendPacket(Packet{SRC: "NN", DST: "DN2", CMD: ACK, RequestID: 6})
+	nn.sendMapLock.Lock()
+	ob := nn.sendMap["DN2"]
+	nn.sendMapLock.Unlock()
+	select {
+	case <-ob.stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Writer kept writing to a broken connection")
+	}
+	nn.dropOutbound("DN2", broken)
+	nn.sendMapLock.Lock()
+	held := nn.held["DN2"]
+	nn.sendMapLock.Unlock()
+	if len(held) != 1 || held[0].RequestID != 6 {
+		t.Errorf("Unsent packet held as %v", held)
+	}
+
+	// a datanode whose connection ends is offline
+	nn.datanodemap["DN3"] = &datanode{ID: "DN3"}
+	local, remote := net.Pipe()
+	ended := make(chan struct{})
+	go func() {
+		nn.HandleConnection(remote)
+		close(ended)
+	}()
+	json.NewEncoder(local).Encode(Packet{SRC: "DN3", DST: "NN", CMD: HB})
+	for offline := true; offline; {
+		time.Sleep(10 * time.Millisecond)
+		nn.view(func() { offline = nn.offline["DN3"] })
+	}
+	local.Close()
+	select {
+	case <-ended:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Connection not ended")
+	}
+	nn.view(func() {
+		if !nn.offline["DN3"] || nn.datanodemap["DN3"].conn != nil {
+			t.Errorf("Disconnected datanode not offline")
+		}
+	})
+	nn.sendMapLock.Lock()
+	_, queued := nn.sendMap["DN3"]
+	nn.sendMapLock.Unlock()
+	if queued {
+		t.Errorf("Queue of a disconnected datanode kept")
+	}
+
+	// the answer to the heartbeat DN3 never read is held besides that of DN2
+	rec := httptest.NewRecorder()
+	nn.ServeMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{"godfs_packets_held 2", "godfs_packets_undelivered_total 3"} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("Missing metric %s", line)
+		}
+	}
+}
+
+func TestSendDuringShutdown(t *testing.T) {
+
+	nn := New()
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	go io.Copy(ioutil.Discard, peer)
+	nn.SetOutbound("DN1", conn)
+
+	// background tasks may send until shutdown closes the queues
+	stop := make(chan struct{})
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			nn.SendPacket(Packet{SRC: "NN", DST: "DN1", CMD: HB})
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := nn.Shutdown(ctx); err != nil {
+		t.Errorf("%s", err)
+	}
+	close(stop)
+	<-sent
+	if err := nn.SendPacket(Packet{SRC: "NN", DST: "DN1", CMD: HB}); err != errShuttingDown {
+		t.Errorf("Expected packets to be dropped once shut down, got %v", err)
+	}
+}
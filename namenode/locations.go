@@ -0,0 +1,75 @@
+package namenode
+
+// ReplicaLocation describes a replica of a Block in a BlockLocation
+type ReplicaLocation struct {
+	DatanodeID  string
+	Address     string // host the datanode connected from
+	HTTPAddress string // host:port of the datanode's HTTP server, if any
+	Rack        string
+	State       string // online, offline or decommissioning, unknown for a datanode no longer in the cluster
+	Cached      bool   // the replica is kept in the datanode's block cache
+}
+
+// BlockLocation describes a Block of a file and where its replicas are, for
+// scheduling work next to the data
+type BlockLocation struct {
+	BlockNum int
+	Offset   int64 // offset of the Block's first byte in the file
+	Length   int64
+	GenStamp int64
+	BlockID  int64
+	Replicas []ReplicaLocation // ordered from the closest to the reader
+}
+
+// BlockLocations describes the file at path, and the Blocks holding its
+// bytes from offset on, length of them or the rest of the file if length is
+// 0. The replicas of each are ordered from the closest to a reader on host.
+// Only the data Blocks of an erasure coded file hold its bytes.
+func (nn *NameNode) BlockLocations(path string, offset, length int64, host string) (FileStatus, []BlockLocation, error) {
+	if offset < 0 || length < 0 {
+		return FileStatus{}, nil, newError(ErrInvalidHeader, "Invalid byte range of "+path)
+	}
+	st, err := nn.Stat(path)
+	if err != nil {
+		return st, nil, err
+	}
+	if st.IsDir {
+		return st, nil, newError(ErrInvalidHeader, "Not a file "+path)
+	}
+	blocks, ok := nn.filemap.Get(path)
+	if !ok {
+		return st, nil, newError(ErrFileNotFound, "No Blocks of "+path)
+	}
+	numBlocks := st.NumBlocks
+	if st.Erasure != "" {
+		e, err := parsePolicy(st.Erasure)
+		if err != nil {
+			return st, nil, err
+		}
+		numBlocks = e.dataBlocks(st.NumBlocks)
+	}
+
+	locations := make([]BlockLocation, 0)
+	var start int64
+	for i := 0; i < numBlocks; i++ {
+		loc := BlockLocation{BlockNum: i, Offset: start, Length: int64(st.BlockSize), Replicas: make([]ReplicaLocation, 0, len(blocks[i]))}
+		if len(blocks[i]) > 0 {
+			loc.Length, loc.GenStamp, loc.BlockID = int64(blocks[i][0].Size), blocks[i][0].GenStamp, blocks[i][0].BlockID
+		}
+		start += loc.Length
+		if start <= offset || (length > 0 && loc.Offset >= offset+length) {
+			continue
+		}
+		for _, h := range nn.sortByDistance(host, blocks[i]) {
+			replica := ReplicaLocation{DatanodeID: h.DatanodeID, State: "unknown"}
+			if dn, ok := nn.datanodemap[h.DatanodeID]; ok {
+				replica.Address, replica.HTTPAddress = dn.host, dn.httpAddr
+				replica.Rack, replica.State = nn.datanodeRack(dn), nn.datanodeState(dn)
+				replica.Cached = dn.pinned[h]
+			}
+			loc.Replicas = append(loc.Replicas, replica)
+		}
+		locations = append(locations, loc)
+	}
+	return st, locations, nil
+}
@@ -0,0 +1,87 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestBlockLocations(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true, host: "10.0.0.1", httpAddr: "10.0.0.1:8080", pinned: make(map[BlockHeader]bool)}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", listed: true, host: "10.0.0.2"}
+	nn.offline["DN2"] = true
+	for _, h := range []BlockHeader{
+		{"DN1", "/data.txt", 4, 0, 3, 1, "", 0, ""},
+		{"DN2", "/data.txt", 4, 0, 3, 1, "", 0, ""},
+		{"DN1", "/data.txt", 4, 1, 3, 2, "", 0, ""},
+		{"DN1", "/data.txt", 2, 2, 3, 3, "", 0, ""},
+		{"DN9", "/data.txt", 2, 2, 3, 3, "", 0, ""},
+	} {
+		nn.MergeNode(h)
+	}
+	nn.datanodemap["DN1"].pinned[BlockHeader{"DN1", "/data.txt", 4, 1, 3, 2, "", 0, ""}] = true
+	delete(nn.datanodemap, "DN9")
+
+	var r Packet
+	nn.handleNamespace(Packet{SRC: "C", CMD: GETBLOCKLOCATIONS, Headers: []BlockHeader{{Filename: "/data.txt"}}}, &r)
+	if r.CMD != GETBLOCKLOCATIONS || len(r.Status) != 1 || r.Status[0].Size != 10 {
+		t.Fatalf("Unexpected answer %v %s", CommandName(r.CMD), r.Message)
+	}
+	if len(r.Locations) != 3 {
+		t.Fatalf("Listed %d Blocks, expected 3", len(r.Locations))
+	}
+	for i, offset := range []int64{0, 4, 8} {
+		if loc := r.Locations[i]; loc.BlockNum != i || loc.Offset != offset || loc.GenStamp != int64(i+1) {
+			t.Errorf("Unexpected Block %+v", loc)
+		}
+	}
+	states := make(map[string]string)
+	for _, replica := range r.Locations[0].Replicas {
+		states[replica.DatanodeID] = replica.State
+	}
+	if len(states) != 2 || states["DN1"] != "online" || states["DN2"] != "offline" {
+		t.Errorf("Unexpected replicas of the first Block %+v", r.Locations[0].Replicas)
+	}
+	if replica := r.Locations[1].Replicas[0]; replica.Address != "10.0.0.1" || replica.HTTPAddress != "10.0.0.1:8080" || replica.Rack == "" || !replica.Cached {
+		t.Errorf("Unexpected replica of the second Block %+v", replica)
+	}
+	for _, replica := range r.Locations[2].Replicas {
+		if replica.DatanodeID == "DN9" && replica.State != "unknown" {
+			t.Errorf("Replica on a datanode which left is %s", replica.State)
+		}
+	}
+
+	// only the Blocks overlapping the range are listed
+	for _, c := range []struct {
+		offset, length int64
+		blocks         []int
+	}{
+		{4, 0, []int{1, 2}},
+		{3, 2, []int{0, 1}},
+		{4, 4, []int{1}},
+		{9, 100, []int{2}},
+		{10, 0, []int{}},
+	} {
+		_, locations, err := nn.BlockLocations("/data.txt", c.offset, c.length, "")
+		if err != nil || len(locations) != len(c.blocks) {
+			t.Errorf("Range %d+%d listed %+v %v", c.offset, c.length, locations, err)
+			continue
+		}
+		for i, num := range c.blocks {
+			if locations[i].BlockNum != num {
+				t.Errorf("Range %d+%d listed Block %d, expected %d", c.offset, c.length, locations[i].BlockNum, num)
+			}
+		}
+	}
+
+	for _, p := range []Packet{
+		{SRC: "C", CMD: GETBLOCKLOCATIONS, Headers: []BlockHeader{{Filename: "/missing.txt"}}},
+		{SRC: "C", CMD: GETBLOCKLOCATIONS, Headers: []BlockHeader{{Filename: "/"}}},
+		{SRC: "C", CMD: GETBLOCKLOCATIONS, Offset: -1, Headers: []BlockHeader{{Filename: "/data.txt"}}},
+	} {
+		nn.handleNamespace(p, &r)
+		if r.CMD != ERROR {
+			t.Errorf("Locations of %s from %d listed", p.Headers[0].Filename, p.Offset)
+		}
+	}
+}
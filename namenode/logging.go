@@ -0,0 +1,39 @@
+package namenode
+
+import (
+	"log/slog"
+	"os"
+)
+
+// SetLogger replaces the namenode's logger, from which the subsystem
+// loggers are derived
+func (nn *NameNode) SetLogger(l *slog.Logger) {
+	nn.log = l
+	nn.connLog = l.With("subsystem", "connection")
+	nn.placementLog = l.With("subsystem", "placement")
+	nn.metaLog = l.With("subsystem", "metadata")
+}
+
+// defaultLogger writes text logs to stderr at the namenode's log level
+func (nn *NameNode) defaultLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: nn.logLevel}))
+}
+
+// packetAttr describes a packet for logging. Block data and header lists
+// are summarized unless payload logging is enabled.
+func (nn *NameNode) packetAttr(p Packet) slog.Attr {
+	attrs := []any{
+		slog.String("src", p.SRC),
+		slog.String("dst", p.DST),
+		slog.String("cmd", CommandName(p.CMD)),
+		slog.Int("headers", len(p.Headers)),
+		slog.Int("bytes", len(p.Data.Data)),
+	}
+	if p.Message != "" {
+		attrs = append(attrs, slog.String("message", p.Message))
+	}
+	if nn.logPayloads {
+		attrs = append(attrs, slog.Any("payload", p))
+	}
+	return slog.Group("packet", attrs...)
+}
@@ -0,0 +1,34 @@
+package namenode
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestPayloadsSuppressed(t *testing.T) {
+
+	nn := New()
+	var buf bytes.Buffer
+	nn.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	p := Packet{SRC: "C", DST: "NN", CMD: DISTRIBUTE}
+	p.Data = Block{BlockHeader{"", "/out.txt", 7, 0, 1, 0, "", 0, ""}, []byte("secret!")}
+
+	nn.connLog.Info("test", nn.packetAttr(p))
+	out := buf.String()
+	if strings.Contains(out, "secret") || strings.Contains(out, "payload") {
+		t.Errorf("Payload logged while disabled: %s", out)
+	}
+	if !strings.Contains(out, "subsystem=connection") || !strings.Contains(out, "packet.bytes=7") {
+		t.Errorf("Expected packet summary, got %s", out)
+	}
+
+	buf.Reset()
+	nn.logPayloads = true
+	nn.connLog.Info("test", nn.packetAttr(p))
+	if !strings.Contains(buf.String(), "payload") {
+		t.Errorf("Payload not logged while enabled: %s", buf.String())
+	}
+}
@@ -7,17 +7,15 @@ import (
 
 func TestSingleInsert(t *testing.T) {
 
-	filemap = make(map[string]map[int][]BlockHeader)
-	root = &filenode{"/", nil, make([]*filenode, 0, 5)}
-	datanodemap = make(map[string]*datanode)
+	nn := New()
 
-	dn1 := datanode{"DN1", true, 0}
-	datanodemap["DN1"] = &dn1
+	dn1 := datanode{ID: "DN1", listed: true}
+	nn.datanodemap["DN1"] = &dn1
 
 	// Test a file that exists
-	inh := BlockHeader{"DN1", "/out.txt", 1, 0, 1}
-	MergeNode(inh)
-	_, ok := filemap["/out.txt"]
+	inh := BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""}
+	nn.MergeNode(inh)
+	_, ok := nn.filemap.Get("/out.txt")
 	if !ok {
 		t.Errorf("merge failed ")
 	}
@@ -25,28 +23,26 @@ func TestSingleInsert(t *testing.T) {
 
 func TestMultipleInsertsSameFile(t *testing.T) {
 
-	filemap = make(map[string]map[int][]BlockHeader)
-	root = &filenode{"/", nil, make([]*filenode, 0, 5)}
-	datanodemap = make(map[string]*datanode)
+	nn := New()
 
-	dn1 := datanode{"DN1", true, 0}
-	datanodemap["DN1"] = &dn1
+	dn1 := datanode{ID: "DN1", listed: true}
+	nn.datanodemap["DN1"] = &dn1
 
 	// Test handling multiple blocks
-	inh1 := BlockHeader{"DN1", "/out.txt", 1, 0, 2}
-	inh2 := BlockHeader{"DN1", "/out.txt", 1, 1, 2}
+	inh1 := BlockHeader{"DN1", "/out.txt", 1, 0, 2, 0, "", 0, ""}
+	inh2 := BlockHeader{"DN1", "/out.txt", 1, 1, 2, 0, "", 0, ""}
 
-	err := MergeNode(inh1)
+	err := nn.MergeNode(inh1)
 	if err != nil {
 		t.Errorf("%s", err)
 	}
 
-	err = MergeNode(inh2)
+	err = nn.MergeNode(inh2)
 	if err != nil {
 		t.Errorf("%s", err)
 	}
 
-	blks, ok := filemap["/out.txt"]
+	blks, ok := nn.filemap.Get("/out.txt")
 	if !ok {
 		t.Errorf("merge failed ")
 	}
@@ -78,29 +74,27 @@ func TestMultipleInsertsSameFile(t *testing.T) {
 }
 
 func TestDuplicateInsert(t *testing.T) {
-	filemap = make(map[string]map[int][]BlockHeader)
-	root = &filenode{"/", nil, make([]*filenode, 0, 5)}
-	datanodemap = make(map[string]*datanode)
+	nn := New()
 
-	dn1 := datanode{"DN1", true, 0}
-	datanodemap["DN1"] = &dn1
+	dn1 := datanode{ID: "DN1", listed: true}
+	nn.datanodemap["DN1"] = &dn1
 
-	inh := BlockHeader{"DN1", "/out.txt", 1, 0, 1}
-	err := MergeNode(inh)
+	inh := BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""}
+	err := nn.MergeNode(inh)
 
 	if err != nil {
 		t.Errorf("%s", err)
 	}
-	_, ok := filemap["/out.txt"]
+	_, ok := nn.filemap.Get("/out.txt")
 	if !ok {
 		t.Errorf("merge failed ")
 	}
-	err = MergeNode(inh)
+	err = nn.MergeNode(inh)
 	if err != nil {
 		t.Errorf("%s", err)
 	}
 
-	blks, ok := filemap["/out.txt"]
+	blks, ok := nn.filemap.Get("/out.txt")
 	if !ok {
 		t.Errorf("merge failed ")
 	}
@@ -111,3 +105,15 @@ func TestDuplicateInsert(t *testing.T) {
 	}
 
 }
+
+// BenchmarkMergeNode merges the Blocks of a file of 8000 Blocks, each of
+// which changes the file in place
+func BenchmarkMergeNode(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		nn := New()
+		nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+		for num := 0; num < 8000; num++ {
+			nn.MergeNode(BlockHeader{"DN1", "/bench/merge", 1, num, 8000, 1, "", int64(num + 1), ""})
+		}
+	}
+}
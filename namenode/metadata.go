@@ -0,0 +1,81 @@
+package namenode
+
+import (
+	"sort"
+)
+
+// The namespace tree, the Blocks of files and the datanodes are guarded by
+// state. Packet handlers and background tasks changing them hold it for
+// writing, while client requests which only read them share it, as do the
+// status page and the metrics. The exported accessors below take it
+// themselves, for callers outside a handler.
+
+// view runs fn holding the namespace for reading
+func (nn *NameNode) view(fn func()) {
+	nn.state.RLock()
+	defer nn.state.RUnlock()
+	fn()
+}
+
+// update runs fn holding the namespace for writing
+func (nn *NameNode) update(fn func()) {
+	nn.state.Lock()
+	defer nn.state.Unlock()
+	fn()
+}
+
+// FileBlocks returns a copy of the replicas of each Block of the file at path
+func (nn *NameNode) FileBlocks(path string) (map[int][]BlockHeader, bool) {
+	nn.state.RLock()
+	defer nn.state.RUnlock()
+
+	blocks, ok := nn.filemap.Get(path)
+	if !ok {
+		return nil, false
+	}
+	copied := make(map[int][]BlockHeader, len(blocks))
+	for num, replicas := range blocks {
+		copied[num] = append([]BlockHeader(nil), replicas...)
+	}
+	return copied, true
+}
+
+// Files lists the paths of the files holding Blocks, sorted
+func (nn *NameNode) Files() []string {
+	nn.state.RLock()
+	defer nn.state.RUnlock()
+
+	paths := make([]string, 0, nn.filemap.Len())
+	nn.filemap.Range(func(path string, blocks map[int][]BlockHeader) bool {
+		paths = append(paths, path)
+		return true
+	})
+	sort.Strings(paths)
+	return paths
+}
+
+// Datanodes lists the IDs of the known datanodes, sorted
+func (nn *NameNode) Datanodes() []string {
+	nn.state.RLock()
+	defer nn.state.RUnlock()
+
+	ids := make([]string, 0, len(nn.datanodemap))
+	for id := range nn.datanodemap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// DatanodeUsage returns the bytes of Blocks stored on the datanode id, and
+// whether it is known
+func (nn *NameNode) DatanodeUsage(id string) (int64, bool) {
+	nn.state.RLock()
+	defer nn.state.RUnlock()
+
+	dn, ok := nn.datanodemap[id]
+	if !ok {
+		return 0, false
+	}
+	return dn.size, true
+}
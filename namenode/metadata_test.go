@@ -0,0 +1,106 @@
+package namenode
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentMetadata writes files while others are listed and read, on
+// several connections at once. Run it with -race.
+func TestConcurrentMetadata(t *testing.T) {
+
+	nn := New()
+	nn.handlerCount = 4
+	for i, id := range []string{"DN1", "DN2", "DN3"} {
+		nn.datanodemap[id] = &datanode{ID: id, listed: true}
+		local, peer := net.Pipe()
+		defer peer.Close()
+		nn.SetOutbound(id, local)
+
+		// stand in for the datanode, acknowledging each Block it is sent
+		go func(id string, conn int64, peer net.Conn) {
+			decoder := json.NewDecoder(peer)
+			for {
+				var p Packet
+				if decoder.Decode(&p) != nil {
+					return
+				}
+				if p.CMD == BLOCK {
+					nn.dispatch(conn, Packet{SRC: id, DST: "NN", CMD: BLOCKACK, Headers: []BlockHeader{p.Data.Header}})
+				}
+			}
+		}(id, int64(100+i), peer)
+	}
+	local, peer := net.Pipe()
+	defer peer.Close()
+	nn.SetOutbound("C", local)
+	go io.Copy(ioutil.Discard, peer)
+	merged := make(chan struct{})
+	go func() {
+		nn.HandleBlockHeaders()
+		close(merged)
+	}()
+
+	const files, blocks = 6, 4
+	var wg sync.WaitGroup
+	for f := 0; f < files; f++ {
+		path := "/file" + strconv.Itoa(f)
+		holder := "W" + strconv.Itoa(f)
+		writer, reader := int64(f+1), int64(f+50)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			nn.dispatch(writer, Packet{SRC: "C", DST: "NN", CMD: LEASE, Message: holder, Headers: []BlockHeader{{Filename: path}}})
+			for n := 0; n < blocks; n++ {
+				b := Block{BlockHeader{"", path, 1, n, blocks, 0, "", 0, ""}, []byte{byte(n)}}
+				nn.dispatch(writer, Packet{SRC: "C", DST: "NN", CMD: DISTRIBUTE, Data: b, Message: holder})
+			}
+			nn.dispatcher.drain(writer)
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				nn.dispatch(reader, Packet{SRC: "C", DST: "NN", CMD: LIST})
+				nn.dispatch(reader, Packet{SRC: "C", DST: "NN", CMD: GETHEADERS, Headers: []BlockHeader{{Filename: path}}})
+				nn.Files()
+				nn.FileBlocks(path)
+			}
+			nn.dispatcher.drain(reader)
+		}()
+	}
+	wg.Wait()
+
+	// the acknowledgements are merged in the background
+	complete := func() bool {
+		for _, path := range nn.Files() {
+			if b, _ := nn.FileBlocks(path); len(b) != blocks {
+				return false
+			}
+		}
+		return len(nn.Files()) == files
+	}
+	for deadline := time.Now().Add(5 * time.Second); !complete() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(nn.quit)
+	<-merged
+	nn.dispatcher.close()
+
+	if !complete() {
+		t.Fatalf("Expected %d files of %d Blocks, got %v", files, blocks, nn.Files())
+	}
+	var used int64
+	for _, id := range nn.Datanodes() {
+		size, _ := nn.DatanodeUsage(id)
+		used += size
+	}
+	if used != files*blocks {
+		t.Errorf("Expected %d bytes stored, got %d", files*blocks, used)
+	}
+}
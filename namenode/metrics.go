@@ -0,0 +1,239 @@
+package namenode
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// upper bounds in seconds of the block distribution latency histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics holds the counters exported on the /metrics endpoint
+type metrics struct {
+	mu      sync.Mutex
+	packets map[int]int64 // packets handled by CMD
+
+	latencyCounts []int64 // observations per latency bucket
+	latencySum    float64 // total observed latency in seconds
+	latencyCount  int64   // number of observations
+
+	distributing map[BlockHeader]time.Time // assigned Blocks awaiting their BLOCKACK
+
+	corrupt int64 // corrupt replicas reported by datanodes
+
+	refused     map[string]int64 // client requests refused by the reason they were refused
+	connRefused map[string]int64 // connections refused by the limit they would pass
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		packets:       make(map[int]int64),
+		latencyCounts: make([]int64, len(latencyBuckets)),
+		distributing:  make(map[BlockHeader]time.Time),
+		refused:       make(map[string]int64),
+		connRefused:   make(map[string]int64),
+	}
+}
+
+// countPacket records a packet handled by the namenode
+func (m *metrics) countPacket(cmd int) {
+	m.mu.Lock()
+	m.packets[cmd]++
+	m.mu.Unlock()
+}
+
+// countCorrupt records a corrupt replica reported by a datanode
+func (m *metrics) countCorrupt() {
+	m.mu.Lock()
+	m.corrupt++
+	m.mu.Unlock()
+}
+
+// countRefused records a client request refused for a limit
+func (m *metrics) countRefused(reason string) {
+	m.mu.Lock()
+	m.refused[reason]++
+	m.mu.Unlock()
+}
+
+// countConnRefused records a connection refused for a limit
+func (m *metrics) countConnRefused(reason string) {
+	m.mu.Lock()
+	m.connRefused[reason]++
+	m.mu.Unlock()
+}
+
+// startDistribution records the time a Block was assigned to a datanode
+func (m *metrics) startDistribution(h BlockHeader) {
+	m.mu.Lock()
+	m.distributing[h] = time.Now()
+	m.mu.Unlock()
+}
+
+// finishDistribution observes the time between assigning a Block and its
+// BLOCKACK, which it returns, and false if the Block was not being
+// distributed
+func (m *metrics) finishDistribution(h BlockHeader) (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start, ok := m.distributing[h]
+	if !ok {
+		return 0, false
+	}
+	delete(m.distributing, h)
+
+	latency := time.Since(start)
+	seconds := latency.Seconds()
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			m.latencyCounts[i]++
+		}
+	}
+	m.latencySum += seconds
+	m.latencyCount++
+	return latency, true
+}
+
+// isDistributing reports whether a Block of the file at path was assigned to
+// a datanode which has not acknowledged it yet
+func (m *metrics) isDistributing(path string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for h := range m.distributing {
+		if h.Filename == path {
+			return true
+		}
+	}
+	return false
+}
+
+// underReplicated counts the Blocks with fewer replicas than the configured
+// replication factor, including Blocks with no replicas at all
+func (nn *NameNode) underReplicated() int {
+	n := 0
+	nn.filemap.Range(func(path string, blocks map[int][]BlockHeader) bool {
+		numBlocks := 0
+		for _, replicas := range blocks {
+			if len(replicas) > 0 {
+				numBlocks = replicas[0].NumBlocks
+			}
+			break
+		}
+		for i := 0; i < numBlocks; i++ {
+			if len(blocks[i]) < nn.neededReplicas(path) {
+				n++
+			}
+		}
+		return true
+	})
+	return n
+}
+
+// writeMetric writes a single metric family in the Prometheus text format
+func writeMetric(w io.Writer, name, kind, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+	fmt.Fprintf(w, "%s %v\n", name, value)
+}
+
+// ServeMetrics writes the namenode's metrics in the Prometheus text format
+func (nn *NameNode) ServeMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	nn.state.RLock()
+	blocks := 0
+	nn.filemap.Range(func(path string, b map[int][]BlockHeader) bool {
+		blocks += len(b)
+		return true
+	})
+	connected, files, under := len(nn.datanodemap)-len(nn.offline), nn.filemap.Len(), nn.underReplicated()
+	nn.state.RUnlock()
+	writeMetric(w, "godfs_datanodes_connected", "gauge", "Number of connected datanodes.", connected)
+	writeMetric(w, "godfs_files", "gauge", "Number of files in the namespace.", files)
+	writeMetric(w, "godfs_blocks", "gauge", "Number of blocks with at least one replica.", blocks)
+	writeMetric(w, "godfs_blocks_under_replicated", "gauge", "Number of blocks with fewer replicas than the replication factor.", under)
+	fmt.Fprintf(w, "# HELP godfs_replication_queue_length Blocks waiting to be replicated by priority.\n")
+	fmt.Fprintf(w, "# TYPE godfs_replication_queue_length gauge\n")
+	for p, n := range nn.needed.lengths() {
+		fmt.Fprintf(w, "godfs_replication_queue_length{priority=%q} %d\n", priorityNames[p], n)
+	}
+
+	m := nn.metrics
+	m.mu.Lock()
+	cmds := make([]int, 0, len(m.packets))
+	for cmd, _ := range m.packets {
+		cmds = append(cmds, cmd)
+	}
+	sort.Ints(cmds)
+	fmt.Fprintf(w, "# HELP godfs_packets_handled_total Packets handled by command.\n")
+	fmt.Fprintf(w, "# TYPE godfs_packets_handled_total counter\n")
+	for _, cmd := range cmds {
+		fmt.Fprintf(w, "godfs_packets_handled_total{cmd=%q} %d\n", CommandName(cmd), m.packets[cmd])
+	}
+
+	name := "godfs_block_distribution_latency_seconds"
+	fmt.Fprintf(w, "# HELP %s Time from assigning a block to a datanode until its BLOCKACK.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for i, le := range latencyBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, le, m.latencyCounts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, m.latencyCount)
+	fmt.Fprintf(w, "%s_sum %g\n", name, m.latencySum)
+	fmt.Fprintf(w, "%s_count %d\n", name, m.latencyCount)
+	writeMetric(w, "godfs_corrupt_replicas_total", "counter", "Replicas which datanodes found corrupt.", m.corrupt)
+	fmt.Fprintf(w, "# HELP godfs_requests_refused_total Client requests refused for a rate, concurrency or size limit.\n")
+	fmt.Fprintf(w, "# TYPE godfs_requests_refused_total counter\n")
+	for _, reason := range []string{refusedClientRate, refusedGlobalRate, refusedConcurrency, refusedSize} {
+		fmt.Fprintf(w, "godfs_requests_refused_total{reason=%q} %d\n", reason, m.refused[reason])
+	}
+	fmt.Fprintf(w, "# HELP godfs_connections_refused_total Connections refused for the total or per host limit.\n")
+	fmt.Fprintf(w, "# TYPE godfs_connections_refused_total counter\n")
+	for _, reason := range []string{refusedConnections, refusedHostConnections} {
+		fmt.Fprintf(w, "godfs_connections_refused_total{reason=%q} %d\n", reason, m.connRefused[reason])
+	}
+	m.mu.Unlock()
+
+	stats := nn.SendStats()
+	fmt.Fprintf(w, "# HELP godfs_send_queue_depth Packets waiting in a connection's send queue.\n")
+	fmt.Fprintf(w, "# TYPE godfs_send_queue_depth gauge\n")
+	for _, s := range stats {
+		fmt.Fprintf(w, "godfs_send_queue_depth{node=%q} %d\n", s.ID, s.Depth)
+	}
+	fmt.Fprintf(w, "# HELP godfs_send_queue_stalls_total Times a sender blocked on a full send queue.\n")
+	fmt.Fprintf(w, "# TYPE godfs_send_queue_stalls_total counter\n")
+	for _, s := range stats {
+		fmt.Fprintf(w, "godfs_send_queue_stalls_total{node=%q} %d\n", s.ID, s.Stalls)
+	}
+	fmt.Fprintf(w, "# HELP godfs_send_queue_dropped_total Packets dropped as a connection's send queue was full.\n")
+	fmt.Fprintf(w, "# TYPE godfs_send_queue_dropped_total counter\n")
+	for _, s := range stats {
+		fmt.Fprintf(w, "godfs_send_queue_dropped_total{node=%q} %d\n", s.ID, s.Dropped)
+	}
+	fmt.Fprintf(w, "# HELP godfs_send_queue_spilled Packets of a connection's send queue waiting on disc.\n")
+	fmt.Fprintf(w, "# TYPE godfs_send_queue_spilled gauge\n")
+	for _, s := range stats {
+		fmt.Fprintf(w, "godfs_send_queue_spilled{node=%q} %d\n", s.ID, s.OnDisc)
+	}
+
+	writeMetric(w, "godfs_handler_queue_depth", "gauge", "Packets read from connections waiting for a worker.", nn.dispatcher.pending())
+	nn.mu.Lock()
+	open := len(nn.conns)
+	nn.mu.Unlock()
+	writeMetric(w, "godfs_connections_open", "gauge", "Connections accepted and not yet closed.", open)
+	writeMetric(w, "godfs_connections_idle_closed_total", "counter", "Connections closed for sending no packet within the idle timeout.", atomic.LoadInt64(&nn.idleClosed))
+	writeMetric(w, "godfs_packets_held", "gauge", "Packets held for datanodes whose connection dropped until they reconnect.", nn.heldPackets())
+	writeMetric(w, "godfs_packets_undelivered_total", "counter", "Packets which could neither be sent nor held.", atomic.LoadInt64(&nn.undelivered))
+	writeMetric(w, "godfs_client_requests_in_flight", "gauge", "Client requests waiting for a worker or being handled.", atomic.LoadInt64(&nn.clientRequests))
+	writeMetric(w, "godfs_retry_cache_entries", "gauge", "Responses to mutating client requests kept for their retries.", nn.retryCache.len())
+	writeMetric(w, "godfs_header_queue_depth", "gauge", "Reported block headers waiting to be merged.", len(nn.headerChannel)+nn.headerSpill.len())
+	writeMetric(w, "godfs_header_queue_stalls_total", "counter", "Times a handler merged a header itself as the header queue was full.", atomic.LoadInt64(&nn.headerStalls))
+	writeMetric(w, "godfs_header_queue_dropped_total", "counter", "Reported block headers dropped as the header queue was full.", atomic.LoadInt64(&nn.headerDropped))
+	writeMetric(w, "godfs_header_queue_spilled_total", "counter", "Reported block headers written to disc as the header queue was full.", atomic.LoadInt64(&nn.headerSpilled))
+}
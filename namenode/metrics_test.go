@@ -0,0 +1,42 @@
+package namenode
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+
+	nn := New()
+	nn.replication = 2
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+
+	inh1 := BlockHeader{"DN1", "/out.txt", 1, 0, 2, 0, "", 0, ""}
+	inh2 := BlockHeader{"DN1", "/out.txt", 1, 1, 2, 0, "", 0, ""}
+	nn.MergeNode(inh1)
+	nn.MergeNode(inh2)
+
+	nn.metrics.countPacket(HB)
+	nn.metrics.countPacket(HB)
+	nn.metrics.startDistribution(inh1)
+	nn.metrics.finishDistribution(inh1)
+
+	rec := httptest.NewRecorder()
+	nn.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	expected := []string{
+		"godfs_datanodes_connected 1\n",
+		"godfs_files 1\n",
+		"godfs_blocks 2\n",
+		"godfs_blocks_under_replicated 2\n",
+		"godfs_packets_handled_total{cmd=\"HB\"} 2\n",
+		"godfs_block_distribution_latency_seconds_count 1\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Errorf("Metrics missing %q", e)
+		}
+	}
+}
@@ -2,54 +2,348 @@
 package namenode
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
-	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
+	osuser "os/user"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Config Options
-var host string     // listen host
-var port string     // listen port
-var SIZEOFBLOCK int //size of block in bytes
-var id string       // the namenode id
-
-var headerChannel chan BlockHeader   // processes headers into filesystem
-var sendChannel chan Packet          //  enqueued packets for transmission
-var sendMap map[string]*json.Encoder // maps DatanodeIDs to their connections
-var sendMapLock sync.Mutex
-var clientMap map[BlockHeader]string // maps requested Blocks to the client ID which requested them, based on Blockheader
-var clientMapLock sync.Mutex
-
-var blockReceiverChannel chan Block        // used to fetch blocks on user request
-var blockRequestorChannel chan BlockHeader // used to send block requests
-
-var root *filenode                             // the filesystem
-var filemap map[string](map[int][]BlockHeader) // filenames to blocknumbers to headers
-var datanodemap map[string]*datanode           // filenames to datanodes
+// commands for node communication, sent by number, so new commands are
+// only added at the end
+const (
+	HB                = iota // heartbeat
+	LIST              = iota // list directorys
+	ACK               = iota // acknowledgement
+	BLOCK             = iota // handle the incoming Block
+	BLOCKACK          = iota // notifcation that Block was written to disc
+	RETRIEVEBLOCK     = iota // request to retrieve a Block
+	DISTRIBUTE        = iota // request to distribute a Block to a datanode
+	GETHEADERS        = iota // request to retrieve the headers of a given filename
+	ERROR             = iota // notification of a failed request
+	INVALIDATE        = iota // request to delete the listed Blocks from a datanode
+	INVALIDATEACK     = iota // notification that invalidated Blocks were deleted
+	DELETE            = iota // request to delete a file
+	BLOCKREPORT       = iota // incremental report of Blocks added and removed on a datanode
+	STAT              = iota // request the status of a file or directory
+	LISTDIR           = iota // request the status of the entries of a directory
+	MKDIR             = iota // request to create a directory
+	SETQUOTA          = iota // request to set the quotas of a directory
+	GETQUOTA          = iota // request the quotas and usage of a directory
+	RENAME            = iota // request to move a file or directory, or the listed Blocks of a datanode
+	RENAMEACK         = iota // notification that renamed Blocks were moved
+	CREATESNAPSHOT    = iota // request to capture a directory as a named snapshot
+	DELETESNAPSHOT    = iota // request to delete a snapshot of a directory
+	LISTSNAPSHOT      = iota // request the snapshots of a directory
+	DECOMMISSION      = iota // request to drain a datanode and remove it from the cluster
+	BALANCE           = iota // request to move Blocks until datanodes store similar amounts
+	LEASE             = iota // request the lease on a file before writing it
+	RELEASE           = iota // notification that a file is written and its lease may be given up
+	ERASURECODE       = iota // request to store a file being written with an erasure coding policy
+	CORRUPTBLOCK      = iota // notification that the listed Blocks of a datanode failed verification
+	HELLO             = iota // handshake describing a node, the first packet of a connection
+	CREATEZONE        = iota // request to make an empty directory an encryption zone
+	LISTZONES         = iota // request the encryption zones and their keys
+	FILEKEY           = iota // request to store the wrapped data key of a file being written in an encryption zone
+	REPORT            = iota // request a report on the datanodes and the namespace
+	SAFEMODE          = iota // request to enter, leave or report the read-only safe mode
+	REFRESHNODES      = iota // request to reload the host lists and the topology of the datanodes
+	SETBLOCKSIZE      = iota // request to change the default size of new Blocks
+	LISTLEASES        = iota // request the leases on files being written
+	TRIGGERREPORT     = iota // request a full block report from a datanode, or from all of them
+	SETREP            = iota // request to change the replication factor of a file or of the files below a directory
+	REPLICATE         = iota // request to copy a Block directly to another datanode
+	REPLICATEACK      = iota // notification that a datanode copied a Block to another, or could not
+	TRUNCATE          = iota // request to shorten a file to a length
+	GLOB              = iota // request the status of the files and directories matching a pattern
+	SETTIMES          = iota // request to set the modification and access times of a file or directory
+	SETXATTR          = iota // request to set an extended attribute of a file or directory
+	GETXATTR          = iota // request the value of an extended attribute
+	LISTXATTRS        = iota // request the names of the extended attributes of a file or directory
+	REMOVEXATTR       = iota // request to remove an extended attribute
+	CREATESYMLINK     = iota // request to create a symbolic link to a path
+	READLINK          = iota // request the target of a symbolic link
+	SUBSCRIBE         = iota // request the events of the namespace below a path
+	UNSUBSCRIBE       = iota // request to end a subscription to events
+	EVENT             = iota // a change to the namespace, sent to a subscription
+	HASTATE           = iota // request to make a namenode the active or the standby, or report which it is
+	REGISTER          = iota // request the ID a datanode is known by, following its HELLO
+	SHUTDOWN          = iota // request to stop a datanode, sent with the answer to its heartbeat
+	SETBANDWIDTH      = iota // request to cap the bytes per second a datanode sends copying replicas to other datanodes
+	BATCH             = iota // request to perform many namespace requests at once, answered with the answer to each
+	ARCHIVE           = iota // request to index the files packed into an archive file, which are then read through it
+	CACHE             = iota // request to keep the Blocks of the files at or below a path in the memory of their datanodes
+	UNCACHE           = iota // request to stop keeping the Blocks of a path given to CACHE in memory
+	LISTCACHE         = iota // request the paths whose Blocks are kept in memory
+	STORAGEPOLICY     = iota // request to set the storage policy of a file or directory, or to clear it
+	MIGRATE           = iota // request a datanode to move Blocks to its volumes of another storage type
+	SNAPSHOTDIFF      = iota // request the paths changed below a directory between two of its snapshots
+	TENANTS           = iota // request the use each tenant makes of the namenode, or to reload the tenants
+	CHUNK             = iota // part of the Block data of the packet before it
+	GETREPORT         = iota // request the datanodes and totals of the cluster as JSON
+	RECOVERLEASE      = iota // request to take the lease on a file from its writer at once
+	GETBLOCKLOCATIONS = iota // request the replicas of the Blocks of a file within the byte range of Offset and Limit
+	FLUSH             = iota // request to make the Blocks of a file being written durable on a number of replicas, answered with its visible length
+	SYNC              = iota // request a datanode to force the listed Blocks to stable storage
+	SYNCACK           = iota // notification that the listed Blocks are on stable storage
+	RESUME            = iota // request to write a file resumably, or to resume its write, answered with the Blocks it is missing
+	CHECKSUM          = iota // request for the CRC-32 of a Block's data, answered by its datanode
+	CHECKSUMACK       = iota // answer to a CHECKSUM with the CRC-32 of the Block's uncompressed data
+)
 
-// commands for node communication
+// flags modifying commands
 const (
-	HB            = iota // heartbeat
-	LIST          = iota // list directorys
-	ACK           = iota // acknowledgement
-	BLOCK         = iota // handle the incoming Block
-	BLOCKACK      = iota // notifcation that Block was written to disc
-	RETRIEVEBLOCK = iota // request to retrieve a Block
-	DISTRIBUTE    = iota // request to distribute a Block to a datanode
-	GETHEADERS    = iota // request to retrieve the headers of a given filename
-	ERROR         = iota // notification of a failed request
+	RECURSIVE = 1 << iota // DELETE and LISTDIR directory contents
+	PARENTS               // MKDIR creates missing parent directories
+	SKIPTRASH             // DELETE removes immediately rather than moving to the trash
+	CAPACITY              // HB reports the free storage of a datanode in Capacity
+	FSYNC                 // DISTRIBUTE and BLOCK have the datanode force the Block to stable storage before its BLOCKACK, which has it set
+	CREATE                // LEASE may create the file if it does not exist
+	OVERWRITE             // LEASE empties an existing file, so the Blocks written replace its own
+	EXCLUSIVE             // LEASE fails if the file exists
 )
 
+// names of the commands, used when reporting on packets
+var commandNames = []string{"HB", "LIST", "ACK", "BLOCK", "BLOCKACK", "RETRIEVEBLOCK", "DISTRIBUTE",
+	"GETHEADERS", "ERROR", "INVALIDATE", "INVALIDATEACK", "DELETE", "BLOCKREPORT", "STAT", "LISTDIR",
+	"MKDIR", "SETQUOTA", "GETQUOTA", "RENAME", "RENAMEACK", "CREATESNAPSHOT", "DELETESNAPSHOT",
+	"LISTSNAPSHOT", "DECOMMISSION", "BALANCE", "LEASE", "RELEASE", "ERASURECODE",
+	"CORRUPTBLOCK", "HELLO", "CREATEZONE", "LISTZONES", "FILEKEY", "REPORT", "SAFEMODE", "REFRESHNODES",
+	"SETBLOCKSIZE", "LISTLEASES", "TRIGGERREPORT", "SETREP", "REPLICATE", "REPLICATEACK", "TRUNCATE", "GLOB", "SETTIMES",
+	"SETXATTR", "GETXATTR", "LISTXATTRS", "REMOVEXATTR", "CREATESYMLINK", "READLINK",
+	"SUBSCRIBE", "UNSUBSCRIBE", "EVENT", "HASTATE", "REGISTER", "SHUTDOWN", "SETBANDWIDTH", "BATCH", "ARCHIVE",
+	"CACHE", "UNCACHE", "LISTCACHE", "STORAGEPOLICY", "MIGRATE", "SNAPSHOTDIFF", "TENANTS", "CHUNK", "GETREPORT",
+	"RECOVERLEASE", "GETBLOCKLOCATIONS", "FLUSH", "SYNC", "SYNCACK", "RESUME", "CHECKSUM", "CHECKSUMACK"}
+
+// CommandName returns the name of a command
+func CommandName(cmd int) string {
+	if cmd < 0 || cmd >= len(commandNames) {
+		return "UNKNOWN"
+	}
+	return commandNames[cmd]
+}
+
+// NameNode holds the state of a single namenode, so several can run
+// within one process
+type NameNode struct {
+	// Config Options
+	host          string   // listen host
+	port          string   // listen port
+	sizeofblock   int      // size of block in bytes
+	id            string   // the namenode id
+	sendQueueSize int      // number of packets buffered per connection
+	metadatafile  string   // location the namespace is saved to on shutdown
+	metadatastore string   // file the Blocks of files are kept in, rather than in memory
+	metadatacache int      // files of the metadata store cached in memory
+	minProtocol   int      // oldest protocol version accepted from nodes
+	codecs        []string // codecs of frames accepted from nodes besides JSON
+	httpport      string   // port of the HTTP server, disabled if empty
+	quic          bool     // also accept QUIC connections on the UDP port of listenport
+	replication   int      // desired number of replicas of each block
+	logLevel      *slog.LevelVar
+	logPayloads   bool // include packet contents when logging packets
+
+	auditLog   *auditLog // records client requests, nil if disabled
+	auditFile  string    // file the audit log is written to, disabled if empty
+	auditSize  int64     // bytes the audit log grows to before it is rotated
+	auditFiles int       // rotated audit logs kept
+
+	log          *slog.Logger
+	connLog      *slog.Logger // connections and packet transmission
+	placementLog *slog.Logger // assignment of blocks to datanodes
+	metaLog      *slog.Logger // the namespace and block reports
+
+	headerChannel chan BlockHeader // processes headers into filesystem
+	headerSpill   *spillFile       // headers which overflowed headerChannel, with the spill policy
+	headerWake    chan struct{}    // signalled when a header is spilled
+	queueOverflow string           // what to do with items for a full queue: block, drop or spill
+	spillDir      string           // directory queues spill to, the system's temporary directory if empty
+	merger        sync.WaitGroup   // the running HandleBlockHeaders started by Serve
+
+	// header queue counters, accessed atomically
+	headerStalls  int64 // headers which found the queue full and were merged by their handler
+	headerDropped int64 // headers discarded as the queue was full
+	headerSpilled int64 // headers written to disc as the queue was full
+
+	// Packet handling
+	dispatcher       *dispatcher // hands the packets of connections to the workers in turn
+	handlerCount     int         // workers handling packets
+	handlerQueueSize int         // packets queued per connection before it stops being read
+	connSeq          int64       // numbers the connections, accessed atomically
+
+	// Client request limits
+	clientRate        float64      // requests per second of each client connection, 0 for no limit
+	globalRate        float64      // requests per second of all clients, 0 for no limit
+	globalLimiter     *rateLimiter // limits the requests of all clients, nil for no limit
+	maxClientRequests int          // client requests waiting or being handled at once, 0 for no limit
+	maxRequestSize    int64        // longest client request in bytes, 0 for no limit
+	clientRequests    int64        // client requests waiting or being handled, accessed atomically
+
+	// Connection limits
+	maxConnections      int           // open connections, 0 for no limit
+	maxConnectionsPerIP int           // open connections from a single host, 0 for no limit
+	idleTimeout         time.Duration // time without a packet after which a connection is closed, 0 for never
+	idleClosed          int64         // connections closed as idle, accessed atomically
+
+	state sync.RWMutex // guards the namespace and datanodes between handlers and background tasks
+
+	sendMap       map[string]*outbound // maps node IDs to their outbound queues
+	held          map[string][]Packet  // packets for datanodes whose connection dropped, sent once they reconnect
+	sendMapLock   sync.Mutex
+	sendClosed    bool             // the queues are closed by shutdown, so packets sent since are dropped
+	sending       sync.WaitGroup   // SendPacket calls enqueueing a packet
+	undelivered   int64            // packets which could neither be sent nor held, accessed atomically
+	clientMap     map[int64]string // maps the IDs of requested Blocks to the client ID which requested them
+	clientMapLock sync.Mutex
+
+	root        *filenode            // the filesystem
+	filemap     fileMap              // filenames to blocknumbers to headers
+	datanodemap map[string]*datanode // datanode IDs to datanodes
+	offline     map[string]bool      // datanodes which are known but not connected
+
+	invalidations  map[string][]BlockHeader // datanode IDs to replicas awaiting deletion
+	invalidateLock sync.Mutex
+	renames        map[string][]renameOrder // datanode IDs to Blocks awaiting a rename
+	renameLock     sync.Mutex
+	commands       map[string][]Packet // datanode IDs to commands awaiting their next heartbeat
+	commandLock    sync.Mutex
+
+	// Trash
+	trashInterval time.Duration        // time deleted paths stay in the trash, 0 disables it
+	trash         map[string]time.Time // paths in the trash to the time they were deleted
+
+	// Orphaned replicas
+	orphanGrace time.Duration             // time a replica of no file is kept before it is deleted, 0 disables it
+	orphans     map[BlockHeader]time.Time // replicas of no file to the time they were first reported
+
+	snapshots map[string]map[string]*snapshot // directories to their snapshots by name
+
+	archives map[string]*archive // container files to the index of the files packed into them
+
+	cacheDirectives map[string]bool // paths whose Blocks are kept in datanode memory
+	cacheInterval   time.Duration   // time between scans placing the Blocks of cache directives
+
+	storage       map[BlockHeader]string    // replicas to the storage type holding them, where it is not DISK
+	migrating     map[BlockHeader]time.Time // replicas a datanode was asked to move between its volumes
+	moverInterval time.Duration             // time between scans moving replicas to the storage of their policies
+
+	erasure map[string]FileStatus // erasure coded files to their policy and size
+
+	encrypted map[string]FileStatus // files in encryption zones to their zone key and wrapped data key
+
+	layouts              map[string]FileStatus // files to their own block size and replication factor
+	replicationQueue     map[string]bool       // files whose Blocks may have too few or too many replicas
+	replicationQueueLock sync.Mutex
+
+	replications   map[BlockHeader]replicationOrder // replicas being copied to another datanode
+	replicateLock  sync.Mutex
+	decommissioned map[string]bool // datanodes removed from the cluster
+
+	needed              *neededReplications // Blocks waiting to be replicated, most urgent first
+	replicationRate     int                 // Blocks whose replication may start each second
+	replicationInterval time.Duration       // time between checks of the queued files
+
+	// Host lists, reloaded by REFRESHNODES
+	includeFile  string          // file naming the datanodes which may register, any may if empty
+	excludeFile  string          // file naming the datanodes to decommission
+	includeHosts map[string]bool // datanode IDs and hosts which may register, nil if any may
+	excludeHosts map[string]bool // datanode IDs and hosts to decommission
+	hostsLock    sync.Mutex
+
+	// Balancer
+	balanceBandwidth int64                       // default bytes per second moved by the balancer
+	balanceRate      int64                       // bytes per second moved by the running balancer
+	balancing        bool                        // whether the balancer is running
+	balanceLock      sync.Mutex                  // guards balanceRate and balancing
+	moving           map[BlockHeader]pendingMove // copies made by the balancer to their originals
+
+	// Topology
+	topology       map[string]string // datanode IDs and hosts to their racks
+	topologyScript string            // command printing the rack of an ID or host missing from topology
+	topologyFile   string            // file topology was loaded from, reloaded by REFRESHNODES
+	topologyLock   sync.Mutex
+	clientHost     string // host of the client connection, which reads prefer to be close to
+
+	// Placement
+	placement string    // how datanodes are chosen for new Blocks: random or hash
+	ring      *hashRing // ring of the known datanodes for hash placement, rebuilt as they change
+	ringLock  sync.Mutex
+
+	genStamp int64 // the newest generation stamp given to a Block, accessed atomically
+	blockID  int64 // the newest ID given to a Block, accessed atomically
+
+	synced   map[BlockHeader]bool      // replicas of files being written which their datanodes synced for a FLUSH
+	syncing  map[BlockHeader]time.Time // replicas a datanode was asked to sync, to when it was asked
+	syncLock sync.Mutex
+
+	leases    map[string]*lease // files being written to their writer's lease
+	leaseLock sync.Mutex
+
+	// Administration
+	admins    map[string]bool // users allowed administrative requests besides superuser
+	superuser string          // user running the namenode, always an administrator
+	safeMode  bool            // clients may not change the namespace
+
+	// Tenants
+	tenantsFile string             // file describing the tenants, none if empty
+	tenantsRoot string             // directory holding the namespace root of each tenant
+	tenants     map[string]*tenant // tenants by ID
+	tenantUsers map[string]*tenant // tenant of each of their users
+	tenantLock  sync.Mutex
+
+	metrics      *metrics
+	recentErrors errorLog // errors shown on the status page
+
+	retryCache *retryCache // responses to mutating client requests, for their retries
+
+	globLimit int // paths a glob pattern may match along the way before it is refused
+
+	maxPathDepth       int // components a path may have
+	maxComponentLength int // bytes a component of a path may have
+
+	accessTimePrecision time.Duration // how stale an access time may be before a read updates it, 0 to never update it
+
+	maxXAttrs    int // extended attributes a file or directory may have
+	maxXAttrSize int // bytes of the name and value of an extended attribute
+
+	maxSymlinkDepth int // symbolic links followed resolving a path
+
+	subscriptions *subscriptions // client requests for namespace events
+
+	// High availability
+	standby       bool          // edits are read from the active namenode, and requests refused
+	activeAddress string        // HTTP host:port of the active namenode a standby reads edits from, or empty to read the shared edit log
+	editLogPath   string        // file the edits made since the namespace was saved are appended to, disabled if empty
+	editLog       *os.File      // the edit log while appending to it
+	editSeq       int64         // number of the last edit logged or applied
+	imageSeq      int64         // number of the last edit the metadata file includes, those before it are dropped from the edit log
+	tailInterval  time.Duration // time between reads of the active namenode's edits
+	replaying     bool          // an edit is being applied, so its changes are not logged again
+	logging       bool          // a request is being handled whose edit is logged once it is done
+
+	mu           sync.Mutex        // guards listener, quicListener, httpServer and conns
+	listener     net.Listener      // accepts connections while serving
+	quicListener net.Listener      // accepts QUIC connections if configured
+	httpServer   *http.Server      // serves HTTP endpoints if configured
+	conns        map[net.Conn]bool // open connections
+	hostConns    map[string]int    // open connections of each host
+	handlers     sync.WaitGroup    // running HandleConnection goroutines
+	stop         chan struct{}     // closed when shutdown begins
+	quit         chan struct{}     // closed once handlers have stopped
+	finished     chan struct{}     // closed once shutdown has completed
+}
+
 // The XML parsing structures for configuration options
 type ConfigOptionList struct {
 	XMLName       xml.Name       `xml:"ConfigOptionList"`
@@ -74,16 +368,70 @@ type BlockHeader struct {
 	Size       int    // size of Block in bytes
 	BlockNum   int    // the 0 indexed position of Block within file
 	NumBlocks  int    // total number of Blocks in file
+	GenStamp   int64  // generation of the Block, assigned by the namenode when it is written
+	Codec      string // compression of the Block's data, empty if it is not compressed
+	BlockID    int64  // unique ID of the Block given by the namenode, 0 for Blocks written before IDs
+	Nonce      string // base64 nonce the client sealed the Block's data with, empty if it is not encrypted
 }
 
 // Packets are sent over the network
 type Packet struct {
-	SRC     string        // source ID
-	DST     string        // destination ID
-	CMD     int           // command for the handler
-	Message string        // optional packet contents explanation
-	Data    Block         // optional Block
-	Headers []BlockHeader // optional BlockHeader list
+	SRC       string          // source ID
+	DST       string          // destination ID
+	CMD       int             // command for the handler
+	Message   string          // optional packet contents explanation
+	Data      Block           // optional Block
+	Headers   []BlockHeader   // optional BlockHeader list
+	Removed   []BlockHeader   // optional BlockHeader list of deleted Blocks
+	Renamed   []BlockHeader   // optional new BlockHeaders of the renamed Blocks in Headers
+	ReportID  int64           // identifies a block report and its acknowledgement
+	RequestID int64           // identifies a request, echoed in its response
+	Flags     int             // optional command flags
+	Status    []FileStatus    // optional file and directory descriptions
+	Address   string          // optional HTTP address a datanode serves on
+	Capacity  int64           // optional free bytes of a datanode, with CAPACITY set
+	Hello     *Hello          // optional description of a node, with HELLO
+	User      string          // user a client request is made as, for the audit log
+	Key       string          // optional idempotency key of a mutating client request, the same in its retries
+	Commands  []Packet        // optional commands for a datanode, with the answer to its heartbeat, or the requests of a BATCH and their answers
+	Offset    int             // optional first item of a paged answer, such as the block number of the first header, or the first byte of GETBLOCKLOCATIONS
+	Limit     int             // optional most items of a paged answer, or bytes of GETBLOCKLOCATIONS, 0 for all
+	Cache     *CacheStats     // optional use of a datanode's block cache, with its heartbeat
+	Storage   string          // storage type a Block is written or moved to, such as SSD
+	Storages  []string        // storage type of each of the Headers of a block report, or of a datanode's volumes with its heartbeat
+	Chunked   int64           // optional bytes of the Block data sent after it in CHUNK packets
+	Code      string          // machine-readable kind of an ERROR, such as FILE_NOT_FOUND
+	Locations []BlockLocation // the Blocks of a byte range and their replicas, answering GETBLOCKLOCATIONS
+}
+
+// FileStatus describes a file or directory in the namespace
+type FileStatus struct {
+	Path        string // absolute path
+	IsDir       bool   // true for directories
+	Size        int64  // total size of a file in bytes
+	NumBlocks   int    // number of Blocks in a file
+	Replication int    // fewest replicas of any Block in a file
+	Children    int    // number of entries in a directory
+	FileQuota   int    // maximum number of files below a directory, 0 for none
+	SpaceQuota  int64  // maximum bytes of file data below a directory, 0 for none
+	FileCount   int    // number of files below a directory, when its usage was requested
+	SpaceUsed   int64  // bytes of file data below a directory, when its usage was requested
+	Erasure     string // erasure coding policy of a file, such as RS-6-3, empty for replicated files
+	Zone        string // zone key of an encryption zone or of the files within it, empty outside zones
+	Key         []byte // data key of an encrypted file, wrapped with its zone key
+	BlockSize   int    // size of the Blocks of a file
+	Replicas    int    // replicas wanted of each Block of a file
+	ModTime     int64  // last change, in milliseconds since the epoch
+	AccessTime  int64  // last read of a file, in milliseconds since the epoch
+	Symlink     string // target of a symbolic link, empty for files and directories
+	Archive     string // archive holding a packed file, or the path of an archive itself
+	Offset      int64  // offset of a packed file within its archive
+	Storage     string // storage policy of a file or directory, set on it or inherited, empty for none
+	Change      string // change to a path in a snapshot diff: + created, - deleted, M modified or R renamed
+	Source      string // path a renamed path had before, in a snapshot diff
+	Writing     bool   // file still being written, whose Size is the bytes its writer flushed, in answers to GETHEADERS
+
+	XAttrs map[string][]byte // extended attributes, in requests and answers about them
 }
 
 // filenodes compose an internal tree representation of the filesystem
@@ -91,14 +439,56 @@ type filenode struct {
 	path     string
 	parent   *filenode
 	children []*filenode
+	explicit bool // directory created by MKDIR, which is kept when empty
+
+	fileQuota  int   // maximum number of files below the directory, 0 for none
+	spaceQuota int64 // maximum bytes of file data below the directory, 0 for none
+
+	zoneKey string // key of the encryption zone rooted at the directory, empty for none
+
+	mtime int64 // last change of a file or the entries of a directory, in milliseconds since the epoch
+	atime int64 // last read of a file, in milliseconds since the epoch, accessed atomically
+
+	xattrs map[string][]byte // extended attributes by name
+
+	target string // target path of a symbolic link, empty for files and directories
+
+	policy string // storage policy set on the file or directory, inherited by the files below
 }
 
 // Represent connected Datanodes
 // Hold file and connection information
 type datanode struct {
-	ID     string
-	listed bool
-	size   int64
+	ID         string
+	listed     bool
+	size       int64
+	lastReport int64 // ID of the last block report applied
+
+	lastHeartbeat time.Time // time the last heartbeat was received
+	httpAddr      string    // host:port of the datanode's HTTP server, if any
+	host          string    // host the datanode connected from
+
+	capacity      int64  // free bytes on the datanode's healthy volumes
+	hasCapacity   bool   // the datanode reported its capacity
+	failedVolumes string // storage directories which failed on the datanode
+
+	decommissioning bool // no new Blocks are placed while its Blocks are replicated elsewhere
+
+	protocol int      // protocol version agreed with the datanode
+	software string   // release the datanode runs, if it sent a HELLO
+	features []string // optional features agreed with the datanode
+
+	reportRequested bool // an administrator asked for a full block report
+
+	latency latencies // times from sending the datanode a Block to its BLOCKACK
+	slow    bool      // its latencies are outliers, so it is given new Blocks last
+
+	cache  CacheStats           // use of the datanode's block cache, from its last heartbeat
+	pinned map[BlockHeader]bool // replicas the datanode was asked to keep in its cache
+
+	storages []string // storage types of the datanode's volumes, DISK alone if empty
+
+	conn net.Conn // connection of the datanode, closed if the namenode becomes the standby
 }
 
 // By is used to select the fields used when comparing datanodes
@@ -131,16 +521,306 @@ func (s *datanodeSorter) Less(i, j int) bool {
 	return s.by(&s.nodes[i], &s.nodes[j])
 }
 
-// Sendpacket abstracts packet sending details
-func (dn *datanode) SendPacket(p Packet) {
-	sendChannel <- p
+// outbound queues packets for a single connection, which are written
+// by its own goroutine so a slow peer only stalls itself
+type outbound struct {
+	ID      string
+	conn    net.Conn // connection the packets are written to
+	encoder packetEncoder
+	log     *slog.Logger
+	queue   chan Packet   // bounded buffer of pending packets
+	done    chan struct{} // closed when the connection is replaced or dropped
+	stopped chan struct{} // closed once the writer returns
+	flushed chan struct{} // closed once a closed queue has been written out
+	wake    chan struct{} // signalled when a packet is spilled
+	policy  string        // what to do with packets for a full queue
+	spill   *spillFile    // packets which overflowed the queue, with the spill policy
+	unsent  []Packet      // packet the writer failed to write, set before stopped is closed
+
+	// counters, accessed atomically
+	sent    int64 // packets written to the connection
+	stalls  int64 // enqueues which found the queue full and had to wait
+	errors  int64 // packets which failed to encode
+	dropped int64 // packets discarded as the queue was full
+	spilled int64 // packets written to disc as the queue was full
+}
+
+// SendQueueStats is a snapshot of an outbound queue used to monitor backpressure
+type SendQueueStats struct {
+	ID       string
+	Depth    int   // packets currently waiting
+	Capacity int   // size of the buffer
+	Sent     int64 // packets written
+	Stalls   int64 // times a sender blocked on a full queue
+	Errors   int64 // packets which could not be written
+	Dropped  int64 // packets discarded as the queue was full
+	Spilled  int64 // packets written to disc as the queue was full
+	OnDisc   int   // spilled packets still waiting
+}
+
+func newOutbound(id string, conn net.Conn, size int) *outbound {
+	return &outbound{
+		ID:      id,
+		conn:    conn,
+		encoder: newPacketEncoder(conn),
+		queue:   make(chan Packet, size),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		flushed: make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// New returns a namenode with an empty filesystem and default configuration
+func New() *NameNode {
+	nn := &NameNode{
+		sendQueueSize: 64,
+		auditSize:     64 << 20,
+		auditFiles:    10,
+
+		headerChannel: make(chan BlockHeader, 1024),
+		headerWake:    make(chan struct{}, 1),
+		queueOverflow: overflowBlock,
+		sendMap:       make(map[string]*outbound),
+		held:          make(map[string][]Packet),
+		clientMap:     make(map[int64]string),
+
+		dispatcher:       newDispatcher(),
+		handlerCount:     defaultHandlerCount,
+		handlerQueueSize: defaultHandlerQueueSize,
+
+		root:        &filenode{path: "/", children: make([]*filenode, 0, 1), explicit: true},
+		filemap:     newCompactFileMap(),
+		datanodemap: make(map[string]*datanode),
+		offline:     make(map[string]bool),
+
+		invalidations: make(map[string][]BlockHeader),
+		renames:       make(map[string][]renameOrder),
+		commands:      make(map[string][]Packet),
+		trash:         make(map[string]time.Time),
+		orphans:       make(map[BlockHeader]time.Time),
+		snapshots:     make(map[string]map[string]*snapshot),
+		archives:      make(map[string]*archive),
+		erasure:       make(map[string]FileStatus),
+		encrypted:     make(map[string]FileStatus),
+		layouts:       make(map[string]FileStatus),
+
+		replicationQueue: make(map[string]bool),
+
+		cacheDirectives: make(map[string]bool),
+		cacheInterval:   defaultCacheInterval,
+
+		storage:       make(map[BlockHeader]string),
+		migrating:     make(map[BlockHeader]time.Time),
+		moverInterval: defaultMoverInterval,
+
+		replications:   make(map[BlockHeader]replicationOrder),
+		decommissioned: make(map[string]bool),
+
+		needed:              newNeededReplications(),
+		replicationRate:     defaultReplicationRate,
+		replicationInterval: defaultReplicationInterval,
+
+		balanceBandwidth: defaultBalanceBandwidth,
+		moving:           make(map[BlockHeader]pendingMove),
+		topology:         make(map[string]string),
+		placement:        placementRandom,
+		leases:           make(map[string]*lease),
+		synced:           make(map[BlockHeader]bool),
+		syncing:          make(map[BlockHeader]time.Time),
+		admins:           make(map[string]bool),
+		tenantsRoot:      defaultTenantsRoot,
+
+		replication:   1,
+		metadatacache: 100000,
+		minProtocol:   1,
+		codecs:        defaultCodecs,
+		metrics:       newMetrics(),
+		retryCache:    newRetryCache(defaultRetryCacheExpiry),
+		globLimit:     defaultGlobLimit,
+
+		maxPathDepth:       defaultMaxPathDepth,
+		maxComponentLength: defaultMaxComponentLength,
+
+		accessTimePrecision: defaultAccessTimePrecision,
+
+		maxXAttrs:    defaultMaxXAttrs,
+		maxXAttrSize: defaultMaxXAttrSize,
+
+		maxSymlinkDepth: defaultMaxSymlinkDepth,
+
+		subscriptions: newSubscriptions(),
+		tailInterval:  defaultTailInterval,
+
+		conns:     make(map[net.Conn]bool),
+		hostConns: make(map[string]int),
+		stop:      make(chan struct{}),
+		quit:      make(chan struct{}),
+		finished:  make(chan struct{}),
+
+		logLevel: new(slog.LevelVar),
+	}
+	if u, err := osuser.Current(); err == nil {
+		nn.superuser = u.Username
+	}
+	nn.SetLogger(nn.defaultLogger())
+	return nn
+}
+
+// SendPacket enqueues a packet on the outbound queue of its destination.
+// While that queue is full the caller blocks, or the packet is dropped or
+// spilled to disc, as the overflow policy says. A packet for a datanode
+// whose connection dropped is held until it reconnects, and an error is
+// returned if its destination is not connected and it cannot be held.
+func (nn *NameNode) SendPacket(p Packet) error {
+	nn.sendMapLock.Lock()
+	if nn.sendClosed {
+		nn.sendMapLock.Unlock()
+		nn.undeliverable(p, errShuttingDown)
+		return errShuttingDown
+	}
+	ob, ok := nn.sendMap[p.DST]
+	if !ok {
+		err := nn.hold(p)
+		nn.sendMapLock.Unlock()
+		if err != nil {
+			nn.undeliverable(p, err)
+		}
+		return err
+	}
+	nn.sending.Add(1)
+	nn.sendMapLock.Unlock()
+	defer nn.sending.Done()
+
+	if ob.spill.len() > 0 {
+		// keep the order behind the packets already spilled
+		return nn.spillPacket(ob, p)
+	}
+	select {
+	case ob.queue <- p:
+		return nil
+	default:
+	}
+
+	switch ob.policy {
+	case overflowDrop:
+		atomic.AddInt64(&ob.dropped, 1)
+		ob.log.Warn("Send queue full, dropping packet", nn.packetAttr(p))
+		return errQueueFull
+	case overflowSpill:
+		return nn.spillPacket(ob, p)
+	}
+	atomic.AddInt64(&ob.stalls, 1)
+	select {
+	case ob.queue <- p:
+		return nil
+	case <-ob.done:
+		// the packet goes to the connection in its place, or is held
+		return nn.SendPacket(p)
+	}
+}
+
+// SetOutbound registers the connection used to reach a node, retiring
+// the writer of any previous connection
+func (nn *NameNode) SetOutbound(nodeID string, conn net.Conn) {
+	ob := newOutbound(nodeID, conn, nn.sendQueueSize)
+	ob.log = nn.connLog.With("dst", nodeID)
+	ob.policy = nn.queueOverflow
+	if ob.policy == overflowSpill {
+		ob.spill = newSpillFile(nn.spillDir)
+	}
+
+	nn.sendMapLock.Lock()
+	old, ok := nn.sendMap[nodeID]
+	nn.sendMap[nodeID] = ob
+	// the held packets are no more than the queue takes
+	for _, p := range nn.held[nodeID] {
+		ob.queue <- p
+	}
+	delete(nn.held, nodeID)
+	nn.sendMapLock.Unlock()
+
+	if ok {
+		go nn.retire(old)
+	}
+	go ob.SendPackets()
+}
+
+// SendStats reports the state of every outbound queue
+func (nn *NameNode) SendStats() []SendQueueStats {
+	nn.sendMapLock.Lock()
+	defer nn.sendMapLock.Unlock()
+
+	stats := make([]SendQueueStats, 0, len(nn.sendMap))
+	for _, ob := range nn.sendMap {
+		stats = append(stats, SendQueueStats{
+			ID:       ob.ID,
+			Depth:    len(ob.queue),
+			Capacity: cap(ob.queue),
+			Sent:     atomic.LoadInt64(&ob.sent),
+			Stalls:   atomic.LoadInt64(&ob.stalls),
+			Errors:   atomic.LoadInt64(&ob.errors),
+			Dropped:  atomic.LoadInt64(&ob.dropped),
+			Spilled:  atomic.LoadInt64(&ob.spilled),
+			OnDisc:   ob.spill.len(),
+		})
+	}
+	sort.Sort(byQueueID(stats))
+	return stats
 }
 
+type byQueueID []SendQueueStats
+
+func (s byQueueID) Len() int           { return len(s) }
+func (s byQueueID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byQueueID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+
 // HandleBlockHeaders reads incoming BlockHeaders and merges them into the filesystem
-func HandleBlockHeaders() {
-	for h := range headerChannel {
-		MergeNode(h)
+func (nn *NameNode) HandleBlockHeaders() {
+	for {
+		// headers spilled to disc follow those queued before them
+		if len(nn.headerChannel) == 0 {
+			if h, ok := nn.popHeader(); ok {
+				nn.mergeQueued(h)
+				continue
+			}
+		}
+		select {
+		case h := <-nn.headerChannel:
+			nn.mergeQueued(h)
+		case <-nn.headerWake:
+		case <-nn.quit:
+			// the handlers have stopped, so merge what they queued
+			for {
+				select {
+				case h := <-nn.headerChannel:
+					nn.mergeQueued(h)
+					continue
+				default:
+				}
+				h, ok := nn.popHeader()
+				if !ok {
+					return
+				}
+				nn.mergeQueued(h)
+			}
+		}
+	}
+}
+
+// mergeQueued merges a header taken from the header queue, holding the
+// namespace
+func (nn *NameNode) mergeQueued(h BlockHeader) {
+	nn.update(func() { nn.mergeReported(h) })
+}
+
+// mergeReported merges a header reported by a datanode, unless the replica
+// is waiting to be deleted or renamed, or is only kept for snapshots
+func (nn *NameNode) mergeReported(h BlockHeader) {
+	if nn.isInvalidated(h) || nn.isRenamed(h) || strings.HasPrefix(h.Filename, snapshotStorage+"/") {
+		return
 	}
+	nn.MergeNode(h)
 }
 
 // ContainsHeader searches a BlockHeader for a given BlockHeader
@@ -153,19 +833,6 @@ func ContainsHeader(arr []BlockHeader, h BlockHeader) bool {
 	return false
 }
 
-type errorString struct {
-	s string
-}
-
-func (e *errorString) Error() string {
-	return e.s
-}
-
-// New returns an error that formats as the given text.
-func New(text string) error {
-	return &errorString{text}
-}
-
 // listFiles is a recursive helper for ListFiles
 func listFiles(node *filenode, input string) string {
 
@@ -182,374 +849,1110 @@ func listFiles(node *filenode, input string) string {
 	return input
 }
 
-func ListFiles() string {
-	return listFiles(root, "")
+func (nn *NameNode) ListFiles() string {
+	return listFiles(nn.root, "")
 
 }
 
 // Mergenode adds a BlockHeader entry to the filesystem, in its correct location
-func MergeNode(h BlockHeader) error {
+func (nn *NameNode) MergeNode(h BlockHeader) error {
 
 	if &h == nil || h.DatanodeID == "" || h.Filename == "" || h.Size < 0 || h.BlockNum < 0 || h.NumBlocks < h.BlockNum {
-		return errors.New("Invalid header input")
+		return newError(ErrInvalidHeader, "Invalid header input")
+	}
+	if fname, err := nn.cleanPath(h.Filename); err != nil || fname != h.Filename {
+		return newError(ErrInvalidHeader, "Invalid path "+h.Filename)
 	}
 
-	dn, ok := datanodemap[h.DatanodeID]
+	dn, ok := nn.datanodemap[h.DatanodeID]
 	if !ok {
 		return errors.New("BlockHeader DatanodeID: " + h.DatanodeID + " does not exist in map")
 	}
 
+	// only replicas of the newest generation of a Block are kept
+	nn.observeGenStamp(h.GenStamp)
+	nn.observeBlockID(h.BlockID)
+	if nn.isStale(h) {
+		nn.metaLog.Info("Deleting stale replica", "header", h)
+		nn.Invalidate(h)
+		return nil
+	}
+	nn.replaceStale(h)
+
+	// Blocks written past a quota are deleted again
+	replicas, exists := nn.filemap.Block(h.Filename, h.BlockNum)
+	if len(replicas) == 0 {
+		err := nn.checkQuota(h.Filename, !exists, int64(h.Size))
+		if err != nil {
+			nn.Invalidate(h)
+			return err
+		}
+	}
+
 	path := h.Filename
+	n, created := nn.attach(path)
+	if n == nil {
+		return nil
+	}
+
+	// If file already been added, we add the BlockHeader to the map
+	if !exists && !created {
+		return errors.New("Attempted to add to filenode that does not exist!")
+	}
+	if !nn.filemap.Add(h) {
+		return nil
+	}
+	dn.size += int64(h.Size)
+	nn.logEdit(edit{Added: &h})
+	if created {
+		nn.notify(eventCreate, path, "")
+	}
+	//nn.metaLog.Debug("adding Block header", "block", h.BlockNum, "file", path)
+	return nil
+}
+
+// attach finds the filenode of path, creating it and any missing parent
+// directories. created reports whether the filenode of path was created.
+func (nn *NameNode) attach(path string) (n *filenode, created bool) {
 	path_arr := strings.Split(path, "/")
-	q := root
+	q := nn.root
 
 	for i, _ := range path_arr {
 		//skip
 		if i == 0 {
 			continue
-		} else {
-
-			partial := strings.Join(path_arr[0:i+1], "/")
-			exists := false
-			for _, v := range q.children {
-				if v.path == partial {
-					q = v
-					exists = true
-					break
-				}
-			}
-			if exists {
-				// If file already been added, we add the BlockHeader to the map
-				if partial == path {
-					blks, ok := filemap[path]
-					if !ok {
-						return errors.New("Attempted to add to filenode that does not exist!")
-					}
-					_, ok = blks[h.BlockNum]
-					if !ok {
-						filemap[partial][h.BlockNum] = make([]BlockHeader, 1, 1)
-						filemap[partial][h.BlockNum][0] = h
-
-					} else {
-						if !ContainsHeader(filemap[path][h.BlockNum], h) {
-							filemap[path][h.BlockNum] = append(filemap[path][h.BlockNum], h)
-
-						}
-					}
-					dn.size += int64(h.Size)
-					//fmt.Println("adding Block header # ", h.BlockNum, "to filemap at ", path)
-				}
-
-				//else it is a directory
-				continue
-			} else {
-
-				//  if we are at file, create the map entry
-				n := &filenode{partial, q, make([]*filenode, 1)}
-				if partial == path {
-					filemap[partial] = make(map[int][]BlockHeader)
-					filemap[partial][h.BlockNum] = make([]BlockHeader, 1, 1)
-					filemap[partial][h.BlockNum][0] = h
-					dn.size += int64(h.Size)
-					//fmt.fmt("creating Block header # ", h.BlockNum, "to filemap at ", path)
-				}
+		}
 
-				n.parent = q
-				q.children = append(q.children, n)
-				q = n
+		partial := strings.Join(path_arr[0:i+1], "/")
+		exists := false
+		for _, v := range q.children {
+			if v.path == partial {
+				q = v
+				exists = true
+				break
 			}
 		}
+		if !exists && q.target != "" {
+			// Blocks are not stored below symbolic links
+			return nil, false
+		}
+		if !exists {
+			c := newFilenode(partial, q)
+			q.children = append(q.children, c)
+			q = c
+			created = partial == path
+		}
+		n = q
 	}
-	return nil
+	return n, created
 }
 
 // AssignBlocks creates packets based on BlockHeader metadata and enqueues them for transmission
-func AssignBlocks(bls []Block) {
+func (nn *NameNode) AssignBlocks(bls []Block) {
 	for _, b := range bls {
-		AssignBlock(b)
+		nn.AssignBlock(b)
 	}
 }
 
 // AssignBlocks chooses a datanode which balances the load across nodes for a block and enqueues
 // the block for distribution
-func AssignBlock(b Block) (Packet, error) {
+func (nn *NameNode) AssignBlock(b Block) (Packet, error) {
 	p := new(Packet)
 
-	if &b == nil || &b.Header == nil || &b.Data == nil || b.Header.Filename == "" ||
-		b.Header.Size <= 0 || b.Header.BlockNum < 0 || b.Header.NumBlocks <= b.Header.BlockNum {
-		return *p, errors.New("Invalid Block input")
+	if b.Header.Filename == "" || b.Header.Size <= 0 || b.Header.BlockNum < 0 || b.Header.NumBlocks <= b.Header.BlockNum {
+		return *p, newError(ErrInvalidHeader, "Invalid Block input")
+	}
+	if int64(b.Header.Size) > maxBlockSize || int64(len(b.Data)) > maxBlockSize {
+		return *p, errors.New("Block of " + strconv.Itoa(b.Header.Size) + " bytes is longer than " + strconv.FormatInt(maxBlockSize, 10) + " bytes")
+	}
+
+	if isSnapshotPath(b.Header.Filename) || strings.HasPrefix(b.Header.Filename, snapshotStorage+"/") {
+		return *p, errors.New("Cannot write to a snapshot " + b.Header.Filename)
+	}
+
+	// the first Block of a new file reserves whole Blocks for the file
+	if _, exists := nn.filemap.Get(b.Header.Filename); !exists && b.Header.BlockNum == 0 {
+		size := int64(b.Header.Size)
+		if b.Header.NumBlocks > 1 {
+			size = int64(b.Header.NumBlocks) * int64(nn.blockSize(b.Header.Filename))
+		}
+		err := nn.checkQuota(b.Header.Filename, true, size)
+		if err != nil {
+			return *p, err
+		}
+	}
+
+	// datanodes restored from metadata may not have reconnected yet
+	hashed := nn.placement == placementHash
+	nodeIDs := make([]string, 0, len(nn.datanodemap))
+	local := make([]string, 0, len(nn.datanodemap))
+	writerRack := nn.hostRack(nn.clientHost)
+	for _, v := range nn.datanodemap {
+		if !nn.offline[v.ID] && !v.decommissioning && v.hasRoom(int64(b.Header.Size)) {
+			nodeIDs = append(nodeIDs, v.ID)
+			if !hashed && nn.datanodeRack(v) == writerRack {
+				local = append(local, v.ID)
+			}
+		}
+	}
+	// the first replica is placed on the writer's rack when possible, and
+	// the Blocks of an erasure coded stripe on different datanodes
+	if spread := nn.spreadStripe(b.Header, local); len(spread) > 0 {
+		nodeIDs = spread
+	} else if spread := nn.spreadStripe(b.Header, nodeIDs); len(spread) > 0 {
+		nodeIDs = spread
 	}
 
-	if len(datanodemap) < 1 {
-		return *p, errors.New("Cannot distribute Block, no datanodes are connected")
+	if len(nodeIDs) < 1 {
+		return *p, newError(ErrNoDatanodes, "Cannot distribute Block, no datanodes are connected")
+	}
+	if !hashed {
+		nodeIDs = nn.avoidSlow(nodeIDs)
 	}
 
+	// the first replica goes to the storage the file's policy asks for
+	storage := nn.newReplicaStorage(b.Header.Filename, nil)
+	nodeIDs = nn.withStorage(nodeIDs, storage)
+
 	// Create Packet and send block
-	p.SRC = id
+	p.SRC = nn.id
 	p.CMD = BLOCK
 
-	//Random load balancing
-	nodeIDs := make([]string, len(datanodemap), len(datanodemap))
-	i := 0
-	for _, v := range datanodemap {
-		nodeIDs[i] = v.ID
-		i++
+	// the ID is given first, as hash placement follows it
+	b.Header.BlockID = nn.nextBlockID()
+	if hashed {
+		// the first datanode following the Block on the ring
+		p.DST = nn.ringTarget(b.Header, nodeIDs)
+	} else {
+		//Random load balancing
+		rand.Seed(time.Now().UTC().UnixNano())
+		nodeindex := rand.Intn(len(nodeIDs))
+		p.DST = nodeIDs[nodeindex]
 	}
-	rand.Seed(time.Now().UTC().UnixNano())
-	nodeindex := rand.Intn(len(nodeIDs))
-	p.DST = nodeIDs[nodeindex]
 	b.Header.DatanodeID = p.DST
 
+	b.Header.GenStamp = nn.nextGenStamp()
 	p.Data = Block{b.Header, b.Data}
+	p.Storage = storage
 
 	return *p, nil
 
 }
 
-// SendPackets encodes packets and transmits them to their proper recipients
-func SendPackets() {
-	for p := range sendChannel {
-
-		sendMapLock.Lock()
-		encoder, ok := sendMap[p.DST]
+// SendPackets encodes the packets queued for a connection and transmits them
+// until the connection is replaced or dropped, or its queue is closed. A
+// packet which fails to encode leaves the stream in an unknown state, so the
+// connection is closed, ending its reader, and the packet kept as unsent.
+func (ob *outbound) SendPackets() {
+	defer close(ob.stopped)
+	for {
+		p, ok := ob.next()
 		if !ok {
-			fmt.Println("Could not find encoder for ", p.DST)
-			continue
+			return
 		}
-		err := encoder.Encode(p)
+		err := ob.encoder.Encode(p)
 		if err != nil {
-			fmt.Println("Error sending", p.DST)
+			atomic.AddInt64(&ob.errors, 1)
+			ob.log.Warn("Error sending packet, closing connection", "err", err)
+			ob.unsent = []Packet{p}
+			ob.conn.Close()
+			return
 		}
-		sendMapLock.Unlock()
+		atomic.AddInt64(&ob.sent, 1)
 	}
 }
 
 // WriteJSON writes a JSON encoded interface to disc
-func WriteJSON(fileName string, key interface{}) {
+func WriteJSON(fileName string, key interface{}) error {
 	outFile, err := os.Create(fileName)
 	if err != nil {
-		fmt.Println("Error opening JSON ", err)
-		return
+		return err
 	}
 	defer outFile.Close()
 	encoder := json.NewEncoder(outFile)
-	err = encoder.Encode(key)
+	return encoder.Encode(key)
+}
+
+// ReadJSON reads a JSON encoded interface from disc
+func ReadJSON(fileName string, key interface{}) error {
+	inFile, err := os.Open(fileName)
 	if err != nil {
-		fmt.Println("Error encoding JSON ", err)
-		return
+		return err
 	}
+	defer inFile.Close()
+	return json.NewDecoder(inFile).Decode(key)
 }
 
-// Handle handles a packet and performs the proper action based on its contents
-
-func HandlePacket(p Packet) {
-
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Recovered from panic ", r)
-			fmt.Println("Unable to handle packet ", p)
-			return
-		}
-	}()
+// metadataImage is the on disc representation of the namespace
+type metadataImage struct {
+	Datanodes     []string      // IDs of known datanodes
+	Headers       []BlockHeader // every stored replica not in Placed
+	Placed        []placedBlock // Blocks whose replicas are where hash placement puts them
+	Invalidations []BlockHeader // replicas awaiting deletion
+	Directories   []string      // directories created by MKDIR
+	Quotas        []FileStatus  // directories with quotas
+	Renames       []renameOrder // replicas awaiting a rename
+	Trash         []trashEntry  // paths in the trash
+	Snapshots     []*snapshot   // snapshots of directories
+	Archives      []*archive    // indexes of the files packed into archives
+	Cached        []string      // paths whose Blocks are kept in datanode memory
+	Erasure       []FileStatus  // policies and sizes of erasure coded files
+	Zones         []FileStatus  // encryption zones and their keys
+	Encrypted     []FileStatus  // wrapped data keys of encrypted files
+	Layouts       []FileStatus  // block sizes and replication factors of files
+	Times         []FileStatus  // modification and access times of files and directories
+	XAttrs        []FileStatus  // extended attributes of files and directories
+	Policies      []FileStatus  // storage policies set on files and directories
+	Symlinks      []FileStatus  // symbolic links and their targets
+
+	Decommissioning []string // datanodes being drained
+	Decommissioned  []string // datanodes removed from the cluster
+	GenStamp        int64    // the newest generation stamp given out
+	BlockID         int64    // the newest Block ID given out
+	EditSeq         int64    // number of the last edit the image includes
+}
 
-	if p.SRC == "" {
-		fmt.Println("Could not identify packet")
+// SaveMetadata writes the namespace to the configured metadata file
+func (nn *NameNode) SaveMetadata() {
+	if nn.metadatafile == "" {
 		return
 	}
 
-	r := Packet{id, p.SRC, ACK, "", *new(Block), make([]BlockHeader, 0)}
-
-	if p.SRC == "C" {
-
-		switch p.CMD {
-		case HB:
-			fmt.Println("Received client connection", p.SRC)
-			return
-		case LIST:
-			fmt.Println("Received List Request")
-			r.Message = ListFiles()
-			r.CMD = LIST
-			fmt.Println(r)
+	img := nn.image()
+	err := WriteJSON(nn.metadatafile, img)
+	if err != nil {
+		nn.metaLog.Error("Could not save metadata", "file", nn.metadatafile, "err", err)
+		return
+	}
+	nn.imageSeq = img.EditSeq
+	nn.metaLog.Info("Saved metadata", "file", nn.metadatafile)
+}
 
-		case DISTRIBUTE:
-			b := p.Data
-			fmt.Println("Distributing Block ", b.Header.Filename, "/", b.Header.BlockNum, " to ", b.Header.DatanodeID)
-			p, err := AssignBlock(b)
-			if err != nil {
-				r.CMD = ERROR
-				r.Message = err.Error()
+// image captures the namespace in its on disc representation
+func (nn *NameNode) image() metadataImage {
+	var img metadataImage
+	for id, dn := range nn.datanodemap {
+		img.Datanodes = append(img.Datanodes, id)
+		if dn.decommissioning {
+			img.Decommissioning = append(img.Decommissioning, id)
+		}
+	}
+	for id := range nn.decommissioned {
+		img.Decommissioned = append(img.Decommissioned, id)
+	}
+	img.GenStamp = atomic.LoadInt64(&nn.genStamp)
+	img.BlockID = atomic.LoadInt64(&nn.blockID)
+	img.EditSeq = nn.editSeq
+	// a metadata store keeps the Blocks of files itself
+	if nn.metadatastore == "" {
+		var ring *hashRing
+		if nn.placement == placementHash {
+			ring = newHashRing(img.Datanodes)
+		}
+		nn.filemap.Range(func(path string, blocks map[int][]BlockHeader) bool {
+			for _, headers := range blocks {
+				if ring != nil {
+					if b, ok := placeOnRing(ring, headers); ok {
+						img.Placed = append(img.Placed, b)
+						continue
+					}
+				}
+				img.Headers = append(img.Headers, headers...)
 			}
-			sendChannel <- p
+			return true
+		})
+	}
+	nn.walk(nn.root, func(n *filenode) {
+		if n.explicit && n != nn.root {
+			img.Directories = append(img.Directories, n.path)
+		}
+		if n.target != "" {
+			img.Symlinks = append(img.Symlinks, FileStatus{Path: n.path, Symlink: n.target})
+		}
+		if n.fileQuota > 0 || n.spaceQuota > 0 {
+			img.Quotas = append(img.Quotas, FileStatus{Path: n.path, IsDir: true, FileQuota: n.fileQuota, SpaceQuota: n.spaceQuota})
+		}
+		if n.zoneKey != "" {
+			img.Zones = append(img.Zones, FileStatus{Path: n.path, IsDir: true, Zone: n.zoneKey})
+		}
+		img.Times = append(img.Times, FileStatus{Path: n.path, ModTime: n.mtime, AccessTime: atomic.LoadInt64(&n.atime)})
+		if len(n.xattrs) > 0 {
+			img.XAttrs = append(img.XAttrs, FileStatus{Path: n.path, XAttrs: n.xattrs})
+		}
+		if n.policy != "" {
+			img.Policies = append(img.Policies, FileStatus{Path: n.path, Storage: n.policy})
+		}
+	})
 
-			r.CMD = ACK
-		case RETRIEVEBLOCK:
-			r.CMD = RETRIEVEBLOCK
-			if p.Headers == nil || len(p.Headers) != 1 {
-				r.CMD = ERROR
-				r.Message = "Invalid Header received"
-				fmt.Println("Invalid RETRIEVEBLOCK Packet , ", p)
-				break
-			}
+	nn.invalidateLock.Lock()
+	for _, headers := range nn.invalidations {
+		img.Invalidations = append(img.Invalidations, headers...)
+	}
+	nn.invalidateLock.Unlock()
 
-			r.DST = p.Headers[0].DatanodeID // Block to retrieve is specified by given header
-			fmt.Println("Retrieving Block for client ", p.SRC, "from node ", r.DST)
+	nn.renameLock.Lock()
+	for _, list := range nn.renames {
+		img.Renames = append(img.Renames, list...)
+	}
+	nn.renameLock.Unlock()
 
-			r.Headers = p.Headers
-			// specify client that is requesting a block when it arrives
-			clientMapLock.Lock()
-			clientMap[p.Headers[0]] = p.SRC
-			clientMapLock.Unlock()
+	for path, deleted := range nn.trash {
+		img.Trash = append(img.Trash, trashEntry{path, deleted})
+	}
+	for _, snaps := range nn.snapshots {
+		for _, s := range snaps {
+			img.Snapshots = append(img.Snapshots, s)
+		}
+	}
+	for _, a := range nn.archives {
+		img.Archives = append(img.Archives, a)
+	}
+	for path := range nn.cacheDirectives {
+		img.Cached = append(img.Cached, path)
+	}
+	for _, st := range nn.erasure {
+		img.Erasure = append(img.Erasure, st)
+	}
+	for _, st := range nn.encrypted {
+		img.Encrypted = append(img.Encrypted, st)
+	}
+	for _, st := range nn.layouts {
+		img.Layouts = append(img.Layouts, st)
+	}
+	return img
+}
 
-		case GETHEADERS:
-			r.CMD = GETHEADERS
-			if p.Headers == nil || len(p.Headers) != 1 {
-				r.CMD = ERROR
-				r.Message = "Invalid Header received"
-				fmt.Println("Received invalid Header Packet, ", p)
-				break
-			}
+// LoadMetadata restores a namespace saved by SaveMetadata and replays the
+// edits logged since, so a restarted namenode can serve files before its
+// datanodes report their blocks
+func (nn *NameNode) LoadMetadata() error {
+	err := nn.openMetadataStore()
+	if err != nil {
+		return err
+	}
+	err = nn.loadImage()
+	if err != nil {
+		return err
+	}
+	return nn.loadEditLog()
+}
 
-			fmt.Println("Retrieving headers for client using ", p.Headers[0])
+// loadImage restores the namespace saved in the metadata file
+func (nn *NameNode) loadImage() error {
+	if nn.metadatafile == "" {
+		return nil
+	}
 
-			fname := p.Headers[0].Filename
-			blockMap, ok := filemap[fname]
-			if !ok {
-				r.CMD = ERROR
-				r.Message = "File not found " + fname
-				fmt.Println("Requested file in filesystem not found, ", fname)
-				break
-			}
+	var img metadataImage
+	err := ReadJSON(nn.metadatafile, &img)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = nn.restoreImage(img)
+	if err != nil {
+		return err
+	}
+	nn.imageSeq = img.EditSeq
+	nn.metaLog.Info("Loaded metadata", "file", nn.metadatafile, "headers", len(img.Headers), "placed", len(img.Placed))
+	return nil
+}
 
-			_, ok = blockMap[0]
-			if !ok {
-				r.CMD = ERROR
-				r.Message = "Could not locate first block in file"
-				break
+// restoreImage rebuilds the namespace captured in img
+func (nn *NameNode) restoreImage(img metadataImage) error {
+	var err error
+	for _, id := range img.Datanodes {
+		if _, ok := nn.datanodemap[id]; !ok {
+			nn.datanodemap[id] = &datanode{ID: id}
+			nn.offline[id] = true
+		}
+	}
+	for _, id := range img.Decommissioning {
+		nn.datanodemap[id].decommissioning = true
+	}
+	for _, id := range img.Decommissioned {
+		nn.decommissioned[id] = true
+	}
+	nn.observeGenStamp(img.GenStamp)
+	nn.observeBlockID(img.BlockID)
+	nn.editSeq = img.EditSeq
+	for _, dir := range img.Directories {
+		err = nn.Mkdir(dir, true)
+		if err != nil {
+			return err
+		}
+	}
+	for _, st := range img.Symlinks {
+		err = nn.CreateSymlink(st.Path, st.Symlink, true)
+		if err != nil {
+			return err
+		}
+	}
+	for _, h := range img.Headers {
+		err = nn.MergeNode(h)
+		if err != nil {
+			return err
+		}
+	}
+	if len(img.Placed) > 0 {
+		ring := newHashRing(img.Datanodes)
+		for _, b := range img.Placed {
+			replicas, err := unplace(ring, b)
+			if err != nil {
+				return err
 			}
-			numBlocks := blockMap[0][0].NumBlocks
-
-			headers := make([]BlockHeader, numBlocks, numBlocks)
-			for i, _ := range headers {
-
-				_, ok = blockMap[i]
-				if !ok {
-					r.CMD = ERROR
-					r.Message = "Could not find needed block in file "
-					break
+			for _, h := range replicas {
+				err = nn.MergeNode(h)
+				if err != nil {
+					return err
 				}
-				headers[i] = blockMap[i][0] // grab the first available BlockHeader for each block number
 			}
-			r.Headers = headers
-			fmt.Println("Retrieved headers ")
 		}
-
-	} else {
-		dn := datanodemap[p.SRC]
-		listed := dn.listed
-
-		switch p.CMD {
+	}
+	for _, h := range img.Invalidations {
+		nn.Invalidate(h)
+	}
+	for _, o := range img.Renames {
+		nn.renames[o.From.DatanodeID] = append(nn.renames[o.From.DatanodeID], o)
+	}
+	for _, e := range img.Trash {
+		nn.trash[e.Path] = e.Deleted
+	}
+	for _, s := range img.Snapshots {
+		if nn.snapshots[s.Root] == nil {
+			nn.snapshots[s.Root] = make(map[string]*snapshot)
+		}
+		nn.snapshots[s.Root][s.Name] = s
+	}
+	for _, a := range img.Archives {
+		nn.archives[a.Path] = a
+	}
+	for _, path := range img.Cached {
+		nn.cacheDirectives[path] = true
+	}
+	for _, st := range img.Erasure {
+		nn.erasure[st.Path] = st
+	}
+	for _, z := range img.Zones {
+		err = nn.Mkdir(z.Path, true)
+		if err != nil {
+			return err
+		}
+		nn.lookup(z.Path).zoneKey = z.Zone
+	}
+	for _, st := range img.Encrypted {
+		nn.encrypted[st.Path] = st
+	}
+	for _, st := range img.Layouts {
+		nn.layouts[st.Path] = st
+		nn.queueReplication(st.Path)
+	}
+	// quotas are restored last, so files stored before a quota was lowered are kept
+	for _, q := range img.Quotas {
+		err = nn.SetQuota(q.Path, q.FileQuota, q.SpaceQuota)
+		if err != nil {
+			return err
+		}
+	}
+	// times and attributes are restored once every filenode is rebuilt
+	for _, st := range img.Times {
+		if n := nn.lookup(st.Path); n != nil {
+			n.mtime, n.atime = st.ModTime, st.AccessTime
+		}
+	}
+	for _, st := range img.XAttrs {
+		if n := nn.lookup(st.Path); n != nil {
+			n.xattrs = st.XAttrs
+		}
+	}
+	for _, st := range img.Policies {
+		if n := nn.lookup(st.Path); n != nil {
+			n.policy = st.Storage
+		}
+	}
+	return nil
+}
+
+// Handle handles a packet and performs the proper action based on its contents
+
+func (nn *NameNode) HandlePacket(p Packet) {
+
+	if p.SRC == "" {
+		nn.connLog.Warn("Could not identify packet", nn.packetAttr(p))
+		return
+	}
+	nn.metrics.countPacket(p.CMD)
+	if p.CMD == HELLO {
+		// answered by HandleConnection as the first packet of a connection
+		return
+	}
+
+	r := Packet{SRC: nn.id, DST: p.SRC, CMD: ACK, Headers: make([]BlockHeader, 0), RequestID: p.RequestID}
+
+	// a retried request which was done is answered as it was the first time
+	if p.SRC == "C" && p.Key != "" {
+		if cached, ok := nn.retryCache.get(p.Key); ok {
+			nn.connLog.Info("Answering retried request from the retry cache", "cmd", CommandName(p.CMD), "key", p.Key)
+			cached.RequestID = p.RequestID
+			nn.SendPacket(cached)
+			return
+		}
+	}
+
+	// client paths are used in their canonical form, and invalid ones refused,
+	// with the symbolic links along them resolved
+	var pathErr error
+	if p.SRC == "C" {
+		pathErr = nn.cleanPaths(&p)
+		if pathErr == nil {
+			pathErr = nn.resolvePaths(&p)
+		}
+	}
+	// users of a tenant are kept to its namespace and its rate limit
+	var tenantErr error
+	if p.SRC == "C" && pathErr == nil && !nn.standby {
+		tenantErr = nn.checkTenant(p)
+	}
+
+	if p.SRC == "C" && nn.standby && p.CMD != HASTATE {
+		if p.CMD == HB {
+			return
+		}
+		fail(&r, ErrStandby)
+	} else if pathErr != nil {
+		fail(&r, pathErr)
+	} else if tenantErr != nil {
+		fail(&r, tenantErr)
+	} else if p.SRC == "C" && nn.safeMode && changesNamespace(p.CMD) {
+		fail(&r, ErrSafeMode)
+	} else if p.SRC == "C" {
+
+		switch p.CMD {
+		case HB:
+			nn.connLog.Debug("Received client heartbeat", "src", p.SRC)
+			nn.RenewLeases(p.Message)
+			return
+		case LIST:
+			nn.metaLog.Debug("Received List Request", "src", p.SRC)
+			r.Message = nn.ListFiles()
+			r.CMD = LIST
+
+		case DISTRIBUTE:
+			// the writer must hold the lease on the file, named in Message
+			b := p.Data
+			err := nn.checkLease(b.Header.Filename, p.Message)
+			var bp Packet
+			if err == nil {
+				err = nn.checkEncrypted(b.Header.Filename)
+			}
+			if err == nil {
+				bp, err = nn.AssignBlock(b)
+			}
+			if err == nil {
+				err = nn.checkWrite(b.Header)
+			}
+			if err != nil {
+				nn.placementLog.Warn("Could not distribute Block", "file", b.Header.Filename, "block", b.Header.BlockNum, "err", err)
+				fail(&r, err)
+				break
+			}
+			nn.placementLog.Debug("Distributing Block", "file", b.Header.Filename, "block", b.Header.BlockNum, "datanode", bp.DST)
+			// a Block being flushed is synced by its datanode before the BLOCKACK
+			bp.Flags = p.Flags & FSYNC
+			err = nn.SendPacket(bp)
+			if err != nil {
+				nn.placementLog.Warn("Could not send Block", "file", b.Header.Filename, "block", b.Header.BlockNum, "datanode", bp.DST, "err", err)
+				fail(&r, err)
+				break
+			}
+			nn.metrics.startDistribution(bp.Data.Header)
+			nn.modified(b.Header.Filename)
+
+			r.CMD = ACK
+		case RETRIEVEBLOCK:
+			r.CMD = RETRIEVEBLOCK
+			if p.Headers == nil || len(p.Headers) != 1 {
+				fail(&r, ErrInvalidHeader)
+				nn.connLog.Warn("Invalid RETRIEVEBLOCK Packet", nn.packetAttr(p))
+				break
+			}
+
+			r.DST = p.Headers[0].DatanodeID // Block to retrieve is specified by given header
+			r.Headers = p.Headers
+			if p.Message != "" {
+				// a hedged read asks for a replica on a datanode other than the one named
+				h, ok := nn.otherReplica(p.Headers[0], p.Message)
+				if !ok {
+					r.DST = p.SRC
+					fail(&r, newError(ErrNoDatanodes, "No other replica of Block "+strconv.Itoa(p.Headers[0].BlockNum)+" of "+p.Headers[0].Filename))
+					break
+				}
+				r.DST = h.DatanodeID
+				r.Headers = []BlockHeader{h}
+			}
+			if _, ok := nn.datanodemap[r.DST]; !ok {
+				r.DST = p.SRC
+				fail(&r, newError(ErrNoDatanodes, "Unknown datanode "+r.Headers[0].DatanodeID+" holding Block "+strconv.Itoa(r.Headers[0].BlockNum)))
+				break
+			}
+			nn.connLog.Debug("Retrieving Block for client", "src", p.SRC, "datanode", r.DST)
+
+			// specify client that is requesting a block when it arrives
+			nn.clientMapLock.Lock()
+			nn.clientMap[r.Headers[0].BlockID] = p.SRC
+			nn.clientMapLock.Unlock()
+
+		case CHECKSUM:
+			// the datanode holding the Block named computes its checksum
+			if p.Headers == nil || len(p.Headers) != 1 {
+				fail(&r, ErrInvalidHeader)
+				nn.connLog.Warn("Invalid CHECKSUM Packet", nn.packetAttr(p))
+				break
+			}
+			if p.Message != "" {
+				// a client whose replicas failed is answered with the next to
+				// try, the datanodes it tried being listed in Message
+				h, ok := nn.untriedReplica(p.Headers[0], strings.Split(p.Message, ","))
+				if !ok {
+					fail(&r, newError(ErrNoDatanodes, "No other replica of Block "+strconv.Itoa(p.Headers[0].BlockNum)+" of "+p.Headers[0].Filename))
+					break
+				}
+				r.CMD = CHECKSUM
+				r.Headers = []BlockHeader{h}
+				break
+			}
+			if _, ok := nn.datanodemap[p.Headers[0].DatanodeID]; !ok {
+				fail(&r, newError(ErrNoDatanodes, "Unknown datanode "+p.Headers[0].DatanodeID+" holding Block "+strconv.Itoa(p.Headers[0].BlockNum)))
+				break
+			}
+			nn.connLog.Debug("Asking for Block checksum for client", "src", p.SRC, "datanode", p.Headers[0].DatanodeID)
+			r.CMD = CHECKSUM
+			r.DST = p.Headers[0].DatanodeID
+			r.Headers = p.Headers
+
+		case GETHEADERS:
+			r.CMD = GETHEADERS
+			if p.Headers == nil || len(p.Headers) != 1 {
+				fail(&r, ErrInvalidHeader)
+				nn.connLog.Warn("Received invalid Header Packet", nn.packetAttr(p))
+				break
+			}
+
+			nn.metaLog.Debug("Retrieving headers", "file", p.Headers[0].Filename)
+
+			fname := p.Headers[0].Filename
+			// packed files are read through their archive, from the offset given
+			if a, rel, ok := nn.splitArchivePath(fname); ok && rel != "" {
+				st, err := nn.archiveStat(a, rel)
+				if err != nil || st.IsDir {
+					fail(&r, newError(ErrFileNotFound, "File not found "+fname))
+					break
+				}
+				r.Status = []FileStatus{st}
+				nn.accessed(a.Path)
+				break
+			}
+			blockMap, ok := nn.blocksFor(fname)
+			if !ok {
+				fail(&r, newError(ErrFileNotFound, "File not found "+fname))
+				nn.metaLog.Info("Requested file in filesystem not found", "file", fname)
+				break
+			}
+
+			// erasure coded files are rebuilt by the reader from the Blocks left
+			if ec, ok := nn.erasureOf(fname); ok {
+				st := nn.fileBlocksStatus(fname, blockMap)
+				if st.Replication == 0 {
+					fail(&r, newError(ErrBlocksLost, "Too many Blocks lost to rebuild "+fname))
+					break
+				}
+				r.Headers = nn.erasureHeaders(blockMap, st.NumBlocks)
+				r.Status = []FileStatus{ec}
+				if k, ok := nn.keyOf(fname); ok {
+					r.Status[0].Zone, r.Status[0].Key = k.Zone, k.Key
+				}
+				break
+			}
+
+			// a file still being written is read as far as its writer flushed it
+			if visible, ok := nn.readableLength(fname, blockMap); ok {
+				r.Headers = nn.visibleHeaders(blockMap, visible)
+				st := FileStatus{Path: fname, NumBlocks: len(r.Headers), Writing: true}
+				for _, h := range r.Headers {
+					st.Size += int64(h.Size)
+				}
+				if k, ok := nn.keyOf(fname); ok {
+					st.Zone, st.Key = k.Zone, k.Key
+				}
+				r.Status = []FileStatus{st}
+				nn.accessed(fname)
+				break
+			}
+
+			if len(blockMap[0]) == 0 {
+				fail(&r, newError(ErrBlocksLost, "Could not locate first block in file"))
+				break
+			}
+			numBlocks := blockMap[0][0].NumBlocks
+
+			// a page of Limit headers from block Offset, or all of them
+			first, last := p.Offset, numBlocks
+			if p.Limit > 0 && first+p.Limit < last {
+				last = first + p.Limit
+			}
+			if first < 0 || first >= numBlocks {
+				fail(&r, newError(ErrInvalidHeader, "Offset "+strconv.Itoa(first)+" is not a Block of "+fname))
+				break
+			}
+			headers := make([]BlockHeader, last-first)
+			for i := range headers {
+
+				if len(blockMap[first+i]) == 0 {
+					fail(&r, newError(ErrBlocksLost, "Could not find needed block in file "))
+					break
+				}
+				headers[i] = nn.sortByDistance(nn.clientHost, blockMap[first+i])[0] // the closest replica of each block number
+			}
+			r.Headers = headers
+			r.Offset = first
+			nn.accessed(fname)
+			// readers of an encrypted file need its data key
+			if k, ok := nn.keyOf(fname); ok {
+				r.Status = []FileStatus{k}
+			}
+
+		case BALANCE, DECOMMISSION, REPORT, SAFEMODE, REFRESHNODES, SETBLOCKSIZE, LISTLEASES, TRIGGERREPORT, HASTATE, SHUTDOWN, SETBANDWIDTH,
+			TENANTS, GETREPORT, RECOVERLEASE:
+			nn.handleAdmin(p, &r)
+
+		case DELETE, STAT, LISTDIR, MKDIR, SETQUOTA, GETQUOTA, RENAME, CREATESNAPSHOT, DELETESNAPSHOT, LISTSNAPSHOT, LEASE, RELEASE, ERASURECODE,
+			CREATEZONE, LISTZONES, FILEKEY, SETREP, TRUNCATE, GLOB, SETTIMES,
+			SETXATTR, GETXATTR, LISTXATTRS, REMOVEXATTR, CREATESYMLINK, READLINK, SUBSCRIBE, UNSUBSCRIBE, ARCHIVE,
+			CACHE, UNCACHE, LISTCACHE, STORAGEPOLICY, SNAPSHOTDIFF, GETBLOCKLOCATIONS, FLUSH, RESUME:
+			if p.Headers == nil || len(p.Headers) != 1 {
+				fail(&r, ErrInvalidHeader)
+				nn.connLog.Warn("Received invalid namespace Packet", nn.packetAttr(p))
+				break
+			}
+			nn.handleNamespace(p, &r)
+
+		case BATCH:
+			nn.handleBatch(p, &r)
+		}
+
+	} else {
+		dn, ok := nn.datanodemap[p.SRC]
+		if !ok {
+			nn.connLog.Warn("Packet from unknown datanode", nn.packetAttr(p))
+			return
+		}
+		listed := dn.listed
+
+		switch p.CMD {
 		case HB:
 
-			fmt.Println("Received Heartbeat from ", p.SRC)
-			if !listed {
+			nn.connLog.Debug("Received Heartbeat", "src", p.SRC)
+			dn.lastHeartbeat = time.Now()
+			dn.httpAddr = p.Address
+			nn.updateCapacity(dn, p)
+			nn.updateCache(dn, p)
+			dn.storages = p.Storages
+			if dn.decommissioning && listed {
+				nn.checkDecommission(dn)
+			}
+			// a datanode whose reports we have not seen must send a full one
+			if dn.supports("commands") {
+				r.CMD = ACK
+				r.Commands = nn.heartbeatCommands(dn, listed, p.ReportID)
+			} else if !listed || p.ReportID != dn.lastReport || dn.reportRequested {
 				r.CMD = LIST
+				dn.reportRequested = false
+			} else if len(nn.PendingRenames(p.SRC)) > 0 {
+				// renames go first, as invalidations may refer to the new names
+				r = nn.renamePacket(p.SRC)
+			} else if pending := nn.PendingInvalidations(p.SRC); len(pending) > 0 {
+				r.CMD = INVALIDATE
+				r.Headers = pending
 			} else {
 				r.CMD = ACK
 			}
 
+		case REGISTER:
+			r.CMD = REGISTER
+			r.Message = dn.ID
+
 		case LIST:
 
-			fmt.Println("Received BlockHeaders from ", p.SRC)
-			list := p.Headers
-			for _, h := range list {
-				headerChannel <- h
-			}
-			dn.listed = true
+			nn.metaLog.Info("Received full block report", "datanode", p.SRC, "headers", len(p.Headers))
+			nn.recordStorage(dn, p, true)
+			nn.ApplyFullReport(dn, p.ReportID, p.Headers)
 			r.CMD = ACK
+			r.ReportID = p.ReportID
+
+		case BLOCKREPORT:
+			nn.metaLog.Debug("Received block report", "datanode", p.SRC, "report", p.ReportID)
+			nn.recordStorage(dn, p, false)
+			if nn.ApplyBlockReport(dn, p.ReportID, p.Headers, p.Removed) {
+				r.CMD = ACK
+				r.ReportID = p.ReportID
+			} else {
+				r.CMD = LIST
+			}
 
 		case BLOCKACK:
 			// receive acknowledgement for single Block header as being stored
+			r.CMD = ACK
 			if p.Headers != nil && len(p.Headers) == 1 {
-				headerChannel <- p.Headers[0]
+				if d, ok := nn.metrics.finishDistribution(p.Headers[0]); ok {
+					nn.observeLatency(dn, d)
+				}
+				if nn.isStale(p.Headers[0]) || nn.isStaleWrite(p.Headers[0]) {
+					nn.metaLog.Info("Rejecting BLOCKACK of an outdated generation", "header", p.Headers[0])
+					nn.Invalidate(p.Headers[0])
+					fail(&r, newError(ErrStaleGeneration, "Outdated generation of Block "+p.Headers[0].Filename))
+					break
+				}
+				nn.enqueueHeader(p.Headers[0])
+				nn.completeMove(p.Headers[0])
+				if p.Flags&FSYNC != 0 {
+					nn.CompleteSync(p.SRC, p.Headers)
+				}
+				// the writer may have released the lease before the Block was stored
+				if nn.leaseHolder(p.Headers[0].Filename) == "" {
+					nn.queueReplication(p.Headers[0].Filename)
+				}
 			}
+			nn.metaLog.Debug("Received BLOCKACK", "datanode", p.SRC)
+
+		case INVALIDATEACK:
+			nn.metaLog.Debug("Received INVALIDATEACK", "datanode", p.SRC, "headers", len(p.Headers))
+			nn.CompleteInvalidation(p.SRC, p.Headers)
+			r.CMD = ACK
+
+		case RENAMEACK:
+			nn.metaLog.Debug("Received RENAMEACK", "datanode", p.SRC, "headers", len(p.Headers))
+			nn.CompleteRename(p.SRC, p.Headers)
+			r.CMD = ACK
+
+		case SYNCACK:
+			nn.metaLog.Debug("Received SYNCACK", "datanode", p.SRC, "headers", len(p.Headers))
+			nn.CompleteSync(p.SRC, p.Headers)
+			r.CMD = ACK
+
+		case CORRUPTBLOCK:
+			nn.ReportCorrupt(dn, p.Headers, p.Message)
+			r.CMD = ACK
+
+		case REPLICATEACK:
 			r.CMD = ACK
-			fmt.Println("Received BLOCKACK from ", p.SRC)
+			if len(p.Headers) == 1 {
+				nn.completeTransfer(p.Headers[0], p.Message)
+			}
+
+		case CHECKSUMACK:
+			nn.connLog.Debug("Received Block checksum", "datanode", p.SRC, "headers", len(p.Headers))
+			r.DST = "C"
+			r.CMD = CHECKSUMACK
+			r.Headers = p.Headers
+			r.Message = p.Message
+			// a datanode which could not read the Block names none
+			if len(p.Headers) == 0 {
+				fail(&r, errors.New("Datanode "+p.SRC+" could not checksum Block: "+p.Message))
+			}
 
 		case BLOCK:
-			fmt.Println("Received Block Packet with header", p.Data.Header)
+			nn.connLog.Debug("Received Block Packet", "header", p.Data.Header)
 
 			// TODO map multiple clients
-			//clientMapLock.Lock()
-			//cID,ok := clientMap[p.Data.Header]
-			//clientMapLock.Unlock()
+			//nn.clientMapLock.Lock()
+			//cID,ok := nn.clientMap[p.Data.Header.BlockID]
+			//nn.clientMapLock.Unlock()
 			//if !ok {
-			//	fmt.Println("Header not found in clientMap  ", p.Data.Header)
+			//	nn.connLog.Warn("Header not found in clientMap", "header", p.Data.Header)
 			//  return
 			//}
 			r.DST = "C"
 			r.CMD = BLOCK
 			r.Data = p.Data
+			if target, ok := nn.takeReplication(p.Data.Header); ok {
+				r.DST = target
+				r.Data.Header.DatanodeID = target
+				r.Storage = nn.newReplicaStorage(p.Data.Header.Filename, nn.replicas(p.Data.Header.Filename, p.Data.Header.BlockNum))
+			}
+
+		}
+	}
 
+	if r.CMD == ERROR {
+		nn.recentErrors.add(CommandName(p.CMD) + " from " + p.SRC + ": " + r.Message)
+	}
+
+	if p.SRC == "C" {
+		nn.audit(p, r)
+		if p.Key != "" && r.CMD != ERROR {
+			nn.retryCache.add(p.Key, r)
 		}
 	}
 
 	// send response
-	sendChannel <- r
+	nn.SendPacket(r)
 
 }
 
 // Checkconnection adds or updates a connection to the namenode and handles its first packet
-func CheckConnection(conn net.Conn, p Packet) {
+func (nn *NameNode) CheckConnection(conn net.Conn, p Packet) {
 
 	// C is the client(hardcode for now)
 	if p.SRC == "C" {
-		fmt.Println("Adding new client connection")
-		sendMapLock.Lock()
-		sendMap[p.SRC] = json.NewEncoder(conn)
-		sendMapLock.Unlock()
+		nn.connLog.Info("Adding new client connection", "src", p.SRC)
+		nn.clientHost = remoteHost(conn.RemoteAddr().String())
+		nn.SetOutbound(p.SRC, conn)
+		// events of subscriptions made on an earlier connection cannot reach them
+		nn.clearSubscriptions()
 	} else {
-		dn, ok := datanodemap[p.SRC]
+		if nn.decommissioned[p.SRC] {
+			nn.connLog.Warn("Refusing decommissioned datanode", "datanode", p.SRC)
+			conn.Close()
+			return
+		}
+		host := remoteHost(conn.RemoteAddr().String())
+		if !nn.isIncluded(p.SRC, host) {
+			nn.connLog.Warn("Refusing datanode missing from the include file", "datanode", p.SRC, "host", host)
+			conn.Close()
+			return
+		}
+		dn, ok := nn.datanodemap[p.SRC]
 		if !ok {
-			fmt.Println("Adding new datanode :", p.SRC)
-			datanodemap[p.SRC] = &datanode{p.SRC, false, 0}
+			nn.connLog.Info("Adding new datanode", "datanode", p.SRC)
+			nn.datanodemap[p.SRC] = &datanode{ID: p.SRC}
 		} else {
-			fmt.Printf("Datanode %s reconnected \n", dn.ID)
+			nn.connLog.Info("Datanode reconnected", "datanode", dn.ID)
+		}
+		nn.datanodemap[p.SRC].host = host
+		nn.datanodemap[p.SRC].conn = conn
+		// a datanode which restarted lost its cache, so its Blocks are cached again
+		nn.datanodemap[p.SRC].pinned = nil
+		if nn.isExcluded(p.SRC, host) && !nn.datanodemap[p.SRC].decommissioning {
+			nn.log.Info("Decommissioning excluded datanode", "datanode", p.SRC)
+			nn.datanodemap[p.SRC].decommissioning = true
 		}
-		sendMapLock.Lock()
-		sendMap[p.SRC] = json.NewEncoder(conn)
-		sendMapLock.Unlock()
-		dn = datanodemap[p.SRC]
+		delete(nn.offline, p.SRC)
+		nn.SetOutbound(p.SRC, conn)
 	}
-	HandlePacket(p)
+	nn.HandlePacket(p)
 }
 
 // Handle Connection initializes the connection and performs packet retrieval
-func HandleConnection(conn net.Conn) {
+func (nn *NameNode) HandleConnection(conn net.Conn) {
 
 	// receive first Packet and add datanode if necessary
 	var p Packet
-	decoder := json.NewDecoder(conn)
+	nn.awaitPacket(conn)
+	decoder, conn := newPacketDecoder(conn)
 	err := decoder.Decode(&p)
+	for isBadFrame(err) {
+		nn.connLog.Warn("Skipped frame", "remote", conn.RemoteAddr().String(), "err", err)
+		err = decoder.Decode(&p)
+	}
+	if nn.isIdle(err) {
+		nn.closeIdle(p.SRC, conn)
+		return
+	}
+	if err != nil {
+		nn.connLog.Warn("Unable to communicate with node", "remote", conn.RemoteAddr().String(), "err", err)
+		return
+	}
+	encoder := newPacketEncoder(conn)
+	hello, err := nn.handshake(p, encoder)
 	if err != nil {
-		fmt.Println("Unable to communicate with node")
+		nn.connLog.Warn("Refusing node", "src", p.SRC, "err", err)
+		conn.Close()
+		return
 	}
-	CheckConnection(conn, p)
-	dn := datanodemap[p.SRC]
 
-	// receive packets and handle
+	decodedWith(decoder, hello.Codec)
+	conn = chunked(encodedWith(conn, hello.Codec), hello)
+
+	// a datanode which registers is known by the ID the namenode gives it,
+	// and its REGISTER is answered in place of the HELLO
+	registering := p.SRC != "C" && hasFeature(hello.Features, "register")
+	if registering {
+		p, err = nn.readRegistration(decoder)
+	}
+	nn.update(func() {
+		if registering && err == nil {
+			p.SRC, err = nn.register(p, remoteHost(conn.RemoteAddr().String()))
+		}
+		if err != nil {
+			return
+		}
+		nn.CheckConnection(conn, p)
+		nn.recordHello(p.SRC, hello)
+	})
+	if err != nil {
+		nn.connLog.Warn("Refusing datanode registration", "src", p.SRC, "err", err)
+		r := Packet{SRC: nn.id, DST: p.SRC}
+		fail(&r, err)
+		encoder.Encode(r)
+		conn.Close()
+		return
+	}
+	src := p.SRC
+	defer nn.dropOutbound(src, conn)
+	defer nn.update(func() {
+		// the ID may be registered from another host once the datanode is gone
+		if dn, ok := nn.datanodemap[src]; ok && dn.conn == conn {
+			dn.conn = nil
+			nn.offline[src] = true
+			nn.connLog.Info("Datanode offline", "datanode", src)
+		}
+	})
+
+	// receive packets and queue them for the workers, returning once those
+	// already read are handled
+	seq := atomic.AddInt64(&nn.connSeq, 1)
+	defer nn.dispatcher.drain(seq)
+	var limiter *rateLimiter
+	if src == "C" && nn.clientRate > 0 {
+		limiter = newRateLimiter(nn.clientRate)
+	}
 	for {
 		var p Packet
+		offset := decoder.InputOffset()
+		nn.awaitPacket(conn)
 		err := decoder.Decode(&p)
+		if isTooLong(err) && src == "C" {
+			// the client learns why its request went unanswered
+			nn.metrics.countRefused(refusedSize)
+			nn.refuse(Packet{SRC: src, CMD: p.CMD, RequestID: p.RequestID}, err)
+		}
+		if isBadFrame(err) {
+			nn.connLog.Warn("Skipped frame", "src", src, "err", err)
+			continue
+		}
+		if nn.isIdle(err) {
+			nn.closeIdle(src, conn)
+			return
+		}
 		if err != nil {
-			fmt.Println("Datanode ", dn.ID, " disconnected!")
+			nn.connLog.Info("Node disconnected", "src", src, "err", err)
 			return
 		}
-		HandlePacket(p)
+		if p.SRC == "C" {
+			err = nn.admit(p, decoder.InputOffset()-offset, limiter)
+			if err != nil {
+				nn.refuse(p, err)
+				continue
+			}
+		}
+		nn.dispatch(seq, p)
 	}
 }
 
 // Parse Config sets up the node with the provided XML file
-func ParseConfigXML(configpath string) error {
+func (nn *NameNode) ParseConfigXML(configpath string) error {
 	xmlFile, err := os.Open(configpath)
 	if err != nil {
 		return err
@@ -565,11 +1968,11 @@ func ParseConfigXML(configpath string) error {
 	for _, o := range list.ConfigOptions {
 		switch o.Key {
 		case "namenodeid":
-			id = o.Value
+			nn.id = o.Value
 		case "listenhost":
-			host = o.Value
+			nn.host = o.Value
 		case "listenport":
-			port = o.Value
+			nn.port = o.Value
 		case "sizeofblock":
 			n, err := strconv.Atoi(o.Value)
 			if err != nil {
@@ -579,62 +1982,713 @@ func ParseConfigXML(configpath string) error {
 			if n < 4096 {
 				return errors.New("Buffer size must be greater than or equal to 4096 bytes")
 			}
-			SIZEOFBLOCK = n
-		default:
-			return errors.New("Bad ConfigOption received Key : " + o.Key + " Value : " + o.Value)
-		}
-	}
-
-	return nil
-}
-
-// Init initializes all internal structures to run a namnode and handles incoming connections
-func Init(configpath string) {
-
-	// Read config
-	err := ParseConfigXML(configpath)
-
-	if err != nil {
-		log.Fatal("Fatal error ", err.Error())
-	}
-
-	// setup filesystem
-	root = &filenode{"/", nil, make([]*filenode, 0, 1)}
-	filemap = make(map[string]map[int][]BlockHeader)
-
-	// setup communication
-	headerChannel = make(chan BlockHeader)
-	sendChannel = make(chan Packet)
-	sendMap = make(map[string]*json.Encoder)
-	sendMapLock = sync.Mutex{}
-	clientMap = make(map[BlockHeader]string)
-	clientMapLock = sync.Mutex{}
-
-	datanodemap = make(map[string]*datanode)
-}
+			nn.sizeofblock = n
+		case "maxpacketsize":
+			n, err := strconv.ParseInt(o.Value, 10, 64)
+			if err != nil {
+				return err
+			}
 
-// Run starts the namenode
-func Run(configpath string) {
+			if n < 1<<20 {
+				return errors.New("Longest packet must be at least 1048576 bytes")
+			}
+			maxPacketSize = n
+		case "maxblocksize":
+			n, err := strconv.ParseInt(o.Value, 10, 64)
+			if err != nil {
+				return err
+			}
 
-	// setup filesystem
-	Init(configpath)
+			if n < 4096 {
+				return errors.New("Longest Block must be at least 4096 bytes")
+			}
+			maxBlockSize = n
+		case "metadatafile":
+			nn.metadatafile = o.Value
+		case "metadatastore":
+			nn.metadatastore = o.Value
+		case "metadatacache":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
 
-	// Start communication
-	go HandleBlockHeaders()
-	go SendPackets()
+			if n < 1 {
+				return errors.New("Metadata cache must hold at least 1 file")
+			}
+			nn.metadatacache = n
+		case "codecs":
+			codecs := strings.Split(o.Value, ",")
+			for i, name := range codecs {
+				codecs[i] = strings.TrimSpace(name)
+				if codecVersion(codecs[i]) == 0 {
+					return errors.New("Codecs must be json, gob or msgpack")
+				}
+			}
+			nn.codecs = codecs
+		case "minprotocolversion":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
 
-	listener, err := net.Listen("tcp", host+":"+port)
-	if err != nil {
-		log.Fatal("Fatal error ", err.Error())
+			if n < 1 || n > protocolVersion {
+				return errors.New("Minimum protocol version must be from 1 to " + strconv.Itoa(protocolVersion))
+			}
+			nn.minProtocol = n
+		case "loglevel":
+			err := nn.logLevel.UnmarshalText([]byte(o.Value))
+			if err != nil {
+				return err
+			}
+		case "logpayloads":
+			b, err := strconv.ParseBool(o.Value)
+			if err != nil {
+				return err
+			}
+			nn.logPayloads = b
+		case "httpport":
+			nn.httpport = o.Value
+		case "quic":
+			b, err := strconv.ParseBool(o.Value)
+			if err != nil {
+				return err
+			}
+			nn.quic = b
+		case "trashinterval":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Trash interval must not be negative")
+			}
+			nn.trashInterval = time.Duration(n) * time.Minute
+		case "orphangraceperiod":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Orphan grace period must not be negative")
+			}
+			nn.orphanGrace = time.Duration(n) * time.Second
+		case "balancebandwidth":
+			n, err := strconv.ParseInt(o.Value, 10, 64)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Balancer bandwidth must be at least 1 byte per second")
+			}
+			nn.balanceBandwidth = n
+		case "replicationrate":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Replication rate must be at least 1 Block per second")
+			}
+			nn.replicationRate = n
+		case "moverinterval":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Mover interval must be at least 1 second")
+			}
+			nn.moverInterval = time.Duration(n) * time.Second
+		case "cacherescaninterval":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Cache rescan interval must be at least 1 second")
+			}
+			nn.cacheInterval = time.Duration(n) * time.Second
+		case "replicationinterval":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Replication interval must be at least 1 second")
+			}
+			nn.replicationInterval = time.Duration(n) * time.Second
+		case "retrycacheexpiry":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Retry cache expiry must not be negative")
+			}
+			nn.retryCache.expiry = time.Duration(n) * time.Second
+		case "accesstimeprecision":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Access time precision must not be negative")
+			}
+			nn.accessTimePrecision = time.Duration(n) * time.Second
+		case "maxxattrs":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Maximum extended attributes must be at least 1")
+			}
+			nn.maxXAttrs = n
+		case "maxxattrsize":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Maximum extended attribute size must be at least 1 byte")
+			}
+			nn.maxXAttrSize = n
+		case "editlog":
+			nn.editLogPath = o.Value
+		case "hastate":
+			switch o.Value {
+			case "active":
+				nn.standby = false
+			case "standby":
+				nn.standby = true
+			default:
+				return errors.New("HA state must be active or standby")
+			}
+		case "activeaddress":
+			nn.activeAddress = o.Value
+		case "tailinterval":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Tail interval must be at least 1 second")
+			}
+			nn.tailInterval = time.Duration(n) * time.Second
+		case "maxsymlinkdepth":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Maximum symbolic link depth must be at least 1 link")
+			}
+			nn.maxSymlinkDepth = n
+		case "globlimit":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Glob limit must be at least 1 path")
+			}
+			nn.globLimit = n
+		case "maxpathdepth":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Maximum path depth must be at least 1 component")
+			}
+			nn.maxPathDepth = n
+		case "maxcomponentlength":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Maximum component length must be at least 1 byte")
+			}
+			nn.maxComponentLength = n
+		case "topologyfile":
+			err := nn.LoadTopology(o.Value)
+			if err != nil {
+				return err
+			}
+			nn.topologyFile = o.Value
+		case "placement":
+			if o.Value != placementRandom && o.Value != placementHash {
+				return errors.New("Placement must be random or hash")
+			}
+			nn.placement = o.Value
+		case "topologyscript":
+			nn.topologyScript = o.Value
+		case "includefile":
+			nn.includeFile = o.Value
+			err := nn.LoadHosts()
+			if err != nil {
+				return err
+			}
+		case "excludefile":
+			nn.excludeFile = o.Value
+			err := nn.LoadHosts()
+			if err != nil {
+				return err
+			}
+		case "adminusers":
+			for _, u := range strings.Split(o.Value, ",") {
+				if u = strings.TrimSpace(u); u != "" {
+					nn.admins[u] = true
+				}
+			}
+		case "tenantsfile":
+			nn.tenantsFile = o.Value
+			err := nn.LoadTenants()
+			if err != nil {
+				return err
+			}
+		case "tenantsroot":
+			root, err := nn.cleanPath(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if root == "/" {
+				return errors.New("Tenants root must not be the root directory")
+			}
+			nn.tenantsRoot = root
+		case "replication":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Replication must be at least 1")
+			}
+			nn.replication = n
+		case "sendqueuesize":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Send queue size must be at least 1")
+			}
+			nn.sendQueueSize = n
+		case "handlercount":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Handler count must be at least 1")
+			}
+			nn.handlerCount = n
+		case "clientrate":
+			r, err := strconv.ParseFloat(o.Value, 64)
+			if err != nil {
+				return err
+			}
+
+			if r < 0 {
+				return errors.New("Client rate must not be negative")
+			}
+			nn.clientRate = r
+		case "globalrate":
+			r, err := strconv.ParseFloat(o.Value, 64)
+			if err != nil {
+				return err
+			}
+
+			if r < 0 {
+				return errors.New("Global rate must not be negative")
+			}
+			nn.globalRate, nn.globalLimiter = r, nil
+			if r > 0 {
+				nn.globalLimiter = newRateLimiter(r)
+			}
+		case "maxclientrequests":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Most client requests must not be negative")
+			}
+			nn.maxClientRequests = n
+		case "maxrequestsize":
+			n, err := strconv.ParseInt(o.Value, 10, 64)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Longest request size must not be negative")
+			}
+			nn.maxRequestSize = n
+		case "maxconnections":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Most connections must not be negative")
+			}
+			nn.maxConnections = n
+		case "maxconnectionsperip":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Most connections per IP must not be negative")
+			}
+			nn.maxConnectionsPerIP = n
+		case "idletimeout":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Idle timeout must not be negative")
+			}
+			nn.idleTimeout = time.Duration(n) * time.Minute
+		case "handlerqueuesize":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Handler queue size must be at least 1")
+			}
+			nn.handlerQueueSize = n
+		case "headerqueuesize":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Header queue size must be at least 1")
+			}
+			nn.headerChannel = make(chan BlockHeader, n)
+		case "queueoverflow":
+			if o.Value != overflowBlock && o.Value != overflowDrop && o.Value != overflowSpill {
+				return errors.New("Queue overflow policy must be block, drop or spill")
+			}
+			nn.queueOverflow = o.Value
+		case "spilldir":
+			nn.spillDir = o.Value
+		case "auditlog":
+			nn.auditFile = o.Value
+		case "auditlogsize":
+			n, err := strconv.ParseInt(o.Value, 0, 64)
+			if err != nil {
+				return err
+			}
+
+			if n < 1 {
+				return errors.New("Audit log size must be at least 1 byte")
+			}
+			nn.auditSize = n
+		case "auditlogfiles":
+			n, err := strconv.Atoi(o.Value)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("Audit log files must not be negative")
+			}
+			nn.auditFiles = n
+		default:
+			return errors.New("Bad ConfigOption received Key : " + o.Key + " Value : " + o.Value)
+		}
 	}
 
+	if nn.queueOverflow == overflowSpill {
+		nn.headerSpill = newSpillFile(nn.spillDir)
+	}
+	if nn.auditFile != "" {
+		nn.auditLog = newAuditLog(nn.auditFile, nn.auditSize, nn.auditFiles)
+	}
+	return nil
+}
+
+// Serve starts the namenode's internal goroutines and handles connections
+// accepted on l until Shutdown is called
+func (nn *NameNode) Serve(l net.Listener) error {
+
+	nn.mu.Lock()
+	select {
+	case <-nn.stop:
+		nn.mu.Unlock()
+		l.Close()
+		return errors.New("Namenode is shut down")
+	default:
+	}
+	nn.listener = l
+	nn.mu.Unlock()
+
+	// Start communication
+	nn.merger.Add(1)
+	go func() {
+		defer nn.merger.Done()
+		nn.HandleBlockHeaders()
+	}()
+	go nn.ExpireLeases()
+	go nn.MonitorReplication()
+	go nn.MonitorCache()
+	go nn.MonitorStorage()
+	go nn.TailEditLog()
+	if nn.trashInterval > 0 {
+		go nn.ExpungeTrash()
+	}
+	if nn.orphanGrace > 0 {
+		go nn.CollectOrphans()
+	}
+	return nn.accept(l)
+}
+
+// accept handles the connections accepted on l until it is closed
+func (nn *NameNode) accept(l net.Listener) error {
+
 	// listen for datanode connections
 	for {
-		conn, err := listener.Accept()
+		conn, err := l.Accept()
 		if err != nil {
-			fmt.Println("Connection error ", err.Error())
+			select {
+			case <-nn.stop:
+				return nil
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			nn.connLog.Error("Connection error", "err", err)
+			nn.recentErrors.add("Connection error " + err.Error())
 			continue
 		}
-		go HandleConnection(conn)
+
+		// track the connection so Shutdown can interrupt it
+		nn.mu.Lock()
+		select {
+		case <-nn.stop:
+			nn.mu.Unlock()
+			conn.Close()
+			return nil
+		default:
+		}
+		err = nn.admitConn(conn)
+		if err != nil {
+			nn.mu.Unlock()
+			nn.connLog.Warn("Refusing connection", "remote", conn.RemoteAddr().String(), "err", err)
+			conn.Close()
+			continue
+		}
+		nn.handlers.Add(1)
+		nn.mu.Unlock()
+
+		go func() {
+			defer nn.handlers.Done()
+			nn.HandleConnection(conn)
+
+			nn.mu.Lock()
+			nn.closedConn(conn)
+			nn.mu.Unlock()
+		}()
+	}
+}
+
+// ListenAndServe listens on the configured host and port and serves connections
+func (nn *NameNode) ListenAndServe() error {
+	l, err := net.Listen("tcp", nn.host+":"+nn.port)
+	if err != nil {
+		return err
+	}
+
+	if nn.httpport != "" {
+		hl, err := net.Listen("tcp", nn.host+":"+nn.httpport)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		go nn.ServeHTTP(hl)
+	}
+	if nn.quic {
+		ql, err := listenQUIC(nn.host + ":" + nn.port)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		go nn.ServeQUIC(ql)
+	}
+	return nn.Serve(l)
+}
+
+// ServeQUIC handles the QUIC connections accepted on l as it does those over
+// TCP, until shutdown closes l
+func (nn *NameNode) ServeQUIC(l net.Listener) {
+	nn.mu.Lock()
+	select {
+	case <-nn.stop:
+		nn.mu.Unlock()
+		l.Close()
+		return
+	default:
+	}
+	nn.quicListener = l
+	nn.mu.Unlock()
+
+	err := nn.accept(l)
+	if err != nil {
+		nn.connLog.Error("QUIC listener error", "err", err)
+	}
+}
+
+// Shutdown stops accepting connections, waits for in flight packets to be
+// handled and sent, closes all connections and saves the namespace to disc.
+// If ctx expires first the remaining packets are dropped and ctx's error is
+// returned once the namespace has been saved.
+func (nn *NameNode) Shutdown(ctx context.Context) error {
+	nn.mu.Lock()
+	select {
+	case <-nn.stop:
+		nn.mu.Unlock()
+		return errors.New("Namenode already shut down")
+	default:
+	}
+	close(nn.stop)
+
+	// stop accepting, and stop reading from open connections
+	if nn.listener != nil {
+		nn.listener.Close()
+	}
+	if nn.quicListener != nil {
+		nn.quicListener.Close()
+	}
+	if nn.httpServer != nil {
+		nn.httpServer.Close()
+	}
+	for conn, _ := range nn.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	nn.mu.Unlock()
+
+	defer close(nn.finished)
+	defer nn.filemap.Close()
+	defer nn.auditLog.Close()
+	defer nn.update(nn.closeEditLog)
+	// background tasks may still be finishing a round
+	defer nn.update(nn.SaveMetadata)
+
+	// wait for handlers, which may still be handling packets or merging headers
+	handled := make(chan struct{})
+	go func() {
+		nn.handlers.Wait()
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		nn.closeConnections()
+		return ctx.Err()
+	}
+	nn.dispatcher.close()
+	close(nn.quit)
+
+	// wait for the queued headers to be merged
+	merged := make(chan struct{})
+	go func() {
+		nn.merger.Wait()
+		close(merged)
+	}()
+	select {
+	case <-merged:
+	case <-ctx.Done():
+		nn.closeConnections()
+		return ctx.Err()
+	}
+
+	// background tasks stopped by quit may still be sending, so packets
+	// are dropped from now on, and those being enqueued are waited for
+	nn.sendMapLock.Lock()
+	nn.sendClosed = true
+	nn.sendMapLock.Unlock()
+	enqueued := make(chan struct{})
+	go func() {
+		nn.sending.Wait()
+		close(enqueued)
+	}()
+	select {
+	case <-enqueued:
+	case <-ctx.Done():
+		nn.closeConnections()
+		return ctx.Err()
+	}
+
+	// nothing else will be enqueued, so flush what remains
+	nn.sendMapLock.Lock()
+	queues := make([]*outbound, 0, len(nn.sendMap))
+	for _, ob := range nn.sendMap {
+		close(ob.queue)
+		queues = append(queues, ob)
+	}
+	nn.sendMapLock.Unlock()
+
+	var err error
+	for _, ob := range queues {
+		select {
+		case <-ob.flushed:
+		case <-ob.done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	nn.closeConnections()
+	return err
+}
+
+// Done returns a channel which is closed once Shutdown has completed
+func (nn *NameNode) Done() <-chan struct{} {
+	return nn.finished
+}
+
+// closeConnections closes every connection that is still open
+func (nn *NameNode) closeConnections() {
+	nn.mu.Lock()
+	defer nn.mu.Unlock()
+	for conn, _ := range nn.conns {
+		conn.Close()
 	}
 }
@@ -0,0 +1,124 @@
+package namenode
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndependentNameNodes(t *testing.T) {
+
+	nn1 := New()
+	nn2 := New()
+
+	nn1.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	err := nn1.MergeNode(BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""})
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+
+	if _, ok := nn2.filemap.Get("/out.txt"); ok {
+		t.Errorf("Namenodes share a filesystem")
+	}
+	if len(nn2.datanodemap) != 0 {
+		t.Errorf("Namenodes share datanodes")
+	}
+}
+
+func TestServeReturnsAfterShutdown(t *testing.T) {
+
+	nn := New()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- nn.Serve(l)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	nn.Shutdown(context.Background())
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve returned %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Serve did not return after Shutdown")
+	}
+}
+
+func TestMetadataSurvivesRestart(t *testing.T) {
+
+	nn := New()
+	nn.metadatafile = filepath.Join(os.TempDir(), "godfs_metadata_test.json")
+	defer os.Remove(nn.metadatafile)
+
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/dir/out.txt", 1, 0, 2, 0, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/dir/out.txt", 1, 1, 2, 0, "", 0, ""})
+	nn.Mkdir("/empty/sub", true)
+
+	err := nn.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+
+	restarted := New()
+	restarted.metadatafile = nn.metadatafile
+	err = restarted.LoadMetadata()
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+
+	blks, ok := restarted.filemap.Get("/dir/out.txt")
+	if !ok || len(blks) != 2 {
+		t.Errorf("Metadata was not restored, got %v", blks)
+	}
+	if restarted.datanodemap["DN1"].size != 2 {
+		t.Errorf("Datanode size not restored, got %d", restarted.datanodemap["DN1"].size)
+	}
+	if n := restarted.lookup("/empty/sub"); n == nil || !n.explicit || len(n.children) != 0 {
+		t.Errorf("Empty directory was not restored")
+	}
+}
+
+func TestPagedHeaders(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1"}
+	for n := 0; n < 5; n++ {
+		nn.MergeNode(BlockHeader{"DN1", "/big.bin", 1, n, 5, 0, "", 0, ""})
+	}
+	conn, peer := net.Pipe()
+	nn.SetOutbound("C", conn)
+	dec := json.NewDecoder(peer)
+	headers := func(offset, limit int) Packet {
+		go nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: GETHEADERS, Headers: []BlockHeader{{Filename: "/big.bin"}}, Offset: offset, Limit: limit})
+		var r Packet
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	if r := headers(0, 0); r.CMD != GETHEADERS || len(r.Headers) != 5 {
+		t.Errorf("Expected every header without a limit, got %v", r)
+	}
+	if r := headers(1, 2); r.Offset != 1 || len(r.Headers) != 2 || r.Headers[0].BlockNum != 1 || r.Headers[1].BlockNum != 2 {
+		t.Errorf("Expected the headers of Blocks 1 and 2, got %v", r)
+	}
+	if r := headers(4, 2); len(r.Headers) != 1 || r.Headers[0].BlockNum != 4 {
+		t.Errorf("Expected the last header, got %v", r)
+	}
+	if r := headers(5, 2); r.CMD != ERROR {
+		t.Errorf("Page past the last Block answered %v", r)
+	}
+}
@@ -0,0 +1,480 @@
+package namenode
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// handleNamespace performs a client's namespace request on the path in its
+// first header, filling in the response r
+func (nn *NameNode) handleNamespace(p Packet, r *Packet) {
+	path := p.Headers[0].Filename
+	var err error
+
+	// a change is logged as the request making it, once it is made
+	if journaled(p.CMD) && !nn.replaying && !nn.logging {
+		stamp := atomic.LoadInt64(&nn.genStamp)
+		nn.logging = true
+		defer func() {
+			nn.logging = false
+			if r.CMD != ERROR {
+				nn.logEdit(edit{GenStamp: stamp, Request: &p})
+			}
+		}()
+	}
+
+	switch p.CMD {
+	case DELETE, MKDIR, SETQUOTA, RENAME, CREATESNAPSHOT, DELETESNAPSHOT, LEASE, ERASURECODE, CREATEZONE, FILEKEY, SETREP, TRUNCATE, SETTIMES,
+		SETXATTR, REMOVEXATTR, CREATESYMLINK, STORAGEPOLICY, RESUME:
+		if isSnapshotPath(path) || (len(p.Renamed) == 1 && isSnapshotPath(p.Renamed[0].Filename)) {
+			r.CMD = ERROR
+			r.Message = "Snapshots are read-only " + path
+			return
+		}
+	}
+	switch p.CMD {
+	case DELETE, MKDIR, SETQUOTA, RENAME, CREATESNAPSHOT, LEASE, ERASURECODE, CREATEZONE, FILEKEY, SETREP, TRUNCATE, SETTIMES,
+		SETXATTR, REMOVEXATTR, CREATESYMLINK, ARCHIVE, STORAGEPOLICY, RESUME:
+		// the container of an archive may only be deleted, moved or replicated
+		_, container := nn.archives[path]
+		rewrites := p.CMD == LEASE || p.CMD == TRUNCATE || p.CMD == ERASURECODE || p.CMD == FILEKEY || p.CMD == RESUME
+		if nn.inArchive(path) || (len(p.Renamed) == 1 && nn.inArchive(p.Renamed[0].Filename)) || (container && rewrites) {
+			r.CMD = ERROR
+			r.Message = "Archives are read-only " + path
+			return
+		}
+	}
+
+	switch p.CMD {
+	case DELETE:
+		recursive := p.Flags&RECURSIVE != 0
+		trashed := nn.trashInterval > 0 && p.Flags&SKIPTRASH == 0 && !inTrash(path)
+		dst := nn.trashPath(path, p.SRC)
+		if nn.replaying {
+			// the edit of a move to the trash names where it was moved
+			trashed = len(p.Renamed) == 1
+			if trashed {
+				dst = p.Renamed[0].Filename
+			}
+		}
+		p.Renamed = nil
+		if trashed {
+			err = nn.MoveToTrash(path, dst, recursive)
+			r.Message = "Moved to trash " + dst
+			if err == nil {
+				nn.notify(eventRename, path, dst)
+				p.Renamed = []BlockHeader{{Filename: dst}}
+			}
+		} else {
+			err = nn.Delete(path, recursive)
+			if err == nil {
+				nn.notify(eventDelete, path, "")
+			}
+		}
+		r.CMD = ACK
+	case RENAME:
+		if len(p.Renamed) != 1 {
+			err = errors.New("Missing destination")
+			break
+		}
+		err = nn.checkZoneRename(path, p.Renamed[0].Filename)
+		if err == nil {
+			err = nn.Rename(path, p.Renamed[0].Filename)
+		}
+		if err == nil {
+			nn.notify(eventRename, path, p.Renamed[0].Filename)
+		}
+		r.CMD = ACK
+	case LEASE:
+		err = nn.CreateFile(path, p.Message, p.Flags)
+		// the writer may choose the block size and replication of the file
+		if err == nil && len(p.Status) == 1 {
+			err = nn.SetLayout(path, p.Message, p.Status[0].BlockSize, p.Status[0].Replicas)
+		}
+		// a writer in an encryption zone gives the file a data key
+		if zone := nn.zoneOf(path); zone != nil {
+			r.Status = []FileStatus{{Path: zone.path, IsDir: true, Zone: zone.zoneKey}}
+		}
+		r.CMD = ACK
+	case FLUSH:
+		replicas := 0
+		if len(p.Status) == 1 {
+			replicas = p.Status[0].Replicas
+		}
+		var visible int64
+		visible, err = nn.Flush(path, p.Message, replicas)
+		r.Status = []FileStatus{{Path: path, Size: visible}}
+		r.CMD = ACK
+	case RESUME:
+		var layout FileStatus
+		if len(p.Status) == 1 {
+			layout = p.Status[0]
+		}
+		var st FileStatus
+		st, r.Headers, err = nn.Resume(path, p.Message, layout.BlockSize, layout.Replicas)
+		r.Status = []FileStatus{st}
+		r.CMD = ACK
+	case RELEASE:
+		err = nn.ReleaseLease(path, p.Message)
+		if err == nil && nn.lookup(path) != nil {
+			nn.notify(eventClose, path, "")
+		}
+		r.CMD = ACK
+	case ERASURECODE:
+		if len(p.Status) != 1 {
+			err = errors.New("Missing erasure coding policy")
+			break
+		}
+		err = nn.SetErasure(path, p.Status[0].Erasure, p.Message, p.Status[0].Size)
+		r.CMD = ACK
+	case CREATEZONE:
+		err = nn.CreateZone(path, p.Message)
+		r.CMD = ACK
+	case SETREP:
+		var n, changed int
+		n, err = strconv.Atoi(p.Message)
+		if err == nil {
+			changed, err = nn.SetReplication(path, n)
+		}
+		r.Message = "Replication of " + strconv.Itoa(changed) + " files set to " + p.Message
+		r.CMD = ACK
+	case TRUNCATE:
+		var length int64
+		length, err = strconv.ParseInt(p.Message, 10, 64)
+		if err == nil {
+			err = nn.Truncate(path, length)
+		}
+		r.CMD = ACK
+	case LISTZONES:
+		r.Status = nn.ListZones()
+		r.CMD = LISTZONES
+	case FILEKEY:
+		if len(p.Status) != 1 {
+			err = errors.New("Missing data key")
+			break
+		}
+		err = nn.SetFileKey(path, p.Message, p.Status[0].Zone, p.Status[0].Key)
+		r.CMD = ACK
+	case CREATESNAPSHOT:
+		err = nn.CreateSnapshot(path, p.Message)
+		r.CMD = ACK
+	case ARCHIVE:
+		err = nn.CreateArchive(path, p.Status)
+		r.CMD = ACK
+	case CACHE:
+		err = nn.authorize(p.User)
+		if err == nil {
+			err = nn.AddCacheDirective(path)
+		}
+		r.CMD = ACK
+	case UNCACHE:
+		err = nn.authorize(p.User)
+		if err == nil {
+			err = nn.RemoveCacheDirective(path)
+		}
+		r.CMD = ACK
+	case STORAGEPOLICY:
+		err = nn.SetStoragePolicy(path, p.Message)
+		r.CMD = ACK
+	case LISTCACHE:
+		r.Status = nn.ListCacheDirectives()
+		r.CMD = LISTCACHE
+	case DELETESNAPSHOT:
+		err = nn.DeleteSnapshot(path, p.Message)
+		r.CMD = ACK
+	case LISTSNAPSHOT:
+		r.Status, err = nn.ListSnapshots(path)
+		r.CMD = LISTSNAPSHOT
+	case SNAPSHOTDIFF:
+		// the Message names the earlier snapshot, and the later after a slash
+		from, to := p.Message, ""
+		if i := strings.Index(p.Message, "/"); i >= 0 {
+			from, to = p.Message[:i], p.Message[i+1:]
+		}
+		r.Status, err = nn.SnapshotDiff(path, from, to)
+		r.CMD = SNAPSHOTDIFF
+	case GETBLOCKLOCATIONS:
+		var st FileStatus
+		st, r.Locations, err = nn.BlockLocations(path, int64(p.Offset), int64(p.Limit), nn.clientHost)
+		r.CMD = GETBLOCKLOCATIONS
+		r.Status = []FileStatus{st}
+	case STAT:
+		var st FileStatus
+		st, err = nn.Stat(path)
+		r.CMD = STAT
+		r.Status = []FileStatus{st}
+	case LISTDIR:
+		r.Status, err = nn.ListDir(path, p.Flags&RECURSIVE != 0)
+		r.CMD = LISTDIR
+	case GLOB:
+		r.Status, err = nn.Glob(path)
+		r.CMD = GLOB
+	case SETXATTR:
+		if len(p.Status) != 1 {
+			err = errors.New("Missing attribute value")
+			break
+		}
+		err = nn.SetXAttr(path, p.Message, p.Status[0].XAttrs[p.Message], p.User)
+		r.CMD = ACK
+	case GETXATTR:
+		var value []byte
+		value, err = nn.GetXAttr(path, p.Message)
+		r.Status = []FileStatus{{Path: path, XAttrs: map[string][]byte{p.Message: value}}}
+		r.CMD = GETXATTR
+	case LISTXATTRS:
+		var names []string
+		names, err = nn.ListXAttrs(path)
+		r.Message = strings.Join(names, "\n")
+		r.CMD = LISTXATTRS
+	case REMOVEXATTR:
+		err = nn.RemoveXAttr(path, p.Message, p.User)
+		r.CMD = ACK
+	case CREATESYMLINK:
+		err = nn.CreateSymlink(path, p.Message, p.Flags&PARENTS != 0)
+		if err == nil {
+			nn.notify(eventCreate, path, "")
+		}
+		r.CMD = ACK
+	case READLINK:
+		r.Message, err = nn.Readlink(path)
+		r.CMD = READLINK
+	case SUBSCRIBE:
+		nn.Subscribe(p.RequestID, path)
+		r.CMD = ACK
+	case UNSUBSCRIBE:
+		var id int64
+		id, err = strconv.ParseInt(p.Message, 10, 64)
+		if err == nil {
+			nn.Unsubscribe(id)
+		}
+		r.CMD = ACK
+	case SETTIMES:
+		if len(p.Status) != 1 {
+			err = errors.New("Missing times")
+			break
+		}
+		err = nn.SetTimes(path, p.Status[0].ModTime, p.Status[0].AccessTime)
+		r.CMD = ACK
+	case MKDIR:
+		existed := nn.lookup(path) != nil
+		err = nn.Mkdir(path, p.Flags&PARENTS != 0)
+		if err == nil && !existed {
+			nn.notify(eventCreate, path, "")
+		}
+		r.CMD = ACK
+	case SETQUOTA:
+		if len(p.Status) != 1 {
+			err = errors.New("Missing quota")
+			break
+		}
+		err = nn.SetQuota(path, p.Status[0].FileQuota, p.Status[0].SpaceQuota)
+		r.CMD = ACK
+	case GETQUOTA:
+		var st FileStatus
+		st, err = nn.Stat(path)
+		r.CMD = GETQUOTA
+		r.Status = []FileStatus{st}
+	}
+
+	if err != nil {
+		fail(r, err)
+		r.Status = nil
+	}
+}
+
+// walk calls fn for n and every filenode below it
+func (nn *NameNode) walk(n *filenode, fn func(*filenode)) {
+	fn(n)
+	for _, c := range n.children {
+		nn.walk(c, fn)
+	}
+}
+
+// isFile reports whether a filenode is a file rather than a directory
+func (nn *NameNode) isFile(n *filenode) bool {
+	_, ok := nn.filemap.Get(n.path)
+	return ok
+}
+
+// fileStatus describes a single filenode
+func (nn *NameNode) fileStatus(n *filenode) FileStatus {
+	var st FileStatus
+	if blocks, ok := nn.filemap.Get(n.path); ok {
+		st = nn.fileBlocksStatus(n.path, blocks)
+		// an archive reads as the directory of the files packed into it
+		if a, ok := nn.archives[n.path]; ok {
+			st.IsDir, st.Archive, st.Children = true, a.Path, len(a.children("", false))
+		}
+	} else if n.target != "" {
+		st = FileStatus{Path: n.path, Symlink: n.target}
+	} else {
+		st = FileStatus{Path: n.path, IsDir: true, Children: len(n.children), FileQuota: n.fileQuota, SpaceQuota: n.spaceQuota, Zone: n.zoneKey}
+	}
+	st.ModTime, st.AccessTime = n.mtime, atomic.LoadInt64(&n.atime)
+	st.Storage = nn.storagePolicy(n)
+	return st
+}
+
+// blocksStatus describes the file at path made of blocks
+func blocksStatus(path string, blocks map[int][]BlockHeader) FileStatus {
+	st := FileStatus{Path: path}
+	for _, replicas := range blocks {
+		if len(replicas) == 0 {
+			continue
+		}
+		st.NumBlocks = replicas[0].NumBlocks
+		st.Size += int64(replicas[0].Size)
+		if st.Replication == 0 || len(replicas) < st.Replication {
+			st.Replication = len(replicas)
+		}
+	}
+	if len(blocks) < st.NumBlocks {
+		// some blocks have no replicas at all
+		st.Replication = 0
+	}
+	return st
+}
+
+// Stat describes the file or directory at path, including the usage of a
+// directory
+func (nn *NameNode) Stat(path string) (FileStatus, error) {
+	if isSnapshotPath(path) {
+		return nn.statSnapshotPath(path)
+	}
+	if a, rel, ok := nn.splitArchivePath(path); ok && rel != "" {
+		return nn.archiveStat(a, rel)
+	}
+	n := nn.lookup(path)
+	if n == nil {
+		return FileStatus{}, newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+	st := nn.fileStatus(n)
+	if st.IsDir {
+		st.FileCount, st.SpaceUsed = nn.usage(n)
+	}
+	return st, nil
+}
+
+// ListDir describes the entries of the directory at path, or the file itself
+// if path is a file. If recursive is set the entries of subdirectories are
+// listed after their directory.
+func (nn *NameNode) ListDir(path string, recursive bool) ([]FileStatus, error) {
+	if isSnapshotPath(path) {
+		return nn.listSnapshotPath(path, recursive)
+	}
+	if a, rel, ok := nn.splitArchivePath(path); ok {
+		return nn.listArchivePath(a, rel, recursive)
+	}
+	n := nn.lookup(path)
+	if n == nil {
+		return nil, newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+	if nn.isFile(n) {
+		return []FileStatus{nn.fileStatus(n)}, nil
+	}
+
+	list := make([]FileStatus, 0, len(n.children))
+	var add func(dir *filenode)
+	add = func(dir *filenode) {
+		for _, c := range dir.children {
+			list = append(list, nn.fileStatus(c))
+			if recursive && !nn.isFile(c) {
+				add(c)
+			}
+		}
+	}
+	add(n)
+	return list, nil
+}
+
+// Mkdir creates a directory at path. Unless parents is set the parent
+// directory must already exist, and it is an error for path to exist.
+func (nn *NameNode) Mkdir(path string, parents bool) error {
+	if !strings.HasPrefix(path, "/") || path == "/" {
+		if parents && path == "/" {
+			return nil
+		}
+		return errors.New("Invalid directory " + path)
+	}
+
+	path_arr := strings.Split(path, "/")
+	q := nn.root
+	for i := 1; i < len(path_arr); i++ {
+		partial := strings.Join(path_arr[0:i+1], "/")
+		var next *filenode
+		for _, c := range q.children {
+			if c.path == partial {
+				next = c
+				break
+			}
+		}
+
+		last := i == len(path_arr)-1
+		if next != nil {
+			if nn.isFile(next) || next.target != "" {
+				return errors.New("File exists " + partial)
+			}
+			if last && !parents {
+				return errors.New("Directory exists " + partial)
+			}
+			if last {
+				next.explicit = true
+			}
+			q = next
+			continue
+		}
+
+		if !last && !parents {
+			return errors.New("No such directory " + partial)
+		}
+		next = newFilenode(partial, q)
+		next.explicit = true
+		q.children = append(q.children, next)
+		q = next
+	}
+	return nil
+}
+
+// Delete removes the file or directory at path. A directory must be empty
+// unless recursive is set, in which case every file beneath it is deleted.
+func (nn *NameNode) Delete(path string, recursive bool) error {
+	n := nn.lookup(path)
+	if n == nil {
+		return newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+	if nn.isFile(n) {
+		return nn.DeleteFile(path)
+	}
+	if n == nn.root {
+		return errors.New("Cannot delete the root directory")
+	}
+	if nn.hasSnapshots(path) {
+		return errors.New("Cannot delete a directory with snapshots " + path)
+	}
+	if len(n.children) > 0 && !recursive {
+		return errors.New("Directory not empty " + path)
+	}
+
+	files := make([]string, 0)
+	nn.walk(n, func(c *filenode) {
+		if nn.isFile(c) {
+			files = append(files, c.path)
+		}
+	})
+	for _, f := range files {
+		err := nn.DeleteFile(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the directory may already have been pruned with its last file
+	n.explicit = false
+	if nn.lookup(path) == n {
+		n.children = nil
+		nn.removeFile(path)
+	}
+	return nil
+}
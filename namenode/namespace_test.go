@@ -0,0 +1,93 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestMkdirAndListDir(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+
+	err := nn.Mkdir("/a/b", false)
+	if err == nil {
+		t.Errorf("Created directory without its parent")
+	}
+	err = nn.Mkdir("/a/b", true)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	err = nn.Mkdir("/a", false)
+	if err == nil {
+		t.Errorf("Created existing directory")
+	}
+
+	nn.MergeNode(BlockHeader{"DN1", "/a/file.txt", 10, 0, 2, 0, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/a/file.txt", 5, 1, 2, 0, "", 0, ""})
+
+	list, err := nn.ListDir("/a", false)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", list)
+	}
+
+	st, err := nn.Stat("/a/file.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if st.IsDir || st.Size != 15 || st.NumBlocks != 2 || st.Replication != 1 {
+		t.Errorf("Unexpected file status %v", st)
+	}
+	st, err = nn.Stat("/a/b")
+	if err != nil || !st.IsDir {
+		t.Errorf("Unexpected directory status %v %v", st, err)
+	}
+
+	list, err = nn.ListDir("/", true)
+	if err != nil || len(list) != 3 {
+		t.Errorf("Expected 3 recursive entries, got %v %v", list, err)
+	}
+
+	if _, err := nn.Stat("/missing"); err == nil {
+		t.Errorf("Stat of missing path succeeded")
+	}
+}
+
+func TestDeleteDirectory(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+
+	nn.Mkdir("/empty", false)
+	nn.MergeNode(BlockHeader{"DN1", "/dir/sub/file.txt", 1, 0, 1, 0, "", 0, ""})
+
+	err := nn.Delete("/dir", false)
+	if err == nil {
+		t.Errorf("Deleted non empty directory without recursive")
+	}
+	err = nn.Delete("/dir", true)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	if nn.lookup("/dir") != nil {
+		t.Errorf("Directory still exists after delete")
+	}
+	if len(nn.PendingInvalidations("DN1")) != 1 {
+		t.Errorf("Replica was not invalidated")
+	}
+
+	// explicit directories survive until deleted
+	if nn.lookup("/empty") == nil {
+		t.Errorf("Empty directory was removed")
+	}
+	err = nn.Delete("/empty", false)
+	if err != nil || nn.lookup("/empty") != nil {
+		t.Errorf("Empty directory was not deleted %v", err)
+	}
+
+	if nn.Delete("/", true) == nil {
+		t.Errorf("Deleted the root directory")
+	}
+}
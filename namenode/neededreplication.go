@@ -0,0 +1,157 @@
+package namenode
+
+import (
+	"sync"
+	"time"
+)
+
+// default Blocks whose replication may start each second
+const defaultReplicationRate = 20
+
+// priorities of the Blocks waiting to be replicated, most urgent first
+const (
+	priorityHighest = iota // a single replica is left, or none on a datanode in service
+	priorityHigh           // fewer than a third of the replicas wanted are left
+	priorityNormal         // some replicas are missing
+	numPriorities
+)
+
+// names of the priorities, used by the report and the metrics
+var priorityNames = []string{"highest", "high", "normal"}
+
+// replicationPriority ranks a Block with live replicas on datanodes in
+// service out of the needed ones
+func replicationPriority(live, needed int) int {
+	switch {
+	case live <= 1:
+		return priorityHighest
+	case live*3 < needed:
+		return priorityHigh
+	}
+	return priorityNormal
+}
+
+// blockKey identifies a Block regardless of its replicas
+type blockKey struct {
+	Filename string
+	BlockNum int
+}
+
+// neededReplication is a Block waiting for a copy, made from preferred if
+// its datanode is connected
+type neededReplication struct {
+	replicas  []BlockHeader
+	preferred BlockHeader
+}
+
+// neededReplications queues the Blocks to replicate by priority, in the
+// order they were added within a priority. Each Block is queued once.
+type neededReplications struct {
+	mu       sync.Mutex
+	levels   [numPriorities][]blockKey
+	blocks   map[blockKey]neededReplication
+	priority map[blockKey]int
+
+	started int       // replications started since window
+	window  time.Time // start of the current second
+}
+
+func newNeededReplications() *neededReplications {
+	return &neededReplications{blocks: make(map[blockKey]neededReplication), priority: make(map[blockKey]int)}
+}
+
+// add queues a Block at priority, moving it if it was queued at another
+func (q *neededReplications) add(n neededReplication, priority int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := blockKey{n.preferred.Filename, n.preferred.BlockNum}
+	if old, ok := q.priority[key]; ok && old != priority {
+		q.remove(key, old)
+	}
+	if _, ok := q.blocks[key]; !ok || q.priority[key] != priority {
+		q.levels[priority] = append(q.levels[priority], key)
+	}
+	q.blocks[key] = n
+	q.priority[key] = priority
+}
+
+// remove drops key from the level of priority. The caller must hold mu.
+func (q *neededReplications) remove(key blockKey, priority int) {
+	level := q.levels[priority]
+	for i, k := range level {
+		if k == key {
+			q.levels[priority] = append(level[:i], level[i+1:]...)
+			break
+		}
+	}
+}
+
+// pop removes the most urgent Block. The caller must hold mu.
+func (q *neededReplications) pop() (neededReplication, bool) {
+	for p := range q.levels {
+		if len(q.levels[p]) == 0 {
+			continue
+		}
+		key := q.levels[p][0]
+		q.levels[p] = q.levels[p][1:]
+		n := q.blocks[key]
+		delete(q.blocks, key)
+		delete(q.priority, key)
+		return n, true
+	}
+	return neededReplication{}, false
+}
+
+// lengths counts the queued Blocks of each priority
+func (q *neededReplications) lengths() [numPriorities]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var n [numPriorities]int
+	for p := range q.levels {
+		n[p] = len(q.levels[p])
+	}
+	return n
+}
+
+// replicate queues a copy of a Block, reading it from the preferred replica
+// if that datanode is connected, and starts the most urgent copies the
+// replication rate allows
+func (nn *NameNode) replicate(replicas []BlockHeader, preferred BlockHeader) {
+	live := 0
+	for _, h := range replicas {
+		if dn, ok := nn.datanodemap[h.DatanodeID]; ok && !dn.decommissioning && !nn.offline[dn.ID] {
+			live++
+		}
+	}
+	priority := replicationPriority(live, nn.neededReplicas(preferred.Filename))
+	nn.needed.add(neededReplication{replicas, preferred}, priority)
+	nn.dispatchReplications()
+}
+
+// dispatchReplications starts copies of the queued Blocks, most urgent
+// first, until replicationRate have started within the current second
+func (nn *NameNode) dispatchReplications() {
+	q := nn.needed
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if time.Since(q.window) >= time.Second {
+		q.window = time.Now()
+		q.started = 0
+	}
+	for q.started < nn.replicationRate {
+		n, ok := q.pop()
+		if !ok {
+			return
+		}
+		// Blocks of files deleted since are dropped
+		if len(nn.replicas(n.preferred.Filename, n.preferred.BlockNum)) == 0 {
+			continue
+		}
+		if nn.startReplication(n.replicas, n.preferred) {
+			q.started++
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package namenode
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplicationPriority(t *testing.T) {
+
+	nn := New()
+	nn.replication = 3
+	nn.replicationRate = 1
+	for _, id := range []string{"DN1", "DN2", "DN3", "DN4"} {
+		nn.datanodemap[id] = &datanode{ID: id, listed: true}
+	}
+	first := []BlockHeader{{"DN1", "/first.txt", 10, 0, 1, 0, "", 0, ""}, {"DN2", "/first.txt", 10, 0, 1, 0, "", 0, ""}}
+	degraded := []BlockHeader{{"DN1", "/degraded.txt", 10, 0, 1, 0, "", 0, ""}, {"DN2", "/degraded.txt", 10, 0, 1, 0, "", 0, ""}}
+	single := []BlockHeader{{"DN3", "/single.txt", 10, 0, 1, 0, "", 0, ""}}
+	for _, replicas := range [][]BlockHeader{first, degraded, single} {
+		for _, h := range replicas {
+			nn.MergeNode(h)
+		}
+	}
+
+	// the rate allows one copy within the second, the rest wait by priority
+	nn.replicate(first, first[0])
+	nn.replicate(degraded, degraded[0])
+	nn.replicate(degraded, degraded[0])
+	nn.replicate(single, single[0])
+	if _, ok := nn.replications[first[0]]; !ok || len(nn.replications) != 1 {
+		t.Fatalf("Expected the first Block copied, got %v", nn.replications)
+	}
+	if n := nn.needed.lengths(); n[priorityHighest] != 1 || n[priorityNormal] != 1 {
+		t.Fatalf("Unexpected queue lengths %v", n)
+	}
+
+	nn.needed.window = time.Time{}
+	nn.dispatchReplications()
+	if _, ok := nn.replications[single[0]]; !ok {
+		t.Errorf("Block with a single replica was not copied first %v", nn.replications)
+	}
+	if n := nn.needed.lengths(); n[priorityHighest] != 0 || n[priorityNormal] != 1 {
+		t.Errorf("Unexpected queue lengths %v", n)
+	}
+
+	// a Block losing another replica moves up the queue
+	nn.datanodemap["DN2"].decommissioning = true
+	nn.replicate(degraded, degraded[0])
+	if n := nn.needed.lengths(); n[priorityHighest] != 1 || n[priorityNormal] != 0 {
+		t.Errorf("Unexpected queue lengths %v", n)
+	}
+	if !strings.Contains(nn.Report(), "Replication queue: 1 highest, 0 high, 0 normal priority") {
+		t.Errorf("Queue missing from the report")
+	}
+
+	if replicationPriority(2, 9) != priorityHigh || replicationPriority(2, 3) != priorityNormal || replicationPriority(0, 3) != priorityHighest {
+		t.Errorf("Unexpected priorities")
+	}
+}
@@ -0,0 +1,110 @@
+package namenode
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// persistsNamespace reports whether the namenode keeps its namespace across
+// restarts, rather than learning its files from block reports
+func (nn *NameNode) persistsNamespace() bool {
+	return nn.metadatafile != "" || nn.metadatastore != "" || nn.editLogPath != ""
+}
+
+// holdOrphan reports whether a replica listed in a full block report belongs
+// to no file, in which case it is held back rather than merged, so it does not
+// bring a deleted file back. The time it was first reported is kept, and it is
+// deleted by collectOrphans once the grace period passes without its file
+// appearing. Replicas of files being written are never orphans.
+func (nn *NameNode) holdOrphan(h BlockHeader) bool {
+	if nn.orphanGrace == 0 || nn.standby || !nn.persistsNamespace() {
+		return false
+	}
+	if nn.isInvalidated(h) || nn.isRenamed(h) || strings.HasPrefix(h.Filename, snapshotStorage+"/") {
+		return false
+	}
+	if _, ok := nn.filemap.Block(h.Filename, h.BlockNum); ok || nn.leaseHolder(h.Filename) != "" {
+		return false
+	}
+	if _, ok := nn.orphans[h]; !ok {
+		nn.metaLog.Info("Found orphaned replica", "header", h)
+		nn.orphans[h] = time.Now()
+	}
+	return true
+}
+
+// forgetOrphans drops the orphans of a datanode which its full report did
+// not list, as it no longer holds them
+func (nn *NameNode) forgetOrphans(dn *datanode, reported map[BlockHeader]struct{}) {
+	for h := range nn.orphans {
+		if _, ok := reported[h]; !ok && h.DatanodeID == dn.ID {
+			delete(nn.orphans, h)
+		}
+	}
+}
+
+// CollectOrphans periodically deletes the orphaned replicas whose grace
+// period passed, until the namenode shuts down
+func (nn *NameNode) CollectOrphans() {
+	interval := nn.orphanGrace / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case now := <-tick.C:
+			nn.update(func() { nn.collectOrphans(now) })
+		case <-nn.quit:
+			return
+		}
+	}
+}
+
+// collectOrphans invalidates the orphans whose grace period expired by now,
+// recording each in the audit log. An orphan whose file appeared meanwhile,
+// as when its writer's BLOCKACK arrived after the report, is merged instead.
+func (nn *NameNode) collectOrphans(now time.Time) {
+	for h, found := range nn.orphans {
+		if _, ok := nn.filemap.Block(h.Filename, h.BlockNum); ok {
+			delete(nn.orphans, h)
+			nn.mergeReported(h)
+			continue
+		}
+		if _, ok := nn.datanodemap[h.DatanodeID]; !ok {
+			delete(nn.orphans, h)
+			continue
+		}
+		if nn.leaseHolder(h.Filename) != "" || now.Sub(found) < nn.orphanGrace {
+			continue
+		}
+
+		delete(nn.orphans, h)
+		nn.metaLog.Info("Deleting orphaned replica", "header", h, "found", found)
+		nn.Invalidate(h)
+		nn.auditOrphan(h)
+	}
+}
+
+// auditOrphan records the deletion of an orphaned replica in the audit log,
+// as a request of the superuser
+func (nn *NameNode) auditOrphan(h BlockHeader) {
+	if nn.auditLog == nil {
+		return
+	}
+	rec := AuditRecord{
+		Time:    time.Now().UTC(),
+		User:    nn.superuser,
+		Command: CommandName(INVALIDATE),
+		Path:    h.Filename,
+		Arg:     "orphaned Block " + strconv.Itoa(h.BlockNum) + " on " + h.DatanodeID,
+		Result:  "ok",
+	}
+	err := nn.auditLog.write(rec)
+	if err != nil {
+		nn.log.Error("Could not write audit log", "file", nn.auditLog.path, "err", err)
+	}
+}
@@ -0,0 +1,83 @@
+package namenode
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrphanCollection(t *testing.T) {
+
+	nn := New()
+	nn.metadatafile = filepath.Join(t.TempDir(), "metadata.json")
+	nn.orphanGrace = time.Hour
+	nn.auditLog = newAuditLog(filepath.Join(t.TempDir(), "audit.log"), 1<<20, 2)
+	defer nn.auditLog.Close()
+	dn := &datanode{ID: "DN1"}
+	nn.datanodemap["DN1"] = dn
+
+	known := BlockHeader{"DN1", "/known.txt", 1, 0, 1, 0, "", 0, ""}
+	nn.MergeNode(known)
+	orphan := BlockHeader{"DN1", "/deleted.txt", 1, 0, 1, 0, "", 0, ""}
+	late := BlockHeader{"DN1", "/late.txt", 1, 0, 1, 0, "", 0, ""}
+	writing := BlockHeader{"DN1", "/writing.txt", 1, 0, 1, 0, "", 0, ""}
+	nn.leases["/writing.txt"] = &lease{Holder: "C1", Renewed: time.Now()}
+
+	nn.ApplyFullReport(dn, 1, []BlockHeader{known, orphan, late, writing})
+	if nn.lookup("/deleted.txt") != nil || nn.lookup("/late.txt") != nil {
+		t.Fatalf("Orphans merged into the namespace\n%s", nn.ListFiles())
+	}
+	if nn.lookup("/known.txt") == nil || nn.lookup("/writing.txt") == nil {
+		t.Fatalf("Replicas of known files held back\n%s", nn.ListFiles())
+	}
+	if len(nn.orphans) != 2 {
+		t.Fatalf("Found %d orphans", len(nn.orphans))
+	}
+
+	// nothing is deleted within the grace period
+	nn.collectOrphans(time.Now())
+	if len(nn.PendingInvalidations("DN1")) != 0 {
+		t.Errorf("Orphan deleted before its grace period passed")
+	}
+
+	// the writer's BLOCKACK arrives after the report
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2"}
+	nn.MergeNode(BlockHeader{"DN2", "/late.txt", 1, 0, 1, 0, "", 0, ""})
+	nn.collectOrphans(time.Now().Add(2 * time.Hour))
+	if blks, _ := nn.filemap.Get("/late.txt"); len(blks[0]) != 2 {
+		t.Errorf("Orphan of a file which appeared not merged %v", blks)
+	}
+	if pending := nn.PendingInvalidations("DN1"); len(pending) != 1 || pending[0] != orphan {
+		t.Fatalf("Pending invalidations %v", pending)
+	}
+	if len(nn.orphans) != 0 {
+		t.Errorf("Collected orphans kept %v", nn.orphans)
+	}
+
+	var recs []AuditRecord
+	ReadAudit(nn.auditLog.path, func(rec AuditRecord) bool {
+		recs = append(recs, rec)
+		return true
+	})
+	if len(recs) != 1 || recs[0].Command != "INVALIDATE" || recs[0].Path != "/deleted.txt" || !strings.Contains(recs[0].Arg, "DN1") {
+		t.Errorf("Audit trail %+v", recs)
+	}
+
+	// a replica the datanode no longer lists is forgotten
+	nn.ApplyFullReport(dn, 2, []BlockHeader{{"DN1", "/gone.txt", 1, 0, 1, 0, "", 0, ""}})
+	nn.ApplyFullReport(dn, 3, nil)
+	if len(nn.orphans) != 0 {
+		t.Errorf("Orphan missing from a report kept %v", nn.orphans)
+	}
+
+	// a namenode learning its files from block reports adopts them
+	fresh := New()
+	fresh.orphanGrace = time.Hour
+	fdn := &datanode{ID: "DN1"}
+	fresh.datanodemap["DN1"] = fdn
+	fresh.ApplyFullReport(fdn, 1, []BlockHeader{orphan})
+	if fresh.lookup("/deleted.txt") == nil {
+		t.Errorf("Namenode without a saved namespace held back a replica")
+	}
+}
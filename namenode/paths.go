@@ -0,0 +1,82 @@
+package namenode
+
+import (
+	"strconv"
+	"strings"
+)
+
+// default limits on the paths of the namespace
+const (
+	defaultMaxPathDepth       = 1000 // components of a path
+	defaultMaxComponentLength = 255  // bytes of a component
+)
+
+// cleanPath returns the canonical form of the path p, with a single slash
+// between components and none at the end. Paths which are not absolute, or
+// have . or .. components, NUL bytes, too many components or components too
+// long, are invalid.
+func (nn *NameNode) cleanPath(p string) (string, error) {
+	if !strings.HasPrefix(p, "/") {
+		return "", newError(ErrInvalidHeader, "Invalid path "+p+": not absolute")
+	}
+	parts := make([]string, 0, strings.Count(p, "/"))
+	for _, c := range strings.Split(p, "/") {
+		switch {
+		case c == "":
+			continue
+		case c == "." || c == "..":
+			return "", newError(ErrInvalidHeader, "Invalid path "+p+": "+c+" components are not allowed")
+		case strings.IndexByte(c, 0) >= 0:
+			return "", newError(ErrInvalidHeader, "Invalid path "+p+": NUL byte")
+		case len(c) > nn.maxComponentLength:
+			return "", newError(ErrInvalidHeader, "Invalid path "+p+": component longer than "+strconv.Itoa(nn.maxComponentLength)+" bytes")
+		}
+		parts = append(parts, c)
+	}
+	if len(parts) > nn.maxPathDepth {
+		return "", newError(ErrInvalidHeader, "Invalid path "+p+": deeper than "+strconv.Itoa(nn.maxPathDepth)+" components")
+	}
+	return "/" + strings.Join(parts, "/"), nil
+}
+
+// cleanPaths replaces each path named in the client request p with its
+// canonical form, returning an error for the first invalid one
+func (nn *NameNode) cleanPaths(p *Packet) error {
+	clean := func(headers []BlockHeader) ([]BlockHeader, error) {
+		cleaned := make([]BlockHeader, len(headers))
+		for i, h := range headers {
+			cleaned[i] = h
+			if h.Filename == "" {
+				continue
+			}
+			fname, err := nn.cleanPath(h.Filename)
+			if err != nil {
+				return nil, err
+			}
+			cleaned[i].Filename = fname
+		}
+		return cleaned, nil
+	}
+
+	var err error
+	if p.Headers != nil {
+		p.Headers, err = clean(p.Headers)
+		if err != nil {
+			return err
+		}
+	}
+	if p.Renamed != nil {
+		p.Renamed, err = clean(p.Renamed)
+		if err != nil {
+			return err
+		}
+	}
+	if p.CMD == DISTRIBUTE {
+		fname, err := nn.cleanPath(p.Data.Header.Filename)
+		if err != nil {
+			return err
+		}
+		p.Data.Header.Filename = fname
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package namenode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCleanPath(t *testing.T) {
+
+	nn := New()
+	nn.maxPathDepth = 3
+	nn.maxComponentLength = 8
+	for p, expected := range map[string]string{"/": "/", "//": "/", "/a/b/": "/a/b", "/a//b": "/a/b", "//a///b//c//": "/a/b/c"} {
+		if clean, err := nn.cleanPath(p); err != nil || clean != expected {
+			t.Errorf("Expected %s for %s, got %s %v", expected, p, clean, err)
+		}
+	}
+	for _, p := range []string{"", "a/b", "/a/../b", "/a/./b", "/..", "/a\x00b", "/a/b/c/d", "/longerthan8"} {
+		if _, err := nn.cleanPath(p); err == nil {
+			t.Errorf("Expected an error for %q", p)
+		}
+	}
+
+	// client requests name canonical paths, and are refused invalid ones
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: MKDIR, Flags: PARENTS, Headers: []BlockHeader{{Filename: "/dir//sub/"}}})
+	if n := nn.lookup("/dir/sub"); n == nil {
+		t.Fatalf("Directory /dir/sub not created")
+	}
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: MKDIR, Flags: PARENTS, Headers: []BlockHeader{{Filename: "/dir/../up"}}})
+	if nn.lookup("/up") != nil || nn.lookup("/dir/..") != nil {
+		t.Errorf("Directory created from an invalid path")
+	}
+	if e := nn.recentErrors.entries; len(e) == 0 || !strings.Contains(e[len(e)-1].Message, "Invalid path") {
+		t.Errorf("Invalid path not refused")
+	}
+
+	// block reports of invalid paths are refused
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	for _, p := range []string{"/dir/file/", "dir/file", "/dir//file", "/dir/../file"} {
+		if err := nn.MergeNode(BlockHeader{"DN1", p, 1, 0, 1, 1, "", 0, ""}); err == nil {
+			t.Errorf("Block of %s merged", p)
+		}
+	}
+	if err := nn.MergeNode(BlockHeader{"DN1", "/dir/file", 1, 0, 1, 1, "", 0, ""}); err != nil {
+		t.Errorf("%s", err)
+	}
+}
@@ -0,0 +1,231 @@
+package namenode
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// policies for a full queue
+const (
+	overflowBlock = "block" // wait for room in the queue
+	overflowDrop  = "drop"  // discard the item, counting it
+	overflowSpill = "spill" // write the item to disc, to be read back in order once the queue drains
+)
+
+// spillFile keeps the items which overflowed a queue on disc, in order. The
+// file is created by the first item spilled, and removed once every item has
+// been read back. The methods of a nil spillFile hold nothing.
+type spillFile struct {
+	mu      sync.Mutex
+	dir     string // directory the file is created in
+	file    *os.File
+	encoder *json.Encoder
+	decoder *json.Decoder // reads from a second handle on the file
+	reader  *os.File
+	pending int  // items written but not read back
+	closed  bool // discarded, so nothing more is kept
+}
+
+func newSpillFile(dir string) *spillFile {
+	return &spillFile{dir: dir}
+}
+
+// push appends an item to the file
+func (s *spillFile) push(v interface{}) error {
+	if s == nil {
+		return errors.New("Queue does not spill")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("Spill file closed")
+	}
+	if s.file == nil {
+		f, err := os.CreateTemp(s.dir, "godfs-*.spill")
+		if err != nil {
+			return err
+		}
+		r, err := os.Open(f.Name())
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return err
+		}
+		s.file, s.reader = f, r
+		s.encoder, s.decoder = json.NewEncoder(f), json.NewDecoder(r)
+	}
+	err := s.encoder.Encode(v)
+	if err != nil {
+		return err
+	}
+	s.pending++
+	return nil
+}
+
+// pop reads back the oldest item into v, reporting whether there was one.
+// If it cannot be read the remaining items are discarded.
+func (s *spillFile) pop(v interface{}) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending == 0 {
+		return false, nil
+	}
+	err := s.decoder.Decode(v)
+	if err != nil {
+		s.reset()
+		return false, err
+	}
+	s.pending--
+	if s.pending == 0 {
+		s.reset()
+	}
+	return true, nil
+}
+
+// len returns the number of items waiting on disc
+func (s *spillFile) len() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// discard removes the file and any items still in it, returning how many
+// were lost
+func (s *spillFile) discard() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lost := s.pending
+	s.reset()
+	s.closed = true
+	return lost
+}
+
+// reset removes the file. The caller must hold mu.
+func (s *spillFile) reset() {
+	if s.file != nil {
+		s.file.Close()
+		s.reader.Close()
+		os.Remove(s.file.Name())
+	}
+	s.file, s.reader, s.encoder, s.decoder = nil, nil, nil, nil
+	s.pending = 0
+}
+
+// wake signals a goroutine waiting on ch without blocking
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// spillPacket writes a packet for a full outbound queue to disc
+func (nn *NameNode) spillPacket(ob *outbound, p Packet) error {
+	err := ob.spill.push(p)
+	if err != nil {
+		atomic.AddInt64(&ob.dropped, 1)
+		ob.log.Warn("Could not spill packet, dropping it", "err", err, nn.packetAttr(p))
+		return err
+	}
+	atomic.AddInt64(&ob.spilled, 1)
+	wake(ob.wake)
+	return nil
+}
+
+// next returns the next packet to send, taking the packets spilled to disc
+// once those queued before them are sent. It returns false once the
+// connection is replaced or dropped, or the closed queue and its spill file
+// are empty.
+func (ob *outbound) next() (Packet, bool) {
+	for {
+		var p Packet
+		if len(ob.queue) == 0 {
+			ok, err := ob.spill.pop(&p)
+			if err != nil {
+				ob.log.Error("Could not read spilled packets, dropping them", "err", err)
+			}
+			if ok {
+				return p, true
+			}
+		}
+		select {
+		case q, ok := <-ob.queue:
+			if ok {
+				return q, true
+			}
+			if ok, _ := ob.spill.pop(&p); ok {
+				return p, true
+			}
+			close(ob.flushed)
+			return Packet{}, false
+		case <-ob.wake:
+		case <-ob.done:
+			// the packets left are taken by retire
+			return Packet{}, false
+		}
+	}
+}
+
+// enqueueHeader queues the header of a BLOCKACK to be merged, applying the
+// overflow policy when the queue is full
+func (nn *NameNode) enqueueHeader(h BlockHeader) {
+	if nn.headerSpill.len() > 0 {
+		// keep the order behind the headers already spilled
+		nn.spillHeader(h)
+		return
+	}
+	select {
+	case nn.headerChannel <- h:
+		return
+	default:
+	}
+
+	switch nn.queueOverflow {
+	case overflowDrop:
+		atomic.AddInt64(&nn.headerDropped, 1)
+		nn.metaLog.Warn("Header queue full, dropping header until the next block report", "header", h)
+	case overflowSpill:
+		nn.spillHeader(h)
+	default:
+		// the handler holds the namespace, which the merger needs to make
+		// room, so it merges the header itself
+		atomic.AddInt64(&nn.headerStalls, 1)
+		nn.mergeReported(h)
+	}
+}
+
+// spillHeader writes a header for the full header queue to disc
+func (nn *NameNode) spillHeader(h BlockHeader) {
+	err := nn.headerSpill.push(h)
+	if err != nil {
+		atomic.AddInt64(&nn.headerDropped, 1)
+		nn.metaLog.Warn("Could not spill header, dropping it until the next block report", "header", h, "err", err)
+		return
+	}
+	atomic.AddInt64(&nn.headerSpilled, 1)
+	wake(nn.headerWake)
+}
+
+// popHeader reads back the oldest header spilled to disc
+func (nn *NameNode) popHeader() (BlockHeader, bool) {
+	var h BlockHeader
+	ok, err := nn.headerSpill.pop(&h)
+	if err != nil {
+		nn.metaLog.Error("Could not read spilled headers, dropping them until the next block report", "err", err)
+	}
+	return h, ok
+}
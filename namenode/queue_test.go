@@ -0,0 +1,100 @@
+package namenode
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSpillFile(t *testing.T) {
+
+	dir := t.TempDir()
+	s := newSpillFile(dir)
+	for i := 0; i < 3; i++ {
+		if err := s.push(BlockHeader{"DN1", "/f", 1, i, 3, 1, "", 0, ""}); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		var h BlockHeader
+		if ok, err := s.pop(&h); !ok || err != nil || h.BlockNum != i {
+			t.Fatalf("Expected Block %d, got %v %s", i, h, err)
+		}
+	}
+	if ok, _ := s.pop(&BlockHeader{}); ok || s.len() != 0 {
+		t.Errorf("Item read back twice")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Spill file kept once drained")
+	}
+}
+
+func TestQueueOverflow(t *testing.T) {
+
+	nn := New()
+	nn.sendQueueSize = 1
+	nn.queueOverflow = overflowDrop
+	slow, _ := net.Pipe()
+	nn.SetOutbound("DN1", slow)
+
+	// the peer never reads, so packets beyond the one being written and the
+	// one queued are dropped rather than blocking the sender
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 5; i++ {
+			nn.SendPacket(Packet{SRC: "NN", DST: "DN1", CMD: HB})
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Sender blocked on a full queue")
+	}
+	if st := nn.SendStats()[0]; st.Dropped < 3 || st.Stalls != 0 {
+		t.Errorf("Expected dropped packets, got %v", st)
+	}
+
+	nn.queueOverflow = overflowSpill
+	nn.spillDir = t.TempDir()
+	local, peer := net.Pipe()
+	nn.SetOutbound("DN2", local)
+	for i := 0; i < 5; i++ {
+		nn.SendPacket(Packet{SRC: "NN", DST: "DN2", CMD: HB, ReportID: int64(i)})
+	}
+	if st := nn.SendStats()[1]; st.Spilled < 3 || st.OnDisc == 0 {
+		t.Errorf("Expected spilled packets, got %v", st)
+	}
+	decoder := json.NewDecoder(peer)
+	for i := 0; i < 5; i++ {
+		var p Packet
+		decoder.Decode(&p)
+		if p.ReportID != int64(i) {
+			t.Fatalf("Expected packet %d, got %d", i, p.ReportID)
+		}
+	}
+}
+
+func TestHeaderQueueSpill(t *testing.T) {
+
+	nn := New()
+	nn.headerChannel = make(chan BlockHeader, 1)
+	nn.queueOverflow = overflowSpill
+	nn.headerSpill = newSpillFile(t.TempDir())
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+
+	for num := 0; num < 4; num++ {
+		nn.enqueueHeader(BlockHeader{"DN1", "/f", 1, num, 4, 1, "", 0, ""})
+	}
+	if nn.headerSpilled != 3 {
+		t.Errorf("Expected 3 spilled headers, got %d", nn.headerSpilled)
+	}
+	close(nn.quit)
+	nn.HandleBlockHeaders()
+	blocks, _ := nn.filemap.Get("/f")
+	if len(blocks) != 4 || nn.headerSpill.len() != 0 {
+		t.Errorf("Expected every queued header merged, got %d Blocks", len(blocks))
+	}
+}
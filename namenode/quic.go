@@ -0,0 +1,131 @@
+//go:build quic
+
+package namenode
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/quic-go/quic-go"
+	"github.com/sjarvie/godfs/quicconn"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// time a QUIC connection has to open its stream once it is accepted
+const quicStreamTimeout = 10 * time.Second
+
+// quicListener accepts QUIC connections once they open their stream
+type quicListener struct {
+	transport *quic.Transport
+	ln        *quic.Listener
+	conns     chan net.Conn
+	done      chan struct{}
+	once      sync.Once
+	open      sync.WaitGroup // connections accepted and not yet closed
+}
+
+// listenQUIC listens for QUIC connections on the UDP port of address. The
+// namenode has no certificate of its own, so it makes a new self-signed
+// one each time it starts: connections are encrypted but, as over TCP, the
+// namenode is not authenticated.
+func listenQUIC(address string) (net.Listener, error) {
+	cert, err := selfSignedCertificate()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{quicconn.Protocol}}
+	udp, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	transport := &quic.Transport{Conn: udp}
+	ln, err := transport.Listen(tlsConfig, quicconn.Config)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+	l := &quicListener{transport: transport, ln: ln, conns: make(chan net.Conn), done: make(chan struct{})}
+	go l.serve()
+	return l, nil
+}
+
+// serve accepts QUIC connections and waits for each to open its stream, so
+// that a connection which does not keeps no other from being accepted.
+// Connections outlive the listener, as they do over TCP, so its socket is
+// closed once they are.
+func (l *quicListener) serve() {
+	defer func() {
+		l.Close()
+		l.open.Wait()
+		l.transport.Close()
+	}()
+	for {
+		c, err := l.ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		l.open.Add(1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), quicStreamTimeout)
+			defer cancel()
+			s, err := c.AcceptStream(ctx)
+			if err != nil {
+				c.CloseWithError(0, "no stream opened")
+				l.open.Done()
+				return
+			}
+			select {
+			case l.conns <- &quicconn.Conn{Stream: s, Session: c, Closed: l.open.Done}:
+			case <-l.done:
+				c.CloseWithError(0, "namenode shut down")
+				l.open.Done()
+			}
+		}()
+	}
+}
+
+func (l *quicListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *quicListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.ln.Close()
+}
+
+func (l *quicListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// selfSignedCertificate makes a certificate for QUIC's TLS handshake
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "godfs namenode"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
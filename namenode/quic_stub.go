@@ -0,0 +1,14 @@
+//go:build !quic
+
+package namenode
+
+import (
+	"github.com/sjarvie/godfs/quicconn"
+	"net"
+)
+
+// listenQUIC reports that QUIC support was not built in. Build with -tags
+// quic to enable it.
+func listenQUIC(address string) (net.Listener, error) {
+	return nil, quicconn.ErrNotBuilt
+}
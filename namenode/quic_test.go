@@ -0,0 +1,58 @@
+//go:build quic
+
+package namenode
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/quic-go/quic-go"
+	"github.com/sjarvie/godfs/quicconn"
+	"testing"
+	"time"
+)
+
+func TestQUIC(t *testing.T) {
+
+	nn := New()
+	l, err := listenQUIC("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	go nn.ServeQUIC(l)
+	defer nn.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tlsConfig := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{quicconn.Protocol}}
+	c, err := quic.DialAddr(ctx, l.Addr().String(), tlsConfig, quicconn.Config)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	s, err := c.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	conn := &quicconn.Conn{Stream: s, Session: c}
+	defer conn.Close()
+
+	// nodes speak the protocol over the stream as they do over TCP
+	hello := Hello{Version: 2, MinVersion: 2, Codecs: []string{"msgpack"}}
+	go newFrameEncoder(conn).Encode(Packet{SRC: "C1", DST: "NN", CMD: HELLO, Hello: &hello})
+	decoder := newFrameDecoder(conn)
+	var r Packet
+	if err := decoder.Decode(&r); err != nil || r.CMD != HELLO || r.Hello.Codec != "msgpack" {
+		t.Fatalf("Expected a HELLO over QUIC, got %v %v", r, err)
+	}
+	decodedWith(decoder, r.Hello.Codec)
+	go (&frameEncoder{conn, codecVersion("msgpack")}).Encode(Packet{SRC: "C1", DST: "NN", CMD: LIST, RequestID: 1})
+	if err := decoder.Decode(&r); err != nil || r.RequestID != 1 {
+		t.Fatalf("No answer over QUIC %v %v", r, err)
+	}
+
+	nn.mu.Lock()
+	open := len(nn.conns)
+	nn.mu.Unlock()
+	if open != 1 {
+		t.Errorf("Expected the QUIC connection to be counted, got %d", open)
+	}
+}
@@ -0,0 +1,72 @@
+package namenode
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// SetQuota limits the number of files and bytes of file data below the
+// directory at path. A limit of 0 removes it. Quotas may be set below the
+// current usage, which only prevents further growth.
+func (nn *NameNode) SetQuota(path string, files int, space int64) error {
+	if files < 0 || space < 0 {
+		return errors.New("Quotas must not be negative")
+	}
+	n := nn.lookup(path)
+	if n == nil {
+		return errors.New("No such directory " + path)
+	}
+	if nn.isFile(n) {
+		return errors.New("Quotas can only be set on directories " + path)
+	}
+
+	n.fileQuota = files
+	n.spaceQuota = space
+	if n != nn.root {
+		// keep the directory and its quota when it is emptied
+		n.explicit = true
+	}
+	nn.metaLog.Info("Set quota", "path", path, "files", files, "space", space)
+	return nil
+}
+
+// usage counts the files and bytes of file data below a filenode
+func (nn *NameNode) usage(n *filenode) (files int, space int64) {
+	nn.walk(n, func(c *filenode) {
+		if nn.isFile(c) {
+			files++
+			space += nn.fileStatus(c).Size
+		}
+	})
+	return files, space
+}
+
+// checkQuota returns an error if adding size bytes to the file at path, and
+// a file if newFile is set, would exceed the quota of a directory above it
+func (nn *NameNode) checkQuota(path string, newFile bool, size int64) error {
+	path_arr := strings.Split(path, "/")
+	for i := len(path_arr) - 1; i > 0; i-- {
+		dir := strings.Join(path_arr[0:i], "/")
+		if dir == "" {
+			dir = "/"
+		}
+		n := nn.lookup(dir)
+		if n == nil {
+			continue
+		}
+		fileQuota, spaceQuota := nn.quotaOf(n)
+		if fileQuota == 0 && spaceQuota == 0 {
+			continue
+		}
+
+		files, space := nn.usage(n)
+		if newFile && fileQuota > 0 && files+1 > fileQuota {
+			return errors.New("File quota of " + dir + " exceeded, limit " + strconv.Itoa(fileQuota))
+		}
+		if spaceQuota > 0 && space+size > spaceQuota {
+			return errors.New("Space quota of " + dir + " exceeded, limit " + strconv.FormatInt(spaceQuota, 10) + " bytes")
+		}
+	}
+	return nil
+}
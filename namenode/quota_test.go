@@ -0,0 +1,81 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestFileQuota(t *testing.T) {
+
+	nn := New()
+	nn.sizeofblock = 4
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.Mkdir("/q", false)
+
+	err := nn.SetQuota("/q", 1, 0)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	_, err = nn.AssignBlock(Block{BlockHeader{"", "/q/a.txt", 1, 0, 1, 0, "", 0, ""}, []byte{0}})
+	if err != nil {
+		t.Errorf("Rejected file within quota %s", err)
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/q/a.txt", 1, 0, 1, 0, "", 0, ""})
+
+	_, err = nn.AssignBlock(Block{BlockHeader{"", "/q/b.txt", 1, 0, 1, 0, "", 0, ""}, []byte{0}})
+	if err == nil {
+		t.Errorf("Accepted file beyond file quota")
+	}
+
+	// Blocks written past the quota are invalidated
+	h := BlockHeader{"DN1", "/q/c.txt", 1, 0, 1, 0, "", 0, ""}
+	if nn.MergeNode(h) == nil {
+		t.Errorf("Merged file beyond file quota")
+	}
+	if !nn.isInvalidated(h) {
+		t.Errorf("Block beyond quota was not invalidated")
+	}
+
+	st, err := nn.Stat("/q")
+	if err != nil || st.FileQuota != 1 || st.FileCount != 1 || st.SpaceUsed != 1 {
+		t.Errorf("Unexpected quota status %v %v", st, err)
+	}
+}
+
+func TestSpaceQuota(t *testing.T) {
+
+	nn := New()
+	nn.sizeofblock = 4
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.Mkdir("/q/sub", true)
+	nn.SetQuota("/q", 0, 10)
+
+	// a new file reserves whole Blocks
+	_, err := nn.AssignBlock(Block{BlockHeader{"", "/q/sub/big.txt", 4, 0, 3, 0, "", 0, ""}, []byte{0, 0, 0, 0}})
+	if err == nil {
+		t.Errorf("Accepted file beyond space quota")
+	}
+	_, err = nn.AssignBlock(Block{BlockHeader{"", "/q/sub/a.txt", 4, 0, 2, 0, "", 0, ""}, []byte{0, 0, 0, 0}})
+	if err != nil {
+		t.Errorf("Rejected file within space quota %s", err)
+	}
+
+	nn.MergeNode(BlockHeader{"DN1", "/q/sub/a.txt", 4, 0, 2, 0, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/q/sub/a.txt", 4, 1, 2, 0, "", 0, ""})
+	if nn.MergeNode(BlockHeader{"DN1", "/q/b.txt", 4, 0, 1, 0, "", 0, ""}) == nil {
+		t.Errorf("Merged Block beyond space quota")
+	}
+
+	// further replicas do not count against the quota
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", listed: true}
+	if err := nn.MergeNode(BlockHeader{"DN2", "/q/sub/a.txt", 4, 0, 2, 0, "", 0, ""}); err != nil {
+		t.Errorf("Rejected replica %s", err)
+	}
+
+	if nn.SetQuota("/q/sub/a.txt", 1, 0) == nil {
+		t.Errorf("Set quota on a file")
+	}
+	if nn.SetQuota("/q", -1, 0) == nil {
+		t.Errorf("Set negative quota")
+	}
+}
@@ -0,0 +1,38 @@
+package namenode
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter allows requests at a steady rate, with bursts of up to a
+// second's worth, or of one request, after a quiet spell
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   float64 // requests allowed per second
+	tokens float64 // requests allowed now
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter allowing rate requests per second
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: math.Max(rate, 1), last: time.Now()}
+}
+
+// allow reports whether a request may be made now, taking its place if so
+func (l *rateLimiter) allow() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if burst := math.Max(l.rate, 1); l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,78 @@
+package namenode
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// longest datanode ID accepted in a REGISTER
+const maxDatanodeIDLength = 128
+
+// readRegistration reads the REGISTER a datanode which agreed to the
+// register feature sends once its HELLO is answered
+func (nn *NameNode) readRegistration(decoder packetDecoder) (Packet, error) {
+	var p Packet
+	err := decoder.Decode(&p)
+	for isBadFrame(err) {
+		nn.connLog.Warn("Skipped frame", "src", p.SRC, "err", err)
+		err = decoder.Decode(&p)
+	}
+	if err != nil {
+		return p, err
+	}
+	if p.CMD != REGISTER {
+		return p, fmt.Errorf("Datanode sent %s rather than REGISTER after its HELLO", CommandName(p.CMD))
+	}
+	return p, nil
+}
+
+// register returns the ID a datanode connecting from host is known by. A
+// datanode without an ID, named in Message, is given a new UUID, which it
+// keeps on its disc and registers with from then on. An ID is refused while a
+// datanode connected from another host holds it, so two datanodes never merge.
+func (nn *NameNode) register(p Packet, host string) (string, error) {
+	id := p.Message
+	if id == "" {
+		for {
+			id = newDatanodeID()
+			if _, ok := nn.datanodemap[id]; !ok {
+				break
+			}
+		}
+		nn.connLog.Info("Assigned datanode ID", "datanode", id, "host", host)
+		return id, nil
+	}
+
+	if err := nn.validDatanodeID(id); err != nil {
+		return "", err
+	}
+	if dn, ok := nn.datanodemap[id]; ok && dn.conn != nil && dn.host != host {
+		return "", fmt.Errorf("Datanode %s is already registered from %s", id, dn.host)
+	}
+	return id, nil
+}
+
+// validDatanodeID returns an error if id cannot name a datanode
+func (nn *NameNode) validDatanodeID(id string) error {
+	if len(id) > maxDatanodeIDLength {
+		return fmt.Errorf("Datanode ID longer than %d bytes", maxDatanodeIDLength)
+	}
+	if id == "C" || id == nn.id {
+		return fmt.Errorf("Datanode ID %s names a client or the namenode", id)
+	}
+	if strings.IndexFunc(id, func(r rune) bool { return unicode.IsSpace(r) || !unicode.IsPrint(r) }) >= 0 {
+		return fmt.Errorf("Datanode ID %q has spaces or unprintable characters", id)
+	}
+	return nil
+}
+
+// newDatanodeID returns a random version 4 UUID
+func newDatanodeID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,65 @@
+package namenode
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRegister(t *testing.T) {
+
+	nn := New()
+	local, remote := net.Pipe()
+	go nn.HandleConnection(remote)
+	encoder := json.NewEncoder(local)
+	decoder := json.NewDecoder(local)
+
+	// a new datanode is given an ID in answer to its REGISTER
+	go encoder.Encode(Packet{SRC: "", DST: "NN", CMD: HELLO, Hello: &Hello{2, 2, "0.3.0", []string{"register"}, 0, 0, nil, ""}})
+	var r Packet
+	if err := decoder.Decode(&r); err != nil || r.CMD != HELLO {
+		t.Fatalf("Wrong answer to a HELLO %v %v", r, err)
+	}
+	go encoder.Encode(Packet{SRC: "", DST: "NN", CMD: REGISTER})
+	if err := decoder.Decode(&r); err != nil || r.CMD != REGISTER || len(r.Message) != 36 {
+		t.Fatalf("Wrong answer to a REGISTER %v %v", r, err)
+	}
+	id := r.Message
+	var dn *datanode
+	nn.view(func() { dn = nn.datanodemap[id] })
+	if dn == nil || dn.conn == nil {
+		t.Fatalf("Registered datanode %s not added", id)
+	}
+
+	// the ID cannot be taken from another host while the datanode is connected
+	var err error
+	nn.update(func() { _, err = nn.register(Packet{Message: id}, "10.0.0.2") })
+	if err == nil {
+		t.Errorf("Duplicate registration from another host accepted")
+	}
+	nn.update(func() { _, err = nn.register(Packet{Message: id}, dn.host) })
+	if err != nil {
+		t.Errorf("Registration from the same host refused %s", err)
+	}
+	for _, bad := range []string{"C", "DN 1", "DN\x00"} {
+		nn.update(func() { _, err = nn.register(Packet{Message: bad}, "10.0.0.2") })
+		if err == nil {
+			t.Errorf("Datanode ID %q accepted", bad)
+		}
+	}
+
+	// once disconnected, a datanode which moved may register from its new host
+	local.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		nn.update(func() { _, err = nn.register(Packet{Message: id}, "10.0.0.2") })
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Errorf("Disconnected datanode not registered from its new host %s", err)
+	}
+}
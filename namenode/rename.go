@@ -0,0 +1,217 @@
+package namenode
+
+import (
+	"errors"
+	"strings"
+)
+
+// renameOrder asks a datanode to move a stored Block to a new header
+type renameOrder struct {
+	From BlockHeader
+	To   BlockHeader
+}
+
+// Rename moves the file or directory at src to dst, whose parent directory
+// must exist. The namespace changes at once, while the datanodes holding the
+// moved Blocks are told to rename them, and reminded on each heartbeat until
+// they acknowledge it.
+func (nn *NameNode) Rename(src, dst string) error {
+	n := nn.lookup(src)
+	if n == nil {
+		return newError(ErrFileNotFound, "No such file or directory "+src)
+	}
+	if n == nn.root {
+		return errors.New("Cannot rename the root directory")
+	}
+	if nn.hasSnapshots(src) {
+		return errors.New("Cannot move a directory with snapshots " + src)
+	}
+	if !strings.HasPrefix(dst, "/") || dst == "/" {
+		return errors.New("Invalid destination " + dst)
+	}
+	if nn.lookup(dst) != nil {
+		return errors.New("File exists " + dst)
+	}
+	if strings.HasPrefix(dst, src+"/") {
+		return errors.New("Cannot move " + src + " into itself")
+	}
+
+	i := strings.LastIndex(dst, "/")
+	parentPath := dst[:i]
+	if parentPath == "" {
+		parentPath = "/"
+	}
+	parent := nn.lookup(parentPath)
+	if parent == nil || nn.isFile(parent) {
+		return errors.New("No such directory " + parentPath)
+	}
+
+	files, space := nn.usage(n)
+	err := nn.checkRenameQuota(parentPath, src, files, space)
+	if err != nil {
+		return err
+	}
+
+	// detach from the old parent, pruning directories left empty
+	old := n.parent
+	for j, c := range old.children {
+		if c == n {
+			old.children = append(old.children[:j], old.children[j+1:]...)
+			break
+		}
+	}
+	n.parent = parent
+	parent.children = append(parent.children, n)
+	now := nowMillis()
+	old.mtime, parent.mtime = now, now
+
+	orders := make(map[string][]renameOrder)
+	nn.walk(n, func(c *filenode) {
+		from := c.path
+		c.path = dst + strings.TrimPrefix(c.path, src)
+
+		blocks, ok := nn.filemap.Get(from)
+		if !ok {
+			return
+		}
+		nn.filemap.Delete(from)
+		for num, replicas := range blocks {
+			moved := make([]BlockHeader, len(replicas))
+			for k, h := range replicas {
+				moved[k] = h
+				moved[k].Filename = c.path
+				orders[h.DatanodeID] = append(orders[h.DatanodeID], renameOrder{h, moved[k]})
+			}
+			blocks[num] = moved
+		}
+		nn.filemap.Put(c.path, blocks)
+	})
+	for old != nn.root && !old.explicit && len(old.children) == 0 {
+		next := old.parent
+		next.mtime = now
+		for j, c := range next.children {
+			if c == old {
+				next.children = append(next.children[:j], next.children[j+1:]...)
+				break
+			}
+		}
+		old = next
+	}
+
+	nn.renameErasure(src, dst)
+	nn.renameArchives(src, dst)
+	nn.renameKeys(src, dst)
+	nn.renameLayouts(src, dst)
+	for id, list := range orders {
+		nn.renameInSnapshots(list)
+		nn.renameBlocks(id, list)
+	}
+	nn.metaLog.Info("Renamed", "src", src, "dst", dst)
+	return nil
+}
+
+// checkRenameQuota checks the quotas of the directories above dir which do
+// not already hold src
+func (nn *NameNode) checkRenameQuota(dir, src string, files int, space int64) error {
+	for {
+		if !(dir == "/" || strings.HasPrefix(src, dir+"/")) {
+			n := nn.lookup(dir)
+			fileQuota, spaceQuota := nn.quotaOf(n)
+			if fileQuota > 0 || spaceQuota > 0 {
+				used, size := nn.usage(n)
+				if fileQuota > 0 && used+files > fileQuota {
+					return errors.New("File quota of " + dir + " exceeded")
+				}
+				if spaceQuota > 0 && size+space > spaceQuota {
+					return errors.New("Space quota of " + dir + " exceeded")
+				}
+			}
+		}
+		if dir == "/" {
+			return nil
+		}
+		i := strings.LastIndex(dir, "/")
+		dir = dir[:i]
+		if dir == "" {
+			dir = "/"
+		}
+	}
+}
+
+// renameBlocks records renames for a datanode and sends them straight away,
+// so they reach it ahead of any new Blocks written under the old names
+func (nn *NameNode) renameBlocks(datanodeID string, list []renameOrder) {
+	nn.renameLock.Lock()
+	nn.renames[datanodeID] = append(nn.renames[datanodeID], list...)
+	nn.renameLock.Unlock()
+
+	if !nn.offline[datanodeID] {
+		nn.SendPacket(nn.renamePacket(datanodeID))
+	}
+}
+
+// renamePacket lists the renames pending on a datanode
+func (nn *NameNode) renamePacket(datanodeID string) Packet {
+	p := Packet{SRC: nn.id, DST: datanodeID, CMD: RENAME}
+	for _, o := range nn.PendingRenames(datanodeID) {
+		p.Headers = append(p.Headers, o.From)
+		p.Renamed = append(p.Renamed, o.To)
+	}
+	return p
+}
+
+// PendingRenames returns the renames waiting to be applied by a datanode
+func (nn *NameNode) PendingRenames(datanodeID string) []renameOrder {
+	nn.renameLock.Lock()
+	defer nn.renameLock.Unlock()
+
+	pending := nn.renames[datanodeID]
+	list := make([]renameOrder, len(pending))
+	copy(list, pending)
+	return list
+}
+
+// isRenamed reports whether a replica is waiting to be renamed, in which case
+// a report of it under its old name must not be merged
+func (nn *NameNode) isRenamed(h BlockHeader) bool {
+	nn.renameLock.Lock()
+	defer nn.renameLock.Unlock()
+	for _, o := range nn.renames[h.DatanodeID] {
+		if o.From == h {
+			return true
+		}
+	}
+	return false
+}
+
+// isRenameTarget reports whether a replica is the new header of a pending
+// rename, which its datanode cannot report yet
+func (nn *NameNode) isRenameTarget(h BlockHeader) bool {
+	nn.renameLock.Lock()
+	defer nn.renameLock.Unlock()
+	for _, o := range nn.renames[h.DatanodeID] {
+		if o.To == h {
+			return true
+		}
+	}
+	return false
+}
+
+// CompleteRename handles a datanode's confirmation that Blocks were renamed
+func (nn *NameNode) CompleteRename(datanodeID string, headers []BlockHeader) {
+	nn.renameLock.Lock()
+	defer nn.renameLock.Unlock()
+
+	pending := nn.renames[datanodeID]
+	remaining := make([]renameOrder, 0, len(pending))
+	for _, o := range pending {
+		if !ContainsHeader(headers, o.From) {
+			remaining = append(remaining, o)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(nn.renames, datanodeID)
+	} else {
+		nn.renames[datanodeID] = remaining
+	}
+}
@@ -0,0 +1,221 @@
+package namenode
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// default time between checks of the files whose replication is being changed
+const defaultReplicationInterval = 10 * time.Second
+
+// SetLayout gives the file at path, which holder is about to write, its own
+// block size and replication factor, where they are not 0
+func (nn *NameNode) SetLayout(path, holder string, blockSize, replication int) error {
+	if blockSize != 0 && blockSize < 4096 {
+		return errors.New("Buffer size must be greater than or equal to 4096 bytes")
+	}
+	if replication < 0 {
+		return errors.New("Replication must be at least 1")
+	}
+	err := nn.checkLease(path, holder)
+	if err != nil {
+		return err
+	}
+	if _, ok := nn.filemap.Get(path); ok {
+		return errors.New("Cannot change the block size of a written file " + path)
+	}
+	if blockSize == 0 && replication == 0 {
+		delete(nn.layouts, path)
+		return nil
+	}
+	nn.layouts[path] = FileStatus{Path: path, BlockSize: blockSize, Replicas: replication}
+	nn.metaLog.Debug("Set file layout", "path", path, "blocksize", blockSize, "replication", replication)
+	return nil
+}
+
+// blockSize is the size of the Blocks of the file at path
+func (nn *NameNode) blockSize(path string) int {
+	if l, ok := nn.layouts[path]; ok && l.BlockSize > 0 {
+		return l.BlockSize
+	}
+	return nn.sizeofblock
+}
+
+// SetReplication changes the replication factor of the file at path, or of
+// every replicated file below the directory at path, to n. Blocks are copied
+// or their excess replicas deleted in the background. It returns the number
+// of files changed.
+func (nn *NameNode) SetReplication(path string, n int) (int, error) {
+	if n < 1 {
+		return 0, errors.New("Replication must be at least 1")
+	}
+	node := nn.lookup(path)
+	if node == nil {
+		return 0, newError(ErrFileNotFound, "File not found "+path)
+	}
+	if _, ok := nn.erasure[path]; ok {
+		return 0, errors.New("Cannot change the replication of an erasure coded file " + path)
+	}
+
+	changed := 0
+	nn.walk(node, func(c *filenode) {
+		if !nn.isFile(c) {
+			return
+		}
+		if _, ok := nn.erasure[c.path]; ok {
+			return
+		}
+		l, ok := nn.layouts[c.path]
+		if !ok {
+			l = FileStatus{Path: c.path}
+		}
+		l.Replicas = n
+		nn.layouts[c.path] = l
+		nn.queueReplication(c.path)
+		changed++
+	})
+	nn.metaLog.Info("Set replication", "path", path, "replication", n, "files", changed)
+	return changed, nil
+}
+
+// renameLayouts moves the layouts of the files at or below src to dst
+func (nn *NameNode) renameLayouts(src, dst string) {
+	for path, l := range nn.layouts {
+		if path == src || strings.HasPrefix(path, src+"/") {
+			delete(nn.layouts, path)
+			l.Path = dst + strings.TrimPrefix(path, src)
+			nn.layouts[l.Path] = l
+			nn.queueReplication(l.Path)
+		}
+	}
+}
+
+// queueReplication has the replication of the file at path checked until
+// each of its Blocks has as many replicas as it needs
+func (nn *NameNode) queueReplication(path string) {
+	nn.replicationQueueLock.Lock()
+	defer nn.replicationQueueLock.Unlock()
+	nn.replicationQueue[path] = true
+}
+
+// checkReplication copies the Blocks of the file at path which have fewer
+// replicas than it needs, and invalidates the replicas beyond them. It
+// returns the number of Blocks still short of replicas.
+func (nn *NameNode) checkReplication(path string) int {
+	blocks, ok := nn.filemap.Get(path)
+	if !ok {
+		return 0
+	}
+	needed := nn.neededReplicas(path)
+	remaining := 0
+	for _, replicas := range blocks {
+		kept := nn.keptReplicas(replicas)
+		switch {
+		case len(kept) == 0:
+		case len(kept) < needed:
+			remaining++
+			// no datanode can take a copy until another joins
+			if nn.chooseTarget(kept) != nil {
+				nn.replicate(kept, kept[0])
+			}
+		case len(kept) > needed:
+			for _, h := range nn.excessReplicas(kept, len(kept)-needed) {
+				nn.placementLog.Debug("Deleting excess replica", "file", h.Filename, "block", h.BlockNum, "datanode", h.DatanodeID)
+				nn.Invalidate(h)
+			}
+		}
+	}
+	return remaining
+}
+
+// keptReplicas leaves out the replicas which are being deleted
+func (nn *NameNode) keptReplicas(replicas []BlockHeader) []BlockHeader {
+	kept := make([]BlockHeader, 0, len(replicas))
+	for _, h := range replicas {
+		if !nn.isInvalidated(h) {
+			kept = append(kept, h)
+		}
+	}
+	return kept
+}
+
+// excessReplicas chooses n of replicas to delete: those on decommissioning
+// or offline datanodes first, then those on racks holding several replicas,
+// so as few racks as possible are lost, and then the most used datanodes
+func (nn *NameNode) excessReplicas(replicas []BlockHeader, n int) []BlockHeader {
+	left := make([]BlockHeader, len(replicas))
+	copy(left, replicas)
+	excess := make([]BlockHeader, 0, n)
+	for ; n > 0 && len(left) > 0; n-- {
+		racks := make(map[string]int)
+		for _, h := range left {
+			if dn, ok := nn.datanodemap[h.DatanodeID]; ok {
+				racks[nn.datanodeRack(dn)]++
+			}
+		}
+		rank := func(h BlockHeader) (bool, bool, int64) {
+			dn, ok := nn.datanodemap[h.DatanodeID]
+			if !ok {
+				return true, true, 0
+			}
+			return dn.decommissioning || nn.offline[dn.ID], racks[nn.datanodeRack(dn)] > 1, dn.size
+		}
+		sort.SliceStable(left, func(i, j int) bool {
+			gi, si, ui := rank(left[i])
+			gj, sj, uj := rank(left[j])
+			if gi != gj {
+				return gi
+			}
+			if si != sj {
+				return si
+			}
+			return ui > uj
+		})
+		excess = append(excess, left[0])
+		left = left[1:]
+	}
+	return excess
+}
+
+// MonitorReplication periodically checks the queued files until their
+// Blocks have the replicas they need, and every second starts the copies of
+// the queued Blocks the replication rate allows
+func (nn *NameNode) MonitorReplication() {
+	tick := time.NewTicker(nn.replicationInterval)
+	defer tick.Stop()
+	dispatch := time.NewTicker(time.Second)
+	defer dispatch.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			nn.update(nn.checkQueuedReplication)
+		case <-dispatch.C:
+			nn.update(nn.dispatchReplications)
+		case <-nn.quit:
+			return
+		}
+	}
+}
+
+// checkQueuedReplication checks each queued file, keeping those whose
+// Blocks are still short of replicas
+func (nn *NameNode) checkQueuedReplication() {
+	nn.replicationQueueLock.Lock()
+	paths := make([]string, 0, len(nn.replicationQueue))
+	for path := range nn.replicationQueue {
+		paths = append(paths, path)
+	}
+	nn.replicationQueueLock.Unlock()
+
+	for _, path := range paths {
+		if nn.checkReplication(path) > 0 {
+			continue
+		}
+		nn.replicationQueueLock.Lock()
+		delete(nn.replicationQueue, path)
+		nn.replicationQueueLock.Unlock()
+	}
+}
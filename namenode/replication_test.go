@@ -0,0 +1,98 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestFileLayout(t *testing.T) {
+
+	nn := New()
+	nn.sizeofblock = 4096
+	nn.replication = 3
+	for _, id := range []string{"DN1", "DN2", "DN3"} {
+		nn.datanodemap[id] = &datanode{ID: id, listed: true}
+	}
+
+	var r Packet
+	lease := Packet{SRC: "C", CMD: LEASE, Message: "W1", Headers: []BlockHeader{{Filename: "/logs/app.log"}}}
+	lease.Status = []FileStatus{{BlockSize: 100}}
+	nn.handleNamespace(lease, &r)
+	if r.CMD != ERROR {
+		t.Errorf("Block size below 4096 bytes accepted")
+	}
+	lease.Status = []FileStatus{{BlockSize: 1 << 16, Replicas: 2}}
+	nn.handleNamespace(lease, &r)
+	if r.CMD != ACK {
+		t.Fatalf("%s", r.Message)
+	}
+	for num := 0; num < 2; num++ {
+		nn.MergeNode(BlockHeader{"DN1", "/logs/app.log", 1, num, 2, 0, "", 0, ""})
+	}
+	nn.ReleaseLease("/logs/app.log", "W1")
+
+	st, _ := nn.Stat("/logs/app.log")
+	if st.BlockSize != 1<<16 || st.Replicas != 2 {
+		t.Errorf("Expected the file's own layout, got %v", st)
+	}
+	if other := nn.fileBlocksStatus("/other", nil); other.BlockSize != 4096 || other.Replicas != 3 {
+		t.Errorf("Expected the default layout, got %v", other)
+	}
+
+	// the written file is copied up to its replication factor
+	nn.checkQueuedReplication()
+	if len(nn.replications) != 2 {
+		t.Fatalf("Expected a copy of each Block, got %v", nn.replications)
+	}
+	for h, o := range nn.replications {
+		copied := h
+		copied.DatanodeID = o.Target
+		nn.MergeNode(copied)
+	}
+	nn.replications = make(map[BlockHeader]replicationOrder)
+	nn.checkQueuedReplication()
+	if len(nn.replicationQueue) != 0 || len(nn.replications) != 0 {
+		t.Errorf("Replicated file still queued")
+	}
+
+	// lowering the replication of the directory deletes the excess replicas
+	nn.Rename("/logs", "/archive")
+	n, err := nn.SetReplication("/archive", 1)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected one file changed, got %d %v", n, err)
+	}
+	nn.checkQueuedReplication()
+	pending := 0
+	for _, id := range []string{"DN1", "DN2", "DN3"} {
+		pending += len(nn.PendingInvalidations(id))
+	}
+	if pending != 2 {
+		t.Errorf("Expected an excess replica of each Block deleted, got %d", pending)
+	}
+	if st, _ := nn.Stat("/archive/app.log"); st.BlockSize != 1<<16 {
+		t.Errorf("Layout lost by the rename")
+	}
+	if _, err := nn.SetReplication("/archive", 0); err == nil {
+		t.Errorf("Replication of 0 accepted")
+	}
+}
+
+func TestExcessReplicas(t *testing.T) {
+
+	nn := New()
+	nn.topology = map[string]string{"DN1": "/rack1", "DN2": "/rack1", "DN3": "/rack2"}
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", size: 10}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", size: 20}
+	nn.datanodemap["DN3"] = &datanode{ID: "DN3", size: 30}
+	replicas := []BlockHeader{{DatanodeID: "DN1"}, {DatanodeID: "DN2"}, {DatanodeID: "DN3"}}
+
+	// the most used datanode on the rack holding two replicas goes first
+	excess := nn.excessReplicas(replicas, 1)
+	if len(excess) != 1 || excess[0].DatanodeID != "DN2" {
+		t.Errorf("Expected the replica on DN2, got %v", excess)
+	}
+	nn.datanodemap["DN3"].decommissioning = true
+	excess = nn.excessReplicas(replicas, 2)
+	if len(excess) != 2 || excess[0].DatanodeID != "DN3" || excess[1].DatanodeID != "DN2" {
+		t.Errorf("Expected the replicas on DN3 and DN2, got %v", excess)
+	}
+}
@@ -0,0 +1,135 @@
+package namenode
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// DatanodeReport describes a datanode in a ClusterReport
+type DatanodeReport struct {
+	ID            string
+	Address       string    // host the datanode connected from
+	HTTPAddress   string    // host:port of the datanode's HTTP server, if any
+	State         string    // online, offline, decommissioning or decommissioned
+	Capacity      int64     // free bytes on its healthy volumes, -1 if it has not reported them
+	Used          int64     // bytes of the Blocks it holds
+	LastHeartbeat time.Time // zero if it never sent one
+	Blocks        int       // replicas it holds
+	Rack          string
+	FailedVolumes string   // storage directories which failed, if any
+	Storages      []string // storage types of its volumes, DISK alone if empty
+	Slow          bool     // its latencies are outliers
+}
+
+// ClusterReport describes the datanodes of a cluster and its totals, as
+// answered to GETREPORT as JSON
+type ClusterReport struct {
+	ID              string // ID of the namenode
+	SafeMode        bool
+	Files           int
+	Blocks          int
+	UnderReplicated int
+	Replication     int
+	BlockSize       int
+	Live            int   // datanodes online or decommissioning
+	Capacity        int64 // free bytes of the datanodes which reported them
+	Used            int64 // bytes of the Blocks on every datanode
+	Datanodes       []DatanodeReport
+}
+
+// datanodeState returns online, offline or decommissioning for a known
+// datanode
+func (nn *NameNode) datanodeState(dn *datanode) string {
+	switch {
+	case dn.decommissioning:
+		return "decommissioning"
+	case nn.offline[dn.ID]:
+		return "offline"
+	}
+	return "online"
+}
+
+// replicaCounts returns the Blocks of the namespace and the replicas each
+// datanode holds
+func (nn *NameNode) replicaCounts() (blocks int, counts map[string]int) {
+	counts = make(map[string]int)
+	nn.filemap.Range(func(path string, b map[int][]BlockHeader) bool {
+		blocks += len(b)
+		for _, replicas := range b {
+			for _, h := range replicas {
+				counts[h.DatanodeID]++
+			}
+		}
+		return true
+	})
+	return blocks, counts
+}
+
+// ClusterReport describes the datanodes, ordered by ID, and the cluster's
+// totals
+func (nn *NameNode) ClusterReport() ClusterReport {
+	blocks, counts := nn.replicaCounts()
+	report := ClusterReport{
+		ID:              nn.id,
+		SafeMode:        nn.safeMode,
+		Files:           nn.filemap.Len(),
+		Blocks:          blocks,
+		UnderReplicated: nn.underReplicated(),
+		Replication:     nn.replication,
+		BlockSize:       nn.sizeofblock,
+		Datanodes:       make([]DatanodeReport, 0, len(nn.datanodemap)+len(nn.decommissioned)),
+	}
+	for _, dn := range nn.datanodemap {
+		d := DatanodeReport{
+			ID:            dn.ID,
+			Address:       dn.host,
+			HTTPAddress:   dn.httpAddr,
+			State:         nn.datanodeState(dn),
+			Capacity:      -1,
+			Used:          dn.size,
+			LastHeartbeat: dn.lastHeartbeat,
+			Blocks:        counts[dn.ID],
+			Rack:          nn.datanodeRack(dn),
+			FailedVolumes: dn.failedVolumes,
+			Storages:      dn.storages,
+			Slow:          dn.slow,
+		}
+		if dn.hasCapacity {
+			d.Capacity = dn.capacity
+			report.Capacity += dn.capacity
+		}
+		if d.State != "offline" {
+			report.Live++
+		}
+		report.Used += dn.size
+		report.Datanodes = append(report.Datanodes, d)
+	}
+	for id := range nn.decommissioned {
+		report.Datanodes = append(report.Datanodes, DatanodeReport{ID: id, State: "decommissioned", Capacity: -1})
+	}
+	sort.Slice(report.Datanodes, func(i, j int) bool { return report.Datanodes[i].ID < report.Datanodes[j].ID })
+	return report
+}
+
+// GetReport returns the ClusterReport as JSON
+func (nn *NameNode) GetReport() (string, error) {
+	data, err := json.Marshal(nn.ClusterReport())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ServeReport serves the ClusterReport as JSON, for the status page and
+// other tools
+func (nn *NameNode) ServeReport(w http.ResponseWriter, req *http.Request) {
+	var report ClusterReport
+	nn.view(func() { report = nn.ClusterReport() })
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,69 @@
+package namenode
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClusterReport(t *testing.T) {
+
+	nn := New()
+	nn.id = "NN"
+	nn.superuser = "hdfs"
+	heartbeat := time.Now().Truncate(time.Second)
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true, host: "10.0.0.1", lastHeartbeat: heartbeat, capacity: 100, hasCapacity: true}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", host: "10.0.0.2", decommissioning: true, capacity: 50, hasCapacity: true}
+	nn.datanodemap["DN3"] = &datanode{ID: "DN3"}
+	nn.offline["DN3"] = true
+	nn.decommissioned["DN4"] = true
+	nn.MergeNode(BlockHeader{"DN1", "/dir/a.txt", 2, 0, 2, 1, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/dir/a.txt", 1, 1, 2, 1, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN2", "/dir/a.txt", 1, 1, 2, 1, "", 0, ""})
+
+	var r Packet
+	nn.handleAdmin(Packet{SRC: "C", CMD: GETREPORT, User: "bob"}, &r)
+	if r.CMD != ERROR || r.Code != ErrUnauthorized.Code {
+		t.Errorf("Report given to bob %v", r)
+	}
+	nn.handleAdmin(Packet{SRC: "C", CMD: GETREPORT, User: "hdfs"}, &r)
+	if r.CMD != ACK {
+		t.Fatalf("Report refused %s", r.Message)
+	}
+	var report ClusterReport
+	if err := json.Unmarshal([]byte(r.Message), &report); err != nil {
+		t.Fatalf("Report is not JSON %s %v", r.Message, err)
+	}
+
+	if report.ID != "NN" || report.Files != 1 || report.Blocks != 2 || report.Live != 2 || report.Capacity != 150 || report.Used != 4 {
+		t.Errorf("Unexpected totals %+v", report)
+	}
+	if len(report.Datanodes) != 4 {
+		t.Fatalf("Reported %d datanodes, expected 4", len(report.Datanodes))
+	}
+	states := []string{"online", "decommissioning", "offline", "decommissioned"}
+	for i, d := range report.Datanodes {
+		if d.State != states[i] {
+			t.Errorf("%s reported %s, expected %s", d.ID, d.State, states[i])
+		}
+	}
+	d := report.Datanodes[0]
+	if d.ID != "DN1" || d.Address != "10.0.0.1" || d.Blocks != 2 || d.Capacity != 100 || !d.LastHeartbeat.Equal(heartbeat) || d.Rack == "" {
+		t.Errorf("Unexpected report of DN1 %+v", d)
+	}
+	if d := report.Datanodes[2]; d.Capacity != -1 || !d.LastHeartbeat.IsZero() {
+		t.Errorf("Unexpected report of DN3 %+v", d)
+	}
+
+	// the status page links to the same report
+	rec := httptest.NewRecorder()
+	nn.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/report", nil))
+	var served ClusterReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil || len(served.Datanodes) != 4 || served.Blocks != 2 {
+		t.Errorf("Unexpected served report %s %v", rec.Body.String(), err)
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Report served as %s", rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,76 @@
+package namenode
+
+import (
+	"errors"
+)
+
+// Resume gives holder the lease on the file at path to write it resumably,
+// with the block size and replication given where they are not 0. A
+// resumable write which stops before it completes, such as when its client
+// crashes, keeps the Blocks written, and the next Resume of the file takes
+// its lease over once it is released or expires. It returns the status of
+// the file, counting no Blocks if none were written, and a header of each
+// Block it is missing.
+func (nn *NameNode) Resume(path, holder string, blockSize, replication int) (FileStatus, []BlockHeader, error) {
+	st := FileStatus{Path: path}
+	if h := nn.leaseHolder(path); h != "" && h != holder {
+		return st, nil, newError(ErrWriteConflict, "File is being written by "+h+" "+path)
+	}
+	n := nn.lookup(path)
+	if n != nil && !nn.isFile(n) {
+		return st, nil, errors.New("Not a file " + path)
+	}
+	if n != nil && !nn.resumable(path) {
+		return st, nil, newError(ErrFileExists, "File exists and was not written resumably "+path)
+	}
+	if _, ok := nn.erasureOf(path); ok {
+		return st, nil, errors.New("Erasure coded files cannot be resumed " + path)
+	}
+	if nn.zoneOf(path) != nil {
+		return st, nil, errors.New("Files in encryption zones cannot be resumed " + path)
+	}
+
+	err := nn.AcquireLease(path, holder)
+	if err != nil {
+		return st, nil, err
+	}
+	blocks, ok := nn.filemap.Get(path)
+	if !ok {
+		err = nn.SetLayout(path, holder, blockSize, replication)
+		if err != nil {
+			nn.ReleaseLease(path, holder)
+			return st, nil, err
+		}
+	}
+	st = nn.fileBlocksStatus(path, blocks)
+
+	nn.leaseLock.Lock()
+	l := nn.leases[path]
+	l.Resumable = true
+	l.NumBlocks = st.NumBlocks
+	nn.leaseLock.Unlock()
+
+	missing := make([]BlockHeader, 0)
+	for num := 0; num < st.NumBlocks; num++ {
+		if len(blocks[num]) == 0 {
+			missing = append(missing, BlockHeader{Filename: path, BlockNum: num, NumBlocks: st.NumBlocks})
+		}
+	}
+	nn.metaLog.Info("Resuming write", "path", path, "holder", holder, "blocks", st.NumBlocks, "missing", len(missing))
+	return st, missing, nil
+}
+
+// resumable reports whether the file at path was left incomplete by a
+// resumable write
+func (nn *NameNode) resumable(path string) bool {
+	nn.leaseLock.Lock()
+	l, ok := nn.leases[path]
+	nn.leaseLock.Unlock()
+	return ok && l.Resumable
+}
+
+// incomplete reports whether the file at path is missing any of its Blocks
+func (nn *NameNode) incomplete(path string) bool {
+	blocks, ok := nn.filemap.Get(path)
+	return ok && nn.fileBlocksStatus(path, blocks).Replication == 0
+}
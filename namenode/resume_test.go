@@ -0,0 +1,60 @@
+package namenode
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResume(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+
+	// a new file is missing nothing the writer must be told of
+	st, missing, err := nn.Resume("/big.bin", "W1", 0, 0)
+	if err != nil || st.NumBlocks != 0 || len(missing) != 0 {
+		t.Fatalf("Resume of a new file %+v %v %v", st, missing, err)
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/big.bin", 4, 0, 3, nn.nextGenStamp(), "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/big.bin", 4, 2, 3, nn.nextGenStamp(), "", 0, ""})
+
+	// the writer crashes, and recovery keeps the Blocks it wrote
+	nn.expireLeases(time.Now().Add(leaseTimeout))
+	nn.expireLeases(time.Now().Add(3 * leaseTimeout))
+	if _, ok := nn.filemap.Get("/big.bin"); !ok || !nn.resumable("/big.bin") {
+		t.Fatalf("Incomplete file of a resumable write not kept")
+	}
+
+	st, missing, err = nn.Resume("/big.bin", "W2", 0, 0)
+	if err != nil || st.NumBlocks != 3 || len(missing) != 1 || missing[0].BlockNum != 1 || missing[0].NumBlocks != 3 {
+		t.Fatalf("Expected Block 1 missing, got %+v %v %v", st, missing, err)
+	}
+	if _, _, err := nn.Resume("/big.bin", "W3", 0, 0); !errors.Is(err, ErrWriteConflict) {
+		t.Errorf("Resumed a file being resumed %v", err)
+	}
+	if nn.checkWrite(BlockHeader{"", "/big.bin", 4, 1, 4, 0, "", 0, ""}) == nil {
+		t.Errorf("Resumed write of another number of Blocks accepted")
+	}
+
+	// an incomplete file released is left to be resumed, and a complete one is done
+	if err := nn.ReleaseLease("/big.bin", "W2"); err != nil || !nn.resumable("/big.bin") {
+		t.Errorf("Incomplete file released %v", err)
+	}
+	if _, _, err := nn.Resume("/big.bin", "W2", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/big.bin", 4, 1, 3, nn.nextGenStamp(), "", 0, ""})
+	if err := nn.ReleaseLease("/big.bin", "W2"); err != nil || len(nn.leases) != 0 {
+		t.Errorf("Lease kept on a complete file %v %v", err, nn.leases)
+	}
+	if _, _, err := nn.Resume("/big.bin", "W2", 0, 0); !errors.Is(err, ErrFileExists) {
+		t.Errorf("Resumed a complete file %v", err)
+	}
+
+	// files not written resumably are not resumed
+	nn.MergeNode(BlockHeader{"DN1", "/other.bin", 4, 0, 1, 1, "", 0, ""})
+	if _, _, err := nn.Resume("/other.bin", "W1", 0, 0); !errors.Is(err, ErrFileExists) {
+		t.Errorf("Resumed a file not written resumably %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package namenode
+
+import (
+	"sync"
+	"time"
+)
+
+// default time the response to a mutating client request is kept for its
+// retries
+const defaultRetryCacheExpiry = 10 * time.Minute
+
+// retryCache keeps the responses to mutating client requests by their
+// idempotency key, so a request retried after its response was lost is
+// answered again rather than done twice
+type retryCache struct {
+	mu        sync.Mutex
+	expiry    time.Duration // time a response is kept, 0 to keep none
+	responses map[string]Packet
+	order     []cachedKey // keys in the order they were added
+}
+
+type cachedKey struct {
+	key   string
+	added time.Time
+}
+
+// newRetryCache returns an empty cache keeping responses for expiry
+func newRetryCache(expiry time.Duration) *retryCache {
+	return &retryCache{expiry: expiry, responses: make(map[string]Packet)}
+}
+
+// get returns the response to the request with key, if it is kept
+func (c *retryCache) get(key string) (Packet, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(time.Now())
+	r, ok := c.responses[key]
+	return r, ok
+}
+
+// add keeps r as the response to the request with key
+func (c *retryCache) add(key string, r Packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expiry <= 0 {
+		return
+	}
+	now := time.Now()
+	c.expire(now)
+	if _, ok := c.responses[key]; !ok {
+		c.order = append(c.order, cachedKey{key, now})
+	}
+	c.responses[key] = r
+}
+
+// expire forgets the responses kept for longer than expiry
+func (c *retryCache) expire(now time.Time) {
+	n := 0
+	for n < len(c.order) && now.Sub(c.order[n].added) >= c.expiry {
+		delete(c.responses, c.order[n].key)
+		n++
+	}
+	c.order = c.order[n:]
+}
+
+// len returns the number of responses kept
+func (c *retryCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.responses)
+}
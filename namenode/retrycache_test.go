@@ -0,0 +1,51 @@
+package namenode
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetryCache(t *testing.T) {
+
+	nn := New()
+	local, peer := net.Pipe()
+	defer peer.Close()
+	nn.SetOutbound("C", local)
+	decoder := json.NewDecoder(peer)
+	request := func(p Packet) Packet {
+		go nn.HandlePacket(p)
+		var r Packet
+		decoder.Decode(&r)
+		return r
+	}
+
+	mkdir := Packet{SRC: "C", DST: "NN", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/d"}}}
+	if r := request(mkdir); r.CMD != ACK {
+		t.Fatalf("%s", r.Message)
+	}
+
+	// a retried delete is answered from the cache rather than failing
+	del := Packet{SRC: "C", DST: "NN", CMD: DELETE, Flags: SKIPTRASH, Headers: []BlockHeader{{Filename: "/d"}}, Key: "C-1", RequestID: 1}
+	if r := request(del); r.CMD != ACK {
+		t.Fatalf("%s", r.Message)
+	}
+	del.RequestID = 2
+	if r := request(del); r.CMD != ACK || r.RequestID != 2 {
+		t.Errorf("Expected the retry answered with the cached ACK, got %v", r)
+	}
+	del.Key = "C-2"
+	if r := request(del); r.CMD != ERROR {
+		t.Errorf("Expected a new delete of the deleted directory to fail, got %v", r)
+	}
+
+	// failures are not kept, and responses expire
+	if nn.retryCache.len() != 1 {
+		t.Errorf("Expected one response kept, got %d", nn.retryCache.len())
+	}
+	nn.retryCache.expire(time.Now().Add(defaultRetryCacheExpiry))
+	if _, ok := nn.retryCache.get("C-1"); ok {
+		t.Errorf("Expired response kept")
+	}
+}
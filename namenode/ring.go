@@ -0,0 +1,189 @@
+package namenode
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+)
+
+// block placement strategies
+const (
+	placementRandom = "random" // a random datanode, preferring the writer's rack
+	placementHash   = "hash"   // the datanodes following the Block on a hash ring
+)
+
+// points each datanode has on the hash ring, evening out its share of Blocks
+const ringPoints = 64
+
+// hashRing places each Block on the datanodes whose points follow the hash
+// of the Block's ID, so its place follows from the ID and the datanodes
+// alone, and stays the same when its file is renamed. A datanode joining or
+// leaving moves only the Blocks next to its points.
+type hashRing struct {
+	points  []uint64 // sorted
+	owners  []string // datanode ID of each point
+	members map[string]bool
+}
+
+// placedBlock is a Block whose replicas are on the first datanodes of its
+// ring order, saved in the metadata file without naming them
+type placedBlock struct {
+	Header   BlockHeader // the replicas' header, with no DatanodeID
+	Replicas int
+}
+
+// newHashRing returns the ring of the datanodes ids
+func newHashRing(ids []string) *hashRing {
+	r := &hashRing{members: make(map[string]bool, len(ids))}
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	points := make([]point, 0, len(ids)*ringPoints)
+	for _, id := range ids {
+		if r.members[id] {
+			continue
+		}
+		r.members[id] = true
+		for i := 0; i < ringPoints; i++ {
+			points = append(points, point{ringHash(id + "#" + strconv.Itoa(i)), id})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].owner < points[j].owner
+	})
+	for _, p := range points {
+		r.points = append(r.points, p.hash)
+		r.owners = append(r.owners, p.owner)
+	}
+	return r
+}
+
+// ringHash hashes a datanode point or Block ID onto the ring
+func ringHash(s string) uint64 {
+	sum := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// ringKey identifies the Block of h on the ring by its ID, or by its file
+// and number if it was written before Blocks were given IDs
+func ringKey(h BlockHeader) string {
+	if h.BlockID == 0 {
+		return h.Filename + "#" + strconv.Itoa(h.BlockNum)
+	}
+	return strconv.FormatInt(h.BlockID, 10)
+}
+
+// order lists the datanodes of the ring in the order the Block id is placed
+// on them, each once
+func (r *hashRing) order(id string) []string {
+	ids := make([]string, 0, len(r.members))
+	if len(r.points) == 0 {
+		return ids
+	}
+	seen := make(map[string]bool, len(r.members))
+	h := ringHash(id)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	for i := 0; i < len(r.points) && len(ids) < len(r.members); i++ {
+		owner := r.owners[(start+i)%len(r.points)]
+		if !seen[owner] {
+			seen[owner] = true
+			ids = append(ids, owner)
+		}
+	}
+	return ids
+}
+
+// placementRing returns the ring of the known datanodes, rebuilt whenever
+// they change
+func (nn *NameNode) placementRing() *hashRing {
+	nn.ringLock.Lock()
+	defer nn.ringLock.Unlock()
+	current := nn.ring != nil && len(nn.ring.members) == len(nn.datanodemap)
+	if current {
+		for id := range nn.datanodemap {
+			if !nn.ring.members[id] {
+				current = false
+				break
+			}
+		}
+	}
+	if !current {
+		ids := make([]string, 0, len(nn.datanodemap))
+		for id := range nn.datanodemap {
+			ids = append(ids, id)
+		}
+		nn.ring = newHashRing(ids)
+	}
+	return nn.ring
+}
+
+// ringRanks numbers the known datanodes in the ring order of the Block of h
+func (nn *NameNode) ringRanks(h BlockHeader) map[string]int {
+	order := nn.placementRing().order(ringKey(h))
+	ranks := make(map[string]int, len(order))
+	for i, id := range order {
+		ranks[id] = i
+	}
+	return ranks
+}
+
+// ringTarget returns the first of the datanodes ids in the ring order of the
+// Block of h
+func (nn *NameNode) ringTarget(h BlockHeader, ids []string) string {
+	ranks := nn.ringRanks(h)
+	target := ids[0]
+	for _, id := range ids[1:] {
+		if ranks[id] < ranks[target] {
+			target = id
+		}
+	}
+	return target
+}
+
+// placeOnRing returns the replicas of a Block as a placedBlock if they are
+// alike and on the first datanodes of its order on the ring
+func placeOnRing(ring *hashRing, replicas []BlockHeader) (placedBlock, bool) {
+	if len(replicas) == 0 {
+		return placedBlock{}, false
+	}
+	header := replicas[0]
+	header.DatanodeID = ""
+	first := make(map[string]bool, len(replicas))
+	order := ring.order(ringKey(header))
+	if len(order) < len(replicas) {
+		return placedBlock{}, false
+	}
+	for _, id := range order[:len(replicas)] {
+		first[id] = true
+	}
+	for _, h := range replicas {
+		holder := h.DatanodeID
+		h.DatanodeID = ""
+		if h != header || !first[holder] {
+			return placedBlock{}, false
+		}
+		delete(first, holder)
+	}
+	return placedBlock{Header: header, Replicas: len(replicas)}, true
+}
+
+// unplace returns the replicas of a placedBlock on the ring
+func unplace(ring *hashRing, b placedBlock) ([]BlockHeader, error) {
+	order := ring.order(ringKey(b.Header))
+	if b.Replicas < 1 || b.Replicas > len(order) {
+		return nil, errors.New("Cannot place " + strconv.Itoa(b.Replicas) + " replicas of " + b.Header.Filename + " on " + strconv.Itoa(len(order)) + " datanodes")
+	}
+	replicas := make([]BlockHeader, 0, b.Replicas)
+	for _, id := range order[:b.Replicas] {
+		h := b.Header
+		h.DatanodeID = id
+		replicas = append(replicas, h)
+	}
+	return replicas, nil
+}
@@ -0,0 +1,120 @@
+package namenode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+
+	ids := []string{"DN1", "DN2", "DN3", "DN4"}
+	ring := newHashRing(ids)
+	h := BlockHeader{"", "/out.txt", 1, 0, 1, 0, "", 7, ""}
+	order := ring.order(ringKey(h))
+	if len(order) != len(ids) {
+		t.Fatalf("Expected each datanode once, got %v", order)
+	}
+	if again := newHashRing([]string{"DN4", "DN3", "DN2", "DN1"}).order(ringKey(h)); !reflect.DeepEqual(again, order) {
+		t.Errorf("Ring order depends on the order of the datanodes, %v and %v", order, again)
+	}
+
+	// a Block is placed by its ID, wherever its file is
+	renamed := h
+	renamed.Filename = "/renamed.txt"
+	if ringKey(renamed) != ringKey(h) {
+		t.Errorf("Renamed Block moved on the ring")
+	}
+	if older := (BlockHeader{Filename: "/out.txt"}); ringKey(older) == ringKey(BlockHeader{Filename: "/out.txt", BlockNum: 1}) {
+		t.Errorf("Blocks without IDs placed alike")
+	}
+
+	// a datanode leaving moves only the Blocks placed on it
+	smaller := newHashRing([]string{"DN1", "DN2", "DN3"})
+	placed := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		id := ringKey(BlockHeader{BlockID: int64(i + 1)})
+		before, after := ring.order(id)[0], smaller.order(id)[0]
+		placed[before]++
+		if before != "DN4" && before != after {
+			t.Fatalf("Block %d moved from %s to %s", i, before, after)
+		}
+	}
+	for _, id := range ids {
+		if placed[id] < 100 {
+			t.Errorf("Only %d of 1000 Blocks placed on %s", placed[id], id)
+		}
+	}
+}
+
+func TestHashPlacement(t *testing.T) {
+
+	nn := New()
+	nn.placement = placementHash
+	for _, id := range []string{"DN1", "DN2", "DN3"} {
+		nn.datanodemap[id] = &datanode{ID: id, listed: true}
+	}
+	b := Block{BlockHeader{"", "/out.txt", 1, 0, 1, 0, "", 0, ""}, []byte{1}}
+	p, err := nn.AssignBlock(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := p.Data.Header.BlockID
+	order := nn.placementRing().order(ringKey(p.Data.Header))
+	if p.DST != order[0] {
+		t.Errorf("Expected the Block on %s, got %s", order[0], p.DST)
+	}
+
+	// the next datanode on the ring takes the Block of an offline one, and
+	// its next replica
+	nn.offline["DN1"] = true
+	p, _ = nn.AssignBlock(b)
+	next := nn.placementRing().order(ringKey(p.Data.Header))
+	if next[0] == "DN1" {
+		next = next[1:]
+	}
+	if p.DST != next[0] {
+		t.Errorf("Expected the Block on %s with DN1 offline, got %s", next[0], p.DST)
+	}
+	delete(nn.offline, "DN1")
+	first := BlockHeader{order[0], "/out.txt", 1, 0, 1, 0, "", id, ""}
+	nn.datanodemap[order[1]].size = 100
+	if target := nn.chooseTarget([]BlockHeader{first}); target == nil || target.ID != order[1] {
+		t.Errorf("Expected the second replica on %s, got %v", order[1], target)
+	}
+
+	// replicas where the ring places them are saved without their datanodes
+	second := first
+	second.DatanodeID = order[1]
+	nn.MergeNode(first)
+	nn.MergeNode(second)
+	moved := BlockHeader{order[2], "/moved.txt", 1, 0, 1, 0, "", 0, ""}
+	if o := nn.placementRing().order(ringKey(moved)); o[0] == order[2] {
+		moved.DatanodeID = o[1]
+	}
+	nn.MergeNode(moved)
+	img := nn.image()
+	if len(img.Placed) != 1 || img.Placed[0].Replicas != 2 || img.Placed[0].Header.DatanodeID != "" {
+		t.Errorf("Placed Blocks saved as %+v", img.Placed)
+	}
+	if !reflect.DeepEqual(img.Headers, []BlockHeader{moved}) {
+		t.Errorf("Headers saved as %v", img.Headers)
+	}
+
+	restored := New()
+	err = restored.restoreImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas := restored.replicas("/out.txt", 0)
+	if len(replicas) != 2 || replicas[0].DatanodeID == replicas[1].DatanodeID {
+		t.Fatalf("Restored replicas %v", replicas)
+	}
+	for _, h := range replicas {
+		if h != first && h != second {
+			t.Errorf("Restored replica %v", h)
+		}
+	}
+	if r := restored.replicas("/moved.txt", 0); !reflect.DeepEqual(r, []BlockHeader{moved}) {
+		t.Errorf("Restored moved replica %v", r)
+	}
+}
@@ -0,0 +1,49 @@
+package namenode
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSlowConnectionDoesNotBlockOthers(t *testing.T) {
+
+	nn := New()
+	nn.sendQueueSize = 1
+
+	// DN1 never reads its end of the pipe
+	slow, _ := net.Pipe()
+	fast, peer := net.Pipe()
+	nn.SetOutbound("DN1", slow)
+	nn.SetOutbound("DN2", fast)
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			nn.SendPacket(Packet{SRC: "NN", DST: "DN1", CMD: ACK})
+		}
+	}()
+
+	done := make(chan Packet)
+	go func() {
+		var p Packet
+		json.NewDecoder(peer).Decode(&p)
+		done <- p
+	}()
+
+	nn.SendPacket(Packet{SRC: "NN", DST: "DN2", CMD: HB})
+
+	select {
+	case p := <-done:
+		if p.CMD != HB {
+			t.Errorf("Received wrong packet %v", p)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Packet to DN2 was blocked by DN1")
+	}
+
+	stats := nn.SendStats()
+	if len(stats) != 2 || stats[0].ID != "DN1" || stats[1].ID != "DN2" {
+		t.Errorf("Unexpected queue stats %v", stats)
+	}
+}
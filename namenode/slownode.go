@@ -0,0 +1,116 @@
+package namenode
+
+import (
+	"sort"
+	"time"
+)
+
+// BLOCKACK latencies kept per datanode, the newest replacing the oldest
+const latencySamples = 100
+
+// latencies a datanode must have before it may be judged slow
+const minLatencySamples = 10
+
+// a datanode is slow when its median latency is this many times the median
+// of the datanodes' medians
+const slowFactor = 3
+
+// median latency below which no datanode is slow, however fast the others
+const minSlowLatency = 20 * time.Millisecond
+
+// latencies keeps the newest latencySamples observed for a datanode
+type latencies struct {
+	samples []time.Duration
+	next    int // index the next sample replaces once samples is full
+}
+
+// add observes a latency, dropping the oldest once the window is full
+func (l *latencies) add(d time.Duration) {
+	if len(l.samples) < latencySamples {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % latencySamples
+}
+
+// percentile returns the latency below which the fraction q of the samples
+// lie, or 0 without samples
+func (l *latencies) percentile(q float64) time.Duration {
+	if len(l.samples) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(l.samples))
+	copy(sorted, l.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+// observeLatency records the time dn took to acknowledge a Block sent to it,
+// and judges again which datanodes are slow
+func (nn *NameNode) observeLatency(dn *datanode, d time.Duration) {
+	dn.latency.add(d)
+	nn.detectSlow()
+}
+
+// detectSlow flags the datanodes whose median latency is an outlier among
+// the connected datanodes with enough samples. At least three are needed to
+// tell an outlier.
+func (nn *NameNode) detectSlow() {
+	medians := make(map[*datanode]time.Duration)
+	for _, dn := range nn.datanodemap {
+		if !nn.offline[dn.ID] && len(dn.latency.samples) >= minLatencySamples {
+			medians[dn] = dn.latency.percentile(0.5)
+		}
+	}
+	var cluster time.Duration
+	if len(medians) >= 3 {
+		all := make([]time.Duration, 0, len(medians))
+		for _, m := range medians {
+			all = append(all, m)
+		}
+		sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+		cluster = all[len(all)/2]
+	}
+
+	for _, dn := range nn.datanodemap {
+		m, ok := medians[dn]
+		slow := ok && cluster > 0 && m > slowFactor*cluster && m > minSlowLatency
+		if slow && !dn.slow {
+			nn.placementLog.Warn("Datanode is slow", "datanode", dn.ID, "median", m, "cluster", cluster)
+		} else if !slow && dn.slow {
+			nn.placementLog.Info("Datanode is no longer slow", "datanode", dn.ID, "median", m, "cluster", cluster)
+		}
+		dn.slow = slow
+	}
+}
+
+// avoidSlow drops the slow datanodes from the IDs a new Block may be placed
+// on, unless they are all slow
+func (nn *NameNode) avoidSlow(ids []string) []string {
+	fast := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if dn, ok := nn.datanodemap[id]; !ok || !dn.slow {
+			fast = append(fast, id)
+		}
+	}
+	if len(fast) == 0 {
+		return ids
+	}
+	return fast
+}
+
+// latencySummary describes the latencies of a datanode for the report and
+// the status page
+func (dn *datanode) latencySummary() string {
+	if len(dn.latency.samples) == 0 {
+		return "-"
+	}
+	s := "p50 " + dn.latency.percentile(0.5).Round(time.Millisecond).String() +
+		", p90 " + dn.latency.percentile(0.9).Round(time.Millisecond).String() +
+		", p99 " + dn.latency.percentile(0.99).Round(time.Millisecond).String()
+	if dn.slow {
+		s += " (slow)"
+	}
+	return s
+}
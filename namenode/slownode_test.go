@@ -0,0 +1,59 @@
+package namenode
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowDatanodes(t *testing.T) {
+
+	nn := New()
+	for _, id := range []string{"DN1", "DN2", "DN3", "DN4"} {
+		nn.datanodemap[id] = &datanode{ID: id, listed: true}
+	}
+	for i := 0; i < minLatencySamples; i++ {
+		nn.observeLatency(nn.datanodemap["DN1"], 10*time.Millisecond)
+		nn.observeLatency(nn.datanodemap["DN2"], 12*time.Millisecond)
+		nn.observeLatency(nn.datanodemap["DN3"], 15*time.Millisecond)
+		nn.observeLatency(nn.datanodemap["DN4"], 200*time.Millisecond)
+	}
+	if !nn.datanodemap["DN4"].slow || nn.datanodemap["DN1"].slow || nn.datanodemap["DN3"].slow {
+		t.Fatalf("Wrong datanodes flagged as slow")
+	}
+
+	// slow datanodes are given new Blocks and replicas only if nothing else has room
+	for i := 0; i < 20; i++ {
+		p, err := nn.AssignBlock(Block{Header: BlockHeader{"", "/out.txt", 1, 0, 1, 0, "", 0, ""}, Data: []byte("a")})
+		if err != nil || p.DST == "DN4" {
+			t.Fatalf("Block placed on %s %v", p.DST, err)
+		}
+	}
+	if dn := nn.chooseTarget([]BlockHeader{{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""}}); dn == nil || dn.ID == "DN4" {
+		t.Errorf("Replica target %v", dn)
+	}
+	if ids := nn.avoidSlow([]string{"DN4"}); len(ids) != 1 {
+		t.Errorf("Only datanode avoided")
+	}
+	if !strings.Contains(nn.Report(), "(slow)") {
+		t.Errorf("Slow datanode missing from report\n%s", nn.Report())
+	}
+
+	// a datanode recovers once its newer latencies push the old ones out
+	for i := 0; i < latencySamples; i++ {
+		nn.observeLatency(nn.datanodemap["DN4"], 11*time.Millisecond)
+	}
+	if nn.datanodemap["DN4"].slow || len(nn.datanodemap["DN4"].latency.samples) != latencySamples {
+		t.Errorf("Datanode still slow after recovering")
+	}
+
+	// with too few datanodes to compare, none is slow
+	nn.offline["DN1"] = true
+	nn.offline["DN2"] = true
+	for i := 0; i < minLatencySamples; i++ {
+		nn.observeLatency(nn.datanodemap["DN4"], time.Second)
+	}
+	if nn.datanodemap["DN4"].slow {
+		t.Errorf("Datanode judged slow against one other")
+	}
+}
@@ -0,0 +1,361 @@
+package namenode
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the name of the read-only directory presenting the snapshots of a directory
+const snapshotDir = ".snapshot"
+
+// the storage names of Blocks kept only for snapshots, once their file was
+// deleted. Their names are freed so new files may be written there.
+const snapshotStorage = "/.reserved/snapshot"
+
+// snapshot is a read-only copy of the namespace below a directory. It
+// refers to the same replicas as the files it captured, which are kept on
+// the datanodes until no file or snapshot refers to them.
+type snapshot struct {
+	Name    string
+	Root    string
+	Created time.Time
+	Files   map[string]map[int][]BlockHeader // paths relative to Root to their Blocks
+	Dirs    map[string]bool                  // directories relative to Root
+	Erasure map[string]FileStatus            // erasure coded files relative to Root
+	Keys    map[string]FileStatus            // wrapped data keys of encrypted files relative to Root
+}
+
+// splitSnapshotPath splits a path of the form <dir>/.snapshot/<name><rel>.
+// ok is false if the path is not within a snapshot directory.
+func splitSnapshotPath(path string) (dir, name, rel string, ok bool) {
+	path_arr := strings.Split(path, "/")
+	for i, c := range path_arr {
+		if c != snapshotDir {
+			continue
+		}
+		dir = strings.Join(path_arr[:i], "/")
+		if dir == "" {
+			dir = "/"
+		}
+		if i+1 < len(path_arr) {
+			name = path_arr[i+1]
+		}
+		if i+2 < len(path_arr) {
+			rel = "/" + strings.Join(path_arr[i+2:], "/")
+		}
+		return dir, name, rel, true
+	}
+	return "", "", "", false
+}
+
+// isSnapshotPath reports whether path is within a read-only snapshot directory
+func isSnapshotPath(path string) bool {
+	_, _, _, ok := splitSnapshotPath(path)
+	return ok
+}
+
+// getSnapshot finds a snapshot of dir
+func (nn *NameNode) getSnapshot(dir, name string) (*snapshot, error) {
+	s, ok := nn.snapshots[dir][name]
+	if !ok {
+		return nil, errors.New("No such snapshot " + dir + "/" + snapshotDir + "/" + name)
+	}
+	return s, nil
+}
+
+// CreateSnapshot captures the namespace below the directory dir as the
+// snapshot name, readable at dir/.snapshot/name
+func (nn *NameNode) CreateSnapshot(dir, name string) error {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return errors.New("Invalid snapshot name " + name)
+	}
+	n := nn.lookup(dir)
+	if n == nil || nn.isFile(n) {
+		return errors.New("No such directory " + dir)
+	}
+	if _, ok := nn.snapshots[dir][name]; ok {
+		return errors.New("Snapshot exists " + name)
+	}
+
+	s := nn.capture(n, dir, name)
+	if nn.snapshots[dir] == nil {
+		nn.snapshots[dir] = make(map[string]*snapshot)
+	}
+	nn.snapshots[dir][name] = s
+	nn.metaLog.Info("Created snapshot", "dir", dir, "name", name, "files", len(s.Files))
+	return nil
+}
+
+// capture copies the namespace below the directory n at dir as the
+// snapshot name
+func (nn *NameNode) capture(n *filenode, dir, name string) *snapshot {
+	s := &snapshot{
+		Name:    name,
+		Root:    dir,
+		Created: time.Now(),
+		Files:   make(map[string]map[int][]BlockHeader),
+		Dirs:    make(map[string]bool),
+		Erasure: make(map[string]FileStatus),
+		Keys:    make(map[string]FileStatus),
+	}
+	nn.walk(n, func(c *filenode) {
+		if c == n {
+			return
+		}
+		rel := strings.TrimPrefix(c.path, strings.TrimSuffix(dir, "/"))
+		blocks, ok := nn.filemap.Get(c.path)
+		if !ok {
+			s.Dirs[rel] = true
+			return
+		}
+		copied := make(map[int][]BlockHeader, len(blocks))
+		for num, replicas := range blocks {
+			copied[num] = append([]BlockHeader(nil), replicas...)
+		}
+		s.Files[rel] = copied
+		if ec, ok := nn.erasure[c.path]; ok {
+			s.Erasure[rel] = ec
+		}
+		if k, ok := nn.encrypted[c.path]; ok {
+			s.Keys[rel] = k
+		}
+	})
+	return s
+}
+
+// DeleteSnapshot deletes a snapshot of dir, invalidating the replicas which
+// no file or other snapshot refers to
+func (nn *NameNode) DeleteSnapshot(dir, name string) error {
+	s, err := nn.getSnapshot(dir, name)
+	if err != nil {
+		return err
+	}
+	delete(nn.snapshots[dir], name)
+	if len(nn.snapshots[dir]) == 0 {
+		delete(nn.snapshots, dir)
+	}
+
+	for _, blocks := range s.Files {
+		for _, replicas := range blocks {
+			for _, h := range replicas {
+				if !nn.isLive(h) && !nn.inSnapshot(h) {
+					nn.Invalidate(h)
+				}
+			}
+		}
+	}
+	nn.metaLog.Info("Deleted snapshot", "dir", dir, "name", name)
+	return nil
+}
+
+// ListSnapshots describes the snapshots of dir, ordered by name, with the
+// time each was created as its modification time
+func (nn *NameNode) ListSnapshots(dir string) ([]FileStatus, error) {
+	n := nn.lookup(dir)
+	if n == nil || nn.isFile(n) {
+		return nil, errors.New("No such directory " + dir)
+	}
+
+	names := make([]string, 0, len(nn.snapshots[dir]))
+	for name := range nn.snapshots[dir] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]FileStatus, 0, len(names))
+	for _, name := range names {
+		st, _ := nn.snapshotStat(nn.snapshots[dir][name], "")
+		st.ModTime = nn.snapshots[dir][name].Created.UnixNano() / int64(time.Millisecond)
+		list = append(list, st)
+	}
+	return list, nil
+}
+
+// snapshotPrefix is the path at which the contents of a snapshot are read
+func (s *snapshot) prefix() string {
+	return strings.TrimSuffix(s.Root, "/") + "/" + snapshotDir + "/" + s.Name
+}
+
+// snapshotStat describes the path rel within a snapshot
+func (nn *NameNode) snapshotStat(s *snapshot, rel string) (FileStatus, error) {
+	path := s.prefix() + rel
+	if blocks, ok := s.Files[rel]; ok {
+		return nn.fileBlocksStatus(path, blocks), nil
+	}
+	if rel != "" && !s.Dirs[rel] {
+		return FileStatus{}, newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+
+	st := FileStatus{Path: path, IsDir: true}
+	for _, child := range s.children(rel, false) {
+		if child == rel {
+			continue
+		}
+		st.Children++
+	}
+	return st, nil
+}
+
+// children lists the paths directly below rel in a snapshot, or every path
+// below it if recursive is set, ordered by path
+func (s *snapshot) children(rel string, recursive bool) []string {
+	list := make([]string, 0)
+	add := func(p string) {
+		if !strings.HasPrefix(p, rel+"/") {
+			return
+		}
+		if !recursive && strings.Contains(p[len(rel)+1:], "/") {
+			return
+		}
+		list = append(list, p)
+	}
+	for p := range s.Files {
+		add(p)
+	}
+	for p := range s.Dirs {
+		add(p)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// statSnapshotPath describes a path within a snapshot directory
+func (nn *NameNode) statSnapshotPath(path string) (FileStatus, error) {
+	dir, name, rel, _ := splitSnapshotPath(path)
+	if name == "" {
+		if nn.lookup(dir) == nil {
+			return FileStatus{}, newError(ErrFileNotFound, "No such file or directory "+path)
+		}
+		return FileStatus{Path: path, IsDir: true, Children: len(nn.snapshots[dir])}, nil
+	}
+	s, err := nn.getSnapshot(dir, name)
+	if err != nil {
+		return FileStatus{}, err
+	}
+	return nn.snapshotStat(s, rel)
+}
+
+// listSnapshotPath lists a directory within a snapshot directory
+func (nn *NameNode) listSnapshotPath(path string, recursive bool) ([]FileStatus, error) {
+	dir, name, rel, _ := splitSnapshotPath(path)
+	if name == "" {
+		return nn.ListSnapshots(dir)
+	}
+	s, err := nn.getSnapshot(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	st, err := nn.snapshotStat(s, rel)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir {
+		return []FileStatus{st}, nil
+	}
+
+	children := s.children(rel, recursive)
+	list := make([]FileStatus, 0, len(children))
+	for _, c := range children {
+		st, _ := nn.snapshotStat(s, c)
+		list = append(list, st)
+	}
+	return list, nil
+}
+
+// blocksFor returns the Blocks of the file at path, which may be within a
+// snapshot
+func (nn *NameNode) blocksFor(path string) (map[int][]BlockHeader, bool) {
+	dir, name, rel, ok := splitSnapshotPath(path)
+	if !ok {
+		return nn.filemap.Get(path)
+	}
+	s, err := nn.getSnapshot(dir, name)
+	if err != nil {
+		return nil, false
+	}
+	blocks, ok := s.Files[rel]
+	return blocks, ok
+}
+
+// hasSnapshots reports whether path or any directory below it has snapshots,
+// in which case it may not be deleted or moved
+func (nn *NameNode) hasSnapshots(path string) bool {
+	for dir := range nn.snapshots {
+		if dir == path || strings.HasPrefix(dir, strings.TrimSuffix(path, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isLive reports whether a replica belongs to a file in the namespace
+func (nn *NameNode) isLive(h BlockHeader) bool {
+	blocks, ok := nn.filemap.Get(h.Filename)
+	return ok && ContainsHeader(blocks[h.BlockNum], h)
+}
+
+// inSnapshot reports whether any snapshot refers to a replica
+func (nn *NameNode) inSnapshot(h BlockHeader) bool {
+	for _, snaps := range nn.snapshots {
+		for _, s := range snaps {
+			for _, blocks := range s.Files {
+				if ContainsHeader(blocks[h.BlockNum], h) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// renameInSnapshots points snapshots at the new headers of renamed replicas
+func (nn *NameNode) renameInSnapshots(orders []renameOrder) {
+	for _, snaps := range nn.snapshots {
+		for _, s := range snaps {
+			for _, blocks := range s.Files {
+				for _, o := range orders {
+					replicas := blocks[o.From.BlockNum]
+					for i, h := range replicas {
+						if h == o.From {
+							replicas[i] = o.To
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// preserveForSnapshots moves the replicas of a deleted file which snapshots
+// refer to under a reserved storage name, returning the replicas which may
+// be invalidated
+func (nn *NameNode) preserveForSnapshots(path string, headers []BlockHeader) []BlockHeader {
+	if len(nn.snapshots) == 0 {
+		return headers
+	}
+
+	storage := snapshotStorage + "/" + strconv.FormatInt(time.Now().UnixNano(), 36) + path
+	unused := make([]BlockHeader, 0, len(headers))
+	orders := make(map[string][]renameOrder)
+	all := make([]renameOrder, 0)
+	for _, h := range headers {
+		if !nn.inSnapshot(h) {
+			unused = append(unused, h)
+			continue
+		}
+		to := h
+		to.Filename = storage
+		o := renameOrder{h, to}
+		orders[h.DatanodeID] = append(orders[h.DatanodeID], o)
+		all = append(all, o)
+	}
+
+	nn.renameInSnapshots(all)
+	for id, list := range orders {
+		nn.renameBlocks(id, list)
+	}
+	return unused
+}
@@ -0,0 +1,83 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	h := BlockHeader{"DN1", "/dir/sub/out.txt", 1, 0, 1, 0, "", 0, ""}
+	nn.MergeNode(h)
+
+	err := nn.CreateSnapshot("/dir", "s1")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if nn.CreateSnapshot("/dir", "s1") == nil {
+		t.Errorf("Created a snapshot twice")
+	}
+	if nn.CreateSnapshot("/missing", "s1") == nil {
+		t.Errorf("Snapshot of a missing directory")
+	}
+
+	st, err := nn.Stat("/dir/.snapshot/s1/sub/out.txt")
+	if err != nil || st.IsDir || st.Size != 1 {
+		t.Fatalf("Unexpected snapshot file status %v %v", st, err)
+	}
+	list, err := nn.ListDir("/dir/.snapshot/s1", true)
+	if err != nil || len(list) != 2 || list[0].Path != "/dir/.snapshot/s1/sub" || list[1].Path != "/dir/.snapshot/s1/sub/out.txt" {
+		t.Errorf("Unexpected snapshot listing %v %v", list, err)
+	}
+	list, err = nn.ListSnapshots("/dir")
+	if err != nil || len(list) != 1 || list[0].Path != "/dir/.snapshot/s1" {
+		t.Errorf("Unexpected snapshots %v %v", list, err)
+	}
+
+	// snapshots are read-only
+	p := Packet{SRC: "C", DST: "NN", CMD: DELETE, Headers: []BlockHeader{{Filename: "/dir/.snapshot/s1/sub/out.txt"}}}
+	var r Packet
+	nn.handleNamespace(p, &r)
+	if r.CMD != ERROR {
+		t.Errorf("Deleted from a snapshot")
+	}
+	if nn.Delete("/dir", true) == nil || nn.Rename("/dir", "/other") == nil {
+		t.Errorf("Removed a directory with snapshots")
+	}
+
+	// deleting the file keeps its replicas for the snapshot
+	err = nn.DeleteFile("/dir/sub/out.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(nn.PendingInvalidations("DN1")) != 0 {
+		t.Errorf("Replica referred to by a snapshot was invalidated")
+	}
+	pending := nn.PendingRenames("DN1")
+	if len(pending) != 1 || pending[0].From != h {
+		t.Fatalf("Replica was not moved to snapshot storage %v", pending)
+	}
+	blocks, ok := nn.blocksFor("/dir/.snapshot/s1/sub/out.txt")
+	if !ok || blocks[0][0] != pending[0].To {
+		t.Errorf("Snapshot does not refer to the moved replica %v", blocks)
+	}
+
+	// a new file may be written under the old name
+	nn.MergeNode(h)
+	if _, ok := nn.filemap.Get("/dir/sub/out.txt"); !ok {
+		t.Errorf("New file not merged")
+	}
+
+	err = nn.DeleteSnapshot("/dir", "s1")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	invalidated := nn.PendingInvalidations("DN1")
+	if len(invalidated) != 1 || invalidated[0] != pending[0].To {
+		t.Errorf("Unexpected invalidations %v", invalidated)
+	}
+	if nn.hasSnapshots("/dir") {
+		t.Errorf("Snapshot was not deleted")
+	}
+}
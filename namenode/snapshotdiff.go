@@ -0,0 +1,226 @@
+package namenode
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// changes to a path in a snapshot diff
+const (
+	diffCreated  = "+"
+	diffDeleted  = "-"
+	diffModified = "M"
+	diffRenamed  = "R"
+)
+
+// SnapshotDiff describes the paths below the directory dir which changed
+// between its snapshots from and to, or between from and the directory as it
+// is now if to is empty or ".". Each has its Change set: created, deleted and
+// modified paths are described as they are in to, or in from if deleted,
+// and renamed paths at their new path, with their path in from as their
+// Source. Every path created is listed, while a renamed directory is listed
+// without the paths below it and a deleted directory hides the paths deleted
+// below it.
+func (nn *NameNode) SnapshotDiff(dir, from, to string) ([]FileStatus, error) {
+	n := nn.lookup(dir)
+	if n == nil || nn.isFile(n) {
+		return nil, errors.New("No such directory " + dir)
+	}
+	a, err := nn.getSnapshot(dir, from)
+	if err != nil {
+		return nil, err
+	}
+	b := nn.capture(n, dir, "")
+	if to == "." {
+		to = ""
+	}
+	if to != "" {
+		b, err = nn.getSnapshot(dir, to)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	root := strings.TrimSuffix(dir, "/")
+	changes := diffSnapshots(a, b)
+	list := make([]FileStatus, 0, len(changes))
+	for _, c := range changes {
+		var st FileStatus
+		switch {
+		case c.kind == diffDeleted:
+			st, _ = nn.snapshotStat(a, c.rel)
+		case to == "":
+			st, _ = nn.Stat(root + c.rel)
+		default:
+			st, _ = nn.snapshotStat(b, c.rel)
+		}
+		st.Path, st.Change = root+c.rel, c.kind
+		if c.source != "" {
+			st.Source = root + c.source
+		}
+		list = append(list, st)
+	}
+	return list, nil
+}
+
+// pathChange is the change to a path between two snapshots, relative to
+// their directory, and the path it was renamed from
+type pathChange struct {
+	rel    string
+	kind   string
+	source string
+}
+
+// diffSnapshots returns the changes between the snapshots a and b of a
+// directory, ordered by path, with a deletion before a creation at the same
+// path. A file is renamed if its Blocks are those of a file no longer at its
+// path in a, as renames keep the replicas of a file and snapshots refer to
+// their new names. A directory is renamed if everything below it was
+// renamed below the same new directory.
+func diffSnapshots(a, b *snapshot) []pathChange {
+	created := make(map[string]bool)
+	deleted := make(map[string]bool)
+	changes := make([]pathChange, 0)
+	for rel, blocks := range b.Files {
+		old, ok := a.Files[rel]
+		if !ok {
+			created[rel] = true
+		} else if blocksSignature(old) != blocksSignature(blocks) {
+			changes = append(changes, pathChange{rel, diffModified, ""})
+		}
+	}
+	for rel := range b.Dirs {
+		if !a.Dirs[rel] {
+			created[rel] = true
+		}
+	}
+	for rel := range a.Files {
+		if _, ok := b.Files[rel]; !ok {
+			deleted[rel] = true
+		}
+	}
+	for rel := range a.Dirs {
+		if !b.Dirs[rel] {
+			deleted[rel] = true
+		}
+	}
+
+	// files whose Blocks moved to another path
+	moved := make(map[string]string)
+	for rel := range created {
+		if blocks, ok := b.Files[rel]; ok && len(blocks) > 0 {
+			moved[blocksSignature(blocks)] = rel
+		}
+	}
+	renamed := make(map[string]string)
+	for rel := range deleted {
+		blocks, ok := a.Files[rel]
+		if !ok || len(blocks) == 0 {
+			continue
+		}
+		if to, ok := moved[blocksSignature(blocks)]; ok {
+			renamed[rel] = to
+		}
+	}
+
+	// directories whose contents all moved below another, outermost first
+	dirs := make([]string, 0)
+	for rel := range deleted {
+		if a.Dirs[rel] {
+			dirs = append(dirs, rel)
+		}
+	}
+	sort.Strings(dirs)
+	for _, rel := range dirs {
+		if !deleted[rel] {
+			continue
+		}
+		to, ok := renamedDir(a, b, rel, created, renamed)
+		if !ok {
+			continue
+		}
+		changes = append(changes, pathChange{to, diffRenamed, rel})
+		delete(deleted, rel)
+		delete(created, to)
+		for _, c := range a.children(rel, true) {
+			delete(deleted, c)
+			delete(created, to+c[len(rel):])
+			delete(renamed, c)
+		}
+	}
+
+	for from, to := range renamed {
+		changes = append(changes, pathChange{to, diffRenamed, from})
+		delete(deleted, from)
+		delete(created, to)
+	}
+	for rel := range created {
+		changes = append(changes, pathChange{rel, diffCreated, ""})
+	}
+	for rel := range deleted {
+		if !deleted[parentRel(rel)] {
+			changes = append(changes, pathChange{rel, diffDeleted, ""})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].rel != changes[j].rel {
+			return changes[i].rel < changes[j].rel
+		}
+		return changes[i].kind == diffDeleted
+	})
+	return changes
+}
+
+// renamedDir returns the directory of b the directory rel of a was renamed
+// to, if every file below it was renamed to the same path below that
+// directory, and each directory below it was created there
+func renamedDir(a, b *snapshot, rel string, created map[string]bool, renamed map[string]string) (string, bool) {
+	to := ""
+	below := a.children(rel, true)
+	for _, c := range below {
+		if dst, ok := renamed[c]; ok {
+			to = strings.TrimSuffix(dst, c[len(rel):])
+			break
+		}
+	}
+	if to == "" || to == rel || !b.Dirs[to] || !created[to] {
+		return "", false
+	}
+	for _, c := range below {
+		target := to + c[len(rel):]
+		if a.Dirs[c] && !(b.Dirs[target] && created[target]) {
+			return "", false
+		}
+		if _, ok := a.Files[c]; ok && renamed[c] != target {
+			return "", false
+		}
+	}
+	return to, true
+}
+
+// blocksSignature identifies the data of a file by its Blocks, whichever
+// datanodes hold their replicas
+func blocksSignature(blocks map[int][]BlockHeader) string {
+	nums := make([]int, 0, len(blocks))
+	for num := range blocks {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	var b strings.Builder
+	for _, num := range nums {
+		if len(blocks[num]) == 0 {
+			continue
+		}
+		h := blocks[num][0]
+		b.WriteString(h.Filename + "\x00" + strconv.Itoa(h.BlockNum) + "\x00" + strconv.Itoa(h.Size) + "\x00" +
+			strconv.FormatInt(h.GenStamp, 10) + "\x00")
+	}
+	return b.String()
+}
+
+// parentRel returns the directory holding the path rel of a snapshot
+func parentRel(rel string) string {
+	return rel[:strings.LastIndex(rel, "/")]
+}
@@ -0,0 +1,122 @@
+package namenode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotDiff(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	for _, h := range []BlockHeader{
+		{"DN1", "/dir/same.txt", 1, 0, 1, 1, "", 0, ""},
+		{"DN1", "/dir/changed.txt", 1, 0, 1, 1, "", 0, ""},
+		{"DN1", "/dir/gone.txt", 1, 0, 1, 1, "", 0, ""},
+		{"DN1", "/dir/moved.txt", 1, 0, 1, 1, "", 0, ""},
+		{"DN1", "/dir/sub/a.txt", 1, 0, 1, 1, "", 0, ""},
+		{"DN1", "/dir/sub/deep/b.txt", 1, 0, 1, 1, "", 0, ""},
+		{"DN1", "/dir/old/c.txt", 1, 0, 1, 1, "", 0, ""},
+		{"DN1", "/dir/old/d.txt", 1, 0, 1, 1, "", 0, ""},
+	} {
+		nn.MergeNode(h)
+	}
+	if err := nn.CreateSnapshot("/dir", "s1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := nn.DeleteFile("/dir/changed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/dir/changed.txt", 2, 0, 1, 2, "", 0, ""})
+	if err := nn.DeleteFile("/dir/gone.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nn.Rename("/dir/moved.txt", "/dir/new/moved.txt"); err == nil {
+		t.Fatal("Renamed into a missing directory")
+	}
+	if err := nn.Rename("/dir/moved.txt", "/dir/renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nn.Rename("/dir/sub", "/dir/other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nn.Delete("/dir/old", true); err != nil {
+		t.Fatal(err)
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/dir/fresh/e.txt", 1, 0, 1, 3, "", 0, ""})
+
+	want := []string{
+		"M /dir/changed.txt",
+		"+ /dir/fresh",
+		"+ /dir/fresh/e.txt",
+		"- /dir/gone.txt",
+		"- /dir/old",
+		"R /dir/other /dir/sub",
+		"R /dir/renamed.txt /dir/moved.txt",
+	}
+	check := func(list []FileStatus, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(list))
+		for _, st := range list {
+			got = append(got, strings.TrimSpace(st.Change+" "+st.Path+" "+st.Source))
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("Unexpected diff\n%s\nexpected\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+
+	// the directory as it is now, and as a later snapshot
+	check(nn.SnapshotDiff("/dir", "s1", ""))
+	if err := nn.CreateSnapshot("/dir", "s2"); err != nil {
+		t.Fatal(err)
+	}
+	check(nn.SnapshotDiff("/dir", "s1", "s2"))
+	list, _ := nn.SnapshotDiff("/dir", "s1", "s2")
+	if len(list) != len(want) || list[0].Size != 2 || list[3].Size != 1 {
+		t.Errorf("Changed paths described as %+v", list)
+	}
+	if list, err := nn.SnapshotDiff("/dir", "s2", "."); err != nil || len(list) != 0 {
+		t.Errorf("Unchanged directory differs %v %v", list, err)
+	}
+
+	// a file replaced by a directory is deleted before it is created
+	if err := nn.DeleteFile("/dir/same.txt"); err != nil {
+		t.Fatal(err)
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/dir/same.txt/f.txt", 1, 0, 1, 4, "", 0, ""})
+	if list, err := nn.SnapshotDiff("/dir", "s2", ""); err != nil || len(list) != 3 || list[0].Change != "-" || list[1].Change != "+" || !list[1].IsDir {
+		t.Errorf("Unexpected diff of a replaced file %+v %v", list, err)
+	}
+
+	if _, err := nn.SnapshotDiff("/dir", "missing", ""); err == nil {
+		t.Errorf("Diff from a missing snapshot")
+	}
+	if _, err := nn.SnapshotDiff("/dir/changed.txt", "s1", ""); err == nil {
+		t.Errorf("Diff of a file")
+	}
+
+	// requests name the snapshots in their Message
+	var r Packet
+	nn.handleNamespace(Packet{SRC: "C", DST: "NN", CMD: SNAPSHOTDIFF, Message: "s1/s2", Headers: []BlockHeader{{Filename: "/dir"}}}, &r)
+	if r.CMD != SNAPSHOTDIFF || len(r.Status) != len(want) {
+		t.Errorf("Unexpected answer %v %s", CommandName(r.CMD), r.Message)
+	}
+
+	rec := webhdfs(nn, "GET", "/dir?op=GETSNAPSHOTDIFF&oldsnapshotname=s1&snapshotname=s2")
+	var report struct {
+		SnapshotDiffReport struct {
+			DiffList []map[string]string
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || len(report.SnapshotDiffReport.DiffList) != len(want) {
+		t.Fatalf("Unexpected WebHDFS diff %s %v", rec.Body.String(), err)
+	}
+	if d := report.SnapshotDiffReport.DiffList[5]; d["type"] != "RENAME" || d["sourcePath"] != "sub" || d["targetPath"] != "other" {
+		t.Errorf("Unexpected WebHDFS rename %v", d)
+	}
+}
@@ -0,0 +1,134 @@
+package namenode
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// standbyMessage refuses the requests made of a standby namenode. Clients
+// which know other namenodes try the next of them on reading it.
+const standbyMessage = "Namenode is in standby"
+
+// default time between reads of the active namenode's edit log
+const defaultTailInterval = time.Second
+
+// time the active namenode has to serve its edit log
+const tailTimeout = 10 * time.Second
+
+// fetchEdits reads the edits after since from the active namenode's HTTP
+// server, or from the edit log it shares with the standby
+func (nn *NameNode) fetchEdits(since int64) ([]edit, error) {
+	if nn.activeAddress != "" {
+		c := http.Client{Timeout: tailTimeout}
+		resp, err := c.Get("http://" + nn.activeAddress + "/editlog?since=" + strconv.FormatInt(since, 10))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.New("Active namenode answered " + resp.Status)
+		}
+		return readEdits(resp.Body, since)
+	}
+
+	if nn.editLogPath == "" {
+		return nil, errors.New("No edit log to tail, set editlog or activeaddress")
+	}
+	f, err := os.Open(nn.editLogPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readEdits(f, since)
+}
+
+// TailEditLog applies the active namenode's edits while the namenode is the
+// standby, keeping its namespace up to date for a failover
+func (nn *NameNode) TailEditLog() {
+	tick := time.NewTicker(nn.tailInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			var standby bool
+			var since int64
+			nn.view(func() { standby, since = nn.standby, nn.editSeq })
+			if !standby {
+				continue
+			}
+			// the edits are read without holding the namespace
+			edits, err := nn.fetchEdits(since)
+			if err != nil {
+				nn.metaLog.Warn("Could not read the active namenode's edit log", "err", err)
+				continue
+			}
+			nn.update(func() {
+				if !nn.standby {
+					return
+				}
+				for _, e := range edits {
+					nn.applyEdit(e)
+				}
+			})
+		case <-nn.quit:
+			return
+		}
+	}
+}
+
+// HAState makes the namenode the active or the standby, as action is active
+// or standby, or reports which it is if action is empty. Becoming the active
+// applies the edits the standby has not yet read, and starts logging its own.
+// Becoming the standby disconnects the datanodes, so they reconnect to the
+// active, and clients are refused until they fail over too.
+func (nn *NameNode) HAState(action string) (string, error) {
+	switch action {
+	case "active":
+		if !nn.standby {
+			break
+		}
+		// a failed active cannot serve the last of its edits
+		edits, err := nn.fetchEdits(nn.editSeq)
+		if err != nil {
+			nn.log.Warn("Becoming active without the last edits of the active namenode", "err", err)
+		}
+		for _, e := range edits {
+			nn.applyEdit(e)
+		}
+		err = nn.openEditLog()
+		if err != nil {
+			return "", err
+		}
+		nn.standby = false
+		nn.log.Warn("Namenode is now active", "seq", nn.editSeq)
+	case "standby":
+		if nn.standby {
+			break
+		}
+		nn.standby = true
+		if nn.activeAddress == "" {
+			// the new active appends to the shared edit log
+			nn.closeEditLog()
+		}
+		for _, dn := range nn.datanodemap {
+			if dn.conn != nil {
+				dn.conn.Close()
+			}
+		}
+		nn.log.Warn("Namenode is now the standby", "seq", nn.editSeq)
+	case "":
+	default:
+		return "", errors.New("HA state must be active or standby")
+	}
+	if nn.standby {
+		return "Namenode is the standby, at edit " + strconv.FormatInt(nn.editSeq, 10), nil
+	}
+	return "Namenode is active, at edit " + strconv.FormatInt(nn.editSeq, 10), nil
+}
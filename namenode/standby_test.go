@@ -0,0 +1,171 @@
+package namenode
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sortedFiles lists the paths of the namespace in order, as the image
+// restores directories before files
+func sortedFiles(nn *NameNode) string {
+	paths := strings.Fields(nn.ListFiles())
+	sort.Strings(paths)
+	return strings.Join(paths, "\n")
+}
+
+func TestEditLogReplay(t *testing.T) {
+
+	dir := t.TempDir()
+	nn := New()
+	nn.editLogPath = filepath.Join(dir, "edits.log")
+	nn.metadatafile = filepath.Join(dir, "metadata.json")
+	nn.trashInterval = time.Hour
+	if err := nn.LoadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/dir/a.txt", 1, 0, 1, 0, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/dir/b.txt", 1, 0, 1, 0, "", 0, ""})
+
+	var r Packet
+	nn.handleNamespace(Packet{SRC: "C", CMD: MKDIR, Flags: PARENTS, Headers: []BlockHeader{{Filename: "/empty/sub"}}}, &r)
+	// edits logged before the namespace is saved are not replayed again
+	nn.SaveMetadata()
+	nn.handleNamespace(Packet{SRC: "C", CMD: RENAME, Headers: []BlockHeader{{Filename: "/dir/a.txt"}}, Renamed: []BlockHeader{{Filename: "/dir/c.txt"}}}, &r)
+	nn.handleNamespace(Packet{SRC: "C", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/empty/sub"}}}, &r)
+	if r.CMD != ERROR {
+		t.Fatalf("Existing directory created again %v", r)
+	}
+
+	// a path moved to the trash twice is replayed to where it was moved
+	nn.handleNamespace(Packet{SRC: "C", CMD: DELETE, Headers: []BlockHeader{{Filename: "/dir/b.txt"}}}, &r)
+	nn.MergeNode(BlockHeader{"DN1", "/dir/b.txt", 1, 0, 1, 0, "", 0, ""})
+	nn.handleNamespace(Packet{SRC: "C", CMD: DELETE, Headers: []BlockHeader{{Filename: "/dir/b.txt"}}}, &r)
+	trashed := strings.TrimPrefix(r.Message, "Moved to trash ")
+	if trashed == "/.Trash/C/dir/b.txt" || nn.lookup(trashed) == nil {
+		t.Fatalf("Wrong move to the trash %v", r)
+	}
+	nn.closeEditLog()
+
+	replayed := New()
+	replayed.editLogPath = nn.editLogPath
+	replayed.metadatafile = nn.metadatafile
+	if err := replayed.LoadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.closeEditLog()
+	if sortedFiles(replayed) != sortedFiles(nn) || replayed.editSeq != nn.editSeq {
+		t.Fatalf("Replayed namespace at %d differs from the one at %d\n%s\n%s", replayed.editSeq, nn.editSeq, replayed.ListFiles(), nn.ListFiles())
+	}
+	if replayed.lookup(trashed) == nil || replayed.lookup("/.Trash/C/dir/b.txt") == nil {
+		t.Errorf("Move to the trash replayed elsewhere")
+	}
+	if !replayed.offline["DN1"] {
+		t.Errorf("Datanode of replayed replicas is online")
+	}
+
+	// a restarted namenode numbers its edits after those it replayed
+	replayed.handleNamespace(Packet{SRC: "C", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/more"}}}, &r)
+	if replayed.editSeq != nn.editSeq+1 {
+		t.Errorf("Edit numbered %d after %d", replayed.editSeq, nn.editSeq)
+	}
+}
+
+func TestStandby(t *testing.T) {
+
+	dir := t.TempDir()
+	active := New()
+	active.editLogPath = filepath.Join(dir, "active.log")
+	if err := active.LoadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(active.Handler())
+	defer server.Close()
+
+	standby := New()
+	standby.superuser = "hdfs"
+	standby.standby = true
+	standby.activeAddress = strings.TrimPrefix(server.URL, "http://")
+	standby.editLogPath = filepath.Join(dir, "standby.log")
+	if err := standby.LoadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+
+	active.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	active.MergeNode(BlockHeader{"DN1", "/dir/a.txt", 1, 0, 1, 0, "", 0, ""})
+	var r Packet
+	active.handleNamespace(Packet{SRC: "C", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/b"}}}, &r)
+
+	// the standby refuses clients and datanodes
+	standby.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/x"}}})
+	if e := standby.recentErrors.entries; standby.lookup("/x") != nil || len(e) == 0 || !strings.Contains(e[len(e)-1].Message, standbyMessage) {
+		t.Errorf("Standby made a client request")
+	}
+	r, kept := connect(t, standby, Packet{SRC: "DN1", DST: "NN", CMD: HELLO, Hello: &Hello{2, 1, "0.3.0", nil, 0, 0, nil, ""}})
+	if r.CMD != ERROR || r.Message != standbyMessage || kept {
+		t.Errorf("Standby accepted a datanode %v", r)
+	}
+
+	edits, err := standby.fetchEdits(standby.editSeq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range edits {
+		standby.applyEdit(e)
+	}
+	if standby.lookup("/dir/a.txt") == nil || standby.lookup("/b") == nil || standby.editSeq != active.editSeq {
+		t.Fatalf("Standby at %d did not apply the edits of the active at %d\n%s", standby.editSeq, active.editSeq, standby.ListFiles())
+	}
+
+	// a standby sharing the edit log reads it without writing to it
+	shared := New()
+	shared.standby = true
+	shared.editLogPath = active.editLogPath
+	if err := shared.LoadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if shared.lookup("/b") == nil || shared.editLog != nil {
+		t.Errorf("Wrong standby sharing the edit log")
+	}
+
+	// promotion applies the edits not yet read
+	active.handleNamespace(Packet{SRC: "C", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/c"}}}, &r)
+	standby.handleAdmin(Packet{SRC: "C", CMD: HASTATE, Message: "active", User: "bob"}, &r)
+	if r.CMD != ERROR || !standby.standby {
+		t.Fatalf("Standby promoted without permission")
+	}
+	standby.handleAdmin(Packet{SRC: "C", CMD: HASTATE, Message: "active", User: "hdfs"}, &r)
+	if r.CMD != ACK || standby.standby || standby.lookup("/c") == nil {
+		t.Fatalf("Standby not promoted %v", r)
+	}
+
+	// the new active logs its edits after those of the old one, and keeps
+	// every edit, so it restarts without the old active
+	server.Close()
+	standby.handleNamespace(Packet{SRC: "C", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/d"}}}, &r)
+	if standby.editSeq != active.editSeq+1 {
+		t.Errorf("Edit numbered %d after %d", standby.editSeq, active.editSeq)
+	}
+	standby.closeEditLog()
+	restarted := New()
+	restarted.editLogPath = standby.editLogPath
+	if err := restarted.LoadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.closeEditLog()
+	for _, p := range []string{"/dir/a.txt", "/b", "/c", "/d"} {
+		if restarted.lookup(p) == nil {
+			t.Errorf("%s lost on restart", p)
+		}
+	}
+
+	// the old active no longer appends to the edit log
+	active.HAState("standby")
+	if msg, _ := active.HAState(""); !strings.HasPrefix(msg, "Namenode is the standby") || active.editLog != nil {
+		t.Errorf("Wrong state after becoming the standby %s", msg)
+	}
+}
@@ -0,0 +1,294 @@
+package namenode
+
+import (
+	"errors"
+	"path"
+	"time"
+)
+
+// storage types of datanode volumes
+const (
+	storageSSD     = "SSD"
+	storageDisk    = "DISK"
+	storageArchive = "ARCHIVE"
+)
+
+// default time between scans moving replicas to the storage their policies ask for
+const defaultMoverInterval = time.Minute
+
+// replicas the mover moves in one scan, so it does not crowd out other copies
+const moverBatch = 1000
+
+// storagePolicies gives the storage types of the replicas of a file under
+// each policy, in order, the last type repeated for the remaining replicas
+var storagePolicies = map[string][]string{
+	"HOT":  {storageSSD},
+	"WARM": {storageSSD, storageDisk},
+	"COLD": {storageArchive},
+}
+
+// policyStorages returns the storage type wanted for each of n replicas
+// under policy
+func policyStorages(policy string, n int) []string {
+	types := storagePolicies[policy]
+	if len(types) == 0 {
+		return nil
+	}
+	list := make([]string, n)
+	for i := range list {
+		if i < len(types) {
+			list[i] = types[i]
+		} else {
+			list[i] = types[len(types)-1]
+		}
+	}
+	return list
+}
+
+// hasStorage reports whether a datanode has volumes of the storage type.
+// Datanodes which do not report their types store everything on DISK.
+func (dn *datanode) hasStorage(storage string) bool {
+	if storage == "" {
+		return true
+	}
+	if len(dn.storages) == 0 {
+		return storage == storageDisk
+	}
+	for _, t := range dn.storages {
+		if t == storage {
+			return true
+		}
+	}
+	return false
+}
+
+// SetStoragePolicy sets the storage policy of the file or directory at path,
+// which the files below a directory inherit unless they have their own. An
+// empty policy clears it. Existing replicas are moved by the mover.
+func (nn *NameNode) SetStoragePolicy(path, policy string) error {
+	n := nn.lookup(path)
+	if n == nil {
+		return newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+	if _, ok := storagePolicies[policy]; !ok && policy != "" {
+		return errors.New("Unknown storage policy " + policy + ", expected HOT, WARM or COLD")
+	}
+	n.policy = policy
+	nn.metaLog.Info("Set storage policy", "path", path, "policy", policy)
+	return nil
+}
+
+// storagePolicy returns the policy of a filenode, set on it or on the
+// nearest directory above it
+func (nn *NameNode) storagePolicy(n *filenode) string {
+	for ; n != nil; n = n.parent {
+		if n.policy != "" {
+			return n.policy
+		}
+	}
+	return ""
+}
+
+// policyOf returns the storage policy of the file or directory at p, or of
+// the nearest directory above it for a file not yet in the namespace
+func (nn *NameNode) policyOf(p string) string {
+	for {
+		if n := nn.lookup(p); n != nil {
+			return nn.storagePolicy(n)
+		}
+		if p == "/" || p == "." || p == "" {
+			return ""
+		}
+		p = path.Dir(p)
+	}
+}
+
+// withStorage returns the datanodes of ids which have volumes of the storage
+// type, or all of them if none do
+func (nn *NameNode) withStorage(ids []string, storage string) []string {
+	list := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if dn, ok := nn.datanodemap[id]; ok && dn.hasStorage(storage) {
+			list = append(list, id)
+		}
+	}
+	if len(list) == 0 {
+		return ids
+	}
+	return list
+}
+
+// replicaStorage returns the storage type of a replica, as its datanode
+// last reported it
+func (nn *NameNode) replicaStorage(h BlockHeader) string {
+	if t, ok := nn.storage[h]; ok {
+		return t
+	}
+	return storageDisk
+}
+
+// recordStorage keeps the storage types of the replicas listed in a block
+// report. A full report replaces those of the datanode.
+func (nn *NameNode) recordStorage(dn *datanode, p Packet, full bool) {
+	if full {
+		for h := range nn.storage {
+			if h.DatanodeID == dn.ID {
+				delete(nn.storage, h)
+			}
+		}
+	}
+	for _, h := range p.Removed {
+		delete(nn.storage, h)
+	}
+	for i, h := range p.Headers {
+		delete(nn.migrating, h)
+		if i < len(p.Storages) && p.Storages[i] != storageDisk {
+			nn.storage[h] = p.Storages[i]
+		} else {
+			delete(nn.storage, h)
+		}
+	}
+}
+
+// newReplicaStorage returns the storage type a new replica of a Block of the
+// file at path is written to, the first its policy asks for which none of
+// the existing replicas is on. It is empty for files without a policy.
+func (nn *NameNode) newReplicaStorage(path string, replicas []BlockHeader) string {
+	count := nn.neededReplicas(path)
+	if count < len(replicas)+1 {
+		count = len(replicas) + 1
+	}
+	wanted := policyStorages(nn.policyOf(path), count)
+	if wanted == nil {
+		return ""
+	}
+	for _, h := range replicas {
+		t := nn.replicaStorage(h)
+		for i, w := range wanted {
+			if w == t {
+				wanted = append(wanted[:i], wanted[i+1:]...)
+				break
+			}
+		}
+	}
+	return wanted[0]
+}
+
+// misplacedReplicas pairs the replicas of a Block which are not on the
+// storage their policy asks for with the type each should move to
+func (nn *NameNode) misplacedReplicas(policy string, replicas []BlockHeader) map[BlockHeader]string {
+	wanted := policyStorages(policy, len(replicas))
+	if wanted == nil {
+		return nil
+	}
+	left := make([]BlockHeader, 0)
+	for _, h := range replicas {
+		t := nn.replicaStorage(h)
+		matched := false
+		for i, w := range wanted {
+			if w == t {
+				wanted = append(wanted[:i], wanted[i+1:]...)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			left = append(left, h)
+		}
+	}
+	misplaced := make(map[BlockHeader]string, len(left))
+	for i, h := range left {
+		misplaced[h] = wanted[i]
+	}
+	return misplaced
+}
+
+// MonitorStorage periodically moves the replicas of files with storage
+// policies to the storage types they ask for, until the namenode shuts down
+func (nn *NameNode) MonitorStorage() {
+	tick := time.NewTicker(nn.moverInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			nn.update(nn.moveStorage)
+		case <-nn.quit:
+			return
+		}
+	}
+}
+
+// moveStorage moves up to moverBatch misplaced replicas. A replica stays on
+// its datanode when that has volumes of the wanted type, which is asked to
+// move it between its volumes, and is otherwise copied to a datanode which
+// has them and deleted once the copy is acknowledged.
+func (nn *NameNode) moveStorage() {
+	if nn.replaying || nn.standby || nn.safeMode {
+		return
+	}
+	moved := 0
+	migrations := make(map[string]map[string][]BlockHeader)
+	nn.walkPolicies(nn.root, "", func(path, policy string) bool {
+		blocks, ok := nn.filemap.Get(path)
+		if !ok {
+			return true
+		}
+		for _, replicas := range blocks {
+			replicas = nn.keptReplicas(replicas)
+			if nn.isMoving(replicas) {
+				continue
+			}
+			for h, storage := range nn.misplacedReplicas(policy, replicas) {
+				if sent, ok := nn.migrating[h]; ok && time.Since(sent) < replicationTimeout {
+					continue
+				}
+				dn, ok := nn.datanodemap[h.DatanodeID]
+				if !ok || nn.offline[dn.ID] {
+					continue
+				}
+				if dn.hasStorage(storage) && dn.supports("storage") {
+					if migrations[dn.ID] == nil {
+						migrations[dn.ID] = make(map[string][]BlockHeader)
+					}
+					migrations[dn.ID][storage] = append(migrations[dn.ID][storage], h)
+					nn.migrating[h] = time.Now()
+				} else if target := nn.chooseStorageTarget(replicas, storage); target != nil {
+					nn.move(h, target.ID)
+				} else {
+					continue
+				}
+				moved++
+				if moved >= moverBatch {
+					return false
+				}
+			}
+		}
+		return true
+	})
+
+	for id, byStorage := range migrations {
+		for storage, headers := range byStorage {
+			sortHeaders(headers)
+			nn.placementLog.Debug("Migrating Blocks", "datanode", id, "storage", storage, "blocks", len(headers))
+			nn.queueCommand(Packet{SRC: nn.id, DST: id, CMD: MIGRATE, Headers: headers, Storage: storage})
+		}
+	}
+}
+
+// walkPolicies calls fn with each file at or below n and its storage policy,
+// skipping those without one, until fn returns false
+func (nn *NameNode) walkPolicies(n *filenode, inherited string, fn func(path, policy string) bool) bool {
+	if n.policy != "" {
+		inherited = n.policy
+	}
+	if inherited != "" && len(n.children) == 0 && !fn(n.path, inherited) {
+		return false
+	}
+	for _, c := range n.children {
+		if !nn.walkPolicies(c, inherited, fn) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestStoragePolicies(t *testing.T) {
+
+	nn := New()
+	nn.replication = 2
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", features: []string{"commands", "replicate", "storage"}, httpAddr: "dn1:8080", storages: []string{"DISK", "SSD"}}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", features: []string{"commands", "replicate"}, httpAddr: "dn2:8080"}
+	nn.datanodemap["DN3"] = &datanode{ID: "DN3", features: []string{"commands", "replicate"}, httpAddr: "dn3:8080", storages: []string{"SSD"}}
+	a1 := BlockHeader{"DN1", "/hot/a.txt", 10, 0, 1, 0, "", 0, ""}
+	a2 := BlockHeader{"DN2", "/hot/a.txt", 10, 0, 1, 0, "", 0, ""}
+	for _, h := range []BlockHeader{a1, a2, {"DN2", "/b.txt", 10, 0, 1, 0, "", 0, ""}} {
+		nn.MergeNode(h)
+	}
+
+	if err := nn.SetStoragePolicy("/hot", "LUKEWARM"); err == nil {
+		t.Errorf("Set an unknown storage policy")
+	}
+	if err := nn.SetStoragePolicy("/missing", "HOT"); err == nil {
+		t.Errorf("Set the storage policy of a missing path")
+	}
+	if err := nn.SetStoragePolicy("/hot", "HOT"); err != nil {
+		t.Fatal(err)
+	}
+
+	// files inherit the policy of their directories, even before they exist
+	if st, err := nn.Stat("/hot/a.txt"); err != nil || st.Storage != "HOT" {
+		t.Errorf("Policy not inherited %+v %v", st, err)
+	}
+	if st, err := nn.Stat("/b.txt"); err != nil || st.Storage != "" {
+		t.Errorf("Policy of a file outside the directory %+v %v", st, err)
+	}
+	if s := nn.newReplicaStorage("/hot/new.txt", nil); s != "SSD" {
+		t.Errorf("New file under HOT written to %q", s)
+	}
+	if s := nn.newReplicaStorage("/b.txt", nil); s != "" {
+		t.Errorf("File without a policy written to %q", s)
+	}
+
+	// new replicas prefer datanodes with the storage
+	if dn := nn.chooseTarget([]BlockHeader{{"DN1", "/hot/c.txt", 10, 0, 1, 0, "", 0, ""}}); dn == nil || dn.ID != "DN3" {
+		t.Errorf("Replica of a HOT file placed on %v", dn)
+	}
+
+	// a replica on a datanode with SSD moves between its volumes, the other
+	// is copied to a datanode with SSD
+	nn.moveStorage()
+	if c := nn.takeCommands("DN1"); len(c) != 1 || c[0].CMD != MIGRATE || c[0].Storage != "SSD" || len(c[0].Headers) != 1 || c[0].Headers[0] != a1 {
+		t.Errorf("Wrong commands for DN1 %v", commandNamesOf(c))
+	}
+	c := nn.takeCommands("DN2")
+	if len(c) != 1 || c[0].CMD != REPLICATE || c[0].Message != "DN3" || c[0].Storage != "SSD" {
+		t.Errorf("Wrong commands for DN2 %v", commandNamesOf(c))
+	}
+
+	// replicas waiting for their migration are not asked again
+	nn.moveStorage()
+	if c := nn.takeCommands("DN1"); len(c) != 0 {
+		t.Errorf("Migration repeated %v", commandNamesOf(c))
+	}
+
+	// block reports record the new storage types
+	nn.recordStorage(nn.datanodemap["DN1"], Packet{Headers: []BlockHeader{a1}, Storages: []string{"SSD"}}, false)
+	if s := nn.replicaStorage(a1); s != "SSD" {
+		t.Errorf("Storage of a migrated replica %q", s)
+	}
+	if len(nn.migrating) != 0 {
+		t.Errorf("Migration not completed %v", nn.migrating)
+	}
+	nn.recordStorage(nn.datanodemap["DN1"], Packet{Headers: []BlockHeader{}}, true)
+	if s := nn.replicaStorage(a1); s != "DISK" {
+		t.Errorf("Storage kept after a full report %q", s)
+	}
+
+	// WARM keeps one replica on SSD and the rest on DISK
+	nn.storage[a1] = "SSD"
+	if err := nn.SetStoragePolicy("/hot/a.txt", "WARM"); err != nil {
+		t.Fatal(err)
+	}
+	if m := nn.misplacedReplicas("WARM", []BlockHeader{a1, a2}); len(m) != 0 {
+		t.Errorf("Misplaced WARM replicas %v", m)
+	}
+	if s := nn.newReplicaStorage("/hot/a.txt", []BlockHeader{a1}); s != "DISK" {
+		t.Errorf("Second WARM replica written to %q", s)
+	}
+
+	img := nn.image()
+	if len(img.Policies) != 2 {
+		t.Errorf("Policies saved as %v", img.Policies)
+	}
+
+	if err := nn.SetStoragePolicy("/hot", ""); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := nn.Stat("/hot"); st.Storage != "" {
+		t.Errorf("Policy not cleared %+v", st)
+	}
+}
@@ -0,0 +1,152 @@
+package namenode
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// default number of symbolic links followed resolving a single path
+const defaultMaxSymlinkDepth = 32
+
+// CreateSymlink creates a symbolic link at link to the absolute path target,
+// which need not exist. Unless parents is set the parent directory of link
+// must already exist.
+func (nn *NameNode) CreateSymlink(link, target string, parents bool) error {
+	if link == "/" || !strings.HasPrefix(link, "/") {
+		return errors.New("Invalid link " + link)
+	}
+	clean, err := nn.cleanPath(target)
+	if err != nil {
+		return err
+	}
+	if nn.lookup(link) != nil {
+		return errors.New("File exists " + link)
+	}
+
+	parentPath := link[:strings.LastIndex(link, "/")]
+	if parentPath == "" {
+		parentPath = "/"
+	}
+	if parents {
+		err = nn.Mkdir(parentPath, true)
+		if err != nil {
+			return err
+		}
+	}
+	parent := nn.lookup(parentPath)
+	if parent == nil || nn.isFile(parent) || parent.target != "" {
+		return errors.New("No such directory " + parentPath)
+	}
+
+	n := newFilenode(link, parent)
+	n.target = clean
+	parent.children = append(parent.children, n)
+	nn.metaLog.Info("Created symbolic link", "link", link, "target", clean)
+	return nil
+}
+
+// Readlink returns the target of the symbolic link at path
+func (nn *NameNode) Readlink(path string) (string, error) {
+	n := nn.lookup(path)
+	if n == nil {
+		return "", newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+	if n.target == "" {
+		return "", errors.New("Not a symbolic link " + path)
+	}
+	return n.target, nil
+}
+
+// firstLink finds the first symbolic link along path, and the rest of path
+// below it. The last component of path is only considered if follow is set.
+func (nn *NameNode) firstLink(path string, follow bool) (*filenode, string) {
+	path_arr := strings.Split(path, "/")
+	q := nn.root
+	for i := 1; i < len(path_arr); i++ {
+		partial := strings.Join(path_arr[0:i+1], "/")
+		var next *filenode
+		for _, c := range q.children {
+			if c.path == partial {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return nil, ""
+		}
+		last := i == len(path_arr)-1
+		if next.target != "" && (follow || !last) {
+			return next, path[len(partial):]
+		}
+		q = next
+	}
+	return nil, ""
+}
+
+// resolve replaces the symbolic links along path with their targets, up to
+// maxSymlinkDepth of them. The last component of path is left as it is unless
+// follow is set, so a link itself can be deleted or renamed.
+func (nn *NameNode) resolve(path string, follow bool) (string, error) {
+	seen := make(map[string]bool)
+	for hops := 0; ; hops++ {
+		n, rest := nn.firstLink(path, follow)
+		if n == nil {
+			return path, nil
+		}
+		if seen[path] {
+			return "", errors.New("Symbolic link loop at " + n.path)
+		}
+		if hops == nn.maxSymlinkDepth {
+			return "", errors.New("More than " + strconv.Itoa(nn.maxSymlinkDepth) + " symbolic links resolving " + path)
+		}
+		seen[path] = true
+		if n.target == "/" && rest != "" {
+			path = rest
+		} else {
+			path = n.target + rest
+		}
+	}
+}
+
+// followsLinks reports whether a client request acts on the target of a
+// symbolic link it names, rather than on the link itself
+func followsLinks(cmd int) bool {
+	switch cmd {
+	case DELETE, RENAME, MKDIR, CREATESYMLINK, READLINK:
+		return false
+	}
+	return true
+}
+
+// resolvePaths replaces the symbolic links along each path named in the
+// client request p with their targets
+func (nn *NameNode) resolvePaths(p *Packet) error {
+	if p.CMD == GLOB || p.CMD == RETRIEVEBLOCK || p.CMD == CHECKSUM {
+		return nil
+	}
+	var err error
+	for i, h := range p.Headers {
+		if h.Filename == "" {
+			continue
+		}
+		p.Headers[i].Filename, err = nn.resolve(h.Filename, followsLinks(p.CMD))
+		if err != nil {
+			return err
+		}
+	}
+	// the destination of a rename is a new name
+	for i, h := range p.Renamed {
+		if h.Filename == "" {
+			continue
+		}
+		p.Renamed[i].Filename, err = nn.resolve(h.Filename, false)
+		if err != nil {
+			return err
+		}
+	}
+	if p.CMD == DISTRIBUTE {
+		p.Data.Header.Filename, err = nn.resolve(p.Data.Header.Filename, true)
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package namenode
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSymlinks(t *testing.T) {
+
+	nn := New()
+	nn.metadatafile = filepath.Join(t.TempDir(), "metadata.json")
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/data/v2/part-0", 1, 0, 1, 0, "", 0, ""})
+
+	err := nn.CreateSymlink("/current", "/data/v2", false)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if nn.CreateSymlink("/current", "/data", false) == nil {
+		t.Errorf("Link created over an existing path")
+	}
+	if nn.CreateSymlink("/missing/link", "/data", false) == nil {
+		t.Errorf("Link created in a missing directory")
+	}
+	if target, err := nn.Readlink("/current"); err != nil || target != "/data/v2" {
+		t.Errorf("Expected /data/v2, got %s %v", target, err)
+	}
+	if _, err := nn.Readlink("/data"); err == nil {
+		t.Errorf("Directory read as a link")
+	}
+
+	// paths through a link resolve to its target
+	if p, err := nn.resolve("/current/part-0", true); err != nil || p != "/data/v2/part-0" {
+		t.Errorf("Expected /data/v2/part-0, got %s %v", p, err)
+	}
+	if p, _ := nn.resolve("/current", false); p != "/current" {
+		t.Errorf("Last component followed, got %s", p)
+	}
+	var r Packet
+	nn.handle(Packet{SRC: "C", DST: "NN", CMD: MKDIR, Headers: []BlockHeader{{Filename: "/current/sub"}}})
+	if nn.lookup("/data/v2/sub") == nil || nn.lookup("/current/sub") != nil {
+		t.Errorf("Directory not created in the target of the link")
+	}
+	list, _ := nn.ListDir("/", false)
+	for _, st := range list {
+		if st.Path == "/current" && (st.Symlink != "/data/v2" || st.IsDir) {
+			t.Errorf("Unexpected status of a link %v", st)
+		}
+	}
+
+	// loops and long chains are refused
+	nn.CreateSymlink("/a", "/b", false)
+	nn.CreateSymlink("/b", "/a", false)
+	if _, err := nn.resolve("/a/x", true); err == nil || !strings.Contains(err.Error(), "loop") {
+		t.Errorf("Expected a loop, got %v", err)
+	}
+	nn.maxSymlinkDepth = 2
+	nn.CreateSymlink("/c1", "/c2", false)
+	nn.CreateSymlink("/c2", "/c3", false)
+	nn.CreateSymlink("/c3", "/data", false)
+	if _, err := nn.resolve("/c1", true); err == nil {
+		t.Errorf("Followed more than %d links", nn.maxSymlinkDepth)
+	}
+	if p, _ := nn.resolve("/c2/v2", true); p != "/data/v2" {
+		t.Errorf("Expected /data/v2, got %s", p)
+	}
+
+	// links survive a restart, and deleting one leaves its target
+	nn.SaveMetadata()
+	restarted := New()
+	restarted.metadatafile = nn.metadatafile
+	err = restarted.LoadMetadata()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if target, err := restarted.Readlink("/current"); err != nil || target != "/data/v2" {
+		t.Errorf("Link not restored %s %v", target, err)
+	}
+	nn.handleNamespace(Packet{SRC: "C", CMD: DELETE, Flags: SKIPTRASH, Headers: []BlockHeader{{Filename: "/current"}}}, &r)
+	if r.CMD != ACK || nn.lookup("/current") != nil || nn.lookup("/data/v2/part-0") == nil {
+		t.Errorf("Link not deleted alone %v", r)
+	}
+}
@@ -0,0 +1,295 @@
+package namenode
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+)
+
+// default directory holding the namespace root of each tenant
+const defaultTenantsRoot = "/tenants"
+
+// tenant is a group of users confined to the namespace below their own
+// directory, with limits on the files and bytes they keep there and on the
+// requests they make
+type tenant struct {
+	ID    string  `xml:"id,attr"`
+	Users string  `xml:"users,attr"` // comma separated
+	Files int     `xml:"files,attr"` // most files below the root, 0 for no limit
+	Space int64   `xml:"space,attr"` // most bytes of file data below the root, 0 for no limit
+	Rate  float64 `xml:"rate,attr"`  // most requests per second, 0 for no limit
+
+	limiter  *rateLimiter
+	requests int64 // requests made, accessed atomically
+	limited  int64 // requests refused for the rate limit, accessed atomically
+	denied   int64 // requests refused for naming paths outside the root, accessed atomically
+}
+
+// tenantList is the XML document of a tenants file
+type tenantList struct {
+	Tenants []*tenant `xml:"tenant"`
+}
+
+// LoadTenants reads the tenants file, replacing the tenants. The counts of
+// requests of tenants which are kept carry on.
+func (nn *NameNode) LoadTenants() error {
+	f, err := os.Open(nn.tenantsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var list tenantList
+	err = xml.NewDecoder(f).Decode(&list)
+	if err != nil {
+		return err
+	}
+
+	tenants := make(map[string]*tenant, len(list.Tenants))
+	users := make(map[string]*tenant)
+	for _, t := range list.Tenants {
+		if t.ID == "" || strings.Contains(t.ID, "/") || t.ID == "." || t.ID == ".." {
+			return errors.New("Invalid tenant ID " + t.ID)
+		}
+		if tenants[t.ID] != nil {
+			return errors.New("Tenant " + t.ID + " is given twice")
+		}
+		if t.Files < 0 || t.Space < 0 || t.Rate < 0 {
+			return errors.New("Limits of tenant " + t.ID + " must not be negative")
+		}
+		for _, u := range strings.Split(t.Users, ",") {
+			if u = strings.TrimSpace(u); u == "" {
+				continue
+			}
+			if other := users[u]; other != nil {
+				return errors.New("User " + u + " belongs to tenants " + other.ID + " and " + t.ID)
+			}
+			users[u] = t
+		}
+		tenants[t.ID] = t
+	}
+
+	nn.tenantLock.Lock()
+	defer nn.tenantLock.Unlock()
+	for id, t := range tenants {
+		if old := nn.tenants[id]; old != nil {
+			t.requests, t.limited, t.denied = atomic.LoadInt64(&old.requests), atomic.LoadInt64(&old.limited), atomic.LoadInt64(&old.denied)
+		}
+		if t.Rate > 0 {
+			t.limiter = newRateLimiter(t.Rate)
+		}
+	}
+	nn.tenants, nn.tenantUsers = tenants, users
+	return nil
+}
+
+// tenantOf returns the tenant of a user, or nil for users of no tenant
+func (nn *NameNode) tenantOf(user string) *tenant {
+	nn.tenantLock.Lock()
+	defer nn.tenantLock.Unlock()
+	return nn.tenantUsers[user]
+}
+
+// tenantRoot returns the directory holding the namespace of a tenant
+func (nn *NameNode) tenantRoot(t *tenant) string {
+	return strings.TrimSuffix(nn.tenantsRoot, "/") + "/" + t.ID
+}
+
+// requestTenant returns the tenant whose limits the requests of a user are
+// held to, or nil for administrators and users of no tenant
+func (nn *NameNode) requestTenant(user string) *tenant {
+	if user == nn.superuser || nn.admins[user] {
+		return nil
+	}
+	return nn.tenantOf(user)
+}
+
+// checkTenant returns an error if the client request p is made by a user of
+// a tenant beyond the tenant's rate limit, or names a path outside the
+// tenant's root
+func (nn *NameNode) checkTenant(p Packet) error {
+	t := nn.requestTenant(p.User)
+	if t == nil || p.CMD == HB {
+		return nil
+	}
+	atomic.AddInt64(&t.requests, 1)
+	if t.limiter != nil && !t.limiter.allow() {
+		atomic.AddInt64(&t.limited, 1)
+		return errors.New(throttledMessage + ": rate limit of tenant " + t.ID + " exceeded, " + strconv.FormatFloat(t.Rate, 'g', -1, 64) + " requests per second")
+	}
+	return nn.confine(t, p)
+}
+
+// confine returns an error if the client request p names a path outside the
+// root of the tenant t. Paths are checked once symbolic links are resolved,
+// so a link cannot lead a tenant out of its root. The requests of a BATCH
+// are checked as each is handled.
+func (nn *NameNode) confine(t *tenant, p Packet) error {
+	root := nn.tenantRoot(t)
+	paths, ok := requestPaths(p)
+	if !ok {
+		atomic.AddInt64(&t.denied, 1)
+		return newError(ErrUnauthorized, "Permission denied: tenant "+t.ID+" may not make "+CommandName(p.CMD)+" requests")
+	}
+	for _, name := range paths {
+		if !within(storedPath(name), root) || (name == root && (p.CMD == DELETE || p.CMD == RENAME)) {
+			atomic.AddInt64(&t.denied, 1)
+			return newError(ErrUnauthorized, "Permission denied: "+name+" is outside the namespace of tenant "+t.ID)
+		}
+	}
+	return nil
+}
+
+// requestPaths returns the paths a client request names. ok is false for
+// requests about the whole namespace, which name none.
+func requestPaths(p Packet) (paths []string, ok bool) {
+	switch p.CMD {
+	case LIST, LISTCACHE:
+		return nil, false
+	case BATCH:
+		return nil, true
+	case DISTRIBUTE:
+		paths = append(paths, p.Data.Header.Filename)
+	case CREATESYMLINK:
+		paths = append(paths, path.Clean(p.Message))
+	}
+	for _, h := range append(p.Headers, p.Renamed...) {
+		if h.Filename != "" {
+			paths = append(paths, h.Filename)
+		}
+	}
+	return paths, true
+}
+
+// storedPath returns the path of the file whose Blocks are stored under
+// name, which is under snapshotStorage for files deleted since a snapshot
+func storedPath(name string) string {
+	if !strings.HasPrefix(name, snapshotStorage+"/") {
+		return name
+	}
+	rest := name[len(snapshotStorage)+1:]
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return rest[i:]
+	}
+	return name
+}
+
+// within reports whether path is dir or below it
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// quotaOf returns the limits on the files and bytes below a directory: its
+// own quota, made tighter by the limits of a tenant if it is a tenant's root
+func (nn *NameNode) quotaOf(n *filenode) (files int, space int64) {
+	files, space = n.fileQuota, n.spaceQuota
+	if !strings.HasPrefix(n.path, strings.TrimSuffix(nn.tenantsRoot, "/")+"/") {
+		return files, space
+	}
+	nn.tenantLock.Lock()
+	t := nn.tenants[n.path[len(strings.TrimSuffix(nn.tenantsRoot, "/"))+1:]]
+	nn.tenantLock.Unlock()
+	if t == nil {
+		return files, space
+	}
+	if t.Files > 0 && (files == 0 || t.Files < files) {
+		files = t.Files
+	}
+	if t.Space > 0 && (space == 0 || t.Space < space) {
+		space = t.Space
+	}
+	return files, space
+}
+
+// TenantUsage describes the use a tenant makes of the namenode
+type TenantUsage struct {
+	ID         string   // tenant ID
+	Root       string   // directory holding the tenant's namespace
+	Users      []string // users of the tenant, ordered
+	Files      int      // files below the root
+	Space      int64    // bytes of file data below the root
+	FileQuota  int      // most files below the root, 0 for no limit
+	SpaceQuota int64    // most bytes of file data below the root, 0 for no limit
+	Rate       float64  // most requests per second, 0 for no limit
+	Requests   int64    // requests made
+	Limited    int64    // requests refused for the rate limit
+	Denied     int64    // requests refused for naming paths outside the root
+}
+
+// TenantUsages describes the use each tenant makes of the namenode, ordered
+// by ID
+func (nn *NameNode) TenantUsages() []TenantUsage {
+	nn.tenantLock.Lock()
+	tenants := make([]*tenant, 0, len(nn.tenants))
+	for _, t := range nn.tenants {
+		tenants = append(tenants, t)
+	}
+	users := make(map[*tenant][]string)
+	for u, t := range nn.tenantUsers {
+		users[t] = append(users[t], u)
+	}
+	nn.tenantLock.Unlock()
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+
+	list := make([]TenantUsage, 0, len(tenants))
+	for _, t := range tenants {
+		sort.Strings(users[t])
+		u := TenantUsage{
+			ID: t.ID, Root: nn.tenantRoot(t), Users: users[t], Rate: t.Rate,
+			Requests: atomic.LoadInt64(&t.requests), Limited: atomic.LoadInt64(&t.limited), Denied: atomic.LoadInt64(&t.denied),
+		}
+		u.FileQuota, u.SpaceQuota = t.Files, t.Space
+		if n := nn.lookup(u.Root); n != nil {
+			u.Files, u.Space = nn.usage(n)
+			u.FileQuota, u.SpaceQuota = nn.quotaOf(n)
+		}
+		list = append(list, u)
+	}
+	return list
+}
+
+// Tenants reloads the tenants file if action is refresh, and describes the
+// use each tenant makes of the namenode
+func (nn *NameNode) Tenants(action string) (string, error) {
+	switch action {
+	case "refresh":
+		if nn.tenantsFile == "" {
+			return "", errors.New("No tenants file is configured")
+		}
+		err := nn.LoadTenants()
+		if err != nil {
+			return "", err
+		}
+		nn.log.Info("Reloaded tenants", "file", nn.tenantsFile)
+	case "":
+	default:
+		return "", errors.New("Tenants action must be refresh or empty")
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Tenant\tRoot\tUsers\tFiles\tSpace\tRate\tRequests\tLimited\tDenied")
+	limit := func(used, quota int64) string {
+		if quota == 0 {
+			return strconv.FormatInt(used, 10)
+		}
+		return strconv.FormatInt(used, 10) + "/" + strconv.FormatInt(quota, 10)
+	}
+	for _, u := range nn.TenantUsages() {
+		rate := "-"
+		if u.Rate > 0 {
+			rate = strconv.FormatFloat(u.Rate, 'g', -1, 64) + "/s"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\n", u.ID, u.Root, strings.Join(u.Users, ","),
+			limit(int64(u.Files), int64(u.FileQuota)), limit(u.Space, u.SpaceQuota), rate, u.Requests, u.Limited, u.Denied)
+	}
+	w.Flush()
+	return buf.String(), nil
+}
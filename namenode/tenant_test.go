@@ -0,0 +1,135 @@
+package namenode
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTenants(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tenants")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(xml string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, "tenants.xml"), []byte(xml), 0644); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	nn := New()
+	nn.superuser = "hdfs"
+	nn.admins["alice"] = true
+	nn.sizeofblock = 4
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.tenantsFile = filepath.Join(dir, "tenants.xml")
+	write(`<tenants>
+	<tenant id="acme" users="bob, carol" files="2"/>
+	<tenant id="slow" users="dave" rate="2"/>
+</tenants>`)
+	if err := nn.LoadTenants(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	nn.Mkdir("/tenants/acme", true)
+	nn.Mkdir("/shared", false)
+
+	// tenants are confined to their own root
+	for _, p := range []Packet{
+		{CMD: MKDIR, Headers: []BlockHeader{{Filename: "/shared/x"}}},
+		{CMD: RENAME, Headers: []BlockHeader{{Filename: "/tenants/acme/a"}}, Renamed: []BlockHeader{{Filename: "/shared/a"}}},
+		{CMD: CREATESYMLINK, Message: "/shared", Headers: []BlockHeader{{Filename: "/tenants/acme/link"}}},
+		{CMD: DELETE, Headers: []BlockHeader{{Filename: "/tenants/acme"}}},
+		{CMD: STAT, Headers: []BlockHeader{{Filename: "/tenants/acmex"}}},
+		{CMD: LIST},
+	} {
+		p.SRC, p.User = "C", "bob"
+		if err := nn.checkTenant(p); err == nil || !strings.Contains(err.Error(), "Permission denied") {
+			t.Errorf("%s request of a tenant allowed %v", CommandName(p.CMD), err)
+		}
+	}
+	for _, user := range []string{"bob", "carol", "alice", "hdfs", "eve"} {
+		p := Packet{SRC: "C", CMD: MKDIR, User: user, Headers: []BlockHeader{{Filename: "/tenants/acme/" + user}}}
+		if err := nn.checkTenant(p); err != nil {
+			t.Errorf("Request of %s refused %s", user, err)
+		}
+	}
+	if err := nn.checkTenant(Packet{SRC: "C", CMD: MKDIR, User: "alice", Headers: []BlockHeader{{Filename: "/shared/y"}}}); err != nil {
+		t.Errorf("Administrator confined to a tenant %s", err)
+	}
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: MKDIR, User: "bob", Headers: []BlockHeader{{Filename: "/shared/z"}}})
+	if nn.lookup("/shared/z") != nil {
+		t.Errorf("Tenant created a directory outside its root")
+	}
+
+	// the limits of a tenant hold for its root
+	_, err = nn.AssignBlock(Block{BlockHeader{"", "/tenants/acme/a.txt", 1, 0, 1, 0, "", 0, ""}, []byte{0}})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	nn.MergeNode(BlockHeader{"DN1", "/tenants/acme/a.txt", 1, 0, 1, 0, "", 0, ""})
+	nn.MergeNode(BlockHeader{"DN1", "/tenants/acme/b.txt", 1, 0, 1, 0, "", 0, ""})
+	_, err = nn.AssignBlock(Block{BlockHeader{"", "/tenants/acme/c.txt", 1, 0, 1, 0, "", 0, ""}, []byte{0}})
+	if err == nil {
+		t.Errorf("Accepted file beyond the limit of a tenant")
+	}
+	if err := nn.SetQuota("/tenants/acme", 1, 0); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if files, _ := nn.quotaOf(nn.lookup("/tenants/acme")); files != 1 {
+		t.Errorf("Tighter directory quota not kept, %d files", files)
+	}
+
+	// requests beyond the rate of a tenant are refused
+	limited := 0
+	for i := 0; i < 5; i++ {
+		err := nn.checkTenant(Packet{SRC: "C", CMD: STAT, User: "dave", Headers: []BlockHeader{{Filename: "/tenants/slow"}}})
+		if err != nil && strings.HasPrefix(err.Error(), throttledMessage) {
+			limited++
+		}
+	}
+	if limited != 3 {
+		t.Errorf("Refused %d requests, expected 3", limited)
+	}
+
+	var r Packet
+	nn.handleAdmin(Packet{SRC: "C", CMD: TENANTS, User: "bob"}, &r)
+	if r.CMD != ERROR {
+		t.Errorf("Tenants described to a user")
+	}
+	nn.handleAdmin(Packet{SRC: "C", CMD: TENANTS, User: "alice"}, &r)
+	lines := strings.Split(strings.TrimSpace(r.Message), "\n")
+	if r.CMD != ACK || len(lines) != 3 || !strings.Contains(lines[1], "bob,carol") || !strings.Contains(lines[1], "2/1") {
+		t.Errorf("Unexpected tenants report %v", r)
+	}
+	usages := nn.TenantUsages()
+	if usages[0].Denied != 7 || usages[1].Requests != 5 || usages[1].Limited != 3 {
+		t.Errorf("Unexpected usage %+v", usages)
+	}
+
+	// a refresh keeps the counts of the tenants which remain
+	write(`<tenants><tenant id="slow" users="dave"/></tenants>`)
+	nn.handleAdmin(Packet{SRC: "C", CMD: TENANTS, Message: "refresh", User: "alice"}, &r)
+	if usages := nn.TenantUsages(); r.CMD != ACK || len(usages) != 1 || usages[0].Requests != 5 || nn.tenantOf("bob") != nil {
+		t.Errorf("Unexpected usage after a refresh %+v %v", usages, r)
+	}
+
+	for _, xml := range []string{
+		`<tenants><tenant id="a" users="x"/><tenant id="b" users="x"/></tenants>`,
+		`<tenants><tenant id="a"/><tenant id="a"/></tenants>`,
+		`<tenants><tenant id="a/b"/></tenants>`,
+		`<tenants><tenant id="a" space="-1"/></tenants>`,
+	} {
+		write(xml)
+		if err := nn.LoadTenants(); err == nil {
+			t.Errorf("Loaded invalid tenants %s", xml)
+		}
+	}
+	if nn.tenantOf("dave") == nil {
+		t.Errorf("Tenants replaced by an invalid file")
+	}
+}
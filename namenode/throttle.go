@@ -0,0 +1,62 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// throttledMessage starts the errors of client requests refused for a rate
+// or concurrency limit, which clients make again after a backoff, as a HTTP
+// client retries a 429 Too Many Requests
+const throttledMessage = "Too many requests"
+
+// reasons client requests are refused, as counted in the metrics
+const (
+	refusedClientRate  = "client_rate"
+	refusedGlobalRate  = "global_rate"
+	refusedConcurrency = "concurrency"
+	refusedSize        = "size"
+)
+
+// admit returns an error if the client request p, read as size bytes from a
+// connection whose requests limiter allows, may not be handled: if it is
+// longer than maxRequestSize, if maxClientRequests requests are already
+// waiting or being handled, or if it is beyond the rate of its connection or
+// of all clients.
+func (nn *NameNode) admit(p Packet, size int64, limiter *rateLimiter) error {
+	if p.CMD == HB || p.CMD == HELLO {
+		return nil
+	}
+	if nn.maxRequestSize > 0 && size > nn.maxRequestSize {
+		nn.metrics.countRefused(refusedSize)
+		return fmt.Errorf("Request of %d bytes is longer than %d bytes", size, nn.maxRequestSize)
+	}
+	if nn.maxClientRequests > 0 && atomic.LoadInt64(&nn.clientRequests) >= int64(nn.maxClientRequests) {
+		nn.metrics.countRefused(refusedConcurrency)
+		return errors.New(throttledMessage + ": " + strconv.Itoa(nn.maxClientRequests) + " requests are being handled")
+	}
+	if limiter != nil && !limiter.allow() {
+		nn.metrics.countRefused(refusedClientRate)
+		return errors.New(throttledMessage + ": client rate limit of " + strconv.FormatFloat(nn.clientRate, 'g', -1, 64) + " requests per second exceeded")
+	}
+	if nn.globalLimiter != nil && !nn.globalLimiter.allow() {
+		nn.metrics.countRefused(refusedGlobalRate)
+		return errors.New(throttledMessage + ": rate limit of " + strconv.FormatFloat(nn.globalRate, 'g', -1, 64) + " requests per second exceeded")
+	}
+	return nil
+}
+
+// inFlight adds n to the client requests waiting or being handled, if p is one
+func (nn *NameNode) inFlight(p Packet, n int64) {
+	if p.SRC == "C" {
+		atomic.AddInt64(&nn.clientRequests, n)
+	}
+}
+
+// refuse answers the client request p with err without handling it
+func (nn *NameNode) refuse(p Packet, err error) {
+	nn.connLog.Debug("Refusing client request", "cmd", CommandName(p.CMD), "err", err)
+	nn.SendPacket(Packet{SRC: nn.id, DST: p.SRC, CMD: ERROR, Message: err.Error(), Code: errorCode(err), Headers: make([]BlockHeader, 0), RequestID: p.RequestID})
+}
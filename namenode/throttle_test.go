@@ -0,0 +1,125 @@
+package namenode
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestThrottle(t *testing.T) {
+
+	nn := New()
+	nn.clientRate = 2
+	nn.maxRequestSize = 1000
+	nn.maxClientRequests = 4
+	nn.Mkdir("/dir", false)
+
+	stat := Packet{SRC: "C", DST: "NN", CMD: STAT, Headers: []BlockHeader{{Filename: "/dir"}}}
+	if err := nn.admit(stat, 1001, nil); err == nil || strings.HasPrefix(err.Error(), throttledMessage) {
+		t.Errorf("Long request refused as %v", err)
+	}
+
+	// each connection has its own rate
+	limiter := newRateLimiter(nn.clientRate)
+	refused := 0
+	for i := 0; i < 5; i++ {
+		if err := nn.admit(stat, 100, limiter); err != nil {
+			if !strings.HasPrefix(err.Error(), throttledMessage) {
+				t.Errorf("Unexpected refusal %s", err)
+			}
+			refused++
+		}
+	}
+	if refused != 3 {
+		t.Errorf("Refused %d requests, expected 3", refused)
+	}
+	if err := nn.admit(Packet{SRC: "C", DST: "NN", CMD: HB}, 100, limiter); err != nil {
+		t.Errorf("Heartbeat refused %s", err)
+	}
+	if err := nn.admit(stat, 100, newRateLimiter(nn.clientRate)); err != nil {
+		t.Errorf("Request of another connection refused %s", err)
+	}
+
+	// and all of them share the global rate
+	nn.globalRate, nn.globalLimiter = 1, newRateLimiter(1)
+	if err := nn.admit(stat, 100, nil); err != nil {
+		t.Errorf("Request within the global rate refused %s", err)
+	}
+	if err := nn.admit(stat, 100, nil); err == nil {
+		t.Errorf("Request beyond the global rate admitted")
+	}
+	nn.globalRate, nn.globalLimiter = 0, nil
+
+	nn.clientRequests = 4
+	if err := nn.admit(stat, 100, nil); err == nil || !strings.HasPrefix(err.Error(), throttledMessage) {
+		t.Errorf("Request beyond the concurrency limit admitted %v", err)
+	}
+	nn.clientRequests = 0
+
+	// refused requests are answered with an error at once
+	nn.clientRate = 1
+	local, remote := net.Pipe()
+	defer local.Close()
+	go nn.HandleConnection(remote)
+	encoder, decoder := json.NewEncoder(local), json.NewDecoder(local)
+	go func() {
+		for id := int64(1); id <= 3; id++ {
+			p := stat
+			p.RequestID = id
+			encoder.Encode(p)
+		}
+	}()
+	answers := make(map[int64]Packet)
+	for len(answers) < 3 {
+		var r Packet
+		if err := decoder.Decode(&r); err != nil {
+			t.Fatalf("%s", err)
+		}
+		answers[r.RequestID] = r
+	}
+	if answers[1].CMD != STAT || answers[2].CMD != STAT {
+		t.Errorf("Requests within the rate refused %v", answers)
+	}
+	if r := answers[3]; r.CMD != ERROR || !strings.HasPrefix(r.Message, throttledMessage) {
+		t.Errorf("Request beyond the rate answered with %v", r)
+	}
+
+	rec := httptest.NewRecorder()
+	nn.ServeMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`godfs_requests_refused_total{reason="client_rate"} 4`,
+		`godfs_requests_refused_total{reason="global_rate"} 1`,
+		`godfs_requests_refused_total{reason="concurrency"} 1`,
+		`godfs_requests_refused_total{reason="size"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("Missing metric %s", line)
+		}
+	}
+
+	nn.globalRate, nn.globalLimiter = 1, newRateLimiter(1)
+	webhdfs(nn, "GET", "/dir?op=GETFILESTATUS")
+	if rec := webhdfs(nn, "GET", "/dir?op=GETFILESTATUS"); rec.Code != 429 {
+		t.Errorf("WebHDFS request beyond the global rate answered with %d", rec.Code)
+	}
+}
+
+func TestFrameOffset(t *testing.T) {
+	var buf bytes.Buffer
+	e := newFrameEncoder(&buf)
+	e.Encode(Packet{SRC: "C", CMD: STAT})
+	first := buf.Len()
+	e.Encode(Packet{SRC: "C", CMD: LIST, Data: Block{Data: make([]byte, 100)}})
+
+	d := newFrameDecoder(bytes.NewReader(buf.Bytes()))
+	var p Packet
+	if err := d.Decode(&p); err != nil || d.InputOffset() != int64(first) {
+		t.Errorf("Offset %d after the first frame of %d bytes %v", d.InputOffset(), first, err)
+	}
+	if err := d.Decode(&p); err != nil || d.InputOffset() != int64(buf.Len()) {
+		t.Errorf("Offset %d after frames of %d bytes %v", d.InputOffset(), buf.Len(), err)
+	}
+}
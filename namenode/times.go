@@ -0,0 +1,65 @@
+package namenode
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// default precision of access times: a read records its time only once the
+// access time of the file is older than this
+const defaultAccessTimePrecision = time.Hour
+
+// nowMillis returns the current time in milliseconds since the epoch, the
+// unit of the times of files and directories
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// newFilenode returns a filenode for path below parent, created now, and
+// records the change to the entries of parent
+func newFilenode(path string, parent *filenode) *filenode {
+	now := nowMillis()
+	parent.mtime = now
+	return &filenode{path: path, parent: parent, children: make([]*filenode, 0, 1), mtime: now, atime: now}
+}
+
+// modified records a change to the data of the file at path
+func (nn *NameNode) modified(path string) {
+	if n := nn.lookup(path); n != nil {
+		n.mtime = nowMillis()
+	}
+}
+
+// accessed records a read of the file at path, unless its access time is
+// within accessTimePrecision of now. Reads share the namespace, so the
+// access time is set atomically.
+func (nn *NameNode) accessed(path string) {
+	if nn.accessTimePrecision <= 0 {
+		return
+	}
+	n := nn.lookup(path)
+	if n == nil {
+		return
+	}
+	now := nowMillis()
+	if now-atomic.LoadInt64(&n.atime) >= int64(nn.accessTimePrecision/time.Millisecond) {
+		atomic.StoreInt64(&n.atime, now)
+	}
+}
+
+// SetTimes sets the modification and access times of the file or directory
+// at path, in milliseconds since the epoch. A negative time is left as it is.
+func (nn *NameNode) SetTimes(path string, mtime, atime int64) error {
+	n := nn.lookup(path)
+	if n == nil {
+		return newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+	if mtime >= 0 {
+		n.mtime = mtime
+	}
+	if atime >= 0 {
+		atomic.StoreInt64(&n.atime, atime)
+	}
+	nn.metaLog.Info("Set times", "path", path, "mtime", mtime, "atime", atime)
+	return nil
+}
@@ -0,0 +1,78 @@
+package namenode
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTimes(t *testing.T) {
+
+	nn := New()
+	nn.metadatafile = filepath.Join(t.TempDir(), "metadata.json")
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	before := nowMillis()
+	nn.MergeNode(BlockHeader{"DN1", "/dir/out.txt", 1, 0, 1, 0, "", 0, ""})
+
+	st, err := nn.Stat("/dir/out.txt")
+	if err != nil || st.ModTime < before || st.AccessTime < before {
+		t.Fatalf("Times of a new file not set %v %v", st, err)
+	}
+	if dir, _ := nn.Stat("/dir"); dir.ModTime < before {
+		t.Errorf("Modification time of the parent not set %v", dir)
+	}
+
+	// negative times are left as they are
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: SETTIMES, Headers: []BlockHeader{{Filename: "/dir/out.txt"}},
+		Status: []FileStatus{{ModTime: 1000, AccessTime: -1}}})
+	if st2, _ := nn.Stat("/dir/out.txt"); st2.ModTime != 1000 || st2.AccessTime != st.AccessTime {
+		t.Errorf("Times not set %v", st2)
+	}
+	if nn.SetTimes("/missing", 1, 1) == nil {
+		t.Errorf("Set times of a missing file")
+	}
+
+	// reads update the access time once it is older than the precision
+	nn.SetTimes("/dir/out.txt", -1, 0)
+	nn.HandlePacket(Packet{SRC: "C", DST: "NN", CMD: GETHEADERS, Headers: []BlockHeader{{Filename: "/dir/out.txt"}}})
+	st, _ = nn.Stat("/dir/out.txt")
+	if st.AccessTime < before {
+		t.Errorf("Access time not updated by a read %v", st)
+	}
+	nn.SetTimes("/dir/out.txt", -1, st.AccessTime-1)
+	nn.accessed("/dir/out.txt")
+	if st2, _ := nn.Stat("/dir/out.txt"); st2.AccessTime != st.AccessTime-1 {
+		t.Errorf("Access time updated within the precision %v", st2)
+	}
+	nn.accessTimePrecision = 0
+	nn.SetTimes("/dir/out.txt", -1, 0)
+	nn.accessed("/dir/out.txt")
+	if st2, _ := nn.Stat("/dir/out.txt"); st2.AccessTime != 0 {
+		t.Errorf("Access time updated while disabled %v", st2)
+	}
+
+	// WebHDFS reports and sets times too
+	rec := webhdfs(nn, "PUT", "/dir?op=SETTIMES&modificationtime=2000")
+	if rec.Code != 200 {
+		t.Errorf("Unexpected SETTIMES %d %s", rec.Code, rec.Body.String())
+	}
+	rec = webhdfs(nn, "GET", "/dir?op=GETFILESTATUS")
+	if !strings.Contains(rec.Body.String(), `"modificationTime":2000`) {
+		t.Errorf("Unexpected GETFILESTATUS %s", rec.Body.String())
+	}
+
+	// times survive a restart
+	nn.SaveMetadata()
+	restarted := New()
+	restarted.metadatafile = nn.metadatafile
+	err = restarted.LoadMetadata()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if st, _ := restarted.Stat("/dir/out.txt"); st.ModTime != 1000 || st.AccessTime != 0 {
+		t.Errorf("File times not restored %v", st)
+	}
+	if st, _ := restarted.Stat("/dir"); st.ModTime != 2000 {
+		t.Errorf("Directory times not restored %v", st)
+	}
+}
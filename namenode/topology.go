@@ -0,0 +1,228 @@
+package namenode
+
+import (
+	"encoding/xml"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// rack of datanodes and hosts missing from the topology
+const defaultRack = "/default-rack"
+
+// network distances between a reader and a replica
+const (
+	sameHost = 0
+	sameRack = 2
+	offRack  = 4
+)
+
+// LoadTopology reads a topology file mapping datanode IDs or hosts to their
+// racks. The file uses the ConfigOptionList format of the configuration,
+// with the ID or host as key and the rack as value.
+func (nn *NameNode) LoadTopology(topologypath string) error {
+	f, err := os.Open(topologypath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var list ConfigOptionList
+	err = xml.NewDecoder(f).Decode(&list)
+	if err != nil {
+		return err
+	}
+
+	nn.topologyLock.Lock()
+	defer nn.topologyLock.Unlock()
+	for _, o := range list.ConfigOptions {
+		nn.topology[o.Key] = strings.TrimSpace(o.Value)
+	}
+	return nil
+}
+
+// rackOf returns the rack of a datanode ID or host. Names missing from the
+// topology file are given to the topology script, if configured, which
+// prints the rack. The script's answers are remembered.
+func (nn *NameNode) rackOf(name string) (string, bool) {
+	nn.topologyLock.Lock()
+	defer nn.topologyLock.Unlock()
+
+	if rack, ok := nn.topology[name]; ok {
+		return rack, rack != ""
+	}
+	if nn.topologyScript == "" || name == "" {
+		return "", false
+	}
+
+	out, err := exec.Command(nn.topologyScript, name).Output()
+	rack := strings.TrimSpace(string(out))
+	if err != nil {
+		nn.placementLog.Warn("Topology script failed", "name", name, "err", err)
+		rack = ""
+	}
+	nn.topology[name] = rack
+	return rack, rack != ""
+}
+
+// datanodeRack returns the rack of a datanode, looked up by ID and then by host
+func (nn *NameNode) datanodeRack(dn *datanode) string {
+	if rack, ok := nn.rackOf(dn.ID); ok {
+		return rack
+	}
+	return nn.hostRack(dn.host)
+}
+
+// hostRack returns the rack of a host
+func (nn *NameNode) hostRack(host string) string {
+	if rack, ok := nn.rackOf(host); ok {
+		return rack
+	}
+	return defaultRack
+}
+
+// remoteHost returns the host of a connection's remote address
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// distance is the network distance from a reader on host to a datanode
+func (nn *NameNode) distance(host string, dn *datanode) int {
+	if host != "" && host == dn.host {
+		return sameHost
+	}
+	if nn.hostRack(host) == nn.datanodeRack(dn) {
+		return sameRack
+	}
+	return offRack
+}
+
+// sortByDistance orders the replicas of a Block closest first to a reader on
+// host, choosing randomly between replicas at the same distance
+func (nn *NameNode) sortByDistance(host string, replicas []BlockHeader) []BlockHeader {
+	sorted := make([]BlockHeader, len(replicas))
+	copy(sorted, replicas)
+	rand.Shuffle(len(sorted), func(i, j int) { sorted[i], sorted[j] = sorted[j], sorted[i] })
+
+	distances := make(map[string]int, len(sorted))
+	for _, h := range sorted {
+		d := offRack + 1 // unknown datanodes come last
+		if dn, ok := nn.datanodemap[h.DatanodeID]; ok {
+			d = nn.distance(host, dn)
+		}
+		distances[h.DatanodeID] = d
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return distances[sorted[i].DatanodeID] < distances[sorted[j].DatanodeID]
+	})
+	return sorted
+}
+
+// otherReplica returns the replica of the Block of h closest to the client
+// which is not on the datanode avoid, for a hedged read
+func (nn *NameNode) otherReplica(h BlockHeader, avoid string) (BlockHeader, bool) {
+	return nn.untriedReplica(h, []string{avoid})
+}
+
+// untriedReplica returns the closest online replica of the Block h names
+// on none of the datanodes tried, if there is one
+func (nn *NameNode) untriedReplica(h BlockHeader, tried []string) (BlockHeader, bool) {
+	for _, r := range nn.sortByDistance(nn.clientHost, nn.replicas(h.Filename, h.BlockNum)) {
+		skip := nn.offline[r.DatanodeID]
+		for _, id := range tried {
+			skip = skip || r.DatanodeID == id
+		}
+		if !skip {
+			return r, true
+		}
+	}
+	return BlockHeader{}, false
+}
+
+// rackCount counts the distinct racks holding the replicas of a Block
+func (nn *NameNode) rackCount(replicas []BlockHeader) int {
+	racks := make(map[string]bool)
+	for _, h := range replicas {
+		if dn, ok := nn.datanodemap[h.DatanodeID]; ok {
+			racks[nn.datanodeRack(dn)] = true
+		}
+	}
+	return len(racks)
+}
+
+// chooseTarget picks the datanode for a new replica of a Block: the least
+// used connected datanode with room which does not hold the Block,
+// preferring datanodes with the storage its file's policy asks for, then
+// datanodes which are not slow, then racks which hold none of its replicas.
+// With hash placement the datanode coming first on the Block's ring is
+// picked instead. It returns nil if there is none.
+func (nn *NameNode) chooseTarget(replicas []BlockHeader) *datanode {
+	storage := ""
+	if len(replicas) > 0 {
+		storage = nn.newReplicaStorage(replicas[0].Filename, replicas)
+	}
+	return nn.pickTarget(replicas, storage, false)
+}
+
+// chooseStorageTarget picks the datanode a replica is moved to for the
+// storage type, as chooseTarget does among the datanodes having it
+func (nn *NameNode) chooseStorageTarget(replicas []BlockHeader, storage string) *datanode {
+	return nn.pickTarget(replicas, storage, true)
+}
+
+// pickTarget picks the target of chooseTarget, which must have volumes of
+// the storage type if required
+func (nn *NameNode) pickTarget(replicas []BlockHeader, storage string, required bool) *datanode {
+	holders := make(map[string]bool)
+	racks := make(map[string]bool)
+	var size int64
+	for _, h := range replicas {
+		size = int64(h.Size)
+		holders[h.DatanodeID] = true
+		if dn, ok := nn.datanodemap[h.DatanodeID]; ok {
+			racks[nn.datanodeRack(dn)] = true
+		}
+	}
+
+	var ranks map[string]int
+	if nn.placement == placementHash && len(replicas) > 0 {
+		ranks = nn.ringRanks(replicas[0])
+	}
+
+	var target *datanode
+	targetNewRack := false
+	for _, dn := range nn.datanodemap {
+		if holders[dn.ID] || dn.decommissioning || nn.offline[dn.ID] || !dn.hasRoom(size) {
+			continue
+		}
+		if required && !dn.hasStorage(storage) {
+			continue
+		}
+		newRack := !racks[nn.datanodeRack(dn)]
+		better := target == nil
+		switch {
+		case better:
+		case ranks != nil:
+			better = ranks[dn.ID] < ranks[target.ID]
+		case dn.hasStorage(storage) != target.hasStorage(storage):
+			better = dn.hasStorage(storage)
+		case dn.slow != target.slow:
+			better = !dn.slow
+		case newRack != targetNewRack:
+			better = newRack
+		default:
+			better = dn.size < target.size
+		}
+		if better {
+			target, targetNewRack = dn, newRack
+		}
+	}
+	return target
+}
@@ -0,0 +1,86 @@
+package namenode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTopology(t *testing.T) {
+
+	topologypath := filepath.Join(t.TempDir(), "topology.xml")
+	err := os.WriteFile(topologypath, []byte(`<ConfigOptionList>
+	<ConfigOption key="DN1">/rack1</ConfigOption>
+	<ConfigOption key="DN2">/rack1</ConfigOption>
+	<ConfigOption key="10.0.0.3">/rack2</ConfigOption>
+	<ConfigOption key="10.0.0.9">/rack2</ConfigOption>
+</ConfigOptionList>`), 0600)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	nn := New()
+	err = nn.LoadTopology(topologypath)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true, host: "10.0.0.1"}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", listed: true, host: "10.0.0.2", size: 5}
+	nn.datanodemap["DN3"] = &datanode{ID: "DN3", listed: true, host: "10.0.0.3", size: 10}
+	nn.datanodemap["DN4"] = &datanode{ID: "DN4", listed: true, host: "10.0.0.4"}
+
+	if rack := nn.datanodeRack(nn.datanodemap["DN3"]); rack != "/rack2" {
+		t.Errorf("Expected DN3 on /rack2 by host, got %s", rack)
+	}
+	if rack := nn.datanodeRack(nn.datanodemap["DN4"]); rack != defaultRack {
+		t.Errorf("Expected DN4 on the default rack, got %s", rack)
+	}
+
+	// a new replica goes to another rack, even if more used
+	replicas := []BlockHeader{{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""}}
+	nn.datanodemap["DN4"].decommissioning = true
+	if target := nn.chooseTarget(replicas); target == nil || target.ID != "DN3" {
+		t.Errorf("Expected DN3 as off rack target, got %v", target)
+	}
+
+	// reads prefer the same host, then the same rack
+	replicas = []BlockHeader{{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""}, {"DN3", "/out.txt", 1, 0, 1, 0, "", 0, ""}}
+	for i := 0; i < 10; i++ {
+		if h := nn.sortByDistance("10.0.0.3", replicas)[0]; h.DatanodeID != "DN3" {
+			t.Fatalf("Expected the local replica, got %v", h)
+		}
+		if h := nn.sortByDistance("10.0.0.9", replicas)[0]; h.DatanodeID != "DN3" {
+			t.Fatalf("Expected the same rack replica, got %v", h)
+		}
+	}
+
+	// moves which would put both replicas on one rack are not planned
+	if nn.keepsRacks(replicas, replicas[1], nn.datanodemap["DN2"]) {
+		t.Errorf("Move onto a single rack was allowed")
+	}
+	if !nn.keepsRacks(replicas, replicas[0], nn.datanodemap["DN2"]) {
+		t.Errorf("Move within a rack was refused")
+	}
+}
+
+func TestOtherReplica(t *testing.T) {
+
+	nn := New()
+	for _, id := range []string{"DN1", "DN2", "DN3"} {
+		nn.datanodemap[id] = &datanode{ID: id, listed: true}
+		nn.MergeNode(BlockHeader{id, "/out.txt", 1, 0, 1, 0, "", 0, ""})
+	}
+	nn.offline["DN3"] = true
+
+	// a hedged read goes to a connected datanode other than the slow one
+	for i := 0; i < 10; i++ {
+		h, ok := nn.otherReplica(BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""}, "DN1")
+		if !ok || h.DatanodeID != "DN2" {
+			t.Fatalf("Expected the replica on DN2, got %v", h)
+		}
+	}
+	nn.offline["DN2"] = true
+	if h, ok := nn.otherReplica(BlockHeader{"DN1", "/out.txt", 1, 0, 1, 0, "", 0, ""}, "DN1"); ok {
+		t.Errorf("Replica on an offline datanode chosen %v", h)
+	}
+}
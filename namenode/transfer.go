@@ -0,0 +1,63 @@
+package namenode
+
+import (
+	"time"
+)
+
+// sendReplication orders a copy of the replica source to the datanode
+// target. A source datanode supporting it is sent a REPLICATE, with its next
+// heartbeat if it takes commands, and streams the Block to the target's HTTP
+// server, otherwise the Block is retrieved and forwarded by the namenode. The
+// caller must hold replicateLock.
+func (nn *NameNode) sendReplication(source BlockHeader, target string, move bool) {
+	src, ok := nn.datanodemap[source.DatanodeID]
+	dst, found := nn.datanodemap[target]
+	if !ok || !found || !src.supports("replicate") || dst.httpAddr == "" {
+		nn.replications[source] = replicationOrder{Target: target, Sent: time.Now(), Move: move}
+		nn.SendPacket(Packet{SRC: nn.id, DST: source.DatanodeID, CMD: RETRIEVEBLOCK, Headers: []BlockHeader{source}})
+		return
+	}
+
+	// the copy goes to the storage the file's policy asks for, besides the
+	// replicas it does not replace
+	others := make([]BlockHeader, 0)
+	for _, h := range nn.replicas(source.Filename, source.BlockNum) {
+		if !move || h != source {
+			others = append(others, h)
+		}
+	}
+	storage := nn.newReplicaStorage(source.Filename, others)
+
+	// the target acknowledges the copy itself, possibly before the source
+	nn.replications[source] = replicationOrder{Target: target, Sent: time.Now()}
+	if move {
+		copied := source
+		copied.DatanodeID = target
+		nn.moving[copied] = pendingMove{source, time.Now()}
+	}
+	order := Packet{SRC: nn.id, DST: source.DatanodeID, CMD: REPLICATE, Headers: []BlockHeader{source}, Message: target, Address: dst.httpAddr, Storage: storage}
+	if src.supports("commands") {
+		nn.queueCommand(order)
+		return
+	}
+	nn.SendPacket(order)
+}
+
+// completeTransfer ends the replication of h once the source datanode
+// reported its transfer. A failed transfer is given up, so the Block is
+// copied again by the next check of its replicas.
+func (nn *NameNode) completeTransfer(h BlockHeader, failure string) {
+	nn.replicateLock.Lock()
+	o, ok := nn.replications[h]
+	delete(nn.replications, h)
+	if ok && failure != "" {
+		copied := h
+		copied.DatanodeID = o.Target
+		delete(nn.moving, copied)
+	}
+	nn.replicateLock.Unlock()
+
+	if ok && failure != "" {
+		nn.placementLog.Warn("Datanode could not transfer Block", "file", h.Filename, "block", h.BlockNum, "from", h.DatanodeID, "to", o.Target, "err", failure)
+	}
+}
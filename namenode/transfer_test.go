@@ -0,0 +1,50 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestReplicationTransfer(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true, features: []string{"replicate"}}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2", listed: true, httpAddr: "localhost:50075"}
+	nn.datanodemap["DN3"] = &datanode{ID: "DN3", listed: true}
+	h := BlockHeader{"DN1", "/out.txt", 10, 0, 1, 0, "", 0, ""}
+	nn.MergeNode(h)
+
+	// the source streams the copy and the target acknowledges it
+	nn.move(h, "DN2")
+	if o := nn.replications[h]; o.Target != "DN2" || o.Move {
+		t.Fatalf("Unexpected replication order %v", o)
+	}
+	if nn.pendingMoves() != 1 {
+		t.Errorf("Expected one pending move, got %d", nn.pendingMoves())
+	}
+	copied := h
+	copied.DatanodeID = "DN2"
+	nn.MergeNode(copied)
+	nn.completeMove(copied)
+	nn.completeTransfer(h, "")
+	if invalidated := nn.PendingInvalidations("DN1"); len(invalidated) != 1 || invalidated[0] != h {
+		t.Errorf("Original was not invalidated %v", invalidated)
+	}
+	if len(nn.replications) != 0 || nn.pendingMoves() != 0 {
+		t.Errorf("Transfer still pending")
+	}
+	nn.CompleteInvalidation("DN1", nn.PendingInvalidations("DN1"))
+
+	// a failed transfer is given up so that it can be retried
+	nn.move(copied, "DN1")
+	if o := nn.replications[copied]; !o.Move {
+		t.Errorf("Expected the Block retrieved by the namenode, got %v", o)
+	}
+	delete(nn.replications, copied)
+	nn.datanodemap["DN2"].features = []string{"replicate"}
+	nn.datanodemap["DN1"].httpAddr = "localhost:50076"
+	nn.move(copied, "DN1")
+	nn.completeTransfer(copied, "connection refused")
+	if len(nn.replications) != 0 || len(nn.moving) != 0 {
+		t.Errorf("Failed transfer still pending")
+	}
+}
@@ -0,0 +1,108 @@
+package namenode
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the directory holding each user's trash
+const trashRoot = "/.Trash"
+
+// trashEntry is the on disc representation of a path in the trash
+type trashEntry struct {
+	Path    string
+	Deleted time.Time
+}
+
+// inTrash reports whether path is the trash or below it
+func inTrash(path string) bool {
+	return path == trashRoot || strings.HasPrefix(path, trashRoot+"/")
+}
+
+// trashPath returns where path is moved to in the trash of user, which is
+// suffixed by the time if a path deleted before is still there
+func (nn *NameNode) trashPath(path, user string) string {
+	dst := trashRoot + "/" + user + path
+	if nn.lookup(dst) != nil {
+		dst += "." + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return dst
+}
+
+// MoveToTrash moves the file or directory at path to dst in the trash, from
+// where it is deleted once the trash interval has passed. A directory must
+// be empty unless recursive is set.
+func (nn *NameNode) MoveToTrash(path, dst string, recursive bool) error {
+	n := nn.lookup(path)
+	if n == nil {
+		return newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+	if n == nn.root {
+		return errors.New("Cannot delete the root directory")
+	}
+	if !nn.isFile(n) && len(n.children) > 0 && !recursive {
+		return errors.New("Directory not empty " + path)
+	}
+
+	err := nn.Mkdir(dst[:strings.LastIndex(dst, "/")], true)
+	if err != nil {
+		return err
+	}
+	err = nn.Rename(path, dst)
+	if err != nil {
+		return err
+	}
+
+	nn.trash[dst] = time.Now()
+	nn.metaLog.Info("Moved to trash", "path", path, "trash", dst)
+	return nil
+}
+
+// ExpungeTrash periodically deletes the paths which have been in the trash
+// for longer than the trash interval, until the namenode is shut down
+func (nn *NameNode) ExpungeTrash() {
+	interval := nn.trashInterval / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case now := <-tick.C:
+			nn.update(func() { nn.expunge(now) })
+		case <-nn.quit:
+			return
+		}
+	}
+}
+
+// expunge deletes the paths whose trash interval expired by now
+func (nn *NameNode) expunge(now time.Time) {
+	if nn.standby {
+		// the active namenode logs the paths it expunges
+		return
+	}
+	for path, deleted := range nn.trash {
+		if nn.lookup(path) == nil {
+			// restored or deleted from the trash
+			delete(nn.trash, path)
+			continue
+		}
+		if now.Sub(deleted) < nn.trashInterval {
+			continue
+		}
+
+		err := nn.Delete(path, true)
+		if err != nil {
+			nn.metaLog.Warn("Could not expunge trash", "path", path, "err", err)
+			continue
+		}
+		delete(nn.trash, path)
+		nn.logEdit(namespaceEdit(DELETE, path, RECURSIVE|SKIPTRASH))
+		nn.metaLog.Info("Expunged trash", "path", path)
+	}
+}
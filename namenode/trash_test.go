@@ -0,0 +1,104 @@
+package namenode
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenameDirectory(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	h := BlockHeader{"DN1", "/a/b/out.txt", 1, 0, 1, 0, "", 0, ""}
+	nn.MergeNode(h)
+	nn.Mkdir("/c", false)
+
+	err := nn.Rename("/a/b", "/c/d")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if nn.lookup("/a") != nil {
+		t.Errorf("Empty source directory was not removed")
+	}
+	moved := BlockHeader{"DN1", "/c/d/out.txt", 1, 0, 1, 0, "", 0, ""}
+	if _, ok := nn.filemap.Get("/c/d/out.txt"); !ok || nn.lookup("/c/d/out.txt") == nil {
+		t.Fatalf("File was not moved")
+	}
+
+	pending := nn.PendingRenames("DN1")
+	if len(pending) != 1 || pending[0].From != h || pending[0].To != moved {
+		t.Errorf("Unexpected pending renames %v", pending)
+	}
+
+	// reports under the old name are ignored until the datanode renames
+	nn.ApplyFullReport(nn.datanodemap["DN1"], 1, []BlockHeader{h})
+	if _, ok := nn.filemap.Get("/a/b/out.txt"); ok {
+		t.Errorf("Old name merged from report")
+	}
+	if len(nn.replicas("/c/d/out.txt", 0)) != 1 {
+		t.Errorf("Renamed replica removed by full report")
+	}
+
+	nn.CompleteRename("DN1", []BlockHeader{h})
+	if len(nn.PendingRenames("DN1")) != 0 {
+		t.Errorf("Rename still pending after acknowledgement")
+	}
+
+	if nn.Rename("/c", "/c/d/e") == nil {
+		t.Errorf("Moved a directory into itself")
+	}
+	if nn.Rename("/missing", "/x") == nil {
+		t.Errorf("Moved a missing path")
+	}
+	if nn.Rename("/c/d/out.txt", "/nodir/out.txt") == nil {
+		t.Errorf("Moved into a missing directory")
+	}
+}
+
+func TestTrash(t *testing.T) {
+
+	nn := New()
+	nn.trashInterval = time.Hour
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/dir/out.txt", 1, 0, 1, 0, "", 0, ""})
+
+	p := Packet{SRC: "C", DST: "NN", CMD: DELETE, Flags: RECURSIVE, Headers: []BlockHeader{{Filename: "/dir"}}}
+	var r Packet
+	nn.handleNamespace(p, &r)
+	if r.CMD != ACK {
+		t.Fatalf("Delete failed %v", r)
+	}
+	if nn.lookup("/dir") != nil || nn.lookup("/.Trash/C/dir/out.txt") == nil {
+		t.Fatalf("Directory was not moved to the trash")
+	}
+	if len(nn.PendingInvalidations("DN1")) != 0 {
+		t.Errorf("Trashed Blocks were invalidated")
+	}
+
+	// deleting again with the same name keeps both
+	nn.MergeNode(BlockHeader{"DN1", "/dir/out.txt", 2, 0, 1, 0, "", 0, ""})
+	nn.handleNamespace(p, &r)
+	if len(nn.trash) != 2 {
+		t.Errorf("Expected two paths in the trash, got %v", nn.trash)
+	}
+
+	nn.expunge(time.Now())
+	if len(nn.trash) != 2 {
+		t.Errorf("Trash expunged before the interval passed")
+	}
+	nn.expunge(time.Now().Add(2 * time.Hour))
+	if len(nn.trash) != 0 || nn.lookup("/.Trash/C/dir") != nil {
+		t.Errorf("Trash was not expunged %v", nn.trash)
+	}
+	if len(nn.PendingInvalidations("DN1")) != 2 {
+		t.Errorf("Expunged Blocks were not invalidated")
+	}
+
+	// skipTrash deletes immediately
+	nn.MergeNode(BlockHeader{"DN1", "/now.txt", 1, 0, 1, 0, "", 0, ""})
+	p = Packet{SRC: "C", DST: "NN", CMD: DELETE, Flags: SKIPTRASH, Headers: []BlockHeader{{Filename: "/now.txt"}}}
+	nn.handleNamespace(p, &r)
+	if len(nn.trash) != 0 || len(nn.PendingInvalidations("DN1")) != 3 {
+		t.Errorf("skipTrash moved the file to the trash")
+	}
+}
@@ -0,0 +1,94 @@
+package namenode
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Truncate shortens the file at path to length bytes. The Blocks past length
+// are invalidated, and the replicas of the Blocks kept are renamed to headers
+// counting the Blocks left. When length falls within a Block, its datanodes
+// trim their replicas to the new size under a new generation stamp, so a
+// replica which missed the trim is stale once it is reported.
+func (nn *NameNode) Truncate(path string, length int64) error {
+	blocks, ok := nn.filemap.Get(path)
+	if !ok {
+		return newError(ErrFileNotFound, "File not found "+path)
+	}
+	if _, ok := nn.erasureOf(path); ok {
+		return errors.New("Cannot truncate the erasure coded file " + path)
+	}
+	if nn.leaseHolder(path) != "" {
+		return errors.New("File is being written " + path)
+	}
+	st := blocksStatus(path, blocks)
+	if len(blocks) < st.NumBlocks {
+		return errors.New("Missing Blocks of " + path)
+	}
+	if length <= 0 || length > st.Size {
+		return errors.New("Cannot truncate " + path + " of " + strconv.FormatInt(st.Size, 10) + " bytes to " + strconv.FormatInt(length, 10))
+	}
+	if length == st.Size {
+		return nil
+	}
+	for _, replicas := range blocks {
+		for _, h := range replicas {
+			if nn.inSnapshot(h) {
+				return errors.New("Cannot truncate a file captured in a snapshot " + path)
+			}
+		}
+	}
+
+	// last is the Block holding the last byte kept, of which trim bytes are kept
+	last, offset := 0, int64(0)
+	for offset+int64(blocks[last][0].Size) < length {
+		offset += int64(blocks[last][0].Size)
+		last++
+	}
+	trim := int(length - offset)
+	trimmed := trim < blocks[last][0].Size
+	if _, ok := nn.keyOf(path); ok && trimmed && blocks[last][0].Codec != "" {
+		return errors.New("Cannot trim a compressed Block of the encrypted file " + path)
+	}
+
+	for num := last + 1; num < st.NumBlocks; num++ {
+		for _, h := range blocks[num] {
+			if dn, ok := nn.datanodemap[h.DatanodeID]; ok {
+				dn.size -= int64(h.Size)
+			}
+			nn.Invalidate(h)
+		}
+		delete(blocks, num)
+	}
+
+	var stamp int64
+	if trimmed {
+		stamp = nn.nextGenStamp()
+	}
+	orders := make(map[string][]renameOrder)
+	for num, replicas := range blocks {
+		kept := make([]BlockHeader, len(replicas))
+		for i, h := range replicas {
+			kept[i] = h
+			kept[i].NumBlocks = last + 1
+			if num == last && trimmed {
+				// datanodes store the trimmed data uncompressed
+				kept[i].Size = trim
+				kept[i].GenStamp = stamp
+				kept[i].Codec = ""
+				if dn, ok := nn.datanodemap[h.DatanodeID]; ok {
+					dn.size -= int64(h.Size - trim)
+				}
+			}
+			orders[h.DatanodeID] = append(orders[h.DatanodeID], renameOrder{h, kept[i]})
+		}
+		blocks[num] = kept
+	}
+	nn.filemap.Put(path, blocks)
+	nn.modified(path)
+	for id, list := range orders {
+		nn.renameBlocks(id, list)
+	}
+	nn.metaLog.Info("Truncated file", "path", path, "length", length, "blocks", last+1)
+	return nil
+}
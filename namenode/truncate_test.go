@@ -0,0 +1,72 @@
+package namenode
+
+import (
+	"testing"
+)
+
+func TestTruncate(t *testing.T) {
+
+	nn := New()
+	for _, id := range []string{"DN1", "DN2"} {
+		nn.datanodemap[id] = &datanode{ID: id, listed: true}
+		nn.offline[id] = true
+		for num, size := range []int{4, 4, 2} {
+			nn.MergeNode(BlockHeader{id, "/out.txt", size, num, 3, 1, "", 0, ""})
+		}
+	}
+
+	if err := nn.Truncate("/out.txt", 11); err == nil {
+		t.Errorf("Expected an error truncating to a greater length")
+	}
+	nn.AcquireLease("/out.txt", "C1")
+	if err := nn.Truncate("/out.txt", 6); err == nil {
+		t.Errorf("Expected an error truncating a file being written")
+	}
+	nn.ReleaseLease("/out.txt", "C1")
+
+	err := nn.Truncate("/out.txt", 6)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	st, _ := nn.Stat("/out.txt")
+	if st.Size != 6 || st.NumBlocks != 2 || st.Replication != 2 {
+		t.Errorf("Expected 6 bytes in 2 Blocks, got %v", st)
+	}
+	if nn.datanodemap["DN1"].size != 6 {
+		t.Errorf("Expected 6 bytes on DN1, got %d", nn.datanodemap["DN1"].size)
+	}
+
+	// the last Block is dropped, the first renamed and the second trimmed
+	pending := nn.PendingInvalidations("DN1")
+	if len(pending) != 1 || pending[0].BlockNum != 2 {
+		t.Errorf("Expected the last Block invalidated, got %v", pending)
+	}
+	renames := nn.PendingRenames("DN1")
+	if len(renames) != 2 {
+		t.Fatalf("Expected 2 renames, got %v", renames)
+	}
+	for _, o := range renames {
+		if o.To.NumBlocks != 2 {
+			t.Errorf("Expected Blocks of 2, got %v", o.To)
+		}
+		if o.From.BlockNum == 1 && (o.To.Size != 2 || o.To.GenStamp <= o.From.GenStamp) {
+			t.Errorf("Expected the second Block trimmed under a new generation, got %v", o.To)
+		}
+	}
+
+	// an untrimmed replica reported once the trim is done is stale
+	var untrimmed BlockHeader
+	for _, o := range renames {
+		if o.From.BlockNum == 1 {
+			untrimmed = o.From
+		}
+	}
+	nn.CompleteRename("DN1", []BlockHeader{renames[0].From, renames[1].From})
+	nn.mergeReported(untrimmed)
+	if !nn.isInvalidated(untrimmed) {
+		t.Errorf("Untrimmed replica not invalidated")
+	}
+	if st, _ := nn.Stat("/out.txt"); st.Size != 6 {
+		t.Errorf("Untrimmed replica changed the size to %d", st.Size)
+	}
+}
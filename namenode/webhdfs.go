@@ -0,0 +1,332 @@
+package namenode
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// the URL prefix of WebHDFS requests
+const webhdfsPrefix = "/webhdfs/v1"
+
+// webhdfsStatus is the WebHDFS FileStatus JSON object
+type webhdfsStatus struct {
+	AccessTime       int64  `json:"accessTime"`
+	BlockSize        int    `json:"blockSize"`
+	ChildrenNum      int    `json:"childrenNum"`
+	Group            string `json:"group"`
+	Length           int64  `json:"length"`
+	ModificationTime int64  `json:"modificationTime"`
+	Owner            string `json:"owner"`
+	PathSuffix       string `json:"pathSuffix"`
+	Permission       string `json:"permission"`
+	Replication      int    `json:"replication"`
+	Symlink          string `json:"symlink,omitempty"`
+	Type             string `json:"type"`
+}
+
+// webhdfsDiffTypes names the changes of a snapshot diff in WebHDFS
+var webhdfsDiffTypes = map[string]string{diffCreated: "CREATE", diffDeleted: "DELETE", diffModified: "MODIFY", diffRenamed: "RENAME"}
+
+// blockLocation describes the replicas of a Block. Names are the HTTP
+// addresses of the datanodes holding it.
+type blockLocation struct {
+	Offset int64    `json:"offset"`
+	Length int64    `json:"length"`
+	Hosts  []string `json:"hosts"`
+	Names  []string `json:"names"`
+}
+
+// ServeWebHDFS serves the namenode operations of the WebHDFS REST API.
+// File contents are read and written by redirecting to a datanode.
+func (nn *NameNode) ServeWebHDFS(w http.ResponseWriter, r *http.Request) {
+	p := path.Clean("/" + strings.TrimPrefix(r.URL.Path, webhdfsPrefix))
+	q := r.URL.Query()
+	op := strings.ToUpper(q.Get("op"))
+	if nn.globalLimiter != nil && !nn.globalLimiter.allow() {
+		nn.metrics.countRefused(refusedGlobalRate)
+		webhdfsError(w, http.StatusTooManyRequests, "RetriableException", throttledMessage)
+		return
+	}
+	if r.Method == "GET" {
+		nn.state.RLock()
+		defer nn.state.RUnlock()
+	} else {
+		nn.state.Lock()
+		defer nn.state.Unlock()
+	}
+
+	_, pathErr := nn.cleanPath(p)
+	if pathErr == nil {
+		p, pathErr = nn.resolve(p, op != "DELETE" && op != "MKDIRS")
+	}
+
+	switch {
+	case nn.standby:
+		webhdfsError(w, http.StatusForbidden, "StandbyException", standbyMessage)
+
+	case pathErr != nil:
+		webhdfsError(w, http.StatusBadRequest, "IllegalArgumentException", pathErr.Error())
+
+	case nn.safeMode && r.Method != "GET":
+		webhdfsError(w, http.StatusForbidden, "SafeModeException", "Namenode is in safe mode, the namespace is read-only")
+
+	case op == "GETFILESTATUS" && r.Method == "GET":
+		st, err := nn.Stat(p)
+		if err != nil {
+			webhdfsError(w, http.StatusNotFound, "FileNotFoundException", err.Error())
+			return
+		}
+		s := nn.webhdfsStatus(st)
+		s.PathSuffix = ""
+		writeWebHDFS(w, map[string]interface{}{"FileStatus": s})
+
+	case op == "LISTSTATUS" && r.Method == "GET":
+		list, err := nn.ListDir(p, false)
+		if err != nil {
+			webhdfsError(w, http.StatusNotFound, "FileNotFoundException", err.Error())
+			return
+		}
+		statuses := make([]webhdfsStatus, 0, len(list))
+		for _, st := range list {
+			s := nn.webhdfsStatus(st)
+			if st.Path == p {
+				// listing a file returns the file itself
+				s.PathSuffix = ""
+			}
+			statuses = append(statuses, s)
+		}
+		writeWebHDFS(w, map[string]interface{}{"FileStatuses": map[string]interface{}{"FileStatus": statuses}})
+
+	case op == "MKDIRS" && r.Method == "PUT":
+		existed := nn.lookup(p) != nil
+		err := nn.Mkdir(p, true)
+		if err != nil {
+			webhdfsError(w, http.StatusForbidden, "FileAlreadyExistsException", err.Error())
+			return
+		}
+		if !existed {
+			nn.notify(eventCreate, p, "")
+			nn.logEdit(namespaceEdit(MKDIR, p, PARENTS))
+		}
+		writeWebHDFS(w, map[string]bool{"boolean": true})
+
+	case op == "DELETE" && r.Method == "DELETE":
+		if nn.lookup(p) == nil {
+			writeWebHDFS(w, map[string]bool{"boolean": false})
+			return
+		}
+		err := nn.Delete(p, q.Get("recursive") == "true")
+		if err != nil {
+			webhdfsError(w, http.StatusForbidden, "IOException", err.Error())
+			return
+		}
+		nn.notify(eventDelete, p, "")
+		nn.logEdit(namespaceEdit(DELETE, p, RECURSIVE|SKIPTRASH))
+		writeWebHDFS(w, map[string]bool{"boolean": true})
+
+	case op == "GETFILEBLOCKLOCATIONS" && r.Method == "GET":
+		locations, err := nn.blockLocations(p, remoteHost(r.RemoteAddr))
+		if err != nil {
+			webhdfsError(w, http.StatusNotFound, "FileNotFoundException", err.Error())
+			return
+		}
+		writeWebHDFS(w, map[string]interface{}{"BlockLocations": map[string]interface{}{"BlockLocation": locations}})
+
+	case op == "GETSNAPSHOTDIFF" && r.Method == "GET":
+		list, err := nn.SnapshotDiff(p, q.Get("oldsnapshotname"), q.Get("snapshotname"))
+		if err != nil {
+			webhdfsError(w, http.StatusNotFound, "SnapshotException", err.Error())
+			return
+		}
+		root := strings.TrimSuffix(p, "/") + "/"
+		diffs := make([]map[string]string, 0, len(list))
+		for _, st := range list {
+			d := map[string]string{"type": webhdfsDiffTypes[st.Change], "sourcePath": strings.TrimPrefix(st.Path, root)}
+			if st.Source != "" {
+				d["sourcePath"], d["targetPath"] = strings.TrimPrefix(st.Source, root), strings.TrimPrefix(st.Path, root)
+			}
+			diffs = append(diffs, d)
+		}
+		writeWebHDFS(w, map[string]interface{}{"SnapshotDiffReport": map[string]interface{}{
+			"snapshotRoot": p, "fromSnapshot": q.Get("oldsnapshotname"), "toSnapshot": q.Get("snapshotname"), "diffList": diffs,
+		}})
+
+	case op == "OPEN" && r.Method == "GET":
+		locations, err := nn.blockLocations(p, remoteHost(r.RemoteAddr))
+		if err != nil {
+			webhdfsError(w, http.StatusNotFound, "FileNotFoundException", err.Error())
+			return
+		}
+		if len(locations) == 0 || len(locations[0].Names) == 0 {
+			webhdfsError(w, http.StatusServiceUnavailable, "IOException", "No datanode can serve "+p)
+			return
+		}
+		nn.accessed(p)
+		nn.redirect(w, r, locations[0].Names[0], p)
+
+	case op == "SETTIMES" && r.Method == "PUT":
+		mtime, atime := int64(-1), int64(-1)
+		var err error
+		if v := q.Get("modificationtime"); v != "" {
+			mtime, err = strconv.ParseInt(v, 10, 64)
+		}
+		if v := q.Get("accesstime"); v != "" && err == nil {
+			atime, err = strconv.ParseInt(v, 10, 64)
+		}
+		if err != nil {
+			webhdfsError(w, http.StatusBadRequest, "IllegalArgumentException", err.Error())
+			return
+		}
+		err = nn.SetTimes(p, mtime, atime)
+		if err != nil {
+			webhdfsError(w, http.StatusNotFound, "FileNotFoundException", err.Error())
+			return
+		}
+		e := namespaceEdit(SETTIMES, p, 0)
+		e.Request.Status = []FileStatus{{ModTime: mtime, AccessTime: atime}}
+		nn.logEdit(e)
+		w.WriteHeader(http.StatusOK)
+
+	case op == "CREATE" && r.Method == "PUT":
+		if zone := nn.zoneOf(p); zone != nil {
+			webhdfsError(w, http.StatusForbidden, "IOException", "Files cannot be written over WebHDFS in the encryption zone "+zone.path)
+			return
+		}
+		if holder := nn.leaseHolder(p); holder != "" {
+			webhdfsError(w, http.StatusForbidden, "FileAlreadyExistsException", p+" is being written by "+holder)
+			return
+		}
+		if n := nn.lookup(p); n != nil {
+			if !nn.isFile(n) {
+				webhdfsError(w, http.StatusForbidden, "FileAlreadyExistsException", p+" is a directory")
+				return
+			}
+			if q.Get("overwrite") != "true" {
+				webhdfsError(w, http.StatusForbidden, "FileAlreadyExistsException", p+" already exists")
+				return
+			}
+			nn.DeleteFile(p)
+			nn.notify(eventDelete, p, "")
+			nn.logEdit(namespaceEdit(DELETE, p, SKIPTRASH))
+		}
+		addresses := make([]string, 0, len(nn.datanodemap))
+		for _, dn := range nn.datanodemap {
+			if dn.httpAddr != "" && !nn.offline[dn.ID] {
+				addresses = append(addresses, dn.httpAddr)
+			}
+		}
+		if len(addresses) == 0 {
+			webhdfsError(w, http.StatusServiceUnavailable, "IOException", "No datanode can accept "+p)
+			return
+		}
+		nn.redirect(w, r, addresses[rand.Intn(len(addresses))], p)
+
+	default:
+		webhdfsError(w, http.StatusBadRequest, "IllegalArgumentException", "Unsupported operation "+r.Method+" op="+op)
+	}
+}
+
+// redirect sends a WebHDFS client to the datanode at address, telling the
+// datanode how to reach the namenode
+func (nn *NameNode) redirect(w http.ResponseWriter, r *http.Request, address, p string) {
+	q := r.URL.Query()
+	q.Set("namenodeaddress", r.Host)
+	u := url.URL{Scheme: "http", Host: address, Path: webhdfsPrefix + p, RawQuery: q.Encode()}
+	w.Header().Set("Location", u.String())
+	w.WriteHeader(http.StatusTemporaryRedirect)
+}
+
+// webhdfsStatus converts a FileStatus to its WebHDFS form
+func (nn *NameNode) webhdfsStatus(st FileStatus) webhdfsStatus {
+	s := webhdfsStatus{
+		BlockSize:   st.BlockSize,
+		Group:       "supergroup",
+		Owner:       "godfs",
+		PathSuffix:  path.Base(st.Path),
+		Permission:  "644",
+		Replication: st.Replication,
+		Type:        "FILE",
+		Length:      st.Size,
+
+		AccessTime:       st.AccessTime,
+		ModificationTime: st.ModTime,
+	}
+	if st.Symlink != "" {
+		s.Symlink = st.Symlink
+		s.Type = "SYMLINK"
+	}
+	if st.IsDir {
+		s.BlockSize = 0
+		s.ChildrenNum = st.Children
+		s.Permission = "755"
+		s.Type = "DIRECTORY"
+	}
+	return s
+}
+
+// blockLocations lists the replicas of each Block of a file in order, each
+// ordered from the closest to a reader on host
+func (nn *NameNode) blockLocations(p string, host string) ([]blockLocation, error) {
+	st, err := nn.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if st.Erasure != "" {
+		return nil, errors.New("Erasure coded files cannot be read over WebHDFS " + p)
+	}
+	if st.Key != nil {
+		return nil, errors.New("Encrypted files cannot be read over WebHDFS " + p)
+	}
+	blocks, _ := nn.filemap.Get(p)
+
+	locations := make([]blockLocation, 0, st.NumBlocks)
+	var offset int64
+	for i := 0; i < st.NumBlocks; i++ {
+		loc := blockLocation{Offset: offset, Hosts: []string{}, Names: []string{}}
+		for _, h := range nn.sortByDistance(host, blocks[i]) {
+			loc.Length = int64(h.Size)
+			dn, ok := nn.datanodemap[h.DatanodeID]
+			if !ok || dn.httpAddr == "" || nn.offline[dn.ID] {
+				continue
+			}
+			loc.Hosts = append(loc.Hosts, dn.ID)
+			loc.Names = append(loc.Names, dn.httpAddr)
+		}
+		offset += loc.Length
+		locations = append(locations, loc)
+	}
+	return locations, nil
+}
+
+// the Java classes of the exceptions WebHDFS clients expect
+var javaClassNames = map[string]string{
+	"FileNotFoundException":      "java.io.FileNotFoundException",
+	"FileAlreadyExistsException": "org.apache.hadoop.fs.FileAlreadyExistsException",
+	"IOException":                "java.io.IOException",
+	"IllegalArgumentException":   "java.lang.IllegalArgumentException",
+	"StandbyException":           "org.apache.hadoop.ipc.StandbyException",
+}
+
+func writeWebHDFS(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// webhdfsError writes a WebHDFS RemoteException
+func webhdfsError(w http.ResponseWriter, status int, exception, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"RemoteException": map[string]string{
+			"exception":     exception,
+			"javaClassName": javaClassNames[exception],
+			"message":       message,
+		},
+	})
+}
@@ -0,0 +1,92 @@
+package namenode
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func webhdfs(nn *NameNode, method, url string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	nn.Handler().ServeHTTP(rec, httptest.NewRequest(method, "http://nn:8081/webhdfs/v1"+url, nil))
+	return rec
+}
+
+func TestWebHDFSMetadata(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true, httpAddr: "dn1:50075"}
+	nn.MergeNode(BlockHeader{"DN1", "/dir/out.txt", 10, 0, 1, 0, "", 0, ""})
+
+	rec := webhdfs(nn, "GET", "/dir/out.txt?op=GETFILESTATUS")
+	var st struct{ FileStatus webhdfsStatus }
+	json.Unmarshal(rec.Body.Bytes(), &st)
+	if rec.Code != 200 || st.FileStatus.Type != "FILE" || st.FileStatus.Length != 10 {
+		t.Errorf("Unexpected GETFILESTATUS %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = webhdfs(nn, "PUT", "/dir/sub?op=MKDIRS")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"boolean":true`) {
+		t.Errorf("Unexpected MKDIRS %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = webhdfs(nn, "GET", "/dir?op=LISTSTATUS")
+	var list struct {
+		FileStatuses struct{ FileStatus []webhdfsStatus }
+	}
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.FileStatuses.FileStatus) != 2 {
+		t.Errorf("Unexpected LISTSTATUS %s", rec.Body.String())
+	}
+
+	rec = webhdfs(nn, "GET", "/missing?op=GETFILESTATUS")
+	if rec.Code != 404 || !strings.Contains(rec.Body.String(), "FileNotFoundException") {
+		t.Errorf("Unexpected missing GETFILESTATUS %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = webhdfs(nn, "DELETE", "/dir?op=DELETE")
+	if rec.Code != 403 {
+		t.Errorf("Deleted non empty directory %d", rec.Code)
+	}
+	rec = webhdfs(nn, "DELETE", "/dir?op=DELETE&recursive=true")
+	if rec.Code != 200 || nn.lookup("/dir") != nil {
+		t.Errorf("Unexpected DELETE %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = webhdfs(nn, "POST", "/dir?op=CONCAT")
+	if rec.Code != 400 {
+		t.Errorf("Unsupported operation returned %d", rec.Code)
+	}
+}
+
+func TestWebHDFSRedirects(t *testing.T) {
+
+	nn := New()
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true, httpAddr: "dn1:50075"}
+	nn.MergeNode(BlockHeader{"DN1", "/out.txt", 10, 0, 1, 0, "", 0, ""})
+
+	rec := webhdfs(nn, "GET", "/out.txt?op=OPEN")
+	location := rec.Header().Get("Location")
+	if rec.Code != 307 || !strings.HasPrefix(location, "http://dn1:50075/webhdfs/v1/out.txt?") ||
+		!strings.Contains(location, "namenodeaddress=nn%3A8081") {
+		t.Errorf("Unexpected OPEN redirect %d %s", rec.Code, location)
+	}
+
+	rec = webhdfs(nn, "PUT", "/out.txt?op=CREATE")
+	if rec.Code != 403 {
+		t.Errorf("CREATE replaced a file without overwrite %d", rec.Code)
+	}
+	rec = webhdfs(nn, "PUT", "/out.txt?op=CREATE&overwrite=true")
+	if rec.Code != 307 || !strings.HasPrefix(rec.Header().Get("Location"), "http://dn1:50075/webhdfs/v1/out.txt?") {
+		t.Errorf("Unexpected CREATE redirect %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if len(nn.PendingInvalidations("DN1")) != 1 {
+		t.Errorf("Overwritten file was not deleted")
+	}
+
+	rec = webhdfs(nn, "GET", "/out.txt?op=GETFILEBLOCKLOCATIONS")
+	if rec.Code != 404 {
+		t.Errorf("Block locations of deleted file returned %d", rec.Code)
+	}
+}
@@ -0,0 +1,186 @@
+package namenode
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// number of errors kept for the status page
+const recentErrorCount = 50
+
+// errorLog keeps the most recent errors reported by the namenode
+type errorLog struct {
+	mu      sync.Mutex
+	entries []errorEntry
+}
+
+type errorEntry struct {
+	Time    time.Time
+	Message string
+}
+
+// add records an error, discarding the oldest once the log is full
+func (l *errorLog) add(message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, errorEntry{time.Now(), message})
+	if len(l.entries) > recentErrorCount {
+		l.entries = l.entries[len(l.entries)-recentErrorCount:]
+	}
+}
+
+// recent returns the recorded errors, newest first
+func (l *errorLog) recent() []errorEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := make([]errorEntry, len(l.entries))
+	for i, e := range l.entries {
+		entries[len(l.entries)-1-i] = e
+	}
+	return entries
+}
+
+// datanodeStatus is a row of the datanode table on the status page
+type datanodeStatus struct {
+	ID            string
+	Online        bool
+	State         string // online, offline, decommissioning or decommissioned
+	LastHeartbeat string
+	Used          int64
+	Blocks        int
+	Rack          string
+	Free          string // free bytes reported by the datanode, and its failed volumes
+	Version       string // release and protocol version of the datanode
+	Latency       string // percentiles of the datanode's BLOCKACK latencies, and whether it is slow
+	Cache         string // bytes in the datanode's block cache, its pinned Blocks, hits and misses
+}
+
+// clusterStatus is rendered by the status page
+type clusterStatus struct {
+	ID              string
+	Datanodes       []datanodeStatus
+	Files           int
+	Blocks          int
+	UnderReplicated int
+	Replication     int
+	Namespace       string
+	Errors          []errorEntry
+}
+
+// status collects the information shown on the status page
+func (nn *NameNode) status() clusterStatus {
+	s := clusterStatus{
+		ID:              nn.id,
+		Files:           nn.filemap.Len(),
+		UnderReplicated: nn.underReplicated(),
+		Replication:     nn.replication,
+		Namespace:       nn.ListFiles(),
+		Errors:          nn.recentErrors.recent(),
+	}
+
+	var blockCounts map[string]int
+	s.Blocks, blockCounts = nn.replicaCounts()
+
+	for _, dn := range nn.datanodemap {
+		last := "never"
+		if !dn.lastHeartbeat.IsZero() {
+			last = time.Since(dn.lastHeartbeat).Truncate(time.Second).String() + " ago"
+		}
+		state := nn.datanodeState(dn)
+		free := "-"
+		if dn.hasCapacity {
+			free = strconv.FormatInt(dn.capacity, 10)
+			if dn.failedVolumes != "" {
+				free += " (failed " + dn.failedVolumes + ")"
+			}
+		}
+		version := "-"
+		if dn.protocol != 0 {
+			version = "protocol " + strconv.Itoa(dn.protocol)
+			if dn.software != "" {
+				version = dn.software + " (" + version + ")"
+			}
+		}
+		s.Datanodes = append(s.Datanodes, datanodeStatus{
+			ID:            dn.ID,
+			Online:        !nn.offline[dn.ID],
+			State:         state,
+			LastHeartbeat: last,
+			Used:          dn.size,
+			Blocks:        blockCounts[dn.ID],
+			Rack:          nn.datanodeRack(dn),
+			Free:          free,
+			Version:       version,
+			Latency:       dn.latencySummary(),
+			Cache:         dn.cacheSummary(),
+		})
+	}
+	for id := range nn.decommissioned {
+		s.Datanodes = append(s.Datanodes, datanodeStatus{ID: id, State: "decommissioned", LastHeartbeat: "-", Free: "-", Version: "-", Latency: "-", Cache: "-"})
+	}
+	sort.Slice(s.Datanodes, func(i, j int) bool { return s.Datanodes[i].ID < s.Datanodes[j].ID })
+	return s
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>GoDFS namenode {{.ID}}</title>
+<meta http-equiv="refresh" content="5">
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+.offline { color: #a00; }
+pre { background: #f4f4f4; padding: 1em; }
+</style>
+</head>
+<body>
+<h1>GoDFS namenode {{.ID}}</h1>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Files</th><td>{{.Files}}</td></tr>
+<tr><th>Blocks</th><td>{{.Blocks}}</td></tr>
+<tr><th>Replication</th><td>{{.Replication}}</td></tr>
+<tr><th>Under replicated blocks</th><td>{{.UnderReplicated}}</td></tr>
+</table>
+
+<h2>Datanodes</h2>
+<p><a href="/report">As JSON</a></p>
+<table>
+<tr><th>ID</th><th>State</th><th>Last heartbeat</th><th>Used (bytes)</th><th>Blocks</th><th>Rack</th><th>Free (bytes)</th><th>Version</th><th>Latency</th><th>Cache</th></tr>
+{{range .Datanodes}}<tr{{if not .Online}} class="offline"{{end}}><td>{{.ID}}</td><td>{{.State}}</td><td>{{.LastHeartbeat}}</td><td>{{.Used}}</td><td>{{.Blocks}}</td><td>{{.Rack}}</td><td>{{.Free}}</td><td>{{.Version}}</td><td>{{.Latency}}</td><td>{{.Cache}}</td></tr>
+{{else}}<tr><td colspan="10">No datanodes</td></tr>
+{{end}}</table>
+
+<h2>Namespace</h2>
+<pre>{{.Namespace}}</pre>
+
+<h2>Recent errors</h2>
+<table>
+{{range .Errors}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Message}}</td></tr>
+{{else}}<tr><td>No errors</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// ServeStatus renders the cluster status page
+func (nn *NameNode) ServeStatus(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var s clusterStatus
+	nn.view(func() { s = nn.status() })
+	err := statusTemplate.Execute(w, s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,41 @@
+package namenode
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusPage(t *testing.T) {
+
+	nn := New()
+	nn.id = "NN"
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true, lastHeartbeat: time.Now()}
+	nn.datanodemap["DN2"] = &datanode{ID: "DN2"}
+	nn.offline["DN2"] = true
+	nn.MergeNode(BlockHeader{"DN1", "/dir/out.txt", 1, 0, 1, 0, "", 0, ""})
+	nn.recentErrors.add("File not found /missing.txt")
+
+	rec := httptest.NewRecorder()
+	nn.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	body := rec.Body.String()
+
+	expected := []string{
+		"<td>DN1</td><td>online</td><td>0s ago</td><td>1</td><td>1</td>",
+		"<td>DN2</td><td>offline</td><td>never</td>",
+		"/dir/out.txt",
+		"File not found /missing.txt",
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Errorf("Status page missing %q", e)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	nn.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/nothing", nil))
+	if rec.Code != 404 {
+		t.Errorf("Expected 404 for unknown page, got %d", rec.Code)
+	}
+}
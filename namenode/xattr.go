@@ -0,0 +1,109 @@
+package namenode
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// default limits on extended attributes
+const (
+	defaultMaxXAttrs    = 32    // attributes of a file or directory
+	defaultMaxXAttrSize = 16384 // bytes of the name and value of an attribute
+)
+
+// checkXAttrName returns an error unless name is in the user namespace, free
+// for applications, or the system namespace, which only administrators may
+// change
+func (nn *NameNode) checkXAttrName(name, user string, change bool) error {
+	switch {
+	case strings.ContainsAny(name, "\n\x00"):
+		// names are listed one per line
+	case strings.HasPrefix(name, "user.") && len(name) > len("user."):
+		return nil
+	case strings.HasPrefix(name, "system.") && len(name) > len("system."):
+		if change {
+			return nn.authorize(user)
+		}
+		return nil
+	}
+	return errors.New("Invalid attribute name " + name + ", expected user.name or system.name")
+}
+
+// SetXAttr sets the extended attribute name of the file or directory at path
+// to value, as user
+func (nn *NameNode) SetXAttr(path, name string, value []byte, user string) error {
+	err := nn.checkXAttrName(name, user, true)
+	if err != nil {
+		return err
+	}
+	n := nn.lookup(path)
+	if n == nil {
+		return newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+	if len(name)+len(value) > nn.maxXAttrSize {
+		return errors.New("Attribute " + name + " is larger than " + strconv.Itoa(nn.maxXAttrSize) + " bytes")
+	}
+	if _, ok := n.xattrs[name]; !ok && len(n.xattrs) >= nn.maxXAttrs {
+		return errors.New(path + " already has " + strconv.Itoa(nn.maxXAttrs) + " attributes")
+	}
+	if n.xattrs == nil {
+		n.xattrs = make(map[string][]byte)
+	}
+	n.xattrs[name] = append([]byte(nil), value...)
+	nn.metaLog.Info("Set attribute", "path", path, "name", name, "bytes", len(value))
+	return nil
+}
+
+// GetXAttr returns the value of the extended attribute name of the file or
+// directory at path
+func (nn *NameNode) GetXAttr(path, name string) ([]byte, error) {
+	err := nn.checkXAttrName(name, "", false)
+	if err != nil {
+		return nil, err
+	}
+	n := nn.lookup(path)
+	if n == nil {
+		return nil, newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+	value, ok := n.xattrs[name]
+	if !ok {
+		return nil, errors.New("No attribute " + name + " on " + path)
+	}
+	return value, nil
+}
+
+// ListXAttrs returns the names of the extended attributes of the file or
+// directory at path, in order
+func (nn *NameNode) ListXAttrs(path string) ([]string, error) {
+	n := nn.lookup(path)
+	if n == nil {
+		return nil, newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+	names := make([]string, 0, len(n.xattrs))
+	for name := range n.xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RemoveXAttr removes the extended attribute name of the file or directory
+// at path, as user
+func (nn *NameNode) RemoveXAttr(path, name, user string) error {
+	err := nn.checkXAttrName(name, user, true)
+	if err != nil {
+		return err
+	}
+	n := nn.lookup(path)
+	if n == nil {
+		return newError(ErrFileNotFound, "No such file or directory "+path)
+	}
+	if _, ok := n.xattrs[name]; !ok {
+		return errors.New("No attribute " + name + " on " + path)
+	}
+	delete(n.xattrs, name)
+	nn.metaLog.Info("Removed attribute", "path", path, "name", name)
+	return nil
+}
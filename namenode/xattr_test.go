@@ -0,0 +1,77 @@
+package namenode
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestXAttrs(t *testing.T) {
+
+	nn := New()
+	nn.metadatafile = filepath.Join(t.TempDir(), "metadata.json")
+	nn.admins["alice"] = true
+	nn.maxXAttrs = 2
+	nn.maxXAttrSize = 32
+	nn.datanodemap["DN1"] = &datanode{ID: "DN1", listed: true}
+	nn.MergeNode(BlockHeader{"DN1", "/dir/out.txt", 1, 0, 1, 0, "", 0, ""})
+
+	err := nn.SetXAttr("/dir/out.txt", "user.schema", []byte("v2"), "bob")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if value, err := nn.GetXAttr("/dir/out.txt", "user.schema"); err != nil || string(value) != "v2" {
+		t.Errorf("Expected v2, got %q %v", value, err)
+	}
+	for _, name := range []string{"schema", "user.", "trusted.x", "user.a\nb"} {
+		if nn.SetXAttr("/dir/out.txt", name, nil, "bob") == nil {
+			t.Errorf("Attribute %q set", name)
+		}
+	}
+	if nn.SetXAttr("/dir/out.txt", "system.retention", []byte("30d"), "bob") == nil {
+		t.Errorf("System attribute set by a user who is not an administrator")
+	}
+	err = nn.SetXAttr("/dir/out.txt", "system.retention", []byte("30d"), "alice")
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	if nn.SetXAttr("/dir/out.txt", "user.owner", nil, "bob") == nil {
+		t.Errorf("More than %d attributes set", nn.maxXAttrs)
+	}
+	if nn.SetXAttr("/dir/out.txt", "user.schema", make([]byte, 30), "bob") == nil {
+		t.Errorf("Attribute larger than %d bytes set", nn.maxXAttrSize)
+	}
+	if nn.SetXAttr("/missing", "user.schema", nil, "bob") == nil {
+		t.Errorf("Attribute of a missing file set")
+	}
+
+	// attributes move with their file, and survive a restart
+	nn.Rename("/dir", "/moved")
+	if names, _ := nn.ListXAttrs("/moved/out.txt"); len(names) != 2 || names[0] != "system.retention" || names[1] != "user.schema" {
+		t.Errorf("Unexpected attributes %v", names)
+	}
+	nn.SaveMetadata()
+	restarted := New()
+	restarted.metadatafile = nn.metadatafile
+	err = restarted.LoadMetadata()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if value, err := restarted.GetXAttr("/moved/out.txt", "system.retention"); err != nil || string(value) != "30d" {
+		t.Errorf("Attribute not restored %q %v", value, err)
+	}
+
+	// removing an attribute
+	var r Packet
+	nn.handleNamespace(Packet{SRC: "C", CMD: REMOVEXATTR, Message: "user.schema", User: "bob", Headers: []BlockHeader{{Filename: "/moved/out.txt"}}}, &r)
+	if r.CMD != ACK {
+		t.Errorf("Attribute not removed %v", r)
+	}
+	nn.handleNamespace(Packet{SRC: "C", CMD: GETXATTR, Message: "user.schema", Headers: []BlockHeader{{Filename: "/moved/out.txt"}}}, &r)
+	if r.CMD != ERROR {
+		t.Errorf("Removed attribute found %v", r)
+	}
+	nn.handleNamespace(Packet{SRC: "C", CMD: LISTXATTRS, Headers: []BlockHeader{{Filename: "/moved/out.txt"}}}, &r)
+	if r.CMD != LISTXATTRS || r.Message != "system.retention" {
+		t.Errorf("Unexpected attributes %v", r)
+	}
+}
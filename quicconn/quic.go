@@ -0,0 +1,98 @@
+//go:build quic
+
+package quicconn
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/quic-go/quic-go"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// Supported is whether QUIC support was built in, with -tags quic
+const Supported = true
+
+// time Close waits for the peer to close its side of the stream
+const closeTimeout = time.Second
+
+// Config keeps idle connections alive, and gives streams windows large
+// enough to keep a Block moving over a link with a long round trip
+var Config = &quic.Config{
+	KeepAlivePeriod:            15 * time.Second,
+	MaxStreamReceiveWindow:     64 << 20,
+	MaxConnectionReceiveWindow: 64 << 20,
+}
+
+// Stream is the stream of a QUIC connection packets are sent on
+type Stream interface {
+	io.ReadWriteCloser
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// Session is the QUIC connection a Stream belongs to
+type Session interface {
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	CloseWithError(code quic.ApplicationErrorCode, reason string) error
+}
+
+// Conn is a QUIC connection as a net.Conn of its one stream
+type Conn struct {
+	Stream
+	Session Session
+	Closed  func() // called once the connection is closed, if set
+	once    sync.Once
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.Session.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.Session.RemoteAddr()
+}
+
+// Close closes the stream, then the connection once the peer has closed
+// its side of the stream too, or closeTimeout has passed. Closing the
+// connection straight away would drop what is still buffered to be sent.
+func (c *Conn) Close() error {
+	c.Stream.Close()
+	c.Stream.SetReadDeadline(time.Now().Add(closeTimeout))
+	io.Copy(ioutil.Discard, c.Stream)
+	err := c.Session.CloseWithError(0, "")
+	if c.Closed != nil {
+		c.once.Do(c.Closed)
+	}
+	return err
+}
+
+// Dial connects to the namenode over QUIC on the UDP port of address, and
+// opens the stream packets are sent on, giving up after timeout unless it
+// is 0. The namenode's certificate is self-signed, so it is not verified:
+// the connection is encrypted but, as over TCP, the namenode is not
+// authenticated.
+func Dial(address string, timeout time.Duration) (net.Conn, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{Protocol}}
+	c, err := quic.DialAddr(ctx, address, tlsConfig, Config)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.OpenStreamSync(ctx)
+	if err != nil {
+		c.CloseWithError(0, err.Error())
+		return nil, err
+	}
+	return &Conn{Stream: s, Session: c}, nil
+}
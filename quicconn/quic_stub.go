@@ -0,0 +1,16 @@
+//go:build !quic
+
+package quicconn
+
+import (
+	"net"
+	"time"
+)
+
+// Supported is whether QUIC support was built in, with -tags quic
+const Supported = false
+
+// Dial reports that QUIC support was not built in
+func Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return nil, ErrNotBuilt
+}
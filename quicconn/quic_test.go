@@ -0,0 +1,106 @@
+//go:build quic
+
+package quicconn
+
+import (
+	"errors"
+	"github.com/quic-go/quic-go"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// halfStream is a stream whose peer closes its side once fin is closed
+type halfStream struct {
+	fin      chan struct{}
+	lock     sync.Mutex
+	closed   bool
+	deadline time.Time
+}
+
+func (s *halfStream) Read(b []byte) (int, error) {
+	s.lock.Lock()
+	wait := time.Until(s.deadline)
+	s.lock.Unlock()
+	select {
+	case <-s.fin:
+		return 0, io.EOF
+	case <-time.After(wait):
+		return 0, errors.New("deadline exceeded")
+	}
+}
+
+func (s *halfStream) Write(b []byte) (int, error) { return len(b), nil }
+
+func (s *halfStream) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *halfStream) SetDeadline(t time.Time) error { return s.SetReadDeadline(t) }
+
+func (s *halfStream) SetReadDeadline(t time.Time) error {
+	s.lock.Lock()
+	s.deadline = t
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *halfStream) SetWriteDeadline(t time.Time) error { return nil }
+
+// closingSession notes when it is closed
+type closingSession struct {
+	lock   sync.Mutex
+	closed bool
+}
+
+func (s *closingSession) LocalAddr() net.Addr  { return &net.UDPAddr{} }
+func (s *closingSession) RemoteAddr() net.Addr { return &net.UDPAddr{} }
+
+func (s *closingSession) CloseWithError(code quic.ApplicationErrorCode, reason string) error {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *closingSession) isClosed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closed
+}
+
+func TestCloseWaitsForPeer(t *testing.T) {
+
+	s := &halfStream{fin: make(chan struct{})}
+	session := &closingSession{}
+	closed := 0
+	c := &Conn{Stream: s, Session: session, Closed: func() { closed++ }}
+
+	early := make(chan bool, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		early <- session.isClosed()
+		close(s.fin)
+	}()
+	c.Close()
+	if <-early {
+		t.Errorf("Connection closed before the peer closed its side of the stream")
+	}
+	if !s.closed || !session.isClosed() || closed != 1 {
+		t.Errorf("Connection not closed, stream %v session %v callback %d", s.closed, session.isClosed(), closed)
+	}
+
+	// a peer which never closes its side holds Close up only briefly
+	silent := &closingSession{}
+	c = &Conn{Stream: &halfStream{fin: make(chan struct{})}, Session: silent}
+	start := time.Now()
+	c.Close()
+	if waited := time.Since(start); waited > 2*closeTimeout || !silent.isClosed() {
+		t.Errorf("Close waited %s for a silent peer", waited)
+	}
+}
@@ -0,0 +1,15 @@
+// Package quicconn carries the connections of GoDFS nodes over QUIC. Each
+// connection sends its packets on one stream, and is a net.Conn of that
+// stream so that it is handled as a connection over TCP is. QUIC support is
+// built in with -tags quic.
+package quicconn
+
+import (
+	"errors"
+)
+
+// Protocol is the ALPN protocol of GoDFS connections over QUIC
+const Protocol = "godfs"
+
+// ErrNotBuilt is returned when QUIC is used by a build without it
+var ErrNotBuilt = errors.New("godfs was built without QUIC support, rebuild with -tags quic")
@@ -0,0 +1,66 @@
+package s3gateway
+
+import (
+	"bufio"
+	"github.com/sjarvie/godfs/client"
+	"io"
+	"sync"
+)
+
+// Backend is the filesystem the gateway serves
+type Backend interface {
+	Stat(path string) (client.FileStatus, error)
+	ListDir(path string, recursive bool) ([]client.FileStatus, error)
+	Mkdir(path string, parents bool) error
+	Delete(path string, recursive bool) error
+	Get(path string, w io.Writer) error
+	Put(path string, r io.Reader, size int64) error
+}
+
+// clientBackend serves the namenode the client is connected to. The client
+// keeps a single connection, so requests are serialized by lock.
+type clientBackend struct {
+	lock sync.Mutex
+}
+
+// ClientBackend returns a Backend using the connected client
+func ClientBackend() Backend {
+	return new(clientBackend)
+}
+
+func (b *clientBackend) Stat(path string) (client.FileStatus, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return client.Stat(path)
+}
+
+func (b *clientBackend) ListDir(path string, recursive bool) ([]client.FileStatus, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return client.ListDir(path, recursive)
+}
+
+func (b *clientBackend) Mkdir(path string, parents bool) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return client.Mkdir(path, parents)
+}
+
+func (b *clientBackend) Delete(path string, recursive bool) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	// S3 deletes are immediate, and overwrites must not fill the trash
+	return client.Delete(path, recursive, true)
+}
+
+func (b *clientBackend) Get(path string, w io.Writer) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return client.RetrieveToWriter(bufio.NewWriterSize(w, client.SIZEOFBLOCK), path)
+}
+
+func (b *clientBackend) Put(path string, r io.Reader, size int64) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return client.DistributeBlocksFromReader(r, size, path)
+}
@@ -0,0 +1,81 @@
+package s3gateway
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// the x-amz-content-sha256 value of uploads using aws-chunked encoding
+const streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+
+// chunkedReader decodes an aws-chunked request body, made of chunks of the
+// form "<hex size>;chunk-signature=<signature>\r\n<data>\r\n" ending with an
+// empty chunk. Signatures are not verified.
+type chunkedReader struct {
+	r       *bufio.Reader
+	left    int64 // bytes left in the current chunk
+	started bool
+	done    bool
+}
+
+func newChunkedReader(r io.Reader) *chunkedReader {
+	return &chunkedReader{r: bufio.NewReader(r)}
+}
+
+func (c *chunkedReader) Read(buf []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.left == 0 {
+		err := c.nextChunk()
+		if err != nil {
+			return 0, err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+	}
+
+	if int64(len(buf)) > c.left {
+		buf = buf[:c.left]
+	}
+	n, err := c.r.Read(buf)
+	c.left -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextChunk reads the end of the previous chunk and the header of the next
+func (c *chunkedReader) nextChunk() error {
+	if c.started {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if line != "\r\n" {
+			return errors.New("Malformed chunk trailer")
+		}
+	}
+	c.started = true
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if i := strings.Index(line, ";"); i >= 0 {
+		line = line[:i]
+	}
+	size, err := strconv.ParseInt(line, 16, 64)
+	if err != nil || size < 0 {
+		return errors.New("Malformed chunk header")
+	}
+	c.left = size
+	c.done = size == 0
+	return nil
+}
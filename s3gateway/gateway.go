@@ -0,0 +1,321 @@
+// Package s3gateway serves a subset of the Amazon S3 API backed by GoDFS.
+// Buckets are the top level directories of the filesystem and object keys
+// the paths below them. Requests are path style, and signatures are not
+// verified.
+package s3gateway
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"github.com/sjarvie/godfs/client"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// Gateway is an http.Handler translating S3 requests to a Backend
+type Gateway struct {
+	backend Backend
+	started time.Time // reported as the creation time of buckets, and of objects without a modification time
+}
+
+// New creates a Gateway serving backend
+func New(backend Backend) *Gateway {
+	return &Gateway{backend: backend, started: time.Now().UTC()}
+}
+
+// modified returns the modification time of an object in UTC
+func (g *Gateway) modified(st client.FileStatus) time.Time {
+	if st.ModTime == 0 {
+		return g.started
+	}
+	return time.Unix(0, st.ModTime*int64(time.Millisecond)).UTC()
+}
+
+// ListenAndServe serves the gateway on address host:port
+func ListenAndServe(address string, backend Backend) error {
+	return http.ListenAndServe(address, New(backend))
+}
+
+// s3Error is the body of an error response
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+type bucket struct {
+	Name         string
+	CreationDate string
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   struct {
+		ID          string
+		DisplayName string
+	}
+	Buckets []bucket `xml:"Buckets>Bucket"`
+}
+
+type object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+type listBucketResult struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Xmlns          string   `xml:"xmlns,attr"`
+	Name           string
+	Prefix         string
+	Delimiter      string `xml:",omitempty"`
+	KeyCount       int
+	MaxKeys        int
+	IsTruncated    bool
+	Contents       []object
+	CommonPrefixes []commonPrefix
+}
+
+// ServeHTTP dispatches a request on the bucket and key in its path
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		bucket, key = path[:i], path[i+1:]
+	}
+
+	switch {
+	case bucket == "" && r.Method == "GET":
+		g.listBuckets(w, r)
+	case bucket == "":
+		g.writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "Method not allowed")
+	case key == "":
+		g.serveBucket(w, r, bucket)
+	default:
+		g.serveObject(w, r, bucket, key)
+	}
+}
+
+func (g *Gateway) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	dir := "/" + bucket
+
+	switch r.Method {
+	case "GET":
+		g.listObjects(w, r, bucket)
+	case "HEAD":
+		st, err := g.backend.Stat(dir)
+		if err != nil || !st.IsDir {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case "PUT":
+		err := g.backend.Mkdir(dir, false)
+		if err != nil {
+			g.writeError(w, r, http.StatusConflict, "BucketAlreadyExists", err.Error())
+			return
+		}
+		w.Header().Set("Location", dir)
+	case "DELETE":
+		if !g.checkBucket(w, r, bucket) {
+			return
+		}
+		err := g.backend.Delete(dir, false)
+		if err != nil {
+			g.writeError(w, r, http.StatusConflict, "BucketNotEmpty", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		g.writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "Method not allowed")
+	}
+}
+
+func (g *Gateway) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	path := "/" + bucket + "/" + key
+
+	if r.URL.Query().Get("uploadId") != "" || r.URL.Query()["uploads"] != nil {
+		g.writeError(w, r, http.StatusNotImplemented, "NotImplemented", "Multipart uploads are not supported")
+		return
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		st, err := g.backend.Stat(path)
+		if err != nil || st.IsDir {
+			g.writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(st.Size, 10))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Last-Modified", g.modified(st).Format(http.TimeFormat))
+		if r.Method == "HEAD" {
+			return
+		}
+		g.backend.Get(path, w)
+	case "PUT":
+		g.putObject(w, r, bucket, path)
+	case "DELETE":
+		// deleting a missing key succeeds
+		g.backend.Delete(path, false)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		g.writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "Method not allowed")
+	}
+}
+
+func (g *Gateway) putObject(w http.ResponseWriter, r *http.Request, bucket, path string) {
+	if !g.checkBucket(w, r, bucket) {
+		return
+	}
+	if r.Header.Get("x-amz-copy-source") != "" {
+		g.writeError(w, r, http.StatusNotImplemented, "NotImplemented", "Copying objects is not supported")
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("x-amz-content-sha256") == streamingPayload {
+		body = newChunkedReader(r.Body)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		g.writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	// keys ending in a slash are directory markers
+	if strings.HasSuffix(path, "/") {
+		err = g.backend.Mkdir(strings.TrimSuffix(path, "/"), true)
+		if err != nil {
+			g.writeError(w, r, http.StatusConflict, "InvalidRequest", err.Error())
+		}
+		return
+	}
+	if len(data) == 0 {
+		g.writeError(w, r, http.StatusBadRequest, "InvalidRequest", "Empty objects are not supported")
+		return
+	}
+
+	// files are written whole, so remove any previous version first
+	g.backend.Delete(path, false)
+	err = g.backend.Put(path, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		g.writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	sum := md5.Sum(data)
+	w.Header().Set("ETag", "\""+hex.EncodeToString(sum[:])+"\"")
+}
+
+// checkBucket writes a NoSuchBucket error and returns false if the bucket
+// does not exist
+func (g *Gateway) checkBucket(w http.ResponseWriter, r *http.Request, bucket string) bool {
+	st, err := g.backend.Stat("/" + bucket)
+	if err != nil || !st.IsDir {
+		g.writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return false
+	}
+	return true
+}
+
+func (g *Gateway) listBuckets(w http.ResponseWriter, r *http.Request) {
+	list, err := g.backend.ListDir("/", false)
+	if err != nil {
+		g.writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	result := listAllMyBucketsResult{Xmlns: s3Namespace}
+	result.Owner.ID = "godfs"
+	result.Owner.DisplayName = "godfs"
+	for _, st := range list {
+		if st.IsDir {
+			name := strings.TrimPrefix(st.Path, "/")
+			result.Buckets = append(result.Buckets, bucket{name, g.started.Format(time.RFC3339)})
+		}
+	}
+	g.writeXML(w, result)
+}
+
+// listObjects lists the keys of a bucket, supporting the prefix and
+// delimiter parameters of both versions of ListObjects
+func (g *Gateway) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !g.checkBucket(w, r, bucket) {
+		return
+	}
+	list, err := g.backend.ListDir("/"+bucket, true)
+	if err != nil {
+		g.writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	result := listBucketResult{Xmlns: s3Namespace, Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: 1000}
+
+	prefixes := make(map[string]bool)
+	for _, st := range list {
+		if st.IsDir {
+			continue
+		}
+		key := strings.TrimPrefix(st.Path, "/"+bucket+"/")
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				prefixes[key[:len(prefix)+i+len(delimiter)]] = true
+				continue
+			}
+		}
+		result.Contents = append(result.Contents, object{
+			Key:          key,
+			LastModified: g.modified(st).Format(time.RFC3339),
+			Size:         st.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+
+	for p := range prefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{p})
+	}
+	sort.Slice(result.CommonPrefixes, func(i, j int) bool {
+		return result.CommonPrefixes[i].Prefix < result.CommonPrefixes[j].Prefix
+	})
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	g.writeXML(w, result)
+}
+
+func (g *Gateway) writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func (g *Gateway) writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method == "HEAD" {
+		return
+	}
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(s3Error{Code: code, Message: message, Resource: r.URL.Path})
+}
@@ -0,0 +1,179 @@
+package s3gateway
+
+import (
+	"errors"
+	"github.com/sjarvie/godfs/client"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+)
+
+// memBackend is an in memory Backend of files and explicit directories
+type memBackend struct {
+	files map[string][]byte
+	dirs  map[string]bool
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{files: make(map[string][]byte), dirs: map[string]bool{"/": true}}
+}
+
+func (m *memBackend) Stat(p string) (client.FileStatus, error) {
+	if data, ok := m.files[p]; ok {
+		return client.FileStatus{Path: p, Size: int64(len(data)), NumBlocks: 1}, nil
+	}
+	if m.dirs[p] {
+		return client.FileStatus{Path: p, IsDir: true}, nil
+	}
+	return client.FileStatus{}, errors.New("No such file or directory " + p)
+}
+
+func (m *memBackend) ListDir(p string, recursive bool) ([]client.FileStatus, error) {
+	var list []client.FileStatus
+	for name := range m.files {
+		if path.Dir(name) == p || (recursive && strings.HasPrefix(name, p+"/")) {
+			st, _ := m.Stat(name)
+			list = append(list, st)
+		}
+	}
+	for name := range m.dirs {
+		if name != "/" && (path.Dir(name) == p || (recursive && strings.HasPrefix(name, p+"/"))) {
+			list = append(list, client.FileStatus{Path: name, IsDir: true})
+		}
+	}
+	return list, nil
+}
+
+func (m *memBackend) Mkdir(p string, parents bool) error {
+	if m.dirs[p] && !parents {
+		return errors.New("Directory exists " + p)
+	}
+	m.dirs[p] = true
+	return nil
+}
+
+func (m *memBackend) Delete(p string, recursive bool) error {
+	if _, ok := m.files[p]; ok {
+		delete(m.files, p)
+		return nil
+	}
+	if !m.dirs[p] {
+		return errors.New("No such file or directory " + p)
+	}
+	for name := range m.files {
+		if strings.HasPrefix(name, p+"/") {
+			return errors.New("Directory not empty " + p)
+		}
+	}
+	delete(m.dirs, p)
+	return nil
+}
+
+func (m *memBackend) Get(p string, w io.Writer) error {
+	_, err := w.Write(m.files[p])
+	return err
+}
+
+func (m *memBackend) Put(p string, r io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(r)
+	m.files[p] = data
+	return err
+}
+
+func do(t *testing.T, h http.Handler, method, url, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestObjectLifecycle(t *testing.T) {
+
+	g := New(newMemBackend())
+
+	if w := do(t, g, "PUT", "/bucket/key.txt", "data"); w.Code != http.StatusNotFound {
+		t.Errorf("Put into missing bucket returned %d", w.Code)
+	}
+	if w := do(t, g, "PUT", "/bucket", ""); w.Code != http.StatusOK {
+		t.Fatalf("Create bucket returned %d", w.Code)
+	}
+	w := do(t, g, "PUT", "/bucket/dir/key.txt", "data")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != "\"8d777f385d3dfec8815d20f7496026dc\"" {
+		t.Fatalf("Put object returned %d %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	w = do(t, g, "GET", "/bucket/dir/key.txt", "")
+	if w.Code != http.StatusOK || w.Body.String() != "data" || w.Header().Get("Content-Length") != "4" {
+		t.Errorf("Get object returned %d %q", w.Code, w.Body.String())
+	}
+	if w := do(t, g, "GET", "/bucket/missing", ""); w.Code != http.StatusNotFound ||
+		!strings.Contains(w.Body.String(), "<Code>NoSuchKey</Code>") {
+		t.Errorf("Get missing object returned %d %s", w.Code, w.Body.String())
+	}
+
+	w = do(t, g, "GET", "/", "")
+	if !strings.Contains(w.Body.String(), "<Name>bucket</Name>") {
+		t.Errorf("Bucket not listed %s", w.Body.String())
+	}
+
+	if w := do(t, g, "DELETE", "/bucket", ""); w.Code != http.StatusConflict {
+		t.Errorf("Delete non empty bucket returned %d", w.Code)
+	}
+	if w := do(t, g, "DELETE", "/bucket/dir/key.txt", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Delete object returned %d", w.Code)
+	}
+	if w := do(t, g, "GET", "/bucket/dir/key.txt", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Deleted object returned %d", w.Code)
+	}
+	if w := do(t, g, "DELETE", "/bucket", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Delete bucket returned %d", w.Code)
+	}
+}
+
+func TestListObjects(t *testing.T) {
+
+	g := New(newMemBackend())
+	do(t, g, "PUT", "/bucket", "")
+	do(t, g, "PUT", "/bucket/a.txt", "a")
+	do(t, g, "PUT", "/bucket/dir/b.txt", "bb")
+	do(t, g, "PUT", "/bucket/dir/sub/c.txt", "ccc")
+
+	w := do(t, g, "GET", "/bucket?list-type=2", "")
+	body := w.Body.String()
+	for _, key := range []string{"a.txt", "dir/b.txt", "dir/sub/c.txt"} {
+		if !strings.Contains(body, "<Key>"+key+"</Key>") {
+			t.Errorf("Key %s not listed in %s", key, body)
+		}
+	}
+
+	w = do(t, g, "GET", "/bucket?prefix=dir/&delimiter=/", "")
+	body = w.Body.String()
+	if !strings.Contains(body, "<Key>dir/b.txt</Key>") || strings.Contains(body, "<Key>a.txt</Key>") ||
+		!strings.Contains(body, "<CommonPrefixes><Prefix>dir/sub/</Prefix></CommonPrefixes>") {
+		t.Errorf("Unexpected delimited listing %s", body)
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+
+	g := New(newMemBackend())
+	do(t, g, "PUT", "/bucket", "")
+
+	body := "5;chunk-signature=abc\r\nhello\r\n6;chunk-signature=def\r\n world\r\n0;chunk-signature=ghi\r\n\r\n"
+	req := httptest.NewRequest("PUT", "/bucket/chunked.txt", strings.NewReader(body))
+	req.Header.Set("x-amz-content-sha256", streamingPayload)
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Chunked put returned %d %s", w.Code, w.Body.String())
+	}
+
+	w = do(t, g, "GET", "/bucket/chunked.txt", "")
+	if w.Body.String() != "hello world" {
+		t.Errorf("Expected decoded body, got %q", w.Body.String())
+	}
+}